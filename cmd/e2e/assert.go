@@ -0,0 +1,255 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// verify returns the ways the namespaces differ from e.
+func (r *runner) verify(e Expected) []error {
+	var errs []error
+	for _, l := range e.Links {
+		errs = appendErr(errs, r.checkLink(l))
+	}
+	for _, a := range e.Addresses {
+		errs = appendErr(errs, r.checkAddress(a))
+	}
+	for _, q := range e.Qdiscs {
+		errs = appendErr(errs, r.checkQdisc(q))
+	}
+	for _, i := range e.Iptables {
+		errs = appendErr(errs, r.checkIptables(i))
+	}
+	return errs
+}
+
+func appendErr(errs []error, err error) []error {
+	if err != nil {
+		return append(errs, err)
+	}
+	return errs
+}
+
+// namespace returns the container namespace with id, or the host namespace
+// if id is empty.
+func (r *runner) namespace(id string) ns.NetNS {
+	if id == "" {
+		return r.hostNS
+	}
+	return r.netns[id]
+}
+
+// linkName returns the name of the link ref refers to.
+func (r *runner) linkName(ref LinkRef) (string, error) {
+	if !strings.HasPrefix(ref.Name, "$") {
+		return ref.Name, nil
+	}
+	id, idx, err := parseResultRef(ref.Name)
+	if err != nil {
+		return "", err
+	}
+	result := r.results[id]
+	if result == nil {
+		return "", fmt.Errorf("link %s: container %s is not attached", ref.Name, id)
+	}
+	if idx >= len(result.Interfaces) {
+		return "", fmt.Errorf("link %s: the result of container %s has %d interfaces", ref.Name, id, len(result.Interfaces))
+	}
+	return result.Interfaces[idx].Name, nil
+}
+
+// withLink calls f with the link ref refers to, in its namespace, or with
+// nil if there is no such link.
+func (r *runner) withLink(ref LinkRef, f func(name string, link netlink.Link) error) error {
+	name, err := r.linkName(ref)
+	if err != nil {
+		return err
+	}
+	return r.namespace(ref.Netns).Do(func(ns.NetNS) error {
+		link, err := netlinksafe.LinkByName(name)
+		if err != nil {
+			var notFound netlink.LinkNotFoundError
+			if !errors.As(err, &notFound) {
+				return fmt.Errorf("failed to look up link %s: %v", describe(ref.Netns, name), err)
+			}
+			link = nil
+		}
+		return f(name, link)
+	})
+}
+
+// describe names a link in the error messages.
+func describe(netns, name string) string {
+	if netns == "" {
+		return fmt.Sprintf("%q of the host", name)
+	}
+	return fmt.Sprintf("%q of container %s", name, netns)
+}
+
+func (r *runner) checkLink(e LinkExpect) error {
+	return r.withLink(e.LinkRef, func(name string, link netlink.Link) error {
+		desc := describe(e.Netns, name)
+		if link == nil {
+			if e.Absent {
+				return nil
+			}
+			return fmt.Errorf("link %s does not exist", desc)
+		}
+		if e.Absent {
+			return fmt.Errorf("link %s exists", desc)
+		}
+
+		attrs := link.Attrs()
+		if e.Kind != "" && link.Type() != e.Kind {
+			return fmt.Errorf("link %s is of kind %s, expected %s", desc, link.Type(), e.Kind)
+		}
+		if e.Up != nil && (attrs.Flags&net.FlagUp != 0) != *e.Up {
+			return fmt.Errorf("link %s has flags %v, expected it up: %t", desc, attrs.Flags, *e.Up)
+		}
+		if e.MTU != 0 && attrs.MTU != e.MTU {
+			return fmt.Errorf("link %s has MTU %d, expected %d", desc, attrs.MTU, e.MTU)
+		}
+		if e.Master != "" {
+			master, err := netlinksafe.LinkByName(e.Master)
+			if err != nil {
+				return fmt.Errorf("failed to look up master %q of link %s: %v", e.Master, desc, err)
+			}
+			if attrs.MasterIndex != master.Attrs().Index {
+				return fmt.Errorf("link %s is not enslaved to %q", desc, e.Master)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *runner) checkAddress(e AddressExpect) error {
+	_, within, err := net.ParseCIDR(e.Within)
+	if err != nil {
+		return err
+	}
+	return r.withLink(e.LinkRef, func(name string, link netlink.Link) error {
+		desc := describe(e.Netns, name)
+		if link == nil {
+			return fmt.Errorf("link %s does not exist", desc)
+		}
+		addrs, err := netlinksafe.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("failed to list addresses of link %s: %v", desc, err)
+		}
+		var found *netlink.Addr
+		for i, addr := range addrs {
+			if within.Contains(addr.IP) {
+				found = &addrs[i]
+				break
+			}
+		}
+		switch {
+		case found == nil && !e.Absent:
+			return fmt.Errorf("link %s has no address within %s", desc, e.Within)
+		case found != nil && e.Absent:
+			return fmt.Errorf("link %s has address %s within %s", desc, found.IPNet, e.Within)
+		}
+		return nil
+	})
+}
+
+func (r *runner) checkQdisc(e QdiscExpect) error {
+	return r.withLink(e.LinkRef, func(name string, link netlink.Link) error {
+		desc := describe(e.Netns, name)
+		if link == nil {
+			return fmt.Errorf("link %s does not exist", desc)
+		}
+		qdiscs, err := netlinksafe.QdiscList(link)
+		if err != nil {
+			return fmt.Errorf("failed to list qdiscs of link %s: %v", desc, err)
+		}
+		found := false
+		for _, q := range qdiscs {
+			if q.Attrs().LinkIndex == link.Attrs().Index && q.Type() == e.Kind {
+				found = true
+				break
+			}
+		}
+		switch {
+		case !found && !e.Absent:
+			return fmt.Errorf("link %s has no %s qdisc", desc, e.Kind)
+		case found && e.Absent:
+			return fmt.Errorf("link %s has a %s qdisc", desc, e.Kind)
+		}
+		return nil
+	})
+}
+
+func (r *runner) checkIptables(e IptablesExpect) error {
+	proto := iptables.ProtocolIPv4
+	if e.IPv6 {
+		proto = iptables.ProtocolIPv6
+	}
+	desc := fmt.Sprintf("%s chain %s", e.Table, e.Chain)
+	if e.Netns != "" {
+		desc += " of container " + e.Netns
+	}
+	return r.namespace(e.Netns).Do(func(ns.NetNS) error {
+		ipt, err := iptables.NewWithProtocol(proto)
+		if err != nil {
+			return fmt.Errorf("failed to open iptables: %v", err)
+		}
+		exists, err := ipt.ChainExists(e.Table, e.Chain)
+		if err != nil {
+			return fmt.Errorf("failed to look up %s: %v", desc, err)
+		}
+		if !exists {
+			if e.Absent {
+				return nil
+			}
+			return fmt.Errorf("%s does not exist", desc)
+		}
+		if e.Rule == "" {
+			if e.Absent {
+				return fmt.Errorf("%s exists", desc)
+			}
+			return nil
+		}
+
+		rules, err := ipt.List(e.Table, e.Chain)
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %v", desc, err)
+		}
+		found := false
+		for _, rule := range rules {
+			if strings.Contains(rule, e.Rule) {
+				found = true
+				break
+			}
+		}
+		switch {
+		case !found && !e.Absent:
+			return fmt.Errorf("%s has no rule containing %q", desc, e.Rule)
+		case found && e.Absent:
+			return fmt.Errorf("%s has a rule containing %q", desc, e.Rule)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,80 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// e2e runs scenarios of plugin chains against the built plugins, in network
+// namespaces of its own, and checks the links, addresses, qdiscs and
+// iptables rules they leave behind. See README.md for the scenario format.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	var cniPath string
+	var useHost bool
+	flag.StringVar(&cniPath, "cni-path", os.Getenv("CNI_PATH"), "directories of the plugin binaries, ./bin by default")
+	flag.BoolVar(&useHost, "use-host-netns", false, "run the plugins in the current network namespace instead of a new one")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] scenario.json...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if cniPath == "" {
+		cniPath = "bin"
+	}
+	var dirs []string
+	for _, dir := range filepath.SplitList(cniPath) {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dirs = append(dirs, abs)
+	}
+
+	failed := 0
+	for _, file := range flag.Args() {
+		if !runScenario(file, dirs, useHost) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d scenarios failed\n", failed, flag.NArg())
+		os.Exit(1)
+	}
+}
+
+func runScenario(file string, cniPath []string, useHost bool) bool {
+	s, err := loadScenario(file)
+	if err != nil {
+		fmt.Printf("FAIL %s: %v\n", file, err)
+		return false
+	}
+	r, err := newRunner(s, cniPath, useHost)
+	if err != nil {
+		fmt.Printf("FAIL %s: %v\n", s.Name, err)
+		return false
+	}
+	defer r.close()
+	return r.run(os.Stdout)
+}
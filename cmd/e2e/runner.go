@@ -0,0 +1,220 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+// runner runs a scenario in namespaces of its own. The plugins are run in
+// the host namespace, which is a new namespace unless the real host's is
+// used.
+type runner struct {
+	scenario *Scenario
+	cni      *libcni.CNIConfig
+	cacheDir string
+
+	hostNS  ns.NetNS
+	ownHost bool
+	netns   map[string]ns.NetNS
+	// attached are the containers an ADD was run for, but no DEL since.
+	attached map[string]bool
+	// results are the results of the last ADD of the attached containers.
+	results map[string]*current.Result
+}
+
+func newRunner(s *Scenario, cniPath []string, useHost bool) (*runner, error) {
+	cacheDir, err := os.MkdirTemp("", "cni-e2e")
+	if err != nil {
+		return nil, err
+	}
+	r := &runner{
+		scenario: s,
+		cni:      libcni.NewCNIConfigWithCacheDir(cniPath, cacheDir, nil),
+		cacheDir: cacheDir,
+		netns:    map[string]ns.NetNS{},
+		attached: map[string]bool{},
+		results:  map[string]*current.Result{},
+	}
+
+	if useHost {
+		r.hostNS, err = ns.GetCurrentNS()
+	} else {
+		r.hostNS, err = newNS()
+		r.ownHost = true
+	}
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	for _, c := range s.Containers {
+		netns, err := newNS()
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		r.netns[c.ID] = netns
+	}
+	return r, nil
+}
+
+// newNS returns a new namespace with its loopback interface up.
+func newNS() (ns.NetNS, error) {
+	netns, err := testutils.NewNS()
+	if err != nil {
+		return nil, err
+	}
+	err = netns.Do(func(ns.NetNS) error {
+		lo, err := netlinksafe.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		return netlink.LinkSetUp(lo)
+	})
+	if err != nil {
+		closeNS(netns)
+		return nil, fmt.Errorf("failed to set up loopback interface: %v", err)
+	}
+	return netns, nil
+}
+
+func closeNS(netns ns.NetNS) {
+	netns.Close()
+	_ = testutils.UnmountNS(netns)
+}
+
+// close deletes the attachments left behind by the scenario and the
+// namespaces.
+func (r *runner) close() {
+	for id := range r.attached {
+		if err := r.do(Step{Action: actionDel, Container: id}); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to clean up container %s: %v\n", id, err)
+		}
+	}
+	for _, netns := range r.netns {
+		closeNS(netns)
+	}
+	if r.hostNS != nil {
+		if r.ownHost {
+			closeNS(r.hostNS)
+		} else {
+			r.hostNS.Close()
+		}
+	}
+	os.RemoveAll(r.cacheDir)
+}
+
+// run runs the steps of the scenario, reporting them to w, until one fails.
+func (r *runner) run(w io.Writer) bool {
+	for i, step := range r.scenario.Steps {
+		desc := step.Action
+		if step.Container != "" {
+			desc += " " + step.Container
+		}
+		errs := r.runStep(step)
+		if len(errs) == 0 {
+			fmt.Fprintf(w, "ok   %s: step %d (%s)\n", r.scenario.Name, i+1, desc)
+			continue
+		}
+		fmt.Fprintf(w, "FAIL %s: step %d (%s)\n", r.scenario.Name, i+1, desc)
+		for _, err := range errs {
+			fmt.Fprintf(w, "       %v\n", err)
+		}
+		return false
+	}
+	return true
+}
+
+func (r *runner) runStep(step Step) []error {
+	err := r.do(step)
+	if step.Error != nil {
+		if err == nil {
+			return []error{fmt.Errorf("expected error code %d, got success", *step.Error)}
+		}
+		if code := cnierrors.Code(err); code != *step.Error {
+			return []error{fmt.Errorf("expected error code %d, got %d: %v", *step.Error, code, err)}
+		}
+	} else if err != nil {
+		return []error{err}
+	}
+	return r.verify(step.Expect)
+}
+
+// do runs the operation of step in the host namespace.
+func (r *runner) do(step Step) error {
+	ctx := context.Background()
+	list := r.scenario.list
+	return r.hostNS.Do(func(ns.NetNS) error {
+		switch step.Action {
+		case actionAdd:
+			r.attached[step.Container] = true
+			res, err := r.cni.AddNetworkList(ctx, list, r.runtimeConf(step.Container))
+			if err != nil {
+				return err
+			}
+			result, err := current.NewResultFromResult(res)
+			if err != nil {
+				return fmt.Errorf("failed to convert result: %v", err)
+			}
+			r.results[step.Container] = result
+			return nil
+		case actionCheck:
+			return r.cni.CheckNetworkList(ctx, list, r.runtimeConf(step.Container))
+		case actionDel:
+			err := r.cni.DelNetworkList(ctx, list, r.runtimeConf(step.Container))
+			if err == nil {
+				delete(r.attached, step.Container)
+				delete(r.results, step.Container)
+			}
+			return err
+		case actionGC:
+			args := &libcni.GCArgs{}
+			for _, id := range step.Valid {
+				args.ValidAttachments = append(args.ValidAttachments, types.GCAttachment{
+					ContainerID: id,
+					IfName:      r.scenario.container(id).IfName,
+				})
+			}
+			return r.cni.GCNetworkList(ctx, list, args)
+		case actionStatus:
+			return r.cni.GetStatusNetworkList(ctx, list)
+		}
+		return fmt.Errorf("unknown action %q", step.Action)
+	})
+}
+
+func (r *runner) runtimeConf(id string) *libcni.RuntimeConf {
+	c := r.scenario.container(id)
+	return &libcni.RuntimeConf{
+		ContainerID:    c.ID,
+		NetNS:          r.netns[id].Path(),
+		IfName:         c.IfName,
+		Args:           c.Args,
+		CapabilityArgs: c.CapabilityArgs,
+	}
+}
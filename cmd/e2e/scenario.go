@@ -0,0 +1,259 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/cni/libcni"
+)
+
+// Actions of the steps of a scenario.
+const (
+	actionAdd    = "add"
+	actionCheck  = "check"
+	actionDel    = "del"
+	actionGC     = "gc"
+	actionStatus = "status"
+)
+
+// Scenario runs the operations of its steps, in order, on a plugin chain,
+// checking the state of the namespaces after each.
+type Scenario struct {
+	Name string `json:"name"`
+	// Config is the network configuration list of the chain.
+	Config     json.RawMessage `json:"config"`
+	Containers []Container     `json:"containers"`
+	Steps      []Step          `json:"steps"`
+
+	list *libcni.NetworkConfigList
+}
+
+// Container is a network namespace the chain is attached to.
+type Container struct {
+	ID string `json:"id"`
+	// IfName is the name of the container interface, eth0 by default.
+	IfName         string                 `json:"ifName,omitempty"`
+	Args           [][2]string            `json:"args,omitempty"`
+	CapabilityArgs map[string]interface{} `json:"capabilityArgs,omitempty"`
+}
+
+// Step is an operation of the chain and the state it must leave behind.
+type Step struct {
+	// Action is one of add, check, del, gc and status.
+	Action string `json:"action"`
+	// Container is the container operated on by add, check and del.
+	Container string `json:"container,omitempty"`
+	// Valid are the containers whose attachments gc keeps.
+	Valid []string `json:"valid,omitempty"`
+	// Error is the code of the CNI error the operation must fail with.
+	Error  *uint    `json:"error,omitempty"`
+	Expect Expected `json:"expect"`
+}
+
+// Expected is the state expected after a step.
+type Expected struct {
+	Links     []LinkExpect     `json:"links,omitempty"`
+	Addresses []AddressExpect  `json:"addresses,omitempty"`
+	Qdiscs    []QdiscExpect    `json:"qdiscs,omitempty"`
+	Iptables  []IptablesExpect `json:"iptables,omitempty"`
+}
+
+// LinkRef names a link of the host namespace, or of the container Netns.
+// A Name like "$c1.0" is the name of the first interface in the result of
+// the last ADD of container c1.
+type LinkRef struct {
+	Netns string `json:"netns,omitempty"`
+	Name  string `json:"name"`
+}
+
+// LinkExpect is a link that must exist, with the given attributes, or not.
+type LinkExpect struct {
+	LinkRef
+	Absent bool   `json:"absent,omitempty"`
+	Kind   string `json:"kind,omitempty"`
+	Up     *bool  `json:"up,omitempty"`
+	MTU    int    `json:"mtu,omitempty"`
+	// Master is the name of the link the link is enslaved to.
+	Master string `json:"master,omitempty"`
+}
+
+// AddressExpect is an address within the Within subnet that a link must
+// have, or not.
+type AddressExpect struct {
+	LinkRef
+	Absent bool   `json:"absent,omitempty"`
+	Within string `json:"within"`
+}
+
+// QdiscExpect is a qdisc of kind Kind that a link must have, or not.
+type QdiscExpect struct {
+	LinkRef
+	Absent bool   `json:"absent,omitempty"`
+	Kind   string `json:"kind"`
+}
+
+// IptablesExpect is a chain that must exist in a namespace, with a rule
+// containing Rule if not empty. If Absent, the chain must not exist, or have
+// no rule containing Rule if not empty.
+type IptablesExpect struct {
+	Netns  string `json:"netns,omitempty"`
+	Absent bool   `json:"absent,omitempty"`
+	IPv6   bool   `json:"ipv6,omitempty"`
+	Table  string `json:"table"`
+	Chain  string `json:"chain"`
+	Rule   string `json:"rule,omitempty"`
+}
+
+// loadScenario reads and validates the scenario in file.
+func loadScenario(file string) (*Scenario, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s := &Scenario{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario %s: %v", file, err)
+	}
+	if s.Name == "" {
+		s.Name = file
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %v", s.Name, err)
+	}
+	return s, nil
+}
+
+func (s *Scenario) validate() error {
+	var err error
+	if s.list, err = libcni.ConfListFromBytes(s.Config); err != nil {
+		return err
+	}
+
+	containers := map[string]bool{}
+	for i := range s.Containers {
+		c := &s.Containers[i]
+		if c.ID == "" {
+			return fmt.Errorf("container %d has no id", i)
+		}
+		if containers[c.ID] {
+			return fmt.Errorf("container %q is defined twice", c.ID)
+		}
+		containers[c.ID] = true
+		if c.IfName == "" {
+			c.IfName = "eth0"
+		}
+	}
+	netns := func(name string) error {
+		if name != "" && !containers[name] {
+			return fmt.Errorf("unknown container %q", name)
+		}
+		return nil
+	}
+	ref := func(r LinkRef) error {
+		if r.Name == "" {
+			return fmt.Errorf("link has no name")
+		}
+		if strings.HasPrefix(r.Name, "$") {
+			id, _, err := parseResultRef(r.Name)
+			if err != nil {
+				return err
+			}
+			if err := netns(id); err != nil {
+				return err
+			}
+		}
+		return netns(r.Netns)
+	}
+
+	for i, step := range s.Steps {
+		var err error
+		switch step.Action {
+		case actionAdd, actionCheck, actionDel:
+			if step.Container == "" {
+				err = fmt.Errorf("%s needs a container", step.Action)
+			} else {
+				err = netns(step.Container)
+			}
+		case actionGC:
+			for _, id := range step.Valid {
+				if err = netns(id); err != nil {
+					break
+				}
+			}
+		case actionStatus:
+		default:
+			err = fmt.Errorf("unknown action %q", step.Action)
+		}
+		for _, l := range step.Expect.Links {
+			err = firstErr(err, ref(l.LinkRef))
+		}
+		for _, a := range step.Expect.Addresses {
+			err = firstErr(err, ref(a.LinkRef))
+			if _, _, perr := net.ParseCIDR(a.Within); perr != nil {
+				err = firstErr(err, perr)
+			}
+		}
+		for _, q := range step.Expect.Qdiscs {
+			err = firstErr(err, ref(q.LinkRef))
+		}
+		for _, r := range step.Expect.Iptables {
+			err = firstErr(err, netns(r.Netns))
+			if r.Table == "" || r.Chain == "" {
+				err = firstErr(err, fmt.Errorf("iptables expectation needs a table and a chain"))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// container returns the container with id.
+func (s *Scenario) container(id string) *Container {
+	for i := range s.Containers {
+		if s.Containers[i].ID == id {
+			return &s.Containers[i]
+		}
+	}
+	return nil
+}
+
+// parseResultRef parses a link name like "$c1.0" into the container and the
+// index of the interface in its result.
+func parseResultRef(name string) (string, int, error) {
+	id, idx, ok := strings.Cut(strings.TrimPrefix(name, "$"), ".")
+	if !ok {
+		return "", 0, fmt.Errorf("link %q is not of the form $<container>.<interface>", name)
+	}
+	i, err := strconv.Atoi(idx)
+	if err != nil || i < 0 {
+		return "", 0, fmt.Errorf("link %q has an invalid interface index", name)
+	}
+	return id, i, nil
+}
+
+func firstErr(err, other error) error {
+	if err != nil {
+		return err
+	}
+	return other
+}
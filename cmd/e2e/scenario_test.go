@@ -0,0 +1,74 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("scenarios", func() {
+	It("loads the example scenarios", func() {
+		files, err := filepath.Glob("testdata/*.json")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).NotTo(BeEmpty())
+		for _, file := range files {
+			s, err := loadScenario(file)
+			Expect(err).NotTo(HaveOccurred(), file)
+			Expect(s.list.Plugins).NotTo(BeEmpty())
+			for _, c := range s.Containers {
+				Expect(c.IfName).To(Equal("eth0"))
+			}
+		}
+	})
+
+	const config = `{"cniVersion": "1.0.0", "name": "test", "plugins": [{"type": "ptp"}]}`
+
+	DescribeTable("rejects invalid scenarios",
+		func(containers, steps, msg string) {
+			s := &Scenario{Config: json.RawMessage(config)}
+			Expect(json.Unmarshal([]byte(containers), &s.Containers)).To(Succeed())
+			Expect(json.Unmarshal([]byte(steps), &s.Steps)).To(Succeed())
+			Expect(s.validate()).To(MatchError(msg))
+		},
+		Entry("duplicate container", `[{"id": "c1"}, {"id": "c1"}]`, `[]`,
+			`container "c1" is defined twice`),
+		Entry("unknown action", `[{"id": "c1"}]`, `[{"action": "attach", "container": "c1"}]`,
+			`step 1: unknown action "attach"`),
+		Entry("missing container", `[{"id": "c1"}]`, `[{"action": "add"}]`,
+			`step 1: add needs a container`),
+		Entry("unknown container", `[{"id": "c1"}]`, `[{"action": "gc", "valid": ["c2"]}]`,
+			`step 1: unknown container "c2"`),
+		Entry("bad result reference", `[{"id": "c1"}]`,
+			`[{"action": "add", "container": "c1", "expect": {"links": [{"name": "$c1"}]}}]`,
+			`step 1: link "$c1" is not of the form $<container>.<interface>`),
+		Entry("bad subnet", `[{"id": "c1"}]`,
+			`[{"action": "add", "container": "c1", "expect": {"addresses": [{"netns": "c1", "name": "eth0", "within": "10.0.0.1"}]}}]`,
+			`step 1: invalid CIDR address: 10.0.0.1`),
+	)
+
+	It("parses result references", func() {
+		id, idx, err := parseResultRef("$c1.2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(id).To(Equal("c1"))
+		Expect(idx).To(Equal(2))
+
+		_, _, err = parseResultRef("$c1.x")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,230 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config decodes network configurations, detecting the keys that no
+// field of the plugin's configuration takes, such as misspelled ones.
+//
+// Unknown keys are logged as a warning, or rejected in strict mode, which is
+// enabled by the "strictConfig" key of the configuration:
+//
+//	{"type": "bandwidth", "strictConfig": true, "ingressRate": 1000000, ...}
+//
+// or by the StrictConfig=true CNI argument. The keys shared by all plugins,
+// like "log" and "trace", and the objects owned by others, like "ipam" and
+// "runtimeConfig", are never unknown.
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/log"
+)
+
+// StrictArg is the CNI argument enabling strict mode.
+const StrictArg = "StrictConfig"
+
+// commonKeys are the top-level keys any plugin may be given.
+var commonKeys = map[string]bool{
+	"args":          true,
+	"capabilities":  true,
+	"ipam":          true,
+	"log":           true,
+	"prevResult":    true,
+	"runtimeConfig": true,
+	"strictConfig":  true,
+	"trace":         true,
+}
+
+// UnknownKeysError is returned in strict mode for configurations with keys
+// no field takes.
+type UnknownKeysError struct {
+	// Keys are the paths of the unknown keys, like "ingresRate" or
+	// "portMappings[0].hostport", in order.
+	Keys []string
+}
+
+func (e *UnknownKeysError) Error() string {
+	quoted := make([]string, len(e.Keys))
+	for i, k := range e.Keys {
+		quoted[i] = strconv.Quote(k)
+	}
+	return fmt.Sprintf("unknown configuration keys: %s", strings.Join(quoted, ", "))
+}
+
+// Decode unmarshals the network configuration data into v, a pointer to
+// the plugin's configuration. envArgs are the CNI arguments. Keys unknown to
+// v are logged, or returned as an *UnknownKeysError in strict mode.
+func Decode(data []byte, envArgs string, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	keys, err := UnknownKeys(data, v)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	if strict(data, envArgs) {
+		return &UnknownKeysError{Keys: keys}
+	}
+	log.Warn("ignoring unknown configuration keys", "keys", keys)
+	return nil
+}
+
+// strict returns whether strict mode is enabled by data or envArgs.
+func strict(data []byte, envArgs string) bool {
+	conf := struct {
+		StrictConfig bool `json:"strictConfig"`
+	}{}
+	if err := json.Unmarshal(data, &conf); err == nil && conf.StrictConfig {
+		return true
+	}
+	for _, pair := range strings.Split(envArgs, ";") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k != StrictArg {
+			continue
+		}
+		var b types.UnmarshallableBool
+		if b.UnmarshalText([]byte(v)) == nil && bool(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnknownKeys returns the paths of the keys of the JSON object data that no
+// field of v, a struct or pointer to one, takes, in order. Keys are matched
+// to fields the way encoding/json does. The values of fields of types with
+// their own unmarshalling, maps and interfaces are not looked into.
+func UnknownKeys(data []byte, v interface{}) ([]string, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for k := range obj {
+		if commonKeys[k] {
+			delete(obj, k)
+		}
+	}
+	unknownKeys(obj, reflect.TypeOf(v), "", &keys)
+	sort.Strings(keys)
+	return keys, nil
+}
+
+var unmarshalerTypes = []reflect.Type{
+	reflect.TypeOf((*json.Unmarshaler)(nil)).Elem(),
+	reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem(),
+}
+
+// opaque returns whether the fields of values of t cannot be known.
+func opaque(t reflect.Type) bool {
+	for _, u := range unmarshalerTypes {
+		if t.Implements(u) || reflect.PointerTo(t).Implements(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+func unknownKeys(obj map[string]json.RawMessage, t reflect.Type, prefix string, keys *[]string) {
+	t = indirect(t)
+	if t.Kind() != reflect.Struct || opaque(t) {
+		return
+	}
+	fields := jsonFields(t)
+	for k, raw := range obj {
+		ft, ok := fields[k]
+		if !ok {
+			// encoding/json falls back to case-insensitive matching
+			for name, f := range fields {
+				if strings.EqualFold(name, k) {
+					ft, ok = f, true
+					break
+				}
+			}
+		}
+		if !ok {
+			*keys = append(*keys, prefix+k)
+			continue
+		}
+		unknownValueKeys(raw, ft, prefix+k, keys)
+	}
+}
+
+func unknownValueKeys(raw json.RawMessage, t reflect.Type, path string, keys *[]string) {
+	t = indirect(t)
+	if opaque(t) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(raw, &obj) == nil {
+			unknownKeys(obj, t, path+".", keys)
+		}
+	case reflect.Slice, reflect.Array:
+		var elems []json.RawMessage
+		if json.Unmarshal(raw, &elems) == nil {
+			for i, elem := range elems {
+				unknownValueKeys(elem, t.Elem(), fmt.Sprintf("%s[%d]", path, i), keys)
+			}
+		}
+	}
+}
+
+// jsonFields maps the JSON names of the fields of struct type t, promoted
+// ones included, to their types.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			if et := indirect(f.Type); et.Kind() == reflect.Struct {
+				for n, ft := range jsonFields(et) {
+					if _, ok := fields[n]; !ok {
+						fields[n] = ft
+					}
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
@@ -0,0 +1,109 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/config"
+)
+
+type Rate struct {
+	IngressRate uint64 `json:"ingressRate"`
+	EgressRate  uint64 `json:"egressRate"`
+}
+
+type mapping struct {
+	HostPort int `json:"hostPort"`
+}
+
+type testConf struct {
+	types.NetConf
+	*Rate
+	Mappings []mapping      `json:"mappings,omitempty"`
+	Labels   map[string]int `json:"labels,omitempty"`
+	Subnet   types.IPNet    `json:"subnet"`
+	Gateway  net.IP         `json:"gateway"`
+	Untagged string
+	Ignored  string `json:"-"`
+}
+
+var _ = Describe("config", func() {
+	const valid = `{
+		"cniVersion": "1.0.0",
+		"name": "test",
+		"type": "test",
+		"ingressRate": 1000,
+		"mappings": [{"hostPort": 80}],
+		"labels": {"any": 1},
+		"subnet": "10.0.0.0/24",
+		"gateway": "10.0.0.1",
+		"untagged": "case-insensitive",
+		"ipam": {"type": "host-local", "subnet": "10.0.0.0/24"},
+		"runtimeConfig": {"portMappings": []},
+		"log": {"level": "debug"},
+		"trace": {"exporter": "file:/tmp/trace"},
+		"prevResult": {"cniVersion": "1.0.0"}
+	}`
+
+	It("decodes configurations without unknown keys", func() {
+		conf := &testConf{}
+		Expect(config.Decode([]byte(valid), "", conf)).To(Succeed())
+		Expect(conf.IngressRate).To(BeEquivalentTo(1000))
+		Expect(conf.Untagged).To(Equal("case-insensitive"))
+
+		keys, err := config.UnknownKeys([]byte(valid), conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(BeEmpty())
+	})
+
+	It("finds unknown keys, nested ones included", func() {
+		keys, err := config.UnknownKeys([]byte(`{
+			"name": "test",
+			"ingresRate": 1000,
+			"mappings": [{"hostPort": 80}, {"hostport": 81, "proto": "tcp"}],
+			"Ignored": "x"
+		}`), &testConf{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(Equal([]string{"Ignored", "ingresRate", "mappings[1].proto"}))
+	})
+
+	It("ignores unknown keys unless strict", func() {
+		data := []byte(`{"name": "test", "ingresRate": 1000}`)
+		conf := &testConf{}
+		Expect(config.Decode(data, "", conf)).To(Succeed())
+		Expect(conf.Name).To(Equal("test"))
+	})
+
+	It("rejects unknown keys in strict mode", func() {
+		err := config.Decode([]byte(`{"name": "test", "strictConfig": true, "ingresRate": 1000}`), "", &testConf{})
+		Expect(err).To(MatchError(`unknown configuration keys: "ingresRate"`))
+		Expect(err).To(BeAssignableToTypeOf(&config.UnknownKeysError{}))
+		Expect(err.(*config.UnknownKeysError).Keys).To(Equal([]string{"ingresRate"}))
+
+		err = config.Decode([]byte(`{"name": "test", "ingresRate": 1000}`), "IgnoreUnknown=1;StrictConfig=true", &testConf{})
+		Expect(err).To(MatchError(`unknown configuration keys: "ingresRate"`))
+
+		Expect(config.Decode([]byte(`{"name": "test", "ingresRate": 1000}`), "StrictConfig=false", &testConf{})).To(Succeed())
+	})
+
+	It("returns decoding errors", func() {
+		Expect(config.Decode([]byte(`{"name": 1}`), "", &testConf{})).NotTo(Succeed())
+	})
+})
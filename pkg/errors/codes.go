@@ -0,0 +1,75 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errors
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// ErrPluginNotAvailable is the code of the CNI spec that STATUS returns when
+// ADD requests would fail, e.g. because the backend whose failures ADD
+// reports with ErrBackendUnavailable cannot be used.
+const ErrPluginNotAvailable uint = 50
+
+// Error codes shared by the plugins of this repository. The CNI spec reserves
+// the codes below 100 for its well-known errors, such as types.ErrInvalidNetNS
+// or types.ErrTryAgainLater, which the plugins return as well.
+const (
+	// ErrInterfaceNotFound is returned when an interface the configuration
+	// names, like the master of a macvlan, does not exist.
+	ErrInterfaceNotFound uint = 100 + iota
+	// ErrBackendUnavailable is returned when the host facility a plugin
+	// programs, like iptables or the DHCP daemon, cannot be used.
+	ErrBackendUnavailable
+	// ErrPoolExhausted is returned when no address is left to allocate.
+	ErrPoolExhausted
+	// ErrAddressInUse is returned when another host answers for an address
+	// on the segment of the interface it is to be added to.
+	ErrAddressInUse
+	// ErrPortInUse is returned when a host port is already mapped to
+	// another container.
+	ErrPortInUse
+	// ErrPoolNearlyExhausted is returned by STATUS when more addresses
+	// than the configured threshold are allocated. ADD still succeeds.
+	ErrPoolNearlyExhausted
+)
+
+// Newf returns a CNI error with code and the formatted message.
+func Newf(code uint, format string, args ...interface{}) *types.Error {
+	return types.NewError(code, fmt.Sprintf(format, args...), "")
+}
+
+// Wrapf returns a CNI error with code and the formatted message, whose
+// details are err. A CNI error is returned as it is, keeping its code.
+func Wrapf(code uint, err error, format string, args ...interface{}) *types.Error {
+	var e *types.Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return types.NewError(code, fmt.Sprintf(format, args...), err.Error())
+}
+
+// Code returns the code of the CNI error in the chain of err, or
+// types.ErrInternal if there is none.
+func Code(err error) uint {
+	var e *types.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return types.ErrInternal
+}
@@ -0,0 +1,85 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+func TestWrapf(t *testing.T) {
+	tests := []struct {
+		name        string
+		existingErr error
+		expectedErr *types.Error
+	}{
+		{
+			"plain error",
+			errors.New("not found"),
+			&types.Error{Code: ErrInterfaceNotFound, Msg: `failed to find "eth0"`, Details: "not found"},
+		},
+		{
+			"CNI error",
+			fmt.Errorf("wrapped: %w", types.NewError(types.ErrTryAgainLater, "busy", "")),
+			&types.Error{Code: types.ErrTryAgainLater, Msg: "busy"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !reflect.DeepEqual(Wrapf(ErrInterfaceNotFound, test.existingErr, "failed to find %q", "eth0"), test.expectedErr) {
+				t.Errorf("test case %s fails", test.name)
+				return
+			}
+		})
+	}
+}
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode uint
+	}{
+		{
+			"plain error",
+			errors.New("failed"),
+			types.ErrInternal,
+		},
+		{
+			"CNI error",
+			Newf(ErrPoolExhausted, "no addresses left in %s", "10.0.0.0/24"),
+			ErrPoolExhausted,
+		},
+		{
+			"wrapped CNI error",
+			fmt.Errorf("context: %w", Newf(ErrBackendUnavailable, "no iptables")),
+			ErrBackendUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := Code(test.err); code != test.expectedCode {
+				t.Errorf("test case %s fails: got code %d", test.name, code)
+				return
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gc helps plugins implement GC, by matching the state they keep
+// against the attachments the runtime still considers valid.
+//
+// A GC request passes the valid attachments of the network in
+// cni.dev/valid-attachments. State recorded for an attachment, identified
+// by container ID and interface name, is garbage once the attachment is
+// no longer valid. State recorded for a whole container, or by previous
+// versions without the interface name, is garbage once no attachment of
+// the container is valid.
+package gc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+type attachment struct {
+	containerID string
+	ifName      string
+}
+
+// Attachments is a set of valid attachments.
+type Attachments struct {
+	attachments  map[attachment]bool
+	containerIDs map[string]bool
+}
+
+// NewAttachments returns the set of the valid attachments.
+func NewAttachments(valid []types.GCAttachment) *Attachments {
+	a := &Attachments{
+		attachments:  map[attachment]bool{},
+		containerIDs: map[string]bool{},
+	}
+	for _, v := range valid {
+		a.attachments[attachment{v.ContainerID, v.IfName}] = true
+		a.containerIDs[v.ContainerID] = true
+	}
+	return a
+}
+
+// ParseAttachments returns the valid attachments of the network
+// configuration of a GC request.
+func ParseAttachments(stdin []byte) (*Attachments, error) {
+	conf := types.NetConf{}
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	return NewAttachments(conf.ValidAttachments), nil
+}
+
+// Valid returns whether the attachment of ifName to the container is
+// valid. An empty ifName stands for any attachment of the container.
+func (a *Attachments) Valid(containerID, ifName string) bool {
+	if ifName == "" {
+		return a.ValidContainer(containerID)
+	}
+	return a.attachments[attachment{containerID, ifName}]
+}
+
+// ValidContainer returns whether the container has a valid attachment.
+func (a *Attachments) ValidContainer(containerID string) bool {
+	return a.containerIDs[containerID]
+}
+
+// ContainerIDs returns the containers with a valid attachment.
+func (a *Attachments) ContainerIDs() []string {
+	ids := make([]string, 0, len(a.containerIDs))
+	for id := range a.containerIDs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Names returns the names, as returned by name, of the state of the valid
+// attachments, e.g. the interfaces or chains they are expected to have.
+// State not named in it is garbage.
+func (a *Attachments) Names(name func(containerID, ifName string) string) map[string]bool {
+	names := map[string]bool{}
+	for v := range a.attachments {
+		names[name(v.containerID, v.ifName)] = true
+	}
+	return names
+}
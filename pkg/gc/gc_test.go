@@ -0,0 +1,73 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gc_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/plugins/pkg/gc"
+)
+
+var _ = Describe("gc", func() {
+	var valid *gc.Attachments
+
+	BeforeEach(func() {
+		var err error
+		valid, err = gc.ParseAttachments([]byte(`{
+			"cniVersion": "1.1.0",
+			"name": "test",
+			"type": "test",
+			"cni.dev/valid-attachments": [
+				{"containerID": "c1", "ifname": "eth0"},
+				{"containerID": "c1", "ifname": "net1"},
+				{"containerID": "c2", "ifname": "eth0"}
+			]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("matches attachments", func() {
+		Expect(valid.Valid("c1", "eth0")).To(BeTrue())
+		Expect(valid.Valid("c1", "net1")).To(BeTrue())
+		Expect(valid.Valid("c2", "net1")).To(BeFalse())
+		Expect(valid.Valid("c3", "eth0")).To(BeFalse())
+	})
+
+	It("matches containers", func() {
+		Expect(valid.ValidContainer("c2")).To(BeTrue())
+		Expect(valid.ValidContainer("c3")).To(BeFalse())
+
+		// State without interface name belongs to the whole container
+		Expect(valid.Valid("c2", "")).To(BeTrue())
+		Expect(valid.Valid("c3", "")).To(BeFalse())
+	})
+
+	It("returns the expected names", func() {
+		names := valid.Names(func(containerID, ifName string) string {
+			return containerID + "_" + ifName
+		})
+		Expect(names).To(Equal(map[string]bool{"c1_eth0": true, "c1_net1": true, "c2_eth0": true}))
+	})
+
+	It("treats a request without attachments as having none valid", func() {
+		none, err := gc.ParseAttachments([]byte(`{"cniVersion": "1.1.0", "name": "test"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(none.ValidContainer("c1")).To(BeFalse())
+
+		_, err = gc.ParseAttachments([]byte(`{`))
+		Expect(err).To(HaveOccurred())
+	})
+})
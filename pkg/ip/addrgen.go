@@ -0,0 +1,200 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"net"
+)
+
+// AddrGenMode is how the kernel generates the IPv6 link-local and SLAAC
+// addresses of an interface, the value of its addr_gen_mode sysctl.
+type AddrGenMode int
+
+const (
+	// AddrGenModeEUI64 derives the interface identifier from the MAC.
+	AddrGenModeEUI64 AddrGenMode = 0
+	// AddrGenModeNone generates no addresses.
+	AddrGenModeNone AddrGenMode = 1
+	// AddrGenModeStablePrivacy derives the interface identifier from a
+	// secret, the prefix and the permanent MAC, as of RFC 7217.
+	AddrGenModeStablePrivacy AddrGenMode = 2
+	// AddrGenModeRandom is AddrGenModeStablePrivacy with a random secret.
+	AddrGenModeRandom AddrGenMode = 3
+)
+
+var addrGenModeNames = map[AddrGenMode]string{
+	AddrGenModeEUI64:         "eui64",
+	AddrGenModeNone:          "none",
+	AddrGenModeStablePrivacy: "stable-privacy",
+	AddrGenModeRandom:        "random",
+}
+
+func (m AddrGenMode) String() string {
+	if name, ok := addrGenModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("AddrGenMode(%d)", int(m))
+}
+
+// ParseAddrGenMode parses "eui64", "none", "stable-privacy" or "random".
+func ParseAddrGenMode(s string) (AddrGenMode, error) {
+	for m, name := range addrGenModeNames {
+		if s == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid IPv6 address generation mode %q", s)
+}
+
+// linkLocalPrefix is the prefix of the link-local addresses, fe80::/64.
+var linkLocalPrefix = &net.IPNet{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(64, 128)}
+
+// EUI64Address returns the address of prefix, a /64, with the modified
+// EUI-64 interface identifier of hw, a 48 or 64-bit MAC, as of RFC 4291.
+// With a nil prefix, the link-local address is returned.
+func EUI64Address(prefix *net.IPNet, hw net.HardwareAddr) (net.IP, error) {
+	addr, err := slaacPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	switch len(hw) {
+	case 6:
+		copy(addr[8:11], hw[:3])
+		addr[11], addr[12] = 0xff, 0xfe
+		copy(addr[13:], hw[3:])
+	case 8:
+		copy(addr[8:], hw)
+	default:
+		return nil, fmt.Errorf("cannot derive an EUI-64 interface identifier from MAC %q", hw)
+	}
+	addr[8] ^= 0x02
+	return addr, nil
+}
+
+// idgenRetries is the number of times a reserved stable-privacy interface
+// identifier is generated again, like the default of the idgen_retries
+// sysctl.
+const idgenRetries = 3
+
+// StablePrivacyAddress returns the address of prefix, a /64, that the kernel
+// generates in stable-privacy mode for an interface with the permanent MAC
+// hw, given the stable_secret sysctl secret. With a nil prefix, the
+// link-local address is returned. The address is the one of the first
+// attempt, before any duplicate address detection failure.
+func StablePrivacyAddress(prefix *net.IPNet, hw net.HardwareAddr, secret net.IP) (net.IP, error) {
+	addr, err := slaacPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) != net.IPv6len || secret.To4() != nil {
+		return nil, fmt.Errorf("stable secret %q is not an IPv6 address", secret)
+	}
+	if len(hw) > maxAddrLen {
+		return nil, fmt.Errorf("MAC %q is too long", hw)
+	}
+
+	// The kernel hashes a single SHA-1 block of the secret, the prefix, the
+	// MAC zero-padded to MAX_ADDR_LEN and the DAD attempt, without padding,
+	// and stores the first two words of the digest in host byte order.
+	var block [sha1BlockSize]byte
+	copy(block[0:16], secret)
+	copy(block[16:24], addr[:8])
+	copy(block[24:24+maxAddrLen], hw)
+	for dadCount := 0; dadCount <= idgenRetries; dadCount++ {
+		block[24+maxAddrLen] = byte(dadCount)
+		digest := sha1Block(block)
+		binary.NativeEndian.PutUint32(addr[8:12], digest[0])
+		binary.NativeEndian.PutUint32(addr[12:16], digest[1])
+		if !reservedInterfaceID(addr) {
+			return addr, nil
+		}
+	}
+	return nil, fmt.Errorf("no stable-privacy address for MAC %q in prefix %s", hw, prefix)
+}
+
+// slaacPrefix returns a copy of the address of prefix, which must be a /64,
+// or of the link-local prefix if nil.
+func slaacPrefix(prefix *net.IPNet) (net.IP, error) {
+	if prefix == nil {
+		prefix = linkLocalPrefix
+	}
+	if ones, b := prefix.Mask.Size(); ones != 64 || b != 128 || prefix.IP.To4() != nil {
+		return nil, fmt.Errorf("prefix %s is not an IPv6 /64", prefix)
+	}
+	addr := make(net.IP, net.IPv6len)
+	copy(addr, prefix.IP.To16().Mask(prefix.Mask))
+	return addr, nil
+}
+
+// reservedInterfaceID returns whether the interface identifier of addr is
+// reserved, as of RFC 5453.
+func reservedInterfaceID(addr net.IP) bool {
+	hi := binary.BigEndian.Uint32(addr[8:12])
+	lo := binary.BigEndian.Uint32(addr[12:16])
+	switch {
+	case hi == 0 && lo == 0:
+		return true
+	case hi == 0x02005eff && lo&0xfe000000 == 0xfe000000:
+		return true
+	case hi == 0xfdffffff && lo&0xffffff80 == 0xffffff80:
+		return true
+	}
+	return false
+}
+
+const (
+	// maxAddrLen is the MAX_ADDR_LEN of the kernel.
+	maxAddrLen    = 32
+	sha1BlockSize = 64
+)
+
+// sha1Block returns the SHA-1 state after the compression of the single
+// block p, as the kernel's sha1_transform does; crypto/sha1 always pads the
+// message.
+func sha1Block(p [sha1BlockSize]byte) [5]uint32 {
+	h := [5]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476, 0xc3d2e1f0}
+	var w [80]uint32
+	for i := 0; i < 16; i++ {
+		w[i] = binary.BigEndian.Uint32(p[4*i:])
+	}
+	for i := 16; i < 80; i++ {
+		w[i] = bits.RotateLeft32(w[i-3]^w[i-8]^w[i-14]^w[i-16], 1)
+	}
+
+	a, b, c, d, e := h[0], h[1], h[2], h[3], h[4]
+	for i := 0; i < 80; i++ {
+		var f, k uint32
+		switch {
+		case i < 20:
+			f, k = b&c|^b&d, 0x5a827999
+		case i < 40:
+			f, k = b^c^d, 0x6ed9eba1
+		case i < 60:
+			f, k = b&c|b&d|c&d, 0x8f1bbcdc
+		default:
+			f, k = b^c^d, 0xca62c1d6
+		}
+		a, b, c, d, e = bits.RotateLeft32(a, 5)+f+e+k+w[i], a, bits.RotateLeft32(b, 30), c, d
+	}
+	h[0] += a
+	h[1] += b
+	h[2] += c
+	h[3] += d
+	h[4] += e
+	return h
+}
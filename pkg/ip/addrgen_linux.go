@@ -0,0 +1,104 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+)
+
+func ipv6ConfSysctl(ifName, name string) string {
+	return fmt.Sprintf("net/ipv6/conf/%s/%s", ifName, name)
+}
+
+// SetAddrGenMode sets the IPv6 address generation mode of the interface in
+// the current namespace, and for AddrGenModeStablePrivacy its secret, which
+// must be an IPv6 address. It is to be called before the interface is set
+// up, as the addresses generated before are not removed.
+func SetAddrGenMode(ifName string, mode AddrGenMode, secret net.IP) error {
+	if mode == AddrGenModeStablePrivacy {
+		if len(secret) != net.IPv6len || secret.To4() != nil {
+			return fmt.Errorf("stable-privacy mode needs an IPv6 address as secret, got %q", secret)
+		}
+		if _, err := sysctl.Sysctl(ipv6ConfSysctl(ifName, "stable_secret"), secret.String()); err != nil {
+			return fmt.Errorf("failed to set the stable secret of %q: %v", ifName, err)
+		}
+	}
+	if _, err := sysctl.Sysctl(ipv6ConfSysctl(ifName, "addr_gen_mode"), strconv.Itoa(int(mode))); err != nil {
+		return fmt.Errorf("failed to set the IPv6 address generation mode of %q to %s: %v", ifName, mode, err)
+	}
+	return nil
+}
+
+// GetAddrGenMode returns the IPv6 address generation mode of the interface
+// in the current namespace.
+func GetAddrGenMode(ifName string) (AddrGenMode, error) {
+	value, err := sysctl.Sysctl(ipv6ConfSysctl(ifName, "addr_gen_mode"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the IPv6 address generation mode of %q: %v", ifName, err)
+	}
+	mode, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid IPv6 address generation mode %q of %q", value, ifName)
+	}
+	return AddrGenMode(mode), nil
+}
+
+// GeneratedAddress returns the address of prefix, a /64, that the interface
+// in the current namespace generates in its current mode. With a nil prefix,
+// the link-local address is returned. There is none in the none and random
+// modes.
+func GeneratedAddress(ifName string, prefix *net.IPNet) (net.IP, error) {
+	mode, err := GetAddrGenMode(ifName)
+	if err != nil {
+		return nil, err
+	}
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	switch mode {
+	case AddrGenModeEUI64:
+		return EUI64Address(prefix, link.Attrs().HardwareAddr)
+	case AddrGenModeStablePrivacy:
+		secret, err := stableSecret(ifName)
+		if err != nil {
+			return nil, err
+		}
+		return StablePrivacyAddress(prefix, link.Attrs().PermHWAddr, secret)
+	}
+	return nil, fmt.Errorf("%q generates no predictable address in %s mode", ifName, mode)
+}
+
+// stableSecret returns the stable secret of the interface, or else the
+// default one of the namespace.
+func stableSecret(ifName string) (net.IP, error) {
+	for _, name := range []string{ifName, "default"} {
+		// reading a secret that is not set fails
+		value, err := sysctl.Sysctl(ipv6ConfSysctl(name, "stable_secret"))
+		if err != nil {
+			continue
+		}
+		if secret := net.ParseIP(value); secret != nil {
+			return secret, nil
+		}
+	}
+	return nil, fmt.Errorf("%q has no stable secret", ifName)
+}
@@ -0,0 +1,118 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip_test
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+var _ = Describe("IPv6 address generation modes", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			return netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "gen0"},
+				PeerName:  "gen1",
+			})
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	// linkLocal sets the veth pair up and returns the link-local address of
+	// its ifName end.
+	linkLocal := func(ifName string) net.IP {
+		peer, err := netlinksafe.LinkByName("gen1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netlink.LinkSetUp(peer)).To(Succeed())
+		link, err := netlinksafe.LinkByName(ifName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netlink.LinkSetUp(link)).To(Succeed())
+
+		var addr net.IP
+		Eventually(func() net.IP {
+			addrs, err := netlinksafe.AddrList(link, netlink.FAMILY_V6)
+			Expect(err).NotTo(HaveOccurred())
+			for _, a := range addrs {
+				if a.IP.IsLinkLocalUnicast() {
+					addr = a.IP
+				}
+			}
+			return addr
+		}).ShouldNot(BeNil())
+		return addr
+	}
+
+	for _, mode := range []ip.AddrGenMode{ip.AddrGenModeEUI64, ip.AddrGenModeStablePrivacy} {
+		mode := mode
+		It("predicts the link-local address in "+mode.String()+" mode", func() {
+			err := testNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				Expect(ip.SetAddrGenMode("gen0", mode, net.ParseIP("2001:db8::5ec:7e7"))).To(Succeed())
+				current, err := ip.GetAddrGenMode("gen0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(current).To(Equal(mode))
+
+				expected, err := ip.GeneratedAddress("gen0", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(linkLocal("gen0")).To(Equal(expected))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	}
+
+	It("generates no link-local address in none mode", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(ip.SetAddrGenMode("gen0", ip.AddrGenModeNone, nil)).To(Succeed())
+			_, err := ip.GeneratedAddress("gen0", nil)
+			Expect(err).To(MatchError(`"gen0" generates no predictable address in none mode`))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("requires a secret in stable-privacy mode", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(ip.SetAddrGenMode("gen0", ip.AddrGenModeStablePrivacy, nil)).NotTo(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,101 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IPv6 address generation", func() {
+	It("parses and formats modes", func() {
+		for _, name := range []string{"eui64", "none", "stable-privacy", "random"} {
+			mode, err := ParseAddrGenMode(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mode.String()).To(Equal(name))
+		}
+		_, err := ParseAddrGenMode("eui-64")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("derives EUI-64 addresses", func() {
+		hw, _ := net.ParseMAC("00:11:22:33:44:55")
+		addr, err := EUI64Address(nil, hw)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addr.String()).To(Equal("fe80::211:22ff:fe33:4455"))
+
+		_, prefix, _ := net.ParseCIDR("2001:db8:1:2::/64")
+		addr, err = EUI64Address(prefix, hw)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addr.String()).To(Equal("2001:db8:1:2:211:22ff:fe33:4455"))
+
+		_, prefix, _ = net.ParseCIDR("2001:db8::/48")
+		_, err = EUI64Address(prefix, hw)
+		Expect(err).To(MatchError("prefix 2001:db8::/48 is not an IPv6 /64"))
+	})
+
+	It("derives stable-privacy addresses from the secret, prefix and MAC", func() {
+		hw, _ := net.ParseMAC("00:11:22:33:44:55")
+		secret := net.ParseIP("2001:db8::1")
+		_, prefix, _ := net.ParseCIDR("2001:db8:1:2::/64")
+
+		ll, err := StablePrivacyAddress(nil, hw, secret)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ll.IsLinkLocalUnicast()).To(BeTrue())
+
+		global, err := StablePrivacyAddress(prefix, hw, secret)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(prefix.Contains(global)).To(BeTrue())
+		Expect(global[8:]).NotTo(Equal(ll[8:]))
+
+		again, err := StablePrivacyAddress(prefix, hw, secret)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(Equal(global))
+
+		other, err := StablePrivacyAddress(prefix, hw, net.ParseIP("2001:db8::2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other).NotTo(Equal(global))
+
+		_, err = StablePrivacyAddress(prefix, hw, net.ParseIP("10.0.0.1"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("compresses SHA-1 blocks", func() {
+		// a 55-byte message is padded to a single block
+		msg := []byte("the quick brown fox jumps over the lazy dog, 55 bytes..")
+		Expect(msg).To(HaveLen(55))
+		var block [sha1BlockSize]byte
+		copy(block[:], msg)
+		block[55] = 0x80
+		binary.BigEndian.PutUint64(block[56:], uint64(len(msg))*8)
+
+		sum := sha1.Sum(msg)
+		digest := sha1Block(block)
+		for i, word := range digest {
+			Expect(word).To(Equal(binary.BigEndian.Uint32(sum[4*i:])))
+		}
+	})
+
+	It("knows the reserved interface identifiers", func() {
+		Expect(reservedInterfaceID(net.ParseIP("fe80::"))).To(BeTrue())
+		Expect(reservedInterfaceID(net.ParseIP("fe80::200:5eff:fe00:1"))).To(BeTrue())
+		Expect(reservedInterfaceID(net.ParseIP("fe80::fdff:ffff:ffff:ff80"))).To(BeTrue())
+		Expect(reservedInterfaceID(net.ParseIP("fe80::1"))).To(BeFalse())
+	})
+})
@@ -0,0 +1,262 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/mdlayher/packet"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+)
+
+// Addresses are probed as in IPv4 Address Conflict Detection (RFC 5227) and
+// IPv6 Duplicate Address Detection (RFC 4862): a few ARP probes, or neighbor
+// solicitations, from the unspecified address are sent for the address, and
+// any host answering for it, or probing for it at the same time, holds it.
+
+// conflictProbes is the number of probes sent over the probe timeout.
+const conflictProbes = 3
+
+// AddressConflictError is returned when another host answers for an address.
+type AddressConflictError struct {
+	IP net.IP
+	// HardwareAddr is the address of the host that answered
+	HardwareAddr net.HardwareAddr
+}
+
+func (e *AddressConflictError) Error() string {
+	return fmt.Sprintf("address %s is already in use by %s", e.IP, e.HardwareAddr)
+}
+
+// ProbeAddressConflict probes the segment of the interface ifName, which
+// must be up, for another host using ip, for up to timeout. It returns an
+// *AddressConflictError if there is one. Interfaces without neighbor
+// discovery, like those of point-to-point links, are not probed.
+func ProbeAddressConflict(ifName string, ip net.IP, timeout time.Duration) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	attrs := link.Attrs()
+	if attrs.RawFlags&unix.IFF_NOARP != 0 || len(attrs.HardwareAddr) != 6 {
+		return nil
+	}
+	if attrs.RawFlags&unix.IFF_UP == 0 {
+		return fmt.Errorf("cannot probe for address conflicts on %q: link is down", ifName)
+	}
+	ifi := &net.Interface{Index: attrs.Index, Name: attrs.Name, HardwareAddr: attrs.HardwareAddr, MTU: attrs.MTU}
+
+	var p *prober
+	if ip4 := ip.To4(); ip4 != nil {
+		p, err = newARPProber(ifi, ip4)
+	} else {
+		p, err = newNDPProber(ifi, ip.To16())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to probe for %s on %q: %v", ip, ifName, err)
+	}
+	defer p.conn.Close()
+
+	interval := timeout / conflictProbes
+	for i := 0; i < conflictProbes; i++ {
+		if _, err := p.conn.WriteTo(p.probe, &packet.Addr{HardwareAddr: p.dst}); err != nil {
+			return fmt.Errorf("failed to probe for %s on %q: %v", ip, ifName, err)
+		}
+		if err := p.listen(time.Now().Add(interval)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prober sends the probe for ip to dst, and takes the replies matched by
+// conflict as conflicts.
+type prober struct {
+	conn     *packet.Conn
+	ip       net.IP
+	probe    []byte
+	dst      net.HardwareAddr
+	conflict func(reply []byte) bool
+	// seesOwn is set when the kernel cannot leave our own probes out
+	seesOwn bool
+}
+
+// listenProbes returns a packet socket for proto on ifi that does not see
+// the packets sent on ifi, as those are our own probes. They cannot be told
+// apart by their source address, as hosts sharing the hardware address of
+// ifi, like the other ipvlan interfaces on its master, would be missed.
+// Kernels older than 4.20 show all packets, which is reported by seesOwn.
+func listenProbes(ifi *net.Interface, proto int) (*packet.Conn, bool, error) {
+	conn, err := packet.Listen(ifi, packet.Datagram, proto, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_PACKET, unix.PACKET_IGNORE_OUTGOING, 1)
+	}); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if sockErr != nil && !errors.Is(sockErr, unix.ENOPROTOOPT) {
+		conn.Close()
+		return nil, false, sockErr
+	}
+	return conn, sockErr != nil, nil
+}
+
+// listen waits until deadline for a reply showing a conflict.
+func (p *prober) listen(deadline time.Time) error {
+	if err := p.conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return fmt.Errorf("failed to read replies to probes for %s: %v", p.ip, err)
+		}
+		// Without PACKET_IGNORE_OUTGOING our own probes are seen as well
+		if p.seesOwn && bytes.Equal(buf[:n], p.probe) {
+			continue
+		}
+		sender := from.(*packet.Addr).HardwareAddr
+		if p.conflict(buf[:n]) {
+			return &AddressConflictError{IP: p.ip, HardwareAddr: sender}
+		}
+	}
+}
+
+// newARPProber returns the prober of ip, sending ARP probes.
+func newARPProber(ifi *net.Interface, ip net.IP) (*prober, error) {
+	conn, seesOwn, err := listenProbes(ifi, unix.ETH_P_ARP)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ethernet/IPv4 ARP request from 0.0.0.0, see RFC 826
+	probe := make([]byte, 28)
+	binary.BigEndian.PutUint16(probe[0:2], 1)
+	binary.BigEndian.PutUint16(probe[2:4], unix.ETH_P_IP)
+	probe[4], probe[5] = 6, 4
+	binary.BigEndian.PutUint16(probe[6:8], 1)
+	copy(probe[8:14], ifi.HardwareAddr)
+	copy(probe[24:28], ip)
+
+	return &prober{
+		conn:    conn,
+		ip:      ip,
+		probe:   probe,
+		dst:     net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		seesOwn: seesOwn,
+		conflict: func(reply []byte) bool {
+			if len(reply) < 28 || binary.BigEndian.Uint16(reply[2:4]) != unix.ETH_P_IP || reply[4] != 6 || reply[5] != 4 {
+				return false
+			}
+			senderIP, targetIP := net.IP(reply[14:18]), net.IP(reply[24:28])
+			// Any ARP packet from the address, or a probe for it
+			return senderIP.Equal(ip) || (senderIP.Equal(net.IPv4zero) && targetIP.Equal(ip))
+		},
+	}, nil
+}
+
+// newNDPProber returns the prober of ip, sending neighbor solicitations to
+// its solicited-node multicast address.
+func newNDPProber(ifi *net.Interface, ip net.IP) (*prober, error) {
+	conn, seesOwn, err := listenProbes(ifi, unix.ETH_P_IPV6)
+	if err != nil {
+		return nil, err
+	}
+
+	dstIP := net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0xff, ip[13], ip[14], ip[15]}
+
+	// IPv6 header from ::, then the ICMPv6 neighbor solicitation for ip,
+	// without source link-layer address option, see RFC 4861
+	probe := make([]byte, 40+24)
+	probe[0] = 6 << 4
+	binary.BigEndian.PutUint16(probe[4:6], 24)
+	probe[6] = unix.IPPROTO_ICMPV6
+	probe[7] = 255
+	copy(probe[24:40], dstIP)
+	probe[40] = ndpNeighborSolicitation
+	copy(probe[48:64], ip)
+	binary.BigEndian.PutUint16(probe[42:44], icmpv6Checksum(net.IPv6unspecified, dstIP, probe[40:]))
+
+	return &prober{
+		conn:    conn,
+		ip:      ip,
+		probe:   probe,
+		dst:     net.HardwareAddr{0x33, 0x33, 0xff, ip[13], ip[14], ip[15]},
+		seesOwn: seesOwn,
+		conflict: func(reply []byte) bool {
+			if len(reply) < 40+24 || reply[0]>>4 != 6 || reply[6] != unix.IPPROTO_ICMPV6 {
+				return false
+			}
+			srcIP, icmpType, targetIP := net.IP(reply[8:24]), reply[40], net.IP(reply[48:64])
+			if !targetIP.Equal(ip) {
+				return false
+			}
+			// An advertisement for the address, or another probe for it
+			return icmpType == ndpNeighborAdvertisement ||
+				(icmpType == ndpNeighborSolicitation && srcIP.Equal(net.IPv6unspecified))
+		},
+	}, nil
+}
+
+const (
+	ndpNeighborSolicitation  = 135
+	ndpNeighborAdvertisement = 136
+)
+
+// icmpv6Checksum returns the checksum of the ICMPv6 message msg from src to
+// dst, see RFC 4443.
+func icmpv6Checksum(src, dst net.IP, msg []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src.To16())
+	add(dst.To16())
+	pseudo := make([]byte, 8)
+	binary.BigEndian.PutUint32(pseudo[0:4], uint32(len(msg)))
+	pseudo[7] = unix.IPPROTO_ICMPV6
+	add(pseudo)
+	add(msg)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
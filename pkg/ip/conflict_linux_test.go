@@ -0,0 +1,158 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip_test
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+var _ = Describe("ProbeAddressConflict", func() {
+	const timeout = 300 * time.Millisecond
+
+	var (
+		hostNS, containerNS ns.NetNS
+		hostVeth            net.Interface
+		containerVethName   string
+	)
+
+	BeforeEach(func() {
+		var err error
+		hostNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		containerNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = containerNS.Do(func(ns.NetNS) error {
+			var containerVeth net.Interface
+			hostVeth, containerVeth, err = ip.SetupVeth("eth0", 1500, "", hostNS)
+			if err != nil {
+				return err
+			}
+			containerVethName = containerVeth.Name
+			link, err := netlinksafe.LinkByName(containerVethName)
+			if err != nil {
+				return err
+			}
+			return netlink.LinkSetUp(link)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// The host holds an address of each family
+		err = hostNS.Do(func(ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(hostVeth.Name)
+			if err != nil {
+				return err
+			}
+			if err := netlink.LinkSetUp(link); err != nil {
+				return err
+			}
+			for _, addr := range []string{"10.1.2.3/24", "fd00::3/64"} {
+				ipn, err := netlink.ParseIPNet(addr)
+				if err != nil {
+					return err
+				}
+				if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: ipn, Flags: unix.IFA_F_NODAD}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(containerNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(containerNS)).To(Succeed())
+		Expect(hostNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(hostNS)).To(Succeed())
+	})
+
+	DescribeTable("detects the addresses of other hosts",
+		func(addr string) {
+			err := containerNS.Do(func(ns.NetNS) error {
+				return ip.ProbeAddressConflict(containerVethName, net.ParseIP(addr), timeout)
+			})
+			var conflict *ip.AddressConflictError
+			Expect(err).To(BeAssignableToTypeOf(conflict))
+			conflict = err.(*ip.AddressConflictError)
+			Expect(conflict.IP.Equal(net.ParseIP(addr))).To(BeTrue())
+			Expect(conflict.HardwareAddr).To(Equal(hostVeth.HardwareAddr))
+		},
+		Entry("IPv4", "10.1.2.3"),
+		Entry("IPv6", "fd00::3"),
+	)
+
+	DescribeTable("finds no conflict for free addresses",
+		func(addr string) {
+			err := containerNS.Do(func(ns.NetNS) error {
+				return ip.ProbeAddressConflict(containerVethName, net.ParseIP(addr), timeout)
+			})
+			Expect(err).NotTo(HaveOccurred())
+		},
+		Entry("IPv4", "10.1.2.4"),
+		Entry("IPv6", "fd00::4"),
+	)
+
+	It("detects hosts sharing the hardware address of the interface", func() {
+		// Like ipvlan interfaces on the same master
+		var hwAddr net.HardwareAddr
+		err := containerNS.Do(func(ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(containerVethName)
+			hwAddr = link.Attrs().HardwareAddr
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+		err = hostNS.Do(func(ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(hostVeth.Name)
+			if err != nil {
+				return err
+			}
+			return netlink.LinkSetHardwareAddr(link, hwAddr)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = containerNS.Do(func(ns.NetNS) error {
+			return ip.ProbeAddressConflict(containerVethName, net.ParseIP("10.1.2.3"), timeout)
+		})
+		var conflict *ip.AddressConflictError
+		Expect(err).To(BeAssignableToTypeOf(conflict))
+		Expect(err.(*ip.AddressConflictError).HardwareAddr).To(Equal(hwAddr))
+	})
+
+	It("fails on a link that is down", func() {
+		err := containerNS.Do(func(ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(containerVethName)
+			if err != nil {
+				return err
+			}
+			if err := netlink.LinkSetDown(link); err != nil {
+				return err
+			}
+			return ip.ProbeAddressConflict(containerVethName, net.ParseIP("10.1.2.4"), timeout)
+		})
+		Expect(err).To(MatchError(`cannot probe for address conflicts on "eth0": link is down`))
+	})
+})
@@ -23,9 +23,13 @@ import (
 
 	"github.com/safchain/ethtool"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 
+	"github.com/containernetworking/plugins/pkg/journal"
+	"github.com/containernetworking/plugins/pkg/log"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/trace"
 	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 )
 
@@ -49,9 +53,17 @@ func makeVethPair(name, peer string, mtu int, mac string, hostNS ns.NetNS) (netl
 		}
 		veth.LinkAttrs.HardwareAddr = m
 	}
-	if err := netlink.LinkAdd(veth); err != nil {
+	// The host end is journaled, for ReconcileJournal to find it
+	done := journal.Begin("veth", peer)
+	span := trace.Start("netlink veth", "name", name, "peer", peer)
+	err := netlink.LinkAdd(veth)
+	span.SetError(err)
+	span.End()
+	done(err)
+	if err != nil {
 		return nil, err
 	}
+	log.Debug("created veth pair", "name", name, "peer", peer)
 	// Re-fetch the container link to get its creation-time parameters, e.g. index and mac
 	veth2, err := netlinksafe.LinkByName(name)
 	if err != nil {
@@ -173,6 +185,99 @@ func SetupVeth(contVethName string, mtu int, contVethMac string, hostNS ns.NetNS
 	return SetupVethWithName(contVethName, "", mtu, contVethMac, hostNS)
 }
 
+// SetupVethAt sets up a pair of virtual ethernet devices like SetupVeth,
+// but is called from the host netns: the container-side veth is created
+// directly in contNS and looked up through a netlink handle in it, so that
+// the calling thread does not have to enter the container netns.
+// On success, SetupVethAt returns (hostVeth, containerVeth, nil)
+func SetupVethAt(contVethName string, mtu int, contVethMac string, contNS ns.NetNS) (net.Interface, net.Interface, error) {
+	contHandle, err := netlinksafe.NewHandleAt(netns.NsHandle(int(contNS.Fd())))
+	if err != nil {
+		return net.Interface{}, net.Interface{}, fmt.Errorf("failed to open netlink handle in %q: %v", contNS.Path(), err)
+	}
+	defer contHandle.Close()
+
+	// The name of the host-side veth is random, so a name conflict on
+	// creation is for the container side only if it is taken in contNS.
+	if _, err := contHandle.LinkByName(contVethName); err == nil {
+		return net.Interface{}, net.Interface{}, fmt.Errorf("container veth name (%q) already exists", contVethName)
+	}
+
+	var hostVeth netlink.Link
+	for i := 0; i < 10; i++ {
+		var hostVethName string
+		hostVethName, err = RandomVethName()
+		if err != nil {
+			return net.Interface{}, net.Interface{}, err
+		}
+
+		hostVeth, err = makeVethPairAt(hostVethName, contVethName, mtu, contVethMac, contNS)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		if os.IsExist(err) {
+			return net.Interface{}, net.Interface{}, fmt.Errorf("failed to find a unique veth name")
+		}
+		return net.Interface{}, net.Interface{}, fmt.Errorf("failed to make veth pair: %v", err)
+	}
+
+	contVeth, err := contHandle.LinkByName(contVethName)
+	if err != nil {
+		netlink.LinkDel(hostVeth) // try and clean up the link if possible.
+		return net.Interface{}, net.Interface{}, fmt.Errorf("failed to lookup %q in %q: %v", contVethName, contNS.Path(), err)
+	}
+
+	if err = netlink.LinkSetUp(hostVeth); err != nil {
+		return net.Interface{}, net.Interface{}, fmt.Errorf("failed to set %q up: %v", hostVeth.Attrs().Name, err)
+	}
+
+	// we want to own the routes for this interface
+	_, _ = sysctl.Sysctl(fmt.Sprintf("net/ipv6/conf/%s/accept_ra", hostVeth.Attrs().Name), "0")
+
+	return ifaceFromNetlinkLink(hostVeth), ifaceFromNetlinkLink(contVeth), nil
+}
+
+// makeVethPairAt is called from the host netns, it creates the peer
+// directly in contNS.
+func makeVethPairAt(name, peer string, mtu int, peerMac string, contNS ns.NetNS) (netlink.Link, error) {
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = name
+	linkAttrs.MTU = mtu
+
+	veth := &netlink.Veth{
+		LinkAttrs:     linkAttrs,
+		PeerName:      peer,
+		PeerNamespace: netlink.NsFd(int(contNS.Fd())),
+	}
+	if peerMac != "" {
+		m, err := net.ParseMAC(peerMac)
+		if err != nil {
+			return nil, err
+		}
+		veth.PeerHardwareAddr = m
+	}
+	done := journal.Begin("veth", name)
+	span := trace.Start("netlink veth", "name", name, "peer", peer)
+	err := netlink.LinkAdd(veth)
+	span.SetError(err)
+	span.End()
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("created veth pair", "name", name, "peer", peer)
+	// Re-fetch the link to get its creation-time parameters, e.g. index and mac
+	veth2, err := netlinksafe.LinkByName(name)
+	if err != nil {
+		netlink.LinkDel(veth) // try and clean up the link if possible.
+		return nil, err
+	}
+
+	return veth2, nil
+}
+
 // DelLinkByName removes an interface link.
 func DelLinkByName(ifName string) error {
 	iface, err := netlinksafe.LinkByName(ifName)
@@ -183,13 +288,47 @@ func DelLinkByName(ifName string) error {
 		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
 	}
 
-	if err = netlink.LinkDel(iface); err != nil {
+	done := journal.Begin("link-delete", ifName)
+	span := trace.Start("netlink link-delete", "name", ifName)
+	err = netlink.LinkDel(iface)
+	span.SetError(err)
+	span.End()
+	done(err)
+	if err != nil {
 		return fmt.Errorf("failed to delete %q: %v", ifName, err)
 	}
+	log.Debug("deleted link", "name", ifName)
 
 	return nil
 }
 
+// ReconcileJournal is the journal.Reconciler of the steps taken by this
+// package. It deletes, from the current network namespace, the host end of
+// the veth pairs that were being created. Interrupted deletions are left for
+// DEL to retry.
+func ReconcileJournal(entry journal.Entry) error {
+	for _, step := range entry.Pending {
+		if step.Kind != "veth" {
+			continue
+		}
+		link, err := netlinksafe.LinkByName(step.Name)
+		if err != nil {
+			if _, ok := err.(netlink.LinkNotFoundError); ok {
+				continue
+			}
+			return fmt.Errorf("failed to lookup %q: %v", step.Name, err)
+		}
+		if _, ok := link.(*netlink.Veth); !ok {
+			continue
+		}
+		if err := netlink.LinkDel(link); err != nil {
+			return fmt.Errorf("failed to delete %q: %v", step.Name, err)
+		}
+		log.Debug("deleted journaled link", "name", step.Name, "containerID", entry.Invocation.ContainerID)
+	}
+	return nil
+}
+
 // DelLinkByNameAddr remove an interface and returns its addresses
 func DelLinkByNameAddr(ifName string) ([]*net.IPNet, error) {
 	iface, err := netlinksafe.LinkByName(ifName)
@@ -24,6 +24,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/journal"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
@@ -251,6 +252,52 @@ var _ = Describe("Link", func() {
 		})
 	})
 
+	Context("when the veth pair is set up from the host", func() {
+		BeforeEach(func() {
+			containerVethName += "0"
+			rand.Reader = originalRandReader
+		})
+
+		It("creates the container-side veth directly in the container namespace", func() {
+			const mac = "02:00:00:00:01:24"
+			var contVeth net.Interface
+			_ = hostNetNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				var err error
+				hostVeth, contVeth, err = ip.SetupVethAt(containerVethName, mtu, mac, containerNetNS)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(contVeth.Name).To(Equal(containerVethName))
+				Expect(contVeth.HardwareAddr.String()).To(Equal(mac))
+				Expect(contVeth.MTU).To(Equal(mtu))
+
+				link, err := netlinksafe.LinkByName(hostVeth.Name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.Attrs().Flags & net.FlagUp).To(Equal(net.FlagUp))
+				return nil
+			})
+
+			_ = containerNetNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				link, err := netlinksafe.LinkByName(containerVethName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.Attrs().Index).To(Equal(contVeth.Index))
+				return nil
+			})
+		})
+
+		It("returns useful error if the container name is taken", func() {
+			_ = hostNetNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				_, _, err := ip.SetupVethAt(containerVeth.Name, mtu, "", containerNetNS)
+				Expect(err).To(MatchError(fmt.Sprintf("container veth name (%q) already exists", containerVeth.Name)))
+				return nil
+			})
+		})
+	})
+
 	It("DelLinkByName must delete the veth endpoints", func() {
 		_ = containerNetNS.Do(func(ns.NetNS) error {
 			defer GinkgoRecover()
@@ -275,6 +322,28 @@ var _ = Describe("Link", func() {
 		})
 	})
 
+	It("ReconcileJournal must delete the journaled host endpoints", func() {
+		entry := journal.Entry{Pending: []journal.Step{
+			{Seq: 1, Kind: "veth", Name: hostVethName},
+			{Seq: 2, Kind: "veth", Name: "lo"},
+			{Seq: 3, Kind: "veth", Name: "missing0"},
+		}}
+
+		_ = hostNetNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(ip.ReconcileJournal(entry)).To(Succeed())
+
+			_, err := netlinksafe.LinkByName(hostVethName)
+			Expect(err).To(HaveOccurred())
+			// Only veths are deleted
+			_, err = netlinksafe.LinkByName("lo")
+			Expect(err).NotTo(HaveOccurred())
+
+			return nil
+		})
+	})
+
 	It("DelLinkByNameAddr should return no IPs when no IPs are configured", func() {
 		_ = containerNetNS.Do(func(ns.NetNS) error {
 			defer GinkgoRecover()
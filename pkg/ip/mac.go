@@ -0,0 +1,76 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+)
+
+// PrivateMACPrefix is the OUI-like prefix used for MACs derived from an
+// IPv4 address. The first octet has the locally-administered bit set and
+// the multicast bit cleared.
+var PrivateMACPrefix = []byte{0x0a, 0x58}
+
+// setLocallyAdministered marks hw as a locally-administered unicast address.
+func setLocallyAdministered(hw net.HardwareAddr) {
+	hw[0] = (hw[0] | 0x02) &^ 0x01
+}
+
+// StableMAC derives a locally-administered unicast MAC from the given
+// identity strings (typically the container ID and interface name). The
+// same inputs always produce the same address, so an interface recreated
+// for the same attachment keeps its MAC.
+func StableMAC(ids ...string) net.HardwareAddr {
+	h := sha256.New()
+	for _, id := range ids {
+		// include a separator so ("ab", "c") and ("a", "bc") differ
+		h.Write([]byte(id))
+		h.Write([]byte{0})
+	}
+	sum := h.Sum(nil)
+
+	hw := make(net.HardwareAddr, 6)
+	copy(hw, sum[:6])
+	setLocallyAdministered(hw)
+	return hw
+}
+
+// MACFromIP derives a locally-administered MAC from an IP address. For
+// IPv4 the result is PrivateMACPrefix followed by the four address octets;
+// for IPv6 the last four octets of the address are used instead.
+func MACFromIP(ip net.IP) (net.HardwareAddr, error) {
+	var suffix net.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		suffix = ip4
+	} else if ip6 := ip.To16(); ip6 != nil {
+		suffix = ip6[12:]
+	} else {
+		return nil, fmt.Errorf("invalid IP address %q", ip.String())
+	}
+
+	hw := make(net.HardwareAddr, 0, 6)
+	hw = append(hw, PrivateMACPrefix...)
+	hw = append(hw, suffix...)
+	setLocallyAdministered(hw)
+	return hw, nil
+}
+
+// IsLocallyAdministeredMAC reports whether hw is a locally-administered
+// unicast MAC address.
+func IsLocallyAdministeredMAC(hw net.HardwareAddr) bool {
+	return len(hw) > 0 && hw[0]&0x02 == 0x02 && hw[0]&0x01 == 0
+}
@@ -0,0 +1,59 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MAC generation", func() {
+	It("StableMAC is deterministic and locally administered", func() {
+		a := StableMAC("container-1", "eth0")
+		b := StableMAC("container-1", "eth0")
+		c := StableMAC("container-1", "eth1")
+
+		Expect(a).To(HaveLen(6))
+		Expect(a).To(Equal(b))
+		Expect(a).NotTo(Equal(c))
+		Expect(IsLocallyAdministeredMAC(a)).To(BeTrue())
+		Expect(IsLocallyAdministeredMAC(c)).To(BeTrue())
+
+		Expect(StableMAC("ab", "c")).NotTo(Equal(StableMAC("a", "bc")))
+	})
+
+	It("MACFromIP", func() {
+		testCases := []struct {
+			ip  net.IP
+			mac string
+		}{
+			{net.ParseIP("10.0.0.2"), "0a:58:0a:00:00:02"},
+			{net.ParseIP("192.168.1.254"), "0a:58:c0:a8:01:fe"},
+			{net.ParseIP("2001:db8::c0a8:102"), "0a:58:c0:a8:01:02"},
+		}
+
+		for _, test := range testCases {
+			hw, err := MACFromIP(test.ip)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hw.String()).To(Equal(test.mac))
+			Expect(IsLocallyAdministeredMAC(hw)).To(BeTrue())
+		}
+
+		_, err := MACFromIP(net.IP{1, 2, 3})
+		Expect(err).To(HaveOccurred())
+	})
+})
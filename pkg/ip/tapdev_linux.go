@@ -0,0 +1,136 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	sysClassNet = "/sys/class/net"
+	devDir      = "/dev"
+)
+
+// TapDevice is the character device of a macvtap or ipvtap link.
+type TapDevice struct {
+	Path string `json:"path"`
+	Dev  uint64 `json:"dev"`
+	// Created is set if SetupTapDevice created the node, as devtmpfs did
+	// not. The kernel only removes the nodes of devtmpfs along with the
+	// link, the others are left to RemoveTapDevice.
+	Created bool `json:"created,omitempty"`
+}
+
+// SetupTapDevice makes sure the character device of the macvtap or ipvtap
+// link named ifName exists, as /dev/tap<index> like devtmpfs creates it, and
+// sets its owner and group if they are given.
+// The link is looked up in sysfs, so SetupTapDevice must be called while the
+// link is in the network namespace sysfs was mounted from, i.e. the host's.
+// The device keeps its number when the link is moved to another namespace.
+func SetupTapDevice(ifName string, owner, group *uint32) (*TapDevice, error) {
+	// e.g. /sys/class/net/macvtap0/macvtap/tap12/dev
+	matches, err := filepath.Glob(filepath.Join(sysClassNet, ifName, "*", "tap*", "dev"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) != 1 {
+		return nil, fmt.Errorf("failed to find the tap device of %q in %s", ifName, sysClassNet)
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		return nil, err
+	}
+	dev, err := parseDevNumber(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tap device number of %q: %v", ifName, err)
+	}
+
+	tap := &TapDevice{Path: filepath.Join(devDir, filepath.Base(filepath.Dir(matches[0]))), Dev: dev}
+	var st unix.Stat_t
+	switch err := unix.Stat(tap.Path, &st); {
+	case err == nil:
+		if st.Mode&unix.S_IFMT != unix.S_IFCHR || st.Rdev != dev {
+			return nil, fmt.Errorf("%s is not the tap device of %q", tap.Path, ifName)
+		}
+	case os.IsNotExist(err):
+		// No devtmpfs
+		if err := unix.Mknod(tap.Path, unix.S_IFCHR|0o600, int(dev)); err != nil {
+			return nil, fmt.Errorf("failed to create tap device %s: %v", tap.Path, err)
+		}
+		tap.Created = true
+	default:
+		return nil, err
+	}
+
+	uid, gid := -1, -1
+	if owner != nil {
+		uid = int(*owner)
+	}
+	if group != nil {
+		gid = int(*group)
+	}
+	if err := os.Chown(tap.Path, uid, gid); err != nil {
+		if tap.Created {
+			_ = os.Remove(tap.Path)
+		}
+		return nil, fmt.Errorf("failed to set owner of tap device %s: %v", tap.Path, err)
+	}
+	return tap, nil
+}
+
+// RemoveTapDevice removes the node of tap if SetupTapDevice created it and
+// it is still that of the device. A missing node is not an error.
+func RemoveTapDevice(tap *TapDevice) error {
+	if !tap.Created {
+		return nil
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(tap.Path, &st); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR || st.Rdev != tap.Dev {
+		return nil
+	}
+	if err := os.Remove(tap.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove tap device %s: %v", tap.Path, err)
+	}
+	return nil
+}
+
+// parseDevNumber parses a device number as found in sysfs, "major:minor".
+func parseDevNumber(s string) (uint64, error) {
+	major, minor, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("%q is not major:minor", s)
+	}
+	maj, err := strconv.ParseUint(major, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	mnr, err := strconv.ParseUint(minor, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return unix.Mkdev(uint32(maj), uint32(mnr)), nil
+}
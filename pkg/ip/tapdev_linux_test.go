@@ -0,0 +1,84 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("SetupTapDevice", func() {
+	var origSysClassNet, origDevDir string
+
+	BeforeEach(func() {
+		origSysClassNet, origDevDir = sysClassNet, devDir
+		sysClassNet = GinkgoT().TempDir()
+		devDir = GinkgoT().TempDir()
+
+		// The device number of /dev/null stands in for the tap's
+		tapDir := filepath.Join(sysClassNet, "mvtap0", "macvtap", "tap12")
+		Expect(os.MkdirAll(tapDir, 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(tapDir, "dev"), []byte("1:3\n"), 0o644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		sysClassNet, devDir = origSysClassNet, origDevDir
+	})
+
+	It("creates the missing device node with the given owner and group", func() {
+		owner, group := uint32(107), uint32(108)
+		tap, err := SetupTapDevice("mvtap0", &owner, &group)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tap.Path).To(Equal(filepath.Join(devDir, "tap12")))
+		Expect(tap.Created).To(BeTrue())
+
+		var st unix.Stat_t
+		Expect(unix.Stat(tap.Path, &st)).To(Succeed())
+		Expect(st.Mode & unix.S_IFMT).To(Equal(uint32(unix.S_IFCHR)))
+		Expect(st.Rdev).To(Equal(unix.Mkdev(1, 3)))
+		Expect(st.Uid).To(Equal(owner))
+		Expect(st.Gid).To(Equal(group))
+
+		Expect(RemoveTapDevice(tap)).To(Succeed())
+		Expect(tap.Path).NotTo(BeAnExistingFile())
+		Expect(RemoveTapDevice(tap)).To(Succeed())
+	})
+
+	It("leaves the nodes it did not create", func() {
+		path := filepath.Join(devDir, "tap12")
+		Expect(unix.Mknod(path, unix.S_IFCHR|0o600, int(unix.Mkdev(1, 3)))).To(Succeed())
+
+		tap, err := SetupTapDevice("mvtap0", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tap.Created).To(BeFalse())
+		Expect(RemoveTapDevice(tap)).To(Succeed())
+		Expect(path).To(BeAnExistingFile())
+	})
+
+	It("refuses a node that is not the tap device", func() {
+		Expect(os.WriteFile(filepath.Join(devDir, "tap12"), nil, 0o600)).To(Succeed())
+		_, err := SetupTapDevice("mvtap0", nil, nil)
+		Expect(err).To(MatchError(ContainSubstring("is not the tap device")))
+	})
+
+	It("fails for a link without a tap device", func() {
+		_, err := SetupTapDevice("eth0", nil, nil)
+		Expect(err).To(MatchError(ContainSubstring(`failed to find the tap device of "eth0"`)))
+	})
+})
@@ -19,20 +19,41 @@ import (
 
 	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/trace"
 )
 
 func ExecAdd(plugin string, netconf []byte) (types.Result, error) {
-	return invoke.DelegateAdd(context.TODO(), plugin, netconf, nil)
+	span := trace.Start("ipam ADD", "ipam.type", plugin)
+	defer span.End()
+	defer trace.Delegate()()
+	r, err := invoke.DelegateAdd(context.TODO(), plugin, netconf, nil)
+	span.SetError(err)
+	return r, err
 }
 
 func ExecCheck(plugin string, netconf []byte) error {
-	return invoke.DelegateCheck(context.TODO(), plugin, netconf, nil)
+	span := trace.Start("ipam CHECK", "ipam.type", plugin)
+	defer span.End()
+	defer trace.Delegate()()
+	err := invoke.DelegateCheck(context.TODO(), plugin, netconf, nil)
+	span.SetError(err)
+	return err
 }
 
 func ExecDel(plugin string, netconf []byte) error {
-	return invoke.DelegateDel(context.TODO(), plugin, netconf, nil)
+	span := trace.Start("ipam DEL", "ipam.type", plugin)
+	defer span.End()
+	defer trace.Delegate()()
+	err := invoke.DelegateDel(context.TODO(), plugin, netconf, nil)
+	span.SetError(err)
+	return err
 }
 
 func ExecStatus(plugin string, netconf []byte) error {
-	return invoke.DelegateStatus(context.TODO(), plugin, netconf, nil)
+	span := trace.Start("ipam STATUS", "ipam.type", plugin)
+	defer span.End()
+	defer trace.Delegate()()
+	err := invoke.DelegateStatus(context.TODO(), plugin, netconf, nil)
+	span.SetError(err)
+	return err
 }
@@ -15,15 +15,19 @@
 package ipam
 
 import (
+	"errors"
 	"fmt"
-	"net"
 	"os"
+	"time"
 
 	"github.com/vishvananda/netlink"
 
 	current "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/log"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/trace"
 	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 )
 
@@ -31,11 +35,31 @@ const (
 	// Note: use slash as separator so we can have dots in interface name (VLANs)
 	DisableIPv6SysctlTemplate    = "net/ipv6/conf/%s/disable_ipv6"
 	KeepAddrOnDownSysctlTemplate = "net/ipv6/conf/%s/keep_addr_on_down"
+
+	// DefaultConflictProbeTimeout is how long plugins opting in probe
+	// for address conflicts, unless configured otherwise.
+	DefaultConflictProbeTimeout = time.Second
 )
 
+// ConfigureIfaceOptions are the opt-in steps of ConfigureIfaceWithOptions.
+type ConfigureIfaceOptions struct {
+	// ConflictProbeTimeout, if positive, is how long to probe the segment
+	// of the interface for other hosts using each address before adding
+	// it. A conflict fails with the CNI error ErrAddressInUse.
+	ConflictProbeTimeout time.Duration
+}
+
 // ConfigureIface takes the result of IPAM plugin and
 // applies to the ifName interface
 func ConfigureIface(ifName string, res *current.Result) error {
+	return ConfigureIfaceWithOptions(ifName, res, ConfigureIfaceOptions{})
+}
+
+// ConfigureIfaceWithOptions is ConfigureIface with the opt-in steps of opts.
+func ConfigureIfaceWithOptions(ifName string, res *current.Result, opts ConfigureIfaceOptions) error {
+	span := trace.Start("netlink configure-interface", "name", ifName)
+	defer span.End()
+
 	if len(res.Interfaces) == 0 {
 		return fmt.Errorf("no interfaces to configure")
 	}
@@ -45,7 +69,13 @@ func ConfigureIface(ifName string, res *current.Result) error {
 		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
 	}
 
-	var v4gw, v6gw net.IP
+	if opts.ConflictProbeTimeout > 0 {
+		// Probes need the link up
+		if err := netlink.LinkSetUp(link); err != nil {
+			return fmt.Errorf("failed to set %q UP: %v", ifName, err)
+		}
+	}
+
 	hasEnabledIpv6 := false
 	for _, ipc := range res.IPs {
 		if ipc.Interface == nil {
@@ -57,6 +87,16 @@ func ConfigureIface(ifName string, res *current.Result) error {
 			return fmt.Errorf("failed to add IP addr %v to %q: invalid interface index", ipc, ifName)
 		}
 
+		if opts.ConflictProbeTimeout > 0 {
+			if err := ip.ProbeAddressConflict(ifName, ipc.Address.IP, opts.ConflictProbeTimeout); err != nil {
+				var conflict *ip.AddressConflictError
+				if errors.As(err, &conflict) {
+					return cnierrors.Wrapf(cnierrors.ErrAddressInUse, err, "address %s is already in use on the segment of %q", ipc.Address.IP, ifName)
+				}
+				return err
+			}
+		}
+
 		// Make sure sysctl "disable_ipv6" is 0 and "keep_addr_on_down" is 1
 		// if we are about to add an IPv6 address to the interface
 		if !hasEnabledIpv6 && ipc.Address.IP.To4() == nil {
@@ -98,59 +138,20 @@ func ConfigureIface(ifName string, res *current.Result) error {
 			return fmt.Errorf("failed to add IP addr %v to %q: %v", ipc, ifName, err)
 		}
 
-		gwIsV4 := ipc.Gateway.To4() != nil
-		if gwIsV4 && v4gw == nil {
-			v4gw = ipc.Gateway
-		} else if !gwIsV4 && v6gw == nil {
-			v6gw = ipc.Gateway
-		}
 	}
 
 	if err := netlink.LinkSetUp(link); err != nil {
 		return fmt.Errorf("failed to set %q UP: %v", ifName, err)
 	}
 
-	if v6gw != nil {
+	if _, v6gw := gateways(res, &ifName); v6gw != nil {
 		ip.SettleAddresses(ifName, 10)
 	}
 
-	for _, r := range res.Routes {
-		routeIsV4 := r.Dst.IP.To4() != nil
-		gw := r.GW
-		if gw == nil {
-			if routeIsV4 && v4gw != nil {
-				gw = v4gw
-			} else if !routeIsV4 && v6gw != nil {
-				gw = v6gw
-			}
-		}
-		route := netlink.Route{
-			Dst:       &r.Dst,
-			LinkIndex: link.Attrs().Index,
-			Gw:        gw,
-			Priority:  r.Priority,
-		}
-
-		if r.Table != nil {
-			route.Table = *r.Table
-		}
-
-		if r.Scope != nil {
-			route.Scope = netlink.Scope(*r.Scope)
-		}
-
-		if r.Table != nil {
-			route.Table = *r.Table
-		}
-
-		if r.Scope != nil {
-			route.Scope = netlink.Scope(*r.Scope)
-		}
-
-		if err = netlink.RouteAddEcmp(&route); err != nil {
-			return fmt.Errorf("failed to add route '%v via %v dev %v metric %d (Scope: %v, Table: %d)': %v", r.Dst, gw, ifName, r.Priority, route.Scope, route.Table, err)
-		}
+	if err := AddRoutes(ifName, res); err != nil {
+		return err
 	}
 
+	log.Debug("configured interface", "name", ifName, "ips", len(res.IPs), "routes", len(res.Routes))
 	return nil
 }
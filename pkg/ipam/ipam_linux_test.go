@@ -17,6 +17,7 @@ package ipam
 import (
 	"net"
 	"syscall"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -24,6 +25,8 @@ import (
 
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
@@ -306,6 +309,119 @@ var _ = Describe("ConfigureIface", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("marks gateways outside the address prefixes on-link", func() {
+		ipv4, err := types.ParseCIDR("1.2.3.30/32")
+		Expect(err).NotTo(HaveOccurred())
+		result.IPs = []*current.IPConfig{
+			{
+				Interface: current.Int(0),
+				Address:   *ipv4,
+				Gateway:   ipgw4,
+			},
+		}
+		_, defaultv4, err := net.ParseCIDR("0.0.0.0/0")
+		Expect(err).NotTo(HaveOccurred())
+		result.Routes = []*types.Route{{Dst: *defaultv4}}
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := ConfigureIface(LINK_NAME, result)
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlinksafe.LinkByName(LINK_NAME)
+			Expect(err).NotTo(HaveOccurred())
+			routes, err := netlinksafe.RouteList(link, netlink.FAMILY_V4)
+			Expect(err).NotTo(HaveOccurred())
+
+			var found bool
+			for _, r := range routes {
+				if isDefault(r.Dst) && r.Gw.Equal(ipgw4) {
+					Expect(r.Flags & int(netlink.FLAG_ONLINK)).NotTo(BeZero())
+					found = true
+				}
+			}
+			Expect(found).To(BeTrue())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("adds link-scope routes without a gateway", func() {
+		prefix, err := types.ParseCIDR("abcd:1234:ffff:0:1::1/112")
+		Expect(err).NotTo(HaveOccurred())
+		result.IPs = []*current.IPConfig{
+			{
+				Interface: current.Int(0),
+				Address:   *prefix,
+				Gateway:   ipgw6,
+			},
+		}
+		_, delegated, err := net.ParseCIDR("abcd:1234:ffff:0:1::/112")
+		Expect(err).NotTo(HaveOccurred())
+		scopeLink := int(netlink.SCOPE_LINK)
+		result.Routes = []*types.Route{{Dst: *delegated, Scope: &scopeLink}}
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := ConfigureIface(LINK_NAME, result)
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlinksafe.LinkByName(LINK_NAME)
+			Expect(err).NotTo(HaveOccurred())
+			routes, err := netlinksafe.RouteList(link, netlink.FAMILY_V6)
+			Expect(err).NotTo(HaveOccurred())
+
+			var found bool
+			for _, r := range routes {
+				if r.Dst != nil && ipNetEqual(r.Dst, delegated) && r.Protocol != syscall.RTPROT_KERNEL {
+					Expect(r.Gw).To(BeNil())
+					found = true
+				}
+			}
+			Expect(found).To(BeTrue())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("gives later default routes of a family increasing metrics", func() {
+		_, defaultv4, err := net.ParseCIDR("0.0.0.0/0")
+		Expect(err).NotTo(HaveOccurred())
+		gw2 := net.ParseIP("1.2.3.2")
+		result.Routes = []*types.Route{
+			{Dst: *defaultv4},
+			{Dst: *defaultv4, GW: gw2},
+		}
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := ConfigureIface(LINK_NAME, result)
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlinksafe.LinkByName(LINK_NAME)
+			Expect(err).NotTo(HaveOccurred())
+			routes, err := netlinksafe.RouteList(link, netlink.FAMILY_V4)
+			Expect(err).NotTo(HaveOccurred())
+
+			metrics := map[string]int{}
+			for _, r := range routes {
+				if isDefault(r.Dst) {
+					Expect(r.Flags & int(netlink.FLAG_ONLINK)).To(BeZero())
+					metrics[r.Gw.String()] = r.Priority
+				}
+			}
+			Expect(metrics).To(Equal(map[string]int{
+				ipgw4.String(): 0,
+				gw2.String():   1,
+			}))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
 	It("returns an error when the interface index doesn't match the link name", func() {
 		result.IPs[0].Interface = current.Int(1)
 		err := originalNS.Do(func(ns.NetNS) error {
@@ -376,3 +492,77 @@ var _ = Describe("ConfigureIface", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 })
+
+var _ = Describe("ConfigureIfaceWithOptions", func() {
+	var hostNS, containerNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		hostNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		containerNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		// Another host of the segment holds 10.1.2.3
+		err = containerNS.Do(func(ns.NetNS) error {
+			hostVeth, _, err := ip.SetupVeth(LINK_NAME, 1500, "", hostNS)
+			if err != nil {
+				return err
+			}
+			return hostNS.Do(func(ns.NetNS) error {
+				link, err := netlinksafe.LinkByName(hostVeth.Name)
+				if err != nil {
+					return err
+				}
+				if err := netlink.LinkSetUp(link); err != nil {
+					return err
+				}
+				ipn, err := netlink.ParseIPNet("10.1.2.3/24")
+				if err != nil {
+					return err
+				}
+				return netlink.AddrAdd(link, &netlink.Addr{IPNet: ipn})
+			})
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(containerNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(containerNS)).To(Succeed())
+		Expect(hostNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(hostNS)).To(Succeed())
+	})
+
+	configure := func(address string) error {
+		ipn, err := types.ParseCIDR(address)
+		Expect(err).NotTo(HaveOccurred())
+		result := &current.Result{
+			Interfaces: []*current.Interface{{Name: LINK_NAME}},
+			IPs:        []*current.IPConfig{{Interface: current.Int(0), Address: *ipn}},
+		}
+		return containerNS.Do(func(ns.NetNS) error {
+			return ConfigureIfaceWithOptions(LINK_NAME, result, ConfigureIfaceOptions{ConflictProbeTimeout: 300 * time.Millisecond})
+		})
+	}
+
+	It("fails with ErrAddressInUse for an address of another host", func() {
+		err := configure("10.1.2.3/24")
+		Expect(cnierrors.Code(err)).To(Equal(cnierrors.ErrAddressInUse))
+
+		err = containerNS.Do(func(ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(LINK_NAME)
+			if err != nil {
+				return err
+			}
+			addrs, err := netlinksafe.AddrList(link, netlink.FAMILY_V4)
+			Expect(addrs).To(BeEmpty())
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("adds a free address", func() {
+		Expect(configure("10.1.2.4/24")).To(Succeed())
+	})
+})
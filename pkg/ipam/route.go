@@ -0,0 +1,87 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// AddDefaultRoutes adds to res a default route for each address family
+// via the first gateway of its addresses, unless res already routes the
+// family by default via a gateway.
+func AddDefaultRoutes(res *current.Result) {
+	v4gw, v6gw := gateways(res, nil)
+	for _, gw := range []net.IP{v4gw, v6gw} {
+		if gw == nil || hasDefaultRoute(res, isIPv4(gw)) {
+			continue
+		}
+		res.Routes = append(res.Routes, &types.Route{Dst: defaultNet(isIPv4(gw)), GW: gw})
+	}
+}
+
+// gateways returns the first gateway of each family of the addresses of
+// res, of only those of the interface with name ifName if not nil.
+func gateways(res *current.Result, ifName *string) (v4gw, v6gw net.IP) {
+	for _, ipc := range res.IPs {
+		if ipc.Gateway == nil {
+			continue
+		}
+		if ifName != nil && !onInterface(res, ipc, *ifName) {
+			continue
+		}
+		if isIPv4(ipc.Address.IP) && v4gw == nil {
+			v4gw = ipc.Gateway
+		} else if !isIPv4(ipc.Address.IP) && v6gw == nil {
+			v6gw = ipc.Gateway
+		}
+	}
+	return v4gw, v6gw
+}
+
+func onInterface(res *current.Result, ipc *current.IPConfig, ifName string) bool {
+	if ipc.Interface == nil {
+		return false
+	}
+	idx := *ipc.Interface
+	return idx >= 0 && idx < len(res.Interfaces) && res.Interfaces[idx].Name == ifName
+}
+
+func hasDefaultRoute(res *current.Result, v4 bool) bool {
+	for _, r := range res.Routes {
+		if r.GW != nil && isDefault(&r.Dst) && isIPv4(r.Dst.IP) == v4 {
+			return true
+		}
+	}
+	return false
+}
+
+func defaultNet(v4 bool) net.IPNet {
+	if v4 {
+		return net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	}
+	return net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+}
+
+func isDefault(dst *net.IPNet) bool {
+	ones, _ := dst.Mask.Size()
+	return ones == 0
+}
+
+func isIPv4(ip net.IP) bool {
+	return ip.To4() != nil
+}
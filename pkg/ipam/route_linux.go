@@ -0,0 +1,98 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+)
+
+// AddRoutes adds the routes of res to the ifName interface, once its
+// addresses are configured.
+//
+// Routes that name no gateway go via the first gateway of the addresses
+// of their family on the interface, unless their scope is link: those are
+// on-link, like the route to an IPv6 prefix delegated to the interface. A
+// gateway outside the prefixes of those addresses, such as that of a /32
+// address, is marked on-link, as the kernel otherwise rejects it as
+// unreachable. A family's default routes after the first that set no metric
+// get the next metric up: the kernel would otherwise shadow them for IPv4
+// but merge them into a multipath route for IPv6.
+func AddRoutes(ifName string, res *current.Result) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	v4gw, v6gw := gateways(res, &ifName)
+	lastMetric := map[bool]int{}
+	for _, r := range res.Routes {
+		routeIsV4 := isIPv4(r.Dst.IP)
+		gw := r.GW
+		if gw == nil && (r.Scope == nil || netlink.Scope(*r.Scope) != netlink.SCOPE_LINK) {
+			if routeIsV4 {
+				gw = v4gw
+			} else {
+				gw = v6gw
+			}
+		}
+		route := netlink.Route{
+			Dst:       &r.Dst,
+			LinkIndex: link.Attrs().Index,
+			Gw:        gw,
+			Priority:  r.Priority,
+		}
+
+		if r.Table != nil {
+			route.Table = *r.Table
+		}
+
+		if r.Scope != nil {
+			route.Scope = netlink.Scope(*r.Scope)
+		}
+
+		if gw != nil && !gw.IsLinkLocalUnicast() && !onLink(res, ifName, gw) {
+			route.Flags = int(netlink.FLAG_ONLINK)
+		}
+
+		if isDefault(&r.Dst) {
+			if last, ok := lastMetric[routeIsV4]; ok && route.Priority == 0 {
+				route.Priority = last + 1
+			}
+			lastMetric[routeIsV4] = route.Priority
+		}
+
+		if err = netlink.RouteAddEcmp(&route); err != nil {
+			return fmt.Errorf("failed to add route '%v via %v dev %v metric %d (Scope: %v, Table: %d)': %v", r.Dst, gw, ifName, route.Priority, route.Scope, route.Table, err)
+		}
+	}
+	return nil
+}
+
+// onLink returns whether gw lies within the prefix of an address of res
+// on the ifName interface.
+func onLink(res *current.Result, ifName string, gw net.IP) bool {
+	for _, ipc := range res.IPs {
+		if onInterface(res, ipc, ifName) && ipc.Address.Contains(gw) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+func mustParseCIDR(s string) net.IPNet {
+	ipn, err := types.ParseCIDR(s)
+	Expect(err).NotTo(HaveOccurred())
+	return *ipn
+}
+
+var _ = Describe("AddDefaultRoutes", func() {
+	It("adds a default route per family via its first gateway", func() {
+		res := &current.Result{
+			IPs: []*current.IPConfig{
+				{Address: mustParseCIDR("10.0.0.2/24"), Gateway: net.ParseIP("10.0.0.1")},
+				{Address: mustParseCIDR("10.1.0.2/24"), Gateway: net.ParseIP("10.1.0.1")},
+				{Address: mustParseCIDR("2001:db8::2/64"), Gateway: net.ParseIP("2001:db8::1")},
+			},
+		}
+		AddDefaultRoutes(res)
+		Expect(res.Routes).To(HaveLen(2))
+		Expect(res.Routes[0].Dst.String()).To(Equal("0.0.0.0/0"))
+		Expect(res.Routes[0].GW.String()).To(Equal("10.0.0.1"))
+		Expect(res.Routes[1].Dst.String()).To(Equal("::/0"))
+		Expect(res.Routes[1].GW.String()).To(Equal("2001:db8::1"))
+	})
+
+	It("keeps existing default routes of a family", func() {
+		res := &current.Result{
+			IPs: []*current.IPConfig{
+				{Address: mustParseCIDR("10.0.0.2/24"), Gateway: net.ParseIP("10.0.0.1")},
+				{Address: mustParseCIDR("2001:db8::2/64"), Gateway: net.ParseIP("2001:db8::1")},
+			},
+			Routes: []*types.Route{
+				{Dst: mustParseCIDR("0.0.0.0/0"), GW: net.ParseIP("10.0.0.254")},
+			},
+		}
+		AddDefaultRoutes(res)
+		Expect(res.Routes).To(HaveLen(2))
+		Expect(res.Routes[0].GW.String()).To(Equal("10.0.0.254"))
+		Expect(res.Routes[1].Dst.String()).To(Equal("::/0"))
+	})
+
+	It("adds no route for a family without a gateway", func() {
+		res := &current.Result{
+			IPs: []*current.IPConfig{
+				{Address: mustParseCIDR("10.0.0.2/24")},
+			},
+		}
+		AddDefaultRoutes(res)
+		Expect(res.Routes).To(BeEmpty())
+	})
+})
@@ -0,0 +1,380 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package journal implements an optional write-ahead journal of the
+// mutations made by a plugin invocation.
+//
+// Each invocation writes one file, recording every step before it is taken
+// and again once it has completed. The file is removed when the invocation
+// returns with all its steps completed, so any file left behind belongs to
+// an invocation that crashed, was killed, or failed half-way, and lists the
+// resources that may be half-created. Pending reads them back, for an
+// operator to inspect, and the GC command of a wrapped plugin reconciles
+// those of invocations that are gone.
+//
+// Journaling is enabled by setting CNI_JOURNAL_DIR, which should point to a
+// tmpfs such as /run/cni/journal, in the plugin's environment.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/gc"
+)
+
+// EnvDir is the environment variable that enables journaling.
+const EnvDir = "CNI_JOURNAL_DIR"
+
+const fileSuffix = ".journal"
+
+// Invocation identifies the plugin invocation a journal belongs to. It is
+// the first record of every journal file.
+type Invocation struct {
+	Plugin      string    `json:"plugin"`
+	Command     string    `json:"command"`
+	ContainerID string    `json:"containerID"`
+	Netns       string    `json:"netns,omitempty"`
+	IfName      string    `json:"ifName,omitempty"`
+	PID         int       `json:"pid"`
+	Started     time.Time `json:"started"`
+}
+
+// Step is a single mutation, e.g. Kind "veth" and Name "veth1234abcd".
+type Step struct {
+	Seq  int    `json:"seq"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+type record struct {
+	Invocation *Invocation `json:"invocation,omitempty"`
+	Begin      *Step       `json:"begin,omitempty"`
+	Done       *int        `json:"done,omitempty"`
+	Aborted    *int        `json:"aborted,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Journal records the steps of one invocation. A nil *Journal is valid and
+// records nothing.
+type Journal struct {
+	mu      sync.Mutex
+	f       *os.File
+	path    string
+	seq     int
+	pending map[int]bool
+}
+
+// Open creates the journal file for an invocation in dir.
+func Open(dir string, inv Invocation) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory %s: %v", dir, err)
+	}
+	if inv.PID == 0 {
+		inv.PID = os.Getpid()
+	}
+	if inv.Started.IsZero() {
+		inv.Started = time.Now()
+	}
+
+	name := fmt.Sprintf("%s-%s-%d-%d%s", inv.Plugin, strings.ToLower(inv.Command), inv.PID, inv.Started.UnixNano(), fileSuffix)
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal: %v", err)
+	}
+
+	j := &Journal{f: f, path: path, pending: map[int]bool{}}
+	if err := j.write(record{Invocation: &inv}); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return j, nil
+}
+
+// write appends r to the journal and syncs it, so that the record survives
+// the process being killed right after.
+func (j *Journal) write(r record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal %s: %v", j.path, err)
+	}
+	return j.f.Sync()
+}
+
+// Begin records that the step kind/name is about to be taken, and returns
+// the function to call with its outcome. A step that failed is recorded as
+// aborted rather than done, so steps should be single requests that the
+// kernel either makes or rejects as a whole.
+func (j *Journal) Begin(kind, name string) func(err error) {
+	if j == nil {
+		return func(error) {}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	seq := j.seq
+	// A step that cannot be journaled is still taken; the journal is only
+	// a forensic aid.
+	_ = j.write(record{Begin: &Step{Seq: seq, Kind: kind, Name: name}})
+	j.pending[seq] = true
+
+	return func(err error) {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if !j.pending[seq] {
+			return
+		}
+		if err != nil {
+			_ = j.write(record{Aborted: &seq, Error: err.Error()})
+		} else {
+			_ = j.write(record{Done: &seq})
+		}
+		delete(j.pending, seq)
+	}
+}
+
+// Close closes the journal, removing its file if every step completed.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	if len(j.pending) == 0 {
+		return os.Remove(j.path)
+	}
+	return nil
+}
+
+var (
+	currentMu sync.Mutex
+	current   *Journal
+)
+
+// Begin records a step in the journal of the running invocation, if any.
+// It is meant for library code, such as pkg/ip, that has no handle on the
+// invocation.
+func Begin(kind, name string) func(err error) {
+	currentMu.Lock()
+	j := current
+	currentMu.Unlock()
+	return j.Begin(kind, name)
+}
+
+func setCurrent(j *Journal) {
+	currentMu.Lock()
+	current = j
+	currentMu.Unlock()
+}
+
+// Reconciler undoes what the pending steps of entry may have left behind.
+type Reconciler func(entry Entry) error
+
+// Wrap returns funcs with each command journaled, if CNI_JOURNAL_DIR is
+// set. plugin names the plugin in the journal. GC also reconciles the
+// journals left by the plugin with reconcile, see Reconcile.
+func Wrap(plugin string, reconcile Reconciler, funcs skel.CNIFuncs) skel.CNIFuncs {
+	dir := os.Getenv(EnvDir)
+	if dir == "" {
+		return funcs
+	}
+	return skel.CNIFuncs{
+		Add:    wrapCmd(dir, plugin, "ADD", funcs.Add),
+		Del:    wrapCmd(dir, plugin, "DEL", funcs.Del),
+		Check:  wrapCmd(dir, plugin, "CHECK", funcs.Check),
+		GC:     wrapCmd(dir, plugin, "GC", wrapGC(dir, plugin, reconcile, funcs.GC)),
+		Status: wrapCmd(dir, plugin, "STATUS", funcs.Status),
+	}
+}
+
+func wrapGC(dir, plugin string, reconcile Reconciler, cmd func(*skel.CmdArgs) error) func(*skel.CmdArgs) error {
+	if reconcile == nil {
+		return cmd
+	}
+	return func(args *skel.CmdArgs) error {
+		valid, err := gc.ParseAttachments(args.StdinData)
+		if err != nil {
+			return err
+		}
+		if err := Reconcile(dir, plugin, valid, reconcile); err != nil {
+			return err
+		}
+		if cmd == nil {
+			return nil
+		}
+		return cmd(args)
+	}
+}
+
+func wrapCmd(dir, plugin, command string, cmd func(*skel.CmdArgs) error) func(*skel.CmdArgs) error {
+	if cmd == nil {
+		return nil
+	}
+	return func(args *skel.CmdArgs) error {
+		j, err := Open(dir, Invocation{
+			Plugin:      plugin,
+			Command:     command,
+			ContainerID: args.ContainerID,
+			Netns:       args.Netns,
+			IfName:      args.IfName,
+		})
+		if err != nil {
+			// Journaling is best-effort; never fail the command over it.
+			fmt.Fprintf(os.Stderr, "%s: %v\n", plugin, err)
+			return cmd(args)
+		}
+		setCurrent(j)
+		defer func() {
+			setCurrent(nil)
+			j.Close()
+		}()
+		return cmd(args)
+	}
+}
+
+// Entry is a journal left behind by an invocation that did not complete
+// all its steps.
+type Entry struct {
+	Path       string
+	Invocation Invocation
+	// Pending are the steps that were begun but never completed, in order.
+	Pending []Step
+}
+
+// Pending returns the journals in dir with incomplete steps. A missing dir
+// is not an error.
+func Pending(dir string) ([]Entry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for _, path := range paths {
+		entry, err := readJournal(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(entry.Pending) > 0 {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+func readJournal(path string) (*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entry := &Entry{Path: path}
+	done := map[int]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// The last record may be torn if the process died mid-write.
+			break
+		}
+		switch {
+		case r.Invocation != nil:
+			entry.Invocation = *r.Invocation
+		case r.Begin != nil:
+			entry.Pending = append(entry.Pending, *r.Begin)
+		case r.Done != nil:
+			done[*r.Done] = true
+		case r.Aborted != nil:
+			// The step was rejected, nothing was left behind
+			done[*r.Aborted] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal %s: %v", path, err)
+	}
+
+	pending := entry.Pending[:0]
+	for _, step := range entry.Pending {
+		if !done[step.Seq] {
+			pending = append(pending, step)
+		}
+	}
+	entry.Pending = pending
+	return entry, nil
+}
+
+// Reconcile calls reconcile on the journals in dir left by plugin, for the
+// attachments that are no longer valid, and removes them once reconciled.
+// Journals of invocations that are still running are skipped.
+func Reconcile(dir, plugin string, valid *gc.Attachments, reconcile Reconciler) error {
+	entries, err := Pending(dir)
+	if err != nil {
+		return err
+	}
+
+	// Keep on going, but return the last failure.
+	var errReturn error
+	for _, entry := range entries {
+		inv := entry.Invocation
+		if inv.Plugin != plugin || running(inv.PID) || valid.Valid(inv.ContainerID, inv.IfName) {
+			continue
+		}
+		if err := reconcile(entry); err != nil {
+			errReturn = fmt.Errorf("failed to reconcile journal %s: %v", entry.Path, err)
+			continue
+		}
+		if err := entry.Remove(); err != nil {
+			errReturn = err
+		}
+	}
+	return errReturn
+}
+
+// running tells whether the process pid is still running.
+func running(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
+
+// Remove deletes a journal once its pending steps have been reconciled.
+func (e *Entry) Remove() error {
+	if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/journal"
+)
+
+var _ = Describe("journal", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	inv := journal.Invocation{
+		Plugin:      "test",
+		Command:     "ADD",
+		ContainerID: "c1",
+		IfName:      "eth0",
+	}
+
+	It("removes the journal if every step completed", func() {
+		j, err := journal.Open(dir, inv)
+		Expect(err).NotTo(HaveOccurred())
+		j.Begin("veth", "eth0/veth1")(nil)
+		j.Begin("veth", "eth1/veth2")(nil)
+		Expect(j.Close()).To(Succeed())
+
+		files, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("reports incomplete steps", func() {
+		j, err := journal.Open(dir, inv)
+		Expect(err).NotTo(HaveOccurred())
+		j.Begin("veth", "eth0/veth1")(nil)
+		j.Begin("link-delete", "eth1")
+		Expect(j.Close()).To(Succeed())
+
+		entries, err := journal.Pending(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Invocation.ContainerID).To(Equal("c1"))
+		Expect(entries[0].Invocation.PID).To(Equal(os.Getpid()))
+		Expect(entries[0].Pending).To(Equal([]journal.Step{{Seq: 2, Kind: "link-delete", Name: "eth1"}}))
+
+		Expect(entries[0].Remove()).To(Succeed())
+		entries, err = journal.Pending(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("does not report failed steps", func() {
+		j, err := journal.Open(dir, inv)
+		Expect(err).NotTo(HaveOccurred())
+		j.Begin("veth", "veth1")(errors.New("file exists"))
+		j.Begin("veth", "veth2")(nil)
+		Expect(j.Close()).To(Succeed())
+
+		files, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("tolerates a torn last record", func() {
+		j, err := journal.Open(dir, inv)
+		Expect(err).NotTo(HaveOccurred())
+		j.Begin("veth", "eth0/veth1")
+		Expect(j.Close()).To(Succeed())
+
+		paths, err := filepath.Glob(filepath.Join(dir, "*"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(paths).To(HaveLen(1))
+		f, err := os.OpenFile(paths[0], os.O_APPEND|os.O_WRONLY, 0)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteString(`{"done":`)
+		Expect(err).NotTo(HaveOccurred())
+		f.Close()
+
+		entries, err := journal.Pending(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Pending).To(HaveLen(1))
+	})
+
+	It("journals wrapped commands only when enabled", func() {
+		var stepErr error
+		funcs := skel.CNIFuncs{
+			Add: func(_ *skel.CmdArgs) error {
+				journal.Begin("veth", "eth0/veth1")
+				return stepErr
+			},
+		}
+		args := &skel.CmdArgs{ContainerID: "c1", IfName: "eth0"}
+
+		Expect(journal.Wrap("test", nil, funcs).Add(args)).To(Succeed())
+		entries, err := journal.Pending(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+
+		GinkgoT().Setenv(journal.EnvDir, dir)
+		stepErr = errors.New("failed")
+		wrapped := journal.Wrap("test", nil, funcs)
+		Expect(wrapped.Del).To(BeNil())
+		Expect(wrapped.Add(args)).To(MatchError("failed"))
+
+		entries, err = journal.Pending(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Invocation.Plugin).To(Equal("test"))
+		Expect(entries[0].Invocation.Command).To(Equal("ADD"))
+		Expect(entries[0].Pending[0].Name).To(Equal("eth0/veth1"))
+
+		// Begin is a no-op outside of a wrapped command
+		journal.Begin("veth", "eth0/veth2")(nil)
+	})
+
+	Describe("reconciling", func() {
+		var reconciled []journal.Step
+
+		reconcile := func(entry journal.Entry) error {
+			reconciled = append(reconciled, entry.Pending...)
+			return nil
+		}
+
+		// leave writes the journal of an invocation of pid with a pending step
+		leave := func(pid int, containerID, name string) {
+			j, err := journal.Open(dir, journal.Invocation{
+				Plugin:      "test",
+				Command:     "ADD",
+				ContainerID: containerID,
+				IfName:      "eth0",
+				PID:         pid,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			j.Begin("veth", name)
+			Expect(j.Close()).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			reconciled = nil
+		})
+
+		It("reconciles the journals of gone invocations of invalid attachments", func() {
+			// Beyond the default pid_max, so never running
+			const gone = 1 << 22
+			leave(gone, "c1", "veth1")
+			leave(gone, "c2", "veth2")
+			leave(os.Getpid(), "c3", "veth3")
+
+			valid := gc.NewAttachments([]types.GCAttachment{{ContainerID: "c2", IfName: "eth0"}})
+			Expect(journal.Reconcile(dir, "test", valid, reconcile)).To(Succeed())
+			Expect(reconciled).To(Equal([]journal.Step{{Seq: 1, Kind: "veth", Name: "veth1"}}))
+
+			entries, err := journal.Pending(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+		})
+
+		It("reconciles on GC of wrapped plugins", func() {
+			const gone = 1 << 22
+			leave(gone, "c1", "veth1")
+
+			GinkgoT().Setenv(journal.EnvDir, dir)
+			wrapped := journal.Wrap("test", reconcile, skel.CNIFuncs{})
+			err := wrapped.GC(&skel.CmdArgs{StdinData: []byte(`{"cniVersion":"1.1.0","name":"test","type":"test"}`)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reconciled).To(HaveLen(1))
+
+			entries, err := journal.Pending(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+	})
+})
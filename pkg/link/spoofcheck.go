@@ -17,6 +17,7 @@ package link
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"time"
 
@@ -27,6 +28,9 @@ import (
 const (
 	natTableName            = "nat"
 	preRoutingBaseChainName = "PREROUTING"
+
+	payloadProtocolARP     = "arp"
+	payloadFieldARPSAddrIP = "saddr ip"
 )
 
 type NftConfigurer interface {
@@ -40,6 +44,9 @@ type SpoofChecker struct {
 	refID      string
 	configurer NftConfigurer
 	rulestore  *nft.Config
+
+	ipCheck bool
+	ips     []net.IP
 }
 
 type defaultNftConfigurer struct{}
@@ -63,7 +70,17 @@ func NewSpoofChecker(iface, macAddress, refID string) *SpoofChecker {
 }
 
 func NewSpoofCheckerWithConfigurer(iface, macAddress, refID string, configurer NftConfigurer) *SpoofChecker {
-	return &SpoofChecker{iface, macAddress, refID, configurer, nil}
+	return &SpoofChecker{iface: iface, macAddress: macAddress, refID: refID, configurer: configurer}
+}
+
+// WithIPCheck makes the checker also restrict the IPv4, IPv6 and ARP traffic
+// from the interface to the source addresses in ips. IPv6 link-local and
+// unspecified sources remain allowed for neighbor discovery and DAD, as do
+// ARP probes. Teardown only needs the option, not the addresses.
+func (sc *SpoofChecker) WithIPCheck(ips []net.IP) *SpoofChecker {
+	sc.ipCheck = true
+	sc.ips = ips
+	return sc
 }
 
 // Setup applies nftables configuration to restrict traffic
@@ -91,6 +108,10 @@ func (sc *SpoofChecker) Setup() error {
 	baseConfig.AddChain(ifaceChain)
 	macChain := sc.macChain(ifaceChain.Name)
 	baseConfig.AddChain(macChain)
+	ipChain := sc.ipChain(ifaceChain.Name)
+	if sc.ipCheck {
+		baseConfig.AddChain(ipChain)
+	}
 
 	if _, err := sc.configurer.Apply(baseConfig); err != nil {
 		return fmt.Errorf("failed to setup spoof-check: %v", err)
@@ -100,11 +121,13 @@ func (sc *SpoofChecker) Setup() error {
 
 	rulesConfig.FlushChain(ifaceChain)
 	rulesConfig.FlushChain(macChain)
+	if sc.ipCheck {
+		rulesConfig.FlushChain(ipChain)
+	}
 
-	rulesConfig.AddRule(sc.matchIfaceJumpToChainRule(preRoutingBaseChainName, ifaceChain.Name))
-	rulesConfig.AddRule(sc.jumpToChainRule(ifaceChain.Name, macChain.Name))
-	rulesConfig.AddRule(sc.matchMacRule(macChain.Name))
-	rulesConfig.AddRule(sc.dropRule(macChain.Name))
+	for _, rule := range sc.rules() {
+		rulesConfig.AddRule(rule)
+	}
 
 	rulestore, err := sc.configurer.Apply(rulesConfig)
 	if err != nil {
@@ -156,6 +179,9 @@ func (sc *SpoofChecker) Teardown() error {
 	regularChainsConfig := nft.NewConfig()
 	regularChainsConfig.DeleteChain(ifaceChain)
 	regularChainsConfig.DeleteChain(sc.macChain(ifaceChain.Name))
+	if sc.ipCheck {
+		regularChainsConfig.DeleteChain(sc.ipChain(ifaceChain.Name))
+	}
 
 	var regularChainsErr error
 	if _, err := sc.configurer.Apply(regularChainsConfig); err != nil {
@@ -168,6 +194,117 @@ func (sc *SpoofChecker) Teardown() error {
 	return nil
 }
 
+// Check verifies that the rules installed by Setup are in place.
+func (sc *SpoofChecker) Check() error {
+	rules := sc.rules()
+	byChain := map[string]*nft.Config{}
+	for _, rule := range rules {
+		if _, ok := byChain[rule.Chain]; ok {
+			continue
+		}
+		config, err := sc.configurer.Read("chain", "bridge", natTableName, rule.Chain)
+		if err != nil {
+			return fmt.Errorf("failed to read spoof-check chain %s: %v", rule.Chain, err)
+		}
+		byChain[rule.Chain] = config
+	}
+
+	for _, rule := range rules {
+		if len(byChain[rule.Chain].LookupRule(rule)) == 0 {
+			return fmt.Errorf("spoof-check rule missing in chain %s", rule.Chain)
+		}
+	}
+	return nil
+}
+
+// rules returns the rules of the spoof-check, in order.
+func (sc *SpoofChecker) rules() []*schema.Rule {
+	ifaceChain := sc.ifaceChain()
+	macChain := sc.macChain(ifaceChain.Name)
+
+	rules := []*schema.Rule{
+		sc.matchIfaceJumpToChainRule(preRoutingBaseChainName, ifaceChain.Name),
+		sc.jumpToChainRule(ifaceChain.Name, macChain.Name),
+	}
+	if sc.ipCheck {
+		rules = append(rules, sc.jumpToChainRule(ifaceChain.Name, sc.ipChain(ifaceChain.Name).Name))
+	}
+	rules = append(rules,
+		sc.matchMacRule(macChain.Name),
+		sc.dropRule(macChain.Name),
+	)
+	if sc.ipCheck {
+		rules = append(rules, sc.ipRules(sc.ipChain(ifaceChain.Name).Name)...)
+	}
+	return rules
+}
+
+// ipRules returns the rules that let through the traffic sourced from the
+// allowed addresses, and drop any other IPv4, IPv6 and ARP traffic.
+func (sc *SpoofChecker) ipRules(chain string) []*schema.Rule {
+	var rules []*schema.Rule
+	for _, ip := range sc.ips {
+		addr := ip.String()
+		if ip.To4() != nil {
+			rules = append(rules,
+				sc.matchPayloadReturnRule(chain, schema.PayloadProtocolIP4, schema.PayloadFieldIPSAddr, schema.Expression{String: &addr}),
+				sc.matchPayloadReturnRule(chain, payloadProtocolARP, payloadFieldARPSAddrIP, schema.Expression{String: &addr}),
+			)
+		} else {
+			rules = append(rules,
+				sc.matchPayloadReturnRule(chain, schema.PayloadProtocolIP6, schema.PayloadFieldIPSAddr, schema.Expression{String: &addr}),
+			)
+		}
+	}
+
+	unspecified4, unspecified6 := net.IPv4zero.String(), net.IPv6unspecified.String()
+	rules = append(rules,
+		sc.matchPayloadReturnRule(chain, payloadProtocolARP, payloadFieldARPSAddrIP, schema.Expression{String: &unspecified4}),
+		sc.matchPayloadReturnRule(chain, schema.PayloadProtocolIP6, schema.PayloadFieldIPSAddr,
+			schema.Expression{RowData: []byte(`{"prefix":{"addr":"fe80::","len":10}}`)}),
+		sc.matchPayloadReturnRule(chain, schema.PayloadProtocolIP6, schema.PayloadFieldIPSAddr, schema.Expression{String: &unspecified6}),
+	)
+
+	for _, etherType := range []string{"ip", "ip6", "arp"} {
+		etherType := etherType
+		rules = append(rules, &schema.Rule{
+			Family: schema.FamilyBridge,
+			Table:  natTableName,
+			Chain:  chain,
+			Expr: []schema.Statement{
+				{Match: &schema.Match{
+					Op: schema.OperEQ,
+					Left: schema.Expression{Payload: &schema.Payload{
+						Protocol: schema.PayloadProtocolEther,
+						Field:    schema.PayloadFieldEtherType,
+					}},
+					Right: schema.Expression{String: &etherType},
+				}},
+				{Verdict: schema.Verdict{SimpleVerdict: schema.SimpleVerdict{Drop: true}}},
+			},
+			Comment: ruleComment(sc.refID),
+		})
+	}
+	return rules
+}
+
+func (sc *SpoofChecker) matchPayloadReturnRule(chain, protocol, field string, right schema.Expression) *schema.Rule {
+	return &schema.Rule{
+		Family: schema.FamilyBridge,
+		Table:  natTableName,
+		Chain:  chain,
+		Expr: []schema.Statement{
+			{Match: &schema.Match{
+				Op:    schema.OperEQ,
+				Left:  schema.Expression{Payload: &schema.Payload{Protocol: protocol, Field: field}},
+				Right: right,
+			}},
+			{Verdict: schema.Verdict{SimpleVerdict: schema.SimpleVerdict{Return: true}}},
+		},
+		Comment: ruleComment(sc.refID),
+	}
+}
+
 func (sc *SpoofChecker) matchIfaceJumpToChainRule(chain, toChain string) *schema.Rule {
 	return &schema.Rule{
 		Family: schema.FamilyBridge,
@@ -260,6 +397,14 @@ func (sc *SpoofChecker) macChain(ifaceChainName string) *schema.Chain {
 	}
 }
 
+func (sc *SpoofChecker) ipChain(ifaceChainName string) *schema.Chain {
+	return &schema.Chain{
+		Family: schema.FamilyBridge,
+		Table:  natTableName,
+		Name:   ifaceChainName + "-ip",
+	}
+}
+
 func ruleComment(id string) string {
 	const refIDPrefix = "macspoofchk-"
 	return refIDPrefix + id
@@ -17,6 +17,7 @@ package link_test
 import (
 	"errors"
 	"fmt"
+	"net"
 
 	"github.com/networkplumbing/go-nft/nft"
 	. "github.com/onsi/ginkgo/v2"
@@ -115,6 +116,46 @@ var _ = Describe("spoofcheck", func() {
 		})
 	})
 
+	Context("ip check", func() {
+		ips := []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("2001:db8::2")}
+
+		It("sets up, checks and tears down the ip chain", func() {
+			c := configurerStub{}
+			sc := link.NewSpoofCheckerWithConfigurer(iface, mac, id, &c).WithIPCheck(ips)
+			Expect(sc.Setup()).To(Succeed())
+
+			baseJSON, err := c.applyConfig[0].ToJSON()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(baseJSON)).To(ContainSubstring(`"name":"cni-br-iface-container99-net1-ip"`))
+
+			rulesJSON, err := c.applyConfig[1].ToJSON()
+			Expect(err).NotTo(HaveOccurred())
+			for _, expr := range []string{
+				`{"jump":{"target":"cni-br-iface-container99-net1-ip"}}`,
+				`"left":{"payload":{"protocol":"ip","field":"saddr"}},"right":"10.0.0.2"`,
+				`"left":{"payload":{"protocol":"arp","field":"saddr ip"}},"right":"10.0.0.2"`,
+				`"left":{"payload":{"protocol":"arp","field":"saddr ip"}},"right":"0.0.0.0"`,
+				`"left":{"payload":{"protocol":"ip6","field":"saddr"}},"right":"2001:db8::2"`,
+				`"left":{"payload":{"protocol":"ip6","field":"saddr"}},"right":{"prefix":{"addr":"fe80::","len":10}}`,
+				`"left":{"payload":{"protocol":"ether","field":"type"}},"right":"arp"`,
+			} {
+				Expect(string(rulesJSON)).To(ContainSubstring(expr))
+			}
+
+			c.readConfig = c.applyConfig[1]
+			Expect(sc.Check()).To(Succeed())
+			other := link.NewSpoofCheckerWithConfigurer(iface, mac, id, &c).WithIPCheck(ips[:1])
+			Expect(other.Check()).To(Succeed())
+			other = link.NewSpoofCheckerWithConfigurer(iface, mac, id, &c).WithIPCheck([]net.IP{net.ParseIP("10.0.0.3")})
+			Expect(other.Check()).To(MatchError("spoof-check rule missing in chain cni-br-iface-container99-net1-ip"))
+
+			Expect(link.NewSpoofCheckerWithConfigurer("", "", id, &c).WithIPCheck(nil).Teardown()).To(Succeed())
+			deleteJSON, err := c.applyConfig[len(c.applyConfig)-1].ToJSON()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(deleteJSON)).To(ContainSubstring(`{"delete":{"chain":{"family":"bridge","table":"nat","name":"cni-br-iface-container99-net1-ip"}}}`))
+		})
+	})
+
 	Context("echo", func() {
 		It("succeeds, no read called", func() {
 			c := configurerStub{}
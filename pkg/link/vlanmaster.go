@@ -0,0 +1,222 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package link
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/alexflint/go-filemutex"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/statestore"
+)
+
+// VlanMaster is an 802.1q subinterface of a host interface, serving as the
+// master of macvlan or ipvlan links. It is created for the first attachment
+// using it and removed with the last.
+type VlanMaster struct {
+	Parent string `json:"parent"`
+	ID     int    `json:"id"`
+}
+
+// vlanMasterAlias marks the subinterfaces created by AcquireVlanMaster, the
+// only ones ReleaseVlanMaster removes.
+const vlanMasterAlias = "cni-vlan-master"
+
+// The attachments using a subinterface are recorded under
+// <vlanMasterDir>/<name>, whose lock is <vlanMasterDir>/<name>.lock.
+var vlanMasterDir = "/run/cni/vlan-master"
+
+// Name returns the name of the subinterface, <parent>.<id>.
+func (v *VlanMaster) Name() string {
+	return fmt.Sprintf("%s.%d", v.Parent, v.ID)
+}
+
+// Validate checks the parent and VLAN ID of v.
+func (v *VlanMaster) Validate() error {
+	if v.Parent == "" {
+		return fmt.Errorf("masterVlan parent is required")
+	}
+	if v.ID < 1 || v.ID > 4094 {
+		return fmt.Errorf("invalid masterVlan id %d, must be [1, 4094]", v.ID)
+	}
+	// IFNAMSIZ counts the terminating NUL
+	if len(v.Name()) >= unix.IFNAMSIZ {
+		return fmt.Errorf("masterVlan name %q is longer than %d characters", v.Name(), unix.IFNAMSIZ-1)
+	}
+	return nil
+}
+
+func (v *VlanMaster) store(network string) *statestore.Store {
+	return statestore.New(filepath.Join(vlanMasterDir, v.Name()), network)
+}
+
+// lock serializes the changes to the subinterface and its users across the
+// plugin processes.
+func (v *VlanMaster) lock() (*filemutex.FileMutex, error) {
+	if err := os.MkdirAll(vlanMasterDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", vlanMasterDir, err)
+	}
+	m, err := filemutex.New(filepath.Join(vlanMasterDir, v.Name()+".lock"))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Lock(); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// AcquireVlanMaster makes sure the subinterface v exists, creating it if
+// needed, and calls attach with its name to create the link of the
+// attachment of containerID and ifName on it. The attachment is then
+// recorded as a user of the subinterface, until ReleaseVlanMaster.
+// The subinterface cannot be removed by a concurrent ReleaseVlanMaster
+// while attach runs.
+func AcquireVlanMaster(v *VlanMaster, network, containerID, ifName string, attach func(master string) error) error {
+	m, err := v.lock()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	created, err := ensureVlanMaster(v)
+	if err != nil {
+		return err
+	}
+	if err := attach(v.Name()); err != nil {
+		if created != nil {
+			_ = netlink.LinkDel(created)
+		}
+		return err
+	}
+
+	key := statestore.Key{ContainerID: containerID, IfName: ifName}
+	return v.store(network).Save(key, v)
+}
+
+// ReleaseVlanMaster drops the attachment of containerID and ifName from the
+// users of the subinterface v, and removes the subinterface if it was the
+// last one and AcquireVlanMaster created it.
+func ReleaseVlanMaster(v *VlanMaster, network, containerID, ifName string) error {
+	m, err := v.lock()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	store := v.store(network)
+	if err := store.Delete(statestore.Key{ContainerID: containerID, IfName: ifName}); err != nil {
+		return err
+	}
+	return removeUnusedVlanMaster(v, store)
+}
+
+// GCVlanMaster drops the attachments of network that are not valid from the
+// users of the subinterface v, as their DEL was lost, and removes the
+// subinterface if no users are left and AcquireVlanMaster created it.
+func GCVlanMaster(v *VlanMaster, network string, valid *gc.Attachments) error {
+	m, err := v.lock()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	store := v.store(network)
+	if err := store.GC(valid, nil); err != nil {
+		return err
+	}
+	return removeUnusedVlanMaster(v, store)
+}
+
+// removeUnusedVlanMaster removes the subinterface v if store, which lists
+// the users of all networks, has none and AcquireVlanMaster created it. The
+// lock of v must be held.
+func removeUnusedVlanMaster(v *VlanMaster, store *statestore.Store) error {
+	users, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return nil
+	}
+
+	link, err := netlinksafe.LinkByName(v.Name())
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+	if link.Attrs().Alias != vlanMasterAlias {
+		// Provisioned by the operator
+		return nil
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete masterVlan %q: %v", v.Name(), err)
+	}
+	return nil
+}
+
+// ensureVlanMaster creates the subinterface v if it does not exist, and
+// returns it if it did.
+func ensureVlanMaster(v *VlanMaster) (netlink.Link, error) {
+	parent, err := netlinksafe.LinkByName(v.Parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup masterVlan parent %q: %v", v.Parent, err)
+	}
+
+	link, err := netlinksafe.LinkByName(v.Name())
+	if err == nil {
+		vlan, ok := link.(*netlink.Vlan)
+		if !ok || vlan.VlanId != v.ID || vlan.ParentIndex != parent.Attrs().Index {
+			return nil, fmt.Errorf("%q exists and is not VLAN %d of %q", v.Name(), v.ID, v.Parent)
+		}
+		if link.Attrs().Flags&net.FlagUp == 0 {
+			if err := netlink.LinkSetUp(link); err != nil {
+				return nil, fmt.Errorf("failed to set masterVlan %q up: %v", v.Name(), err)
+			}
+		}
+		return nil, nil
+	}
+	if _, ok := err.(netlink.LinkNotFoundError); !ok {
+		return nil, err
+	}
+
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = v.Name()
+	linkAttrs.ParentIndex = parent.Attrs().Index
+	linkAttrs.Alias = vlanMasterAlias
+	vlan := &netlink.Vlan{
+		LinkAttrs:    linkAttrs,
+		VlanId:       v.ID,
+		VlanProtocol: netlink.VLAN_PROTOCOL_8021Q,
+	}
+	if err := netlink.LinkAdd(vlan); err != nil {
+		return nil, fmt.Errorf("failed to create masterVlan %q: %v", v.Name(), err)
+	}
+	if err := netlink.LinkSetUp(vlan); err != nil {
+		_ = netlink.LinkDel(vlan)
+		return nil, fmt.Errorf("failed to set masterVlan %q up: %v", v.Name(), err)
+	}
+	return vlan, nil
+}
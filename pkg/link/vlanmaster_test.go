@@ -0,0 +1,186 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package link
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/statestore"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+var _ = Describe("VlanMaster", func() {
+	DescribeTable("validates its parent and id",
+		func(v VlanMaster, expectedErr string) {
+			err := v.Validate()
+			if expectedErr == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(expectedErr))
+			}
+		},
+		Entry("valid", VlanMaster{Parent: "eth0", ID: 42}, ""),
+		Entry("no parent", VlanMaster{ID: 42}, "masterVlan parent is required"),
+		Entry("id out of range", VlanMaster{Parent: "eth0", ID: 4095}, "invalid masterVlan id 4095, must be [1, 4094]"),
+		Entry("name too long", VlanMaster{Parent: "enp0s20f0u1u2", ID: 100}, `masterVlan name "enp0s20f0u1u2.100" is longer than 15 characters`),
+	)
+
+	Context("in a network namespace", func() {
+		var (
+			testNS ns.NetNS
+			v      *VlanMaster
+		)
+
+		BeforeEach(func() {
+			DeferCleanup(func(dir string) { vlanMasterDir = dir }, vlanMasterDir)
+			vlanMasterDir = GinkgoT().TempDir()
+			v = &VlanMaster{Parent: "eth0", ID: 42}
+
+			var err error
+			testNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+
+			err = testNS.Do(func(ns.NetNS) error {
+				linkAttrs := netlink.NewLinkAttrs()
+				linkAttrs.Name = "eth0"
+				return netlink.LinkAdd(&netlink.Dummy{LinkAttrs: linkAttrs})
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		})
+
+		vlanExists := func() bool {
+			_, err := netlinksafe.LinkByName("eth0.42")
+			return err == nil
+		}
+
+		It("creates the subinterface for the first user and removes it with the last", func() {
+			err := testNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				for _, id := range []string{"c1", "c2"} {
+					err := AcquireVlanMaster(v, "net1", id, "net1", func(master string) error {
+						Expect(master).To(Equal("eth0.42"))
+						link, err := netlinksafe.LinkByName(master)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(link).To(BeAssignableToTypeOf(&netlink.Vlan{}))
+						Expect(link.(*netlink.Vlan).VlanId).To(Equal(42))
+						return nil
+					})
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				Expect(ReleaseVlanMaster(v, "net1", "c1", "net1")).To(Succeed())
+				Expect(vlanExists()).To(BeTrue())
+				Expect(ReleaseVlanMaster(v, "net1", "c2", "net1")).To(Succeed())
+				Expect(vlanExists()).To(BeFalse())
+
+				// Releasing again is harmless
+				Expect(ReleaseVlanMaster(v, "net1", "c2", "net1")).To(Succeed())
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("drops the users whose DEL was lost on GC", func() {
+			err := testNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				for _, user := range []struct{ network, id string }{{"net1", "c1"}, {"net1", "c2"}, {"net2", "c3"}} {
+					Expect(AcquireVlanMaster(v, user.network, user.id, "net1", func(string) error { return nil })).To(Succeed())
+				}
+
+				valid := gc.NewAttachments([]types.GCAttachment{{ContainerID: "c2", IfName: "net1"}})
+				Expect(GCVlanMaster(v, "net1", valid)).To(Succeed())
+				Expect(v.store("net1").List()).To(ConsistOf(
+					statestore.Key{ContainerID: "c2", IfName: "net1"},
+					statestore.Key{ContainerID: "c3", IfName: "net1"},
+				))
+
+				// The users of other networks keep the subinterface
+				Expect(GCVlanMaster(v, "net1", gc.NewAttachments(nil))).To(Succeed())
+				Expect(vlanExists()).To(BeTrue())
+
+				Expect(GCVlanMaster(v, "net2", gc.NewAttachments(nil))).To(Succeed())
+				Expect(vlanExists()).To(BeFalse())
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("removes the subinterface it created when the attachment fails", func() {
+			err := testNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				err := AcquireVlanMaster(v, "net1", "c1", "net1", func(string) error {
+					return errors.New("no room")
+				})
+				Expect(err).To(MatchError("no room"))
+				Expect(vlanExists()).To(BeFalse())
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("keeps a subinterface provisioned by the operator", func() {
+			err := testNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				parent, err := netlinksafe.LinkByName("eth0")
+				Expect(err).NotTo(HaveOccurred())
+				linkAttrs := netlink.NewLinkAttrs()
+				linkAttrs.Name = "eth0.42"
+				linkAttrs.ParentIndex = parent.Attrs().Index
+				Expect(netlink.LinkAdd(&netlink.Vlan{LinkAttrs: linkAttrs, VlanId: 42})).To(Succeed())
+
+				Expect(AcquireVlanMaster(v, "net1", "c1", "net1", func(string) error { return nil })).To(Succeed())
+				Expect(ReleaseVlanMaster(v, "net1", "c1", "net1")).To(Succeed())
+				Expect(vlanExists()).To(BeTrue())
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("refuses a subinterface of another VLAN under its name", func() {
+			err := testNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				parent, err := netlinksafe.LinkByName("eth0")
+				Expect(err).NotTo(HaveOccurred())
+				linkAttrs := netlink.NewLinkAttrs()
+				linkAttrs.Name = "eth0.42"
+				linkAttrs.ParentIndex = parent.Attrs().Index
+				Expect(netlink.LinkAdd(&netlink.Vlan{LinkAttrs: linkAttrs, VlanId: 43})).To(Succeed())
+
+				err = AcquireVlanMaster(v, "net1", "c1", "net1", func(string) error { return nil })
+				Expect(err).To(MatchError(`"eth0.42" exists and is not VLAN 42 of "eth0"`))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
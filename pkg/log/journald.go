@@ -0,0 +1,109 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// Records are sent to journald as datagrams of its native protocol, see
+// https://systemd.io/JOURNAL_NATIVE_PROTOCOL/.
+var journaldSocket = "/run/systemd/journal/socket"
+
+// Syslog priorities of the slog levels
+const (
+	priorityErr     = 3
+	priorityWarning = 4
+	priorityInfo    = 6
+	priorityDebug   = 7
+)
+
+// journald writes each record formatted by a handler as the message of a
+// journal entry, with the priority of the record being handled.
+type journald struct {
+	mu         sync.Mutex
+	conn       *net.UnixConn
+	identifier string
+	priority   int
+}
+
+func dialJournald(identifier string) (*journald, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %v", err)
+	}
+	return &journald{conn: conn, identifier: identifier, priority: priorityInfo}, nil
+}
+
+func (j *journald) Write(p []byte) (int, error) {
+	msg := bytes.TrimSuffix(p, []byte("\n"))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "PRIORITY=%d\nSYSLOG_IDENTIFIER=%s\n", j.priority, j.identifier)
+	// The binary form, as the message may contain newlines
+	buf.WriteString("MESSAGE\n")
+	binary.Write(&buf, binary.LittleEndian, uint64(len(msg)))
+	buf.Write(msg)
+	buf.WriteByte('\n')
+
+	if _, err := j.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (j *journald) Close() error {
+	return j.conn.Close()
+}
+
+// priorityHandler sets the priority of the journal entries from the level
+// of the records.
+type priorityHandler struct {
+	slog.Handler
+	j *journald
+}
+
+func (h *priorityHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.j.mu.Lock()
+	defer h.j.mu.Unlock()
+	h.j.priority = priority(r.Level)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *priorityHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &priorityHandler{Handler: h.Handler.WithAttrs(attrs), j: h.j}
+}
+
+func (h *priorityHandler) WithGroup(name string) slog.Handler {
+	return &priorityHandler{Handler: h.Handler.WithGroup(name), j: h.j}
+}
+
+func priority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return priorityErr
+	case level >= slog.LevelWarn:
+		return priorityWarning
+	case level >= slog.LevelInfo:
+		return priorityInfo
+	}
+	return priorityDebug
+}
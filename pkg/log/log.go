@@ -0,0 +1,202 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package log implements optional structured logging of plugin invocations.
+//
+// Logging is enabled by the "log" key of the network configuration:
+//
+//	"log": {"file": "/var/log/cni/plugins.log", "level": "debug", "format": "json"}
+//
+// File is the path of a file the records are appended to, or "journald" to
+// send them to the systemd journal. Level is one of debug, info (the
+// default), warn and error, and format is text (the default) or json.
+// Without the key nothing is logged, as stdout carries the result and
+// stderr is often discarded by runtimes.
+//
+// Every record carries the plugin, command, network, container ID and
+// interface of the invocation, and the outcome of the command is logged
+// once it returns.
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// Journald is the File of the configurations logging to the systemd
+// journal.
+const Journald = "journald"
+
+// Config is the "log" key of a network configuration.
+type Config struct {
+	File   string `json:"file"`
+	Level  string `json:"level,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+var (
+	currentMu sync.Mutex
+	current   = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(math.MaxInt)}))
+)
+
+func logger() *slog.Logger {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	return current
+}
+
+func setLogger(l *slog.Logger) *slog.Logger {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	old := current
+	current = l
+	return old
+}
+
+// Debug logs at debug level to the logger of the running invocation, if
+// any. args are alternating keys and values, as for slog.
+func Debug(msg string, args ...any) {
+	logger().Debug(msg, args...)
+}
+
+// Info logs at info level to the logger of the running invocation, if any.
+func Info(msg string, args ...any) {
+	logger().Info(msg, args...)
+}
+
+// Warn logs at warn level to the logger of the running invocation, if any.
+func Warn(msg string, args ...any) {
+	logger().Warn(msg, args...)
+}
+
+// Error logs at error level to the logger of the running invocation, if
+// any.
+func Error(msg string, args ...any) {
+	logger().Error(msg, args...)
+}
+
+// New returns a logger writing to the destination of conf, and the closer
+// to release it with. plugin names the plugin, in the journal.
+func New(conf *Config, plugin string) (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if conf.Level != "" {
+		if err := level.UnmarshalText([]byte(conf.Level)); err != nil {
+			return nil, nil, fmt.Errorf("invalid log level %q", conf.Level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var w io.WriteCloser
+	switch conf.File {
+	case "":
+		return nil, nil, fmt.Errorf("no log file configured")
+	case Journald:
+		j, err := dialJournald(plugin)
+		if err != nil {
+			return nil, nil, err
+		}
+		// journald has its own timestamps and priorities
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{}
+			}
+			return a
+		}
+		w = j
+	default:
+		if err := os.MkdirAll(filepath.Dir(conf.File), 0o755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create log directory: %v", err)
+		}
+		f, err := os.OpenFile(conf.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open log file: %v", err)
+		}
+		w = f
+	}
+
+	var h slog.Handler
+	switch conf.Format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		w.Close()
+		return nil, nil, fmt.Errorf("invalid log format %q", conf.Format)
+	}
+	if j, ok := w.(*journald); ok {
+		h = &priorityHandler{Handler: h, j: j}
+	}
+	return slog.New(h), w, nil
+}
+
+// Wrap returns funcs with each command logged, for the network
+// configurations with a "log" key. plugin names the plugin in the records.
+func Wrap(plugin string, funcs skel.CNIFuncs) skel.CNIFuncs {
+	return skel.CNIFuncs{
+		Add:    wrapCmd(plugin, "ADD", funcs.Add),
+		Del:    wrapCmd(plugin, "DEL", funcs.Del),
+		Check:  wrapCmd(plugin, "CHECK", funcs.Check),
+		GC:     wrapCmd(plugin, "GC", funcs.GC),
+		Status: wrapCmd(plugin, "STATUS", funcs.Status),
+	}
+}
+
+func wrapCmd(plugin, command string, cmd func(*skel.CmdArgs) error) func(*skel.CmdArgs) error {
+	if cmd == nil {
+		return nil
+	}
+	return func(args *skel.CmdArgs) error {
+		conf := struct {
+			Name string  `json:"name"`
+			Log  *Config `json:"log"`
+		}{}
+		if err := json.Unmarshal(args.StdinData, &conf); err != nil || conf.Log == nil {
+			// Invalid configurations are the command's to report
+			return cmd(args)
+		}
+
+		l, closer, err := New(conf.Log, plugin)
+		if err != nil {
+			// Logging is best-effort; never fail the command over it.
+			fmt.Fprintf(os.Stderr, "%s: %v\n", plugin, err)
+			return cmd(args)
+		}
+		defer closer.Close()
+
+		l = l.With("plugin", plugin, "command", command, "network", conf.Name,
+			"containerID", args.ContainerID, "ifName", args.IfName)
+		old := setLogger(l)
+		defer setLogger(old)
+
+		l.Debug("command started", "netns", args.Netns, "args", args.Args)
+		start := time.Now()
+		err = cmd(args)
+		if err != nil {
+			l.Error("command failed", "error", err, "duration", time.Since(start))
+		} else {
+			l.Info("command succeeded", "duration", time.Since(start))
+		}
+		return err
+	}
+}
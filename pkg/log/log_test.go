@@ -0,0 +1,156 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+func readRecords(path string) []map[string]any {
+	data, err := os.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred())
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r map[string]any
+		Expect(json.Unmarshal([]byte(line), &r)).To(Succeed())
+		records = append(records, r)
+	}
+	return records
+}
+
+var _ = Describe("log", func() {
+	var path string
+	var args *skel.CmdArgs
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "cni", "plugins.log")
+		args = &skel.CmdArgs{ContainerID: "ctr", Netns: "/var/run/netns/ctr", IfName: "eth0"}
+	})
+
+	It("logs nothing without configuration", func() {
+		args.StdinData = []byte(`{"name": "test"}`)
+		funcs := Wrap("test", skel.CNIFuncs{Add: func(*skel.CmdArgs) error {
+			Info("not logged")
+			return nil
+		}})
+		Expect(funcs.Add(args)).To(Succeed())
+		Expect(funcs.Del).To(BeNil())
+		Expect(path).NotTo(BeAnExistingFile())
+	})
+
+	It("logs the commands and their records", func() {
+		args.StdinData = []byte(fmt.Sprintf(`{"name": "test", "log": {"file": %q, "level": "debug", "format": "json"}}`, path))
+		funcs := Wrap("test", skel.CNIFuncs{
+			Add: func(*skel.CmdArgs) error {
+				Debug("created link", "name", "veth0")
+				return nil
+			},
+			Del: func(*skel.CmdArgs) error {
+				return errors.New("boom")
+			},
+		})
+		Expect(funcs.Add(args)).To(Succeed())
+		Expect(funcs.Del(args)).To(MatchError("boom"))
+
+		// Records outside of an invocation are dropped
+		Error("not logged")
+
+		records := readRecords(path)
+		Expect(records).To(HaveLen(5))
+		for _, r := range records {
+			Expect(r).To(HaveKeyWithValue("plugin", "test"))
+			Expect(r).To(HaveKeyWithValue("network", "test"))
+			Expect(r).To(HaveKeyWithValue("containerID", "ctr"))
+			Expect(r).To(HaveKeyWithValue("ifName", "eth0"))
+		}
+		Expect(records[0]).To(HaveKeyWithValue("msg", "command started"))
+		Expect(records[0]).To(HaveKeyWithValue("netns", "/var/run/netns/ctr"))
+		Expect(records[1]).To(HaveKeyWithValue("msg", "created link"))
+		Expect(records[1]).To(HaveKeyWithValue("name", "veth0"))
+		Expect(records[2]).To(HaveKeyWithValue("msg", "command succeeded"))
+		Expect(records[2]).To(HaveKeyWithValue("command", "ADD"))
+		Expect(records[4]).To(HaveKeyWithValue("msg", "command failed"))
+		Expect(records[4]).To(HaveKeyWithValue("level", "ERROR"))
+		Expect(records[4]).To(HaveKeyWithValue("error", "boom"))
+	})
+
+	It("filters the records below the level", func() {
+		args.StdinData = []byte(fmt.Sprintf(`{"name": "test", "log": {"file": %q, "level": "warn"}}`, path))
+		funcs := Wrap("test", skel.CNIFuncs{Add: func(*skel.CmdArgs) error {
+			Info("not logged")
+			Warn("logged")
+			return nil
+		}})
+		Expect(funcs.Add(args)).To(Succeed())
+
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Count(string(data), "\n")).To(Equal(1))
+		Expect(string(data)).To(ContainSubstring("level=WARN msg=logged plugin=test"))
+	})
+
+	It("rejects invalid configurations", func() {
+		for _, conf := range []Config{
+			{},
+			{File: path, Level: "verbose"},
+			{File: path, Format: "xml"},
+		} {
+			_, _, err := New(&conf, "test")
+			Expect(err).To(HaveOccurred(), fmt.Sprintf("%+v", conf))
+		}
+
+		// The command runs regardless
+		args.StdinData = []byte(`{"name": "test", "log": {"file": "/dev/null", "level": "verbose"}}`)
+		funcs := Wrap("test", skel.CNIFuncs{Add: func(*skel.CmdArgs) error { return nil }})
+		Expect(funcs.Add(args)).To(Succeed())
+	})
+
+	It("sends the records to journald", func() {
+		oldSocket := journaldSocket
+		DeferCleanup(func() { journaldSocket = oldSocket })
+		journaldSocket = filepath.Join(GinkgoT().TempDir(), "socket")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		l, closer, err := New(&Config{File: Journald}, "test")
+		Expect(err).NotTo(HaveOccurred())
+		l.Warn("multi\nline", "key", "value")
+		Expect(closer.Close()).To(Succeed())
+
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		Expect(err).NotTo(HaveOccurred())
+		header, msg, found := bytes.Cut(buf[:n], []byte("MESSAGE\n"))
+		Expect(found).To(BeTrue())
+		Expect(string(header)).To(Equal("PRIORITY=4\nSYSLOG_IDENTIFIER=test\n"))
+		size := binary.LittleEndian.Uint64(msg)
+		Expect(string(msg[8:])).To(Equal("msg=\"multi\\nline\" key=value\n"))
+		Expect(size).To(Equal(uint64(len(msg) - 9)))
+	})
+})
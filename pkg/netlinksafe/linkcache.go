@@ -0,0 +1,188 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netlinksafe
+
+import (
+	"net"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// LinkCache caches the links looked up by name and by index in each network
+// namespace, for the duration of a plugin invocation, to spare the netlink
+// round trips of looking up the same interfaces again. The links it returns
+// are snapshots: changing links through the methods of the cache drops
+// them, and Invalidate must be called after changing links by other means.
+//
+// A nil *LinkCache is valid and looks links up every time, so that callers
+// can take one optionally.
+type LinkCache struct {
+	mu      sync.Mutex
+	byName  map[linkKey]netlink.Link
+	byIndex map[linkKey]netlink.Link
+}
+
+// linkKey identifies a link by name or by index in the netns ns
+type linkKey struct {
+	ns    string
+	name  string
+	index int
+}
+
+// NewLinkCache returns an empty cache.
+func NewLinkCache() *LinkCache {
+	return &LinkCache{
+		byName:  map[linkKey]netlink.Link{},
+		byIndex: map[linkKey]netlink.Link{},
+	}
+}
+
+// currentNS returns the identity of the netns of the calling thread, or ""
+// if it cannot be told.
+func currentNS() string {
+	h, err := netns.Get()
+	if err != nil {
+		return ""
+	}
+	defer h.Close()
+	return h.UniqueId()
+}
+
+// LinkByName returns the link named name in the current netns.
+func (c *LinkCache) LinkByName(name string) (netlink.Link, error) {
+	if c == nil {
+		return LinkByName(name)
+	}
+	ns := currentNS()
+	if ns != "" {
+		c.mu.Lock()
+		link, ok := c.byName[linkKey{ns: ns, name: name}]
+		c.mu.Unlock()
+		if ok {
+			return link, nil
+		}
+	}
+
+	link, err := LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	c.add(ns, link)
+	return link, nil
+}
+
+// LinkByIndex returns the link of index in the current netns.
+func (c *LinkCache) LinkByIndex(index int) (netlink.Link, error) {
+	if c == nil {
+		return netlink.LinkByIndex(index)
+	}
+	ns := currentNS()
+	if ns != "" {
+		c.mu.Lock()
+		link, ok := c.byIndex[linkKey{ns: ns, index: index}]
+		c.mu.Unlock()
+		if ok {
+			return link, nil
+		}
+	}
+
+	link, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	c.add(ns, link)
+	return link, nil
+}
+
+func (c *LinkCache) add(ns string, link netlink.Link) {
+	if ns == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byName[linkKey{ns: ns, name: link.Attrs().Name}] = link
+	c.byIndex[linkKey{ns: ns, index: link.Attrs().Index}] = link
+}
+
+// Invalidate drops all the cached links.
+func (c *LinkCache) Invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.byName)
+	clear(c.byIndex)
+}
+
+// invalidate drops the cached links after a change of links, whose error it
+// returns.
+func (c *LinkCache) invalidate(err error) error {
+	c.Invalidate()
+	return err
+}
+
+// LinkAdd calls netlink.LinkAdd and invalidates the cache.
+func (c *LinkCache) LinkAdd(link netlink.Link) error {
+	return c.invalidate(netlink.LinkAdd(link))
+}
+
+// LinkDel calls netlink.LinkDel and invalidates the cache.
+func (c *LinkCache) LinkDel(link netlink.Link) error {
+	return c.invalidate(netlink.LinkDel(link))
+}
+
+// LinkSetUp calls netlink.LinkSetUp and invalidates the cache.
+func (c *LinkCache) LinkSetUp(link netlink.Link) error {
+	return c.invalidate(netlink.LinkSetUp(link))
+}
+
+// LinkSetDown calls netlink.LinkSetDown and invalidates the cache.
+func (c *LinkCache) LinkSetDown(link netlink.Link) error {
+	return c.invalidate(netlink.LinkSetDown(link))
+}
+
+// LinkSetMTU calls netlink.LinkSetMTU and invalidates the cache.
+func (c *LinkCache) LinkSetMTU(link netlink.Link, mtu int) error {
+	return c.invalidate(netlink.LinkSetMTU(link, mtu))
+}
+
+// LinkSetName calls netlink.LinkSetName and invalidates the cache.
+func (c *LinkCache) LinkSetName(link netlink.Link, name string) error {
+	return c.invalidate(netlink.LinkSetName(link, name))
+}
+
+// LinkSetAlias calls netlink.LinkSetAlias and invalidates the cache.
+func (c *LinkCache) LinkSetAlias(link netlink.Link, name string) error {
+	return c.invalidate(netlink.LinkSetAlias(link, name))
+}
+
+// LinkSetHardwareAddr calls netlink.LinkSetHardwareAddr and invalidates the
+// cache.
+func (c *LinkCache) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	return c.invalidate(netlink.LinkSetHardwareAddr(link, hwaddr))
+}
+
+// LinkSetMaster calls netlink.LinkSetMaster and invalidates the cache.
+func (c *LinkCache) LinkSetMaster(link, master netlink.Link) error {
+	return c.invalidate(netlink.LinkSetMaster(link, master))
+}
+
+// LinkSetNsFd calls netlink.LinkSetNsFd and invalidates the cache.
+func (c *LinkCache) LinkSetNsFd(link netlink.Link, fd int) error {
+	return c.invalidate(netlink.LinkSetNsFd(link, fd))
+}
@@ -0,0 +1,97 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netlinksafe_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+var _ = Describe("LinkCache", func() {
+	var firstNs, secondNs ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		firstNs, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		secondNs, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = firstNs.Do(func(_ ns.NetNS) error {
+			return netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "veth0"},
+				PeerName:  "peer0",
+			})
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		for _, netns := range []ns.NetNS{firstNs, secondNs} {
+			Expect(netns.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(netns)).To(Succeed())
+		}
+	})
+
+	It("caches the links of each netns until they are changed", func() {
+		links := netlinksafe.NewLinkCache()
+
+		err := firstNs.Do(func(_ ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := links.LinkByName("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			// Another lookup by name or index returns the cached link
+			Expect(links.LinkByName("veth0")).To(BeIdenticalTo(link))
+			Expect(links.LinkByIndex(link.Attrs().Index)).To(BeIdenticalTo(link))
+
+			Expect(links.LinkSetMTU(link, 1400)).To(Succeed())
+			updated, err := links.LinkByIndex(link.Attrs().Index)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updated).NotTo(BeIdenticalTo(link))
+			Expect(updated.Attrs().MTU).To(Equal(1400))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = secondNs.Do(func(_ ns.NetNS) error {
+			_, err := links.LinkByName("veth0")
+			return err
+		})
+		Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}))
+	})
+
+	It("looks links up every time when nil", func() {
+		var links *netlinksafe.LinkCache
+
+		err := firstNs.Do(func(_ ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := links.LinkByName("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links.LinkSetMTU(link, 1400)).To(Succeed())
+			link, err = links.LinkByName("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().MTU).To(Equal(1400))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
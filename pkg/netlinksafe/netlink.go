@@ -210,6 +210,28 @@ func (h *Handle) QdiscList(link netlink.Link) ([]netlink.Qdisc, error) {
 	return qdisc, err
 }
 
+// ClassList calls netlink.ClassList, retrying if necessary.
+func ClassList(link netlink.Link, parent uint32) ([]netlink.Class, error) {
+	var classes []netlink.Class
+	var err error
+	retryOnIntr(func() error {
+		classes, err = netlink.ClassList(link, parent) //nolint:forbidigo
+		return err
+	})
+	return classes, discardErrDumpInterrupted(err)
+}
+
+// ClassList calls h.Handle.ClassList, retrying if necessary.
+func (h *Handle) ClassList(link netlink.Link, parent uint32) ([]netlink.Class, error) {
+	var classes []netlink.Class
+	var err error
+	retryOnIntr(func() error {
+		classes, err = h.Handle.ClassList(link, parent) //nolint:forbidigo
+		return err
+	})
+	return classes, err
+}
+
 // LinkGetProtinfo calls netlink.LinkGetProtinfo, retrying if necessary.
 func LinkGetProtinfo(link netlink.Link) (netlink.Protinfo, error) {
 	var protinfo netlink.Protinfo
@@ -319,3 +341,14 @@ func (h *Handle) ConntrackDeleteFilters(table netlink.ConntrackTableType, family
 	})
 	return deleted, err
 }
+
+// NeighList calls netlink.NeighList, retrying if necessary.
+func NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	var neighs []netlink.Neigh
+	var err error
+	retryOnIntr(func() error {
+		neighs, err = netlink.NeighList(linkIndex, family) //nolint:forbidigo
+		return err
+	})
+	return neighs, discardErrDumpInterrupted(err)
+}
@@ -22,6 +22,8 @@ import (
 	"syscall"
 
 	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/plugins/pkg/trace"
 )
 
 // Returns an object representing the current OS thread's network namespace
@@ -224,6 +226,9 @@ func (ns *netNS) Do(toRun func(NetNS) error) error {
 		return err
 	}
 
+	span := trace.Start("netns", "path", ns.Path())
+	defer span.End()
+
 	containedCall := func(hostNS NetNS) error {
 		threadNS, err := getCurrentNSNoLock()
 		if err != nil {
@@ -0,0 +1,261 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package statestore persists the per-attachment state plugins keep between
+// ADD and DEL, such as the settings to restore on DEL.
+//
+// Each record is a JSON file under the store's directory, named after the
+// container ID, interface name and kind of the record, and holding them
+// along with the network and schema version beside the plugin's data:
+//
+//	{"version": 1, "network": "net1", "containerID": "...", "ifName": "eth0", "data": {...}}
+//
+// Records are replaced atomically, so a crash never leaves a torn one. A
+// file that is not such an envelope is taken as a record written before
+// the plugin adopted the store, of version 0, holding just the data.
+package statestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/gc"
+)
+
+// Key identifies a record.
+type Key struct {
+	ContainerID string
+	IfName      string
+	// Kind tells apart the records of an attachment, for plugins keeping
+	// several. It may be empty.
+	Kind string
+}
+
+// Store holds the records of a network in a directory.
+type Store struct {
+	// Dir is the directory of the records, created on the first Save.
+	Dir string
+	// Network is the name of the network the records are saved for.
+	Network string
+	// Version is the schema version of the data saved. Records of a newer
+	// version cannot be loaded.
+	Version int
+	// Migrate, if set, converts the data of records of an older version
+	// to the current one on Load.
+	Migrate func(version int, data json.RawMessage) (json.RawMessage, error)
+	// Legacy, if set, returns the name in Dir of the record of key as
+	// written before the plugin adopted the store. Load falls back to it
+	// and Delete removes it.
+	Legacy func(key Key) string
+}
+
+type record struct {
+	Version     int             `json:"version"`
+	Network     string          `json:"network,omitempty"`
+	ContainerID string          `json:"containerID"`
+	IfName      string          `json:"ifName"`
+	Kind        string          `json:"kind,omitempty"`
+	Data        json.RawMessage `json:"data"`
+}
+
+const suffix = ".json"
+
+// New returns a store of version 1 for the records of network in dir.
+func New(dir, network string) *Store {
+	return &Store{Dir: dir, Network: network, Version: 1}
+}
+
+func (s *Store) path(key Key) string {
+	name := key.ContainerID + "_" + key.IfName
+	if key.Kind != "" {
+		name += "." + key.Kind
+	}
+	return filepath.Join(s.Dir, name+suffix)
+}
+
+// Save records v as the data of key, replacing any previous record.
+func (s *Store) Save(key Key, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state of %s/%s: %v", key.ContainerID, key.IfName, err)
+	}
+	rec, err := json.Marshal(&record{
+		Version:     s.Version,
+		Network:     s.Network,
+		ContainerID: key.ContainerID,
+		IfName:      key.IfName,
+		Kind:        key.Kind,
+		Data:        data,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory %s: %v", s.Dir, err)
+	}
+	path := s.path(key)
+	tmp, err := os.CreateTemp(s.Dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(rec); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state %s: %v", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state %s: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state %s: %v", path, err)
+	}
+	return nil
+}
+
+// Load reads the data of the record of key into v, and reports whether
+// there was one.
+func (s *Store) Load(key Key, v interface{}) (bool, error) {
+	path := s.path(key)
+	rec, err := readRecord(path)
+	if os.IsNotExist(err) && s.Legacy != nil {
+		path = filepath.Join(s.Dir, s.Legacy(key))
+		rec, err = readRecord(path)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	data := rec.Data
+	switch {
+	case rec.Version > s.Version:
+		return false, fmt.Errorf("state %s has version %d, newer than the supported %d", path, rec.Version, s.Version)
+	case rec.Version < s.Version && s.Migrate != nil:
+		if data, err = s.Migrate(rec.Version, data); err != nil {
+			return false, fmt.Errorf("failed to migrate state %s from version %d: %v", path, rec.Version, err)
+		}
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to parse state %s: %v", path, err)
+	}
+	return true, nil
+}
+
+// readRecord reads the record at path, taking a file that is not an
+// envelope as the data of a record of version 0.
+func readRecord(path string) (*record, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rec := &record{}
+	if err := json.Unmarshal(raw, rec); err != nil || rec.Version == 0 {
+		return &record{Data: raw}, nil
+	}
+	return rec, nil
+}
+
+// Delete removes the record of key, if any.
+func (s *Store) Delete(key Key) error {
+	paths := []string{s.path(key)}
+	if s.Legacy != nil {
+		paths = append(paths, filepath.Join(s.Dir, s.Legacy(key)))
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove state %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// List returns the keys of the records in the store's directory, of any
+// network. Records of version 0, which do not name their attachment, are
+// left out.
+func (s *Store) List() ([]Key, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list state directory %s: %v", s.Dir, err)
+	}
+
+	var keys []Key
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+		rec, err := readRecord(filepath.Join(s.Dir, entry.Name()))
+		if err != nil || rec.Version == 0 {
+			continue
+		}
+		keys = append(keys, Key{ContainerID: rec.ContainerID, IfName: rec.IfName, Kind: rec.Kind})
+	}
+	return keys, nil
+}
+
+// GC removes the records of the network whose attachment is not valid, after
+// calling release, if set, with their key and data, so that the plugin can
+// free what they hold. A failing release keeps the record for the next GC.
+// Records of other networks and those of version 0, which do not name their
+// attachment, are left alone.
+func (s *Store) GC(valid *gc.Attachments, release func(key Key, data json.RawMessage) error) error {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to list state directory %s: %v", s.Dir, err)
+	}
+
+	var errs []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+		path := filepath.Join(s.Dir, entry.Name())
+		rec, err := readRecord(path)
+		if err != nil || rec.Version == 0 || rec.Network != s.Network {
+			continue
+		}
+		key := Key{ContainerID: rec.ContainerID, IfName: rec.IfName, Kind: rec.Kind}
+		if valid.Valid(key.ContainerID, key.IfName) {
+			continue
+		}
+		if release != nil {
+			if err := release(key, rec.Data); err != nil {
+				errs = append(errs, fmt.Sprintf("%s/%s: %v", key.ContainerID, key.IfName, err))
+				continue
+			}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Sprintf("failed to remove state %s: %v", path, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to release state: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statestore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/statestore"
+)
+
+type state struct {
+	MTU int `json:"mtu"`
+}
+
+var _ = Describe("statestore", func() {
+	var (
+		dir   string
+		store *statestore.Store
+		key   statestore.Key
+	)
+
+	BeforeEach(func() {
+		dir = filepath.Join(GinkgoT().TempDir(), "state")
+		store = statestore.New(dir, "net1")
+		key = statestore.Key{ContainerID: "c1", IfName: "eth0"}
+	})
+
+	It("saves, loads and deletes records", func() {
+		Expect(store.Save(key, &state{MTU: 1400})).To(Succeed())
+
+		data, err := os.ReadFile(filepath.Join(dir, "c1_eth0.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(MatchJSON(`{
+			"version": 1,
+			"network": "net1",
+			"containerID": "c1",
+			"ifName": "eth0",
+			"data": {"mtu": 1400}
+		}`))
+
+		s := &state{}
+		found, err := store.Load(key, s)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(s.MTU).To(Equal(1400))
+
+		Expect(store.Delete(key)).To(Succeed())
+		found, err = store.Load(key, s)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+		Expect(store.Delete(key)).To(Succeed())
+	})
+
+	It("keeps the records of several kinds apart", func() {
+		vf := statestore.Key{ContainerID: "c1", IfName: "eth0", Kind: "vf"}
+		Expect(store.Save(key, &state{MTU: 1400})).To(Succeed())
+		Expect(store.Save(vf, &state{MTU: 9000})).To(Succeed())
+		Expect(filepath.Join(dir, "c1_eth0.vf.json")).To(BeAnExistingFile())
+
+		s := &state{}
+		_, err := store.Load(key, s)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.MTU).To(Equal(1400))
+	})
+
+	It("loads records written before the store", func() {
+		Expect(os.MkdirAll(dir, 0o700)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "c1-eth0.old"), []byte(`{"mtu": 1300}`), 0o600)).To(Succeed())
+		store.Legacy = func(key statestore.Key) string {
+			return key.ContainerID + "-" + key.IfName + ".old"
+		}
+
+		s := &state{}
+		found, err := store.Load(key, s)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(s.MTU).To(Equal(1300))
+
+		Expect(store.Delete(key)).To(Succeed())
+		Expect(filepath.Join(dir, "c1-eth0.old")).NotTo(BeAnExistingFile())
+	})
+
+	It("migrates records of older versions", func() {
+		Expect(store.Save(key, map[string]int{"oldMTU": 1400})).To(Succeed())
+
+		store.Version = 2
+		store.Migrate = func(version int, data json.RawMessage) (json.RawMessage, error) {
+			Expect(version).To(Equal(1))
+			old := map[string]int{}
+			if err := json.Unmarshal(data, &old); err != nil {
+				return nil, err
+			}
+			return json.Marshal(&state{MTU: old["oldMTU"]})
+		}
+
+		s := &state{}
+		_, err := store.Load(key, s)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.MTU).To(Equal(1400))
+	})
+
+	It("refuses records of newer versions", func() {
+		store.Version = 2
+		Expect(store.Save(key, &state{MTU: 1400})).To(Succeed())
+
+		_, err := statestore.New(dir, "net1").Load(key, &state{})
+		Expect(err).To(MatchError(ContainSubstring("newer than the supported 1")))
+	})
+
+	It("lists the records of all networks", func() {
+		Expect(store.List()).To(BeEmpty())
+
+		Expect(store.Save(key, &state{})).To(Succeed())
+		other := statestore.New(dir, "net2")
+		Expect(other.Save(statestore.Key{ContainerID: "c2", IfName: "eth1", Kind: "mtu"}, &state{})).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "c3_eth0.json"), []byte(`{"mtu": 1500}`), 0o600)).To(Succeed())
+
+		Expect(store.List()).To(ConsistOf(key, statestore.Key{ContainerID: "c2", IfName: "eth1", Kind: "mtu"}))
+	})
+
+	It("garbage collects the records of invalid attachments on the network", func() {
+		for _, id := range []string{"c1", "c2", "c3"} {
+			Expect(store.Save(statestore.Key{ContainerID: id, IfName: "eth0"}, &state{})).To(Succeed())
+		}
+		other := statestore.New(dir, "net2")
+		Expect(other.Save(statestore.Key{ContainerID: "c4", IfName: "eth0"}, &state{})).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "c5_eth0.json"), []byte(`{"mtu": 1500}`), 0o600)).To(Succeed())
+
+		valid := gc.NewAttachments([]types.GCAttachment{{ContainerID: "c1", IfName: "eth0"}})
+		var released []string
+		err := store.GC(valid, func(key statestore.Key, _ json.RawMessage) error {
+			released = append(released, key.ContainerID)
+			if key.ContainerID == "c3" {
+				return fmt.Errorf("busy")
+			}
+			return nil
+		})
+		Expect(err).To(MatchError(ContainSubstring("c3/eth0: busy")))
+		Expect(released).To(ConsistOf("c2", "c3"))
+
+		Expect(filepath.Join(dir, "c1_eth0.json")).To(BeAnExistingFile())
+		Expect(filepath.Join(dir, "c2_eth0.json")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(dir, "c3_eth0.json")).To(BeAnExistingFile())
+		Expect(filepath.Join(dir, "c4_eth0.json")).To(BeAnExistingFile())
+		Expect(filepath.Join(dir, "c5_eth0.json")).To(BeAnExistingFile())
+	})
+
+	It("garbage collects nothing without a directory", func() {
+		Expect(store.GC(gc.NewAttachments(nil), nil)).To(Succeed())
+	})
+})
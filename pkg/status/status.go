@@ -0,0 +1,49 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package status probes the capabilities of the node a plugin needs to
+// process ADD requests, for the plugins' STATUS implementations.
+//
+// Every probe returns nil or a *types.Error with the
+// errors.ErrPluginNotAvailable code, which STATUS returns as is, so a
+// runtime can hold back the network until the node is ready.
+package status
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+)
+
+func notAvailable(msg string, err error) *types.Error {
+	return types.NewError(cnierrors.ErrPluginNotAvailable, msg, err.Error())
+}
+
+// Writable checks that the directory dir, created if missing, is
+// writable.
+func Writable(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return notAvailable(fmt.Sprintf("directory %s is not usable", dir), err)
+	}
+	probe, err := os.CreateTemp(dir, ".status-")
+	if err != nil {
+		return notAvailable(fmt.Sprintf("directory %s is not writable", dir), err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
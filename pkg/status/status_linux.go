@@ -0,0 +1,139 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"golang.org/x/sys/unix"
+	"sigs.k8s.io/knftables"
+
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+)
+
+var (
+	sysModuleDir = "/sys/module"
+	modulesDir   = "/lib/modules"
+)
+
+// Netlink checks that the rtnetlink socket of the current network namespace
+// is reachable.
+func Netlink() error {
+	if _, err := netlinksafe.LinkList(); err != nil {
+		return notAvailable("netlink is not reachable", err)
+	}
+	return nil
+}
+
+// Link checks that the link name exists in the current network namespace.
+func Link(name string) error {
+	if _, err := netlinksafe.LinkByName(name); err != nil {
+		return notAvailable(fmt.Sprintf("link %q is not available", name), err)
+	}
+	return nil
+}
+
+// KernelModules checks that the kernel modules names are loaded, built
+// into the kernel, or installed to be loaded on demand. Nothing can be told
+// of the modules that are not loaded when the modules of the running kernel
+// are not visible, as in most containers, so they are then assumed present.
+func KernelModules(names ...string) error {
+	var missing []string
+	for _, name := range names {
+		name = strings.ReplaceAll(name, "-", "_")
+		if _, err := os.Stat(filepath.Join(sysModuleDir, name)); err == nil {
+			continue
+		}
+		missing = append(missing, name)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return notAvailable("failed to get the kernel release", err)
+	}
+	dir := filepath.Join(modulesDir, unix.ByteSliceToString(uts.Release[:]))
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+
+	available := map[string]bool{}
+	for _, index := range []string{"modules.builtin", "modules.dep"} {
+		if err := readModules(filepath.Join(dir, index), available); err != nil {
+			return notAvailable("failed to read the kernel modules", err)
+		}
+	}
+	for _, name := range missing {
+		if !available[name] {
+			return notAvailable(fmt.Sprintf("kernel module %s is not available", name),
+				fmt.Errorf("%s is neither loaded nor listed in %s", name, dir))
+		}
+	}
+	return nil
+}
+
+// readModules adds the names of the modules listed in the index file, a
+// modules.builtin or modules.dep file, to names.
+func readModules(file string, names map[string]bool) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// kernel/net/8021q/8021q.ko.xz: kernel/net/802/garp.ko.xz ...
+		path, _, _ := strings.Cut(scanner.Text(), ":")
+		name, _, _ := strings.Cut(filepath.Base(path), ".ko")
+		names[strings.ReplaceAll(name, "-", "_")] = true
+	}
+	return scanner.Err()
+}
+
+// IPTables checks that netfilter can be used through iptables, or
+// ip6tables if ipv6 is set.
+func IPTables(ipv6 bool) error {
+	proto, cmd := iptables.ProtocolIPv4, "iptables"
+	if ipv6 {
+		proto, cmd = iptables.ProtocolIPv6, "ip6tables"
+	}
+	ipt, err := iptables.NewWithProtocol(proto)
+	if err != nil {
+		return notAvailable(cmd+" is not available", err)
+	}
+	// Whether the chain exists does not matter, only that it can be checked
+	if _, err := ipt.ChainExists("filter", "INPUT"); err != nil {
+		return notAvailable(cmd+" is not usable", err)
+	}
+	return nil
+}
+
+// NFTables checks that netfilter can be used through nftables.
+func NFTables() error {
+	if _, err := knftables.New(knftables.InetFamily, "cni_status_probe"); err != nil {
+		return notAvailable("nftables is not available", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+)
+
+func expectNotAvailable(err error) {
+	Expect(err).To(BeAssignableToTypeOf(&types.Error{}))
+	Expect(err.(*types.Error).Code).To(Equal(cnierrors.ErrPluginNotAvailable))
+}
+
+var _ = Describe("status probes", func() {
+	It("finds the links of the namespace", func() {
+		Expect(Netlink()).To(Succeed())
+		Expect(Link("lo")).To(Succeed())
+		expectNotAvailable(Link("missing0"))
+	})
+
+	It("checks that directories are writable", func() {
+		dir := filepath.Join(GinkgoT().TempDir(), "data")
+		Expect(Writable(dir)).To(Succeed())
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+
+		file := filepath.Join(dir, "file")
+		Expect(os.WriteFile(file, nil, 0o644)).To(Succeed())
+		expectNotAvailable(Writable(file))
+	})
+
+	Describe("kernel modules", func() {
+		var release string
+
+		BeforeEach(func() {
+			oldSys, oldModules := sysModuleDir, modulesDir
+			DeferCleanup(func() {
+				sysModuleDir, modulesDir = oldSys, oldModules
+			})
+			sysModuleDir = GinkgoT().TempDir()
+			modulesDir = GinkgoT().TempDir()
+			Expect(os.Mkdir(filepath.Join(sysModuleDir, "veth"), 0o755)).To(Succeed())
+
+			var uts unix.Utsname
+			Expect(unix.Uname(&uts)).To(Succeed())
+			release = unix.ByteSliceToString(uts.Release[:])
+		})
+
+		It("assumes modules are present when the kernel modules are not visible", func() {
+			Expect(KernelModules("veth", "bridge")).To(Succeed())
+		})
+
+		It("finds loaded, built-in and installed modules", func() {
+			dir := filepath.Join(modulesDir, release)
+			Expect(os.Mkdir(dir, 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "modules.builtin"),
+				[]byte("kernel/net/bridge/bridge.ko\n"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "modules.dep"),
+				[]byte("kernel/net/8021q/8021q.ko.xz: kernel/net/802/garp.ko.xz\nkernel/net/sched/sch_tbf.ko.zst:\n"), 0o644)).To(Succeed())
+
+			Expect(KernelModules("veth", "bridge", "8021q", "sch-tbf")).To(Succeed())
+			err := KernelModules("veth", "macvlan")
+			expectNotAvailable(err)
+			Expect(err.(*types.Error).Msg).To(Equal("kernel module macvlan is not available"))
+		})
+	})
+})
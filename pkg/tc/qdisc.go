@@ -0,0 +1,157 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tc has the traffic control helpers of the plugins that replace the
+// root qdisc of an interface, and give the previous one back on DEL.
+package tc
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+)
+
+// Qdisc describes a root qdisc, so that it can be recorded and recreated.
+// Parameters are only kept for fq and fq_codel; the other types are
+// recreated with their kernel defaults.
+type Qdisc struct {
+	Type   string `json:"type"`
+	Handle uint32 `json:"handle,omitempty"`
+	// Limit is the queue limit in packets.
+	Limit uint32 `json:"limit,omitempty"`
+	// FlowLimit is the per-flow queue limit in packets (fq).
+	FlowLimit uint32 `json:"flowLimit,omitempty"`
+	// Quantum is in bytes.
+	Quantum uint32 `json:"quantum,omitempty"`
+	// MaxRate is the per-flow pacing rate in bytes per second (fq).
+	MaxRate uint32 `json:"maxRate,omitempty"`
+	// Target and Interval are in microseconds (fq_codel).
+	Target   uint32 `json:"target,omitempty"`
+	Interval uint32 `json:"interval,omitempty"`
+	ECN      *bool  `json:"ecn,omitempty"`
+}
+
+// Netlink returns the root qdisc q describes on the link of linkIndex.
+func (q *Qdisc) Netlink(linkIndex int) netlink.Qdisc {
+	attrs := netlink.QdiscAttrs{
+		LinkIndex: linkIndex,
+		Handle:    q.Handle,
+		Parent:    netlink.HANDLE_ROOT,
+	}
+	switch q.Type {
+	case "fq":
+		fq := netlink.NewFq(attrs)
+		fq.PacketLimit = q.Limit
+		fq.FlowPacketLimit = q.FlowLimit
+		fq.Quantum = q.Quantum
+		fq.FlowMaxRate = q.MaxRate
+		return fq
+	case "fq_codel":
+		fqCodel := netlink.NewFqCodel(attrs)
+		fqCodel.Limit = q.Limit
+		fqCodel.Quantum = q.Quantum
+		fqCodel.Target = q.Target
+		fqCodel.Interval = q.Interval
+		if q.ECN != nil && !*q.ECN {
+			fqCodel.ECN = 0
+		}
+		return fqCodel
+	default:
+		return &netlink.GenericQdisc{QdiscAttrs: attrs, QdiscType: q.Type}
+	}
+}
+
+// Describe returns the description of qdisc, or nil if it is nil or a
+// default qdisc of the kernel. Those have no handle.
+func Describe(qdisc netlink.Qdisc) *Qdisc {
+	if qdisc == nil || qdisc.Attrs().Handle == netlink.HANDLE_NONE {
+		return nil
+	}
+	q := &Qdisc{Type: qdisc.Type(), Handle: qdisc.Attrs().Handle}
+	switch qdisc := qdisc.(type) {
+	case *netlink.Fq:
+		q.Limit = qdisc.PacketLimit
+		q.FlowLimit = qdisc.FlowPacketLimit
+		q.Quantum = qdisc.Quantum
+		q.MaxRate = qdisc.FlowMaxRate
+	case *netlink.FqCodel:
+		q.Limit = qdisc.Limit
+		q.Quantum = qdisc.Quantum
+		q.Target = qdisc.Target
+		q.Interval = qdisc.Interval
+		ecn := qdisc.ECN != 0
+		q.ECN = &ecn
+	}
+	return q
+}
+
+// RootQdisc returns the root qdisc of link, or nil if it has none.
+func RootQdisc(link netlink.Link) (netlink.Qdisc, error) {
+	qdiscs, err := netlinksafe.QdiscList(link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list qdiscs of %q: %v", link.Attrs().Name, err)
+	}
+	for _, qdisc := range qdiscs {
+		if qdisc.Attrs().Parent == netlink.HANDLE_ROOT {
+			return qdisc, nil
+		}
+	}
+	return nil, nil
+}
+
+// ResetRootQdisc deletes the root qdisc of link, letting the kernel attach
+// its default one.
+func ResetRootQdisc(link netlink.Link) error {
+	qdisc, err := RootQdisc(link)
+	if err != nil || qdisc == nil {
+		return err
+	}
+	// A default qdisc has no handle and cannot be deleted.
+	if qdisc.Attrs().Handle == netlink.HANDLE_NONE {
+		return nil
+	}
+	if err := netlink.QdiscDel(qdisc); err != nil {
+		return fmt.Errorf("failed to delete qdisc %s of %q: %v", qdisc.Type(), link.Attrs().Name, err)
+	}
+	return nil
+}
+
+// RestoreRootQdisc replaces the root qdisc of link with the one prior
+// describes, or with the kernel default one if prior is nil. If prior cannot
+// be recreated, the kernel default one is attached and the error returned.
+func RestoreRootQdisc(link netlink.Link, prior *Qdisc) error {
+	if prior == nil {
+		return ResetRootQdisc(link)
+	}
+	// The root qdisc would be changed in place, rather than replaced, if it
+	// had the same handle, which fails for another type
+	current, err := RootQdisc(link)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Attrs().Handle == prior.Handle {
+		if err := ResetRootQdisc(link); err != nil {
+			return err
+		}
+	}
+	if err := netlink.QdiscReplace(prior.Netlink(link.Attrs().Index)); err != nil {
+		if resetErr := ResetRootQdisc(link); resetErr != nil {
+			return resetErr
+		}
+		return fmt.Errorf("failed to restore qdisc %s of %q: %v", prior.Type, link.Attrs().Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tc_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/tc"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+var _ = Describe("root qdiscs", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = testNS.Do(func(ns.NetNS) error {
+			return netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "eth0"},
+				PeerName:  "peer0",
+			})
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	// inNS runs f with the link of eth0 in the namespace.
+	inNS := func(f func(link netlink.Link)) {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlinksafe.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			f(link)
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("does not describe a default qdisc", func() {
+		inNS(func(link netlink.Link) {
+			qdisc, err := tc.RootQdisc(link)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tc.Describe(qdisc)).To(BeNil())
+
+			// There is nothing to delete
+			Expect(tc.ResetRootQdisc(link)).To(Succeed())
+			Expect(tc.RestoreRootQdisc(link, nil)).To(Succeed())
+		})
+	})
+
+	It("describes the parameters of fq and fq_codel", func() {
+		fqCodel := netlink.NewFqCodel(netlink.QdiscAttrs{Handle: netlink.MakeHandle(1, 0), Parent: netlink.HANDLE_ROOT})
+		fqCodel.Limit = 500
+		fqCodel.Target = 4000
+		fqCodel.ECN = 0
+		described := tc.Describe(fqCodel)
+		ecn := false
+		Expect(described).To(Equal(&tc.Qdisc{Type: "fq_codel", Handle: netlink.MakeHandle(1, 0), Limit: 500, Target: 4000, ECN: &ecn}))
+		Expect(described.Netlink(3)).To(Equal(&netlink.FqCodel{
+			QdiscAttrs: netlink.QdiscAttrs{LinkIndex: 3, Handle: netlink.MakeHandle(1, 0), Parent: netlink.HANDLE_ROOT},
+			Limit:      500,
+			Target:     4000,
+			ECN:        0,
+		}))
+
+		fq := netlink.NewFq(netlink.QdiscAttrs{Handle: netlink.MakeHandle(2, 0), Parent: netlink.HANDLE_ROOT})
+		fq.FlowMaxRate = 1000
+		Expect(tc.Describe(fq)).To(Equal(&tc.Qdisc{Type: "fq", Handle: netlink.MakeHandle(2, 0), MaxRate: 1000}))
+	})
+
+	It("restores the qdisc it replaced", func() {
+		inNS(func(link netlink.Link) {
+			prior := &tc.Qdisc{Type: "pfifo", Handle: netlink.MakeHandle(1, 0)}
+			Expect(netlink.QdiscReplace(prior.Netlink(link.Attrs().Index))).To(Succeed())
+			qdisc, err := tc.RootQdisc(link)
+			Expect(err).NotTo(HaveOccurred())
+			described := tc.Describe(qdisc)
+			Expect(described).To(Equal(prior))
+
+			// Replaced by one of another type with the same handle
+			other := &tc.Qdisc{Type: "bfifo", Handle: netlink.MakeHandle(1, 0)}
+			Expect(tc.ResetRootQdisc(link)).To(Succeed())
+			Expect(netlink.QdiscReplace(other.Netlink(link.Attrs().Index))).To(Succeed())
+
+			Expect(tc.RestoreRootQdisc(link, described)).To(Succeed())
+			qdisc, err = tc.RootQdisc(link)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tc.Describe(qdisc)).To(Equal(prior))
+
+			Expect(tc.RestoreRootQdisc(link, nil)).To(Succeed())
+			qdisc, err = tc.RootQdisc(link)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tc.Describe(qdisc)).To(BeNil())
+		})
+	})
+})
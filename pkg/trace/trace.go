@@ -0,0 +1,263 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package trace implements optional OpenTelemetry tracing of plugin
+// invocations.
+//
+// Each invocation records a span for the command, with child spans for the
+// steps library and plugin code wrap with Start, such as parsing the
+// configuration, entering network namespaces, netlink operations and IPAM
+// delegation. The spans are exported when the command returns, as an OTLP
+// JSON request, to the exporter given by the "trace" key of the network
+// configuration or, failing that, by CNI_TRACE_EXPORTER:
+//
+//	"trace": {"exporter": "unix:/run/otelcol/otlp.sock"}
+//
+// "unix:<path>" posts the spans to the OTLP/HTTP receiver listening on the
+// socket path, and "file:<path>" appends them to path, one request per
+// line, as read by the collector's otlpjsonfile receiver.
+//
+// A W3C TRACEPARENT in the environment, set by the runtime or by a
+// delegating plugin, parents the invocation's spans, and delegated plugins
+// are given the context of the span delegating to them.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// EnvExporter is the environment variable that enables tracing of the
+// invocations whose configuration has no "trace" key.
+const EnvExporter = "CNI_TRACE_EXPORTER"
+
+// EnvTraceParent is the environment variable carrying the W3C trace
+// context of the caller.
+const EnvTraceParent = "TRACEPARENT"
+
+// Config is the "trace" key of a network configuration.
+type Config struct {
+	Exporter string `json:"exporter"`
+}
+
+// Span is a timed step of an invocation. A nil *Span is valid and records
+// nothing.
+type Span struct {
+	inv      *invocation
+	name     string
+	id       [8]byte
+	parentID [8]byte
+	start    time.Time
+	end      time.Time
+	attrs    []any
+	err      error
+}
+
+// invocation collects the spans of a traced invocation.
+type invocation struct {
+	mu      sync.Mutex
+	traceID [16]byte
+	open    []*Span
+	ended   []*Span
+}
+
+var (
+	currentMu sync.Mutex
+	current   *invocation
+)
+
+func getCurrent() *invocation {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	return current
+}
+
+func setCurrent(inv *invocation) {
+	currentMu.Lock()
+	current = inv
+	currentMu.Unlock()
+}
+
+// Start begins a span of the running invocation, if any, as a child of the
+// innermost span in progress. args are alternating keys and values of its
+// attributes.
+func Start(name string, args ...any) *Span {
+	return getCurrent().start(name, args...)
+}
+
+func (inv *invocation) start(name string, args ...any) *Span {
+	if inv == nil {
+		return nil
+	}
+	s := &Span{inv: inv, name: name, start: time.Now(), attrs: args}
+	rand.Read(s.id[:])
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if n := len(inv.open); n > 0 {
+		s.parentID = inv.open[n-1].id
+	}
+	inv.open = append(inv.open, s)
+	return s
+}
+
+// SetError marks the span as failed with err, if not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.inv.mu.Lock()
+	s.err = err
+	s.inv.mu.Unlock()
+}
+
+// End ends the span.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	inv := s.inv
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	s.end = time.Now()
+	for i := len(inv.open) - 1; i >= 0; i-- {
+		if inv.open[i] == s {
+			inv.open = append(inv.open[:i], inv.open[i+1:]...)
+			break
+		}
+	}
+	inv.ended = append(inv.ended, s)
+}
+
+// TraceParent returns the W3C trace context of the innermost span in
+// progress, for a delegated plugin, or "" if the invocation is not traced.
+func TraceParent() string {
+	inv := getCurrent()
+	if inv == nil {
+		return ""
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if len(inv.open) == 0 {
+		return ""
+	}
+	return formatTraceParent(inv.traceID, inv.open[len(inv.open)-1].id)
+}
+
+// Delegate sets TRACEPARENT to the context of the innermost span in
+// progress for the plugins executed until the returned function is called.
+func Delegate() func() {
+	tp := TraceParent()
+	if tp == "" {
+		return func() {}
+	}
+	old, set := os.LookupEnv(EnvTraceParent)
+	os.Setenv(EnvTraceParent, tp)
+	return func() {
+		if set {
+			os.Setenv(EnvTraceParent, old)
+		} else {
+			os.Unsetenv(EnvTraceParent)
+		}
+	}
+}
+
+func formatTraceParent(traceID [16]byte, spanID [8]byte) string {
+	return fmt.Sprintf("00-%x-%x-01", traceID, spanID)
+}
+
+// parseTraceParent returns the trace and parent span IDs of the W3C trace
+// context tp.
+func parseTraceParent(tp string) (traceID [16]byte, spanID [8]byte, ok bool) {
+	parts := strings.Split(tp, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, false
+	}
+	return traceID, spanID, traceID != [16]byte{} && spanID != [8]byte{}
+}
+
+// Wrap returns funcs with each command traced, if the network configuration
+// has a "trace" key or CNI_TRACE_EXPORTER is set. plugin names the service
+// of the spans.
+func Wrap(plugin string, funcs skel.CNIFuncs) skel.CNIFuncs {
+	return skel.CNIFuncs{
+		Add:    wrapCmd(plugin, "ADD", funcs.Add),
+		Del:    wrapCmd(plugin, "DEL", funcs.Del),
+		Check:  wrapCmd(plugin, "CHECK", funcs.Check),
+		GC:     wrapCmd(plugin, "GC", funcs.GC),
+		Status: wrapCmd(plugin, "STATUS", funcs.Status),
+	}
+}
+
+func wrapCmd(plugin, command string, cmd func(*skel.CmdArgs) error) func(*skel.CmdArgs) error {
+	if cmd == nil {
+		return nil
+	}
+	return func(args *skel.CmdArgs) error {
+		conf := struct {
+			Name  string  `json:"name"`
+			Trace *Config `json:"trace"`
+		}{}
+		// Invalid configurations are the command's to report
+		_ = json.Unmarshal(args.StdinData, &conf)
+		exporter := os.Getenv(EnvExporter)
+		if conf.Trace != nil {
+			exporter = conf.Trace.Exporter
+		}
+		if exporter == "" {
+			return cmd(args)
+		}
+
+		traceID, parentID, ok := parseTraceParent(os.Getenv(EnvTraceParent))
+		if !ok {
+			rand.Read(traceID[:])
+			parentID = [8]byte{}
+		}
+		inv := &invocation{traceID: traceID}
+
+		root := inv.start(plugin+" "+command,
+			"cni.command", command,
+			"cni.network", conf.Name,
+			"cni.container_id", args.ContainerID,
+			"cni.ifname", args.IfName,
+			"cni.netns", args.Netns)
+		root.parentID = parentID
+
+		setCurrent(inv)
+		err := cmd(args)
+		setCurrent(nil)
+		root.SetError(err)
+		root.End()
+
+		// Tracing is best-effort; never fail the command over it.
+		if xerr := export(exporter, plugin, inv.ended); xerr != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to export trace: %v\n", plugin, xerr)
+		}
+		return err
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+func readRequests(path string) []otlpRequest {
+	data, err := os.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred())
+	var reqs []otlpRequest
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var req otlpRequest
+		Expect(json.Unmarshal([]byte(line), &req)).To(Succeed())
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+func spansByName(req otlpRequest) map[string]otlpSpan {
+	Expect(req.ResourceSpans).To(HaveLen(1))
+	Expect(req.ResourceSpans[0].ScopeSpans).To(HaveLen(1))
+	spans := map[string]otlpSpan{}
+	for _, s := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+var _ = Describe("trace", func() {
+	var path string
+	var args *skel.CmdArgs
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "traces", "cni.jsonl")
+		args = &skel.CmdArgs{ContainerID: "ctr", Netns: "/var/run/netns/ctr", IfName: "eth0"}
+		args.StdinData = []byte(fmt.Sprintf(`{"name": "test", "trace": {"exporter": "file:%s"}}`, path))
+		GinkgoT().Setenv(EnvExporter, "")
+		os.Unsetenv(EnvExporter)
+		GinkgoT().Setenv(EnvTraceParent, "")
+		os.Unsetenv(EnvTraceParent)
+	})
+
+	It("records nothing without configuration", func() {
+		args.StdinData = []byte(`{"name": "test"}`)
+		funcs := Wrap("test", skel.CNIFuncs{Add: func(*skel.CmdArgs) error {
+			Expect(Start("step")).To(BeNil())
+			Expect(TraceParent()).To(BeEmpty())
+			return nil
+		}})
+		Expect(funcs.Add(args)).To(Succeed())
+		Expect(funcs.Del).To(BeNil())
+		Expect(path).NotTo(BeAnExistingFile())
+	})
+
+	It("exports the spans of the invocation", func() {
+		funcs := Wrap("test", skel.CNIFuncs{
+			Add: func(*skel.CmdArgs) error {
+				parse := Start("parseConfig")
+				parse.End()
+
+				ipam := Start("ipam ADD", "ipam.type", "host-local", "attempt", 1)
+				restore := Delegate()
+				Expect(os.Getenv(EnvTraceParent)).To(Equal(TraceParent()))
+				restore()
+				Expect(os.LookupEnv(EnvTraceParent)).To(BeZero())
+				ipam.SetError(errors.New("no addresses"))
+				ipam.End()
+				return nil
+			},
+			Del: func(*skel.CmdArgs) error {
+				return errors.New("boom")
+			},
+		})
+		Expect(funcs.Add(args)).To(Succeed())
+		Expect(funcs.Del(args)).To(MatchError("boom"))
+
+		reqs := readRequests(path)
+		Expect(reqs).To(HaveLen(2))
+		Expect(reqs[0].ResourceSpans[0].Resource.Attributes[0].Key).To(Equal("service.name"))
+		Expect(*reqs[0].ResourceSpans[0].Resource.Attributes[0].Value.StringValue).To(Equal("test"))
+
+		spans := spansByName(reqs[0])
+		Expect(spans).To(HaveLen(3))
+		root := spans["test ADD"]
+		Expect(root.ParentSpanID).To(BeEmpty())
+		Expect(root.Status).To(BeNil())
+		Expect(root.Attributes).To(ContainElement(otlpAttr{Key: "cni.container_id", Value: attrValue("ctr")}))
+		for _, name := range []string{"parseConfig", "ipam ADD"} {
+			Expect(spans[name].TraceID).To(Equal(root.TraceID))
+			Expect(spans[name].ParentSpanID).To(Equal(root.SpanID))
+		}
+		ipam := spans["ipam ADD"]
+		Expect(ipam.Status).To(Equal(&otlpStatus{Code: statusCodeError, Message: "no addresses"}))
+		Expect(ipam.Attributes).To(Equal([]otlpAttr{
+			{Key: "ipam.type", Value: attrValue("host-local")},
+			{Key: "attempt", Value: attrValue(1)},
+		}))
+		Expect(*ipam.Attributes[1].Value.IntValue).To(Equal("1"))
+
+		spans = spansByName(reqs[1])
+		Expect(spans["test DEL"].Status.Message).To(Equal("boom"))
+		Expect(spans["test DEL"].TraceID).NotTo(Equal(root.TraceID))
+	})
+
+	It("continues the trace of the caller", func() {
+		os.Setenv(EnvTraceParent, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+		args.StdinData = []byte(`{"name": "test"}`)
+		os.Setenv(EnvExporter, "file:"+path)
+		funcs := Wrap("test", skel.CNIFuncs{Add: func(*skel.CmdArgs) error {
+			Expect(TraceParent()).To(HavePrefix("00-0af7651916cd43dd8448eb211c80319c-"))
+			return nil
+		}})
+		Expect(funcs.Add(args)).To(Succeed())
+
+		root := spansByName(readRequests(path)[0])["test ADD"]
+		Expect(root.TraceID).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+		Expect(root.ParentSpanID).To(Equal("b7ad6b7169203331"))
+	})
+
+	It("ignores an invalid trace context", func() {
+		for _, tp := range []string{"", "foo", "00-00000000000000000000000000000000-b7ad6b7169203331-01", "00-0af7651916cd43dd8448eb211c80319c-zzad6b7169203331-01"} {
+			_, _, ok := parseTraceParent(tp)
+			Expect(ok).To(BeFalse(), tp)
+		}
+	})
+
+	It("posts the spans to an OTLP receiver on a unix socket", func() {
+		socket := filepath.Join(GinkgoT().TempDir(), "otlp.sock")
+		l, err := net.Listen("unix", socket)
+		Expect(err).NotTo(HaveOccurred())
+		received := make(chan []byte, 1)
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Path).To(Equal("/v1/traces"))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			body, err := io.ReadAll(r.Body)
+			Expect(err).NotTo(HaveOccurred())
+			received <- body
+		})}
+		go srv.Serve(l)
+		defer srv.Close()
+
+		args.StdinData = []byte(fmt.Sprintf(`{"name": "test", "trace": {"exporter": "unix:%s"}}`, socket))
+		funcs := Wrap("test", skel.CNIFuncs{Add: func(*skel.CmdArgs) error { return nil }})
+		Expect(funcs.Add(args)).To(Succeed())
+
+		var req otlpRequest
+		Expect(json.Unmarshal(<-received, &req)).To(Succeed())
+		Expect(spansByName(req)).To(HaveKey("test ADD"))
+	})
+})
@@ -0,0 +1,117 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// RestorePayload is the input of iptables-restore: commands grouped by
+// table, in the order the tables were first used. Each table is committed
+// as a whole.
+type RestorePayload struct {
+	tables []string
+	lines  map[string][]string
+}
+
+// Add appends commands, as rendered by RestoreRule, to the table.
+func (p *RestorePayload) Add(table string, lines ...string) {
+	if p.lines == nil {
+		p.lines = map[string][]string{}
+	}
+	if _, ok := p.lines[table]; !ok {
+		p.tables = append(p.tables, table)
+	}
+	p.lines[table] = append(p.lines[table], lines...)
+}
+
+// Empty returns whether no table was added to the payload.
+func (p *RestorePayload) Empty() bool {
+	return len(p.tables) == 0
+}
+
+func (p *RestorePayload) String() string {
+	var b strings.Builder
+	for _, table := range p.tables {
+		fmt.Fprintf(&b, "*%s\n", table)
+		for _, line := range p.lines[table] {
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+		b.WriteString("COMMIT\n")
+	}
+	return b.String()
+}
+
+// RestoreRule renders a rule as an iptables-restore command, e.g.
+// RestoreRule("-A", "FORWARD", rule), quoting the arguments that
+// iptables-restore would otherwise split.
+func RestoreRule(op, chain string, rule []string) string {
+	parts := []string{op, chain}
+	for _, arg := range rule {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\#") {
+			arg = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
+
+// restoreBinary returns the iptables-restore binary matching the protocol
+// of ipt.
+func restoreBinary(ipt *iptables.IPTables) (string, error) {
+	name := "iptables-restore"
+	if ipt.Proto() == iptables.ProtocolIPv6 {
+		name = "ip6tables-restore"
+	}
+	return exec.LookPath(name)
+}
+
+// HasIPTablesRestore returns whether iptables-restore is installed for the
+// protocol of ipt.
+func HasIPTablesRestore(ipt *iptables.IPTables) bool {
+	_, err := restoreBinary(ipt)
+	return err == nil
+}
+
+// IPTablesRestore applies the payload with iptables-restore, for the
+// protocol of ipt. The tables are not flushed, so only the chains the
+// payload names are touched, and each table is committed atomically.
+func IPTablesRestore(ipt *iptables.IPTables, payload *RestorePayload) error {
+	if ipt == nil {
+		return fmt.Errorf("failed to restore iptables rules: IPTables was nil")
+	}
+	if payload.Empty() {
+		return nil
+	}
+	path, err := restoreBinary(ipt)
+	if err != nil {
+		return fmt.Errorf("failed to restore iptables rules: %v", err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, "--noflush", "--wait")
+	cmd.Stdin = strings.NewReader(payload.String())
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("iptables-restore payloads", func() {
+	It("quotes the arguments iptables-restore would split", func() {
+		Expect(RestoreRule("-A", "CNI-FORWARD", []string{
+			"-m", "comment", "--comment", `dnat name: "test" id: "abc"`, "-j", "ACCEPT",
+		})).To(Equal(`-A CNI-FORWARD -m comment --comment "dnat name: \"test\" id: \"abc\"" -j ACCEPT`))
+		Expect(RestoreRule("-I", "FORWARD", []string{"1", "-m", "comment", "--comment", "", "-j", "DROP"})).
+			To(Equal(`-I FORWARD 1 -m comment --comment "" -j DROP`))
+	})
+
+	It("groups the commands by table", func() {
+		p := RestorePayload{}
+		Expect(p.Empty()).To(BeTrue())
+		p.Add("filter", ":CNI-IN-1 - [0:0]", "-A CNI-IN-1 -j DROP")
+		p.Add("raw", "-A CNI-CT-ZONES -j CNI-CTZ-1")
+		p.Add("filter", "-A CNI-INGRESS -d 10.0.0.2/32 -j CNI-IN-1")
+		Expect(p.Empty()).To(BeFalse())
+		Expect(p.String()).To(Equal(`*filter
+:CNI-IN-1 - [0:0]
+-A CNI-IN-1 -j DROP
+-A CNI-INGRESS -d 10.0.0.2/32 -j CNI-IN-1
+COMMIT
+*raw
+-A CNI-CT-ZONES -j CNI-CTZ-1
+COMMIT
+`))
+	})
+})
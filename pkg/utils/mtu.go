@@ -0,0 +1,57 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// Plugins that create an interface or change its MTU record the resulting
+// MTU in the "mtu" field of the interface in their result. Plugins later in
+// the chain should use ResultMTU rather than reading the device, so that
+// every plugin in the chain works from the same value.
+
+func findResultInterface(result *current.Result, ifName string, inSandbox bool) *current.Interface {
+	if result == nil {
+		return nil
+	}
+	for _, iface := range result.Interfaces {
+		if iface.Name == ifName && (iface.Sandbox != "") == inSandbox {
+			return iface
+		}
+	}
+	return nil
+}
+
+// ResultMTU returns the MTU recorded in result for the interface ifName,
+// which is looked up in the container sandbox if inSandbox is true and on
+// the host otherwise. It returns 0 if no MTU was recorded.
+func ResultMTU(result *current.Result, ifName string, inSandbox bool) int {
+	if iface := findResultInterface(result, ifName, inSandbox); iface != nil {
+		return iface.Mtu
+	}
+	return 0
+}
+
+// SetResultMTU records mtu for the interface ifName in result. It returns
+// false if result has no such interface.
+func SetResultMTU(result *current.Result, ifName string, inSandbox bool, mtu int) bool {
+	iface := findResultInterface(result, ifName, inSandbox)
+	if iface == nil {
+		return false
+	}
+	iface.Mtu = mtu
+	return true
+}
@@ -0,0 +1,53 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+var _ = Describe("Result MTU", func() {
+	var result *current.Result
+
+	BeforeEach(func() {
+		result = &current.Result{
+			Interfaces: []*current.Interface{
+				{Name: "eth0", Mtu: 1500},
+				{Name: "eth0", Sandbox: "/var/run/netns/test", Mtu: 1450},
+			},
+		}
+	})
+
+	It("distinguishes host and sandbox interfaces", func() {
+		Expect(ResultMTU(result, "eth0", false)).To(Equal(1500))
+		Expect(ResultMTU(result, "eth0", true)).To(Equal(1450))
+	})
+
+	It("returns 0 for unknown interfaces", func() {
+		Expect(ResultMTU(result, "eth1", true)).To(Equal(0))
+		Expect(ResultMTU(nil, "eth0", true)).To(Equal(0))
+	})
+
+	It("records a changed MTU", func() {
+		Expect(SetResultMTU(result, "eth0", true, 9000)).To(BeTrue())
+		Expect(result.Interfaces[1].Mtu).To(Equal(9000))
+		Expect(result.Interfaces[0].Mtu).To(Equal(1500))
+
+		Expect(SetResultMTU(result, "eth1", true, 9000)).To(BeFalse())
+	})
+})
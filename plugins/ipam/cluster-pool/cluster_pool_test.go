@@ -0,0 +1,448 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+// fakeAPI serves the Leases of the Kubernetes API from memory.
+type fakeAPI struct {
+	*httptest.Server
+	token string
+
+	mu     sync.Mutex
+	leases map[string]lease // by namespace/name
+	uid    int
+}
+
+func newFakeAPI(token string) *fakeAPI {
+	api := &fakeAPI{token: token, leases: map[string]lease{}}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.serve))
+	return api
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind": "Status", "code": code, "reason": reason, "message": msg,
+	})
+}
+
+func (api *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+api.token {
+		writeStatus(w, http.StatusUnauthorized, "Unauthorized", "Unauthorized")
+		return
+	}
+	// /apis/coordination.k8s.io/v1/namespaces/<ns>/leases[/<name>]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/apis/coordination.k8s.io/v1/namespaces/"), "/")
+	if len(parts) < 2 || parts[1] != "leases" {
+		writeStatus(w, http.StatusNotFound, "NotFound", "the server could not find the requested resource")
+		return
+	}
+	namespace := parts[0]
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 2:
+		selector := strings.SplitN(r.URL.Query().Get("labelSelector"), "=", 2)
+		list := leaseList{Items: []lease{}}
+		for _, l := range api.leases {
+			if l.Metadata.Namespace == namespace && l.Metadata.Labels[selector[0]] == selector[1] {
+				list.Items = append(list.Items, l)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(list)
+
+	case r.Method == http.MethodPost && len(parts) == 2:
+		var l lease
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		key := namespace + "/" + l.Metadata.Name
+		if _, ok := api.leases[key]; ok {
+			writeStatus(w, http.StatusConflict, "AlreadyExists", fmt.Sprintf("leases.coordination.k8s.io %q already exists", l.Metadata.Name))
+			return
+		}
+		api.uid++
+		l.Metadata.Namespace = namespace
+		l.Metadata.UID = fmt.Sprintf("uid-%d", api.uid)
+		api.leases[key] = l
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(l)
+
+	case r.Method == http.MethodDelete && len(parts) == 3:
+		var opts struct {
+			Preconditions struct {
+				UID string `json:"uid"`
+			} `json:"preconditions"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&opts)
+		key := namespace + "/" + parts[2]
+		l, ok := api.leases[key]
+		if !ok {
+			writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("leases.coordination.k8s.io %q not found", parts[2]))
+			return
+		}
+		if opts.Preconditions.UID != "" && opts.Preconditions.UID != l.Metadata.UID {
+			writeStatus(w, http.StatusConflict, "Conflict", "Precondition failed: UID in precondition does not match")
+			return
+		}
+		delete(api.leases, key)
+		writeStatus(w, http.StatusOK, "", "")
+
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	}
+}
+
+// holders returns the IPs of the leases in the namespace by holder.
+func (api *fakeAPI) holders(namespace string) map[string][]string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	holders := map[string][]string{}
+	for _, l := range api.leases {
+		if l.Metadata.Namespace == namespace {
+			holders[l.Spec.HolderIdentity] = append(holders[l.Spec.HolderIdentity], l.Metadata.Annotations[ipAnnotation])
+		}
+	}
+	for _, ips := range holders {
+		sort.Strings(ips)
+	}
+	return holders
+}
+
+func writeKubeconfig(dir, server, user string) string {
+	path := filepath.Join(dir, "kubeconfig")
+	Expect(os.WriteFile(path, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: cni
+clusters:
+- name: local
+  cluster:
+    server: %s
+contexts:
+- name: cni
+  context:
+    cluster: local
+    user: cni
+users:
+- name: cni
+  user:
+%s
+`, server, user)), 0o600)).To(Succeed())
+	return path
+}
+
+var _ = Describe("cluster-pool", func() {
+	var api *fakeAPI
+	var tmpDir, kubeconfigPath string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "cluster-pool")
+		Expect(err).NotTo(HaveOccurred())
+		api = newFakeAPI("secret")
+		kubeconfigPath = writeKubeconfig(tmpDir, api.URL, "    token: secret")
+	})
+
+	AfterEach(func() {
+		api.Close()
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	netConf := func(node, extra string) []byte {
+		return []byte(fmt.Sprintf(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "macvlan",
+			"master": "eth0",
+			%s
+			"ipam": {
+				"type": "cluster-pool",
+				"kubeconfig": "%s",
+				"nodeName": "%s",
+				"ranges": [
+					[{"subnet": "10.1.2.0/29"}],
+					[{"subnet": "2001:db8:1::/64"}]
+				]
+			}
+		}`, extra, kubeconfigPath, node))
+	}
+	cmdArgs := func(node, containerID string) *skel.CmdArgs {
+		return &skel.CmdArgs{
+			ContainerID: containerID,
+			IfName:      "net1",
+			StdinData:   netConf(node, ""),
+		}
+	}
+	add := func(node, containerID string) (*types100.Result, error) {
+		args := cmdArgs(node, containerID)
+		r, _, err := testutils.CmdAddWithArgs(args, func() error {
+			return cmdAdd(args)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return types100.GetResult(r)
+	}
+	del := func(node, containerID string) error {
+		args := cmdArgs(node, containerID)
+		return testutils.CmdDelWithArgs(args, func() error {
+			return cmdDel(args)
+		})
+	}
+
+	It("allocates distinct IPs to the containers of all nodes", func() {
+		var ips []string
+		for i, node := range []string{"node-a", "node-b", "node-a"} {
+			result, err := add(node, fmt.Sprintf("c%d", i))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.IPs).To(HaveLen(2))
+			ips = append(ips, result.IPs[0].Address.String(), result.IPs[1].Address.String())
+		}
+		Expect(ips).To(Equal([]string{
+			"10.1.2.2/29", "2001:db8:1::2/64",
+			"10.1.2.3/29", "2001:db8:1::3/64",
+			"10.1.2.4/29", "2001:db8:1::4/64",
+		}))
+		Expect(api.holders(defaultNamespace)).To(Equal(map[string][]string{
+			"node-a": {"10.1.2.2", "10.1.2.4", "2001:db8:1::2", "2001:db8:1::4"},
+			"node-b": {"10.1.2.3", "2001:db8:1::3"},
+		}))
+		Expect(api.leases).To(HaveKey("kube-system/mynet.10.1.2.2"))
+		Expect(api.leases).To(HaveKey("kube-system/mynet.2001-0db8-0001-0000-0000-0000-0000-0002"))
+	})
+
+	It("releases only the leases the node holds on DEL", func() {
+		_, err := add("node-a", "c1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(del("node-b", "c1")).To(Succeed())
+		Expect(api.holders(defaultNamespace)).To(HaveKey("node-a"))
+
+		args := cmdArgs("node-a", "c1")
+		Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(Succeed())
+
+		Expect(del("node-a", "c1")).To(Succeed())
+		Expect(api.holders(defaultNamespace)).To(BeEmpty())
+		Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(
+			MatchError("cluster-pool: failed to find address added by container c1"))
+
+		// Releasing twice is not an error
+		Expect(del("node-a", "c1")).To(Succeed())
+	})
+
+	It("skips the IPs reserved by other nodes since it listed the leases", func() {
+		store, err := newStore(&PoolConf{Kubeconfig: kubeconfigPath, PoolName: "mynet", NodeName: "node-a"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.Lock()).To(Succeed())
+		defer store.Unlock()
+
+		_, err = add("node-b", "c1")
+		Expect(err).NotTo(HaveOccurred())
+
+		reserved, err := store.Reserve("c2", "net1", net.ParseIP("10.1.2.2"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(BeFalse())
+		reserved, err = store.Reserve("c2", "net1", net.ParseIP("10.1.2.3"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(BeTrue())
+		Expect(store.GetByID("c2", "net1")).To(Equal([]net.IP{net.ParseIP("10.1.2.3")}))
+	})
+
+	It("fails with ErrPoolExhausted once the shared range is full", func() {
+		// 10.1.2.2-10.1.2.6
+		for i := 0; i < 5; i++ {
+			_, err := add(fmt.Sprintf("node-%d", i%2), fmt.Sprintf("c%d", i))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		args := cmdArgs("node-a", "c5")
+		Expect(cmdStatus(args)).To(MatchError(ContainSubstring("IPAM pool exhausted")))
+
+		_, err := add("node-a", "c5")
+		Expect(cnierrors.Code(err)).To(Equal(cnierrors.ErrPoolExhausted))
+		Expect(api.holders(defaultNamespace)).NotTo(HaveKey("node-a"))
+	})
+
+	It("fails STATUS with ErrPoolNearlyExhausted above the utilizationThreshold", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "c3",
+			IfName:      "net1",
+			StdinData: []byte(fmt.Sprintf(`{
+				"cniVersion": "1.1.0",
+				"name": "mynet",
+				"type": "macvlan",
+				"master": "eth0",
+				"ipam": {
+					"type": "cluster-pool",
+					"kubeconfig": "%s",
+					"nodeName": "node-a",
+					"utilizationThreshold": 50,
+					"ranges": [[{"subnet": "10.1.2.0/29"}]]
+				}
+			}`, kubeconfigPath)),
+		}
+		// 10.1.2.2-10.1.2.6
+		for i := 0; i < 2; i++ {
+			_, err := add(fmt.Sprintf("node-%d", i%2), fmt.Sprintf("c%d", i))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(cmdStatus(args)).To(Succeed())
+
+		_, err := add("node-b", "c2")
+		Expect(err).NotTo(HaveOccurred())
+		err = cmdStatus(args)
+		Expect(cnierrors.Code(err)).To(Equal(cnierrors.ErrPoolNearlyExhausted))
+		Expect(err.(*types.Error).Details).To(Equal(
+			"3 of 5 addresses of range set 0 (10.1.2.1-10.1.2.6) are allocated, above the utilizationThreshold of 50%"))
+
+		// ADD still succeeds
+		_, _, err = testutils.CmdAddWithArgs(args, func() error {
+			return cmdAdd(args)
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("fails with ErrBackendUnavailable when the API is unreachable", func() {
+		api.Close()
+
+		_, err := add("node-a", "c1")
+		Expect(cnierrors.Code(err)).To(Equal(cnierrors.ErrBackendUnavailable))
+		Expect(cnierrors.Code(del("node-a", "c1"))).To(Equal(cnierrors.ErrBackendUnavailable))
+
+		err = cmdStatus(cmdArgs("node-a", "c1"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*types.Error).Msg).To(Equal("Kubernetes API is not reachable"))
+	})
+
+	It("releases the leases of invalid attachments of the node on GC", func() {
+		for i, node := range []string{"node-a", "node-a", "node-b"} {
+			_, err := add(node, fmt.Sprintf("c%d", i))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		args := &skel.CmdArgs{
+			StdinData: netConf("node-a", `"cni.dev/valid-attachments": [{"containerID": "c1", "ifname": "net1"}],`),
+		}
+		Expect(cmdGC(args)).To(Succeed())
+		Expect(api.holders(defaultNamespace)).To(Equal(map[string][]string{
+			"node-a": {"10.1.2.3", "2001:db8:1::3"},
+			"node-b": {"10.1.2.4", "2001:db8:1::4"},
+		}))
+	})
+
+	It("shares the IPs of networks with the same poolName", func() {
+		conf := func(name string) []byte {
+			return []byte(fmt.Sprintf(`{
+				"cniVersion": "1.1.0",
+				"name": "%s",
+				"type": "ipvlan",
+				"ipam": {
+					"type": "cluster-pool",
+					"kubeconfig": "%s",
+					"namespace": "cni",
+					"poolName": "flat",
+					"nodeName": "node-a",
+					"subnet": "10.1.2.0/24"
+				}
+			}`, name, kubeconfigPath))
+		}
+		var ips []string
+		for i, name := range []string{"net-a", "net-b"} {
+			args := &skel.CmdArgs{ContainerID: fmt.Sprintf("c%d", i), IfName: "net1", StdinData: conf(name)}
+			r, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+			Expect(err).NotTo(HaveOccurred())
+			result, err := types100.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			ips = append(ips, result.IPs[0].Address.String())
+		}
+		Expect(ips).To(Equal([]string{"10.1.2.2/24", "10.1.2.3/24"}))
+		Expect(api.leases).To(HaveKey("cni/flat.10.1.2.2"))
+	})
+
+	It("rejects invalid configurations", func() {
+		_, _, _, err := loadConf([]byte(`{"name": "mynet", "ipam": {"subnet": "10.1.2.0/24"}}`), "")
+		Expect(err).To(MatchError("kubeconfig is required"))
+
+		_, _, _, err = loadConf([]byte(`{"name": "My_Net", "ipam": {"kubeconfig": "k", "subnet": "10.1.2.0/24"}}`), "")
+		Expect(err).To(MatchError(`network name "My_Net" is not a valid pool name, set poolName`))
+
+		_, _, _, err = loadConf([]byte(`{"name": "mynet", "ipam": {"kubeconfig": "k", "poolName": "a.b", "subnet": "10.1.2.0/24"}}`), "")
+		Expect(err).To(MatchError(`invalid poolName "a.b" (must be a DNS label)`))
+	})
+
+	Context("kubeconfig", func() {
+		It("reads the token file and namespace of the current context, relative to the kubeconfig", func() {
+			Expect(os.WriteFile(filepath.Join(tmpDir, "token"), []byte("secret\n"), 0o600)).To(Succeed())
+			path := writeKubeconfig(tmpDir, api.URL+"/", "    tokenFile: token")
+			data, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			data = []byte(strings.Replace(string(data), "    user: cni\n", "    user: cni\n    namespace: cni\n", 1))
+			Expect(os.WriteFile(path, data, 0o600)).To(Succeed())
+
+			client, err := newKubeClient(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.server).To(Equal(api.URL))
+			Expect(client.token).To(Equal("secret"))
+			Expect(client.namespace).To(Equal("cni"))
+
+			_, err = add("node-a", "c1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(api.holders("cni")).To(HaveKey("node-a"))
+		})
+
+		It("reports the API errors", func() {
+			path := writeKubeconfig(tmpDir, api.URL, "    token: wrong")
+			client, err := newKubeClient(path)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.listLeases("cni", "a=b")
+			Expect(err).To(MatchError("GET /apis/coordination.k8s.io/v1/namespaces/cni/leases: Unauthorized"))
+			Expect(isStatus(err, http.StatusUnauthorized)).To(BeTrue())
+		})
+
+		It("rejects credential plugins and missing contexts", func() {
+			path := writeKubeconfig(tmpDir, api.URL, "    exec:\n      command: get-token")
+			_, err := newKubeClient(path)
+			Expect(err).To(MatchError(fmt.Sprintf(`user "cni" of kubeconfig %s uses a credential plugin, which is not supported`, path)))
+
+			Expect(os.WriteFile(path, []byte("current-context: other\n"), 0o600)).To(Succeed())
+			_, err = newKubeClient(path)
+			Expect(err).To(MatchError(fmt.Sprintf(`kubeconfig %s has no context "other"`, path)))
+		})
+	})
+})
@@ -0,0 +1,330 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// apiTimeout bounds each request to the Kubernetes API.
+const apiTimeout = 10 * time.Second
+
+// kubeconfig is the subset of a kubeconfig file the plugin understands.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string                 `yaml:"token"`
+			TokenFile             string                 `yaml:"tokenFile"`
+			ClientCertificate     string                 `yaml:"client-certificate"`
+			ClientCertificateData string                 `yaml:"client-certificate-data"`
+			ClientKey             string                 `yaml:"client-key"`
+			ClientKeyData         string                 `yaml:"client-key-data"`
+			Exec                  map[string]interface{} `yaml:"exec"`
+			AuthProvider          map[string]interface{} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// kubeClient is a minimal client of the Kubernetes API, for the Leases of
+// one namespace.
+type kubeClient struct {
+	server    string
+	token     string
+	namespace string // The namespace of the kubeconfig context, if any
+	http      *http.Client
+}
+
+// newKubeClient returns a client for the current context of the kubeconfig
+// file at path. Credential plugins are not supported.
+func newKubeClient(path string) (*kubeClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %v", err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig %s: %v", path, err)
+	}
+	dir := filepath.Dir(path)
+
+	ctxIdx := -1
+	for i, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			ctxIdx = i
+		}
+	}
+	if ctxIdx < 0 {
+		return nil, fmt.Errorf("kubeconfig %s has no context %q", path, kc.CurrentContext)
+	}
+	ctx := kc.Contexts[ctxIdx].Context
+
+	client := &kubeClient{namespace: ctx.Namespace}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	found := false
+	for _, c := range kc.Clusters {
+		if c.Name != ctx.Cluster {
+			continue
+		}
+		found = true
+		client.server = strings.TrimSuffix(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca, err := fileOrData(dir, c.Cluster.CertificateAuthority, c.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the certificate authority of cluster %q: %v", c.Name, err)
+		}
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("invalid certificate authority of cluster %q", c.Name)
+			}
+		}
+	}
+	if !found || client.server == "" {
+		return nil, fmt.Errorf("kubeconfig %s has no server for cluster %q", path, ctx.Cluster)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != ctx.User {
+			continue
+		}
+		if u.User.Exec != nil || u.User.AuthProvider != nil {
+			return nil, fmt.Errorf("user %q of kubeconfig %s uses a credential plugin, which is not supported", u.Name, path)
+		}
+		client.token = u.User.Token
+		if u.User.TokenFile != "" {
+			token, err := os.ReadFile(resolve(dir, u.User.TokenFile))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the token of user %q: %v", u.Name, err)
+			}
+			client.token = strings.TrimSpace(string(token))
+		}
+		cert, err := fileOrData(dir, u.User.ClientCertificate, u.User.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the client certificate of user %q: %v", u.Name, err)
+		}
+		key, err := fileOrData(dir, u.User.ClientKey, u.User.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the client key of user %q: %v", u.Name, err)
+		}
+		if cert != nil || key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate of user %q: %v", u.Name, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	client.http = &http.Client{
+		Timeout:   apiTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+	return client, nil
+}
+
+// fileOrData returns the base64-encoded data, or else the contents of file,
+// relative to dir. It returns nil if neither is set.
+func fileOrData(dir, file, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return os.ReadFile(resolve(dir, file))
+	}
+	return nil, nil
+}
+
+// resolve returns path, relative to dir unless absolute, as in kubeconfig
+// files.
+func resolve(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// lease is a coordination.k8s.io/v1 Lease.
+type lease struct {
+	APIVersion string     `json:"apiVersion,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       leaseSpec  `json:"spec"`
+}
+
+type objectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	UID             string            `json:"uid,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity string `json:"holderIdentity,omitempty"`
+	// AcquireTime is a MicroTime, in RFC 3339 with microseconds
+	AcquireTime string `json:"acquireTime,omitempty"`
+}
+
+type leaseList struct {
+	Items []lease `json:"items"`
+}
+
+// microTime is the format of the MicroTime fields of the API.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// apiError is an error status returned by the API.
+type apiError struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.Code, http.StatusText(e.Code))
+	}
+	return e.Message
+}
+
+// isStatus returns whether err is an API error with the HTTP status code.
+func isStatus(err error, code int) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+func (c *kubeClient) leasesURL(namespace string) string {
+	return fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", c.server, url.PathEscape(namespace))
+}
+
+// listLeases returns the leases of the namespace matching the label
+// selector.
+func (c *kubeClient) listLeases(namespace, selector string) ([]lease, error) {
+	var list leaseList
+	u := c.leasesURL(namespace) + "?labelSelector=" + url.QueryEscape(selector)
+	if err := c.do(http.MethodGet, u, nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// createLease creates l, and returns it as created. It fails with a 409
+// Conflict status if a lease of the same name exists.
+func (c *kubeClient) createLease(l *lease) (*lease, error) {
+	l.APIVersion, l.Kind = "coordination.k8s.io/v1", "Lease"
+	var created lease
+	if err := c.do(http.MethodPost, c.leasesURL(l.Metadata.Namespace), l, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// deleteLease deletes the lease l, unless it was recreated since it was
+// read. A lease already gone is not an error.
+func (c *kubeClient) deleteLease(l *lease) error {
+	opts := map[string]interface{}{
+		"apiVersion":    "v1",
+		"kind":          "DeleteOptions",
+		"preconditions": map[string]string{"uid": l.Metadata.UID},
+	}
+	err := c.do(http.MethodDelete, c.leasesURL(l.Metadata.Namespace)+"/"+url.PathEscape(l.Metadata.Name), opts, nil)
+	if isStatus(err, http.StatusNotFound) || isStatus(err, http.StatusConflict) {
+		return nil
+	}
+	return err
+}
+
+// do sends a request with the JSON body in, if not nil, and decodes the
+// JSON response into out, if not nil.
+func (c *kubeClient) do(method, u string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &apiError{}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Code == 0 {
+			apiErr = &apiError{Message: strings.TrimSpace(string(data))}
+		}
+		apiErr.Code = resp.StatusCode
+		return fmt.Errorf("%s %s: %w", method, req.URL.Path, apiErr)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%s %s: invalid response: %v", method, req.URL.Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// cluster-pool allocates IPs from ranges shared by the nodes of a Kubernetes
+// cluster, such as the flat ranges of macvlan and ipvlan networks, keeping
+// the reservations as Leases of the Kubernetes API.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
+	cnilog "github.com/containernetworking/plugins/pkg/log"
+	"github.com/containernetworking/plugins/pkg/trace"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
+)
+
+const defaultNamespace = "kube-system"
+
+// PoolConf is the configuration of the pool, besides the ranges and routes
+// of the IPAM configuration, which are those of host-local.
+type PoolConf struct {
+	// Kubeconfig is the path of the kubeconfig file to reach the API with
+	Kubeconfig string `json:"kubeconfig"`
+	// Namespace is that of the leases, by default the one of the kubeconfig
+	// context, or else kube-system
+	Namespace string `json:"namespace,omitempty"`
+	// PoolName identifies the ranges, by default the network name. Networks
+	// with the same pool name share their IPs.
+	PoolName string `json:"poolName,omitempty"`
+	// NodeName is the holder of the leases of the node, by default its
+	// hostname
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+type netConf struct {
+	Name string    `json:"name"`
+	IPAM *PoolConf `json:"ipam"`
+}
+
+// dnsLabel matches the names valid as pool names.
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// loadConf returns the IPAM configuration, the pool configuration and the
+// CNI version of the network configuration.
+func loadConf(stdin []byte, envArgs string) (*allocator.IPAMConfig, *PoolConf, string, error) {
+	ipamConf, confVersion, err := allocator.LoadIPAMConfig(stdin, envArgs)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	n := netConf{}
+	if err := json.Unmarshal(stdin, &n); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to load netconf: %v", err)
+	}
+	conf := n.IPAM
+
+	if conf.Kubeconfig == "" {
+		return nil, nil, "", fmt.Errorf("kubeconfig is required")
+	}
+	if conf.PoolName == "" {
+		if !dnsLabel.MatchString(n.Name) || len(n.Name) > 63 {
+			return nil, nil, "", fmt.Errorf("network name %q is not a valid pool name, set poolName", n.Name)
+		}
+		conf.PoolName = n.Name
+	} else if !dnsLabel.MatchString(conf.PoolName) || len(conf.PoolName) > 63 {
+		return nil, nil, "", fmt.Errorf("invalid poolName %q (must be a DNS label)", conf.PoolName)
+	}
+	if conf.NodeName == "" {
+		conf.NodeName, err = os.Hostname()
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to get the node name: %v", err)
+		}
+	}
+	return ipamConf, conf, confVersion, nil
+}
+
+// newStore returns the store of the pool.
+func newStore(conf *PoolConf) (*leaseStore, error) {
+	client, err := newKubeClient(conf.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	namespace := conf.Namespace
+	if namespace == "" {
+		namespace = client.namespace
+	}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return &leaseStore{
+		client:    client,
+		namespace: namespace,
+		pool:      conf.PoolName,
+		node:      conf.NodeName,
+	}, nil
+}
+
+func main() {
+	skel.PluginMainFuncs(cnilog.Wrap("cluster-pool", trace.Wrap("cluster-pool", skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		GC:     cmdGC,
+		Status: cmdStatus,
+	})), version.All, bv.BuildString("cluster-pool"))
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	ipamConf, conf, confVersion, err := loadConf(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+
+	store, err := newStore(conf)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	result := &current.Result{CNIVersion: current.ImplementedSpecVersion}
+
+	// Keep the allocators we used, so we can release all IPs if an error
+	// occurs after we start allocating
+	allocs := []*allocator.IPAllocator{}
+
+	// Store all requested IPs in a map, so we can easily remove ones we use
+	// and error if some remain
+	requestedIPs := map[string]net.IP{} // net.IP cannot be a key
+	for _, ip := range ipamConf.IPArgs {
+		requestedIPs[ip.String()] = ip
+	}
+
+	for idx, rangeset := range ipamConf.Ranges {
+		if !ipamConf.RangeSelected(idx) {
+			continue
+		}
+		allocator := allocator.NewIPAllocator(&rangeset, store, idx)
+
+		// Check to see if there are any custom IPs requested in this range.
+		var requestedIP net.IP
+		for k, ip := range requestedIPs {
+			if rangeset.Contains(ip) {
+				requestedIP = ip
+				delete(requestedIPs, k)
+				break
+			}
+		}
+
+		ipConf, err := allocator.Get(args.ContainerID, args.IfName, requestedIP)
+		if err != nil {
+			// Deallocate all already allocated IPs
+			for _, alloc := range allocs {
+				_ = alloc.Release(args.ContainerID, args.IfName)
+			}
+			return fmt.Errorf("failed to allocate for range %d: %w", idx, err)
+		}
+		allocs = append(allocs, allocator)
+		result.IPs = append(result.IPs, ipConf)
+	}
+
+	// If an IP was requested that wasn't fulfilled, fail
+	if len(requestedIPs) != 0 {
+		for _, alloc := range allocs {
+			_ = alloc.Release(args.ContainerID, args.IfName)
+		}
+		errstr := "failed to allocate all requested IPs:"
+		for _, ip := range requestedIPs {
+			errstr = errstr + " " + ip.String()
+		}
+		return errors.New(errstr)
+	}
+
+	cnilog.Debug("allocated addresses", "ips", result.IPs, "pool", conf.PoolName)
+	result.Routes = ipamConf.Routes
+
+	return types.PrintResult(result, confVersion)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	_, conf, _, err := loadConf(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+	store, err := newStore(conf)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock()
+	if len(store.GetByID(args.ContainerID, args.IfName)) == 0 {
+		return fmt.Errorf("cluster-pool: failed to find address added by container %v", args.ContainerID)
+	}
+	return nil
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	_, conf, _, err := loadConf(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+	store, err := newStore(conf)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// The leases of all the range sets are released at once
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock()
+	return store.ReleaseByID(args.ContainerID, args.IfName)
+}
+
+// cmdGC releases the IPs held by the node that are not allocated to one of
+// the valid attachments passed by the runtime. The IPs held by other nodes
+// are left to them.
+func cmdGC(args *skel.CmdArgs) error {
+	_, conf, _, err := loadConf(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+	// The valid attachments are passed in the network config, not the IPAM one
+	valid, err := gc.ParseAttachments(args.StdinData)
+	if err != nil {
+		return err
+	}
+	store, err := newStore(conf)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock()
+	return store.GC(valid.Valid)
+}
+
+// cmdStatus reports whether an ADD can succeed: the API must be reachable,
+// and no range set exhausted. Range sets above the utilizationThreshold are
+// reported too, though ADD still succeeds.
+func cmdStatus(args *skel.CmdArgs) error {
+	ipamConf, conf, _, err := loadConf(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+	store, err := newStore(conf)
+	if err != nil {
+		return types.NewError(cnierrors.ErrPluginNotAvailable, "cluster-pool kubeconfig is not usable", err.Error())
+	}
+	defer store.Close()
+
+	if err := store.Lock(); err != nil {
+		return types.NewError(cnierrors.ErrPluginNotAvailable, "Kubernetes API is not reachable", err.Error())
+	}
+	allocated, _ := store.AllocatedIPs()
+	store.Unlock()
+
+	return ipamConf.CheckUtilization(allocated)
+}
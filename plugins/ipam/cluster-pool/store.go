@@ -0,0 +1,217 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+)
+
+const (
+	poolLabel           = "cni.dev/pool"
+	rangeLabel          = "cni.dev/range"
+	ipAnnotation        = "cni.dev/ip"
+	containerAnnotation = "cni.dev/container-id"
+	ifNameAnnotation    = "cni.dev/ifname"
+)
+
+// leaseStore is a backend.Store keeping an IP reservation per Lease of the
+// Kubernetes API, shared by the nodes allocating from the pool. Creating a
+// lease fails if it exists, which makes reservations atomic across nodes.
+// The leases are held by the node that created them.
+type leaseStore struct {
+	client    *kubeClient
+	namespace string
+	pool      string
+	node      string
+
+	// The leases of the pool by IP, listed when the store is locked
+	leases map[string]*lease
+	// The error listing them, returned by the operations that need them
+	err error
+}
+
+// leaseName returns the name of the lease of ip in pool, a DNS subdomain.
+func leaseName(pool string, ip net.IP) string {
+	if ip.To4() != nil {
+		return pool + "." + ip.String()
+	}
+	addr, _ := netip.AddrFromSlice(ip.To16())
+	return pool + "." + strings.ReplaceAll(addr.StringExpanded(), ":", "-")
+}
+
+// list returns the leases of the pool by IP.
+func (s *leaseStore) list() (map[string]*lease, error) {
+	items, err := s.client.listLeases(s.namespace, poolLabel+"="+s.pool)
+	if err != nil {
+		return nil, cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to list the leases of pool %q", s.pool)
+	}
+	leases := make(map[string]*lease, len(items))
+	for i := range items {
+		l := &items[i]
+		if ip := net.ParseIP(l.Metadata.Annotations[ipAnnotation]); ip != nil {
+			leases[ip.String()] = l
+		}
+	}
+	return leases, nil
+}
+
+func (s *leaseStore) Lock() error {
+	s.leases, s.err = s.list()
+	return s.err
+}
+
+func (s *leaseStore) Unlock() error {
+	s.leases, s.err = nil, nil
+	return nil
+}
+
+func (s *leaseStore) Close() error {
+	return nil
+}
+
+// owns returns whether l is the lease of the attachment of ifname to the
+// container id on this node.
+func (s *leaseStore) owns(l *lease, id, ifname string) bool {
+	return l.Spec.HolderIdentity == s.node &&
+		l.Metadata.Annotations[containerAnnotation] == id &&
+		l.Metadata.Annotations[ifNameAnnotation] == ifname
+}
+
+func (s *leaseStore) Reserve(id string, ifname string, ip net.IP, rangeID string) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	if _, ok := s.leases[ip.String()]; ok {
+		return false, nil
+	}
+
+	l := &lease{
+		Metadata: objectMeta{
+			Name:      leaseName(s.pool, ip),
+			Namespace: s.namespace,
+			Labels:    map[string]string{poolLabel: s.pool, rangeLabel: rangeID},
+			Annotations: map[string]string{
+				ipAnnotation:        ip.String(),
+				containerAnnotation: id,
+				ifNameAnnotation:    ifname,
+			},
+		},
+		Spec: leaseSpec{
+			HolderIdentity: s.node,
+			AcquireTime:    time.Now().UTC().Format(microTime),
+		},
+	}
+	created, err := s.client.createLease(l)
+	if isStatus(err, http.StatusConflict) {
+		// Reserved by another node since the leases were listed, by whom
+		// is unknown
+		if s.leases != nil {
+			s.leases[ip.String()] = &lease{Metadata: objectMeta{Name: l.Metadata.Name}}
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to create the lease of %s", ip)
+	}
+	if s.leases != nil {
+		s.leases[ip.String()] = created
+	}
+	return true, nil
+}
+
+// LastReservedIP returns the IP of the range acquired last by any node, so
+// that the nodes allocate from a range round-robin together.
+func (s *leaseStore) LastReservedIP(rangeID string) (net.IP, error) {
+	var last net.IP
+	var lastTime time.Time
+	for _, l := range s.leases {
+		if l.Metadata.Labels[rangeLabel] != rangeID {
+			continue
+		}
+		t, err := time.Parse(microTime, l.Spec.AcquireTime)
+		if err != nil {
+			continue
+		}
+		if last == nil || t.After(lastTime) {
+			last, lastTime = net.ParseIP(l.Metadata.Annotations[ipAnnotation]), t
+		}
+	}
+	return last, nil
+}
+
+func (s *leaseStore) ReleaseByID(id string, ifname string) error {
+	if s.err != nil {
+		return s.err
+	}
+	var errs []error
+	for _, l := range s.leases {
+		if !s.owns(l, id, ifname) {
+			continue
+		}
+		if err := s.client.deleteLease(l); err != nil {
+			errs = append(errs, cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to delete lease %s", l.Metadata.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *leaseStore) GetByID(id string, ifname string) []net.IP {
+	var ips []net.IP
+	for key, l := range s.leases {
+		if s.owns(l, id, ifname) {
+			ips = append(ips, net.ParseIP(key))
+		}
+	}
+	return ips
+}
+
+// AllocatedIPs returns the IPs reserved in the pool by all nodes.
+func (s *leaseStore) AllocatedIPs() ([]net.IP, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	ips := make([]net.IP, 0, len(s.leases))
+	for key := range s.leases {
+		ips = append(ips, net.ParseIP(key))
+	}
+	return ips, nil
+}
+
+// GC deletes the leases of this node whose attachment is not valid. The
+// store must be locked.
+func (s *leaseStore) GC(valid func(id, ifname string) bool) error {
+	if s.err != nil {
+		return s.err
+	}
+	var errs []error
+	for _, l := range s.leases {
+		id, ifname := l.Metadata.Annotations[containerAnnotation], l.Metadata.Annotations[ifNameAnnotation]
+		if l.Spec.HolderIdentity != s.node || valid(id, ifname) {
+			continue
+		}
+		if err := s.client.deleteLease(l); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete lease %s: %v", l.Metadata.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
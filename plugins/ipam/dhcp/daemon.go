@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"net/rpc"
@@ -31,26 +32,44 @@ import (
 	"time"
 
 	"github.com/coreos/go-systemd/v22/activation"
+	dhcp4 "github.com/insomniacslk/dhcp/dhcpv4"
 
 	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 )
 
 var errNoMoreTries = errors.New("no more tries")
 
+// lease is a DHCPv4 or DHCPv6 lease maintained by the daemon
+type lease interface {
+	Check()
+	Stop()
+	IPNet() (*net.IPNet, error)
+	Gateway() net.IP
+	Routes() []*types.Route
+	DNS() types.DNS
+	MTU() int
+	ExpireTime() time.Time
+	RenewalFailures() uint64
+	Info() LeaseInfo
+	Detach()
+}
+
 type DHCP struct {
 	mux                 sync.Mutex
-	leases              map[string]*DHCPLease
+	leases              map[string]lease
 	hostNetnsPrefix     string
 	clientTimeout       time.Duration
 	clientResendMax     time.Duration
 	clientResendTimeout time.Duration
 	broadcast           bool
+	store               *leaseStore
 }
 
 func newDHCP(clientTimeout, clientResendMax time.Duration, resendTimeout time.Duration) *DHCP {
 	return &DHCP{
-		leases:              make(map[string]*DHCPLease),
+		leases:              make(map[string]lease),
 		clientTimeout:       clientTimeout,
 		clientResendMax:     clientResendMax,
 		clientResendTimeout: resendTimeout,
@@ -76,48 +95,151 @@ func (d *DHCP) Allocate(args *skel.CmdArgs, result *current.Result) error {
 		return fmt.Errorf("error parsing netconf: %v", err)
 	}
 
-	opts, err := prepareOptions(args.Args, conf.IPAM.ProvideOptions, conf.IPAM.RequestOptions)
+	opts, dhcpClientID, err := leaseOptions(args, &conf)
 	if err != nil {
 		return err
 	}
+	allowedServers, err := conf.IPAM.allowedServers()
+	if err != nil {
+		return err
+	}
+
+	if !conf.IPAM.ipv4Enabled() && !conf.IPAM.EnableIPv6 {
+		return fmt.Errorf("at least one of enableIPv4 and enableIPv6 must be set")
+	}
 
+	// Leases are tracked by the generated client ID, whatever the
+	// identifier sent to the server
 	clientID := generateClientID(args.ContainerID, conf.Name, args.IfName)
+	hostNetns := d.hostNetnsPrefix + args.Netns
+
+	if conf.IPAM.ipv4Enabled() {
+		l, err := d.allocateLease(clientID, func() (lease, error) {
+			return AcquireLease(dhcpClientID, hostNetns, args.IfName,
+				opts, allowedServers,
+				d.clientTimeout, d.clientResendMax, d.clientResendTimeout, d.broadcastFor(&conf),
+				d.persister(clientID, args), d.renewalHook(clientID, args, &conf))
+		})
+		if err != nil {
+			return err
+		}
+		addLeaseToResult(result, l, conf.IPAM.ResultOptions)
+	}
+	if conf.IPAM.EnableIPv6 {
+		l, err := d.allocateLease(clientIDv6(clientID), func() (lease, error) {
+			return AcquireLease6(dhcpClientID, hostNetns, args.IfName,
+				allowedServers,
+				d.clientTimeout, d.clientResendMax, d.clientResendTimeout,
+				d.persister(clientIDv6(clientID), args), d.renewalHook(clientIDv6(clientID), args, &conf))
+		})
+		if err != nil {
+			return err
+		}
+		addLeaseToResult(result, l, conf.IPAM.ResultOptions)
+	}
+	if conf.IPAM.Priority != 0 {
+		for _, r := range result.Routes {
+			r.Priority = conf.IPAM.Priority
+		}
+	}
+
+	return nil
+}
+
+// leaseOptions returns the DHCPv4 options of the leases of args, and the
+// client identifier sent to the server.
+func leaseOptions(args *skel.CmdArgs, conf *NetConf) ([]dhcp4.Option, string, error) {
+	cniArgs := parseCNIArgs(args.Args)
+	opts, err := prepareOptions(cniArgs, conf.IPAM.ProvideOptions, conf.IPAM.RequestOptions, conf.IPAM.ResultOptions)
+	if err != nil {
+		return nil, "", err
+	}
+	if conf.IPAM.Hostname != "" {
+		hostname, err := expandTemplate(conf.IPAM.Hostname, args, conf.Name, cniArgs)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(hostname) > 255 {
+			return nil, "", fmt.Errorf("hostname too long: %q", hostname)
+		}
+		opts = append(opts, dhcp4.OptHostName(hostname))
+	}
+	if conf.IPAM.VendorClass != "" {
+		if len(conf.IPAM.VendorClass) > 255 {
+			return nil, "", fmt.Errorf("vendorClass too long: %q", conf.IPAM.VendorClass)
+		}
+		opts = append(opts, dhcp4.OptClassIdentifier(conf.IPAM.VendorClass))
+	}
+
+	dhcpClientID := generateClientID(args.ContainerID, conf.Name, args.IfName)
+	if conf.IPAM.ClientID != "" {
+		if dhcpClientID, err = expandTemplate(conf.IPAM.ClientID, args, conf.Name, cniArgs); err != nil {
+			return nil, "", err
+		}
+		// See generateClientID
+		if len(dhcpClientID) > 254 {
+			dhcpClientID = dhcpClientID[0:254]
+		}
+	}
+	return opts, dhcpClientID, nil
+}
 
-	// If we already have an active lease for this clientID, do not create
+// allocateLease returns the active lease of key, or the lease acquired by
+// acquire if there is none.
+func (d *DHCP) allocateLease(key string, acquire func() (lease, error)) (lease, error) {
+	// If we already have an active lease for this key, do not create
 	// another one
-	l := d.getLease(clientID)
+	l := d.getLease(key)
 	if l != nil {
 		l.Check()
 	} else {
-		hostNetns := d.hostNetnsPrefix + args.Netns
-		l, err = AcquireLease(clientID, hostNetns, args.IfName,
-			opts,
-			d.clientTimeout, d.clientResendMax, d.clientResendTimeout, d.broadcast)
+		var err error
+		l, err = acquire()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	ipn, err := l.IPNet()
-	if err != nil {
+	if _, err := l.IPNet(); err != nil {
 		l.Stop()
-		return err
+		return nil, err
 	}
 
-	d.setLease(clientID, l)
+	d.setLease(key, l)
+	return l, nil
+}
 
-	result.IPs = []*current.IPConfig{{
+func addLeaseToResult(result *current.Result, l lease, resultOptions []string) {
+	ipn, _ := l.IPNet()
+	result.IPs = append(result.IPs, &current.IPConfig{
 		Address: *ipn,
 		Gateway: l.Gateway(),
-	}}
-	result.Routes = l.Routes()
-	if conf.IPAM.Priority != 0 {
-		for _, r := range result.Routes {
-			r.Priority = conf.IPAM.Priority
+	})
+	routes := l.Routes()
+	for _, opt := range resultOptions {
+		switch opt {
+		case "dns":
+			dns := l.DNS()
+			result.DNS.Nameservers = append(result.DNS.Nameservers, dns.Nameservers...)
+			if result.DNS.Domain == "" {
+				result.DNS.Domain = dns.Domain
+			}
+			result.DNS.Search = append(result.DNS.Search, dns.Search...)
+		case "mtu":
+			if mtu := l.MTU(); mtu > 0 {
+				for _, r := range routes {
+					r.MTU = mtu
+				}
+			}
 		}
 	}
+	result.Routes = append(result.Routes, routes...)
+}
 
-	return nil
+// clientIDv6 returns the key of the DHCPv6 lease of clientID, which does not
+// collide with its DHCPv4 lease.
+func clientIDv6(clientID string) string {
+	return clientID + "/ipv6"
 }
 
 // Release stops maintenance of the lease acquired in Allocate()
@@ -129,15 +251,142 @@ func (d *DHCP) Release(args *skel.CmdArgs, _ *struct{}) error {
 	}
 
 	clientID := generateClientID(args.ContainerID, conf.Name, args.IfName)
-	if l := d.getLease(clientID); l != nil {
-		l.Stop()
-		d.clearLease(clientID)
+	for _, key := range []string{clientID, clientIDv6(clientID)} {
+		if l := d.getLease(key); l != nil {
+			l.Stop()
+			d.clearLease(key)
+		}
+		if err := d.store.remove(key); err != nil {
+			log.Printf("%v: failed to remove lease record: %v", key, err)
+		}
+	}
+
+	return nil
+}
+
+// persister returns the hook recording the lease of key, allocated for args,
+// in the store.
+func (d *DHCP) persister(key string, args *skel.CmdArgs) func(*leaseRecord) {
+	if d.store == nil {
+		return nil
+	}
+	return func(rec *leaseRecord) {
+		rec.Key = key
+		rec.Args = args
+		if err := d.store.save(rec); err != nil {
+			log.Printf("%v: failed to persist lease: %v", key, err)
+		}
+	}
+}
+
+// resumeLeases resumes maintaining the leases of the store whose network
+// namespace still exists, and forgets the others.
+func (d *DHCP) resumeLeases() {
+	recs, err := d.store.load()
+	if err != nil {
+		log.Printf("error loading leases: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, rec := range recs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.resumeLease(rec); err != nil {
+				log.Printf("%v: not resuming lease: %v", rec.Key, err)
+				if err := d.store.remove(rec.Key); err != nil {
+					log.Printf("%v: failed to remove lease record: %v", rec.Key, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *DHCP) resumeLease(rec *leaseRecord) error {
+	hostNetns := d.hostNetnsPrefix + rec.Args.Netns
+	if _, err := os.Stat(hostNetns); err != nil {
+		return err
+	}
+	l, err := d.restoreLease(rec)
+	if err != nil {
+		return err
 	}
 
+	log.Printf("%v: resuming lease, expiration is %v", rec.Key, rec.ExpireTime)
+
+	if err := l.start(hostNetns, rec.Args.IfName, time.Now().After(rec.ExpireTime)); err != nil {
+		return err
+	}
+	d.setLease(rec.Key, l)
 	return nil
 }
 
-func (d *DHCP) getLease(clientID string) *DHCPLease {
+// restoredLease is a lease restored from its record, not maintained yet
+type restoredLease interface {
+	lease
+	inNetNS(netns, ifName string, f func() error) error
+	start(netns, ifName string, acquire bool) error
+	renewDue() error
+	release() error
+}
+
+// restoreLease returns the lease of rec.
+func (d *DHCP) restoreLease(rec *leaseRecord) (restoredLease, error) {
+	conf := NetConf{}
+	if err := json.Unmarshal(rec.Args.StdinData, &conf); err != nil {
+		return nil, fmt.Errorf("error parsing netconf: %v", err)
+	}
+	allowedServers, err := conf.IPAM.allowedServers()
+	if err != nil {
+		return nil, err
+	}
+	persist := d.persister(rec.Key, rec.Args)
+	renewed := d.renewalHook(rec.Key, rec.Args, &conf)
+
+	if rec.IPv6 {
+		l := newDHCPv6Lease(rec.ClientID, allowedServers,
+			d.clientTimeout, d.clientResendMax, d.clientResendTimeout,
+			persist, renewed)
+		return l, l.restore(rec)
+	}
+
+	opts, _, err := leaseOptions(rec.Args, &conf)
+	if err != nil {
+		return nil, err
+	}
+	l := newDHCPLease(rec.ClientID, opts, allowedServers,
+		d.clientTimeout, d.clientResendMax, d.clientResendTimeout, d.broadcastFor(&conf),
+		persist, renewed)
+	return l, l.restore(rec)
+}
+
+// broadcastFor returns whether DHCPv4 replies are requested to be broadcast
+// on the network of conf.
+func (d *DHCP) broadcastFor(conf *NetConf) bool {
+	if conf.IPAM.Broadcast != nil {
+		return *conf.IPAM.Broadcast
+	}
+	return d.broadcast
+}
+
+// detachLeases stops maintaining all the leases without releasing them.
+func (d *DHCP) detachLeases() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	var wg sync.WaitGroup
+	for _, l := range d.leases {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Detach()
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *DHCP) getLease(clientID string) lease {
 	d.mux.Lock()
 	defer d.mux.Unlock()
 
@@ -149,7 +398,7 @@ func (d *DHCP) getLease(clientID string) *DHCPLease {
 	return l
 }
 
-func (d *DHCP) setLease(clientID string, l *DHCPLease) {
+func (d *DHCP) setLease(clientID string, l lease) {
 	d.mux.Lock()
 	defer d.mux.Unlock()
 
@@ -191,7 +440,7 @@ func getListener(socketPath string) (net.Listener, error) {
 }
 
 func runDaemon(
-	pidfilePath, hostPrefix, socketPath string,
+	pidfilePath, hostPrefix, socketPath, stateDir, metricsAddr string,
 	dhcpClientTimeout time.Duration, resendMax time.Duration, resendTimeout time.Duration,
 	broadcast bool,
 ) error {
@@ -209,11 +458,28 @@ func runDaemon(
 		}
 	}
 
+	// A socket passed by systemd is not ours to remove
+	activated := os.Getenv("LISTEN_FDS") != ""
 	l, err := getListener(hostPrefix + socketPath)
 	if err != nil {
 		return fmt.Errorf("Error getting listener: %v", err)
 	}
 
+	dhcp := newDHCP(dhcpClientTimeout, resendMax, resendTimeout)
+	dhcp.hostNetnsPrefix = hostPrefix
+	dhcp.broadcast = broadcast
+	if stateDir != "" {
+		if dhcp.store, err = newLeaseStore(hostPrefix + stateDir); err != nil {
+			return fmt.Errorf("Error creating state directory: %v", err)
+		}
+		dhcp.resumeLeases()
+	}
+	if metricsAddr != "" {
+		if err := serveMetrics(dhcp, metricsAddr); err != nil {
+			return fmt.Errorf("Error serving metrics: %v", err)
+		}
+	}
+
 	srv := http.Server{}
 	exit := make(chan os.Signal, 1)
 	done := make(chan bool, 1)
@@ -221,18 +487,26 @@ func runDaemon(
 
 	go func() {
 		<-exit
+		if err := sdNotify("STOPPING=1"); err != nil {
+			log.Printf("error notifying systemd: %v", err)
+		}
 		srv.Shutdown(context.TODO())
-		os.Remove(hostPrefix + socketPath)
+		// The leases are left to the next run of the daemon, once their
+		// in-flight renewals complete
+		dhcp.detachLeases()
+		if !activated {
+			os.Remove(hostPrefix + socketPath)
+		}
 		os.Remove(pidfilePath)
 
 		done <- true
 	}()
 
-	dhcp := newDHCP(dhcpClientTimeout, resendMax, resendTimeout)
-	dhcp.hostNetnsPrefix = hostPrefix
-	dhcp.broadcast = broadcast
 	rpc.Register(dhcp)
 	rpc.HandleHTTP()
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("error notifying systemd: %v", err)
+	}
 	srv.Serve(l)
 
 	<-done
@@ -0,0 +1,338 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The subset of RFC 8415 the client needs to lease addresses with IA_NA.
+const (
+	dhcp6ClientPort = 546
+	dhcp6ServerPort = 547
+
+	dhcp6MsgSolicit   = 1
+	dhcp6MsgAdvertise = 2
+	dhcp6MsgRequest   = 3
+	dhcp6MsgRenew     = 5
+	dhcp6MsgRebind    = 6
+	dhcp6MsgReply     = 7
+	dhcp6MsgRelease   = 8
+
+	dhcp6OptClientID    = 1
+	dhcp6OptServerID    = 2
+	dhcp6OptIANA        = 3
+	dhcp6OptIAAddr      = 5
+	dhcp6OptORO         = 6
+	dhcp6OptElapsedTime = 8
+	dhcp6OptStatusCode  = 13
+	dhcp6OptRapidCommit = 14
+	dhcp6OptDNSServers  = 23
+
+	dhcp6StatusSuccess = 0
+
+	// duidTypeUUID is the DUID type the client ID is hashed into
+	duidTypeUUID = 4
+)
+
+// allDHCPRelayAgentsAndServers is the multicast address clients send to
+var allDHCPRelayAgentsAndServers = net.ParseIP("ff02::1:2")
+
+type dhcp6Option struct {
+	code uint16
+	data []byte
+}
+
+type dhcp6Message struct {
+	msgType byte
+	xid     [3]byte
+	options []dhcp6Option
+}
+
+func newDHCP6Message(msgType byte) (*dhcp6Message, error) {
+	m := &dhcp6Message{msgType: msgType}
+	if _, err := rand.Read(m.xid[:]); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *dhcp6Message) addOption(code uint16, data []byte) {
+	m.options = append(m.options, dhcp6Option{code: code, data: data})
+}
+
+// option returns the data of the first option of code, or nil.
+func (m *dhcp6Message) option(code uint16) []byte {
+	for _, opt := range m.options {
+		if opt.code == code {
+			return opt.data
+		}
+	}
+	return nil
+}
+
+func (m *dhcp6Message) marshal() []byte {
+	b := []byte{m.msgType, m.xid[0], m.xid[1], m.xid[2]}
+	return append(b, marshalDHCP6Options(m.options)...)
+}
+
+func parseDHCP6Message(b []byte) (*dhcp6Message, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("DHCPv6 message too short: %d bytes", len(b))
+	}
+	m := &dhcp6Message{msgType: b[0]}
+	copy(m.xid[:], b[1:4])
+	options, err := parseDHCP6Options(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	m.options = options
+	return m, nil
+}
+
+func marshalDHCP6Options(options []dhcp6Option) []byte {
+	var b []byte
+	for _, opt := range options {
+		b = binary.BigEndian.AppendUint16(b, opt.code)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(opt.data)))
+		b = append(b, opt.data...)
+	}
+	return b
+}
+
+func parseDHCP6Options(b []byte) ([]dhcp6Option, error) {
+	var options []dhcp6Option
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("truncated DHCPv6 option header")
+		}
+		code := binary.BigEndian.Uint16(b[0:2])
+		length := int(binary.BigEndian.Uint16(b[2:4]))
+		if len(b) < 4+length {
+			return nil, fmt.Errorf("truncated DHCPv6 option %d", code)
+		}
+		options = append(options, dhcp6Option{code: code, data: b[4 : 4+length]})
+		b = b[4+length:]
+	}
+	return options, nil
+}
+
+// dhcp6Status is a Status Code option. A missing option means success.
+type dhcp6Status struct {
+	code    uint16
+	message string
+}
+
+func parseDHCP6Status(data []byte) *dhcp6Status {
+	if len(data) < 2 {
+		return nil
+	}
+	return &dhcp6Status{code: binary.BigEndian.Uint16(data[0:2]), message: string(data[2:])}
+}
+
+func (s *dhcp6Status) err() error {
+	if s == nil || s.code == dhcp6StatusSuccess {
+		return nil
+	}
+	return fmt.Errorf("DHCPv6 status %d: %s", s.code, s.message)
+}
+
+type iaAddr struct {
+	ip        net.IP
+	preferred uint32
+	valid     uint32
+}
+
+// iaNA is an Identity Association for Non-temporary Addresses.
+type iaNA struct {
+	iaid   uint32
+	t1, t2 uint32
+	addrs  []iaAddr
+	status *dhcp6Status
+}
+
+func (ia *iaNA) marshal() []byte {
+	b := binary.BigEndian.AppendUint32(nil, ia.iaid)
+	b = binary.BigEndian.AppendUint32(b, ia.t1)
+	b = binary.BigEndian.AppendUint32(b, ia.t2)
+	var options []dhcp6Option
+	for _, addr := range ia.addrs {
+		data := append([]byte{}, addr.ip.To16()...)
+		data = binary.BigEndian.AppendUint32(data, addr.preferred)
+		data = binary.BigEndian.AppendUint32(data, addr.valid)
+		options = append(options, dhcp6Option{code: dhcp6OptIAAddr, data: data})
+	}
+	return append(b, marshalDHCP6Options(options)...)
+}
+
+func parseIANA(data []byte) (*iaNA, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("IA_NA option too short: %d bytes", len(data))
+	}
+	ia := &iaNA{
+		iaid: binary.BigEndian.Uint32(data[0:4]),
+		t1:   binary.BigEndian.Uint32(data[4:8]),
+		t2:   binary.BigEndian.Uint32(data[8:12]),
+	}
+	options, err := parseDHCP6Options(data[12:])
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range options {
+		switch opt.code {
+		case dhcp6OptIAAddr:
+			if len(opt.data) < 24 {
+				return nil, fmt.Errorf("IAADDR option too short: %d bytes", len(opt.data))
+			}
+			addr := iaAddr{
+				ip:        net.IP(append([]byte{}, opt.data[0:16]...)),
+				preferred: binary.BigEndian.Uint32(opt.data[16:20]),
+				valid:     binary.BigEndian.Uint32(opt.data[20:24]),
+			}
+			// An address may carry its own status, e.g. NotOnLink
+			suboptions, err := parseDHCP6Options(opt.data[24:])
+			if err != nil {
+				return nil, err
+			}
+			for _, subopt := range suboptions {
+				if subopt.code == dhcp6OptStatusCode && parseDHCP6Status(subopt.data).err() != nil {
+					addr.valid = 0
+				}
+			}
+			ia.addrs = append(ia.addrs, addr)
+		case dhcp6OptStatusCode:
+			ia.status = parseDHCP6Status(opt.data)
+		}
+	}
+	return ia, nil
+}
+
+// generateDUID derives a DUID-UUID from the client ID, so that the client
+// keeps its identity across daemon restarts.
+func generateDUID(clientID string) []byte {
+	sum := sha256.Sum256([]byte(clientID))
+	duid := binary.BigEndian.AppendUint16(nil, duidTypeUUID)
+	return append(duid, sum[:16]...)
+}
+
+// generateIAID derives the IAID of the interface from the client ID.
+func generateIAID(clientID string) uint32 {
+	sum := sha256.Sum256([]byte("iaid/" + clientID))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// dhcp6Client exchanges DHCPv6 messages on a link. It must be created in
+// the network namespace of the link.
+type dhcp6Client struct {
+	conn    *net.UDPConn
+	ifName  string
+	timeout time.Duration
+	// allowedServers are matched against the source address of responses
+	allowedServers []net.IP
+}
+
+func newDHCP6Client(ifName string, allowedServers []net.IP, timeout time.Duration) (*dhcp6Client, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				if sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); sockErr != nil {
+					return
+				}
+				sockErr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifName)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), "udp6", fmt.Sprintf("[::]:%d", dhcp6ClientPort))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for DHCPv6 on %q: %v", ifName, err)
+	}
+	return &dhcp6Client{conn: conn.(*net.UDPConn), ifName: ifName, timeout: timeout, allowedServers: allowedServers}, nil
+}
+
+func (c *dhcp6Client) Close() error {
+	return c.conn.Close()
+}
+
+// send sends msg to all the DHCPv6 servers of the link.
+func (c *dhcp6Client) send(msg *dhcp6Message) error {
+	dst := &net.UDPAddr{IP: allDHCPRelayAgentsAndServers, Port: dhcp6ServerPort, Zone: c.ifName}
+	if _, err := c.conn.WriteToUDP(msg.marshal(), dst); err != nil {
+		return fmt.Errorf("failed to send DHCPv6 message: %v", err)
+	}
+	return nil
+}
+
+// exchange sends msg and returns the first response to it that accept takes.
+func (c *dhcp6Client) exchange(ctx context.Context, msg *dhcp6Message, accept func(*dhcp6Message) error) (*dhcp6Message, error) {
+	if err := c.send(msg); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	var lastErr error
+	for {
+		n, src, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if lastErr != nil {
+					return nil, fmt.Errorf("no acceptable DHCPv6 response: %v", lastErr)
+				}
+				return nil, fmt.Errorf("timed out waiting for a DHCPv6 response")
+			}
+			return nil, err
+		}
+		resp, err := parseDHCP6Message(buf[:n])
+		if err != nil || resp.xid != msg.xid {
+			continue
+		}
+		if !bytes.Equal(resp.option(dhcp6OptClientID), msg.option(dhcp6OptClientID)) {
+			continue
+		}
+		if !serverAllowed(c.allowedServers, src.IP) {
+			lastErr = fmt.Errorf("DHCPv6 server %s is not allowed", src.IP)
+			continue
+		}
+		if err := accept(resp); err != nil {
+			lastErr = err
+			continue
+		}
+		return resp, nil
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDHCP6MessageRoundTrip(t *testing.T) {
+	m, err := newDHCP6Message(dhcp6MsgSolicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.addOption(dhcp6OptClientID, generateDUID("client"))
+	m.addOption(dhcp6OptRapidCommit, nil)
+
+	parsed, err := parseDHCP6Message(m.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.msgType != dhcp6MsgSolicit || parsed.xid != m.xid {
+		t.Errorf("header mismatch: expected %d/%v, got %d/%v", m.msgType, m.xid, parsed.msgType, parsed.xid)
+	}
+	if !bytes.Equal(parsed.option(dhcp6OptClientID), generateDUID("client")) {
+		t.Errorf("client ID mismatch: got %x", parsed.option(dhcp6OptClientID))
+	}
+	if parsed.option(dhcp6OptRapidCommit) == nil {
+		t.Errorf("rapid commit option missing")
+	}
+	if parsed.option(dhcp6OptServerID) != nil {
+		t.Errorf("unexpected server ID option")
+	}
+}
+
+func TestParseTruncatedDHCP6Message(t *testing.T) {
+	m, _ := newDHCP6Message(dhcp6MsgReply)
+	m.addOption(dhcp6OptServerID, []byte{0, 1, 2, 3})
+	b := m.marshal()
+
+	for _, n := range []int{3, len(b) - 1, 6} {
+		if _, err := parseDHCP6Message(b[:n]); err == nil {
+			t.Errorf("expected an error parsing %d of %d bytes", n, len(b))
+		}
+	}
+}
+
+func TestIANARoundTrip(t *testing.T) {
+	ia := &iaNA{
+		iaid: generateIAID("client"),
+		t1:   300,
+		t2:   480,
+		addrs: []iaAddr{{
+			ip:        net.ParseIP("2001:db8::10"),
+			preferred: 600,
+			valid:     900,
+		}},
+	}
+
+	parsed, err := parseIANA(ia.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.iaid != ia.iaid || parsed.t1 != 300 || parsed.t2 != 480 {
+		t.Errorf("IA_NA mismatch: got %+v", parsed)
+	}
+	if len(parsed.addrs) != 1 || !parsed.addrs[0].ip.Equal(ia.addrs[0].ip) ||
+		parsed.addrs[0].preferred != 600 || parsed.addrs[0].valid != 900 {
+		t.Errorf("IAADDR mismatch: got %+v", parsed.addrs)
+	}
+	if err := parsed.status.err(); err != nil {
+		t.Errorf("unexpected status: %v", err)
+	}
+}
+
+func TestIANAStatus(t *testing.T) {
+	ia := (&iaNA{iaid: 1}).marshal()
+	// NoAddrsAvail
+	ia = append(ia, marshalDHCP6Options([]dhcp6Option{{code: dhcp6OptStatusCode, data: []byte{0, 2, 'n', 'o'}}})...)
+
+	parsed, err := parseIANA(ia)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.status.err(); err == nil || err.Error() != "DHCPv6 status 2: no" {
+		t.Errorf("unexpected status error: %v", err)
+	}
+}
+
+func TestLeasedIA(t *testing.T) {
+	l := &DHCPv6Lease{iaid: generateIAID("client")}
+	reply, _ := newDHCP6Message(dhcp6MsgReply)
+	reply.addOption(dhcp6OptServerID, []byte{0, 1})
+
+	if _, err := l.leasedIA(reply); err == nil {
+		t.Errorf("expected an error without IA_NA")
+	}
+
+	// Addresses the server does not lease have a valid lifetime of 0
+	reply.addOption(dhcp6OptIANA, (&iaNA{
+		iaid:  l.iaid,
+		addrs: []iaAddr{{ip: net.ParseIP("2001:db8::10")}},
+	}).marshal())
+	if _, err := l.leasedIA(reply); err == nil {
+		t.Errorf("expected an error without a valid address")
+	}
+
+	reply.options = reply.options[:1]
+	reply.addOption(dhcp6OptIANA, (&iaNA{iaid: l.iaid + 1, addrs: []iaAddr{{ip: net.ParseIP("2001:db8::11"), valid: 60}}}).marshal())
+	reply.addOption(dhcp6OptIANA, (&iaNA{iaid: l.iaid, addrs: []iaAddr{{ip: net.ParseIP("2001:db8::10"), preferred: 100, valid: 200}}}).marshal())
+	if err := l.commit(reply); err != nil {
+		t.Fatal(err)
+	}
+	if !l.addr.ip.Equal(net.ParseIP("2001:db8::10")) {
+		t.Errorf("leased the wrong address: %v", l.addr.ip)
+	}
+	ipn, _ := l.IPNet()
+	if ipn.String() != "2001:db8::10/128" {
+		t.Errorf("unexpected IPNet: %v", ipn)
+	}
+	// T1 and T2 default to 0.5 and 0.8 times the preferred lifetime
+	if d := time.Until(l.renewalTime); d <= 40*time.Second || d > 50*time.Second {
+		t.Errorf("unexpected renewal time in %v", d)
+	}
+	if d := time.Until(l.rebindingTime); d <= 70*time.Second || d > 80*time.Second {
+		t.Errorf("unexpected rebinding time in %v", d)
+	}
+	if !bytes.Equal(l.serverID, []byte{0, 1}) {
+		t.Errorf("unexpected server ID %x", l.serverID)
+	}
+}
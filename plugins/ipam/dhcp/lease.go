@@ -21,7 +21,6 @@ import (
 	"log"
 	"math/rand"
 	"net"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -38,6 +37,7 @@ import (
 // RFC 2131 suggests using exponential backoff, starting with 4sec
 // and randomized to +/- 1sec
 const (
+	defaultTimeout       = 10 * time.Second
 	resendDelay0         = 4 * time.Second
 	resendDelayMax       = 62 * time.Second
 	defaultLeaseTime     = 60 * time.Minute
@@ -82,14 +82,27 @@ type DHCPLease struct {
 	resendMax     time.Duration
 	resendTimeout time.Duration
 	broadcast     bool
-	stopping      uint32
-	stop          chan struct{}
-	check         chan struct{}
-	wg            sync.WaitGroup
-	cancelFunc    context.CancelFunc
-	ctx           context.Context
+	// allowedServers are the only servers leases are requested from, if set
+	allowedServers []net.IP
+	stopping       uint32
+	stop           chan struct{}
+	check          chan struct{}
+	wg             sync.WaitGroup
+	cancelFunc     context.CancelFunc
+	ctx            context.Context
 	// list of requesting and providing options and if they are necessary / their value
-	opts []dhcp4.Option
+	opts     []dhcp4.Option
+	detached atomic.Bool
+	// expiry and renewalFailures are read by the metrics endpoint
+	expiry          atomic.Int64
+	renewalFailures atomic.Uint64
+	// info is read by the lease-info query
+	info atomic.Pointer[LeaseInfo]
+	// persist records the lease whenever it is committed, if not nil
+	persist func(*leaseRecord)
+	// renewed is called after the lease is renewed or rebound, with its
+	// parameters before, if not nil
+	renewed func(lease, *leaseParams)
 }
 
 var requestOptionsDefault = []dhcp4.OptionCode{
@@ -97,21 +110,12 @@ var requestOptionsDefault = []dhcp4.OptionCode{
 	dhcp4.OptionSubnetMask,
 }
 
-func prepareOptions(cniArgs string, provideOptions []ProvideOption, requestOptions []RequestOption) (
+func prepareOptions(cniArgs map[string]string, provideOptions []ProvideOption, requestOptions []RequestOption, results []string) (
 	[]dhcp4.Option, error,
 ) {
 	var opts []dhcp4.Option
 
 	var err error
-	// parse CNI args
-	cniArgsParsed := map[string]string{}
-	for _, argPair := range strings.Split(cniArgs, ";") {
-		args := strings.SplitN(argPair, "=", 2)
-		if len(args) > 1 {
-			cniArgsParsed[args[0]] = args[1]
-		}
-	}
-
 	// parse providing options map
 	var optParsed dhcp4.OptionCode
 	for _, opt := range provideOptions {
@@ -125,7 +129,7 @@ func prepareOptions(cniArgs string, provideOptions []ProvideOption, requestOptio
 			}
 			opts = append(opts, dhcp4.Option{Code: optParsed, Value: dhcp4.String(opt.Value)})
 		}
-		if value, ok := cniArgsParsed[opt.ValueFromCNIArg]; ok {
+		if value, ok := cniArgs[opt.ValueFromCNIArg]; ok {
 			if len(value) > 255 {
 				return nil, fmt.Errorf("value too long for option %q from CNI_ARGS %q: %q", opt.Option, opt.ValueFromCNIArg, opt.Value)
 			}
@@ -154,6 +158,15 @@ func prepareOptions(cniArgs string, provideOptions []ProvideOption, requestOptio
 			optsRequesting.Add(opt)
 		}
 	}
+	for _, result := range results {
+		codes, ok := resultOptions[result]
+		if !ok {
+			return nil, fmt.Errorf("Can not return option %q in the result", result)
+		}
+		for _, opt := range codes {
+			optsRequesting.Add(opt)
+		}
+	}
 	if len(optsRequesting) > 0 {
 		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionParameterRequestList, Value: optsRequesting})
 	}
@@ -166,48 +179,100 @@ func prepareOptions(cniArgs string, provideOptions []ProvideOption, requestOptio
 // calling DHCPLease.Stop()
 func AcquireLease(
 	clientID, netns, ifName string,
-	opts []dhcp4.Option,
+	opts []dhcp4.Option, allowedServers []net.IP,
 	timeout, resendMax time.Duration, resendTimeout time.Duration, broadcast bool,
+	persist func(*leaseRecord), renewed func(lease, *leaseParams),
 ) (*DHCPLease, error) {
-	errCh := make(chan error, 1)
+	l := newDHCPLease(clientID, opts, allowedServers, timeout, resendMax, resendTimeout, broadcast, persist, renewed)
+
+	log.Printf("%v: acquiring lease", clientID)
+
+	if err := l.start(netns, ifName, true); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// restore restores the lease of rec, acquired by a previous run of the
+// daemon or of the plugin.
+func (l *DHCPLease) restore(rec *leaseRecord) error {
+	offer, err := dhcp4.FromBytes(rec.Offer)
+	if err != nil {
+		return fmt.Errorf("error parsing DHCPOFFER: %v", err)
+	}
+	ack, err := dhcp4.FromBytes(rec.ACK)
+	if err != nil {
+		return fmt.Errorf("error parsing DHCPACK: %v", err)
+	}
 
+	l.latestLease = &nclient4.Lease{Offer: offer, ACK: ack}
+	l.renewalTime = rec.RenewalTime
+	l.rebindingTime = rec.RebindingTime
+	l.expireTime = rec.ExpireTime
+	l.expiry.Store(l.expireTime.UnixNano())
+	l.storeInfo()
+	return nil
+}
+
+func newDHCPLease(
+	clientID string,
+	opts []dhcp4.Option, allowedServers []net.IP,
+	timeout, resendMax time.Duration, resendTimeout time.Duration, broadcast bool,
+	persist func(*leaseRecord), renewed func(lease, *leaseParams),
+) *DHCPLease {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 
-	l := &DHCPLease{
-		clientID:      clientID,
-		stop:          make(chan struct{}),
-		check:         make(chan struct{}),
-		timeout:       timeout,
-		resendMax:     resendMax,
-		resendTimeout: resendTimeout,
-		broadcast:     broadcast,
-		opts:          opts,
-		cancelFunc:    cancel,
-		ctx:           ctx,
+	return &DHCPLease{
+		clientID:       clientID,
+		stop:           make(chan struct{}),
+		check:          make(chan struct{}),
+		timeout:        timeout,
+		resendMax:      resendMax,
+		resendTimeout:  resendTimeout,
+		broadcast:      broadcast,
+		opts:           opts,
+		allowedServers: allowedServers,
+		persist:        persist,
+		renewed:        renewed,
+		cancelFunc:     cancel,
+		ctx:            ctx,
 	}
+}
 
-	log.Printf("%v: acquiring lease", clientID)
+// inNetNS looks up the link in netns and runs f there.
+func (l *DHCPLease) inNetNS(netns, ifName string, f func() error) error {
+	return ns.WithNetNSPath(netns, func(_ ns.NetNS) error {
+		link, err := netlinksafe.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("error looking up %q: %v", ifName, err)
+		}
+
+		l.link = link
+		l.linkName = link.Attrs().Name
+
+		return f()
+	})
+}
+
+// start looks up the link in netns, acquires the lease if acquire is set,
+// and then maintains it in the background.
+func (l *DHCPLease) start(netns, ifName string, acquire bool) error {
+	errCh := make(chan error, 1)
 
 	l.wg.Add(1)
 	go func() {
-		errCh <- ns.WithNetNSPath(netns, func(_ ns.NetNS) error {
-			defer l.wg.Done()
-
-			link, err := netlinksafe.LinkByName(ifName)
-			if err != nil {
-				return fmt.Errorf("error looking up %q: %v", ifName, err)
-			}
+		defer l.wg.Done()
 
-			l.link = link
-			l.linkName = link.Attrs().Name
+		errCh <- l.inNetNS(netns, ifName, func() error {
+			if acquire {
+				if err := l.acquire(); err != nil {
+					return err
+				}
 
-			if err = l.acquire(); err != nil {
-				return err
+				log.Printf("%v: lease acquired, expiration is %v", l.clientID, l.expireTime)
 			}
 
-			log.Printf("%v: lease acquired, expiration is %v", l.clientID, l.expireTime)
-
 			errCh <- nil
 
 			l.maintain()
@@ -215,11 +280,7 @@ func AcquireLease(
 		})
 	}()
 
-	if err := <-errCh; err != nil {
-		return nil, err
-	}
-
-	return l, nil
+	return <-errCh
 }
 
 // Stop terminates the background task that maintains the lease
@@ -232,6 +293,51 @@ func (l *DHCPLease) Stop() {
 	l.wg.Wait()
 }
 
+// Detach terminates the background task that maintains the lease without
+// releasing it, for the next run of the daemon or `dhcp renew` to renew it.
+func (l *DHCPLease) Detach() {
+	l.detached.Store(true)
+	if atomic.CompareAndSwapUint32(&l.stopping, 0, 1) {
+		close(l.stop)
+	}
+	// Let an in-flight renewal complete
+	l.wg.Wait()
+	l.cancelFunc()
+}
+
+// renewDue renews, rebinds or acquires the lease again as its timers are
+// due. It is the one-shot counterpart of maintain() for `dhcp renew`.
+func (l *DHCPLease) renewDue() error {
+	now := time.Now()
+	prev := paramsOf(l)
+	switch {
+	case now.After(l.rebindingTime):
+		if err := l.acquire(); err != nil {
+			l.renewalFailed()
+			return err
+		}
+		log.Printf("%v: lease rebound, expiration is %v", l.clientID, l.expireTime)
+	case now.After(l.renewalTime):
+		if err := l.renew(); err != nil {
+			l.renewalFailed()
+			return err
+		}
+		log.Printf("%v: lease renewed, expiration is %v", l.clientID, l.expireTime)
+	default:
+		return nil
+	}
+	l.afterRenewal(prev)
+	return nil
+}
+
+// afterRenewal calls the renewed hook, if any, with the parameters of the
+// lease before its renewal.
+func (l *DHCPLease) afterRenewal(prev *leaseParams) {
+	if l.renewed != nil {
+		l.renewed(l, prev)
+	}
+}
+
 func (l *DHCPLease) Check() {
 	l.check <- struct{}{}
 }
@@ -249,7 +355,24 @@ func withAllOptions(l *DHCPLease) dhcp4.Modifier {
 		for _, opt := range l.opts {
 			d.Options.Update(opt)
 		}
+		if l.broadcast {
+			d.SetBroadcast()
+		}
+	}
+}
+
+// serverAllowed returns whether server is one of allowedServers, or whether
+// any server is allowed if there are none.
+func serverAllowed(allowedServers []net.IP, server net.IP) bool {
+	if len(allowedServers) == 0 {
+		return true
+	}
+	for _, allowed := range allowedServers {
+		if allowed.Equal(server) {
+			return true
+		}
 	}
+	return false
 }
 
 func (l *DHCPLease) acquire() error {
@@ -269,11 +392,7 @@ func (l *DHCPLease) acquire() error {
 	timeoutCtx, cancel := context.WithTimeoutCause(l.ctx, l.resendTimeout, errNoMoreTries)
 	defer cancel()
 	pkt, err := backoffRetry(timeoutCtx, l.resendMax, func() (*nclient4.Lease, error) {
-		return c.Request(
-			timeoutCtx,
-			withClientID(l.clientID),
-			withAllOptions(l),
-		)
+		return l.request(timeoutCtx, c)
 	})
 	if err != nil {
 		return err
@@ -283,6 +402,29 @@ func (l *DHCPLease) acquire() error {
 	return nil
 }
 
+// request completes the Discover-Offer-Request-Ack handshake, with the
+// first offer of an allowed server.
+func (l *DHCPLease) request(ctx context.Context, c *nclient4.Client) (*nclient4.Lease, error) {
+	modifiers := []dhcp4.Modifier{withClientID(l.clientID), withAllOptions(l)}
+	if len(l.allowedServers) == 0 {
+		return c.Request(ctx, modifiers...)
+	}
+
+	discover, err := dhcp4.NewDiscovery(c.InterfaceAddr(), dhcp4.PrependModifiers(modifiers,
+		dhcp4.WithOption(dhcp4.OptMaxMessageSize(nclient4.MaxMessageSize)))...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a discovery request: %w", err)
+	}
+	offer, err := c.SendAndRead(ctx, c.RemoteAddr(), discover, nclient4.IsAll(
+		nclient4.IsMessageType(dhcp4.MessageTypeOffer),
+		func(p *dhcp4.DHCPv4) bool { return serverAllowed(l.allowedServers, p.ServerIdentifier()) },
+	))
+	if err != nil {
+		return nil, fmt.Errorf("unable to receive an offer from an allowed server: %w", err)
+	}
+	return c.RequestFromOffer(ctx, offer, modifiers...)
+}
+
 func (l *DHCPLease) commit(lease *nclient4.Lease) {
 	l.latestLease = lease
 	ack := lease.ACK
@@ -295,6 +437,19 @@ func (l *DHCPLease) commit(lease *nclient4.Lease) {
 	l.expireTime = now.Add(leaseTime)
 	l.renewalTime = now.Add(renewalTime)
 	l.rebindingTime = now.Add(rebindingTime)
+	l.expiry.Store(l.expireTime.UnixNano())
+	l.storeInfo()
+
+	if l.persist != nil {
+		l.persist(&leaseRecord{
+			ClientID:      l.clientID,
+			RenewalTime:   l.renewalTime,
+			RebindingTime: l.rebindingTime,
+			ExpireTime:    l.expireTime,
+			Offer:         lease.Offer.ToBytes(),
+			ACK:           ack.ToBytes(),
+		})
+	}
 }
 
 func (l *DHCPLease) maintain() {
@@ -321,8 +476,10 @@ func (l *DHCPLease) maintain() {
 			}
 
 		case leaseStateRenewing:
+			prev := paramsOf(l)
 			if err := l.renew(); err != nil {
 				log.Printf("%v: %v", l.clientID, err)
+				l.renewalFailed()
 
 				if time.Now().After(l.rebindingTime) {
 					log.Printf("%v: renewal time expired, rebinding", l.clientID)
@@ -330,12 +487,15 @@ func (l *DHCPLease) maintain() {
 				}
 			} else {
 				log.Printf("%v: lease renewed, expiration is %v", l.clientID, l.expireTime)
+				l.afterRenewal(prev)
 				state = leaseStateBound
 			}
 
 		case leaseStateRebinding:
+			prev := paramsOf(l)
 			if err := l.acquire(); err != nil {
 				log.Printf("%v: %v", l.clientID, err)
+				l.renewalFailed()
 
 				if time.Now().After(l.expireTime) {
 					log.Printf("%v: lease expired, bringing interface DOWN", l.clientID)
@@ -344,6 +504,7 @@ func (l *DHCPLease) maintain() {
 				}
 			} else {
 				log.Printf("%v: lease rebound, expiration is %v", l.clientID, l.expireTime)
+				l.afterRenewal(prev)
 				state = leaseStateBound
 			}
 		}
@@ -355,6 +516,9 @@ func (l *DHCPLease) maintain() {
 			log.Printf("%v: Checking lease", l.clientID)
 
 		case <-l.stop:
+			if l.detached.Load() {
+				return
+			}
 			if err := l.release(); err != nil {
 				log.Printf("%v: failed to release DHCP lease: %v", l.clientID, err)
 			}
@@ -494,12 +658,34 @@ func (l *DHCPLease) Routes() []*types.Route {
 	return routes
 }
 
+func (l *DHCPLease) DNS() types.DNS {
+	ack := l.latestLease.ACK
+
+	dns := types.DNS{Domain: ack.DomainName()}
+	for _, ip := range ack.DNS() {
+		dns.Nameservers = append(dns.Nameservers, ip.String())
+	}
+	if search := ack.DomainSearch(); search != nil {
+		dns.Search = search.Labels
+	}
+	return dns
+}
+
+// MTU returns the Interface MTU option of the lease, or 0.
+func (l *DHCPLease) MTU() int {
+	mtu, err := dhcp4.GetUint16(dhcp4.OptionInterfaceMTU, l.latestLease.ACK.Options)
+	if err != nil {
+		return 0
+	}
+	return int(mtu)
+}
+
 // jitter returns a random value within [-span, span) range
 func jitter(span time.Duration) time.Duration {
 	return time.Duration(float64(span) * (2.0*rand.Float64() - 1.0))
 }
 
-func backoffRetry(ctx context.Context, resendMax time.Duration, f func() (*nclient4.Lease, error)) (*nclient4.Lease, error) {
+func backoffRetry[T any](ctx context.Context, resendMax time.Duration, f func() (T, error)) (T, error) {
 	baseDelay := resendDelay0
 	var sleepTime time.Duration
 	fastRetryLimit := resendFastMax
@@ -522,7 +708,8 @@ func backoffRetry(ctx context.Context, resendMax time.Duration, f func() (*nclie
 
 		select {
 		case <-ctx.Done():
-			return nil, context.Cause(ctx)
+			var zero T
+			return zero, context.Cause(ctx)
 		case <-time.After(sleepTime):
 			// only adjust delay time if we are in normal backoff stage
 			if baseDelay < resendMax && fastRetryLimit == 0 {
@@ -540,3 +727,36 @@ func newDHCPClient(
 	clientOpts = append(clientOpts, nclient4.WithTimeout(timeout))
 	return nclient4.New(link.Attrs().Name, clientOpts...)
 }
+
+// ExpireTime returns when the lease expires.
+func (l *DHCPLease) ExpireTime() time.Time {
+	return time.Unix(0, l.expiry.Load())
+}
+
+// RenewalFailures returns how many times renewing or rebinding the lease failed.
+func (l *DHCPLease) RenewalFailures() uint64 {
+	return l.renewalFailures.Load()
+}
+
+// Info returns the details of the lease.
+func (l *DHCPLease) Info() LeaseInfo {
+	info := LeaseInfo{Family: "ipv4"}
+	if i := l.info.Load(); i != nil {
+		info = *i
+	}
+	info.RenewalFailures = l.RenewalFailures()
+	return info
+}
+
+func (l *DHCPLease) storeInfo() {
+	info := dhcp4Info(l.latestLease.ACK)
+	info.RenewalTime = l.renewalTime
+	info.RebindingTime = l.rebindingTime
+	info.ExpireTime = l.expireTime
+	l.info.Store(info)
+}
+
+func (l *DHCPLease) renewalFailed() {
+	l.renewalFailures.Add(1)
+	renewalFailuresIPv4.Add(1)
+}
@@ -0,0 +1,599 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// DHCPv6Lease is an address leased with DHCPv6 IA_NA. Like DHCPLease, it is
+// maintained in the background, renewed at T1 and rebound at T2.
+type DHCPv6Lease struct {
+	clientID string
+	duid     []byte
+	iaid     uint32
+	serverID []byte
+	addr     iaAddr
+	dns      []net.IP
+	link     netlink.Link
+	linkName string
+	// allowedServers are the only servers whose messages are accepted, if set
+	allowedServers []net.IP
+	renewalTime    time.Time
+	rebindingTime  time.Time
+	expireTime     time.Time
+	timeout        time.Duration
+	resendMax      time.Duration
+	resendTimeout  time.Duration
+	stopping       uint32
+	stop           chan struct{}
+	check          chan struct{}
+	wg             sync.WaitGroup
+	cancelFunc     context.CancelFunc
+	ctx            context.Context
+	detached       atomic.Bool
+	// expiry and renewalFailures are read by the metrics endpoint
+	expiry          atomic.Int64
+	renewalFailures atomic.Uint64
+	// info is read by the lease-info query
+	info atomic.Pointer[LeaseInfo]
+	// persist records the lease whenever it is committed, if not nil
+	persist func(*leaseRecord)
+	// renewed is called after the lease is renewed, rebound or acquired
+	// again, with its parameters before, if not nil
+	renewed func(lease, *leaseParams)
+}
+
+// AcquireLease6 gets a DHCPv6 lease and then maintains it in the background
+// by periodically renewing it. The acquired lease can be released by
+// calling DHCPv6Lease.Stop()
+func AcquireLease6(
+	clientID, netns, ifName string,
+	allowedServers []net.IP,
+	timeout, resendMax time.Duration, resendTimeout time.Duration,
+	persist func(*leaseRecord), renewed func(lease, *leaseParams),
+) (*DHCPv6Lease, error) {
+	l := newDHCPv6Lease(clientID, allowedServers, timeout, resendMax, resendTimeout, persist, renewed)
+
+	log.Printf("%v: acquiring DHCPv6 lease", clientID)
+
+	if err := l.start(netns, ifName, true); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// restore restores the DHCPv6 lease of rec, acquired by a previous run of
+// the daemon or of the plugin.
+func (l *DHCPv6Lease) restore(rec *leaseRecord) error {
+	l.serverID = rec.ServerID
+	l.addr = iaAddr{ip: rec.Address, preferred: rec.Preferred, valid: rec.Valid}
+	l.dns = rec.DNS
+	l.renewalTime = rec.RenewalTime
+	l.rebindingTime = rec.RebindingTime
+	l.expireTime = rec.ExpireTime
+	l.expiry.Store(l.expireTime.UnixNano())
+	l.storeInfo()
+	return nil
+}
+
+func newDHCPv6Lease(
+	clientID string,
+	allowedServers []net.IP,
+	timeout, resendMax time.Duration, resendTimeout time.Duration,
+	persist func(*leaseRecord), renewed func(lease, *leaseParams),
+) *DHCPv6Lease {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &DHCPv6Lease{
+		clientID:       clientID,
+		duid:           generateDUID(clientID),
+		iaid:           generateIAID(clientID),
+		allowedServers: allowedServers,
+		stop:           make(chan struct{}),
+		check:          make(chan struct{}),
+		timeout:        timeout,
+		resendMax:      resendMax,
+		resendTimeout:  resendTimeout,
+		persist:        persist,
+		renewed:        renewed,
+		cancelFunc:     cancel,
+		ctx:            ctx,
+	}
+}
+
+// inNetNS looks up the link in netns and runs f there.
+func (l *DHCPv6Lease) inNetNS(netns, ifName string, f func() error) error {
+	return ns.WithNetNSPath(netns, func(_ ns.NetNS) error {
+		link, err := netlinksafe.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("error looking up %q: %v", ifName, err)
+		}
+
+		l.link = link
+		l.linkName = link.Attrs().Name
+
+		return f()
+	})
+}
+
+// start looks up the link in netns, acquires the lease if acquire is set,
+// and then maintains it in the background.
+func (l *DHCPv6Lease) start(netns, ifName string, acquire bool) error {
+	errCh := make(chan error, 1)
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		errCh <- l.inNetNS(netns, ifName, func() error {
+			if acquire {
+				if err := l.acquire(); err != nil {
+					return err
+				}
+
+				log.Printf("%v: DHCPv6 lease acquired, expiration is %v", l.clientID, l.expireTime)
+			}
+
+			errCh <- nil
+
+			l.maintain()
+			return nil
+		})
+	}()
+
+	if err := <-errCh; err != nil {
+		l.cancelFunc()
+		return err
+	}
+	return nil
+}
+
+// Stop terminates the background task that maintains the lease
+// and issues a DHCPv6 Release
+func (l *DHCPv6Lease) Stop() {
+	if atomic.CompareAndSwapUint32(&l.stopping, 0, 1) {
+		close(l.stop)
+		l.cancelFunc()
+	}
+	l.wg.Wait()
+}
+
+// Detach terminates the background task that maintains the lease without
+// releasing it, for the next run of the daemon or `dhcp renew` to renew it.
+func (l *DHCPv6Lease) Detach() {
+	l.detached.Store(true)
+	if atomic.CompareAndSwapUint32(&l.stopping, 0, 1) {
+		close(l.stop)
+	}
+	// Let an in-flight renewal complete
+	l.wg.Wait()
+	l.cancelFunc()
+}
+
+// renewDue renews, rebinds or acquires the lease again as its timers are
+// due. It is the one-shot counterpart of maintain() for `dhcp renew`.
+func (l *DHCPv6Lease) renewDue() error {
+	now := time.Now()
+	prev := paramsOf(l)
+	switch {
+	case now.After(l.expireTime):
+		if err := l.acquire(); err != nil {
+			l.renewalFailed()
+			return err
+		}
+		log.Printf("%v: DHCPv6 lease acquired, expiration is %v", l.clientID, l.expireTime)
+	case now.After(l.rebindingTime):
+		if err := l.extend(dhcp6MsgRebind); err != nil {
+			l.renewalFailed()
+			return err
+		}
+		log.Printf("%v: DHCPv6 lease rebound, expiration is %v", l.clientID, l.expireTime)
+	case now.After(l.renewalTime):
+		if err := l.extend(dhcp6MsgRenew); err != nil {
+			l.renewalFailed()
+			return err
+		}
+		log.Printf("%v: DHCPv6 lease renewed, expiration is %v", l.clientID, l.expireTime)
+	default:
+		return nil
+	}
+	l.afterRenewal(prev)
+	return nil
+}
+
+// afterRenewal calls the renewed hook, if any, with the parameters of the
+// lease before its renewal.
+func (l *DHCPv6Lease) afterRenewal(prev *leaseParams) {
+	if l.renewed != nil {
+		l.renewed(l, prev)
+	}
+}
+
+func (l *DHCPv6Lease) Check() {
+	l.check <- struct{}{}
+}
+
+// newMessage returns a message of msgType from the client, for the
+// addresses of ia, to the server with serverID if it is not nil.
+func (l *DHCPv6Lease) newMessage(msgType byte, ia *iaNA, serverID []byte) (*dhcp6Message, error) {
+	m, err := newDHCP6Message(msgType)
+	if err != nil {
+		return nil, err
+	}
+	m.addOption(dhcp6OptClientID, l.duid)
+	if serverID != nil {
+		m.addOption(dhcp6OptServerID, serverID)
+	}
+	if ia == nil {
+		ia = &iaNA{iaid: l.iaid}
+	}
+	m.addOption(dhcp6OptIANA, ia.marshal())
+	m.addOption(dhcp6OptElapsedTime, []byte{0, 0})
+	m.addOption(dhcp6OptORO, binary.BigEndian.AppendUint16(nil, dhcp6OptDNSServers))
+	return m, nil
+}
+
+// leasedIA returns the IA_NA of the client in msg, which must hold an address.
+func (l *DHCPv6Lease) leasedIA(msg *dhcp6Message) (*iaNA, error) {
+	if err := parseDHCP6Status(msg.option(dhcp6OptStatusCode)).err(); err != nil {
+		return nil, err
+	}
+	for _, opt := range msg.options {
+		if opt.code != dhcp6OptIANA {
+			continue
+		}
+		ia, err := parseIANA(opt.data)
+		if err != nil {
+			return nil, err
+		}
+		if ia.iaid != l.iaid {
+			continue
+		}
+		if err := ia.status.err(); err != nil {
+			return nil, err
+		}
+		for _, addr := range ia.addrs {
+			if addr.valid > 0 {
+				return ia, nil
+			}
+		}
+		return nil, fmt.Errorf("no address in IA_NA")
+	}
+	return nil, fmt.Errorf("no IA_NA for IAID %d", l.iaid)
+}
+
+func (l *DHCPv6Lease) acquire() error {
+	if (l.link.Attrs().Flags & net.FlagUp) != net.FlagUp {
+		log.Printf("Link %q down. Attempting to set up", l.linkName)
+		if err := netlink.LinkSetUp(l.link); err != nil {
+			return err
+		}
+	}
+
+	c, err := newDHCP6Client(l.linkName, l.allowedServers, l.timeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	timeoutCtx, cancel := context.WithTimeoutCause(l.ctx, l.resendTimeout, errNoMoreTries)
+	defer cancel()
+	reply, err := backoffRetry(timeoutCtx, l.resendMax, func() (*dhcp6Message, error) {
+		return l.solicit(timeoutCtx, c)
+	})
+	if err != nil {
+		return err
+	}
+
+	return l.commit(reply)
+}
+
+// solicit leases an address, in a Solicit/Reply exchange if the server
+// supports Rapid Commit, in Solicit/Advertise/Request/Reply otherwise.
+func (l *DHCPv6Lease) solicit(ctx context.Context, c *dhcp6Client) (*dhcp6Message, error) {
+	solicit, err := l.newMessage(dhcp6MsgSolicit, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	solicit.addOption(dhcp6OptRapidCommit, nil)
+
+	resp, err := c.exchange(ctx, solicit, func(m *dhcp6Message) error {
+		switch {
+		case m.msgType == dhcp6MsgReply && m.option(dhcp6OptRapidCommit) != nil:
+		case m.msgType == dhcp6MsgAdvertise:
+		default:
+			return fmt.Errorf("unexpected DHCPv6 message type %d", m.msgType)
+		}
+		if m.option(dhcp6OptServerID) == nil {
+			return fmt.Errorf("no server ID in DHCPv6 message")
+		}
+		_, err := l.leasedIA(m)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.msgType == dhcp6MsgReply {
+		return resp, nil
+	}
+
+	ia, _ := l.leasedIA(resp)
+	request, err := l.newMessage(dhcp6MsgRequest, ia, resp.option(dhcp6OptServerID))
+	if err != nil {
+		return nil, err
+	}
+	return c.exchange(ctx, request, l.acceptReply)
+}
+
+func (l *DHCPv6Lease) acceptReply(m *dhcp6Message) error {
+	if m.msgType != dhcp6MsgReply {
+		return fmt.Errorf("unexpected DHCPv6 message type %d", m.msgType)
+	}
+	_, err := l.leasedIA(m)
+	return err
+}
+
+func (l *DHCPv6Lease) commit(reply *dhcp6Message) error {
+	ia, err := l.leasedIA(reply)
+	if err != nil {
+		return err
+	}
+	for _, addr := range ia.addrs {
+		if addr.valid > 0 {
+			l.addr = addr
+			break
+		}
+	}
+	if serverID := reply.option(dhcp6OptServerID); serverID != nil {
+		l.serverID = serverID
+	}
+	l.dns = nil
+	for b := reply.option(dhcp6OptDNSServers); len(b) >= net.IPv6len; b = b[net.IPv6len:] {
+		l.dns = append(l.dns, net.IP(append([]byte{}, b[:net.IPv6len]...)))
+	}
+
+	// RFC 8415 leaves T1 and T2 to the client when the server sets them to 0
+	renewalTime := time.Duration(ia.t1) * time.Second
+	if ia.t1 == 0 {
+		renewalTime = time.Duration(l.addr.preferred) * time.Second / 2
+	}
+	rebindingTime := time.Duration(ia.t2) * time.Second
+	if ia.t2 == 0 {
+		rebindingTime = time.Duration(l.addr.preferred) * time.Second * 4 / 5
+	}
+
+	now := time.Now()
+	l.expireTime = now.Add(time.Duration(l.addr.valid) * time.Second)
+	l.renewalTime = now.Add(renewalTime)
+	l.rebindingTime = now.Add(rebindingTime)
+	l.expiry.Store(l.expireTime.UnixNano())
+	l.storeInfo()
+
+	if l.persist != nil {
+		l.persist(&leaseRecord{
+			ClientID:      l.clientID,
+			IPv6:          true,
+			RenewalTime:   l.renewalTime,
+			RebindingTime: l.rebindingTime,
+			ExpireTime:    l.expireTime,
+			ServerID:      l.serverID,
+			Address:       l.addr.ip,
+			Preferred:     l.addr.preferred,
+			Valid:         l.addr.valid,
+			DNS:           l.dns,
+		})
+	}
+	return nil
+}
+
+func (l *DHCPv6Lease) maintain() {
+	state := leaseStateBound
+
+	for {
+		var sleepDur time.Duration
+
+		linkCheckCtx, cancel := context.WithTimeoutCause(l.ctx, l.resendTimeout, errNoMoreTries)
+		defer cancel()
+		linkExists, _ := checkLinkExistsWithBackoff(linkCheckCtx, l.linkName)
+		if !linkExists {
+			log.Printf("%v: interface %s no longer exists or link check failed, terminating DHCPv6 lease maintenance", l.clientID, l.linkName)
+			return
+		}
+
+		switch state {
+		case leaseStateBound:
+			sleepDur = time.Until(l.renewalTime)
+			if sleepDur <= 0 {
+				log.Printf("%v: renewing DHCPv6 lease", l.clientID)
+				state = leaseStateRenewing
+				continue
+			}
+
+		case leaseStateRenewing:
+			prev := paramsOf(l)
+			if err := l.extend(dhcp6MsgRenew); err != nil {
+				log.Printf("%v: %v", l.clientID, err)
+				l.renewalFailed()
+
+				if time.Now().After(l.rebindingTime) {
+					log.Printf("%v: DHCPv6 renewal time expired, rebinding", l.clientID)
+					state = leaseStateRebinding
+				}
+			} else {
+				log.Printf("%v: DHCPv6 lease renewed, expiration is %v", l.clientID, l.expireTime)
+				l.afterRenewal(prev)
+				state = leaseStateBound
+			}
+
+		case leaseStateRebinding:
+			prev := paramsOf(l)
+			if err := l.extend(dhcp6MsgRebind); err != nil {
+				log.Printf("%v: %v", l.clientID, err)
+				l.renewalFailed()
+
+				// The interface may still have an IPv4 lease, so it is left up
+				if time.Now().After(l.expireTime) {
+					log.Printf("%v: DHCPv6 lease expired, terminating lease maintenance", l.clientID)
+					return
+				}
+			} else {
+				log.Printf("%v: DHCPv6 lease rebound, expiration is %v", l.clientID, l.expireTime)
+				l.afterRenewal(prev)
+				state = leaseStateBound
+			}
+		}
+
+		select {
+		case <-time.After(sleepDur):
+
+		case <-l.check:
+			log.Printf("%v: Checking DHCPv6 lease", l.clientID)
+
+		case <-l.stop:
+			if l.detached.Load() {
+				return
+			}
+			if err := l.release(); err != nil {
+				log.Printf("%v: failed to release DHCPv6 lease: %v", l.clientID, err)
+			}
+			return
+		}
+	}
+}
+
+// extend extends the lease with a Renew to the server that granted it, or
+// a Rebind to any server.
+func (l *DHCPv6Lease) extend(msgType byte) error {
+	c, err := newDHCP6Client(l.linkName, l.allowedServers, l.timeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var serverID []byte
+	if msgType == dhcp6MsgRenew {
+		serverID = l.serverID
+	}
+	ia := &iaNA{iaid: l.iaid, addrs: []iaAddr{l.addr}}
+
+	timeoutCtx, cancel := context.WithTimeoutCause(l.ctx, l.resendTimeout, errNoMoreTries)
+	defer cancel()
+	reply, err := backoffRetry(timeoutCtx, l.resendMax, func() (*dhcp6Message, error) {
+		msg, err := l.newMessage(msgType, ia, serverID)
+		if err != nil {
+			return nil, err
+		}
+		return c.exchange(timeoutCtx, msg, l.acceptReply)
+	})
+	if err != nil {
+		return err
+	}
+
+	return l.commit(reply)
+}
+
+func (l *DHCPv6Lease) release() error {
+	log.Printf("%v: releasing DHCPv6 lease", l.clientID)
+
+	c, err := newDHCP6Client(l.linkName, l.allowedServers, l.timeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	msg, err := l.newMessage(dhcp6MsgRelease, &iaNA{iaid: l.iaid, addrs: []iaAddr{l.addr}}, l.serverID)
+	if err != nil {
+		return err
+	}
+	if err := c.send(msg); err != nil {
+		return fmt.Errorf("failed to send DHCPv6 Release: %v", err)
+	}
+	return nil
+}
+
+// IPNet returns the leased address. DHCPv6 does not tell the prefix of the
+// link, which router advertisements do, so it is a /128.
+func (l *DHCPv6Lease) IPNet() (*net.IPNet, error) {
+	return &net.IPNet{IP: l.addr.ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Gateway returns nil, as routers are only learnt from router advertisements.
+func (l *DHCPv6Lease) Gateway() net.IP {
+	return nil
+}
+
+func (l *DHCPv6Lease) Routes() []*types.Route {
+	return nil
+}
+
+func (l *DHCPv6Lease) DNS() types.DNS {
+	dns := types.DNS{}
+	for _, ip := range l.dns {
+		dns.Nameservers = append(dns.Nameservers, ip.String())
+	}
+	return dns
+}
+
+// MTU returns 0, as DHCPv6 has no MTU option.
+func (l *DHCPv6Lease) MTU() int {
+	return 0
+}
+
+// ExpireTime returns when the lease expires.
+func (l *DHCPv6Lease) ExpireTime() time.Time {
+	return time.Unix(0, l.expiry.Load())
+}
+
+// RenewalFailures returns how many times renewing or rebinding the lease failed.
+func (l *DHCPv6Lease) RenewalFailures() uint64 {
+	return l.renewalFailures.Load()
+}
+
+// Info returns the details of the lease.
+func (l *DHCPv6Lease) Info() LeaseInfo {
+	info := LeaseInfo{Family: "ipv6"}
+	if i := l.info.Load(); i != nil {
+		info = *i
+	}
+	info.RenewalFailures = l.RenewalFailures()
+	return info
+}
+
+func (l *DHCPv6Lease) storeInfo() {
+	info := dhcp6Info(l.addr.ip, l.serverID, l.dns)
+	info.RenewalTime = l.renewalTime
+	info.RebindingTime = l.rebindingTime
+	info.ExpireTime = l.expireTime
+	l.info.Store(info)
+}
+
+func (l *DHCPv6Lease) renewalFailed() {
+	l.renewalFailures.Add(1)
+	renewalFailuresIPv6.Add(1)
+}
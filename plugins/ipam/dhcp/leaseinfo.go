@@ -0,0 +1,160 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"sort"
+	"strings"
+	"time"
+
+	dhcp4 "github.com/insomniacslk/dhcp/dhcpv4"
+
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+)
+
+// LeaseQuery selects the leases returned by DHCP.LeaseInfo: those of the
+// interface IfName of the container ContainerID, on Network if set.
+type LeaseQuery struct {
+	ContainerID string
+	IfName      string
+	Network     string
+}
+
+// LeaseInfo describes a lease maintained by the daemon.
+type LeaseInfo struct {
+	// Key identifies the lease, as in the daemon logs and metrics
+	Key    string `json:"key"`
+	Family string `json:"family"`
+	// Address is the leased address with the prefix length of the link
+	Address string `json:"address"`
+	Gateway string `json:"gateway,omitempty"`
+	// Server is the server identifier of a DHCPv4 lease, or the DUID of
+	// the server of a DHCPv6 lease in hex
+	Server          string    `json:"server,omitempty"`
+	RenewalTime     time.Time `json:"renewalTime"`
+	RebindingTime   time.Time `json:"rebindingTime"`
+	ExpireTime      time.Time `json:"expireTime"`
+	RenewalFailures uint64    `json:"renewalFailures"`
+	// Options are the other options of the lease, by name
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// LeaseInfo returns the details of the leases matching query, sorted by key.
+func (d *DHCP) LeaseInfo(query *LeaseQuery, reply *[]LeaseInfo) error {
+	if query.ContainerID == "" || query.IfName == "" {
+		return fmt.Errorf("container ID and interface name must be set")
+	}
+
+	d.mux.Lock()
+	leases := map[string]lease{}
+	for key, l := range d.leases {
+		if query.matches(key) {
+			leases[key] = l
+		}
+	}
+	d.mux.Unlock()
+
+	infos := []LeaseInfo{}
+	for key, l := range leases {
+		info := l.Info()
+		info.Key = key
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	*reply = infos
+	return nil
+}
+
+// matches returns whether the lease of key, as generated by
+// generateClientID or clientIDv6, is selected by q. Network names and
+// container IDs have no slashes.
+func (q *LeaseQuery) matches(key string) bool {
+	parts := strings.Split(strings.TrimSuffix(key, "/ipv6"), "/")
+	if len(parts) != 3 {
+		return false
+	}
+	return parts[0] == q.ContainerID && parts[2] == q.IfName && (q.Network == "" || parts[1] == q.Network)
+}
+
+// dhcp4Info returns the details of the DHCPv4 lease ack, but for its
+// timers.
+func dhcp4Info(ack *dhcp4.DHCPv4) *LeaseInfo {
+	info := &LeaseInfo{Family: "ipv4", Options: map[string]string{}}
+	ipn := net.IPNet{IP: ack.YourIPAddr, Mask: ack.SubnetMask()}
+	if ipn.Mask != nil {
+		info.Address = ipn.String()
+	} else {
+		info.Address = ack.YourIPAddr.String()
+	}
+	if routers := ack.Router(); len(routers) > 0 {
+		info.Gateway = routers[0].String()
+	}
+	if server := ack.ServerIdentifier(); server != nil {
+		info.Server = server.String()
+	}
+	for code, value := range ack.Options {
+		switch dhcp4.GenericOptionCode(code).Code() {
+		case dhcp4.OptionSubnetMask.Code(), dhcp4.OptionServerIdentifier.Code(), dhcp4.OptionDHCPMessageType.Code(), dhcp4.OptionEnd.Code():
+			continue
+		}
+		// Options humanizes the options it knows, as "name: value"
+		name, value, _ := strings.Cut(strings.TrimSpace(dhcp4.Options{code: value}.String()), ": ")
+		info.Options[name] = value
+	}
+	return info
+}
+
+// dhcp6Info returns the details of the DHCPv6 lease of addr, but for its
+// timers.
+func dhcp6Info(addr net.IP, serverID []byte, dns []net.IP) *LeaseInfo {
+	info := &LeaseInfo{
+		Family:  "ipv6",
+		Address: (&net.IPNet{IP: addr, Mask: net.CIDRMask(128, 128)}).String(),
+		Server:  hex.EncodeToString(serverID),
+		Options: map[string]string{},
+	}
+	if len(dns) > 0 {
+		servers := []string{}
+		for _, ip := range dns {
+			servers = append(servers, ip.String())
+		}
+		info.Options["DNS Recursive Name Server"] = strings.Join(servers, ", ")
+	}
+	return info
+}
+
+// printLeaseInfo prints the leases matching query of the daemon listening
+// on socketPath, as JSON.
+func printLeaseInfo(w io.Writer, socketPath string, query *LeaseQuery) error {
+	client, err := rpc.DialHTTP("unix", socketPath)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "error dialing DHCP daemon")
+	}
+	defer client.Close()
+
+	infos := []LeaseInfo{}
+	if err := client.Call("DHCP.LeaseInfo", query, &infos); err != nil {
+		return fmt.Errorf("error calling DHCP.LeaseInfo: %v", err)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(infos)
+}
@@ -0,0 +1,104 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	dhcp4 "github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+func TestLeaseInfo(t *testing.T) {
+	d := newDHCP(time.Second, time.Second, time.Second)
+
+	ack, err := dhcp4.New(
+		dhcp4.WithYourIP(net.IPv4(10, 1, 2, 3)),
+		dhcp4.WithNetmask(net.CIDRMask(24, 32)),
+		dhcp4.WithRouter(net.IPv4(10, 1, 2, 1)),
+		dhcp4.WithDNS(net.IPv4(10, 1, 2, 53)),
+		dhcp4.WithServerIP(net.IPv4(10, 1, 2, 254)),
+		dhcp4.WithOption(dhcp4.OptServerIdentifier(net.IPv4(10, 1, 2, 254))),
+		dhcp4.WithLeaseTime(3600),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l4 := &DHCPLease{}
+	l4.commit(&nclient4.Lease{ACK: ack})
+	l4.renewalFailed()
+	d.setLease("ctr/net/eth0", l4)
+
+	l6 := &DHCPv6Lease{}
+	l6.restore(&leaseRecord{
+		IPv6:       true,
+		ServerID:   []byte{0, 3, 0, 1, 2, 0, 0, 0, 0, 1},
+		Address:    net.ParseIP("fd00::3"),
+		DNS:        []net.IP{net.ParseIP("fd00::53")},
+		ExpireTime: l4.expireTime,
+	})
+	d.setLease(clientIDv6("ctr/net/eth0"), l6)
+	d.setLease("ctr/other/eth0", &DHCPLease{})
+	d.setLease("ctr/net/net1", &DHCPLease{})
+
+	infos := []LeaseInfo{}
+	if err := d.LeaseInfo(&LeaseQuery{ContainerID: "ctr", IfName: "eth0", Network: "net"}, &infos); err != nil {
+		t.Fatal(err)
+	}
+	expected := []LeaseInfo{
+		{
+			Key:             "ctr/net/eth0",
+			Family:          "ipv4",
+			Address:         "10.1.2.3/24",
+			Gateway:         "10.1.2.1",
+			Server:          "10.1.2.254",
+			RenewalTime:     l4.renewalTime,
+			RebindingTime:   l4.rebindingTime,
+			ExpireTime:      l4.expireTime,
+			RenewalFailures: 1,
+			Options: map[string]string{
+				"Router":                  "10.1.2.1",
+				"Domain Name Server":      "10.1.2.53",
+				"IP Addresses Lease Time": "1h0m0s",
+			},
+		},
+		{
+			Key:        "ctr/net/eth0/ipv6",
+			Family:     "ipv6",
+			Address:    "fd00::3/128",
+			Server:     "00030001020000000001",
+			ExpireTime: l4.expireTime,
+			Options: map[string]string{
+				"DNS Recursive Name Server": "fd00::53",
+			},
+		},
+	}
+	if !reflect.DeepEqual(infos, expected) {
+		t.Errorf("unexpected leases:\n%+v\nexpected:\n%+v", infos, expected)
+	}
+
+	if err := d.LeaseInfo(&LeaseQuery{ContainerID: "ctr", IfName: "eth0"}, &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 3 {
+		t.Errorf("expected the leases of all the networks, got %+v", infos)
+	}
+	if err := d.LeaseInfo(&LeaseQuery{ContainerID: "ctr"}, &infos); err == nil {
+		t.Errorf("expected a query without interface to fail")
+	}
+}
@@ -16,18 +16,23 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/rpc"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/status"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
 
@@ -53,6 +58,51 @@ type IPAMConfig struct {
 	RequestOptions []RequestOption `json:"request"`
 	// The metric of routes
 	Priority int `json:"priority,omitempty"`
+	// Templates of the client identifier and the host-name option. {containerID},
+	// {network} and {ifName} are substituted, and so is any other {KEY} with the
+	// value of KEY in CNI_ARGS, e.g. {K8S_POD_NAME}.
+	ClientID string `json:"clientID,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	// The options of the lease to return in the result besides routes: "dns"
+	// and "mtu", which sets the MTU of the routes. They are requested from
+	// the server too.
+	ResultOptions []string `json:"result,omitempty"`
+	// The vendor class identifier option, to be leased from vendor-class-based pools
+	VendorClass string `json:"vendorClass,omitempty"`
+	// Whether DHCPv4 replies are requested to be broadcast, overriding the
+	// -broadcast flag of the daemon
+	Broadcast *bool `json:"broadcast,omitempty"`
+	// The only servers to lease from, if set. DHCPv4 servers are matched by
+	// their server identifier, DHCPv6 servers by the source address of their
+	// messages.
+	AllowedServers []string `json:"allowedServers,omitempty"`
+	// Whether the plugin leases addresses itself rather than through the
+	// daemon, recording them in StateDir for `dhcp renew` to renew
+	Daemonless bool   `json:"daemonless,omitempty"`
+	StateDir   string `json:"stateDir,omitempty"`
+	// Whether to lease an IPv4 address with DHCP, true unless set to false
+	EnableIPv4 *bool `json:"enableIPv4,omitempty"`
+	// Whether to lease an IPv6 address with DHCPv6 IA_NA
+	EnableIPv6 bool `json:"enableIPv6,omitempty"`
+	// What is done when a renewal changes the routes, DNS servers or MTU
+	// of a lease
+	OnRenewal *RenewalHooks `json:"onRenewal,omitempty"`
+}
+
+func (c *IPAMConfig) ipv4Enabled() bool {
+	return c.EnableIPv4 == nil || *c.EnableIPv4
+}
+
+func (c *IPAMConfig) allowedServers() ([]net.IP, error) {
+	var servers []net.IP
+	for _, s := range c.AllowedServers {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid allowed DHCP server %q", s)
+		}
+		servers = append(servers, ip)
+	}
+	return servers, nil
 }
 
 // DHCPOption represents a DHCP option. It can be a number, or a string defined in manual dhcp-options(5).
@@ -77,6 +127,8 @@ func main() {
 		var pidfilePath string
 		var hostPrefix string
 		var socketPath string
+		var stateDir string
+		var metricsAddr string
 		var broadcast bool
 		var timeout time.Duration
 		var resendMax time.Duration
@@ -85,8 +137,10 @@ func main() {
 		daemonFlags.StringVar(&pidfilePath, "pidfile", "", "optional path to write daemon PID to")
 		daemonFlags.StringVar(&hostPrefix, "hostprefix", "", "optional prefix to host root")
 		daemonFlags.StringVar(&socketPath, "socketpath", "", "optional dhcp server socketpath")
+		daemonFlags.StringVar(&stateDir, "statedir", defaultStateDir, "optional directory to persist leases to, resumed when the daemon restarts; empty to disable")
+		daemonFlags.StringVar(&metricsAddr, "metricsaddr", "", "optional address to serve /healthz and Prometheus /metrics on, host:port or unix:path")
 		daemonFlags.BoolVar(&broadcast, "broadcast", false, "broadcast DHCP leases")
-		daemonFlags.DurationVar(&timeout, "timeout", 10*time.Second, "optional dhcp client timeout duration for each request")
+		daemonFlags.DurationVar(&timeout, "timeout", defaultTimeout, "optional dhcp client timeout duration for each request")
 		daemonFlags.DurationVar(&resendMax, "resendmax", resendDelayMax, "optional dhcp client max resend delay between requests")
 		daemonFlags.DurationVar(&resendTimeout, "resendtimeout", defaultResendTimeout, "optional dhcp client resend timeout, no more retries after this timeout")
 		daemonFlags.Parse(os.Args[2:])
@@ -95,17 +149,57 @@ func main() {
 			socketPath = defaultSocketPath
 		}
 
-		if err := runDaemon(pidfilePath, hostPrefix, socketPath, timeout, resendMax, resendTimeout, broadcast); err != nil {
+		if err := runDaemon(pidfilePath, hostPrefix, socketPath, stateDir, metricsAddr, timeout, resendMax, resendTimeout, broadcast); err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+	} else if len(os.Args) > 1 && os.Args[1] == "renew" {
+		var hostPrefix string
+		var stateDir string
+		var timeout time.Duration
+		var resendMax time.Duration
+		var resendTimeout time.Duration
+		renewFlags := flag.NewFlagSet("renew", flag.ExitOnError)
+		renewFlags.StringVar(&hostPrefix, "hostprefix", "", "optional prefix to host root")
+		renewFlags.StringVar(&stateDir, "statedir", defaultStateDir, "optional directory the leases of daemonless networks are recorded in")
+		renewFlags.DurationVar(&timeout, "timeout", defaultTimeout, "optional dhcp client timeout duration for each request")
+		renewFlags.DurationVar(&resendMax, "resendmax", resendDelayMax, "optional dhcp client max resend delay between requests")
+		renewFlags.DurationVar(&resendTimeout, "resendtimeout", defaultResendTimeout, "optional dhcp client resend timeout, no more retries after this timeout")
+		renewFlags.Parse(os.Args[2:])
+
+		store, err := newLeaseStore(hostPrefix + stateDir)
+		if err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+		dhcp := newDHCP(timeout, resendMax, resendTimeout)
+		dhcp.hostNetnsPrefix = hostPrefix
+		dhcp.store = store
+		if err := dhcp.renewLeases(); err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+	} else if len(os.Args) > 1 && os.Args[1] == "lease-info" {
+		var socketPath string
+		query := LeaseQuery{}
+		infoFlags := flag.NewFlagSet("lease-info", flag.ExitOnError)
+		infoFlags.StringVar(&socketPath, "socketpath", defaultSocketPath, "optional dhcp server socketpath")
+		infoFlags.StringVar(&query.ContainerID, "containerid", "", "the ID of the container of the leases")
+		infoFlags.StringVar(&query.IfName, "ifname", "", "the name of the container interface of the leases")
+		infoFlags.StringVar(&query.Network, "network", "", "optional name of the network of the leases")
+		infoFlags.Parse(os.Args[2:])
+
+		if err := printLeaseInfo(os.Stdout, socketPath, &query); err != nil {
 			log.Print(err.Error())
 			os.Exit(1)
 		}
 	} else {
 		skel.PluginMainFuncs(skel.CNIFuncs{
-			Add:   cmdAdd,
-			Check: cmdCheck,
-			Del:   cmdDel,
+			Add:    cmdAdd,
+			Check:  cmdCheck,
+			Del:    cmdDel,
+			Status: cmdStatus,
 			/* FIXME GC */
-			/* FIXME Status */
 		}, version.All, bv.BuildString("dhcp"))
 	}
 }
@@ -119,14 +213,34 @@ func cmdAdd(args *skel.CmdArgs) error {
 	}
 
 	result := &current.Result{CNIVersion: current.ImplementedSpecVersion}
-	if err := rpcCall("DHCP.Allocate", args, result); err != nil {
+	d, err := newOneshotDHCP(args.StdinData)
+	if err != nil {
 		return err
 	}
+	if d != nil {
+		if err := absNetns(args); err != nil {
+			return err
+		}
+		err = d.allocateOnce(args, result)
+	} else {
+		err = rpcCall("DHCP.Allocate", args, result)
+	}
+	if err != nil {
+		return leaseError(err)
+	}
 
 	return types.PrintResult(result, confVersion)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
+	d, err := newOneshotDHCP(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if d != nil {
+		return d.releaseOnce(args)
+	}
+
 	result := struct{}{}
 	return rpcCall("DHCP.Release", args, &result)
 }
@@ -140,10 +254,45 @@ func cmdCheck(args *skel.CmdArgs) error {
 		return err
 	}
 
+	d, err := newOneshotDHCP(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if d != nil {
+		return d.checkOnce(args)
+	}
+
 	result := &current.Result{CNIVersion: current.ImplementedSpecVersion}
 	return rpcCall("DHCP.Allocate", args, result)
 }
 
+// cmdStatus checks that the daemon is reachable, or for daemonless networks
+// that their leases can be recorded.
+func cmdStatus(args *skel.CmdArgs) error {
+	conf := NetConf{}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("error parsing netconf: %v", err)
+	}
+	if conf.IPAM != nil && conf.IPAM.Daemonless {
+		stateDir := conf.IPAM.StateDir
+		if stateDir == "" {
+			stateDir = defaultStateDir
+		}
+		return status.Writable(stateDir)
+	}
+
+	socketPath, err := getSocketPath(args.StdinData)
+	if err != nil {
+		return fmt.Errorf("error obtaining socketPath: %v", err)
+	}
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return types.NewError(cnierrors.ErrPluginNotAvailable, "DHCP daemon is not reachable", err.Error())
+	}
+	conn.Close()
+	return nil
+}
+
 func getSocketPath(stdinData []byte) (string, error) {
 	conf := NetConf{}
 	if err := json.Unmarshal(stdinData, &conf); err != nil {
@@ -163,16 +312,12 @@ func rpcCall(method string, args *skel.CmdArgs, result interface{}) error {
 
 	client, err := rpc.DialHTTP("unix", socketPath)
 	if err != nil {
-		return fmt.Errorf("error dialing DHCP daemon: %v", err)
+		return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "error dialing DHCP daemon")
 	}
 
-	// The daemon may be running under a different working dir
-	// so make sure the netns path is absolute.
-	netns, err := filepath.Abs(args.Netns)
-	if err != nil {
-		return fmt.Errorf("failed to make %q an absolute path: %v", args.Netns, err)
+	if err := absNetns(args); err != nil {
+		return err
 	}
-	args.Netns = netns
 
 	err = client.Call(method, args, result)
 	if err != nil {
@@ -181,3 +326,23 @@ func rpcCall(method string, args *skel.CmdArgs, result interface{}) error {
 
 	return nil
 }
+
+// leaseError returns err as a types.ErrTryAgainLater error if no DHCP server
+// answered in time. Errors of the daemon only carry their message over RPC.
+func leaseError(err error) error {
+	if errors.Is(err, errNoMoreTries) || strings.HasSuffix(err.Error(), errNoMoreTries.Error()) {
+		return types.NewError(types.ErrTryAgainLater, "no DHCP lease was acquired in time", err.Error())
+	}
+	return err
+}
+
+// absNetns makes the netns path of args absolute, as the daemon may be
+// running under a different working dir, and leases are recorded with it.
+func absNetns(args *skel.CmdArgs) error {
+	netns, err := filepath.Abs(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to make %q an absolute path: %v", args.Netns, err)
+	}
+	args.Netns = netns
+	return nil
+}
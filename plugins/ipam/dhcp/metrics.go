@@ -0,0 +1,119 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The renewal failures of all the leases, including released ones
+var (
+	renewalFailuresIPv4 atomic.Uint64
+	renewalFailuresIPv6 atomic.Uint64
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// serveMetrics serves liveness on /healthz, and the metrics of the leases of
+// d in the Prometheus text format on /metrics. addr is either host:port or
+// unix:path.
+func serveMetrics(d *DHCP, addr string) error {
+	var l net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		os.Remove(path)
+		l, err = net.Listen("unix", path)
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		d.writeMetrics(w, time.Now())
+	})
+
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Printf("error serving metrics: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (d *DHCP) writeMetrics(w io.Writer, now time.Time) {
+	d.mux.Lock()
+	keys := make([]string, 0, len(d.leases))
+	leases := make(map[string]lease, len(d.leases))
+	for key, l := range d.leases {
+		keys = append(keys, key)
+		leases[key] = l
+	}
+	d.mux.Unlock()
+	sort.Strings(keys)
+
+	count := map[string]int{"ipv4": 0, "ipv6": 0}
+	for _, l := range leases {
+		count[leaseFamily(l)]++
+	}
+
+	fmt.Fprintln(w, "# HELP cni_dhcp_leases Number of leases maintained by the daemon.")
+	fmt.Fprintln(w, "# TYPE cni_dhcp_leases gauge")
+	fmt.Fprintf(w, "cni_dhcp_leases{family=\"ipv4\"} %d\n", count["ipv4"])
+	fmt.Fprintf(w, "cni_dhcp_leases{family=\"ipv6\"} %d\n", count["ipv6"])
+
+	fmt.Fprintln(w, "# HELP cni_dhcp_lease_remaining_seconds Seconds until the lease expires.")
+	fmt.Fprintln(w, "# TYPE cni_dhcp_lease_remaining_seconds gauge")
+	for _, key := range keys {
+		l := leases[key]
+		fmt.Fprintf(w, "cni_dhcp_lease_remaining_seconds{lease=\"%s\",family=\"%s\"} %g\n",
+			labelValueEscaper.Replace(key), leaseFamily(l), l.ExpireTime().Sub(now).Seconds())
+	}
+
+	fmt.Fprintln(w, "# HELP cni_dhcp_lease_renewal_failures_total Failed renewals and rebindings of the lease.")
+	fmt.Fprintln(w, "# TYPE cni_dhcp_lease_renewal_failures_total counter")
+	for _, key := range keys {
+		l := leases[key]
+		fmt.Fprintf(w, "cni_dhcp_lease_renewal_failures_total{lease=\"%s\",family=\"%s\"} %d\n",
+			labelValueEscaper.Replace(key), leaseFamily(l), l.RenewalFailures())
+	}
+
+	fmt.Fprintln(w, "# HELP cni_dhcp_renewal_failures_total Failed renewals and rebindings of all leases.")
+	fmt.Fprintln(w, "# TYPE cni_dhcp_renewal_failures_total counter")
+	fmt.Fprintf(w, "cni_dhcp_renewal_failures_total{family=\"ipv4\"} %d\n", renewalFailuresIPv4.Load())
+	fmt.Fprintf(w, "cni_dhcp_renewal_failures_total{family=\"ipv6\"} %d\n", renewalFailuresIPv6.Load())
+}
+
+func leaseFamily(l lease) string {
+	if _, ok := l.(*DHCPv6Lease); ok {
+		return "ipv6"
+	}
+	return "ipv4"
+}
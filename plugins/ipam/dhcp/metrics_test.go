@@ -0,0 +1,58 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	now := time.Now()
+	d := newDHCP(time.Second, time.Second, time.Second)
+
+	l4 := &DHCPLease{}
+	l4.expiry.Store(now.Add(90 * time.Second).UnixNano())
+	l4.renewalFailed()
+	l4.renewalFailed()
+	d.setLease(`ctr/net/eth0`, l4)
+
+	l6 := &DHCPv6Lease{}
+	l6.expiry.Store(now.Add(-30 * time.Second).UnixNano())
+	d.setLease(clientIDv6(`ctr/"net"/eth0`), l6)
+
+	var buf bytes.Buffer
+	d.writeMetrics(&buf, now)
+	out := buf.String()
+
+	for _, line := range []string{
+		`cni_dhcp_leases{family="ipv4"} 1`,
+		`cni_dhcp_leases{family="ipv6"} 1`,
+		`cni_dhcp_lease_remaining_seconds{lease="ctr/net/eth0",family="ipv4"} 90`,
+		`cni_dhcp_lease_remaining_seconds{lease="ctr/\"net\"/eth0/ipv6",family="ipv6"} -30`,
+		`cni_dhcp_lease_renewal_failures_total{lease="ctr/net/eth0",family="ipv4"} 2`,
+		`cni_dhcp_lease_renewal_failures_total{lease="ctr/\"net\"/eth0/ipv6",family="ipv6"} 0`,
+		`# TYPE cni_dhcp_renewal_failures_total counter`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics missing %q:\n%s", line, out)
+		}
+	}
+	if renewalFailuresIPv4.Load() < 2 {
+		t.Errorf("renewal failures of all leases not counted")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// In daemonless mode, the plugin acquires the leases itself during ADD and
+// records them in the state directory rather than handing them to the
+// daemon. `dhcp renew`, e.g. run by a systemd timer, renews the recorded
+// leases that are due, and DEL releases them.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// newOneshotDHCP returns the client the plugin leases addresses with if the
+// network config of stdinData is daemonless, or nil.
+func newOneshotDHCP(stdinData []byte) (*DHCP, error) {
+	conf := NetConf{}
+	if err := json.Unmarshal(stdinData, &conf); err != nil {
+		return nil, fmt.Errorf("error parsing netconf: %v", err)
+	}
+	if conf.IPAM == nil || !conf.IPAM.Daemonless {
+		return nil, nil
+	}
+
+	stateDir := conf.IPAM.StateDir
+	if stateDir == "" {
+		stateDir = defaultStateDir
+	}
+	store, err := newLeaseStore(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("error creating state directory: %v", err)
+	}
+
+	d := newDHCP(defaultTimeout, resendDelayMax, defaultResendTimeout)
+	d.store = store
+	return d, nil
+}
+
+// allocateOnce acquires the leases of args like Allocate, but leaves them
+// to `dhcp renew` rather than maintaining them.
+func (d *DHCP) allocateOnce(args *skel.CmdArgs, result *current.Result) error {
+	err := d.Allocate(args, result)
+	d.detachLeases()
+	return err
+}
+
+// releaseOnce releases the recorded leases of args.
+func (d *DHCP) releaseOnce(args *skel.CmdArgs) error {
+	conf := NetConf{}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("error parsing netconf: %v", err)
+	}
+
+	clientID := generateClientID(args.ContainerID, conf.Name, args.IfName)
+	for _, key := range []string{clientID, clientIDv6(clientID)} {
+		rec, err := d.store.get(key)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			continue
+		}
+
+		// There is nothing to release from once the netns is gone
+		hostNetns := d.hostNetnsPrefix + rec.Args.Netns
+		if _, err := os.Stat(hostNetns); err == nil {
+			l, err := d.restoreLease(rec)
+			if err == nil {
+				err = l.inNetNS(hostNetns, rec.Args.IfName, l.release)
+			}
+			if err != nil {
+				log.Printf("%v: failed to release lease: %v", key, err)
+			}
+		}
+		if err := d.store.remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOnce checks that the leases of args are recorded and not expired.
+func (d *DHCP) checkOnce(args *skel.CmdArgs) error {
+	conf := NetConf{}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("error parsing netconf: %v", err)
+	}
+
+	clientID := generateClientID(args.ContainerID, conf.Name, args.IfName)
+	var keys []string
+	if conf.IPAM.ipv4Enabled() {
+		keys = append(keys, clientID)
+	}
+	if conf.IPAM.EnableIPv6 {
+		keys = append(keys, clientIDv6(clientID))
+	}
+	for _, key := range keys {
+		rec, err := d.store.get(key)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			return fmt.Errorf("no lease recorded for %s", key)
+		}
+		if time.Now().After(rec.ExpireTime) {
+			return fmt.Errorf("lease of %s expired at %v", key, rec.ExpireTime)
+		}
+	}
+	return nil
+}
+
+// renewLeases renews the recorded leases that are due, and forgets the
+// leases whose network namespace no longer exists.
+func (d *DHCP) renewLeases() error {
+	recs, err := d.store.load()
+	var errs []string
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	for _, rec := range recs {
+		hostNetns := d.hostNetnsPrefix + rec.Args.Netns
+		if _, err := os.Stat(hostNetns); err != nil {
+			log.Printf("%v: forgetting lease: %v", rec.Key, err)
+			if err := d.store.remove(rec.Key); err != nil {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+
+		l, err := d.restoreLease(rec)
+		if err == nil {
+			err = l.inNetNS(hostNetns, rec.Args.IfName, l.renewDue)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", rec.Key, err))
+		}
+	}
+
+	if errs != nil {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
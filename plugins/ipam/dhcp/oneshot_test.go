@@ -0,0 +1,118 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+)
+
+func TestNewOneshotDHCP(t *testing.T) {
+	d, err := newOneshotDHCP([]byte(`{"name": "net", "ipam": {"type": "dhcp"}}`))
+	if err != nil || d != nil {
+		t.Errorf("expected the daemon to be used, got %v, %v", d, err)
+	}
+
+	stateDir := filepath.Join(t.TempDir(), "state")
+	d, err = newOneshotDHCP([]byte(fmt.Sprintf(`{"name": "net", "ipam": {"type": "dhcp", "daemonless": true, "stateDir": %q}}`, stateDir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil || d.store == nil || d.store.dir != stateDir {
+		t.Errorf("expected a daemonless client recording in %s, got %+v", stateDir, d)
+	}
+}
+
+func TestCmdStatus(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "dhcp.sock")
+	stdin := []byte(fmt.Sprintf(`{"name": "net", "ipam": {"type": "dhcp", "daemonSocketPath": %q}}`, socketPath))
+	err := cmdStatus(&skel.CmdArgs{StdinData: stdin})
+	if e, ok := err.(*types.Error); !ok || e.Code != cnierrors.ErrPluginNotAvailable {
+		t.Errorf("expected the missing daemon to be reported, got %v", err)
+	}
+
+	stateDir := filepath.Join(t.TempDir(), "state")
+	stdin = []byte(fmt.Sprintf(`{"name": "net", "ipam": {"type": "dhcp", "daemonless": true, "stateDir": %q}}`, stateDir))
+	if err := cmdStatus(&skel.CmdArgs{StdinData: stdin}); err != nil {
+		t.Errorf("expected a daemonless network to be available, got %v", err)
+	}
+}
+
+func TestOneshotLeases(t *testing.T) {
+	store, err := newLeaseStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDHCP(time.Second, time.Second, time.Second)
+	d.store = store
+
+	stdin := []byte(`{"name": "net", "ipam": {"type": "dhcp", "daemonless": true, "enableIPv6": true}}`)
+	args := &skel.CmdArgs{ContainerID: "ctr", Netns: "/nonexistent/netns", IfName: "eth0", StdinData: stdin}
+	clientID := generateClientID("ctr", "net", "eth0")
+
+	if err := d.checkOnce(args); err == nil || !strings.Contains(err.Error(), "no lease recorded") {
+		t.Errorf("expected a missing lease error, got %v", err)
+	}
+
+	for _, rec := range []*leaseRecord{
+		{Key: clientID, Args: args, ExpireTime: time.Now().Add(time.Hour)},
+		{Key: clientIDv6(clientID), Args: args, IPv6: true, ExpireTime: time.Now().Add(-time.Minute)},
+	} {
+		if err := store.save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.checkOnce(args); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected an expired lease error, got %v", err)
+	}
+
+	// The leases of a missing netns are forgotten
+	if err := d.releaseOnce(args); err != nil {
+		t.Fatal(err)
+	}
+	if recs, _ := store.load(); len(recs) != 0 {
+		t.Errorf("expected the records to be removed, got %d", len(recs))
+	}
+}
+
+func TestRenewLeasesForgetsMissingNetns(t *testing.T) {
+	store, err := newLeaseStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &leaseRecord{
+		Key:  "ctr/net/eth0",
+		Args: &skel.CmdArgs{ContainerID: "ctr", Netns: "/nonexistent/netns", IfName: "eth0"},
+	}
+	if err := store.save(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newDHCP(time.Second, time.Second, time.Second)
+	d.store = store
+	if err := d.renewLeases(); err != nil {
+		t.Fatal(err)
+	}
+	if recs, _ := store.load(); len(recs) != 0 {
+		t.Errorf("expected the record to be removed, got %d", len(recs))
+	}
+}
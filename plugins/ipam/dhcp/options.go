@@ -17,10 +17,13 @@ package main
 import (
 	"fmt"
 	"net"
+	"regexp"
 	"strconv"
+	"strings"
 
 	dhcp4 "github.com/insomniacslk/dhcp/dhcpv4"
 
+	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 )
 
@@ -31,8 +34,24 @@ var optionNameToID = map[string]dhcp4.OptionCode{
 	"host-name":               dhcp4.OptionHostName,
 	"user-class":              dhcp4.OptionUserClassInformation,
 	"vendor-class-identifier": dhcp4.OptionClassIdentifier,
+	"domain-name-servers":     dhcp4.OptionDomainNameServer,
+	"domain-name":             dhcp4.OptionDomainName,
+	"domain-search":           dhcp4.OptionDNSDomainSearchList,
+	"interface-mtu":           dhcp4.OptionInterfaceMTU,
+	"ntp-servers":             dhcp4.OptionNTPServers,
+	"static-routes":           dhcp4.OptionStaticRoutingTable,
+	"classless-static-routes": dhcp4.OptionClasslessStaticRoute,
 }
 
+// resultOptions maps the lease options that can be returned in the CNI
+// result to the options requested for them. Routes are always returned.
+var resultOptions = map[string][]dhcp4.OptionCode{
+	"dns": {dhcp4.OptionDomainNameServer, dhcp4.OptionDomainName, dhcp4.OptionDNSDomainSearchList},
+	"mtu": {dhcp4.OptionInterfaceMTU},
+}
+
+var templateVariable = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
 func parseOptionName(option string) (dhcp4.OptionCode, error) {
 	if val, ok := optionNameToID[option]; ok {
 		return val, nil
@@ -44,6 +63,41 @@ func parseOptionName(option string) (dhcp4.OptionCode, error) {
 	return dhcp4.GenericOptionCode(i), nil
 }
 
+// parseCNIArgs parses the KEY1=VAL1;KEY2=VAL2 pairs of CNI_ARGS.
+func parseCNIArgs(cniArgs string) map[string]string {
+	parsed := map[string]string{}
+	for _, argPair := range strings.Split(cniArgs, ";") {
+		args := strings.SplitN(argPair, "=", 2)
+		if len(args) > 1 {
+			parsed[args[0]] = args[1]
+		}
+	}
+	return parsed
+}
+
+// expandTemplate substitutes {containerID}, {network} and {ifName} in tmpl,
+// and any other {KEY} with the value of KEY in CNI_ARGS, e.g. {K8S_POD_NAME}.
+func expandTemplate(tmpl string, args *skel.CmdArgs, netName string, cniArgs map[string]string) (string, error) {
+	var err error
+	expanded := templateVariable.ReplaceAllStringFunc(tmpl, func(match string) string {
+		name := match[1 : len(match)-1]
+		switch name {
+		case "containerID":
+			return args.ContainerID
+		case "network":
+			return netName
+		case "ifName":
+			return args.IfName
+		}
+		value, ok := cniArgs[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("template %q: %q is not a CNI_ARGS key", tmpl, name)
+		}
+		return value
+	})
+	return expanded, err
+}
+
 func classfulSubnet(sn net.IP) net.IPNet {
 	return net.IPNet{
 		IP:   sn,
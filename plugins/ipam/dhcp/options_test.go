@@ -21,6 +21,7 @@ import (
 
 	dhcp4 "github.com/insomniacslk/dhcp/dhcpv4"
 
+	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 )
 
@@ -97,3 +98,88 @@ func TestParseOptionName(t *testing.T) {
 		})
 	}
 }
+
+func TestExpandTemplate(t *testing.T) {
+	args := &skel.CmdArgs{ContainerID: "ctr", IfName: "eth0"}
+	cniArgs := parseCNIArgs("K8S_POD_NAMESPACE=ns;K8S_POD_NAME=pod")
+
+	got, err := expandTemplate("{K8S_POD_NAMESPACE}-{K8S_POD_NAME}/{network}/{ifName}/{containerID}", args, "net", cniArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ns-pod/net/eth0/ctr" {
+		t.Errorf("expandTemplate() = %q", got)
+	}
+
+	if _, err := expandTemplate("{K8S_POD_UID}", args, "net", cniArgs); err == nil {
+		t.Errorf("expected an error for a missing CNI_ARGS key")
+	}
+}
+
+func TestPrepareOptionsResult(t *testing.T) {
+	opts, err := prepareOptions(nil, nil, []RequestOption{{SkipDefault: true}}, []string{"dns", "mtu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts) != 1 || opts[0].Code != dhcp4.OptionParameterRequestList {
+		t.Fatalf("unexpected options %v", opts)
+	}
+	requested := opts[0].Value.(dhcp4.OptionCodeList)
+	for _, code := range []dhcp4.OptionCode{
+		dhcp4.OptionDomainNameServer, dhcp4.OptionDomainName,
+		dhcp4.OptionDNSDomainSearchList, dhcp4.OptionInterfaceMTU,
+	} {
+		if !requested.Has(code) {
+			t.Errorf("option %v not requested", code)
+		}
+	}
+
+	if _, err := prepareOptions(nil, nil, nil, []string{"ntp"}); err == nil {
+		t.Errorf("expected an error for an unknown result option")
+	}
+}
+
+func TestAllowedServers(t *testing.T) {
+	conf := &IPAMConfig{AllowedServers: []string{"192.168.1.1", "fe80::1"}}
+	servers, err := conf.allowedServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !serverAllowed(servers, net.ParseIP("192.168.1.1")) || !serverAllowed(servers, net.ParseIP("fe80::1")) {
+		t.Errorf("allowed server rejected")
+	}
+	if serverAllowed(servers, net.ParseIP("192.168.1.2")) {
+		t.Errorf("server not in %v allowed", servers)
+	}
+	if !serverAllowed(nil, net.ParseIP("192.168.1.2")) {
+		t.Errorf("server rejected without an allowlist")
+	}
+
+	conf.AllowedServers = []string{"dhcp.example.com"}
+	if _, err := conf.allowedServers(); err == nil {
+		t.Errorf("expected an error for an invalid server")
+	}
+}
+
+func TestLeaseOptionsVendorClass(t *testing.T) {
+	args := &skel.CmdArgs{ContainerID: "ctr", IfName: "eth0"}
+	conf := &NetConf{IPAM: &IPAMConfig{VendorClass: "pool-a"}}
+	conf.Name = "net"
+
+	opts, clientID, err := leaseOptions(args, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientID != "ctr/net/eth0" {
+		t.Errorf("unexpected client ID %q", clientID)
+	}
+	found := false
+	for _, opt := range opts {
+		if opt.Code == dhcp4.OptionClassIdentifier && opt.Value.String() == "pool-a" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("vendor class option missing from %v", opts)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// A renewal may change the routers, routes, DNS servers or MTU of a lease,
+// which ADD only handed to the runtime once. The renewal hooks of a network
+// apply the new routes and MTU to the container interface and run a
+// notification command, so that long-lived pods follow the changes made on
+// the DHCP server.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+)
+
+// notifyTimeout bounds the run of the notification command of a renewal.
+const notifyTimeout = 30 * time.Second
+
+// RenewalHooks are what is done when a renewal changes the parameters of a
+// lease.
+type RenewalHooks struct {
+	// Whether to replace the routes of the lease on the container
+	// interface, and set its MTU, as they change
+	Update bool `json:"update,omitempty"`
+	// Command run, with its arguments, in the host network namespace,
+	// with the renewalEvent as JSON on its standard input
+	Notify []string `json:"notify,omitempty"`
+}
+
+// leaseParams are the parameters of a lease that renewals may change.
+type leaseParams struct {
+	Routes []*types.Route `json:"routes,omitempty"`
+	DNS    types.DNS      `json:"dns"`
+	MTU    int            `json:"mtu,omitempty"`
+}
+
+// renewalEvent is passed to the notification command of a renewal.
+type renewalEvent struct {
+	ContainerID string      `json:"containerID"`
+	Netns       string      `json:"netns"`
+	IfName      string      `json:"ifName"`
+	Network     string      `json:"network"`
+	Lease       LeaseInfo   `json:"lease"`
+	Previous    leaseParams `json:"previous"`
+	Current     leaseParams `json:"current"`
+}
+
+// paramsOf returns the current parameters of l.
+func paramsOf(l lease) *leaseParams {
+	return &leaseParams{Routes: l.Routes(), DNS: l.DNS(), MTU: l.MTU()}
+}
+
+// renewalHook returns the hook run, in the network namespace of the
+// container, after a renewal of a lease allocated for args, or nil if the
+// network has no renewal hooks. The hook is given the parameters the lease
+// had before the renewal, and does nothing if they are unchanged.
+func (d *DHCP) renewalHook(key string, args *skel.CmdArgs, conf *NetConf) func(lease, *leaseParams) {
+	hooks := conf.IPAM.OnRenewal
+	if hooks == nil || (!hooks.Update && len(hooks.Notify) == 0) {
+		return nil
+	}
+
+	return func(l lease, prev *leaseParams) {
+		cur := paramsOf(l)
+		if reflect.DeepEqual(prev, cur) {
+			return
+		}
+		log.Printf("%v: lease parameters changed on renewal", key)
+		// As in the result of ADD
+		for _, p := range []*leaseParams{prev, cur} {
+			for _, r := range p.Routes {
+				r.Priority = conf.IPAM.Priority
+			}
+		}
+
+		if hooks.Update {
+			if err := applyRenewal(args.IfName, l, prev, cur); err != nil {
+				log.Printf("%v: failed to update interface %s: %v", key, args.IfName, err)
+			}
+		}
+		if len(hooks.Notify) > 0 {
+			info := l.Info()
+			info.Key = key
+			event := &renewalEvent{
+				ContainerID: args.ContainerID,
+				Netns:       args.Netns,
+				IfName:      args.IfName,
+				Network:     conf.Name,
+				Lease:       info,
+				Previous:    *prev,
+				Current:     *cur,
+			}
+			// The lease goroutine is locked in the container network
+			// namespace, unlike a new one
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- runNotify(hooks.Notify, event)
+			}()
+			if err := <-errCh; err != nil {
+				log.Printf("%v: renewal notification failed: %v", key, err)
+			}
+		}
+	}
+}
+
+// applyRenewal replaces the routes of prev by those of cur on the interface
+// ifName, and sets its MTU to that of cur if it changed.
+func applyRenewal(ifName string, l lease, prev, cur *leaseParams) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	ipn, err := l.IPNet()
+	if err != nil {
+		return err
+	}
+
+	route := func(r *types.Route) *netlink.Route {
+		nr := &netlink.Route{
+			Dst:       &r.Dst,
+			LinkIndex: link.Attrs().Index,
+			Gw:        r.GW,
+			Priority:  r.Priority,
+		}
+		if r.GW != nil && !r.GW.IsLinkLocalUnicast() && !ipn.Contains(r.GW) {
+			nr.Flags = int(netlink.FLAG_ONLINK)
+		}
+		return nr
+	}
+	kept := map[string]bool{}
+	for _, r := range cur.Routes {
+		kept[r.String()] = true
+	}
+	for _, r := range prev.Routes {
+		if kept[r.String()] {
+			continue
+		}
+		if err := netlink.RouteDel(route(r)); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to delete route %v: %v", r, err)
+		}
+	}
+	for _, r := range cur.Routes {
+		if err := netlink.RouteReplace(route(r)); err != nil {
+			return fmt.Errorf("failed to replace route %v: %v", r, err)
+		}
+	}
+
+	if cur.MTU > 0 && cur.MTU != prev.MTU && cur.MTU != link.Attrs().MTU {
+		if err := netlink.LinkSetMTU(link, cur.MTU); err != nil {
+			return fmt.Errorf("failed to set MTU of %q to %d: %v", ifName, cur.MTU, err)
+		}
+	}
+	return nil
+}
+
+// runNotify runs the notification command of a renewal with event.
+func runNotify(command []string, event *renewalEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"CNI_CONTAINERID="+event.ContainerID,
+		"CNI_NETNS="+event.Netns,
+		"CNI_IFNAME="+event.IfName,
+	)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %v: %s", command, err, out)
+	}
+	return nil
+}
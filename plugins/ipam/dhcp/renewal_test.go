@@ -0,0 +1,106 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dhcp4 "github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+func renewalAck(t *testing.T, router, dns net.IP) *nclient4.Lease {
+	t.Helper()
+	ack, err := dhcp4.New(
+		dhcp4.WithYourIP(net.IPv4(10, 1, 2, 3)),
+		dhcp4.WithNetmask(net.CIDRMask(24, 32)),
+		dhcp4.WithRouter(router),
+		dhcp4.WithDNS(dns),
+		dhcp4.WithOption(dhcp4.OptServerIdentifier(net.IPv4(10, 1, 2, 254))),
+		dhcp4.WithLeaseTime(3600),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &nclient4.Lease{ACK: ack}
+}
+
+func TestRenewalHookNotifies(t *testing.T) {
+	d := newDHCP(time.Second, time.Second, time.Second)
+	out := filepath.Join(t.TempDir(), "event.json")
+	args := &skel.CmdArgs{ContainerID: "ctr", Netns: "/var/run/netns/ctr", IfName: "eth0"}
+	conf := &NetConf{IPAM: &IPAMConfig{
+		Priority:  100,
+		OnRenewal: &RenewalHooks{Notify: []string{"/bin/sh", "-c", "cat > " + out}},
+	}}
+	conf.Name = "net"
+
+	hook := d.renewalHook("ctr/net/eth0", args, conf)
+	if hook == nil {
+		t.Fatal("expected a renewal hook")
+	}
+
+	l := &DHCPLease{}
+	l.commit(renewalAck(t, net.IPv4(10, 1, 2, 1), net.IPv4(10, 1, 2, 53)))
+
+	// An unchanged lease is not notified
+	hook(l, paramsOf(l))
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("expected no notification, got %v", err)
+	}
+
+	prev := paramsOf(l)
+	l.commit(renewalAck(t, net.IPv4(10, 1, 2, 2), net.IPv4(10, 1, 2, 54)))
+	hook(l, prev)
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := renewalEvent{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ContainerID != "ctr" || event.IfName != "eth0" || event.Network != "net" || event.Lease.Key != "ctr/net/eth0" {
+		t.Errorf("unexpected event %s", data)
+	}
+	if len(event.Previous.Routes) != 1 || !event.Previous.Routes[0].GW.Equal(net.IPv4(10, 1, 2, 1)) {
+		t.Errorf("unexpected previous routes %v", event.Previous.Routes)
+	}
+	if len(event.Current.Routes) != 1 || !event.Current.Routes[0].GW.Equal(net.IPv4(10, 1, 2, 2)) || event.Current.Routes[0].Priority != 100 {
+		t.Errorf("unexpected current routes %v", event.Current.Routes)
+	}
+	if len(event.Current.DNS.Nameservers) != 1 || event.Current.DNS.Nameservers[0] != "10.1.2.54" {
+		t.Errorf("unexpected current DNS %v", event.Current.DNS)
+	}
+}
+
+func TestRenewalHookDisabled(t *testing.T) {
+	d := newDHCP(time.Second, time.Second, time.Second)
+	args := &skel.CmdArgs{ContainerID: "ctr", IfName: "eth0"}
+
+	for _, hooks := range []*RenewalHooks{nil, {}} {
+		if hook := d.renewalHook("ctr/net/eth0", args, &NetConf{IPAM: &IPAMConfig{OnRenewal: hooks}}); hook != nil {
+			t.Errorf("expected no renewal hook for %v", hooks)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+const defaultStateDir = "/var/lib/cni/dhcp"
+
+// leaseRecord is a lease persisted in the state directory, for the daemon
+// to resume maintaining it when it restarts.
+type leaseRecord struct {
+	// The key of the lease in the daemon and the request that allocated it
+	Key  string        `json:"key"`
+	Args *skel.CmdArgs `json:"args"`
+
+	ClientID      string    `json:"clientID"`
+	IPv6          bool      `json:"ipv6,omitempty"`
+	RenewalTime   time.Time `json:"renewalTime"`
+	RebindingTime time.Time `json:"rebindingTime"`
+	ExpireTime    time.Time `json:"expireTime"`
+
+	// The DHCPOFFER and the latest DHCPACK of a DHCPv4 lease
+	Offer []byte `json:"offer,omitempty"`
+	ACK   []byte `json:"ack,omitempty"`
+
+	// The server and the address of a DHCPv6 lease
+	ServerID  []byte   `json:"serverID,omitempty"`
+	Address   net.IP   `json:"address,omitempty"`
+	Preferred uint32   `json:"preferred,omitempty"`
+	Valid     uint32   `json:"valid,omitempty"`
+	DNS       []net.IP `json:"dns,omitempty"`
+}
+
+// leaseStore keeps one file per lease in a directory. A nil leaseStore
+// keeps nothing.
+type leaseStore struct {
+	dir string
+}
+
+func newLeaseStore(dir string) (*leaseStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &leaseStore{dir: dir}, nil
+}
+
+// path returns the file of the lease of key, which is hashed as client IDs
+// may hold any character.
+func (s *leaseStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *leaseStore) save(rec *leaseRecord) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	path := s.path(rec.Key)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *leaseStore) remove(key string) error {
+	if s == nil {
+		return nil
+	}
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// get returns the lease of key, or nil if there is none.
+func (s *leaseStore) get(key string) (*leaseRecord, error) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	rec := &leaseRecord{}
+	if err := json.Unmarshal(data, rec); err != nil || rec.Args == nil {
+		return nil, fmt.Errorf("invalid lease record %s", s.path(key))
+	}
+	return rec, nil
+}
+
+// load returns all the leases of the store. Files that cannot be read are
+// skipped and returned as an error.
+func (s *leaseStore) load() ([]*leaseRecord, error) {
+	if s == nil {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var recs []*leaseRecord
+	var errs []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		rec := &leaseRecord{}
+		if err := json.Unmarshal(data, rec); err != nil || rec.Args == nil {
+			errs = append(errs, fmt.Sprintf("invalid lease record %s", entry.Name()))
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	if errs != nil {
+		return recs, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return recs, nil
+}
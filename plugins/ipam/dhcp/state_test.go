@@ -0,0 +1,119 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+func TestLeaseStore(t *testing.T) {
+	store, err := newLeaseStore(filepath.Join(t.TempDir(), "leases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expire := time.Now().Add(time.Hour).Truncate(time.Second)
+	rec := &leaseRecord{
+		Key:        clientIDv6("ctr/net/eth0"),
+		Args:       &skel.CmdArgs{ContainerID: "ctr", Netns: "/var/run/netns/ctr", IfName: "eth0"},
+		ClientID:   "ctr/net/eth0",
+		IPv6:       true,
+		ExpireTime: expire,
+		Address:    net.ParseIP("2001:db8::10"),
+		Valid:      3600,
+	}
+	if err := store.save(rec); err != nil {
+		t.Fatal(err)
+	}
+	// Saving again replaces the record
+	if err := store.save(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	got := recs[0]
+	if got.Key != rec.Key || got.Args.IfName != "eth0" || !got.IPv6 ||
+		!got.ExpireTime.Equal(expire) || !got.Address.Equal(rec.Address) || got.Valid != 3600 {
+		t.Errorf("record mismatch: got %+v", got)
+	}
+
+	if err := store.remove(rec.Key); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.remove(rec.Key); err != nil {
+		t.Errorf("removing a missing record: %v", err)
+	}
+	if recs, _ := store.load(); len(recs) != 0 {
+		t.Errorf("expected no records, got %d", len(recs))
+	}
+}
+
+func TestLeaseStoreInvalidRecord(t *testing.T) {
+	store, err := newLeaseStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(store.dir, "bad.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.save(&leaseRecord{Key: "good", Args: &skel.CmdArgs{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := store.load()
+	if err == nil {
+		t.Errorf("expected an error for the invalid record")
+	}
+	if len(recs) != 1 || recs[0].Key != "good" {
+		t.Errorf("expected the valid record, got %v", recs)
+	}
+}
+
+func TestResumeLeasesForgetsMissingNetns(t *testing.T) {
+	store, err := newLeaseStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &leaseRecord{
+		Key:  "ctr/net/eth0",
+		Args: &skel.CmdArgs{ContainerID: "ctr", Netns: "/nonexistent/netns", IfName: "eth0"},
+	}
+	if err := store.save(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newDHCP(time.Second, time.Second, time.Second)
+	d.store = store
+	d.resumeLeases()
+
+	if l := d.getLease(rec.Key); l != nil {
+		t.Errorf("resumed a lease of a missing netns")
+	}
+	if recs, _ := store.load(); len(recs) != 0 {
+		t.Errorf("expected the record to be removed, got %d", len(recs))
+	}
+}
@@ -15,17 +15,32 @@
 package allocator
 
 import (
+	"crypto/rand"
 	"fmt"
 	"log"
+	"math/big"
 	"net"
 	"os"
+	"sort"
 	"strconv"
+	"time"
 
 	current "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend"
 )
 
+const (
+	// StrategySequential allocates round-robin from the last reserved IP
+	StrategySequential = "sequential"
+	// StrategyRandom allocates from a random IP of the range set onwards
+	StrategyRandom = "random"
+	// StrategyLeastRecentlyUsed allocates the IPs never released first,
+	// then the IPs released the longest ago
+	StrategyLeastRecentlyUsed = "least-recently-used"
+)
+
 type IPAllocator struct {
 	rangeset *RangeSet
 	store    backend.Store
@@ -42,9 +57,34 @@ func NewIPAllocator(s *RangeSet, store backend.Store, id int) *IPAllocator {
 
 // Get allocates an IP
 func (a *IPAllocator) Get(id string, ifname string, requestedIP net.IP) (*current.IPConfig, error) {
-	a.store.Lock()
-	defer a.store.Unlock()
+	if err := a.lock(); err != nil {
+		return nil, err
+	}
+	defer a.unlock()
+
+	return a.get(id, ifname, requestedIP)
+}
+
+// TryGet allocates an IP like Get, unless another invocation is allocating
+// from the range set, in which case it returns false rather than waiting.
+// Stores that cannot lock a range set are always waited for.
+func (a *IPAllocator) TryGet(id string, ifname string, requestedIP net.IP) (*current.IPConfig, bool, error) {
+	locker, ok := a.store.(backend.RangeLocker)
+	if !ok {
+		ipConf, err := a.Get(id, ifname, requestedIP)
+		return ipConf, true, err
+	}
+	locked, err := locker.TryLockRange(a.rangeID)
+	if err != nil || !locked {
+		return nil, false, err
+	}
+	defer locker.UnlockRange(a.rangeID)
 
+	ipConf, err := a.get(id, ifname, requestedIP)
+	return ipConf, true, err
+}
+
+func (a *IPAllocator) get(id string, ifname string, requestedIP net.IP) (*current.IPConfig, error) {
 	var reservedIP *net.IPNet
 	var gw net.IP
 
@@ -58,18 +98,22 @@ func (a *IPAllocator) Get(id string, ifname string, requestedIP net.IP) (*curren
 			return nil, err
 		}
 
-		if requestedIP.Equal(r.Gateway) {
+		if r.holdsGateway(requestedIP) {
 			return nil, fmt.Errorf("requested ip %s is subnet's gateway", requestedIP.String())
 		}
 
-		reserved, err := a.store.Reserve(id, ifname, requestedIP, a.rangeID)
+		if r.excludedUntil(requestedIP) != nil {
+			return nil, fmt.Errorf("requested ip %s is excluded from range %s", requestedIP.String(), r.String())
+		}
+
+		reservedIP = r.allocation(requestedIP)
+		reserved, err := a.store.Reserve(id, ifname, reservedIP.IP, a.rangeID)
 		if err != nil {
 			return nil, err
 		}
 		if !reserved {
 			return nil, fmt.Errorf("requested IP address %s is not available in range set %s", requestedIP, a.rangeset.String())
 		}
-		reservedIP = &net.IPNet{IP: requestedIP, Mask: r.Subnet.Mask}
 		gw = r.Gateway
 
 	} else {
@@ -88,12 +132,19 @@ func (a *IPAllocator) Get(id string, ifname string, requestedIP net.IP) (*curren
 		if err != nil {
 			return nil, err
 		}
+		released := a.releasedIPs()
+		var deferred []candidate
 		for {
 			reservedIP, gw = iter.Next()
 			if reservedIP == nil {
 				break
 			}
 
+			if t, ok := released[reservedIP.IP.String()]; ok {
+				deferred = append(deferred, candidate{ipn: reservedIP, gw: gw, released: t})
+				continue
+			}
+
 			reserved, err := a.store.Reserve(id, ifname, reservedIP.IP, a.rangeID)
 			if err != nil {
 				return nil, err
@@ -103,20 +154,89 @@ func (a *IPAllocator) Get(id string, ifname string, requestedIP net.IP) (*curren
 				break
 			}
 		}
+
+		// Only the released IPs are left, try the least recently used first
+		sort.SliceStable(deferred, func(i, j int) bool {
+			return deferred[i].released.Before(deferred[j].released)
+		})
+		for _, c := range deferred {
+			if reservedIP != nil {
+				break
+			}
+			reserved, err := a.store.Reserve(id, ifname, c.ipn.IP, a.rangeID)
+			if err != nil {
+				return nil, err
+			}
+			if reserved {
+				reservedIP, gw = c.ipn, c.gw
+			}
+		}
 	}
 
 	if reservedIP == nil {
-		return nil, fmt.Errorf("no IP addresses available in range set: %s", a.rangeset.String())
+		return nil, cnierrors.Newf(cnierrors.ErrPoolExhausted, "no IP addresses available in range set: %s", a.rangeset.String())
+	}
+
+	// A delegated prefix is stored by its first IP, its subnet-router
+	// anycast address, so the attachment gets the next one
+	address := *reservedIP
+	if a.rangeset.PrefixLength() != 0 {
+		address.IP = ip.NextIP(reservedIP.IP)
 	}
 
 	return &current.IPConfig{
-		Address: *reservedIP,
+		Address: address,
 		Gateway: gw,
 	}, nil
 }
 
+// candidate is an IP the least-recently-used strategy deferred, as it was
+// released before.
+type candidate struct {
+	ipn      *net.IPNet
+	gw       net.IP
+	released time.Time
+}
+
+// releasedIPs returns when the IPs were last released, if the strategy is
+// least-recently-used. Without release times, it falls back to sequential.
+func (a *IPAllocator) releasedIPs() map[string]time.Time {
+	if a.rangeset.allocationStrategy() != StrategyLeastRecentlyUsed {
+		return nil
+	}
+	tracker, ok := a.store.(backend.ReleaseTracker)
+	if !ok {
+		return nil
+	}
+	released, err := tracker.ReleasedIPs()
+	if err != nil {
+		log.Printf("Error retrieving released ips: %v", err)
+		return nil
+	}
+	return released
+}
+
+// lock locks the range set of a if the store can, and else the whole store.
+func (a *IPAllocator) lock() error {
+	if locker, ok := a.store.(backend.RangeLocker); ok {
+		return locker.LockRange(a.rangeID)
+	}
+	return a.store.Lock()
+}
+
+func (a *IPAllocator) unlock() error {
+	if locker, ok := a.store.(backend.RangeLocker); ok {
+		return locker.UnlockRange(a.rangeID)
+	}
+	return a.store.Unlock()
+}
+
 // Release clears all IPs allocated for the container with given ID
 func (a *IPAllocator) Release(id string, ifname string) error {
+	// Stores locking range sets lock their bookkeeping of releases themselves
+	if _, ok := a.store.(backend.RangeLocker); ok {
+		return a.store.ReleaseByID(id, ifname)
+	}
 	a.store.Lock()
 	defer a.store.Unlock()
 
@@ -137,15 +257,30 @@ type RangeIter struct {
 }
 
 // GetIter encapsulates the strategy for this allocator.
-// We use a round-robin strategy, attempting to evenly use the whole set.
-// More specifically, a crash-looping container will not see the same IP until
-// the entire range has been run through.
-// We may wish to consider avoiding recently-released IPs in the future.
+// By default we use a round-robin strategy, attempting to evenly use the
+// whole set. More specifically, a crash-looping container will not see the
+// same IP until the entire range has been run through.
+// The random strategy starts from a random IP of the set instead, and the
+// least-recently-used strategy reorders the released IPs in Get. Balanced
+// sets start from a range picked in proportion to its free IPs.
 func (a *IPAllocator) GetIter() (*RangeIter, error) {
 	iter := RangeIter{
 		rangeset: a.rangeset,
 	}
 
+	// With balancing, start from a range picked by its free IPs
+	balancedIdx := -1
+	if a.rangeset.balanced() {
+		balancedIdx = a.balancedRange()
+	}
+
+	if a.rangeset.allocationStrategy() == StrategyRandom {
+		if err := iter.startAtRandom(balancedIdx); err != nil {
+			return nil, err
+		}
+		return &iter, nil
+	}
+
 	// Round-robin by trying to allocate from the last reserved IP + 1
 	startFromLastReservedIP := false
 
@@ -156,6 +291,9 @@ func (a *IPAllocator) GetIter() (*RangeIter, error) {
 		log.Printf("Error retrieving last reserved ip: %v", err)
 	} else if lastReservedIP != nil {
 		startFromLastReservedIP = a.rangeset.Contains(lastReservedIP)
+		if balancedIdx >= 0 {
+			startFromLastReservedIP = (*a.rangeset)[balancedIdx].Contains(lastReservedIP)
+		}
 	}
 
 	// Find the range in the set with this IP
@@ -170,6 +308,9 @@ func (a *IPAllocator) GetIter() (*RangeIter, error) {
 				break
 			}
 		}
+	} else if balancedIdx >= 0 {
+		iter.rangeIdx = balancedIdx
+		iter.startIP = (*a.rangeset)[balancedIdx].RangeStart
 	} else {
 		iter.rangeIdx = 0
 		iter.startIP = (*a.rangeset)[0].RangeStart
@@ -177,6 +318,93 @@ func (a *IPAllocator) GetIter() (*RangeIter, error) {
 	return &iter, nil
 }
 
+// startAtRandom makes the first call to Next return a random IP of the set,
+// or of the range at only if it is not negative, each IP being equally likely.
+func (i *RangeIter) startAtRandom(only int) error {
+	sizes := make([]*big.Int, len(*i.rangeset))
+	for idx, r := range *i.rangeset {
+		sizes[idx] = big.NewInt(0)
+		if only < 0 || idx == only {
+			sizes[idx].SetBytes(r.RangeEnd)
+			sizes[idx].Sub(sizes[idx], new(big.Int).SetBytes(r.RangeStart)).Add(sizes[idx], big.NewInt(1))
+		}
+	}
+
+	idx, n, err := pickWeighted(sizes)
+	if err != nil {
+		return fmt.Errorf("failed to pick a random ip: %v", err)
+	}
+	r := (*i.rangeset)[idx]
+	i.rangeIdx = idx
+	if n.Sign() > 0 {
+		// We advance the cursor on every Next()
+		offset := n.Add(n, new(big.Int).SetBytes(r.RangeStart)).Sub(n, big.NewInt(1))
+		i.cur = offset.FillBytes(make(net.IP, len(r.RangeStart)))
+	}
+	return nil
+}
+
+// pickWeighted picks an index at random, in proportion to its weight, and
+// a random offset below that weight. The weights must not all be zero.
+func pickWeighted(weights []*big.Int) (int, *big.Int, error) {
+	total := big.NewInt(0)
+	for _, weight := range weights {
+		total.Add(total, weight)
+	}
+	n, err := rand.Int(rand.Reader, total)
+	if err != nil {
+		return 0, nil, err
+	}
+	for idx, weight := range weights {
+		if n.Cmp(weight) < 0 {
+			return idx, n, nil
+		}
+		n.Sub(n, weight)
+	}
+	// Not reached, as n < total
+	return len(weights) - 1, n, nil
+}
+
+// balancedRange picks the range of the set to allocate from, in proportion
+// to its free IPs. It returns -1 when it cannot tell, or no IP is free.
+func (a *IPAllocator) balancedRange() int {
+	lister, ok := a.store.(backend.AllocationLister)
+	if !ok {
+		return -1
+	}
+	allocated, err := lister.AllocatedIPs()
+	if err != nil {
+		log.Printf("Error listing allocated ips: %v", err)
+		return -1
+	}
+
+	free := make([]*big.Int, len(*a.rangeset))
+	total := big.NewInt(0)
+	for idx := range *a.rangeset {
+		r := &(*a.rangeset)[idx]
+		free[idx] = r.size()
+		for _, addr := range allocated {
+			if r.Contains(addr) && r.allocatable(addr) {
+				free[idx].Sub(free[idx], big.NewInt(1))
+			}
+		}
+		if free[idx].Sign() < 0 {
+			free[idx].SetInt64(0)
+		}
+		total.Add(total, free[idx])
+	}
+	if total.Sign() == 0 {
+		return -1
+	}
+
+	idx, _, err := pickWeighted(free)
+	if err != nil {
+		log.Printf("Error picking a range: %v", err)
+		return -1
+	}
+	return idx
+}
+
 // Next returns the next IP, its mask, and its gateway. Returns nil
 // if the iterator has been exhausted
 func (i *RangeIter) Next() (*net.IPNet, net.IP) {
@@ -187,22 +415,27 @@ func (i *RangeIter) Next() (*net.IPNet, net.IP) {
 	if i.cur == nil {
 		i.cur = r.RangeStart
 		i.startIP = i.cur
-		if i.cur.Equal(r.Gateway) {
+		if r.holdsGateway(i.cur) {
+			return i.Next()
+		}
+		if end := r.excludedUntil(i.cur); end != nil {
+			i.cur = end
 			return i.Next()
 		}
-		return &net.IPNet{IP: i.cur, Mask: r.Subnet.Mask}, r.Gateway
+		return r.allocation(i.cur), r.Gateway
 	}
 
 	// If we've reached the end of this range, we need to advance the range
-	// RangeEnd is inclusive as well
-	if i.cur.Equal(r.RangeEnd) {
+	// RangeEnd is inclusive as well. When the range delegates prefixes, the
+	// cursor moves a prefix at a time.
+	if r.block(i.cur).Equal(r.block(r.RangeEnd)) {
 		i.rangeIdx++
 		i.rangeIdx %= len(*i.rangeset)
 		r = (*i.rangeset)[i.rangeIdx]
 
 		i.cur = r.RangeStart
 	} else {
-		i.cur = ip.NextIP(i.cur)
+		i.cur = ip.NextIP(r.blockEnd(i.cur))
 	}
 
 	if i.startIP == nil {
@@ -212,9 +445,18 @@ func (i *RangeIter) Next() (*net.IPNet, net.IP) {
 		return nil, nil
 	}
 
-	if i.cur.Equal(r.Gateway) {
+	if r.holdsGateway(i.cur) {
+		return i.Next()
+	}
+
+	// Skip the whole excluded block at once, unless we started within it
+	if end := r.excludedUntil(i.cur); end != nil {
+		if ip.Cmp(i.startIP, i.cur) > 0 && ip.Cmp(i.startIP, end) <= 0 {
+			return nil, nil
+		}
+		i.cur = end
 		return i.Next()
 	}
 
-	return &net.IPNet{IP: i.cur, Mask: r.Subnet.Mask}, r.Gateway
+	return r.allocation(i.cur), r.Gateway
 }
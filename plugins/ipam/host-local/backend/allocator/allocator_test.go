@@ -336,6 +336,223 @@ var _ = Describe("host-local ip allocator", func() {
 		})
 	})
 
+	Context("with excluded addresses", func() {
+		It("should never allocate them", func() {
+			p := RangeSet{
+				Range{Subnet: mustSubnet("192.168.1.0/28"), Exclude: []string{"192.168.1.3", "192.168.1.8/30"}},
+			}
+			Expect(p.Canonicalize()).To(Succeed())
+			alloc := IPAllocator{
+				rangeset: &p,
+				store:    fakestore.NewFakeStore(map[string]string{}, map[string]net.IP{}),
+				rangeID:  "rangeid",
+			}
+
+			got := []string{}
+			for i := 0; ; i++ {
+				res, err := alloc.Get(fmt.Sprintf("ID%d", i), "eth0", nil)
+				if err != nil {
+					Expect(err.Error()).To(HavePrefix("no IP addresses available in range set"))
+					break
+				}
+				got = append(got, res.Address.IP.String())
+			}
+			Expect(got).To(Equal([]string{
+				"192.168.1.2", "192.168.1.4", "192.168.1.5", "192.168.1.6", "192.168.1.7",
+				"192.168.1.12", "192.168.1.13", "192.168.1.14",
+			}))
+		})
+
+		It("should stop when starting within an excluded block", func() {
+			p := RangeSet{
+				Range{Subnet: mustSubnet("192.168.1.0/29"), Exclude: []string{"192.168.1.4/30"}},
+			}
+			Expect(p.Canonicalize()).To(Succeed())
+			store := fakestore.NewFakeStore(map[string]string{"192.168.1.2": "a", "192.168.1.3": "b"},
+				map[string]net.IP{"rangeid": net.ParseIP("192.168.1.4")})
+			alloc := IPAllocator{rangeset: &p, store: store, rangeID: "rangeid"}
+
+			_, err := alloc.Get("ID", "eth0", nil)
+			Expect(err).To(MatchError("no IP addresses available in range set: 192.168.1.1-192.168.1.6"))
+		})
+
+		It("should refuse to allocate them on request", func() {
+			p := RangeSet{
+				Range{Subnet: mustSubnet("192.168.1.0/29"), Exclude: []string{"192.168.1.4/30"}},
+			}
+			Expect(p.Canonicalize()).To(Succeed())
+			alloc := IPAllocator{
+				rangeset: &p,
+				store:    fakestore.NewFakeStore(map[string]string{}, map[string]net.IP{}),
+				rangeID:  "rangeid",
+			}
+
+			_, err := alloc.Get("ID", "eth0", net.ParseIP("192.168.1.5"))
+			Expect(err).To(MatchError("requested ip 192.168.1.5 is excluded from range 192.168.1.1-192.168.1.6"))
+		})
+	})
+
+	Context("when delegating prefixes", func() {
+		newPrefixAllocator := func(strategy string) IPAllocator {
+			p := RangeSet{
+				Range{Subnet: mustSubnet("2001:db8::/120"), PrefixLength: 124, AllocationStrategy: strategy, Exclude: []string{"2001:db8::48"}},
+			}
+			Expect(p.Canonicalize()).To(Succeed())
+			return IPAllocator{
+				rangeset: &p,
+				store:    fakestore.NewFakeStore(map[string]string{}, map[string]net.IP{}),
+				rangeID:  "rangeid",
+			}
+		}
+
+		It("should allocate whole prefixes, skipping the gateway's and excluded ones", func() {
+			for _, strategy := range []string{StrategySequential, StrategyRandom} {
+				alloc := newPrefixAllocator(strategy)
+				got := map[string]bool{}
+				for i := 0; ; i++ {
+					res, err := alloc.Get(fmt.Sprintf("ID%d", i), "eth0", nil)
+					if err != nil {
+						Expect(err.Error()).To(HavePrefix("no IP addresses available in range set"))
+						break
+					}
+					Expect(res.Gateway).To(Equal(net.ParseIP("2001:db8::1")))
+					got[res.Address.String()] = true
+				}
+
+				// 16 /124s but the gateway's and 2001:db8::40/124
+				Expect(got).To(HaveLen(14))
+				for i := 1; i < 16; i++ {
+					Expect(got[fmt.Sprintf("2001:db8::%x1/124", i)]).To(Equal(i != 4))
+				}
+			}
+		})
+
+		It("should allocate the prefix of a requested IP", func() {
+			alloc := newPrefixAllocator("")
+			res, err := alloc.Get("ID", "eth0", net.ParseIP("2001:db8::25"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.Address.String()).To(Equal("2001:db8::21/124"))
+
+			_, err = alloc.Get("ID2", "eth0", net.ParseIP("2001:db8::2a"))
+			Expect(err).To(MatchError("requested IP address 2001:db8::2a is not available in range set 2001:db8::-2001:db8::ff"))
+			_, err = alloc.Get("ID2", "eth0", net.ParseIP("2001:db8::a"))
+			Expect(err).To(MatchError("requested ip 2001:db8::a is subnet's gateway"))
+			_, err = alloc.Get("ID2", "eth0", net.ParseIP("2001:db8::42"))
+			Expect(err).To(MatchError("requested ip 2001:db8::42 is excluded from range 2001:db8::-2001:db8::ff"))
+		})
+	})
+
+	Context("with the random allocation strategy", func() {
+		It("should start from any IP of the range set", func() {
+			a := newAllocatorWithMultiRanges()
+			for i := range *a.rangeset {
+				(*a.rangeset)[i].AllocationStrategy = StrategyRandom
+			}
+
+			seen := map[string]bool{}
+			for i := 0; i < 100; i++ {
+				r, err := a.GetIter()
+				Expect(err).NotTo(HaveOccurred())
+				ip := r.nextip()
+				Expect(a.rangeset.Contains(ip)).To(BeTrue())
+				seen[ip.String()] = true
+			}
+			// 6 IPs, without the gateways
+			Expect(len(seen)).To(BeNumerically(">", 1))
+		})
+
+		It("should allocate the last free IP", func() {
+			alloc := mkalloc()
+			(*alloc.rangeset)[0].AllocationStrategy = StrategyRandom
+			for i := 2; i < 7; i++ {
+				res, err := alloc.Get(fmt.Sprintf("ID%d", i), "eth0", nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect((*alloc.rangeset)[0].Contains(res.Address.IP)).To(BeTrue())
+			}
+			_, err := alloc.Get("ID7", "eth0", nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("with balancing", func() {
+		newBalancedAllocator := func(strategy string, ipmap map[string]string) IPAllocator {
+			p := RangeSet{
+				Range{Subnet: mustSubnet("192.168.1.0/29"), Balanced: true, AllocationStrategy: strategy},
+				Range{Subnet: mustSubnet("192.168.2.0/29"), Balanced: true, AllocationStrategy: strategy},
+			}
+			Expect(p.Canonicalize()).To(Succeed())
+			return IPAllocator{
+				rangeset: &p,
+				store:    fakestore.NewFakeStore(ipmap, map[string]net.IP{"rangeid": net.ParseIP("192.168.1.2")}),
+				rangeID:  "rangeid",
+			}
+		}
+
+		It("should start from the ranges with free IPs", func() {
+			for _, strategy := range []string{StrategySequential, StrategyRandom} {
+				a := newBalancedAllocator(strategy, map[string]string{
+					"192.168.1.2": "a", "192.168.1.3": "b", "192.168.1.4": "c", "192.168.1.5": "d", "192.168.1.6": "e",
+				})
+				for i := 0; i < 20; i++ {
+					r, err := a.GetIter()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(r.rangeIdx).To(Equal(1))
+				}
+			}
+		})
+
+		It("should spread the allocations over the ranges", func() {
+			a := newBalancedAllocator("", map[string]string{"192.168.1.2": "a"})
+			seen := map[int]bool{}
+			for i := 0; i < 100; i++ {
+				r, err := a.GetIter()
+				Expect(err).NotTo(HaveOccurred())
+				seen[r.rangeIdx] = true
+			}
+			Expect(seen).To(Equal(map[int]bool{0: true, 1: true}))
+		})
+	})
+
+	Context("with the least-recently-used allocation strategy", func() {
+		It("should allocate the IPs released the longest ago first", func() {
+			alloc := mkalloc()
+			(*alloc.rangeset)[0].AllocationStrategy = StrategyLeastRecentlyUsed
+			for i := 2; i < 7; i++ {
+				_, err := alloc.Get(fmt.Sprintf("ID%d", i), "eth0", nil)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			for _, id := range []string{"ID4", "ID2", "ID6"} {
+				Expect(alloc.Release(id, "eth0")).To(Succeed())
+			}
+
+			for i, expected := range []string{"192.168.1.4/29", "192.168.1.2/29", "192.168.1.6/29"} {
+				res, err := alloc.Get(fmt.Sprintf("new%d", i), "eth0", nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.Address.String()).To(Equal(expected))
+			}
+		})
+
+		It("should allocate the IPs never released before the released ones", func() {
+			p := RangeSet{
+				Range{Subnet: mustSubnet("192.168.1.0/29"), AllocationStrategy: StrategyLeastRecentlyUsed},
+			}
+			Expect(p.Canonicalize()).To(Succeed())
+			// Round-robin would start over from .2, which was released
+			store := fakestore.NewFakeStore(map[string]string{"192.168.1.2": "ID"},
+				map[string]net.IP{"rangeid": net.ParseIP("192.168.1.6")})
+			Expect(store.ReleaseByID("ID", "eth0")).To(Succeed())
+			alloc := IPAllocator{
+				rangeset: &p,
+				store:    store,
+				rangeID:  "rangeid",
+			}
+
+			res, err := alloc.Get("ID", "eth0", nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.Address.String()).To(Equal("192.168.1.3/29"))
+		})
+	})
+
 	Context("when lastReservedIP is at the end of one of multi ranges", func() {
 		It("should use the first IP of next range as startIP after Next", func() {
 			a := newAllocatorWithMultiRanges()
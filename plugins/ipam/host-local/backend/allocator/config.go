@@ -17,11 +17,15 @@ package allocator
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/trace"
 )
 
 // The top-level network config - IPAM plugins are passed the full configuration
@@ -34,29 +38,50 @@ type Net struct {
 		// The capability arg
 		IPRanges []RangeSet `json:"ipRanges,omitempty"`
 		IPs      []*ip.IP   `json:"ips,omitempty"`
+		// IPRangeSelection restricts the allocation to the matching range sets
+		IPRangeSelection []RangeSelector `json:"ipRangeSelection,omitempty"`
 	} `json:"runtimeConfig,omitempty"`
 	Args *struct {
 		A *IPAMArgs `json:"cni"`
 	} `json:"args"`
 }
 
+const defaultStickyTTL = 24 * time.Hour
+
 // IPAMConfig represents the IP related network configuration.
 // This nests Range because we initially only supported a single
 // range directly, and wish to preserve backwards compatibility
 type IPAMConfig struct {
 	*Range
-	Name       string
-	Type       string         `json:"type"`
-	Routes     []*types.Route `json:"routes"`
-	DataDir    string         `json:"dataDir"`
-	ResolvConf string         `json:"resolvConf"`
-	Ranges     []RangeSet     `json:"ranges"`
-	IPArgs     []net.IP       `json:"-"` // Requested IPs from CNI_ARGS, args and capabilities
+	Name        string
+	Type        string         `json:"type"`
+	Routes      []*types.Route `json:"routes"`
+	DataDir     string         `json:"dataDir"`
+	DataBackend string         `json:"dataBackend,omitempty"` // "disk" (default) or "journal"
+	ResolvConf  string         `json:"resolvConf"`
+	Ranges      []RangeSet     `json:"ranges"`
+	IPArgs      []net.IP       `json:"-"` // Requested IPs from CNI_ARGS, args and capabilities
+	// StickyIPs gives a pod, identified by K8S_POD_NAMESPACE and
+	// K8S_POD_NAME, its previous IPs back if it is recreated within
+	// StickyTTL ("24h" by default) and they are still free.
+	StickyIPs       bool          `json:"stickyIPs,omitempty"`
+	StickyTTL       string        `json:"stickyTTL,omitempty"`
+	PodID           string        `json:"-"` // <namespace>/<name> from CNI_ARGS
+	StickyRetention time.Duration `json:"-"`
+	// UtilizationThreshold makes STATUS fail with ErrPoolNearlyExhausted
+	// once the IPs of a range set are allocated above this percentage, while
+	// ADD still succeeds.
+	UtilizationThreshold int `json:"utilizationThreshold,omitempty"`
+	// SelectedRanges are the indexes of the range sets selected by the
+	// runtime. All the range sets are used if it is nil.
+	SelectedRanges map[int]bool `json:"-"`
 }
 
 type IPAMEnvArgs struct {
 	types.CommonArgs
-	IP ip.IP `json:"ip,omitempty"`
+	IP                ip.IP                      `json:"ip,omitempty"`
+	K8S_POD_NAMESPACE types.UnmarshallableString //nolint:revive,stylecheck
+	K8S_POD_NAME      types.UnmarshallableString //nolint:revive,stylecheck
 }
 
 type IPAMArgs struct {
@@ -65,15 +90,136 @@ type IPAMArgs struct {
 
 type RangeSet []Range
 
+// RangeSelector selects the range sets with a range of this name, the range
+// set at this index, or the range sets with a range of this subnet.
+type RangeSelector struct {
+	Name   string       `json:"name,omitempty"`
+	Index  *int         `json:"index,omitempty"`
+	Subnet *types.IPNet `json:"subnet,omitempty"`
+}
+
 type Range struct {
+	Name       string      `json:"name,omitempty"`       // For runtimes to select the range
 	RangeStart net.IP      `json:"rangeStart,omitempty"` // The first ip, inclusive
 	RangeEnd   net.IP      `json:"rangeEnd,omitempty"`   // The last ip, inclusive
 	Subnet     types.IPNet `json:"subnet"`
 	Gateway    net.IP      `json:"gateway,omitempty"`
+	// AllocationStrategy is "sequential" (default), "random" or
+	// "least-recently-used". The ranges of a set must agree on it.
+	AllocationStrategy string `json:"allocationStrategy,omitempty"`
+	// Balanced spreads the allocations over the ranges of the set in
+	// proportion to their free IPs, rather than filling them in order.
+	Balanced bool `json:"balanced,omitempty"`
+	// Exclude lists the addresses and CIDRs of the subnet that are never
+	// allocated, e.g. those statically assigned to appliances.
+	Exclude  []string    `json:"exclude,omitempty"`
+	excluded []net.IPNet // Parsed from Exclude by Canonicalize
+	// PrefixLength, if set, delegates a whole IPv6 prefix of that length,
+	// e.g. a /112, to each attachment rather than a single IP. The ranges
+	// of a set must agree on it.
+	PrefixLength int `json:"prefixLength,omitempty"`
+}
+
+// RangeSelected reports whether the range set at idx may be allocated from.
+func (c *IPAMConfig) RangeSelected(idx int) bool {
+	return c.SelectedRanges == nil || c.SelectedRanges[idx]
+}
+
+// CheckUtilization returns the error STATUS reports when a range set has
+// all its IPs allocated, or more of them than the UtilizationThreshold.
+func (c *IPAMConfig) CheckUtilization(allocated []net.IP) error {
+	used := make([]*big.Int, len(c.Ranges))
+	for idx := range c.Ranges {
+		n := int64(0)
+		for _, ip := range allocated {
+			if c.Ranges[idx].Allocatable(ip) {
+				n++
+			}
+		}
+		used[idx] = big.NewInt(n)
+	}
+
+	// An exhausted range set is reported first, as ADD fails then
+	for idx := range c.Ranges {
+		rangeset := &c.Ranges[idx]
+		if capacity := rangeset.Capacity(); used[idx].Cmp(capacity) >= 0 {
+			return types.NewError(cnierrors.ErrPluginNotAvailable, "IPAM pool exhausted",
+				fmt.Sprintf("all %s addresses of range set %d (%s) are allocated", capacity, idx, rangeset.String()))
+		}
+	}
+	if c.UtilizationThreshold == 0 {
+		return nil
+	}
+	threshold := big.NewInt(int64(c.UtilizationThreshold))
+	for idx := range c.Ranges {
+		rangeset := &c.Ranges[idx]
+		capacity := rangeset.Capacity()
+		// used / capacity > threshold / 100
+		if new(big.Int).Mul(used[idx], big.NewInt(100)).Cmp(new(big.Int).Mul(capacity, threshold)) > 0 {
+			return types.NewError(cnierrors.ErrPoolNearlyExhausted, "IPAM pool nearly exhausted",
+				fmt.Sprintf("%s of %s addresses of range set %d (%s) are allocated, above the utilizationThreshold of %d%%",
+					used[idx], capacity, idx, rangeset.String(), c.UtilizationThreshold))
+		}
+	}
+	return nil
+}
+
+// TracksReleases reports whether a range set allocates the least recently
+// used IPs first, which needs the store to record when IPs are released.
+func (c *IPAMConfig) TracksReleases() bool {
+	for i := range c.Ranges {
+		if c.Ranges[i].allocationStrategy() == StrategyLeastRecentlyUsed {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the indexes of the range sets the selector selects, which
+// must not be none.
+func (sel RangeSelector) match(rangesets []RangeSet) ([]int, error) {
+	var selected []int
+	switch {
+	case sel.Name != "" && sel.Index == nil && sel.Subnet == nil:
+		for idx, rangeset := range rangesets {
+			for _, r := range rangeset {
+				if r.Name == sel.Name {
+					selected = append(selected, idx)
+					break
+				}
+			}
+		}
+		if selected == nil {
+			return nil, fmt.Errorf("no range named %q", sel.Name)
+		}
+	case sel.Name == "" && sel.Index != nil && sel.Subnet == nil:
+		if *sel.Index < 0 || *sel.Index >= len(rangesets) {
+			return nil, fmt.Errorf("invalid range set index %d (must be between 0 and %d)", *sel.Index, len(rangesets)-1)
+		}
+		selected = append(selected, *sel.Index)
+	case sel.Name == "" && sel.Index == nil && sel.Subnet != nil:
+		subnet := (*net.IPNet)(sel.Subnet).String()
+		for idx, rangeset := range rangesets {
+			for _, r := range rangeset {
+				if (*net.IPNet)(&r.Subnet).String() == subnet {
+					selected = append(selected, idx)
+					break
+				}
+			}
+		}
+		if selected == nil {
+			return nil, fmt.Errorf("no range with subnet %s", subnet)
+		}
+	default:
+		return nil, fmt.Errorf("range selector must set exactly one of name, index or subnet")
+	}
+	return selected, nil
 }
 
 // NewIPAMConfig creates a NetworkConfig from the given network name.
 func LoadIPAMConfig(bytes []byte, envArgs string) (*IPAMConfig, string, error) {
+	defer trace.Start("parseConfig").End()
+
 	n := Net{}
 	if err := json.Unmarshal(bytes, &n); err != nil {
 		return nil, "", err
@@ -94,6 +240,25 @@ func LoadIPAMConfig(bytes []byte, envArgs string) (*IPAMConfig, string, error) {
 		if e.IP.ToIP() != nil {
 			n.IPAM.IPArgs = []net.IP{e.IP.ToIP()}
 		}
+
+		if e.K8S_POD_NAMESPACE != "" && e.K8S_POD_NAME != "" {
+			n.IPAM.PodID = string(e.K8S_POD_NAMESPACE) + "/" + string(e.K8S_POD_NAME)
+		}
+	}
+
+	if n.IPAM.StickyIPs {
+		n.IPAM.StickyRetention = defaultStickyTTL
+		if n.IPAM.StickyTTL != "" {
+			ttl, err := time.ParseDuration(n.IPAM.StickyTTL)
+			if err != nil || ttl <= 0 {
+				return nil, "", fmt.Errorf("invalid stickyTTL %q", n.IPAM.StickyTTL)
+			}
+			n.IPAM.StickyRetention = ttl
+		}
+	}
+
+	if n.IPAM.UtilizationThreshold < 0 || n.IPAM.UtilizationThreshold > 100 {
+		return nil, "", fmt.Errorf("invalid utilizationThreshold %d (must be between 0 and 100)", n.IPAM.UtilizationThreshold)
 	}
 
 	// parse custom IPs from CNI args in network config
@@ -164,6 +329,19 @@ func LoadIPAMConfig(bytes []byte, envArgs string) (*IPAMConfig, string, error) {
 		}
 	}
 
+	if len(n.RuntimeConfig.IPRangeSelection) > 0 {
+		n.IPAM.SelectedRanges = map[int]bool{}
+		for _, selector := range n.RuntimeConfig.IPRangeSelection {
+			selected, err := selector.match(n.IPAM.Ranges)
+			if err != nil {
+				return nil, "", err
+			}
+			for _, idx := range selected {
+				n.IPAM.SelectedRanges[idx] = true
+			}
+		}
+	}
+
 	// Copy net name into IPAM so not to drag Net struct around
 	n.IPAM.Name = n.Name
 
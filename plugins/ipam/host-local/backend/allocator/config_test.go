@@ -15,6 +15,7 @@
 package allocator
 
 import (
+	"fmt"
 	"net"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -478,4 +479,51 @@ var _ = Describe("IPAM config", func() {
 			net.ParseIP("2001:db8::1"),
 		}))
 	})
+
+	It("Should select range sets from runtime configuration", func() {
+		input := `{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"runtimeConfig": {
+				"ipRangeSelection": [{"name": "zone-b"}, {"subnet": "2001:db8:1::/48"}]
+			},
+			"ipam": {
+				"type": "host-local",
+				"ranges": [
+					[{"subnet": "10.1.2.0/24", "name": "zone-a"}],
+					[{"subnet": "10.1.3.0/24", "name": "zone-b"}],
+					[{"subnet": "2001:db8:1::/48"}]
+				]
+			}
+		}`
+		conf, _, err := LoadIPAMConfig([]byte(input), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.SelectedRanges).To(Equal(map[int]bool{1: true, 2: true}))
+		Expect(conf.RangeSelected(0)).To(BeFalse())
+	})
+
+	It("Should reject range selectors that select nothing", func() {
+		conf := func(selector string) []byte {
+			return []byte(fmt.Sprintf(`{
+				"cniVersion": "1.1.0",
+				"name": "mynet",
+				"type": "ipvlan",
+				"runtimeConfig": {"ipRangeSelection": [%s]},
+				"ipam": {
+					"type": "host-local",
+					"ranges": [[{"subnet": "10.1.2.0/24", "name": "zone-a"}]]
+				}
+			}`, selector))
+		}
+		_, _, err := LoadIPAMConfig(conf(`{"name": "zone-b"}`), "")
+		Expect(err).To(MatchError(`no range named "zone-b"`))
+		_, _, err = LoadIPAMConfig(conf(`{"index": 1}`), "")
+		Expect(err).To(MatchError("invalid range set index 1 (must be between 0 and 0)"))
+		_, _, err = LoadIPAMConfig(conf(`{"subnet": "10.1.3.0/24"}`), "")
+		Expect(err).To(MatchError("no range with subnet 10.1.3.0/24"))
+		_, _, err = LoadIPAMConfig(conf(`{"name": "zone-a", "index": 0}`), "")
+		Expect(err).To(MatchError("range selector must set exactly one of name, index or subnet"))
+	})
 })
@@ -16,7 +16,10 @@ package allocator
 
 import (
 	"fmt"
+	"math/big"
 	"net"
+	"sort"
+	"strings"
 
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/plugins/pkg/ip"
@@ -55,6 +58,8 @@ func (r *Range) Canonicalize() error {
 		}
 	}
 
+	startGiven := r.RangeStart != nil
+
 	// RangeStart: If specified, make sure it's sane (inside the subnet),
 	// otherwise use the first free IP (i.e. .1) - this will conflict with the
 	// gateway but we skip it in the iterator
@@ -84,6 +89,46 @@ func (r *Range) Canonicalize() error {
 		r.RangeEnd = lastIP(r.Subnet)
 	}
 
+	// Delegated prefixes are aligned, so the first one starts at the
+	// network address rather than .1
+	if r.PrefixLength != 0 {
+		if masklen != 8*net.IPv6len {
+			return fmt.Errorf("prefixLength is only supported for IPv6 networks, not %s", (*net.IPNet)(&r.Subnet).String())
+		}
+		if r.PrefixLength <= ones || r.PrefixLength > masklen-2 {
+			return fmt.Errorf("prefixLength %d must be longer than %d, that of network %s, and at most %d",
+				r.PrefixLength, ones, (*net.IPNet)(&r.Subnet).String(), masklen-2)
+		}
+		if startGiven && !r.RangeStart.Equal(r.block(r.RangeStart)) {
+			return fmt.Errorf("RangeStart %s is not the first IP of a /%d", r.RangeStart.String(), r.PrefixLength)
+		}
+		r.RangeStart = r.block(r.RangeStart)
+		if !r.RangeEnd.Equal(r.blockEnd(r.RangeEnd)) {
+			return fmt.Errorf("RangeEnd %s is not the last IP of a /%d", r.RangeEnd.String(), r.PrefixLength)
+		}
+	}
+
+	r.excluded = nil
+	for _, entry := range r.Exclude {
+		excluded, err := parseExclude(entry)
+		if err != nil {
+			return err
+		}
+		exOnes, _ := excluded.Mask.Size()
+		subnet := (*net.IPNet)(&r.Subnet)
+		if len(excluded.IP) != len(r.Subnet.IP) || !subnet.Contains(excluded.IP) || exOnes < ones {
+			return fmt.Errorf("exclude %s not in network %s", entry, subnet.String())
+		}
+		r.excluded = append(r.excluded, excluded)
+	}
+
+	switch r.AllocationStrategy {
+	case "", StrategySequential, StrategyRandom, StrategyLeastRecentlyUsed:
+	default:
+		return fmt.Errorf("invalid allocationStrategy %q (must be %q, %q or %q)", r.AllocationStrategy,
+			StrategySequential, StrategyRandom, StrategyLeastRecentlyUsed)
+	}
+
 	return nil
 }
 
@@ -140,6 +185,164 @@ func (r *Range) String() string {
 	return fmt.Sprintf("%s-%s", r.RangeStart.String(), r.RangeEnd.String())
 }
 
+// excludedUntil returns, if addr is excluded, the last IP of the range that
+// is excluded along with it. Otherwise it returns nil. When the range
+// delegates prefixes, addr is excluded if any IP of its prefix is.
+func (r *Range) excludedUntil(addr net.IP) net.IP {
+	first, last := r.block(addr), r.blockEnd(addr)
+	for _, excluded := range r.excluded {
+		if !excluded.Contains(first) && (ip.Cmp(excluded.IP, first) < 0 || ip.Cmp(excluded.IP, last) > 0) {
+			continue
+		}
+		end := make(net.IP, len(excluded.IP))
+		for i := range excluded.IP {
+			end[i] = excluded.IP[i] | ^excluded.Mask[i]
+		}
+		if ip.Cmp(end, r.RangeEnd) > 0 {
+			return r.RangeEnd
+		}
+		return end
+	}
+	return nil
+}
+
+// allocatable reports whether addr, within the range, is neither its
+// gateway nor excluded, nor in the prefix of either when the range
+// delegates prefixes.
+func (r *Range) allocatable(addr net.IP) bool {
+	return !r.holdsGateway(addr) && r.excludedUntil(addr) == nil
+}
+
+// holdsGateway reports whether addr is the gateway, or in its prefix when
+// the range delegates prefixes.
+func (r *Range) holdsGateway(addr net.IP) bool {
+	return r.block(addr).Equal(r.block(r.Gateway))
+}
+
+// block returns the first IP of the prefix of addr, or addr itself if the
+// range allocates single IPs.
+func (r *Range) block(addr net.IP) net.IP {
+	if r.PrefixLength == 0 {
+		return addr
+	}
+	return addr.Mask(net.CIDRMask(r.PrefixLength, len(addr)*8))
+}
+
+// blockEnd returns the last IP of the prefix of addr, or addr itself if the
+// range allocates single IPs.
+func (r *Range) blockEnd(addr net.IP) net.IP {
+	if r.PrefixLength == 0 {
+		return addr
+	}
+	mask := net.CIDRMask(r.PrefixLength, len(addr)*8)
+	end := make(net.IP, len(addr))
+	for i := range addr {
+		end[i] = addr[i] | ^mask[i]
+	}
+	return end
+}
+
+// blockSize returns the number of IPs of each allocation of the range.
+func (r *Range) blockSize() *big.Int {
+	if r.PrefixLength == 0 {
+		return big.NewInt(1)
+	}
+	return new(big.Int).Lsh(big.NewInt(1), uint(len(r.RangeStart)*8-r.PrefixLength))
+}
+
+// allocation returns the allocation of addr: addr in the subnet, or the
+// prefix of addr when the range delegates prefixes.
+func (r *Range) allocation(addr net.IP) *net.IPNet {
+	if r.PrefixLength == 0 {
+		return &net.IPNet{IP: addr, Mask: r.Subnet.Mask}
+	}
+	return &net.IPNet{IP: r.block(addr), Mask: net.CIDRMask(r.PrefixLength, len(addr)*8)}
+}
+
+// size returns the number of IPs the range can allocate, that is neither
+// its gateway nor excluded. When the range delegates prefixes, it returns
+// the number of prefixes instead, holding neither.
+func (r *Range) size() *big.Int {
+	size := new(big.Int).SetBytes(r.RangeEnd)
+	size.Sub(size, new(big.Int).SetBytes(r.RangeStart)).Add(size, big.NewInt(1))
+
+	// Count each excluded IP once, however many blocks hold it, widening
+	// the blocks to whole prefixes
+	blocks := make([][2]*big.Int, 0, len(r.excluded))
+	for _, excluded := range r.excluded {
+		first := new(big.Int).SetBytes(r.block(excluded.IP))
+		last := new(big.Int).Or(new(big.Int).SetBytes(excluded.IP), new(big.Int).SetBytes(invertMask(excluded.Mask)))
+		last.Or(last, new(big.Int).Sub(r.blockSize(), big.NewInt(1)))
+		first = maxInt(first, new(big.Int).SetBytes(r.RangeStart))
+		last = minInt(last, new(big.Int).SetBytes(r.RangeEnd))
+		if first.Cmp(last) <= 0 {
+			blocks = append(blocks, [2]*big.Int{first, last})
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i][0].Cmp(blocks[j][0]) < 0 })
+	var last *big.Int
+	for _, block := range blocks {
+		if last != nil && block[0].Cmp(last) <= 0 {
+			block[0] = new(big.Int).Add(last, big.NewInt(1))
+		}
+		if block[0].Cmp(block[1]) <= 0 {
+			size.Sub(size, new(big.Int).Sub(block[1], block[0]))
+			size.Sub(size, big.NewInt(1))
+			last = block[1]
+		}
+	}
+
+	if r.Contains(r.Gateway) && r.excludedUntil(r.Gateway) == nil {
+		size.Sub(size, r.blockSize())
+	}
+	return size.Div(size, r.blockSize())
+}
+
+func invertMask(mask net.IPMask) []byte {
+	inverted := make([]byte, len(mask))
+	for i := range mask {
+		inverted[i] = ^mask[i]
+	}
+	return inverted
+}
+
+func minInt(a, b *big.Int) *big.Int {
+	if a.Cmp(b) < 0 {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b *big.Int) *big.Int {
+	if a.Cmp(b) > 0 {
+		return a
+	}
+	return b
+}
+
+// parseExclude parses an excluded address or CIDR, in canonical form.
+func parseExclude(entry string) (net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, excluded, err := net.ParseCIDR(entry)
+		if err != nil {
+			return net.IPNet{}, fmt.Errorf("invalid exclude %q", entry)
+		}
+		if err := canonicalizeIP(&excluded.IP); err != nil {
+			return net.IPNet{}, err
+		}
+		return *excluded, nil
+	}
+
+	addr := net.ParseIP(entry)
+	if addr == nil {
+		return net.IPNet{}, fmt.Errorf("invalid exclude %q", entry)
+	}
+	if err := canonicalizeIP(&addr); err != nil {
+		return net.IPNet{}, err
+	}
+	return net.IPNet{IP: addr, Mask: net.CIDRMask(len(addr)*8, len(addr)*8)}, nil
+}
+
 // canonicalizeIP makes sure a provided ip is in standard form
 func canonicalizeIP(ip *net.IP) error {
 	if ip.To4() != nil {
@@ -16,6 +16,7 @@ package allocator
 
 import (
 	"fmt"
+	"math/big"
 	"net"
 	"strings"
 )
@@ -69,6 +70,12 @@ func (s *RangeSet) Canonicalize() error {
 			fam = len((*s)[i].RangeStart)
 		} else if fam != len((*s)[i].RangeStart) {
 			return fmt.Errorf("mixed address families")
+		} else if (*s)[i].AllocationStrategy != (*s)[0].AllocationStrategy {
+			return fmt.Errorf("mixed allocation strategies")
+		} else if (*s)[i].Balanced != (*s)[0].Balanced {
+			return fmt.Errorf("mixed balancing")
+		} else if (*s)[i].PrefixLength != (*s)[0].PrefixLength {
+			return fmt.Errorf("mixed prefix lengths")
 		}
 	}
 
@@ -85,6 +92,45 @@ func (s *RangeSet) Canonicalize() error {
 	return nil
 }
 
+// Allocatable reports whether addr is an IP the set may allocate: within one
+// of its ranges, and neither the gateway of that range nor excluded.
+func (s *RangeSet) Allocatable(addr net.IP) bool {
+	r, err := s.RangeFor(addr)
+	if err != nil {
+		return false
+	}
+	return r.allocatable(addr)
+}
+
+// PrefixLength returns the length of the IPv6 prefixes the set delegates,
+// or 0 if it allocates single IPs.
+func (s *RangeSet) PrefixLength() int {
+	return (*s)[0].PrefixLength
+}
+
+// Capacity returns the number of IPs, or delegated prefixes, the set may
+// allocate.
+func (s *RangeSet) Capacity() *big.Int {
+	capacity := big.NewInt(0)
+	for i := range *s {
+		capacity.Add(capacity, (*s)[i].size())
+	}
+	return capacity
+}
+
+// allocationStrategy returns the allocation strategy of the ranges of the set.
+func (s *RangeSet) allocationStrategy() string {
+	if (*s)[0].AllocationStrategy == "" {
+		return StrategySequential
+	}
+	return (*s)[0].AllocationStrategy
+}
+
+// balanced tells whether the set spreads its allocations over its ranges.
+func (s *RangeSet) balanced() bool {
+	return (*s)[0].Balanced
+}
+
 func (s *RangeSet) String() string {
 	out := []string{}
 	for _, r := range *s {
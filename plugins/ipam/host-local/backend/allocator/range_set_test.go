@@ -52,6 +52,59 @@ var _ = Describe("range sets", func() {
 		Expect(err).To(MatchError("subnets 192.168.0.1-192.168.15.254 and 192.168.2.1-192.168.2.254 overlap"))
 	})
 
+	It("should reject mixed allocation strategies or balancing within a set", func() {
+		p := RangeSet{
+			{Subnet: mustSubnet("192.168.0.0/24"), AllocationStrategy: StrategyRandom},
+			{Subnet: mustSubnet("192.168.1.0/24")},
+		}
+
+		err := p.Canonicalize()
+		Expect(err).To(MatchError("mixed allocation strategies"))
+
+		p = RangeSet{
+			{Subnet: mustSubnet("192.168.0.0/24"), Balanced: true},
+			{Subnet: mustSubnet("192.168.1.0/24")},
+		}
+		err = p.Canonicalize()
+		Expect(err).To(MatchError("mixed balancing"))
+
+		p = RangeSet{
+			{Subnet: mustSubnet("2001:db8::/64"), PrefixLength: 112},
+			{Subnet: mustSubnet("2001:db8:1::/64"), PrefixLength: 96},
+		}
+		err = p.Canonicalize()
+		Expect(err).To(MatchError("mixed prefix lengths"))
+	})
+
+	It("should count the allocatable IPs of a set", func() {
+		p := RangeSet{
+			{Subnet: mustSubnet("192.168.0.0/24"), Exclude: []string{"192.168.0.0/28", "192.168.0.8/29", "192.168.0.20"}},
+			{Subnet: mustSubnet("192.168.1.0/24"), Gateway: net.ParseIP("192.168.1.20"), RangeStart: net.ParseIP("192.168.1.100")},
+		}
+		Expect(p.Canonicalize()).To(Succeed())
+
+		// 192.168.0.16-192.168.0.254 but .20, and 192.168.1.100-192.168.1.254
+		Expect(p.Capacity().Int64()).To(Equal(int64(238 + 155)))
+		Expect(p.Allocatable(net.ParseIP("192.168.0.1"))).To(BeFalse())
+		Expect(p.Allocatable(net.ParseIP("192.168.0.20"))).To(BeFalse())
+		Expect(p.Allocatable(net.ParseIP("192.168.0.21"))).To(BeTrue())
+		Expect(p.Allocatable(net.ParseIP("192.168.1.20"))).To(BeFalse())
+		Expect(p.Allocatable(net.ParseIP("192.168.1.100"))).To(BeTrue())
+	})
+
+	It("should count the delegated prefixes of a set", func() {
+		p := RangeSet{
+			{Subnet: mustSubnet("2001:db8::/112"), PrefixLength: 120, Exclude: []string{"2001:db8::280/122", "2001:db8::300/120"}},
+			{Subnet: mustSubnet("2001:db8:1::/112"), PrefixLength: 120, RangeEnd: net.ParseIP("2001:db8:1::fff")},
+		}
+		Expect(p.Canonicalize()).To(Succeed())
+
+		// 256 /120s but the gateway's and 2 excluded, and 16 /120s but the gateway's
+		Expect(p.Capacity().Int64()).To(Equal(int64(253 + 15)))
+		Expect(p.Allocatable(net.ParseIP("2001:db8::2ff"))).To(BeFalse())
+		Expect(p.Allocatable(net.ParseIP("2001:db8::400"))).To(BeTrue())
+	})
+
 	It("should discover overlaps outside a set", func() {
 		p1 := RangeSet{
 			{Subnet: mustSubnet("192.168.0.0/20")},
@@ -120,6 +120,67 @@ var _ = Describe("IP ranges", func() {
 		Expect(err).Should(MatchError("RangeStart 192.0.2.50 not in network 192.0.2.0/24"))
 	})
 
+	It("should reject an unknown allocationStrategy", func() {
+		r := Range{Subnet: mustSubnet("192.0.2.0/24"), AllocationStrategy: "fifo"}
+		err := r.Canonicalize()
+		Expect(err).Should(MatchError(`invalid allocationStrategy "fifo" (must be "sequential", "random" or "least-recently-used")`))
+	})
+
+	It("should align the ranges of delegated prefixes", func() {
+		r := Range{Subnet: mustSubnet("2001:db8::/64"), PrefixLength: 112}
+		Expect(r.Canonicalize()).To(Succeed())
+		Expect(r.RangeStart).To(Equal(net.ParseIP("2001:db8::")))
+		Expect(r.RangeEnd).To(Equal(net.ParseIP("2001:db8::ffff:ffff:ffff:ffff")))
+		Expect(r.allocation(net.ParseIP("2001:db8::1:2"))).To(Equal(&net.IPNet{IP: net.ParseIP("2001:db8::1:0"), Mask: net.CIDRMask(112, 128)}))
+		Expect(r.allocatable(net.ParseIP("2001:db8::ff"))).To(BeFalse())
+		Expect(r.allocatable(net.ParseIP("2001:db8::1:0"))).To(BeTrue())
+
+		r = Range{Subnet: mustSubnet("2001:db8::/64"), PrefixLength: 112, Exclude: []string{"2001:db8::2:80"}}
+		Expect(r.Canonicalize()).To(Succeed())
+		Expect(r.excludedUntil(net.ParseIP("2001:db8::2:0"))).To(Equal(net.ParseIP("2001:db8::2:80")))
+		Expect(r.excludedUntil(net.ParseIP("2001:db8::3:0"))).To(BeNil())
+	})
+
+	It("should reject invalid delegated prefixes", func() {
+		r := Range{Subnet: mustSubnet("192.0.2.0/24"), PrefixLength: 28}
+		Expect(r.Canonicalize()).To(MatchError("prefixLength is only supported for IPv6 networks, not 192.0.2.0/24"))
+
+		r = Range{Subnet: mustSubnet("2001:db8::/64"), PrefixLength: 64}
+		Expect(r.Canonicalize()).To(MatchError("prefixLength 64 must be longer than 64, that of network 2001:db8::/64, and at most 126"))
+
+		r = Range{Subnet: mustSubnet("2001:db8::/64"), PrefixLength: 112, RangeStart: net.ParseIP("2001:db8::1:1")}
+		Expect(r.Canonicalize()).To(MatchError("RangeStart 2001:db8::1:1 is not the first IP of a /112"))
+
+		r = Range{Subnet: mustSubnet("2001:db8::/64"), PrefixLength: 112, RangeEnd: net.ParseIP("2001:db8::1:0")}
+		Expect(r.Canonicalize()).To(MatchError("RangeEnd 2001:db8::1:0 is not the last IP of a /112"))
+	})
+
+	It("should parse excluded addresses and CIDRs", func() {
+		r := Range{Subnet: mustSubnet("192.0.2.0/24"), Exclude: []string{"192.0.2.10", "192.0.2.64/26"}}
+		Expect(r.Canonicalize()).To(Succeed())
+		Expect(r.excludedUntil(net.ParseIP("192.0.2.10"))).To(Equal(net.IP{192, 0, 2, 10}))
+		Expect(r.excludedUntil(net.ParseIP("192.0.2.70"))).To(Equal(net.IP{192, 0, 2, 127}))
+		Expect(r.excludedUntil(net.ParseIP("192.0.2.11"))).To(BeNil())
+
+		r = Range{Subnet: mustSubnet("2001:db8::/64"), Exclude: []string{"2001:db8::ff00/120"}}
+		Expect(r.Canonicalize()).To(Succeed())
+		Expect(r.excludedUntil(net.ParseIP("2001:db8::ff10"))).To(Equal(net.ParseIP("2001:db8::ffff")))
+	})
+
+	It("should reject invalid excluded addresses", func() {
+		r := Range{Subnet: mustSubnet("192.0.2.0/24"), Exclude: []string{"192.0.2.300"}}
+		Expect(r.Canonicalize()).To(MatchError(`invalid exclude "192.0.2.300"`))
+
+		r = Range{Subnet: mustSubnet("192.0.2.0/24"), Exclude: []string{"192.0.3.10"}}
+		Expect(r.Canonicalize()).To(MatchError("exclude 192.0.3.10 not in network 192.0.2.0/24"))
+
+		r = Range{Subnet: mustSubnet("192.0.2.0/24"), Exclude: []string{"192.0.0.0/16"}}
+		Expect(r.Canonicalize()).To(MatchError("exclude 192.0.0.0/16 not in network 192.0.2.0/24"))
+
+		r = Range{Subnet: mustSubnet("192.0.2.0/24"), Exclude: []string{"2001:db8::1"}}
+		Expect(r.Canonicalize()).To(MatchError("exclude 2001:db8::1 not in network 192.0.2.0/24"))
+	})
+
 	It("should parse all fields correctly", func() {
 		snstr := "192.0.2.0/24"
 		r := Range{
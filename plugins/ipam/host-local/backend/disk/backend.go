@@ -15,17 +15,23 @@
 package disk
 
 import (
+	"encoding/json"
+	"errors"
 	"net"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend"
 )
 
 const (
 	lastIPFilePrefix = "last_reserved_ip."
+	rangeLockPrefix  = "lock."
+	releasedIPsFile  = "released_ips"
 	LineBreak        = "\r\n"
 )
 
@@ -33,13 +39,28 @@ var defaultDataDir = "/var/lib/cni/networks"
 
 // Store is a simple disk-backed store that creates one file per IP
 // address in a given directory. The contents of the file are the container ID.
+//
+// Reserving an IP creates its file exclusively, so allocations only need
+// the lock of their range set, which guards its last reserved IP, and
+// releases need no lock. The lock of the network guards the released IPs,
+// which are only recorded once TrackReleases is called.
 type Store struct {
 	*FileLock
-	dataDir string
+	dataDir       string
+	trackReleases bool
+
+	mu         sync.Mutex
+	rangeLocks map[string]*FileLock
 }
 
-// Store implements the Store interface
-var _ backend.Store = &Store{}
+// Store implements the Store, ReleaseTracker, AllocationLister and
+// RangeLocker interfaces
+var (
+	_ backend.Store            = &Store{}
+	_ backend.ReleaseTracker   = &Store{}
+	_ backend.AllocationLister = &Store{}
+	_ backend.RangeLocker      = &Store{}
+)
 
 func New(network, dataDir string) (*Store, error) {
 	if dataDir == "" {
@@ -54,7 +75,75 @@ func New(network, dataDir string) (*Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Store{lk, dir}, nil
+	return &Store{FileLock: lk, dataDir: dir, rangeLocks: map[string]*FileLock{}}, nil
+}
+
+// TrackReleases makes the store record when IPs are released, which only
+// the least-recently-used allocation strategy needs.
+func (s *Store) TrackReleases() {
+	s.trackReleases = true
+}
+
+// rangeLock returns the lock of the range set rangeID.
+func (s *Store) rangeLock(rangeID string) (*FileLock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lk, ok := s.rangeLocks[rangeID]; ok {
+		return lk, nil
+	}
+	path := GetEscapedPath(s.dataDir, rangeLockPrefix+rangeID)
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	lk, err := NewFileLock(path)
+	if err != nil {
+		return nil, err
+	}
+	s.rangeLocks[rangeID] = lk
+	return lk, nil
+}
+
+// LockRange acquires the lock of the range set rangeID.
+func (s *Store) LockRange(rangeID string) error {
+	lk, err := s.rangeLock(rangeID)
+	if err != nil {
+		return err
+	}
+	return lk.Lock()
+}
+
+// TryLockRange acquires the lock of the range set rangeID, unless another
+// invocation holds it.
+func (s *Store) TryLockRange(rangeID string) (bool, error) {
+	lk, err := s.rangeLock(rangeID)
+	if err != nil {
+		return false, err
+	}
+	return lk.TryLock()
+}
+
+// UnlockRange releases the lock of the range set rangeID.
+func (s *Store) UnlockRange(rangeID string) error {
+	s.mu.Lock()
+	lk, ok := s.rangeLocks[rangeID]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return lk.Unlock()
+}
+
+// Close closes the lock of the network and those of its range sets.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	for _, lk := range s.rangeLocks {
+		lk.Close()
+	}
+	s.rangeLocks = map[string]*FileLock{}
+	s.mu.Unlock()
+	return s.FileLock.Close()
 }
 
 func (s *Store) Reserve(id string, ifname string, ip net.IP, rangeID string) (bool, error) {
@@ -131,7 +220,7 @@ func (s *Store) FindByID(id string, ifname string) bool {
 }
 
 func (s *Store) ReleaseByKey(match string) (bool, error) {
-	found := false
+	var released []string
 	err := filepath.Walk(s.dataDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() {
 			return nil
@@ -144,15 +233,22 @@ func (s *Store) ReleaseByKey(match string) (bool, error) {
 			if err := os.Remove(path); err != nil {
 				return nil
 			}
-			found = true
+			released = append(released, info.Name())
 		}
 		return nil
 	})
-	return found, err
+	if err == nil && s.trackReleases && len(released) > 0 {
+		if err = s.Lock(); err == nil {
+			err = s.recordReleased(released)
+			s.Unlock()
+		}
+	}
+	return len(released) > 0, err
 }
 
 // N.B. This function eats errors to be tolerant and
-// release as much as possible
+// release as much as possible. It takes the lock of the network itself, if
+// it has to record the releases.
 func (s *Store) ReleaseByID(id string, ifname string) error {
 	match := strings.TrimSpace(id) + LineBreak + ifname
 	found, err := s.ReleaseByKey(match)
@@ -194,6 +290,132 @@ func (s *Store) GetByID(id string, ifname string) []net.IP {
 	return ips
 }
 
+// GC releases the IPs whose ID and ifname are not a valid attachment, and
+// forgets the last reserved IP of the ranges that are not valid. IPs
+// reserved by previous versions are checked with an empty ifname.
+func (s *Store) GC(validAttachment func(id, ifname string) bool, validRange func(rangeID string) bool) error {
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	var released []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.dataDir, entry.Name())
+
+		if rangeID, ok := strings.CutPrefix(entry.Name(), lastIPFilePrefix); ok {
+			if !validRange(rangeID) {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					errs = append(errs, err.Error())
+				}
+			}
+			continue
+		}
+
+		ipString := entry.Name()
+		if runtime.GOOS == "windows" {
+			ipString = strings.ReplaceAll(ipString, "_", ":")
+		}
+		if net.ParseIP(ipString) == nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		id, ifname, _ := strings.Cut(strings.TrimSpace(string(data)), LineBreak)
+		if validAttachment(strings.TrimSpace(id), ifname) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+			continue
+		}
+		released = append(released, entry.Name())
+	}
+	if s.trackReleases {
+		if err := s.recordReleased(released); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if errs != nil {
+		return errors.New(strings.Join(errs, ";"))
+	}
+	return nil
+}
+
+// AllocatedIPs returns all the IPs allocated in the network.
+func (s *Store) AllocatedIPs() ([]net.IP, error) {
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ipString := entry.Name()
+		if runtime.GOOS == "windows" {
+			ipString = strings.ReplaceAll(ipString, "_", ":")
+		}
+		if ip := net.ParseIP(ipString); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+// ReleasedIPs returns when the IPs were last released.
+func (s *Store) ReleasedIPs() (map[string]time.Time, error) {
+	released := map[string]time.Time{}
+	data, err := os.ReadFile(filepath.Join(s.dataDir, releasedIPsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return released, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &released); err != nil {
+		return nil, err
+	}
+	return released, nil
+}
+
+// recordReleased records that the IPs of the files were released now. The
+// lock of the network must be held.
+func (s *Store) recordReleased(fnames []string) error {
+	if len(fnames) == 0 {
+		return nil
+	}
+	released, err := s.ReleasedIPs()
+	if err != nil {
+		// Start over rather than failing every release
+		released = map[string]time.Time{}
+	}
+	now := time.Now()
+	for _, fname := range fnames {
+		if runtime.GOOS == "windows" {
+			fname = strings.ReplaceAll(fname, "_", ":")
+		}
+		released[fname] = now
+	}
+	data, err := json.Marshal(released)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dataDir, releasedIPsFile)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
 func GetEscapedPath(dataDir string, fname string) string {
 	if runtime.GOOS == "windows" {
 		fname = strings.ReplaceAll(fname, ":", "_")
@@ -0,0 +1,107 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Range locks", func() {
+	var first, second *Store
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		var err error
+		first, err = New("net", dir)
+		Expect(err).NotTo(HaveOccurred())
+		// Another invocation of the plugin
+		second, err = New("net", dir)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(first.Close()).To(Succeed())
+		Expect(second.Close()).To(Succeed())
+	})
+
+	It("only excludes the allocations of the same range set", func() {
+		Expect(first.LockRange("0")).To(Succeed())
+		Expect(second.LockRange("1")).To(Succeed())
+		Expect(second.Lock()).To(Succeed())
+		Expect(second.Unlock()).To(Succeed())
+		Expect(second.UnlockRange("1")).To(Succeed())
+
+		locked := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(second.LockRange("0")).To(Succeed())
+			close(locked)
+		}()
+		Consistently(locked, 100*time.Millisecond).ShouldNot(BeClosed())
+		Expect(first.UnlockRange("0")).To(Succeed())
+		Eventually(locked).Should(BeClosed())
+		Expect(second.UnlockRange("0")).To(Succeed())
+	})
+
+	It("tells when the range set is locked elsewhere rather than waiting", func() {
+		Expect(first.LockRange("0")).To(Succeed())
+		locked, err := second.TryLockRange("0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(locked).To(BeFalse())
+		locked, err = second.TryLockRange("1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(locked).To(BeTrue())
+		Expect(second.UnlockRange("1")).To(Succeed())
+
+		Expect(first.UnlockRange("0")).To(Succeed())
+		locked, err = second.TryLockRange("0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(locked).To(BeTrue())
+		Expect(second.UnlockRange("0")).To(Succeed())
+	})
+
+	It("only records the released IPs when tracking them", func() {
+		ip := net.ParseIP("10.1.2.3")
+		reserved, err := first.Reserve("c1", "eth0", ip, "0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(BeTrue())
+		Expect(first.ReleaseByID("c1", "eth0")).To(Succeed())
+		Expect(filepath.Join(first.dataDir, releasedIPsFile)).NotTo(BeAnExistingFile())
+
+		second.TrackReleases()
+		reserved, err = second.Reserve("c2", "eth0", ip, "0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(BeTrue())
+		Expect(second.ReleaseByID("c2", "eth0")).To(Succeed())
+		released, err := second.ReleasedIPs()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(released).To(HaveKey("10.1.2.3"))
+		_, err = os.Stat(filepath.Join(second.dataDir, releasedIPsFile))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("does not take the lock files for IPs", func() {
+		Expect(first.LockRange("0")).To(Succeed())
+		Expect(first.UnlockRange("0")).To(Succeed())
+		Expect(first.AllocatedIPs()).To(BeEmpty())
+		Expect(first.GC(func(_, _ string) bool { return false }, func(_ string) bool { return false })).To(Succeed())
+	})
+})
@@ -54,6 +54,16 @@ func (l *FileLock) Lock() error {
 	return l.f.Lock()
 }
 
+// TryLock acquires an exclusive lock, unless it is held elsewhere, in which
+// case it returns false
+func (l *FileLock) TryLock() (bool, error) {
+	err := l.f.TryLock()
+	if err == filemutex.AlreadyLocked {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // Unlock releases the lock
 func (l *FileLock) Unlock() error {
 	return l.f.Unlock()
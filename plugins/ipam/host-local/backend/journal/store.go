@@ -0,0 +1,402 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package journal implements a host-local store that keeps all the
+// allocations of a network in a single append-only file.
+//
+// Every reservation and release is appended as one JSON record and synced
+// before it is acknowledged, and the whole file is replayed each time the
+// store is locked. A record torn by a crash can only be the last one and is
+// dropped, so the file never ends up holding half an allocation. Once the
+// file holds many more records than there are allocations, it is compacted
+// by writing the live allocations to a new file and renaming it in place.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend"
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/disk"
+)
+
+const (
+	journalFile = "allocations.journal"
+	// compactThreshold is the number of superseded records that triggers
+	// a compaction.
+	compactThreshold = 1024
+)
+
+var defaultDataDir = "/var/lib/cni/networks"
+
+const (
+	opReserve = "reserve"
+	opRelease = "release"
+	opLast    = "last"
+	opForget  = "forget"
+)
+
+type record struct {
+	Op      string `json:"op"`
+	IP      string `json:"ip,omitempty"`
+	ID      string `json:"id,omitempty"`
+	IfName  string `json:"ifname,omitempty"`
+	RangeID string `json:"range,omitempty"`
+	// Time is when a release happened, in nanoseconds since the epoch
+	Time int64 `json:"time,omitempty"`
+}
+
+type allocation struct {
+	id     string
+	ifname string
+}
+
+// Store keeps the allocations of a network in <dataDir>/<network>/allocations.journal.
+// The state is only valid while the store is locked.
+type Store struct {
+	*disk.FileLock
+	path string
+
+	allocations map[string]allocation
+	lastIPs     map[string]net.IP
+	released    map[string]time.Time
+	records     int
+	loadErr     error
+}
+
+// Store implements the Store, ReleaseTracker and AllocationLister interfaces
+var (
+	_ backend.Store            = &Store{}
+	_ backend.ReleaseTracker   = &Store{}
+	_ backend.AllocationLister = &Store{}
+)
+
+func New(network, dataDir string) (*Store, error) {
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+	dir := filepath.Join(dataDir, network)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	lk, err := disk.NewFileLock(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{FileLock: lk, path: filepath.Join(dir, journalFile)}, nil
+}
+
+// Lock acquires the lock of the network and loads its allocations, which
+// other processes may have changed since the store was last locked.
+func (s *Store) Lock() error {
+	if err := s.FileLock.Lock(); err != nil {
+		return err
+	}
+	s.loadErr = s.load()
+	return s.loadErr
+}
+
+// Unlock compacts the journal if it grew too long, then releases the lock.
+func (s *Store) Unlock() error {
+	if s.loadErr == nil && s.records-s.live() > compactThreshold {
+		// A failed compaction leaves the journal as it was
+		_ = s.compact()
+	}
+	return s.FileLock.Unlock()
+}
+
+func (s *Store) load() error {
+	s.allocations = map[string]allocation{}
+	s.lastIPs = map[string]net.IP{}
+	s.released = map[string]time.Time{}
+	s.records = 0
+
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var offset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", s.path, err)
+		}
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			// Records are written whole with their newline, so this is
+			// not a torn write: leave the journal for an operator to fix.
+			return fmt.Errorf("corrupt record at offset %d of %s: %v", offset, s.path, err)
+		}
+		s.apply(r)
+		s.records++
+		offset += int64(len(line))
+	}
+
+	// Only the last record may be torn, by a crash mid-write, leaving it
+	// without its newline. Drop it, or the next record would be appended
+	// to it.
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != offset {
+		if err := f.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate torn record of %s: %v", s.path, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) apply(r record) {
+	switch r.Op {
+	case opReserve:
+		s.allocations[r.IP] = allocation{id: r.ID, ifname: r.IfName}
+		delete(s.released, r.IP)
+		if ip := net.ParseIP(r.IP); ip != nil && r.RangeID != "" {
+			s.lastIPs[r.RangeID] = ip
+		}
+	case opRelease:
+		delete(s.allocations, r.IP)
+		if r.Time != 0 {
+			s.released[r.IP] = time.Unix(0, r.Time)
+		}
+	case opLast:
+		if ip := net.ParseIP(r.IP); ip != nil {
+			s.lastIPs[r.RangeID] = ip
+		}
+	case opForget:
+		delete(s.lastIPs, r.RangeID)
+	}
+}
+
+// appendRecords appends the records to the journal and syncs it.
+func (s *Store) appendRecords(records ...record) error {
+	if s.loadErr != nil {
+		return s.loadErr
+	}
+
+	var data []byte
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %v", s.path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %v", s.path, err)
+	}
+
+	for _, r := range records {
+		s.apply(r)
+	}
+	s.records += len(records)
+	return nil
+}
+
+// live returns the number of records a compacted journal holds.
+func (s *Store) live() int {
+	return len(s.allocations) + len(s.released) + len(s.lastIPs)
+}
+
+// compact rewrites the journal with only the live allocations and the
+// release times of the free IPs.
+func (s *Store) compact() error {
+	var data []byte
+	add := func(r record) error {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+		return nil
+	}
+	for ip, a := range s.allocations {
+		if err := add(record{Op: opReserve, IP: ip, ID: a.id, IfName: a.ifname}); err != nil {
+			return err
+		}
+	}
+	for ip, t := range s.released {
+		if err := add(record{Op: opRelease, IP: ip, Time: t.UnixNano()}); err != nil {
+			return err
+		}
+	}
+	// Written last, as replaying the reservations may not preserve them
+	for rangeID, ip := range s.lastIPs {
+		if err := add(record{Op: opLast, IP: ip.String(), RangeID: rangeID}); err != nil {
+			return err
+		}
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.records = s.live()
+	return nil
+}
+
+func (s *Store) Reserve(id string, ifname string, ip net.IP, rangeID string) (bool, error) {
+	if s.loadErr != nil {
+		return false, s.loadErr
+	}
+	if _, ok := s.allocations[ip.String()]; ok {
+		return false, nil
+	}
+	err := s.appendRecords(record{
+		Op:      opReserve,
+		IP:      ip.String(),
+		ID:      strings.TrimSpace(id),
+		IfName:  ifname,
+		RangeID: rangeID,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LastReservedIP returns the last reserved IP if exists
+func (s *Store) LastReservedIP(rangeID string) (net.IP, error) {
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return s.lastIPs[rangeID], nil
+}
+
+// FindByID reports whether any IP is allocated to the id and ifname.
+func (s *Store) FindByID(id string, ifname string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.GetByID(id, ifname)) > 0
+}
+
+func (s *Store) ReleaseByID(id string, ifname string) error {
+	id = strings.TrimSpace(id)
+	now := time.Now().UnixNano()
+	records := []record{}
+	for ip, a := range s.allocations {
+		if a.id == id && a.ifname == ifname {
+			records = append(records, record{Op: opRelease, IP: ip, Time: now})
+		}
+	}
+	if len(records) == 0 {
+		return s.loadErr
+	}
+	return s.appendRecords(records...)
+}
+
+// GC releases the IPs whose ID and ifname are not a valid attachment, and
+// forgets the last reserved IP of the ranges that are not valid.
+func (s *Store) GC(validAttachment func(id, ifname string) bool, validRange func(rangeID string) bool) error {
+	now := time.Now().UnixNano()
+	records := []record{}
+	for ip, a := range s.allocations {
+		if !validAttachment(a.id, a.ifname) {
+			records = append(records, record{Op: opRelease, IP: ip, Time: now})
+		}
+	}
+	for rangeID := range s.lastIPs {
+		if !validRange(rangeID) {
+			records = append(records, record{Op: opForget, RangeID: rangeID})
+		}
+	}
+	if len(records) == 0 {
+		return s.loadErr
+	}
+	return s.appendRecords(records...)
+}
+
+// AllocatedIPs returns all the IPs allocated in the network.
+func (s *Store) AllocatedIPs() ([]net.IP, error) {
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	ips := make([]net.IP, 0, len(s.allocations))
+	for ipString := range s.allocations {
+		if ip := net.ParseIP(ipString); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+// ReleasedIPs returns when the free IPs were last released.
+func (s *Store) ReleasedIPs() (map[string]time.Time, error) {
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return s.released, nil
+}
+
+// GetByID returns the IPs which have been allocated to the specific ID
+func (s *Store) GetByID(id string, ifname string) []net.IP {
+	var ips []net.IP
+
+	id = strings.TrimSpace(id)
+	for ipString, a := range s.allocations {
+		if a.id == id && a.ifname == ifname {
+			if ip := net.ParseIP(ipString); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
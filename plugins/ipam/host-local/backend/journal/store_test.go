@@ -0,0 +1,203 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Journal store", func() {
+	var dataDir string
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = os.MkdirTemp("", "journal")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	// open returns a locked store; its state is only loaded under the lock.
+	open := func() *Store {
+		s, err := New("mynet", dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Lock()).To(Succeed())
+		return s
+	}
+	closeStore := func(s *Store) {
+		Expect(s.Unlock()).To(Succeed())
+		Expect(s.Close()).To(Succeed())
+	}
+
+	It("reserves, finds and releases IPs across instances", func() {
+		s := open()
+		reserved, err := s.Reserve("c1", "eth0", net.ParseIP("10.1.2.2"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(BeTrue())
+		reserved, err = s.Reserve("c2", "eth0", net.ParseIP("10.1.2.2"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(BeFalse())
+		reserved, err = s.Reserve("c1", "eth0", net.ParseIP("fd00::2"), "1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(BeTrue())
+		closeStore(s)
+
+		s = open()
+		Expect(s.GetByID("c1", "eth0")).To(ConsistOf(net.ParseIP("10.1.2.2"), net.ParseIP("fd00::2")))
+		Expect(s.GetByID("c1", "eth1")).To(BeEmpty())
+		last, err := s.LastReservedIP("1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(last).To(Equal(net.ParseIP("fd00::2")))
+		Expect(s.ReleaseByID("c1", "eth0")).To(Succeed())
+		closeStore(s)
+
+		s = open()
+		defer closeStore(s)
+		Expect(s.GetByID("c1", "eth0")).To(BeEmpty())
+		// the last reserved IP outlives its release, for round-robin
+		last, err = s.LastReservedIP("0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(last).To(Equal(net.ParseIP("10.1.2.2")))
+	})
+
+	It("drops a torn last record", func() {
+		s := open()
+		_, err := s.Reserve("c1", "eth0", net.ParseIP("10.1.2.2"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		closeStore(s)
+
+		path := filepath.Join(dataDir, "mynet", journalFile)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteString(`{"op":"reserve","ip":"10.1.2.3","id":"c2"`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		s = open()
+		Expect(s.GetByID("c2", "")).To(BeEmpty())
+		_, err = s.Reserve("c3", "eth0", net.ParseIP("10.1.2.4"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		closeStore(s)
+
+		s = open()
+		defer closeStore(s)
+		Expect(s.GetByID("c1", "eth0")).To(Equal([]net.IP{net.ParseIP("10.1.2.2")}))
+		Expect(s.GetByID("c3", "eth0")).To(Equal([]net.IP{net.ParseIP("10.1.2.4")}))
+	})
+
+	It("refuses a corrupt record followed by others, leaving the journal be", func() {
+		s := open()
+		_, err := s.Reserve("c1", "eth0", net.ParseIP("10.1.2.2"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		closeStore(s)
+
+		path := filepath.Join(dataDir, "mynet", journalFile)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteString("garbage\n" + `{"op":"reserve","ip":"10.1.2.3","id":"c2","ifname":"eth0"}` + "\n")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		before, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		s, err = New("mynet", dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Lock()).To(MatchError(ContainSubstring("corrupt record at offset")))
+		_, err = s.Reserve("c3", "eth0", net.ParseIP("10.1.2.4"), "0")
+		Expect(err).To(HaveOccurred())
+		closeStore(s)
+
+		after, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).To(Equal(before))
+	})
+
+	It("compacts the journal once releases pile up", func() {
+		s := open()
+		for i := 0; i <= compactThreshold; i++ {
+			id := fmt.Sprintf("c%d", i)
+			_, err := s.Reserve(id, "eth0", net.ParseIP("10.1.2.2"), "0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.ReleaseByID(id, "eth0")).To(Succeed())
+		}
+		_, err := s.Reserve("kept", "eth0", net.ParseIP("10.1.2.3"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		closeStore(s)
+
+		data, err := os.ReadFile(filepath.Join(dataDir, "mynet", journalFile))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchRegexp(`^(\{[^\n]*\}\n){3}$`))
+
+		s = open()
+		defer closeStore(s)
+		Expect(s.GetByID("kept", "eth0")).To(Equal([]net.IP{net.ParseIP("10.1.2.3")}))
+		last, err := s.LastReservedIP("0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(last).To(Equal(net.ParseIP("10.1.2.3")))
+		released, err := s.ReleasedIPs()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(released).To(HaveKey("10.1.2.2"))
+	})
+
+	It("remembers when the free IPs were released", func() {
+		s := open()
+		_, err := s.Reserve("c1", "eth0", net.ParseIP("10.1.2.2"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = s.Reserve("c2", "eth0", net.ParseIP("10.1.2.3"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		before := time.Now()
+		Expect(s.ReleaseByID("c1", "eth0")).To(Succeed())
+		Expect(s.ReleaseByID("c2", "eth0")).To(Succeed())
+		_, err = s.Reserve("c3", "eth0", net.ParseIP("10.1.2.3"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		closeStore(s)
+
+		s = open()
+		defer closeStore(s)
+		released, err := s.ReleasedIPs()
+		Expect(err).NotTo(HaveOccurred())
+		// 10.1.2.3 is allocated again
+		Expect(released).To(HaveLen(1))
+		Expect(released["10.1.2.2"]).To(BeTemporally(">=", before))
+	})
+
+	It("releases invalid attachments and forgets invalid ranges on GC", func() {
+		s := open()
+		_, err := s.Reserve("c1", "eth0", net.ParseIP("10.1.2.2"), "0")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = s.Reserve("c2", "eth0", net.ParseIP("fd00::2"), "1")
+		Expect(err).NotTo(HaveOccurred())
+		err = s.GC(func(id, _ string) bool { return id == "c1" }, func(rangeID string) bool { return rangeID == "0" })
+		Expect(err).NotTo(HaveOccurred())
+		closeStore(s)
+
+		s = open()
+		defer closeStore(s)
+		Expect(s.GetByID("c1", "eth0")).To(Equal([]net.IP{net.ParseIP("10.1.2.2")}))
+		Expect(s.GetByID("c2", "eth0")).To(BeEmpty())
+		last, err := s.LastReservedIP("1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(last).To(BeNil())
+	})
+})
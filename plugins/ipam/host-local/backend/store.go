@@ -14,7 +14,10 @@
 
 package backend
 
-import "net"
+import (
+	"net"
+	"time"
+)
 
 type Store interface {
 	Lock() error
@@ -25,3 +28,30 @@ type Store interface {
 	ReleaseByID(id string, ifname string) error
 	GetByID(id string, ifname string) []net.IP
 }
+
+// ReleaseTracker is implemented by the stores that remember when each IP
+// was last released, which the least-recently-used allocation strategy
+// needs. It is only valid while the store is locked.
+type ReleaseTracker interface {
+	ReleasedIPs() (map[string]time.Time, error)
+}
+
+// RangeLocker is implemented by the stores that can lock the IPs of a single
+// range set, so that allocations from different range sets of a network do
+// not wait for each other. Allocating then takes the lock of its range set
+// rather than the lock of the store, and releasing takes no lock: the store
+// only holds its own lock for its bookkeeping of releases.
+type RangeLocker interface {
+	LockRange(rangeID string) error
+	// TryLockRange returns false, rather than waiting, if the range set is
+	// locked.
+	TryLockRange(rangeID string) (bool, error)
+	UnlockRange(rangeID string) error
+}
+
+// AllocationLister is implemented by the stores that can list the allocated
+// IPs, which balancing the ranges of a set needs. It is only valid while the
+// store is locked.
+type AllocationLister interface {
+	AllocatedIPs() ([]net.IP, error)
+}
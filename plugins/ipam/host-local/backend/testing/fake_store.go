@@ -17,6 +17,7 @@ package testing
 import (
 	"net"
 	"os"
+	"time"
 
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend"
 )
@@ -24,13 +25,20 @@ import (
 type FakeStore struct {
 	ipMap          map[string]string
 	lastReservedIP map[string]net.IP
+	released       map[string]time.Time
+	// clock orders the releases, one second apart
+	clock int64
 }
 
-// FakeStore implements the Store interface
-var _ backend.Store = &FakeStore{}
+// FakeStore implements the Store, ReleaseTracker and AllocationLister interfaces
+var (
+	_ backend.Store            = &FakeStore{}
+	_ backend.ReleaseTracker   = &FakeStore{}
+	_ backend.AllocationLister = &FakeStore{}
+)
 
 func NewFakeStore(ipmap map[string]string, lastIPs map[string]net.IP) *FakeStore {
-	return &FakeStore{ipmap, lastIPs}
+	return &FakeStore{ipMap: ipmap, lastReservedIP: lastIPs, released: map[string]time.Time{}}
 }
 
 func (s *FakeStore) Lock() error {
@@ -72,10 +80,24 @@ func (s *FakeStore) ReleaseByID(id string, _ string) error {
 	}
 	for _, ip := range toDelete {
 		delete(s.ipMap, ip)
+		s.clock++
+		s.released[ip] = time.Unix(s.clock, 0)
 	}
 	return nil
 }
 
+func (s *FakeStore) AllocatedIPs() ([]net.IP, error) {
+	ips := make([]net.IP, 0, len(s.ipMap))
+	for k := range s.ipMap {
+		ips = append(ips, net.ParseIP(k))
+	}
+	return ips, nil
+}
+
+func (s *FakeStore) ReleasedIPs() (map[string]time.Time, error) {
+	return s.released, nil
+}
+
 func (s *FakeStore) GetByID(id string, _ string) []net.IP {
 	var ips []net.IP
 	for k, v := range s.ipMap {
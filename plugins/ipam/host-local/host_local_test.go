@@ -15,11 +15,15 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -27,7 +31,9 @@ import (
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	types100 "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/disk"
 )
 
@@ -408,6 +414,105 @@ var _ = Describe("host-local Operations", func() {
 			Expect(err).To(HaveOccurred())
 		})
 
+		It(fmt.Sprintf("[%s] allocates and releases addresses with the journal dataBackend", ver), func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "host-local",
+					"subnet": "10.1.2.0/24",
+					"dataDir": "%s",
+					"dataBackend": "journal"
+				}
+			}`, ver, tmpDir)
+
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       nspath,
+				IfName:      ifname,
+				StdinData:   []byte(conf),
+			}
+
+			r, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result, err := types100.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.IPs[0].Address.String()).To(Equal("10.1.2.2/24"))
+
+			// a second container gets the next address
+			args2 := *args
+			args2.ContainerID = "dummy2"
+			r, _, err = testutils.CmdAddWithArgs(&args2, func() error {
+				return cmdAdd(&args2)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result, err = types100.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.IPs[0].Address.String()).To(Equal("10.1.2.3/24"))
+
+			// all the allocations live in a single file
+			entries, err := os.ReadDir(filepath.Join(tmpDir, "mynet"))
+			Expect(err).NotTo(HaveOccurred())
+			names := []string{}
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			Expect(names).To(ConsistOf("allocations.journal", "lock"))
+
+			if testutils.SpecVersionHasCHECK(ver) {
+				err = testutils.CmdCheckWithArgs(args, func() error {
+					return cmdCheck(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			err = testutils.CmdDelWithArgs(args, func() error {
+				return cmdDel(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// the released address is not handed out again right away
+			args.ContainerID = "dummy3"
+			r, _, err = testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result, err = types100.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.IPs[0].Address.String()).To(Equal("10.1.2.4/24"))
+		})
+
+		It(fmt.Sprintf("[%s] rejects an unknown dataBackend", ver), func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "host-local",
+					"subnet": "10.1.2.0/24",
+					"dataDir": "%s",
+					"dataBackend": "sqlite"
+				}
+			}`, ver, tmpDir)
+
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       nspath,
+				IfName:      ifname,
+				StdinData:   []byte(conf),
+			}
+
+			_, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).To(MatchError(`unknown dataBackend "sqlite" (must be "disk" or "journal")`))
+		})
+
 		It(fmt.Sprintf("[%s] doesn't error when passed an unknown ID on DEL", ver), func() {
 			conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
@@ -678,6 +783,434 @@ var _ = Describe("host-local Operations", func() {
 			}
 		})
 	}
+
+	It("gives a recreated pod its previous IP back with stickyIPs", func() {
+		conf := fmt.Sprintf(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+				"type": "host-local",
+				"subnet": "10.1.2.0/24",
+				"dataDir": "%s",
+				"stickyIPs": true,
+				"stickyTTL": "1h"
+			}
+		}`, tmpDir)
+
+		add := func(containerID, pod string) string {
+			args := &skel.CmdArgs{
+				ContainerID: containerID,
+				Netns:       nspath,
+				IfName:      ifname,
+				StdinData:   []byte(conf),
+				Args:        "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=" + pod,
+			}
+			r, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result, err := types100.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			return result.IPs[0].Address.IP.String()
+		}
+		del := func(containerID, pod string) {
+			args := &skel.CmdArgs{
+				ContainerID: containerID,
+				Netns:       nspath,
+				IfName:      ifname,
+				StdinData:   []byte(conf),
+				Args:        "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=" + pod,
+			}
+			Expect(testutils.CmdDelWithArgs(args, func() error {
+				return cmdDel(args)
+			})).To(Succeed())
+		}
+
+		Expect(add("c1", "db-0")).To(Equal("10.1.2.2"))
+		Expect(add("c2", "db-1")).To(Equal("10.1.2.3"))
+		del("c1", "db-0")
+		// DEL may be repeated, without losing the record
+		del("c1", "db-0")
+
+		Expect(add("c3", "web")).To(Equal("10.1.2.4"))
+		Expect(add("c4", "db-0")).To(Equal("10.1.2.2"))
+
+		// once the IP is taken, the pod gets another one
+		del("c2", "db-1")
+		Expect(add("c5", "other")).To(Equal("10.1.2.3"))
+		del("c4", "db-0")
+		Expect(add("c6", "db-1")).To(Equal("10.1.2.5"))
+
+		// and entries expire
+		path := filepath.Join(tmpDir, "mynet", "sticky.json")
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring(`"default/db-0/eth0"`))
+		sticky := stickyIPs{}
+		Expect(json.Unmarshal(data, &sticky)).To(Succeed())
+		sticky["default/db-0/eth0"].Expires = time.Now().Add(-time.Minute)
+		data, err = json.Marshal(sticky)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(path, data, 0o600)).To(Succeed())
+		Expect(add("c7", "db-0")).To(Equal("10.1.2.6"))
+	})
+
+	It("rejects an unknown allocationStrategy", func() {
+		_, _, err := allocator.LoadIPAMConfig([]byte(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"ipam": {
+				"type": "host-local",
+				"subnet": "10.1.2.0/24",
+				"allocationStrategy": "fifo"
+			}
+		}`), "")
+		Expect(err).To(MatchError(`invalid range set 0: invalid allocationStrategy "fifo" (must be "sequential", "random" or "least-recently-used")`))
+	})
+
+	It("allocates from the range sets selected by the runtime", func() {
+		add := func(containerID, selection string) []string {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "1.1.0",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"runtimeConfig": {"ipRangeSelection": %s},
+				"ipam": {
+					"type": "host-local",
+					"dataDir": "%s",
+					"ranges": [
+						[{"subnet": "10.1.2.0/24", "name": "zone-a"}],
+						[{"subnet": "10.1.3.0/24", "name": "zone-b"}],
+						[{"subnet": "2001:db8:1::/64"}]
+					]
+				}
+			}`, selection, tmpDir)
+			args := &skel.CmdArgs{
+				ContainerID: containerID,
+				Netns:       nspath,
+				IfName:      ifname,
+				StdinData:   []byte(conf),
+			}
+			r, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result, err := types100.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			ips := []string{}
+			for _, ipc := range result.IPs {
+				ips = append(ips, ipc.Address.String())
+			}
+			return ips
+		}
+
+		Expect(add("c1", `[{"name": "zone-b"}]`)).To(Equal([]string{"10.1.3.2/24"}))
+		Expect(add("c2", `[{"name": "zone-a"}, {"index": 2}]`)).To(Equal([]string{"10.1.2.2/24", "2001:db8:1::2/64"}))
+		Expect(add("c3", `[{"subnet": "10.1.3.0/24"}]`)).To(Equal([]string{"10.1.3.3/24"}))
+		Expect(add("c4", `[]`)).To(Equal([]string{"10.1.2.3/24", "10.1.3.4/24", "2001:db8:1::3/64"}))
+	})
+
+	It("delegates an IPv6 prefix to each attachment with an on-link route", func() {
+		conf := fmt.Sprintf(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+				"type": "host-local",
+				"dataDir": "%s",
+				"ranges": [
+					[{"subnet": "10.1.2.0/24"}],
+					[{"subnet": "2001:db8:1::/64", "prefixLength": 112}]
+				]
+			}
+		}`, tmpDir)
+		add := func(containerID string) *types100.Result {
+			args := &skel.CmdArgs{
+				ContainerID: containerID,
+				Netns:       nspath,
+				IfName:      ifname,
+				StdinData:   []byte(conf),
+			}
+			r, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result, err := types100.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			return result
+		}
+
+		scopeLink := 253
+		for i, containerID := range []string{"c1", "c2"} {
+			result := add(containerID)
+			Expect(result.IPs).To(HaveLen(2))
+			Expect(result.IPs[0].Address.String()).To(Equal(fmt.Sprintf("10.1.2.%d/24", i+2)))
+			Expect(result.IPs[1].Address.String()).To(Equal(fmt.Sprintf("2001:db8:1::%d:1/112", i+1)))
+			Expect(result.IPs[1].Gateway).To(Equal(net.ParseIP("2001:db8:1::1")))
+			Expect(result.Routes).To(Equal([]*types.Route{{
+				Dst:   net.IPNet{IP: net.ParseIP(fmt.Sprintf("2001:db8:1::%d:0", i+1)), Mask: net.CIDRMask(112, 128)},
+				Scope: &scopeLink,
+			}}))
+		}
+
+		contents, err := os.ReadFile(filepath.Join(tmpDir, "mynet", "2001:db8:1::1:0"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(Equal("c1" + LineBreak + ifname))
+	})
+
+	It("reports an exhausted or nearly full pool on STATUS", func() {
+		conf := fmt.Sprintf(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+				"type": "host-local",
+				"dataDir": "%s",
+				"utilizationThreshold": 50,
+				"ranges": [[{"subnet": "10.1.2.0/29", "exclude": ["10.1.2.6"]}]]
+			}
+		}`, tmpDir)
+		status := func() error {
+			return testutils.CmdStatus(func() error {
+				return cmdStatus(&skel.CmdArgs{StdinData: []byte(conf)})
+			})
+		}
+		add := func(containerID string) {
+			args := &skel.CmdArgs{
+				ContainerID: containerID,
+				Netns:       nspath,
+				IfName:      ifname,
+				StdinData:   []byte(conf),
+			}
+			_, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		// 10.1.2.2 to 10.1.2.5 are allocatable
+		Expect(status()).To(Succeed())
+		add("c1")
+		add("c2")
+		Expect(status()).To(Succeed())
+
+		// Above the utilizationThreshold, ADD still succeeds
+		add("c3")
+		err := status()
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*types.Error).Code).To(Equal(cnierrors.ErrPoolNearlyExhausted))
+		Expect(err.(*types.Error).Msg).To(Equal("IPAM pool nearly exhausted"))
+		Expect(err.(*types.Error).Details).To(Equal("3 of 4 addresses of range set 0 (10.1.2.1-10.1.2.6) are allocated, above the utilizationThreshold of 50%"))
+
+		add("c4")
+		err = status()
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*types.Error).Code).To(Equal(uint(50)))
+		Expect(err.(*types.Error).Msg).To(Equal("IPAM pool exhausted"))
+		Expect(err.(*types.Error).Details).To(Equal("all 4 addresses of range set 0 (10.1.2.1-10.1.2.6) are allocated"))
+	})
+
+	It("reports an unusable data directory on STATUS", func() {
+		dataDir := filepath.Join(tmpDir, "file")
+		Expect(os.WriteFile(dataDir, nil, 0o600)).To(Succeed())
+		conf := fmt.Sprintf(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"ipam": {
+				"type": "host-local",
+				"dataDir": "%s",
+				"subnet": "10.1.2.0/24"
+			}
+		}`, dataDir)
+
+		err := testutils.CmdStatus(func() error {
+			return cmdStatus(&skel.CmdArgs{StdinData: []byte(conf)})
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*types.Error).Code).To(Equal(uint(50)))
+	})
+
+	It("rejects an invalid utilizationThreshold", func() {
+		_, _, err := allocator.LoadIPAMConfig([]byte(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"ipam": {
+				"type": "host-local",
+				"subnet": "10.1.2.0/24",
+				"utilizationThreshold": 120
+			}
+		}`), "")
+		Expect(err).To(MatchError("invalid utilizationThreshold 120 (must be between 0 and 100)"))
+	})
+
+	It("rejects excluded addresses outside of the range", func() {
+		_, _, err := allocator.LoadIPAMConfig([]byte(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"ipam": {
+				"type": "host-local",
+				"ranges": [[{"subnet": "10.1.2.0/24", "exclude": ["10.1.2.250/31", "10.1.3.1"]}]]
+			}
+		}`), "")
+		Expect(err).To(MatchError("invalid range set 0: exclude 10.1.3.1 not in network 10.1.2.0/24"))
+	})
+
+	It("rejects an invalid stickyTTL", func() {
+		_, _, err := allocator.LoadIPAMConfig([]byte(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"ipam": {
+				"type": "host-local",
+				"subnet": "10.1.2.0/24",
+				"stickyIPs": true,
+				"stickyTTL": "forever"
+			}
+		}`), "")
+		Expect(err).To(MatchError(`invalid stickyTTL "forever"`))
+	})
+
+	for _, backend := range []string{"disk", "journal"} {
+		backend := backend
+
+		It(fmt.Sprintf("allocates the least recently used IPs with the %s dataBackend", backend), func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "1.1.0",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "host-local",
+					"dataDir": "%s",
+					"dataBackend": "%s",
+					"ranges": [[{"subnet": "10.1.2.0/29", "allocationStrategy": "least-recently-used"}]]
+				}
+			}`, tmpDir, backend)
+
+			argsFor := func(containerID string) *skel.CmdArgs {
+				return &skel.CmdArgs{
+					ContainerID: containerID,
+					Netns:       nspath,
+					IfName:      ifname,
+					StdinData:   []byte(conf),
+				}
+			}
+			add := func(containerID string) string {
+				args := argsFor(containerID)
+				r, _, err := testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := types100.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				return result.IPs[0].Address.IP.String()
+			}
+			del := func(containerID string) {
+				args := argsFor(containerID)
+				Expect(testutils.CmdDelWithArgs(args, func() error {
+					return cmdDel(args)
+				})).To(Succeed())
+			}
+
+			for i := 2; i <= 6; i++ {
+				Expect(add(fmt.Sprintf("c%d", i))).To(Equal(fmt.Sprintf("10.1.2.%d", i)))
+			}
+			del("c4")
+			del("c2")
+
+			// round-robin would wrap around to 10.1.2.2
+			Expect(add("c7")).To(Equal("10.1.2.4"))
+			Expect(add("c8")).To(Equal("10.1.2.2"))
+		})
+
+		It(fmt.Sprintf("releases the IPs of invalid attachments on GC with the %s dataBackend", backend), func() {
+			conf := func(validAttachments string) []byte {
+				return []byte(fmt.Sprintf(`{
+					"cniVersion": "1.1.0",
+					"name": "mynet",
+					"type": "ipvlan",
+					"master": "foo0",
+					"cni.dev/valid-attachments": %s,
+					"ipam": {
+						"type": "host-local",
+						"subnet": "10.1.2.0/24",
+						"dataDir": "%s",
+						"dataBackend": "%s"
+					}
+				}`, validAttachments, tmpDir, backend))
+			}
+
+			for _, id := range []string{"c1", "c2"} {
+				args := &skel.CmdArgs{
+					ContainerID: id,
+					Netns:       nspath,
+					IfName:      ifname,
+					StdinData:   conf("[]"),
+				}
+				_, _, err := testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			err := cmdGC(&skel.CmdArgs{StdinData: conf(`[{"containerID": "c1", "ifname": "eth0"}]`)})
+			Expect(err).NotTo(HaveOccurred())
+
+			ipamConf, _, err := allocator.LoadIPAMConfig(conf("[]"), "")
+			Expect(err).NotTo(HaveOccurred())
+			store, err := newStore(ipamConf)
+			Expect(err).NotTo(HaveOccurred())
+			defer store.Close()
+			Expect(store.Lock()).To(Succeed())
+			defer store.Unlock()
+			Expect(store.GetByID("c1", ifname)).To(Equal([]net.IP{net.ParseIP("10.1.2.2")}))
+			Expect(store.GetByID("c2", ifname)).To(BeEmpty())
+			// the round-robin position of the range is kept
+			last, err := store.LastReservedIP("0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(last).To(Equal(net.ParseIP("10.1.2.3")))
+		})
+	}
+
+	It("keeps IPs reserved by previous versions for valid containers and forgets stale ranges on GC", func() {
+		dir := filepath.Join(tmpDir, "mynet")
+		Expect(os.MkdirAll(dir, 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "10.1.2.8"), []byte("c1"), 0o644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "10.1.2.9"), []byte("c2"), 0o644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "last_reserved_ip.0"), []byte("10.1.2.9"), 0o644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "last_reserved_ip.3"), []byte("10.9.2.9"), 0o644)).To(Succeed())
+
+		err := cmdGC(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"cni.dev/valid-attachments": [{"containerID": "c1", "ifname": "eth0"}],
+			"ipam": {
+				"type": "host-local",
+				"subnet": "10.1.2.0/24",
+				"dataDir": "%s"
+			}
+		}`, tmpDir))})
+		Expect(err).NotTo(HaveOccurred())
+
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		// sequential allocation does not need the released IPs recorded
+		Expect(names).To(ConsistOf("10.1.2.8", "last_reserved_ip.0", "lock"))
+	})
 })
 
 func mustCIDR(s string) net.IPNet {
@@ -689,3 +1222,59 @@ func mustCIDR(s string) net.IPNet {
 
 	return *n
 }
+
+// networkLocked hides the range locks of a store, to allocate under the
+// lock of the network.
+type networkLocked struct {
+	store
+}
+
+// BenchmarkConcurrentAdds allocates the IPs of ADDs from the range sets of
+// a network concurrently, each goroutine standing for a plugin invocation
+// with a store of its own.
+func BenchmarkConcurrentAdds(b *testing.B) {
+	for _, rangeSets := range []int{1, 2, 4} {
+		sets := make([]string, rangeSets)
+		for i := range sets {
+			sets[i] = fmt.Sprintf(`[{"subnet": "10.%d.0.0/12"}]`, i*16)
+		}
+
+		for _, mode := range []string{"network lock", "range locks"} {
+			b.Run(fmt.Sprintf("%d range sets/%s", rangeSets, mode), func(b *testing.B) {
+				ipamConf, _, err := allocator.LoadIPAMConfig([]byte(fmt.Sprintf(`{
+					"cniVersion": "1.0.0",
+					"name": "mynet",
+					"ipam": {
+						"type": "host-local",
+						"dataDir": %q,
+						"ranges": [%s]
+					}
+				}`, b.TempDir(), strings.Join(sets, ", "))), "")
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				var n atomic.Int64
+				b.RunParallel(func(pb *testing.PB) {
+					s, err := newStore(ipamConf)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					defer s.Close()
+					if mode == "network lock" {
+						s = networkLocked{s}
+					}
+
+					for pb.Next() {
+						args := &skel.CmdArgs{ContainerID: fmt.Sprintf("ctr-%d", n.Add(1)), IfName: "eth0"}
+						if _, _, err := allocateIPs(ipamConf, s, args); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+			})
+		}
+	}
+}
@@ -17,26 +17,73 @@ package main
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
+	cnilog "github.com/containernetworking/plugins/pkg/log"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/trace"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend"
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/disk"
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/journal"
 )
 
+var defaultDataDir = "/var/lib/cni/networks"
+
+type store interface {
+	backend.Store
+	backend.AllocationLister
+	FindByID(id string, ifname string) bool
+	GC(validAttachment func(id, ifname string) bool, validRange func(rangeID string) bool) error
+}
+
+// networkDir returns the directory holding the state of the network.
+func networkDir(ipamConf *allocator.IPAMConfig) string {
+	dataDir := ipamConf.DataDir
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+	return filepath.Join(dataDir, ipamConf.Name)
+}
+
+// newStore opens the store selected by dataBackend.
+func newStore(ipamConf *allocator.IPAMConfig) (store, error) {
+	switch ipamConf.DataBackend {
+	case "", "disk":
+		s, err := disk.New(ipamConf.Name, ipamConf.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		if ipamConf.TracksReleases() {
+			s.TrackReleases()
+		}
+		return s, nil
+	case "journal":
+		return journal.New(ipamConf.Name, ipamConf.DataDir)
+	default:
+		return nil, fmt.Errorf("unknown dataBackend %q (must be \"disk\" or \"journal\")", ipamConf.DataBackend)
+	}
+}
+
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
-		Add:   cmdAdd,
-		Check: cmdCheck,
-		Del:   cmdDel,
-		/* FIXME GC */
-		/* FIXME Status */
-	}, version.All, bv.BuildString("host-local"))
+	skel.PluginMainFuncs(cnilog.Wrap("host-local", trace.Wrap("host-local", skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		GC:     cmdGC,
+		Status: cmdStatus,
+	})), version.All, bv.BuildString("host-local"))
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
@@ -47,7 +94,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 
 	// Look to see if there is at least one IP address allocated to the container
 	// in the data dir, irrespective of what that address actually is
-	store, err := disk.New(ipamConf.Name, ipamConf.DataDir)
+	store, err := newStore(ipamConf)
 	if err != nil {
 		return err
 	}
@@ -77,16 +124,32 @@ func cmdAdd(args *skel.CmdArgs) error {
 		result.DNS = *dns
 	}
 
-	store, err := disk.New(ipamConf.Name, ipamConf.DataDir)
+	store, err := newStore(ipamConf)
 	if err != nil {
 		return err
 	}
 	defer store.Close()
 
-	// Keep the allocators we used, so we can release all IPs if an error
-	// occurs after we start allocating
-	allocs := []*allocator.IPAllocator{}
+	ipConfs, delegated, err := allocateIPs(ipamConf, store, args)
+	if err != nil {
+		return err
+	}
+	result.IPs = ipConfs
+
+	cnilog.Debug("allocated addresses", "ips", result.IPs)
+	result.Routes = append(ipamConf.Routes, delegated...)
 
+	return types.PrintResult(result, confVersion)
+}
+
+// allocateIPs allocates an IP from each selected range set of the network,
+// and returns them in the order of the range sets, with the routes to the
+// prefixes delegated if any.
+//
+// The range sets that another invocation is allocating from are skipped,
+// and only waited for once the others are done, so that concurrent ADDs do
+// not all queue up behind the lock of the first range set.
+func allocateIPs(ipamConf *allocator.IPAMConfig, store store, args *skel.CmdArgs) ([]*current.IPConfig, []*types.Route, error) {
 	// Store all requested IPs in a map, so we can easily remove ones we use
 	// and error if some remain
 	requestedIPs := map[string]net.IP{} // net.IP cannot be a key
@@ -95,48 +158,118 @@ func cmdAdd(args *skel.CmdArgs) error {
 		requestedIPs[ip.String()] = ip
 	}
 
-	for idx, rangeset := range ipamConf.Ranges {
-		allocator := allocator.NewIPAllocator(&rangeset, store, idx)
+	var stickyIPs map[int]net.IP
+	if ipamConf.StickyIPs && ipamConf.PodID != "" {
+		var err error
+		stickyIPs, err = stickyRequestedIPs(ipamConf, args.IfName)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	allocators := map[int]*allocator.IPAllocator{}
+	requested := map[int]net.IP{}
+	pending := []int{}
+	for idx := range ipamConf.Ranges {
+		if !ipamConf.RangeSelected(idx) {
+			continue
+		}
+		rangeset := &ipamConf.Ranges[idx]
+		allocators[idx] = allocator.NewIPAllocator(rangeset, store, idx)
+		pending = append(pending, idx)
 
 		// Check to see if there are any custom IPs requested in this range.
-		var requestedIP net.IP
 		for k, ip := range requestedIPs {
 			if rangeset.Contains(ip) {
-				requestedIP = ip
+				requested[idx] = ip
 				delete(requestedIPs, k)
 				break
 			}
 		}
-
-		ipConf, err := allocator.Get(args.ContainerID, args.IfName, requestedIP)
-		if err != nil {
-			// Deallocate all already allocated IPs
-			for _, alloc := range allocs {
-				_ = alloc.Release(args.ContainerID, args.IfName)
-			}
-			return fmt.Errorf("failed to allocate for range %d: %v", idx, err)
-		}
-
-		allocs = append(allocs, allocator)
-
-		result.IPs = append(result.IPs, ipConf)
 	}
 
-	// If an IP was requested that wasn't fulfilled, fail
+	// If an IP was requested that no range set can fulfill, fail
 	if len(requestedIPs) != 0 {
-		for _, alloc := range allocs {
-			_ = alloc.Release(args.ContainerID, args.IfName)
-		}
 		errstr := "failed to allocate all requested IPs:"
 		for _, ip := range requestedIPs {
 			errstr = errstr + " " + ip.String()
 		}
-		return errors.New(errstr)
+		return nil, nil, errors.New(errstr)
 	}
 
-	result.Routes = ipamConf.Routes
+	// Keep the IPs we allocated, so we can release them all if an error
+	// occurs after we start allocating
+	ipConfs := map[int]*current.IPConfig{}
+	release := func() {
+		for idx := range ipConfs {
+			_ = allocators[idx].Release(args.ContainerID, args.IfName)
+		}
+	}
 
-	return types.PrintResult(result, confVersion)
+	for wait := false; len(pending) > 0; wait = true {
+		busy := []int{}
+		for _, idx := range pending {
+			alloc := allocators[idx]
+			get := func(ip net.IP) (*current.IPConfig, bool, error) {
+				if wait {
+					ipConf, err := alloc.Get(args.ContainerID, args.IfName, ip)
+					return ipConf, true, err
+				}
+				return alloc.TryGet(args.ContainerID, args.IfName, ip)
+			}
+
+			// The previous IP of the pod is only a preference, unlike one
+			// requested by the runtime
+			if stickyIP := stickyIPs[idx]; requested[idx] == nil && stickyIP != nil {
+				ipConf, done, err := get(stickyIP)
+				if !done {
+					busy = append(busy, idx)
+					continue
+				}
+				if err == nil {
+					ipConfs[idx] = ipConf
+					continue
+				}
+			}
+
+			ipConf, done, err := get(requested[idx])
+			if err != nil {
+				// Deallocate all already allocated IPs
+				release()
+				return nil, nil, fmt.Errorf("failed to allocate for range %d: %w", idx, err)
+			}
+			if !done {
+				busy = append(busy, idx)
+				continue
+			}
+			ipConfs[idx] = ipConf
+		}
+		pending = busy
+	}
+
+	var ips []*current.IPConfig
+	var delegated []*types.Route
+	for idx := range ipamConf.Ranges {
+		if ipConf, ok := ipConfs[idx]; ok {
+			ips = append(ips, ipConf)
+			delegated = appendDelegatedRoute(delegated, &ipamConf.Ranges[idx], ipConf)
+		}
+	}
+	return ips, delegated, nil
+}
+
+// scopeLink is RT_SCOPE_LINK, the scope of on-link routes.
+const scopeLink = 253
+
+// appendDelegatedRoute appends to routes the on-link route to the prefix
+// ipConf was delegated, if rangeset delegates prefixes.
+func appendDelegatedRoute(routes []*types.Route, rangeset *allocator.RangeSet, ipConf *current.IPConfig) []*types.Route {
+	if rangeset.PrefixLength() == 0 {
+		return routes
+	}
+	scope := scopeLink
+	prefix := net.IPNet{IP: ipConf.Address.IP.Mask(ipConf.Address.Mask), Mask: ipConf.Address.Mask}
+	return append(routes, &types.Route{Dst: prefix, Scope: &scope})
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -145,12 +278,19 @@ func cmdDel(args *skel.CmdArgs) error {
 		return err
 	}
 
-	store, err := disk.New(ipamConf.Name, ipamConf.DataDir)
+	store, err := newStore(ipamConf)
 	if err != nil {
 		return err
 	}
 	defer store.Close()
 
+	if ipamConf.StickyIPs && ipamConf.PodID != "" {
+		// Never leak the IPs over it, the pod only loses them
+		if err := recordStickyIPs(ipamConf, store, args.ContainerID, args.IfName); err != nil {
+			log.Printf("failed to record the sticky IPs of %s: %v", ipamConf.PodID, err)
+		}
+	}
+
 	// Loop through all ranges, releasing all IPs, even if an error occurs
 	var errs []string
 	for idx, rangeset := range ipamConf.Ranges {
@@ -167,3 +307,66 @@ func cmdDel(args *skel.CmdArgs) error {
 	}
 	return nil
 }
+
+// cmdGC releases the IPs of the network that are not allocated to one of the
+// valid attachments passed by the runtime.
+func cmdGC(args *skel.CmdArgs) error {
+	ipamConf, _, err := allocator.LoadIPAMConfig(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+	// The valid attachments are passed in the network config, not the IPAM one.
+	// IPs reserved by previous versions only record the container ID.
+	valid, err := gc.ParseAttachments(args.StdinData)
+	if err != nil {
+		return err
+	}
+	// Range IDs are the indexes of the range sets, see allocator.NewIPAllocator
+	validRange := func(rangeID string) bool {
+		idx, err := strconv.Atoi(rangeID)
+		return err == nil && idx >= 0 && idx < len(ipamConf.Ranges)
+	}
+
+	store, err := newStore(ipamConf)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock()
+	return store.GC(valid.Valid, validRange)
+}
+
+// cmdStatus reports whether an ADD can succeed: the data directory must be
+// writable, and no range set exhausted. Range sets above the
+// utilizationThreshold are reported too, though ADD still succeeds, so that
+// the runtime learns the pool is nearly full.
+func cmdStatus(args *skel.CmdArgs) error {
+	ipamConf, _, err := allocator.LoadIPAMConfig(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+
+	store, err := newStore(ipamConf)
+	if err != nil {
+		return types.NewError(cnierrors.ErrPluginNotAvailable, "host-local data directory is not usable", err.Error())
+	}
+	defer store.Close()
+
+	if err := status.Writable(networkDir(ipamConf)); err != nil {
+		return err
+	}
+
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	allocated, err := store.AllocatedIPs()
+	store.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to list allocated IPs: %v", err)
+	}
+	return ipamConf.CheckUtilization(allocated)
+}
@@ -0,0 +1,130 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
+)
+
+const stickyFile = "sticky.json"
+
+// stickyEntry records the IPs a pod had, by range ID, when it was deleted.
+type stickyEntry struct {
+	IPs     map[string]net.IP `json:"ips"`
+	Expires time.Time         `json:"expires"`
+}
+
+// stickyIPs are the entries of a network, keyed by <namespace>/<name>/<ifname>.
+type stickyIPs map[string]*stickyEntry
+
+func stickyPath(ipamConf *allocator.IPAMConfig) string {
+	return filepath.Join(networkDir(ipamConf), stickyFile)
+}
+
+func stickyKey(ipamConf *allocator.IPAMConfig, ifname string) string {
+	return ipamConf.PodID + "/" + ifname
+}
+
+func loadStickyIPs(path string) (stickyIPs, error) {
+	sticky := stickyIPs{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sticky, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sticky); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return sticky, nil
+}
+
+// save drops the expired entries and writes the others to path.
+func (s stickyIPs) save(path string, now time.Time) error {
+	for key, entry := range s {
+		if !now.Before(entry.Expires) {
+			delete(s, key)
+		}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// Write then rename, as ADD reads the file without the store lock
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// stickyRequestedIPs returns the IPs the pod had in each range, if it was
+// deleted less than stickyTTL ago. They may have been handed out since.
+func stickyRequestedIPs(ipamConf *allocator.IPAMConfig, ifname string) (map[int]net.IP, error) {
+	sticky, err := loadStickyIPs(stickyPath(ipamConf))
+	if err != nil {
+		return nil, err
+	}
+	entry := sticky[stickyKey(ipamConf, ifname)]
+	if entry == nil || !time.Now().Before(entry.Expires) {
+		return nil, nil
+	}
+
+	requested := map[int]net.IP{}
+	for idx, rangeset := range ipamConf.Ranges {
+		if ip := entry.IPs[strconv.Itoa(idx)]; ip != nil && rangeset.Contains(ip) {
+			requested[idx] = ip
+		}
+	}
+	return requested, nil
+}
+
+// recordStickyIPs records the IPs allocated to the container for its pod,
+// before DEL releases them.
+func recordStickyIPs(ipamConf *allocator.IPAMConfig, store store, containerID, ifname string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	ips := store.GetByID(containerID, ifname)
+	if len(ips) == 0 {
+		// Already released by an earlier DEL, keep what it recorded
+		return nil
+	}
+
+	path := stickyPath(ipamConf)
+	sticky, err := loadStickyIPs(path)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	entry := &stickyEntry{IPs: map[string]net.IP{}, Expires: now.Add(ipamConf.StickyRetention)}
+	for _, ip := range ips {
+		for idx, rangeset := range ipamConf.Ranges {
+			if rangeset.Contains(ip) {
+				entry.IPs[strconv.Itoa(idx)] = ip
+			}
+		}
+	}
+	sticky[stickyKey(ipamConf, ifname)] = entry
+	return sticky.save(path, now)
+}
@@ -35,7 +35,8 @@ type Net struct {
 	IPAM       *IPAMConfig `json:"ipam"`
 
 	RuntimeConfig struct {
-		IPs []string `json:"ips,omitempty"`
+		IPs    []addressArg   `json:"ips,omitempty"`
+		Routes []*types.Route `json:"routes,omitempty"`
 	} `json:"runtimeConfig,omitempty"`
 	Args *struct {
 		A *IPAMArgs `json:"cni"`
@@ -57,7 +58,40 @@ type IPAMEnvArgs struct {
 }
 
 type IPAMArgs struct {
-	IPs []string `json:"ips"`
+	IPs    []addressArg   `json:"ips"`
+	Routes []*types.Route `json:"routes,omitempty"`
+}
+
+// addressArg is an address passed in args or runtimeConfig, either as a
+// string in CIDR notation or as an object with its own gateway.
+type addressArg struct {
+	Address string `json:"address"`
+	Gateway net.IP `json:"gateway,omitempty"`
+}
+
+func (a *addressArg) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Address); err == nil {
+		return nil
+	}
+	type plain addressArg
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+// parseAddressArgs parses the addresses passed in args or runtimeConfig.
+func parseAddressArgs(args []addressArg) ([]Address, error) {
+	addresses := make([]Address, 0, len(args))
+	for _, arg := range args {
+		ip, addr, err := net.ParseCIDR(arg.Address)
+		if err != nil {
+			return nil, fmt.Errorf("an entry in the 'ips' field is NOT in CIDR notation, got: '%s'", arg.Address)
+		}
+		if arg.Gateway != nil && (arg.Gateway.To4() == nil) != (ip.To4() == nil) {
+			return nil, fmt.Errorf("gateway %s is not in the address family of %s", arg.Gateway, arg.Address)
+		}
+		addr.IP = ip
+		addresses = append(addresses, Address{AddressStr: arg.Address, Address: *addr, Gateway: arg.Gateway})
+	}
+	return addresses, nil
 }
 
 type Address struct {
@@ -69,11 +103,11 @@ type Address struct {
 
 func main() {
 	skel.PluginMainFuncs(skel.CNIFuncs{
-		Add:   cmdAdd,
-		Check: cmdCheck,
-		Del:   cmdDel,
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		Status: cmdStatus,
 		/* FIXME GC */
-		/* FIXME Status */
 	}, version.All, bv.BuildString("static"))
 }
 
@@ -195,32 +229,29 @@ func LoadIPAMConfig(bytes []byte, envArgs string) (*IPAMConfig, string, error) {
 	}
 
 	// import address from args
-	if n.Args != nil && n.Args.A != nil && len(n.Args.A.IPs) != 0 {
+	if n.Args != nil && n.Args.A != nil {
 		// args IP overwrites IP, so clear IPAM Config
-		n.IPAM.Addresses = make([]Address, 0, len(n.Args.A.IPs))
-		for _, addrStr := range n.Args.A.IPs {
-			ip, addr, err := net.ParseCIDR(addrStr)
+		if len(n.Args.A.IPs) != 0 {
+			addresses, err := parseAddressArgs(n.Args.A.IPs)
 			if err != nil {
-				return nil, "", fmt.Errorf("an entry in the 'ips' field is NOT in CIDR notation, got: '%s'", addrStr)
+				return nil, "", err
 			}
-			addr.IP = ip
-			n.IPAM.Addresses = append(n.IPAM.Addresses, Address{AddressStr: addrStr, Address: *addr})
+			n.IPAM.Addresses = addresses
 		}
+		n.IPAM.Routes = append(n.IPAM.Routes, n.Args.A.Routes...)
 	}
 
 	// import address from runtimeConfig
 	if len(n.RuntimeConfig.IPs) != 0 {
 		// runtimeConfig IP overwrites IP, so clear IPAM Config
-		n.IPAM.Addresses = make([]Address, 0, len(n.RuntimeConfig.IPs))
-		for _, addrStr := range n.RuntimeConfig.IPs {
-			ip, addr, err := net.ParseCIDR(addrStr)
-			if err != nil {
-				return nil, "", fmt.Errorf("an entry in the 'ips' field is NOT in CIDR notation, got: '%s'", addrStr)
-			}
-			addr.IP = ip
-			n.IPAM.Addresses = append(n.IPAM.Addresses, Address{AddressStr: addrStr, Address: *addr})
+		addresses, err := parseAddressArgs(n.RuntimeConfig.IPs)
+		if err != nil {
+			return nil, "", err
 		}
+		n.IPAM.Addresses = addresses
 	}
+	// Routes from args and runtimeConfig come in addition to the configured ones
+	n.IPAM.Routes = append(n.IPAM.Routes, n.RuntimeConfig.Routes...)
 
 	// Validate all ranges
 	numV4 := 0
@@ -286,3 +317,8 @@ func cmdDel(_ *skel.CmdArgs) error {
 	// Nothing required because of no resource allocation in static plugin.
 	return nil
 }
+
+func cmdStatus(_ *skel.CmdArgs) error {
+	// Always available, the addresses are given by the configuration.
+	return nil
+}
@@ -678,6 +678,72 @@ var _ = Describe("static Operations", func() {
 				fmt.Sprintf("an entry in the 'ips' field is NOT in CIDR notation, got: '%s'", ipStr)))
 		})
 	}
+
+	It("takes per-address gateways and routes from RuntimeConfig and args", func() {
+		conf := `{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"capabilities": {"ips": true},
+			"ipam": {
+				"type": "static",
+				"routes": [{ "dst": "0.0.0.0/0", "gw": "10.10.0.254" }]
+			},
+			"args": {
+				"cni": {
+					"routes": [{ "dst": "192.168.0.0/16", "gw": "10.10.0.253", "priority": 100 }]
+				}
+			},
+			"RuntimeConfig": {
+				"ips": [
+					{ "address": "10.10.0.1/24", "gateway": "10.10.0.254" },
+					"3ffe:ffff:0:01ff::1/64"
+				],
+				"routes": [{ "dst": "3ffe:ffff:0:02ff::/64", "gw": "3ffe:ffff:0:01ff::fe", "priority": 50 }]
+			}
+		}`
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       "/some/where",
+			IfName:      "eth0",
+			StdinData:   []byte(conf),
+		}
+		r, _, err := testutils.CmdAddWithArgs(args, func() error {
+			return cmdAdd(args)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		result, err := types100.GetResult(r)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(result.IPs).To(HaveLen(2))
+		Expect(*result.IPs[0]).To(Equal(types100.IPConfig{
+			Address: mustCIDR("10.10.0.1/24"),
+			Gateway: net.ParseIP("10.10.0.254"),
+		}))
+		Expect(*result.IPs[1]).To(Equal(types100.IPConfig{
+			Address: mustCIDR("3ffe:ffff:0:01ff::1/64"),
+		}))
+		Expect(result.Routes).To(Equal([]*types.Route{
+			{Dst: mustCIDR("0.0.0.0/0"), GW: net.ParseIP("10.10.0.254")},
+			{Dst: mustCIDR("192.168.0.0/16"), GW: net.ParseIP("10.10.0.253"), Priority: 100},
+			{Dst: mustCIDR("3ffe:ffff:0:02ff::/64"), GW: net.ParseIP("3ffe:ffff:0:01ff::fe"), Priority: 50},
+		}))
+	})
+
+	It("errors when an address is passed a gateway of another family", func() {
+		_, _, err := LoadIPAMConfig([]byte(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"ipam": {"type": "static"},
+			"RuntimeConfig": {
+				"ips": [{ "address": "10.10.0.1/24", "gateway": "3ffe:ffff::1" }]
+			}
+		}`), "")
+		Expect(err).To(MatchError("gateway 3ffe:ffff::1 is not in the address family of 10.10.0.1/24"))
+	})
 })
 
 func mustCIDR(s string) net.IPNet {
@@ -0,0 +1,569 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This plugin creates a bond device in the container, enslaving links
+// already in the container, such as those attached by host-device or
+// SR-IOV plugins earlier, or moved there from the host.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+const (
+	defaultMode   = "active-backup"
+	defaultMiimon = 100
+)
+
+// Link is a link to enslave to the bond.
+type Link struct {
+	Name string `json:"name"`
+}
+
+type NetConf struct {
+	types.NetConf
+	Links []Link `json:"links"`
+	// LinksInContainer is false if the links are to be moved from the host
+	// to the container, and back on DEL
+	LinksInContainer bool   `json:"linksInContainer,omitempty"`
+	Mode             string `json:"mode,omitempty"`
+	Miimon           *int   `json:"miimon,omitempty"`
+	XmitHashPolicy   string `json:"xmitHashPolicy,omitempty"`
+	MTU              int    `json:"mtu,omitempty"`
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func loadConf(bytes []byte) (*NetConf, error) {
+	n := &NetConf{
+		Mode: defaultMode,
+	}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+
+	if n.RawPrevResult != nil {
+		if err := version.ParsePrevResult(&n.NetConf); err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+		if _, err := current.NewResultFromResult(n.PrevResult); err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	if len(n.Links) < 2 {
+		return nil, fmt.Errorf("at least two links are needed for a bond, got %d", len(n.Links))
+	}
+	seen := map[string]bool{}
+	for _, l := range n.Links {
+		if l.Name == "" {
+			return nil, errors.New("links must have a name")
+		}
+		if seen[l.Name] {
+			return nil, fmt.Errorf("link %q is listed twice", l.Name)
+		}
+		seen[l.Name] = true
+	}
+
+	mode := netlink.StringToBondMode(n.Mode)
+	if mode == netlink.BOND_MODE_UNKNOWN {
+		return nil, fmt.Errorf("invalid bond mode %q", n.Mode)
+	}
+
+	if n.Miimon == nil {
+		n.Miimon = new(int)
+		*n.Miimon = defaultMiimon
+	}
+	if *n.Miimon < 0 {
+		return nil, fmt.Errorf("invalid miimon %d", *n.Miimon)
+	}
+
+	if n.XmitHashPolicy != "" {
+		if netlink.StringToBondXmitHashPolicy(n.XmitHashPolicy) == netlink.BOND_XMIT_HASH_POLICY_UNKNOWN {
+			return nil, fmt.Errorf("invalid xmitHashPolicy %q", n.XmitHashPolicy)
+		}
+		switch mode {
+		case netlink.BOND_MODE_BALANCE_XOR, netlink.BOND_MODE_802_3AD, netlink.BOND_MODE_BALANCE_TLB, netlink.BOND_MODE_BALANCE_ALB:
+		default:
+			return nil, fmt.Errorf("xmitHashPolicy does not apply to bond mode %q", n.Mode)
+		}
+	}
+
+	if n.MTU < 0 {
+		return nil, fmt.Errorf("invalid MTU %d", n.MTU)
+	}
+
+	return n, nil
+}
+
+// moveLinks moves the named links from the current namespace to dst. The
+// links already moved are moved back if one of them fails.
+func moveLinks(names []string, src, dst ns.NetNS) error {
+	for i, name := range names {
+		err := src.Do(func(_ ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(name)
+			if err != nil {
+				return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to find link %q", name)
+			}
+			if err := netlink.LinkSetNsFd(link, int(dst.Fd())); err != nil {
+				return fmt.Errorf("failed to move link %q: %v", name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			_ = moveLinks(names[:i], dst, src)
+			return err
+		}
+	}
+	return nil
+}
+
+func linkNames(links []Link) []string {
+	names := []string{}
+	for _, l := range links {
+		names = append(names, l.Name)
+	}
+	return names
+}
+
+// createBond creates the bond ifName in the current namespace and enslaves
+// the links of the configuration to it.
+func createBond(n *NetConf, ifName string) (*netlink.Bond, error) {
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = ifName
+	linkAttrs.MTU = n.MTU
+
+	bond := netlink.NewLinkBond(linkAttrs)
+	bond.Mode = netlink.StringToBondMode(n.Mode)
+	bond.Miimon = *n.Miimon
+	if n.XmitHashPolicy != "" {
+		bond.XmitHashPolicy = netlink.StringToBondXmitHashPolicy(n.XmitHashPolicy)
+	}
+
+	if err := netlink.LinkAdd(bond); err != nil {
+		return nil, fmt.Errorf("failed to create bond %q: %v", ifName, err)
+	}
+
+	err := enslave(n.Links, bond)
+	if err != nil {
+		releaseSlaves(bond)
+		_ = netlink.LinkDel(bond)
+		return nil, err
+	}
+
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refetch bond %q: %v", ifName, err)
+	}
+	bond, ok := link.(*netlink.Bond)
+	if !ok {
+		return nil, fmt.Errorf("link %q is not a bond", ifName)
+	}
+	return bond, nil
+}
+
+func enslave(links []Link, bond *netlink.Bond) error {
+	for _, l := range links {
+		slave, err := netlinksafe.LinkByName(l.Name)
+		if err != nil {
+			return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to find link %q", l.Name)
+		}
+		if slave.Attrs().MasterIndex != 0 {
+			return fmt.Errorf("link %q already has a master", l.Name)
+		}
+		// Links are enslaved down, the bond brings them up
+		if err := netlink.LinkSetDown(slave); err != nil {
+			return fmt.Errorf("failed to set %q down: %v", l.Name, err)
+		}
+		if err := netlink.LinkSetMasterByIndex(slave, bond.Index); err != nil {
+			return fmt.Errorf("failed to enslave %q to %q: %v", l.Name, bond.Name, err)
+		}
+		if err := netlink.LinkSetUp(slave); err != nil {
+			return fmt.Errorf("failed to set %q up: %v", l.Name, err)
+		}
+	}
+	return nil
+}
+
+// slavesOf returns the links enslaved to bond.
+func slavesOf(bond netlink.Link) ([]netlink.Link, error) {
+	links, err := netlinksafe.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	slaves := []netlink.Link{}
+	for _, l := range links {
+		if l.Attrs().MasterIndex == bond.Attrs().Index {
+			slaves = append(slaves, l)
+		}
+	}
+	return slaves, nil
+}
+
+// releaseSlaves releases the links enslaved to bond, on a best effort
+// basis.
+func releaseSlaves(bond netlink.Link) {
+	slaves, err := slavesOf(bond)
+	if err != nil {
+		return
+	}
+	for _, s := range slaves {
+		_ = netlink.LinkSetNoMaster(s)
+	}
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	if !n.LinksInContainer {
+		var hostNS ns.NetNS
+		hostNS, err = ns.GetCurrentNS()
+		if err != nil {
+			return fmt.Errorf("failed to get host netns: %v", err)
+		}
+		defer hostNS.Close()
+
+		if err = moveLinks(linkNames(n.Links), hostNS, netns); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				_ = moveLinks(linkNames(n.Links), netns, hostNS)
+			}
+		}()
+	}
+
+	bondInterface := &current.Interface{
+		Name:    args.IfName,
+		Sandbox: netns.Path(),
+	}
+	err = netns.Do(func(_ ns.NetNS) error {
+		bond, err := createBond(n, args.IfName)
+		if err != nil {
+			return err
+		}
+		bondInterface.Mac = bond.HardwareAddr.String()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Delete the bond, releasing the links, if the rest of the setup fails
+	defer func() {
+		if err != nil {
+			_ = netns.Do(func(_ ns.NetNS) error {
+				return deleteBond(args.IfName)
+			})
+		}
+	}()
+
+	var result *current.Result
+	// Configure iface from PrevResult if we have IPs and an IPAM
+	// block has not been configured
+	haveResult := false
+	if n.IPAM.Type == "" && n.PrevResult != nil {
+		result, err = current.NewResultFromResult(n.PrevResult)
+		if err != nil {
+			return err
+		}
+		if len(result.IPs) > 0 {
+			haveResult = true
+		}
+	}
+	if !haveResult {
+		if n.IPAM.Type == "" {
+			err = errors.New("no IPAM configured and no IPs in prevResult")
+			return err
+		}
+
+		// run the IPAM plugin and get back the config to apply
+		var r types.Result
+		r, err = ipam.ExecAdd(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+
+		// Invoke ipam del if err to avoid ip leak
+		defer func() {
+			if err != nil {
+				ipam.ExecDel(n.IPAM.Type, args.StdinData)
+			}
+		}()
+
+		// Convert whatever the IPAM result was into the current Result type
+		result, err = current.NewResultFromResult(r)
+		if err != nil {
+			return err
+		}
+
+		if len(result.IPs) == 0 {
+			err = errors.New("IPAM plugin returned missing IP config")
+			return err
+		}
+	}
+	for _, ipc := range result.IPs {
+		// All addresses belong to the bond
+		ipc.Interface = current.Int(0)
+	}
+
+	result.Interfaces = []*current.Interface{bondInterface}
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return ipam.ConfigureIface(args.IfName, result)
+	})
+	if err != nil {
+		return err
+	}
+
+	result.DNS = n.DNS
+
+	return types.PrintResult(result, n.CNIVersion)
+}
+
+// deleteBond releases the links of the bond ifName and deletes it. It does
+// not fail if the bond does not exist.
+func deleteBond(ifName string) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return fmt.Errorf("failed to find bond %q: %v", ifName, err)
+	}
+	if _, ok := link.(*netlink.Bond); !ok {
+		return fmt.Errorf("link %q is not a bond", ifName)
+	}
+
+	slaves, err := slavesOf(link)
+	if err != nil {
+		return err
+	}
+	for _, s := range slaves {
+		if err := netlink.LinkSetNoMaster(s); err != nil {
+			return fmt.Errorf("failed to release %q from %q: %v", s.Attrs().Name, ifName, err)
+		}
+	}
+
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete bond %q: %v", ifName, err)
+	}
+	return nil
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	// On chained invocation, IPAM block can be empty
+	if n.IPAM.Type != "" {
+		err = ipam.ExecDel(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Netns == "" {
+		return nil
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		//  if NetNs is passed down by the Cloud Orchestration Engine, or if it called multiple times
+		// so don't return an error if the device is already removed.
+		// https://github.com/kubernetes/kubernetes/issues/43014#issuecomment-287164444
+		if _, ok := err.(ns.NSPathNotExistErr); ok {
+			return nil
+		}
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return deleteBond(args.IfName)
+	})
+	if err != nil {
+		return err
+	}
+
+	if n.LinksInContainer {
+		return nil
+	}
+
+	// Move back the links still in the container, DEL may be called again
+	// after they were moved
+	hostNS, err := ns.GetCurrentNS()
+	if err != nil {
+		return fmt.Errorf("failed to get host netns: %v", err)
+	}
+	defer hostNS.Close()
+
+	names := []string{}
+	_ = netns.Do(func(_ ns.NetNS) error {
+		for _, name := range linkNames(n.Links) {
+			if _, err := netlinksafe.LinkByName(name); err == nil {
+				names = append(names, name)
+			}
+		}
+		return nil
+	})
+	return moveLinks(names, netns, hostNS)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	if n.IPAM.Type != "" {
+		err = ipam.ExecCheck(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.PrevResult == nil {
+		return fmt.Errorf("Required prevResult missing")
+	}
+	result, err := current.NewResultFromResult(n.PrevResult)
+	if err != nil {
+		return err
+	}
+
+	var contMap current.Interface
+	for _, intf := range result.Interfaces {
+		if args.IfName == intf.Name && args.Netns == intf.Sandbox {
+			contMap = *intf
+		}
+	}
+
+	// The namespace must be the same as what was configured
+	if args.Netns != contMap.Sandbox {
+		return fmt.Errorf("Sandbox in prevResult %s doesn't match configured netns: %s",
+			contMap.Sandbox, args.Netns)
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		if err := validateBond(contMap, n); err != nil {
+			return err
+		}
+
+		if err := ip.ValidateExpectedInterfaceIPs(args.IfName, result.IPs); err != nil {
+			return err
+		}
+
+		return ip.ValidateExpectedRoute(result.Routes)
+	})
+}
+
+func validateBond(intf current.Interface, n *NetConf) error {
+	link, err := netlinksafe.LinkByName(intf.Name)
+	if err != nil {
+		return fmt.Errorf("Container Interface name in prevResult: %s not found", intf.Name)
+	}
+	bond, ok := link.(*netlink.Bond)
+	if !ok {
+		return fmt.Errorf("Error: Container interface %s not of type bond", intf.Name)
+	}
+
+	if bond.Mode != netlink.StringToBondMode(n.Mode) {
+		return fmt.Errorf("bond %s mode %s does not match expected value: %s", intf.Name, bond.Mode, n.Mode)
+	}
+	if bond.Miimon != *n.Miimon {
+		return fmt.Errorf("bond %s miimon %d does not match expected value: %d", intf.Name, bond.Miimon, *n.Miimon)
+	}
+	if n.XmitHashPolicy != "" && bond.XmitHashPolicy.String() != n.XmitHashPolicy {
+		return fmt.Errorf("bond %s xmit hash policy %s does not match expected value: %s", intf.Name, bond.XmitHashPolicy, n.XmitHashPolicy)
+	}
+
+	if intf.Mac != "" && intf.Mac != bond.HardwareAddr.String() {
+		return fmt.Errorf("Interface %s Mac %s doesn't match container Mac: %s", intf.Name, intf.Mac, bond.HardwareAddr)
+	}
+
+	slaves, err := slavesOf(bond)
+	if err != nil {
+		return err
+	}
+	enslaved := map[string]bool{}
+	for _, s := range slaves {
+		enslaved[s.Attrs().Name] = true
+	}
+	for _, l := range n.Links {
+		if !enslaved[l.Name] {
+			return fmt.Errorf("link %s is not enslaved to bond %s", l.Name, intf.Name)
+		}
+	}
+	return nil
+}
+
+func cmdStatus(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.IPAM.Type != "" {
+		if err := ipam.ExecStatus(n.IPAM.Type, args.StdinData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		Status: cmdStatus,
+	}, version.All, bv.BuildString("bond"))
+}
@@ -0,0 +1,291 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+const ifname = "bond0"
+
+var _ = Describe("bond plugin", func() {
+	ipsOnly := `{"cniVersion": "1.0.0", "ips": [{"address": "10.1.2.3/24"}]}`
+
+	Describe("configuration", func() {
+		It("defaults to an active-backup bond monitoring the links", func() {
+			n, err := loadConf([]byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "bond",
+				"links": [{"name": "net1"}, {"name": "net2"}],
+				"prevResult": %s
+			}`, ipsOnly)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.Mode).To(Equal("active-backup"))
+			Expect(*n.Miimon).To(Equal(100))
+			Expect(n.LinksInContainer).To(BeFalse())
+
+			n, err = loadConf([]byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "bond",
+				"links": [{"name": "net1"}, {"name": "net2"}],
+				"miimon": 0,
+				"prevResult": %s
+			}`, ipsOnly)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*n.Miimon).To(BeZero())
+		})
+
+		It("rejects invalid configurations", func() {
+			for _, conf := range []string{
+				`{"links": [{"name": "net1"}]}`,
+				`{"links": [{"name": "net1"}, {"name": "net1"}]}`,
+				`{"links": [{"name": "net1"}, {}]}`,
+				`{"links": [{"name": "net1"}, {"name": "net2"}], "mode": "active-active"}`,
+				`{"links": [{"name": "net1"}, {"name": "net2"}], "miimon": -1}`,
+				`{"links": [{"name": "net1"}, {"name": "net2"}], "mode": "802.3ad", "xmitHashPolicy": "layer5"}`,
+				`{"links": [{"name": "net1"}, {"name": "net2"}], "xmitHashPolicy": "layer3+4"}`,
+			} {
+				_, err := loadConf([]byte(conf))
+				Expect(err).To(HaveOccurred(), conf)
+			}
+
+			_, err := loadConf([]byte(`{"links": [{"name": "net1"}, {"name": "net2"}], "mode": "802.3ad", "xmitHashPolicy": "layer3+4"}`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("bonding links", func() {
+		var originalNS, targetNs ns.NetNS
+		var args *skel.CmdArgs
+
+		addVeths := func(netns ns.NetNS) {
+			err := netns.Do(func(_ ns.NetNS) error {
+				for _, name := range []string{"net1", "net2"} {
+					veth := &netlink.Veth{
+						LinkAttrs: netlink.LinkAttrs{Name: name},
+						PeerName:  "peer-" + name,
+					}
+					if err := netlink.LinkAdd(veth); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		masterOf := func(netns ns.NetNS, name string) int {
+			var index int
+			err := netns.Do(func(_ ns.NetNS) error {
+				link, err := netlinksafe.LinkByName(name)
+				if err != nil {
+					return err
+				}
+				index = link.Attrs().MasterIndex
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return index
+		}
+
+		BeforeEach(func() {
+			var err error
+			originalNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			targetNs, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+
+			args = &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNs.Path(),
+				IfName:      ifname,
+			}
+		})
+
+		AfterEach(func() {
+			Expect(originalNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(originalNS)).To(Succeed())
+			Expect(targetNs.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(targetNs)).To(Succeed())
+		})
+
+		It("bonds links of the container", func() {
+			addVeths(targetNs)
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "bond",
+				"links": [{"name": "net1"}, {"name": "net2"}],
+				"linksInContainer": true, "mode": "balance-xor", "xmitHashPolicy": "layer2+3", "miimon": 50,
+				"prevResult": %s
+			}`, ipsOnly))
+
+			var result *current.Result
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				r, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				if err != nil {
+					return err
+				}
+				result, err = current.GetResult(r)
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(1))
+			Expect(result.Interfaces[0].Name).To(Equal(ifname))
+			Expect(result.Interfaces[0].Mac).NotTo(BeEmpty())
+
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				link, err := netlinksafe.LinkByName(ifname)
+				if err != nil {
+					return err
+				}
+				Expect(link).To(BeAssignableToTypeOf(&netlink.Bond{}))
+				bond := link.(*netlink.Bond)
+				Expect(bond.Mode).To(Equal(netlink.BOND_MODE_BALANCE_XOR))
+				Expect(bond.Miimon).To(Equal(50))
+				Expect(bond.XmitHashPolicy).To(Equal(netlink.BOND_XMIT_HASH_POLICY_LAYER2_3))
+				Expect(masterOf(targetNs, "net1")).To(Equal(bond.Index))
+				Expect(masterOf(targetNs, "net2")).To(Equal(bond.Index))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			prevResult, err := json.Marshal(result)
+			Expect(err).NotTo(HaveOccurred())
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "bond",
+				"links": [{"name": "net1"}, {"name": "net2"}],
+				"linksInContainer": true, "mode": "balance-xor", "xmitHashPolicy": "layer2+3", "miimon": 50,
+				"prevResult": %s
+			}`, string(prevResult)))
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// CHECK notices a different mode
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "bond",
+				"links": [{"name": "net1"}, {"name": "net2"}],
+				"linksInContainer": true,
+				"prevResult": %s
+			}`, string(prevResult)))
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+			})
+			Expect(err).To(MatchError("bond bond0 mode balance-xor does not match expected value: active-backup"))
+
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(masterOf(targetNs, "net1")).To(BeZero())
+			Expect(masterOf(targetNs, "net2")).To(BeZero())
+
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("moves host links to the container and back", func() {
+			addVeths(originalNS)
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "bond",
+				"links": [{"name": "net1"}, {"name": "net2"}],
+				"prevResult": %s
+			}`, ipsOnly))
+
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(masterOf(targetNs, "net1")).NotTo(BeZero())
+
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(masterOf(originalNS, "net1")).To(BeZero())
+			Expect(masterOf(originalNS, "net2")).To(BeZero())
+		})
+
+		It("moves the host links back when the bond fails", func() {
+			addVeths(originalNS)
+			args.StdinData = []byte(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "bond",
+				"links": [{"name": "net1"}, {"name": "net2"}],
+				"prevResult": {"cniVersion": "1.0.0"}
+			}`)
+
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).To(MatchError("no IPAM configured and no IPs in prevResult"))
+
+			Expect(masterOf(originalNS, "net1")).To(BeZero())
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				_, err := netlinksafe.LinkByName(ifname)
+				return err
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails on missing links", func() {
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "bond",
+				"links": [{"name": "net1"}, {"name": "net2"}],
+				"linksInContainer": true,
+				"prevResult": %s
+			}`, ipsOnly))
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).To(HaveOccurred())
+
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				_, err := netlinksafe.LinkByName(ifname)
+				return err
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -31,11 +31,17 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/config"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/journal"
 	"github.com/containernetworking/plugins/pkg/link"
+	"github.com/containernetworking/plugins/pkg/log"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/trace"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 )
@@ -47,28 +53,41 @@ const defaultBrName = "cni0"
 
 type NetConf struct {
 	types.NetConf
-	BrName                    string       `json:"bridge"`
-	IsGW                      bool         `json:"isGateway"`
-	IsDefaultGW               bool         `json:"isDefaultGateway"`
-	ForceAddress              bool         `json:"forceAddress"`
-	IPMasq                    bool         `json:"ipMasq"`
-	IPMasqBackend             *string      `json:"ipMasqBackend,omitempty"`
-	MTU                       int          `json:"mtu"`
-	HairpinMode               bool         `json:"hairpinMode"`
-	PromiscMode               bool         `json:"promiscMode"`
-	Vlan                      int          `json:"vlan"`
-	VlanTrunk                 []*VlanTrunk `json:"vlanTrunk,omitempty"`
-	PreserveDefaultVlan       bool         `json:"preserveDefaultVlan"`
-	MacSpoofChk               bool         `json:"macspoofchk,omitempty"`
-	EnableDad                 bool         `json:"enabledad,omitempty"`
-	DisableContainerInterface bool         `json:"disableContainerInterface,omitempty"`
-	PortIsolation             bool         `json:"portIsolation,omitempty"`
+	BrName                    string           `json:"bridge"`
+	BrLabel                   string           `json:"bridgeLabel,omitempty"`
+	IsGW                      bool             `json:"isGateway"`
+	IsDefaultGW               bool             `json:"isDefaultGateway"`
+	ForceAddress              bool             `json:"forceAddress"`
+	IPMasq                    bool             `json:"ipMasq"`
+	IPMasqBackend             *string          `json:"ipMasqBackend,omitempty"`
+	MTU                       int              `json:"mtu"`
+	MTUFrom                   string           `json:"mtuFrom,omitempty"`
+	HairpinMode               bool             `json:"hairpinMode"`
+	PromiscMode               bool             `json:"promiscMode"`
+	Vlan                      int              `json:"vlan"`
+	VlanTrunk                 []*VlanTrunk     `json:"vlanTrunk,omitempty"`
+	PreserveDefaultVlan       bool             `json:"preserveDefaultVlan"`
+	MacSpoofChk               bool             `json:"macspoofchk,omitempty"`
+	DeterministicMac          bool             `json:"deterministicMac,omitempty"`
+	SpoofCheck                bool             `json:"spoofCheck,omitempty"`
+	EnableDad                 bool             `json:"enabledad,omitempty"`
+	DisableContainerInterface bool             `json:"disableContainerInterface,omitempty"`
+	PortIsolation             bool             `json:"portIsolation,omitempty"`
+	MulticastFastLeave        bool             `json:"multicastFastLeave,omitempty"`
+	STP                       *bool            `json:"stp,omitempty"`
+	BridgePriority            *uint16          `json:"bridgePriority,omitempty"`
+	ForwardDelay              *uint32          `json:"forwardDelay,omitempty"`
+	HelloTime                 *uint32          `json:"helloTime,omitempty"`
+	Netfilter                 *BridgeNetfilter `json:"brNetfilter,omitempty"`
+	Uplink                    string           `json:"uplink,omitempty"`
+	DataDir                   string           `json:"dataDir,omitempty"`
 
 	Args struct {
 		Cni BridgeArgs `json:"cni,omitempty"`
 	} `json:"args,omitempty"`
 	RuntimeConfig struct {
-		Mac string `json:"mac,omitempty"`
+		Mac  string        `json:"mac,omitempty"`
+		Vlan *PortVlanConf `json:"vlan,omitempty"`
 	} `json:"runtimeConfig,omitempty"`
 
 	mac   string
@@ -81,6 +100,14 @@ type VlanTrunk struct {
 	ID    *int `json:"id,omitempty"`
 }
 
+// PortVlanConf is the VLAN configuration of a single attachment, passed by
+// the runtime. It replaces vlan and vlanTrunk for that attachment, and may
+// combine a PVID with tagged VLANs.
+type PortVlanConf struct {
+	PVID  int          `json:"pvid,omitempty"`
+	Trunk []*VlanTrunk `json:"trunk,omitempty"`
+}
+
 type BridgeArgs struct {
 	Mac string `json:"mac,omitempty"`
 }
@@ -88,13 +115,13 @@ type BridgeArgs struct {
 // MacEnvArgs represents CNI_ARGS
 type MacEnvArgs struct {
 	types.CommonArgs
-	MAC types.UnmarshallableString `json:"mac,omitempty"`
+	MAC          types.UnmarshallableString `json:"mac,omitempty"`
+	StrictConfig types.UnmarshallableBool
 }
 
 type gwInfo struct {
-	gws               []net.IPNet
-	family            int
-	defaultRouteFound bool
+	gws    []net.IPNet
+	family int
 }
 
 func init() {
@@ -105,14 +132,24 @@ func init() {
 }
 
 func loadNetConf(bytes []byte, envArgs string) (*NetConf, string, error) {
+	defer trace.Start("parseConfig").End()
+
 	n := &NetConf{
-		BrName: defaultBrName,
 		// Set default value equal to true to maintain existing behavior.
 		PreserveDefaultVlan: true,
 	}
-	if err := json.Unmarshal(bytes, n); err != nil {
+	if err := config.Decode(bytes, envArgs, n); err != nil {
 		return nil, "", fmt.Errorf("failed to load netconf: %v", err)
 	}
+	if n.BrName != "" && n.BrLabel != "" {
+		return nil, "", errors.New("cannot set bridge and bridgeLabel at the same time")
+	}
+	if n.BrName == "" && n.BrLabel == "" {
+		n.BrName = defaultBrName
+	}
+	if n.MTU != 0 && n.MTUFrom != "" {
+		return nil, "", errors.New("cannot set mtu and mtuFrom at the same time")
+	}
 	if n.Vlan < 0 || n.Vlan > 4094 {
 		return nil, "", fmt.Errorf("invalid VLAN ID %d (must be between 0 and 4094)", n.Vlan)
 	}
@@ -147,6 +184,26 @@ func loadNetConf(bytes []byte, envArgs string) (*NetConf, string, error) {
 		n.mac = mac
 	}
 
+	if n.mac != "" {
+		if n.DeterministicMac {
+			return nil, "", errors.New("cannot set mac and deterministicMac at the same time")
+		}
+		if n.mac, err = validateMac(n.mac); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if portVlan := n.RuntimeConfig.Vlan; portVlan != nil {
+		if portVlan.PVID < 0 || portVlan.PVID > 4094 {
+			return nil, "", fmt.Errorf("invalid PVID %d (must be between 0 and 4094)", portVlan.PVID)
+		}
+		n.Vlan = portVlan.PVID
+		n.vlans, err = collectVlanTrunk(portVlan.Trunk)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
 	return n, n.CNIVersion, nil
 }
 
@@ -217,20 +274,16 @@ func calcGateways(result *current.Result, n *NetConf) (*gwInfo, *gwInfo, error)
 
 		// Determine if this config is IPv4 or IPv6
 		var gws *gwInfo
-		defaultNet := &net.IPNet{}
 		switch {
 		case ipc.Address.IP.To4() != nil:
 			gws = gwsV4
 			gws.family = netlink.FAMILY_V4
-			defaultNet.IP = net.IPv4zero
 		case len(ipc.Address.IP) == net.IPv6len:
 			gws = gwsV6
 			gws.family = netlink.FAMILY_V6
-			defaultNet.IP = net.IPv6zero
 		default:
 			return nil, nil, fmt.Errorf("Unknown IP object: %v", ipc)
 		}
-		defaultNet.Mask = net.IPMask(defaultNet.IP)
 
 		// All IPs currently refer to the container interface
 		ipc.Interface = current.Int(2)
@@ -241,24 +294,6 @@ func calcGateways(result *current.Result, n *NetConf) (*gwInfo, *gwInfo, error)
 			ipc.Gateway = calcGatewayIP(&ipc.Address)
 		}
 
-		// Add a default route for this family using the current
-		// gateway address if necessary.
-		if n.IsDefaultGW && !gws.defaultRouteFound {
-			for _, route := range result.Routes {
-				if route.GW != nil && defaultNet.String() == route.Dst.String() {
-					gws.defaultRouteFound = true
-					break
-				}
-			}
-			if !gws.defaultRouteFound {
-				result.Routes = append(
-					result.Routes,
-					&types.Route{Dst: *defaultNet, GW: ipc.Gateway},
-				)
-				gws.defaultRouteFound = true
-			}
-		}
-
 		// Append this gateway address to the list of gateways
 		if n.IsGW {
 			gw := net.IPNet{
@@ -268,6 +303,11 @@ func calcGateways(result *current.Result, n *NetConf) (*gwInfo, *gwInfo, error)
 			gws.gws = append(gws.gws, gw)
 		}
 	}
+
+	// Add a default route for each family via its gateway if necessary
+	if n.IsDefaultGW {
+		ipam.AddDefaultRoutes(result)
+	}
 	return gwsV4, gwsV6, nil
 }
 
@@ -336,7 +376,69 @@ func bridgeByName(name string) (*netlink.Bridge, error) {
 	return br, nil
 }
 
-func ensureBridge(brName string, mtu int, promiscMode, vlanFiltering bool) (*netlink.Bridge, error) {
+// bridgeByLabel returns the bridge that has label as one of its alternative
+// names or as its alias. Exactly one bridge must match.
+func bridgeByLabel(label string) (*netlink.Bridge, error) {
+	links, err := netlinksafe.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("could not list links: %v", err)
+	}
+
+	var found *netlink.Bridge
+	for _, l := range links {
+		br, ok := l.(*netlink.Bridge)
+		if !ok {
+			continue
+		}
+		matches := br.Alias == label
+		for _, altName := range br.AltNames {
+			if altName == label {
+				matches = true
+			}
+		}
+		if !matches {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("bridges %q and %q both have label %q", found.Name, br.Name, label)
+		}
+		found = br
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no bridge with label %q found", label)
+	}
+	return found, nil
+}
+
+// resolveBridgeLabel sets n.BrName to the name of the pre-existing bridge
+// selected by n.BrLabel, and returns it, after checking that the bridge is
+// configured the way n needs it. Such bridges are managed outside of the
+// plugin, so unlike bridges selected by name they are never created or
+// reconfigured.
+func resolveBridgeLabel(n *NetConf) (*netlink.Bridge, error) {
+	br, err := bridgeByLabel(n.BrLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.vlanFiltering() && (br.VlanFiltering == nil || !*br.VlanFiltering) {
+		return nil, fmt.Errorf("bridge %q with label %q does not have VLAN filtering enabled", br.Name, n.BrLabel)
+	}
+	if n.MTU != 0 && br.MTU != n.MTU {
+		return nil, fmt.Errorf("bridge %q with label %q has MTU %d, expected %d", br.Name, n.BrLabel, br.MTU, n.MTU)
+	}
+	if n.PromiscMode && br.Promisc == 0 {
+		return nil, fmt.Errorf("bridge %q with label %q is not in promiscuous mode", br.Name, n.BrLabel)
+	}
+	if err := checkBridgeNetfilter(br, n.Netfilter); err != nil {
+		return nil, err
+	}
+
+	n.BrName = br.Name
+	return br, nil
+}
+
+func ensureBridge(brName string, mtu int, promiscMode, vlanFiltering bool, stp bridgeSTP) (*netlink.Bridge, error) {
 	linkAttrs := netlink.NewLinkAttrs()
 	linkAttrs.Name = brName
 	linkAttrs.MTU = mtu
@@ -351,6 +453,7 @@ func ensureBridge(brName string, mtu int, promiscMode, vlanFiltering bool) (*net
 	if err != nil && err != syscall.EEXIST {
 		return nil, fmt.Errorf("could not add %q: %v", brName, err)
 	}
+	created := err == nil
 
 	if promiscMode {
 		if err := netlink.SetPromiscOn(br); err != nil {
@@ -365,6 +468,14 @@ func ensureBridge(brName string, mtu int, promiscMode, vlanFiltering bool) (*net
 		return nil, err
 	}
 
+	// STP settings are only applied to a bridge we created; an existing
+	// bridge is left as it is and CHECK reports any mismatch.
+	if created {
+		if err := setBridgeSTP(br, stp); err != nil {
+			return nil, err
+		}
+	}
+
 	// we want to own the routes for this interface
 	_, _ = sysctl.Sysctl(fmt.Sprintf("net/ipv6/conf/%s/accept_ra", brName), "0")
 
@@ -389,7 +500,7 @@ func ensureVlanInterface(br *netlink.Bridge, vlanID int, preserveDefaultVlan boo
 			return nil, fmt.Errorf("faild to find host namespace: %v", err)
 		}
 
-		_, brGatewayIface, err := setupVeth(hostNS, br, name, br.MTU, false, vlanID, nil, preserveDefaultVlan, "", false)
+		_, brGatewayIface, err := setupVeth(hostNS, br, name, br.MTU, false, vlanID, nil, preserveDefaultVlan, "", false, false)
 		if err != nil {
 			return nil, fmt.Errorf("faild to create vlan gateway %q: %v", name, err)
 		}
@@ -419,27 +530,24 @@ func setupVeth(
 	preserveDefaultVlan bool,
 	mac string,
 	portIsolation bool,
+	fastLeave bool,
 ) (*current.Interface, *current.Interface, error) {
-	contIface := &current.Interface{}
-	hostIface := &current.Interface{}
-
-	err := netns.Do(func(hostNS ns.NetNS) error {
-		// create the veth pair in the container and move host end into host netns
-		hostVeth, containerVeth, err := ip.SetupVeth(ifName, mtu, mac, hostNS)
-		if err != nil {
-			return err
-		}
-		contIface.Name = containerVeth.Name
-		contIface.Mac = containerVeth.HardwareAddr.String()
-		contIface.Sandbox = netns.Path()
-		hostIface.Name = hostVeth.Name
-		return nil
-	})
+	// The veth pair is created from the host, with the container side put
+	// straight into the container netns: the only time cmdAdd enters the
+	// container netns is to configure the interface.
+	hostVethIface, contVethIface, err := ip.SetupVethAt(ifName, mtu, mac, netns)
 	if err != nil {
 		return nil, nil, err
 	}
+	contIface := &current.Interface{
+		Name:    contVethIface.Name,
+		Mac:     contVethIface.HardwareAddr.String(),
+		Sandbox: netns.Path(),
+	}
+	hostIface := &current.Interface{
+		Name: hostVethIface.Name,
+	}
 
-	// need to lookup hostVeth again as its index has changed during ns move
 	hostVeth, err := netlinksafe.LinkByName(hostIface.Name)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to lookup %q: %v", hostIface.Name, err)
@@ -461,6 +569,13 @@ func setupVeth(
 		return nil, nil, fmt.Errorf("failed to set isolated on for %v: %v", hostVeth.Attrs().Name, err)
 	}
 
+	// stop forwarding a multicast group as soon as the container leaves it
+	if fastLeave {
+		if err = netlink.LinkSetFastLeave(hostVeth, true); err != nil {
+			return nil, nil, fmt.Errorf("failed to set multicast fast-leave on %v: %v", hostVeth.Attrs().Name, err)
+		}
+	}
+
 	if (vlanID != 0 || len(vlans) > 0) && !preserveDefaultVlan {
 		err = removeDefaultVlan(hostVeth)
 		if err != nil {
@@ -510,12 +625,85 @@ func calcGatewayIP(ipn *net.IPNet) net.IP {
 	return ip.NextIP(nid)
 }
 
+// validateMac checks that mac can be given to a bridge port and returns it
+// in canonical form.
+func validateMac(mac string) (string, error) {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", fmt.Errorf("invalid MAC address %q: %v", mac, err)
+	}
+	if len(hwAddr) != 6 {
+		return "", fmt.Errorf("invalid MAC address %q: not an Ethernet address", mac)
+	}
+	if hwAddr[0]&0x01 != 0 {
+		return "", fmt.Errorf("invalid MAC address %q: not a unicast address", mac)
+	}
+	if hwAddr.String() == "00:00:00:00:00:00" {
+		return "", fmt.Errorf("invalid MAC address %q: all zeros", mac)
+	}
+	return hwAddr.String(), nil
+}
+
+// checkMacConflict fails if mac is already in use on br, either by the bridge
+// itself, by one of its ports, or behind a port according to the FDB.
+func checkMacConflict(br *netlink.Bridge, mac string) error {
+	if br.Attrs().HardwareAddr.String() == mac {
+		return fmt.Errorf("MAC address %s is already in use by bridge %s", mac, br.Name)
+	}
+
+	links, err := netlinksafe.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %v", err)
+	}
+	ports := map[int]string{}
+	for _, l := range links {
+		if l.Attrs().MasterIndex != br.Index {
+			continue
+		}
+		if l.Attrs().HardwareAddr.String() == mac {
+			return fmt.Errorf("MAC address %s is already in use by port %s of bridge %s", mac, l.Attrs().Name, br.Name)
+		}
+		ports[l.Attrs().Index] = l.Attrs().Name
+	}
+
+	fdb, err := netlinksafe.NeighList(0, syscall.AF_BRIDGE)
+	if err != nil {
+		return fmt.Errorf("failed to list FDB entries: %v", err)
+	}
+	for _, entry := range fdb {
+		port, ok := ports[entry.LinkIndex]
+		if !ok || entry.HardwareAddr.String() != mac {
+			continue
+		}
+		return fmt.Errorf("MAC address %s is already in use behind port %s of bridge %s", mac, port, br.Name)
+	}
+	return nil
+}
+
+// vlanFiltering tells whether the bridge needs VLAN filtering.
+func (n *NetConf) vlanFiltering() bool {
+	return n.Vlan != 0 || n.VlanTrunk != nil || n.RuntimeConfig.Vlan != nil
+}
+
 func setupBridge(n *NetConf) (*netlink.Bridge, *current.Interface, error) {
-	vlanFiltering := n.Vlan != 0 || n.VlanTrunk != nil
-	// create bridge if necessary
-	br, err := ensureBridge(n.BrName, n.MTU, n.PromiscMode, vlanFiltering)
+	// Take the MTU of the uplink as it is now, rather than a static one
+	if n.MTUFrom != "" {
+		uplink, err := netlinksafe.LinkByName(n.MTUFrom)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to lookup mtuFrom interface %q: %v", n.MTUFrom, err)
+		}
+		n.MTU = uplink.Attrs().MTU
+	}
+
+	var br *netlink.Bridge
+	var err error
+	if n.BrLabel != "" {
+		br, err = resolveBridgeLabel(n)
+	} else {
+		br, err = ensureOwnBridge(n)
+	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create bridge %q: %v", n.BrName, err)
+		return nil, nil, err
 	}
 
 	return br, &current.Interface{
@@ -524,6 +712,29 @@ func setupBridge(n *NetConf) (*netlink.Bridge, *current.Interface, error) {
 	}, nil
 }
 
+// ensureOwnBridge creates the bridge named by n if necessary, and applies
+// the settings of n that are owned by the network.
+func ensureOwnBridge(n *NetConf) (*netlink.Bridge, error) {
+	br, err := ensureBridge(n.BrName, n.MTU, n.PromiscMode, n.vlanFiltering(), n.bridgeSTP())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bridge %q: %v", n.BrName, err)
+	}
+
+	// unlike STP, these are owned by the network and applied on every ADD
+	if err := setBridgeNetfilter(br, n.Netfilter); err != nil {
+		return nil, err
+	}
+
+	// and track the uplink if it was retuned since the bridge was created
+	if n.MTUFrom != "" && br.MTU != n.MTU {
+		if err := netlink.LinkSetMTU(br, n.MTU); err != nil {
+			return nil, fmt.Errorf("failed to set MTU of bridge %q to %d: %v", n.BrName, n.MTU, err)
+		}
+		br.MTU = n.MTU
+	}
+	return br, nil
+}
+
 func enableIPForward(family int) error {
 	if family == netlink.FAMILY_V4 {
 		return ip.EnableIP4Forward()
@@ -545,6 +756,10 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return fmt.Errorf("cannot use IPAM when DisableContainerInterface flag is set")
 	}
 
+	if n.SpoofCheck && !isLayer3 {
+		return fmt.Errorf("spoofCheck requires IPAM to know the container IPs")
+	}
+
 	if n.IsDefaultGW {
 		n.IsGW = true
 	}
@@ -557,17 +772,36 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	log.Debug("bridge ready", "name", br.Attrs().Name)
+
+	if n.Uplink != "" {
+		if err := ensureUplink(br, n); err != nil {
+			return err
+		}
+	}
+
+	if n.DeterministicMac {
+		// The container interface keeps its MAC across restarts of the
+		// same attachment
+		n.mac = ip.StableMAC(args.ContainerID, args.IfName).String()
+	}
+	if n.mac != "" {
+		if err := checkMacConflict(br, n.mac); err != nil {
+			return err
+		}
+	}
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
-	hostInterface, containerInterface, err := setupVeth(netns, br, args.IfName, n.MTU, n.HairpinMode, n.Vlan, n.vlans, n.PreserveDefaultVlan, n.mac, n.PortIsolation)
+	hostInterface, containerInterface, err := setupVeth(netns, br, args.IfName, n.MTU, n.HairpinMode, n.Vlan, n.vlans, n.PreserveDefaultVlan, n.mac, n.PortIsolation, n.MulticastFastLeave)
 	if err != nil {
 		return err
 	}
+	log.Debug("attached container to bridge", "host", hostInterface.Name, "container", containerInterface.Name)
 
 	// Assume L2 interface only
 	result := &current.Result{
@@ -579,7 +813,8 @@ func cmdAdd(args *skel.CmdArgs) error {
 		},
 	}
 
-	if n.MacSpoofChk {
+	// spoofCheck covers the MAC too, once the IPs are known
+	if n.MacSpoofChk && !n.SpoofCheck {
 		sc := link.NewSpoofChecker(hostInterface.Name, containerInterface.Mac, uniqueID(args.ContainerID, args.IfName))
 		if err := sc.Setup(); err != nil {
 			return err
@@ -620,6 +855,22 @@ func cmdAdd(args *skel.CmdArgs) error {
 		if len(result.IPs) == 0 {
 			return errors.New("IPAM plugin returned missing IP config")
 		}
+		log.Debug("IPAM allocated addresses", "ipam", n.IPAM.Type, "ips", result.IPs)
+
+		if n.SpoofCheck {
+			sc := link.NewSpoofChecker(hostInterface.Name, containerInterface.Mac, uniqueID(args.ContainerID, args.IfName)).
+				WithIPCheck(resultIPs(result))
+			if err := sc.Setup(); err != nil {
+				return err
+			}
+			defer func() {
+				if !success {
+					if err := sc.Teardown(); err != nil {
+						fmt.Fprintf(os.Stderr, "%v", err)
+					}
+				}
+			}()
+		}
 
 		// Gather gateway information for each IP family
 		gwsV4, gwsV6, err := calcGateways(result, n)
@@ -627,7 +878,8 @@ func cmdAdd(args *skel.CmdArgs) error {
 			return err
 		}
 
-		// Configure the container hardware address and IP address(es)
+		// Configure the container interface and its IP address(es), all in
+		// one go as this is the only time the container netns is entered
 		if err := netns.Do(func(_ ns.NetNS) error {
 			if n.EnableDad {
 				_, _ = sysctl.Sysctl(fmt.Sprintf("/net/ipv6/conf/%s/enhanced_dad", args.IfName), "1")
@@ -816,8 +1068,11 @@ func cmdDel(args *skel.CmdArgs) error {
 		return err
 	}
 
-	if n.MacSpoofChk {
+	if n.MacSpoofChk || n.SpoofCheck {
 		sc := link.NewSpoofChecker("", "", uniqueID(args.ContainerID, args.IfName))
+		if n.SpoofCheck {
+			sc.WithIPCheck(nil)
+		}
 		if err := sc.Teardown(); err != nil {
 			fmt.Fprintf(os.Stderr, "%v", err)
 		}
@@ -833,13 +1088,13 @@ func cmdDel(args *skel.CmdArgs) error {
 }
 
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
+	skel.PluginMainFuncs(log.Wrap("bridge", trace.Wrap("bridge", journal.Wrap("bridge", ip.ReconcileJournal, skel.CNIFuncs{
 		Add:    cmdAdd,
 		Check:  cmdCheck,
 		Del:    cmdDel,
+		GC:     cmdGC,
 		Status: cmdStatus,
-		/* FIXME GC */
-	}, version.All, bv.BuildString("bridge"))
+	}))), version.All, bv.BuildString("bridge"))
 }
 
 type cniBridgeIf struct {
@@ -897,6 +1152,14 @@ func validateCniBrInterface(intf current.Interface, n *NetConf) (cniBridgeIf, er
 			intf.Name, n.PromiscMode, linkPromisc)
 	}
 
+	if err := checkBridgeSTP(link, n.bridgeSTP()); err != nil {
+		return brFound, err
+	}
+
+	if err := checkBridgeNetfilter(link, n.Netfilter); err != nil {
+		return brFound, err
+	}
+
 	brFound.found = true
 	brFound.Name = link.Attrs().Name
 	brFound.ifIndex = link.Attrs().Index
@@ -983,7 +1246,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 	}
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
@@ -1007,6 +1270,12 @@ func cmdCheck(args *skel.CmdArgs) error {
 		return err
 	}
 
+	if n.BrLabel != "" {
+		if _, err := resolveBridgeLabel(n); err != nil {
+			return err
+		}
+	}
+
 	var errLink error
 	var contCNI, vethCNI cniBridgeIf
 	var brMap, contMap current.Interface
@@ -1082,6 +1351,14 @@ func cmdCheck(args *skel.CmdArgs) error {
 		return fmt.Errorf("CNI veth created for bridge %s was not found", n.BrName)
 	}
 
+	if n.SpoofCheck {
+		sc := link.NewSpoofChecker(vethCNI.Name, contMap.Mac, uniqueID(args.ContainerID, args.IfName)).
+			WithIPCheck(resultIPs(result))
+		if err := sc.Check(); err != nil {
+			return err
+		}
+	}
+
 	// Check prevResults for ips, routes and dns against values found in the container
 	return netns.Do(func(_ ns.NetNS) error {
 		err = ip.ValidateExpectedInterfaceIPs(args.IfName, result.IPs)
@@ -1101,12 +1378,40 @@ func uniqueID(containerID, cniIface string) string {
 	return containerID + "-" + cniIface
 }
 
+func resultIPs(result *current.Result) []net.IP {
+	ips := make([]net.IP, 0, len(result.IPs))
+	for _, ipc := range result.IPs {
+		ips = append(ips, ipc.Address.IP)
+	}
+	return ips
+}
+
+// cmdGC releases the uplink once the runtime has no attachments left on the
+// network.
+func cmdGC(args *skel.CmdArgs) error {
+	n, _, err := loadNetConf(args.StdinData, "")
+	if err != nil {
+		return err
+	}
+	if n.Uplink == "" || len(n.ValidAttachments) > 0 {
+		return nil
+	}
+	return releaseUplink(n)
+}
+
 func cmdStatus(args *skel.CmdArgs) error {
 	conf := NetConf{}
 	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
 		return fmt.Errorf("failed to load netconf: %w", err)
 	}
 
+	if err := status.Netlink(); err != nil {
+		return err
+	}
+	if err := status.KernelModules("bridge", "veth"); err != nil {
+		return err
+	}
+
 	if conf.IPAM.Type != "" {
 		if err := ipam.ExecStatus(conf.IPAM.Type, args.StdinData); err != nil {
 			return err
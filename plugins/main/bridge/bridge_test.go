@@ -15,15 +15,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/networkplumbing/go-nft/nft"
+	"github.com/networkplumbing/go-nft/nft/schema"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/vishvananda/netlink"
@@ -82,8 +86,10 @@ type testCase struct {
 	ipMasq            bool
 	ipMasqBackend     string
 	macspoofchk       bool
+	spoofCheck        bool
 	disableContIface  bool
 	portIsolation     bool
+	fastLeave         bool
 
 	AddErr020 string
 	DelErr020 string
@@ -167,6 +173,9 @@ const (
 	portIsolation = `,
     "portIsolation": true`
 
+	multicastFastLeave = `,
+    "multicastFastLeave": true`
+
 	ipamStartStr = `,
     "ipam": {
         "type":    "host-local"`
@@ -210,6 +219,9 @@ const (
 	macspoofchkFormat = `,
         "macspoofchk": %t`
 
+	spoofCheckFormat = `,
+        "spoofCheck": %t`
+
 	argsFormat = `,
     "args": {
         "cni": {
@@ -266,6 +278,9 @@ func (tc testCase) netConfJSON(dataDir string) string {
 	if tc.macspoofchk {
 		conf += fmt.Sprintf(macspoofchkFormat, tc.macspoofchk)
 	}
+	if tc.spoofCheck {
+		conf += fmt.Sprintf(spoofCheckFormat, tc.spoofCheck)
+	}
 
 	if tc.disableContIface {
 		conf += disableContainerInterface
@@ -275,6 +290,10 @@ func (tc testCase) netConfJSON(dataDir string) string {
 		conf += portIsolation
 	}
 
+	if tc.fastLeave {
+		conf += multicastFastLeave
+	}
+
 	if !tc.isLayer2 {
 		conf += netDefault
 		if tc.subnet != "" || tc.ranges != nil {
@@ -661,6 +680,7 @@ func (tester *testerV10x) cmdAddTest(tc testCase, dataDir string) (types.Result,
 		protInfo, err := netlinksafe.LinkGetProtinfo(link)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(protInfo.Isolated).To(Equal(tc.portIsolation), "link isolation should be on when portIsolation is set")
+		Expect(protInfo.FastLeave).To(Equal(tc.fastLeave), "fast-leave should be on when multicastFastLeave is set")
 
 		// check vlan exist on the veth interface
 		if tc.vlan != 0 {
@@ -2304,6 +2324,67 @@ var _ = Describe("bridge Operations", func() {
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		It(fmt.Sprintf("[%s] selects a pre-created bridge by label", ver), func() {
+			conf := &NetConf{
+				NetConf: types.NetConf{
+					CNIVersion: ver,
+					Name:       "testConfig",
+					Type:       "bridge",
+				},
+				BrLabel: "tenant-a",
+				MTU:     1400,
+			}
+
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				linkAttrs := netlink.NewLinkAttrs()
+				linkAttrs.Name = "br-rotated"
+				linkAttrs.MTU = 1400
+				Expect(netlink.LinkAdd(&netlink.Bridge{LinkAttrs: linkAttrs})).To(Succeed())
+				link, err := netlinksafe.LinkByName("br-rotated")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetAlias(link, "tenant-a")).To(Succeed())
+
+				br, _, err := setupBridge(conf)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(br.Attrs().Name).To(Equal("br-rotated"))
+				Expect(conf.BrName).To(Equal("br-rotated"))
+
+				// The bridge is validated, not reconfigured
+				conf.MTU = 1500
+				_, _, err = setupBridge(conf)
+				Expect(err).To(MatchError(`bridge "br-rotated" with label "tenant-a" has MTU 1400, expected 1500`))
+
+				// The MTU of mtuFrom is validated too
+				conf.MTUFrom = "lo"
+				_, _, err = setupBridge(conf)
+				Expect(err).To(MatchError(`bridge "br-rotated" with label "tenant-a" has MTU 1400, expected 65536`))
+
+				conf.MTU = 0
+				conf.MTUFrom = ""
+				conf.PromiscMode = true
+				_, _, err = setupBridge(conf)
+				Expect(err).To(MatchError(`bridge "br-rotated" with label "tenant-a" is not in promiscuous mode`))
+				link, err = netlinksafe.LinkByName("br-rotated")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.Attrs().MTU).To(Equal(1400))
+				Expect(link.Attrs().Promisc).To(Equal(0))
+
+				conf.PromiscMode = false
+				conf.Vlan = 100
+				_, _, err = setupBridge(conf)
+				Expect(err).To(MatchError(`bridge "br-rotated" with label "tenant-a" does not have VLAN filtering enabled`))
+
+				conf.BrLabel = "tenant-b"
+				_, _, err = setupBridge(conf)
+				Expect(err).To(MatchError(`no bridge with label "tenant-b" found`))
+
+				return netlink.LinkDel(link)
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
 		for i, tc := range []testCase{
 			{
 				subnet: "10.1.2.0/24",
@@ -2572,6 +2653,42 @@ var _ = Describe("bridge Operations", func() {
 			})).To(Succeed())
 		})
 
+		It(fmt.Sprintf("[%s] configures spoof-check of the MAC and IPs", ver), func() {
+			Expect(originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				tc := testCase{
+					cniVersion: ver,
+					subnet:     "10.1.2.0/24",
+					spoofCheck: true,
+				}
+				args := tc.createCmdArgs(originalNS, dataDir)
+				_, _, err := testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				for chain, rules := range map[string]int{
+					"cni-br-iface-dummy-0-eth0":     2,
+					"cni-br-iface-dummy-0-eth0-mac": 2,
+					"cni-br-iface-dummy-0-eth0-ip":  8,
+				} {
+					Expect(spoofCheckChainRules(chain)).To(HaveLen(rules))
+				}
+
+				Expect(testutils.CmdDelWithArgs(args, func() error {
+					if err := cmdDel(args); err != nil {
+						return err
+					}
+					assertMacSpoofCheckRulesMissing()
+					Expect(spoofCheckChainRules("cni-br-iface-dummy-0-eth0-ip")).To(BeEmpty())
+					return nil
+				})).To(Succeed())
+
+				return nil
+			})).To(Succeed())
+		})
+
 		It(fmt.Sprintf("[%s] should fail when both IPAM and DisableContainerInterface are set", ver), func() {
 			Expect(originalNS.Do(func(ns.NetNS) error {
 				defer GinkgoRecover()
@@ -2631,6 +2748,21 @@ var _ = Describe("bridge Operations", func() {
 				return nil
 			})).To(Succeed())
 		})
+
+		It(fmt.Sprintf("[%s] when multicastFastLeave is on, should set fast-leave on the veth peer on node", ver), func() {
+			Expect(originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				tc := testCase{
+					cniVersion: ver,
+					fastLeave:  true,
+					isLayer2:   true,
+					AddErr020:  "cannot convert: no valid IP addresses",
+					AddErr010:  "cannot convert: no valid IP addresses",
+				}
+				cmdAddDelTest(originalNS, targetNS, tc, dataDir)
+				return nil
+			})).To(Succeed())
+		})
 	}
 
 	It("check vlan id when loading net conf", func() {
@@ -2666,6 +2798,300 @@ var _ = Describe("bridge Operations", func() {
 			}
 		}
 	})
+
+	It("refuses a MAC with deterministicMac", func() {
+		_, _, err := loadNetConf([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "testConfig",
+			"type": "bridge",
+			"deterministicMac": true,
+			"runtimeConfig": {"mac": "02:00:00:00:00:aa"}
+		}`), "")
+		Expect(err).To(MatchError("cannot set mac and deterministicMac at the same time"))
+	})
+
+	It("takes the port VLANs from runtimeConfig", func() {
+		n, _, err := loadNetConf([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "testConfig",
+			"type": "bridge",
+			"vlan": 10,
+			"runtimeConfig": {
+				"vlan": {
+					"pvid": 100,
+					"trunk": [{"minID": 200, "maxID": 202}, {"id": 300}]
+				}
+			}
+		}`), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n.Vlan).To(Equal(100))
+		Expect(n.vlans).To(Equal([]int{200, 201, 202, 300}))
+		Expect(n.vlanFiltering()).To(BeTrue())
+
+		_, _, err = loadNetConf([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "testConfig",
+			"type": "bridge",
+			"runtimeConfig": {"vlan": {"pvid": 5000}}
+		}`), "")
+		Expect(err).To(MatchError("invalid PVID 5000 (must be between 0 and 4094)"))
+	})
+
+	It("derives and tracks the bridge MTU from an uplink", func() {
+		Expect(originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			linkAttrs := netlink.NewLinkAttrs()
+			linkAttrs.Name = "uplink0"
+			linkAttrs.MTU = 1400
+			Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: linkAttrs, PeerName: "uplink0p"})).To(Succeed())
+
+			n, _, err := loadNetConf([]byte(`{
+				"cniVersion": "1.0.0",
+				"name": "testConfig",
+				"type": "bridge",
+				"bridge": "cni-mtu",
+				"mtuFrom": "uplink0"
+			}`), "")
+			Expect(err).NotTo(HaveOccurred())
+			br, _, err := setupBridge(n)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(br.MTU).To(Equal(1400))
+
+			uplink, err := netlinksafe.LinkByName("uplink0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMTU(uplink, 1300)).To(Succeed())
+
+			n.MTU = 0
+			br, _, err = setupBridge(n)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(br.MTU).To(Equal(1300))
+			link, err := netlinksafe.LinkByName("cni-mtu")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().MTU).To(Equal(1300))
+			return nil
+		})).To(Succeed())
+
+		_, _, err := loadNetConf([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "testConfig",
+			"type": "bridge",
+			"mtu": 1500,
+			"mtuFrom": "uplink0"
+		}`), "")
+		Expect(err).To(MatchError("cannot set mtu and mtuFrom at the same time"))
+	})
+
+	It("configures STP on a new bridge and checks it", func() {
+		Expect(originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			n, _, err := loadNetConf([]byte(`{
+				"cniVersion": "1.0.0",
+				"name": "testConfig",
+				"type": "bridge",
+				"bridge": "cni-stp",
+				"stp": true,
+				"bridgePriority": 4096,
+				"forwardDelay": 400,
+				"helloTime": 100
+			}`), "")
+			Expect(err).NotTo(HaveOccurred())
+			br, brIf, err := setupBridge(n)
+			Expect(err).NotTo(HaveOccurred())
+
+			stp, err := getBridgeSTP(br)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*stp.Enabled).To(BeTrue())
+			Expect(*stp.Priority).To(Equal(uint16(4096)))
+			Expect(*stp.ForwardDelay).To(Equal(uint32(400)))
+			Expect(*stp.HelloTime).To(Equal(uint32(100)))
+
+			_, err = validateCniBrInterface(*brIf, n)
+			Expect(err).NotTo(HaveOccurred())
+
+			// An existing bridge is not reconfigured, and CHECK notices
+			priority := uint16(8192)
+			n.BridgePriority = &priority
+			_, _, err = setupBridge(n)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = validateCniBrInterface(*brIf, n)
+			Expect(err).To(MatchError("Bridge interface cni-stp configured priority 8192 doesn't match current state"))
+			return nil
+		})).To(Succeed())
+	})
+
+	It("sets br_netfilter calls per bridge and checks them", func() {
+		Expect(originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			n, _, err := loadNetConf([]byte(`{
+				"cniVersion": "1.0.0",
+				"name": "testConfig",
+				"type": "bridge",
+				"bridge": "cni-nf",
+				"brNetfilter": {"callIptables": true, "callIp6tables": false}
+			}`), "")
+			Expect(err).NotTo(HaveOccurred())
+			_, brIf, err := setupBridge(n)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = validateCniBrInterface(*brIf, n)
+			Expect(err).NotTo(HaveOccurred())
+
+			// The settings follow the network on an existing bridge
+			enabled := true
+			n.Netfilter.CallIP6Tables = &enabled
+			_, err = validateCniBrInterface(*brIf, n)
+			Expect(err).To(MatchError("Bridge interface cni-nf configured callIp6tables true doesn't match current state"))
+			_, _, err = setupBridge(n)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = validateCniBrInterface(*brIf, n)
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})).To(Succeed())
+	})
+
+	It("validates and canonicalizes a pinned MAC address", func() {
+		n, _, err := loadNetConf([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "testConfig",
+			"type": "bridge",
+			"runtimeConfig": {"mac": "02:AB:CD:00:00:01"}
+		}`), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n.mac).To(Equal("02:ab:cd:00:00:01"))
+
+		for mac, msg := range map[string]string{
+			"not-a-mac":               "invalid MAC address \"not-a-mac\": address not-a-mac: invalid MAC address",
+			"01:00:5e:00:00:01":       "invalid MAC address \"01:00:5e:00:00:01\": not a unicast address",
+			"00:00:00:00:00:00":       "invalid MAC address \"00:00:00:00:00:00\": all zeros",
+			"02:00:00:00:00:00:00:01": "invalid MAC address \"02:00:00:00:00:00:00:01\": not an Ethernet address",
+		} {
+			_, _, err := loadNetConf([]byte(`{
+				"cniVersion": "1.0.0",
+				"name": "testConfig",
+				"type": "bridge"
+			}`), "MAC="+mac)
+			Expect(err).To(MatchError(msg))
+		}
+	})
+
+	It("refuses a MAC address already in use on the bridge", func() {
+		Expect(originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			n, _, err := loadNetConf([]byte(`{
+				"cniVersion": "1.0.0",
+				"name": "testConfig",
+				"type": "bridge",
+				"bridge": "cni-mac"
+			}`), "")
+			Expect(err).NotTo(HaveOccurred())
+			br, _, err := setupBridge(n)
+			Expect(err).NotTo(HaveOccurred())
+
+			mac, err := net.ParseMAC("02:00:00:00:0a:01")
+			Expect(err).NotTo(HaveOccurred())
+			linkAttrs := netlink.NewLinkAttrs()
+			linkAttrs.Name = "port0"
+			linkAttrs.HardwareAddr = mac
+			linkAttrs.MasterIndex = br.Index
+			Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: linkAttrs, PeerName: "port0p"})).To(Succeed())
+
+			Expect(checkMacConflict(br, "02:00:00:00:0a:01")).To(MatchError(
+				"MAC address 02:00:00:00:0a:01 is already in use by port port0 of bridge cni-mac"))
+			Expect(checkMacConflict(br, br.HardwareAddr.String())).To(MatchError(
+				"MAC address " + br.HardwareAddr.String() + " is already in use by bridge cni-mac"))
+
+			port, err := netlinksafe.LinkByName("port0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.NeighAppend(&netlink.Neigh{
+				LinkIndex:    port.Attrs().Index,
+				Family:       syscall.AF_BRIDGE,
+				State:        netlink.NUD_NOARP,
+				Flags:        netlink.NTF_MASTER,
+				HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0x0a, 0x02},
+			})).To(Succeed())
+			Expect(checkMacConflict(br, "02:00:00:00:0a:02")).To(MatchError(
+				"MAC address 02:00:00:00:0a:02 is already in use behind port port0 of bridge cni-mac"))
+			Expect(checkMacConflict(br, "02:00:00:00:0a:03")).To(Succeed())
+			return nil
+		})).To(Succeed())
+	})
+
+	It("attaches an uplink and releases it once no attachments are left", func() {
+		dataDir := GinkgoT().TempDir()
+		Expect(originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			linkAttrs := netlink.NewLinkAttrs()
+			linkAttrs.Name = "nic0"
+			Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: linkAttrs, PeerName: "nic0p"})).To(Succeed())
+
+			conf := func(validAttachments string) []byte {
+				return []byte(fmt.Sprintf(`{
+					"cniVersion": "1.1.0",
+					"name": "testConfig",
+					"type": "bridge",
+					"bridge": "cni-uplink",
+					"uplink": "nic0.100",
+					"dataDir": %q,
+					"cni.dev/valid-attachments": %s
+				}`, dataDir, validAttachments))
+			}
+			n, _, err := loadNetConf(conf("[]"), "")
+			Expect(err).NotTo(HaveOccurred())
+			br, _, err := setupBridge(n)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ensureUplink(br, n)).To(Succeed())
+			uplink, err := netlinksafe.LinkByName("nic0.100")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(uplink.Type()).To(Equal("vlan"))
+			Expect(uplink.Attrs().MasterIndex).To(Equal(br.Index))
+			// and again for the next attachment
+			Expect(ensureUplink(br, n)).To(Succeed())
+
+			Expect(cmdGC(&skel.CmdArgs{StdinData: conf(`[{"containerID": "c1", "ifname": "eth0"}]`)})).To(Succeed())
+			_, err = netlinksafe.LinkByName("nic0.100")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(cmdGC(&skel.CmdArgs{StdinData: conf("[]")})).To(Succeed())
+			_, err = netlinksafe.LinkByName("nic0.100")
+			Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}))
+			_, err = os.Stat(filepath.Join(dataDir, "testConfig", "uplink"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			// An uplink the plugin did not create is only detached
+			n.Uplink = "nic0"
+			Expect(ensureUplink(br, n)).To(Succeed())
+			Expect(cmdGC(&skel.CmdArgs{StdinData: bytes.Replace(conf("[]"), []byte(`"nic0.100"`), []byte(`"nic0"`), 1)})).To(Succeed())
+			uplink, err = netlinksafe.LinkByName("nic0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(uplink.Attrs().MasterIndex).To(BeZero())
+			return nil
+		})).To(Succeed())
+	})
+
+	It("rejects setting both bridge and bridgeLabel", func() {
+		_, _, err := loadNetConf([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "testConfig",
+			"type": "bridge",
+			"bridge": "cni0",
+			"bridgeLabel": "tenant-a"
+		}`), "")
+		Expect(err).To(MatchError("cannot set bridge and bridgeLabel at the same time"))
+
+		n, _, err := loadNetConf([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "testConfig",
+			"type": "bridge",
+			"bridgeLabel": "tenant-a"
+		}`), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n.BrName).To(BeEmpty())
+	})
 })
 
 func assertMacSpoofCheckRulesExist() {
@@ -2682,6 +3108,19 @@ func assertMacSpoofCheckRulesMissing() {
 		})
 }
 
+func spoofCheckChainRules(chain string) []*schema.Rule {
+	c, err := nft.ReadConfig()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	expectedTable := nft.NewTable("nat", "bridge")
+	return c.LookupRule(nft.NewRule(
+		expectedTable,
+		nft.NewRegularChain(expectedTable, chain),
+		nil, nil, nil,
+		"macspoofchk-dummy-0-eth0",
+	))
+}
+
 func assertMacSpoofCheckRules(assert func(actual interface{}, expectedLen int)) {
 	c, err := nft.ReadConfig()
 	ExpectWithOffset(2, err).NotTo(HaveOccurred())
@@ -0,0 +1,86 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// BridgeNetfilter selects whether br_netfilter passes the bridged traffic of
+// this network's bridge to iptables and ip6tables, so that networks on the
+// same node need not agree on the global net.bridge.bridge-nf-call-*
+// sysctls. The kernel ORs the bridge setting with the global one, so
+// disabling it only has an effect while the sysctl is 0.
+type BridgeNetfilter struct {
+	CallIPTables  *bool `json:"callIptables,omitempty"`
+	CallIP6Tables *bool `json:"callIp6tables,omitempty"`
+}
+
+func boolAttr(b bool) []byte {
+	if b {
+		return nl.Uint8Attr(1)
+	}
+	return nl.Uint8Attr(0)
+}
+
+// setBridgeNetfilter applies the nf_call settings of nf to the bridge.
+func setBridgeNetfilter(br netlink.Link, nf *BridgeNetfilter) error {
+	if nf == nil || (nf.CallIPTables == nil && nf.CallIP6Tables == nil) {
+		return nil
+	}
+
+	err := setBridgeInfoData(br, func(data *nl.RtAttr) {
+		if nf.CallIPTables != nil {
+			data.AddRtAttr(nl.IFLA_BR_NF_CALL_IPTABLES, boolAttr(*nf.CallIPTables))
+		}
+		if nf.CallIP6Tables != nil {
+			data.AddRtAttr(nl.IFLA_BR_NF_CALL_IP6TABLES, boolAttr(*nf.CallIP6Tables))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set netfilter settings of bridge %q: %v", br.Attrs().Name, err)
+	}
+	return nil
+}
+
+// checkBridgeNetfilter verifies the nf_call settings of nf that are set.
+func checkBridgeNetfilter(br netlink.Link, nf *BridgeNetfilter) error {
+	if nf == nil || (nf.CallIPTables == nil && nf.CallIP6Tables == nil) {
+		return nil
+	}
+
+	data, err := getBridgeInfoData(br)
+	if err != nil {
+		return err
+	}
+	have := map[uint16]bool{}
+	for _, d := range data {
+		if len(d.Value) > 0 {
+			have[d.Attr.Type] = d.Value[0] != 0
+		}
+	}
+
+	name := br.Attrs().Name
+	if nf.CallIPTables != nil && have[nl.IFLA_BR_NF_CALL_IPTABLES] != *nf.CallIPTables {
+		return fmt.Errorf("Bridge interface %s configured callIptables %v doesn't match current state", name, *nf.CallIPTables)
+	}
+	if nf.CallIP6Tables != nil && have[nl.IFLA_BR_NF_CALL_IP6TABLES] != *nf.CallIP6Tables {
+		return fmt.Errorf("Bridge interface %s configured callIp6tables %v doesn't match current state", name, *nf.CallIP6Tables)
+	}
+	return nil
+}
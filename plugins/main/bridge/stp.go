@@ -0,0 +1,131 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// bridgeSTP are the spanning tree settings of a bridge. Unset fields are
+// left alone. Times are in centiseconds, as with ip-link(8).
+type bridgeSTP struct {
+	Enabled      *bool
+	Priority     *uint16
+	ForwardDelay *uint32
+	HelloTime    *uint32
+}
+
+func (n *NetConf) bridgeSTP() bridgeSTP {
+	return bridgeSTP{
+		Enabled:      n.STP,
+		Priority:     n.BridgePriority,
+		ForwardDelay: n.ForwardDelay,
+		HelloTime:    n.HelloTime,
+	}
+}
+
+func (s bridgeSTP) empty() bool {
+	return s == bridgeSTP{}
+}
+
+// setBridgeSTP applies s to the bridge.
+func setBridgeSTP(br netlink.Link, s bridgeSTP) error {
+	if s.empty() {
+		return nil
+	}
+
+	err := setBridgeInfoData(br, func(data *nl.RtAttr) {
+		// The times must be set before STP is enabled, as the kernel checks
+		// them against the STP limits.
+		if s.ForwardDelay != nil {
+			data.AddRtAttr(nl.IFLA_BR_FORWARD_DELAY, nl.Uint32Attr(*s.ForwardDelay))
+		}
+		if s.HelloTime != nil {
+			data.AddRtAttr(nl.IFLA_BR_HELLO_TIME, nl.Uint32Attr(*s.HelloTime))
+		}
+		if s.Priority != nil {
+			data.AddRtAttr(nl.IFLA_BR_PRIORITY, nl.Uint16Attr(*s.Priority))
+		}
+		if s.Enabled != nil {
+			state := uint32(0)
+			if *s.Enabled {
+				state = 1
+			}
+			data.AddRtAttr(nl.IFLA_BR_STP_STATE, nl.Uint32Attr(state))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set STP settings of bridge %q: %v", br.Attrs().Name, err)
+	}
+	return nil
+}
+
+// getBridgeSTP reads the spanning tree settings of the bridge.
+func getBridgeSTP(br netlink.Link) (bridgeSTP, error) {
+	s := bridgeSTP{}
+
+	data, err := getBridgeInfoData(br)
+	if err != nil {
+		return s, err
+	}
+
+	native := nl.NativeEndian()
+	for _, d := range data {
+		switch d.Attr.Type {
+		case nl.IFLA_BR_STP_STATE:
+			enabled := native.Uint32(d.Value) != 0
+			s.Enabled = &enabled
+		case nl.IFLA_BR_PRIORITY:
+			priority := native.Uint16(d.Value)
+			s.Priority = &priority
+		case nl.IFLA_BR_FORWARD_DELAY:
+			delay := native.Uint32(d.Value)
+			s.ForwardDelay = &delay
+		case nl.IFLA_BR_HELLO_TIME:
+			hello := native.Uint32(d.Value)
+			s.HelloTime = &hello
+		}
+	}
+	return s, nil
+}
+
+// checkBridgeSTP verifies the settings of want that are set.
+func checkBridgeSTP(br netlink.Link, want bridgeSTP) error {
+	if want.empty() {
+		return nil
+	}
+	have, err := getBridgeSTP(br)
+	if err != nil {
+		return err
+	}
+
+	name := br.Attrs().Name
+	if want.Enabled != nil && (have.Enabled == nil || *have.Enabled != *want.Enabled) {
+		return fmt.Errorf("Bridge interface %s configured STP %v doesn't match current state", name, *want.Enabled)
+	}
+	if want.Priority != nil && (have.Priority == nil || *have.Priority != *want.Priority) {
+		return fmt.Errorf("Bridge interface %s configured priority %d doesn't match current state", name, *want.Priority)
+	}
+	if want.ForwardDelay != nil && (have.ForwardDelay == nil || *have.ForwardDelay != *want.ForwardDelay) {
+		return fmt.Errorf("Bridge interface %s configured forward delay %d doesn't match current state", name, *want.ForwardDelay)
+	}
+	if want.HelloTime != nil && (have.HelloTime == nil || *have.HelloTime != *want.HelloTime) {
+		return fmt.Errorf("Bridge interface %s configured hello time %d doesn't match current state", name, *want.HelloTime)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+)
+
+// The uplink enslaved by the plugin is recorded under <dataDir>/<network
+// name>, so that GC only releases an uplink the plugin attached itself, and
+// only deletes a VLAN subinterface the plugin created.
+var defaultDataDir = "/var/lib/cni/bridge"
+
+type uplinkRecord struct {
+	Uplink  string `json:"uplink"`
+	Bridge  string `json:"bridge"`
+	Created bool   `json:"created,omitempty"`
+}
+
+func uplinkRecordPath(n *NetConf) string {
+	dataDir := n.DataDir
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+	return filepath.Join(dataDir, n.Name, "uplink")
+}
+
+func saveUplinkRecord(n *NetConf, record *uplinkRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	path := uplinkRecordPath(n)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create bridge data directory: %v", err)
+	}
+	// Write then rename, so that a crash never leaves a torn record
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("failed to record uplink: %v", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func loadUplinkRecord(n *NetConf) (*uplinkRecord, error) {
+	data, err := os.ReadFile(uplinkRecordPath(n))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	record := &uplinkRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("failed to parse uplink record: %v", err)
+	}
+	return record, nil
+}
+
+// parseVlanUplink splits a VLAN subinterface name such as "eth0.100" into
+// its parent and VLAN ID.
+func parseVlanUplink(name string) (string, int, bool) {
+	i := strings.LastIndex(name, ".")
+	if i <= 0 {
+		return "", 0, false
+	}
+	vlanID, err := strconv.Atoi(name[i+1:])
+	if err != nil || vlanID < 1 || vlanID > 4094 {
+		return "", 0, false
+	}
+	return name[:i], vlanID, true
+}
+
+// ensureUplink attaches n.Uplink to the bridge, creating it first if it is a
+// missing VLAN subinterface of an existing NIC.
+func ensureUplink(br *netlink.Bridge, n *NetConf) error {
+	created := false
+	uplink, err := netlinksafe.LinkByName(n.Uplink)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return fmt.Errorf("failed to lookup uplink %q: %v", n.Uplink, err)
+		}
+		parentName, vlanID, ok := parseVlanUplink(n.Uplink)
+		if !ok {
+			return fmt.Errorf("uplink %q not found", n.Uplink)
+		}
+		parent, err := netlinksafe.LinkByName(parentName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup parent %q of uplink %q: %v", parentName, n.Uplink, err)
+		}
+
+		linkAttrs := netlink.NewLinkAttrs()
+		linkAttrs.Name = n.Uplink
+		linkAttrs.ParentIndex = parent.Attrs().Index
+		if err := netlink.LinkAdd(&netlink.Vlan{LinkAttrs: linkAttrs, VlanId: vlanID}); err != nil {
+			return fmt.Errorf("failed to create uplink %q: %v", n.Uplink, err)
+		}
+		created = true
+		if uplink, err = netlinksafe.LinkByName(n.Uplink); err != nil {
+			return fmt.Errorf("failed to lookup uplink %q: %v", n.Uplink, err)
+		}
+	}
+
+	if uplink.Attrs().MasterIndex == br.Index {
+		return nil
+	}
+	if uplink.Attrs().MasterIndex != 0 {
+		return fmt.Errorf("uplink %q is already enslaved to another device", n.Uplink)
+	}
+
+	// A previous ADD may have created the uplink but failed to attach it
+	if record, _ := loadUplinkRecord(n); record != nil && record.Uplink == n.Uplink {
+		created = created || record.Created
+	}
+
+	// Record before enslaving, so that GC can undo a half-done attach
+	if err := saveUplinkRecord(n, &uplinkRecord{Uplink: n.Uplink, Bridge: br.Name, Created: created}); err != nil {
+		return err
+	}
+	if err := netlink.LinkSetMaster(uplink, br); err != nil {
+		return fmt.Errorf("failed to attach uplink %q to bridge %q: %v", n.Uplink, br.Name, err)
+	}
+	if err := netlink.LinkSetUp(uplink); err != nil {
+		return fmt.Errorf("failed to set uplink %q up: %v", n.Uplink, err)
+	}
+	return nil
+}
+
+// releaseUplink detaches the uplink recorded for the network from its
+// bridge, and deletes it if the plugin created it.
+func releaseUplink(n *NetConf) error {
+	record, err := loadUplinkRecord(n)
+	if err != nil || record == nil {
+		return err
+	}
+
+	uplink, err := netlinksafe.LinkByName(record.Uplink)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return fmt.Errorf("failed to lookup uplink %q: %v", record.Uplink, err)
+		}
+	} else {
+		br, err := bridgeByName(record.Bridge)
+		if err == nil && uplink.Attrs().MasterIndex == br.Index {
+			if err := netlink.LinkSetNoMaster(uplink); err != nil {
+				return fmt.Errorf("failed to detach uplink %q from bridge %q: %v", record.Uplink, record.Bridge, err)
+			}
+		}
+		if record.Created {
+			if err := netlink.LinkDel(uplink); err != nil {
+				return fmt.Errorf("failed to delete uplink %q: %v", record.Uplink, err)
+			}
+		}
+	}
+
+	if err := os.Remove(uplinkRecordPath(n)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove uplink record: %v", err)
+	}
+	return nil
+}
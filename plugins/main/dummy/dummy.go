@@ -26,6 +26,7 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
@@ -33,20 +34,52 @@ import (
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
 
-func parseNetConf(bytes []byte) (*types.NetConf, error) {
-	conf := &types.NetConf{}
+type NetConf struct {
+	types.NetConf
+	MTU int    `json:"mtu,omitempty"`
+	Mac string `json:"mac,omitempty"`
+	// DeterministicMac derives the MAC from the container ID and interface
+	// name, so that it is stable across restarts of the same attachment.
+	DeterministicMac bool `json:"deterministicMac,omitempty"`
+	RuntimeConfig    struct {
+		Mac string `json:"mac,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+}
+
+func parseNetConf(bytes []byte) (*NetConf, error) {
+	conf := &NetConf{}
 	if err := json.Unmarshal(bytes, conf); err != nil {
 		return nil, fmt.Errorf("failed to parse network config: %v", err)
 	}
+	if conf.RuntimeConfig.Mac != "" {
+		conf.Mac = conf.RuntimeConfig.Mac
+	}
+	if conf.Mac != "" && conf.DeterministicMac {
+		return nil, errors.New("cannot set mac and deterministicMac at the same time")
+	}
+	if conf.MTU < 0 {
+		return nil, fmt.Errorf("invalid MTU %d", conf.MTU)
+	}
 	return conf, nil
 }
 
-func createDummy(ifName string, netns ns.NetNS) (*current.Interface, error) {
+func createDummy(conf *NetConf, containerID, ifName string, netns ns.NetNS) (*current.Interface, error) {
 	dummy := &current.Interface{}
 
 	linkAttrs := netlink.NewLinkAttrs()
 	linkAttrs.Name = ifName
 	linkAttrs.Namespace = netlink.NsFd(int(netns.Fd()))
+	linkAttrs.MTU = conf.MTU
+	switch {
+	case conf.Mac != "":
+		addr, err := net.ParseMAC(conf.Mac)
+		if err != nil {
+			return nil, fmt.Errorf("invalid args %v for MAC addr: %v", conf.Mac, err)
+		}
+		linkAttrs.HardwareAddr = addr
+	case conf.DeterministicMac:
+		linkAttrs.HardwareAddr = ip.StableMAC(containerID, ifName)
+	}
 
 	dm := &netlink.Dummy{
 		LinkAttrs: linkAttrs,
@@ -88,11 +121,11 @@ func cmdAdd(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
-	dummyInterface, err := createDummy(args.IfName, netns)
+	dummyInterface, err := createDummy(conf, args.ContainerID, args.IfName, netns)
 	if err != nil {
 		return err
 	}
@@ -202,7 +235,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
@@ -216,7 +249,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 		return fmt.Errorf("dummy: Required prevResult missing")
 	}
 
-	if err := version.ParsePrevResult(conf); err != nil {
+	if err := version.ParsePrevResult(&conf.NetConf); err != nil {
 		return err
 	}
 
@@ -247,7 +280,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 	// Check prevResults for ips, routes and dns against values found in the container
 	if err := netns.Do(func(_ ns.NetNS) error {
 		// Check interface against values found in the container
-		err := validateCniContainerInterface(contMap)
+		err := validateCniContainerInterface(contMap, conf.MTU)
 		if err != nil {
 			return err
 		}
@@ -256,6 +289,11 @@ func cmdCheck(args *skel.CmdArgs) error {
 		if err != nil {
 			return err
 		}
+
+		err = ip.ValidateExpectedRoute(result.Routes)
+		if err != nil {
+			return err
+		}
 		return nil
 	}); err != nil {
 		return err
@@ -264,7 +302,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 	return nil
 }
 
-func validateCniContainerInterface(intf current.Interface) error {
+func validateCniContainerInterface(intf current.Interface, mtu int) error {
 	var link netlink.Link
 	var err error
 
@@ -290,6 +328,10 @@ func validateCniContainerInterface(intf current.Interface) error {
 		}
 	}
 
+	if mtu != 0 && link.Attrs().MTU != mtu {
+		return fmt.Errorf("Interface %s MTU %d doesn't match configured MTU: %d", intf.Name, link.Attrs().MTU, mtu)
+	}
+
 	if link.Attrs().Flags&net.FlagUp != net.FlagUp {
 		return fmt.Errorf("Interface %s is down", intf.Name)
 	}
@@ -215,7 +215,7 @@ var _ = Describe("dummy Operations", func() {
 			err := originalNS.Do(func(ns.NetNS) error {
 				defer GinkgoRecover()
 
-				_, err := createDummy("foobar0", targetNS)
+				_, err := createDummy(&NetConf{}, "dummy", "foobar0", targetNS)
 				Expect(err).NotTo(HaveOccurred())
 				return nil
 			})
@@ -363,6 +363,86 @@ var _ = Describe("dummy Operations", func() {
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		It(fmt.Sprintf("[%s] configures the MAC, MTU and routes of a dummy link", ver), func() {
+			const IFNAME = "dummy0"
+
+			conf := fmt.Sprintf(`{
+			    "cniVersion": "%s",
+			    "name": "dummyTestv4",
+			    "type": "dummy",
+			    "mtu": 1400,
+			    "mac": "02:00:00:00:00:aa",
+			    "ipam": {
+					"type": "host-local",
+					"ranges": [
+						[{"subnet": "10.1.2.0/24"}],
+						[{"subnet": "fd00:1::/64"}]
+					],
+					"routes": [{"dst": "10.20.0.0/16", "gw": "10.1.2.1"}],
+					"dataDir": "%s"
+			    }
+			}`, ver, dataDir)
+
+			args := &skel.CmdArgs{
+				ContainerID: "contDummy",
+				Netns:       targetNS.Path(),
+				IfName:      IFNAME,
+				StdinData:   []byte(conf),
+			}
+
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				_, _, err := testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = targetNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				link, err := netlinksafe.LinkByName(IFNAME)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.Attrs().MTU).To(Equal(1400))
+				Expect(link.Attrs().HardwareAddr.String()).To(Equal("02:00:00:00:00:aa"))
+
+				addrs, err := netlinksafe.AddrList(link, syscall.AF_INET)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addrs).To(HaveLen(1))
+				addrs, err = netlinksafe.AddrList(link, syscall.AF_INET6)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addrs).To(ContainElement(WithTransform(func(a netlink.Addr) string { return a.IPNet.String() }, HavePrefix("fd00:1::"))))
+
+				_, dst, _ := net.ParseCIDR("10.20.0.0/16")
+				routes, err := netlinksafe.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Dst: dst}, netlink.RT_FILTER_DST)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(routes).To(HaveLen(1))
+				Expect(routes[0].Gw.String()).To(Equal("10.1.2.1"))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				return testutils.CmdDelWithArgs(args, func() error {
+					return cmdDel(args)
+				})
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It(fmt.Sprintf("[%s] refuses mac with deterministicMac", ver), func() {
+			_, err := parseNetConf([]byte(fmt.Sprintf(`{
+			    "cniVersion": "%s",
+			    "name": "mynet",
+			    "type": "dummy",
+			    "mac": "02:00:00:00:00:aa",
+			    "deterministicMac": true
+			}`, ver)))
+			Expect(err).To(MatchError("cannot set mac and deterministicMac at the same time"))
+		})
+
 		It(fmt.Sprintf("[%s] fails to create dummy link with no ipam", ver), func() {
 			var err error
 
@@ -0,0 +1,462 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This plugin gives the container the end of a GRE tunnel, either a layer 3
+// gre or a layer 2 gretap interface, over IPv4 or IPv6.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+const (
+	modeGRE    = "gre"
+	modeGRETap = "gretap"
+)
+
+type NetConf struct {
+	types.NetConf
+	// Mode is "gre" for a layer 3 tunnel, "gretap" for a layer 2 one
+	Mode   string `json:"mode,omitempty"`
+	Remote string `json:"remote"`
+	Local  string `json:"local,omitempty"`
+	// Device is the underlay device in the host
+	Device string  `json:"device,omitempty"`
+	Key    *uint32 `json:"key,omitempty"`
+	TTL    int     `json:"ttl,omitempty"`
+	TOS    int     `json:"tos,omitempty"`
+	MTU    int     `json:"mtu,omitempty"`
+
+	remote net.IP
+	local  net.IP
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func loadConf(bytes []byte) (*NetConf, error) {
+	n := &NetConf{
+		Mode: modeGRE,
+	}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+
+	if n.RawPrevResult != nil {
+		if err := version.ParsePrevResult(&n.NetConf); err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+		if _, err := current.NewResultFromResult(n.PrevResult); err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	if n.Mode != modeGRE && n.Mode != modeGRETap {
+		return nil, fmt.Errorf("invalid mode %q, must be %q or %q", n.Mode, modeGRE, modeGRETap)
+	}
+
+	n.remote = net.ParseIP(n.Remote)
+	if n.remote == nil || n.remote.IsUnspecified() {
+		return nil, fmt.Errorf("invalid remote %q", n.Remote)
+	}
+
+	// The unspecified local address lets the kernel pick it
+	isV4 := n.remote.To4() != nil
+	if n.Local == "" {
+		n.local = net.IPv6zero
+		if isV4 {
+			n.local = net.IPv4zero
+		}
+	} else {
+		n.local = net.ParseIP(n.Local)
+		if n.local == nil {
+			return nil, fmt.Errorf("invalid local %q", n.Local)
+		}
+		if (n.local.To4() != nil) != isV4 {
+			return nil, fmt.Errorf("local %s and remote %s are of different families", n.Local, n.Remote)
+		}
+	}
+
+	if n.TTL < 0 || n.TTL > 255 {
+		return nil, fmt.Errorf("invalid ttl %d", n.TTL)
+	}
+	if n.TOS < 0 || n.TOS > 255 {
+		return nil, fmt.Errorf("invalid tos %d", n.TOS)
+	}
+	if n.MTU < 0 {
+		return nil, fmt.Errorf("invalid MTU %d", n.MTU)
+	}
+
+	return n, nil
+}
+
+// linkType returns the kernel link type of the tunnel.
+func (n *NetConf) linkType() string {
+	if n.remote.To4() == nil {
+		return "ip6" + n.Mode
+	}
+	return n.Mode
+}
+
+// newLink returns the link of the configuration, with linkAttrs.
+func newLink(n *NetConf, linkAttrs netlink.LinkAttrs) (netlink.Link, error) {
+	linkAttrs.MTU = n.MTU
+
+	var device uint32
+	if n.Device != "" {
+		dev, err := netlinksafe.LinkByName(n.Device)
+		if err != nil {
+			return nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup device %q", n.Device)
+		}
+		device = uint32(dev.Attrs().Index)
+	}
+	var key uint32
+	if n.Key != nil {
+		key = *n.Key
+	}
+
+	// Path MTU discovery is enabled, as with ip-tunnel(8), which a fixed
+	// TTL requires
+	if n.Mode == modeGRETap {
+		return &netlink.Gretap{
+			LinkAttrs: linkAttrs,
+			Local:     n.local,
+			Remote:    n.remote,
+			IKey:      key,
+			OKey:      key,
+			Ttl:       uint8(n.TTL),
+			Tos:       uint8(n.TOS),
+			PMtuDisc:  1,
+			Link:      device,
+		}, nil
+	}
+	return &netlink.Gretun{
+		LinkAttrs: linkAttrs,
+		Local:     n.local,
+		Remote:    n.remote,
+		IKey:      key,
+		OKey:      key,
+		Ttl:       uint8(n.TTL),
+		Tos:       uint8(n.TOS),
+		PMtuDisc:  1,
+		Link:      device,
+	}, nil
+}
+
+// validateLink checks that link is the tunnel of the configuration.
+func validateLink(n *NetConf, link netlink.Link) error {
+	name := link.Attrs().Name
+	if link.Type() != n.linkType() {
+		return fmt.Errorf("interface %s is of type %s, expected %s", name, link.Type(), n.linkType())
+	}
+
+	var remote net.IP
+	var key uint32
+	var ttl uint8
+	switch l := link.(type) {
+	case *netlink.Gretun:
+		remote, key, ttl = l.Remote, l.OKey, l.Ttl
+	case *netlink.Gretap:
+		remote, key, ttl = l.Remote, l.OKey, l.Ttl
+	}
+
+	if !n.remote.Equal(remote) {
+		return fmt.Errorf("interface %s remote %s does not match expected value: %s", name, remote, n.remote)
+	}
+	var expectedKey uint32
+	if n.Key != nil {
+		expectedKey = *n.Key
+	}
+	if key != expectedKey {
+		return fmt.Errorf("interface %s key %d does not match expected value: %d", name, key, expectedKey)
+	}
+	if int(ttl) != n.TTL {
+		return fmt.Errorf("interface %s ttl %d does not match expected value: %d", name, ttl, n.TTL)
+	}
+	return nil
+}
+
+func createTunnel(n *NetConf, ifName string, netns ns.NetNS) (*current.Interface, error) {
+	// due to kernel bug we have to create with tmpname or it might
+	// collide with the name on the host and error out
+	tmpName, err := ip.RandomVethName()
+	if err != nil {
+		return nil, err
+	}
+
+	// The tunnel is created in the container namespace, but from the host
+	// one, whose routes carry the encapsulated packets
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = tmpName
+	linkAttrs.Namespace = netlink.NsFd(int(netns.Fd()))
+
+	link, err := newLink(n, linkAttrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := netlink.LinkAdd(link); err != nil {
+		return nil, fmt.Errorf("failed to create %s tunnel: %v", n.linkType(), err)
+	}
+
+	tunnel := &current.Interface{}
+	err = netns.Do(func(_ ns.NetNS) error {
+		err := ip.RenameLink(tmpName, ifName)
+		if err != nil {
+			_ = ip.DelLinkByName(tmpName)
+			return fmt.Errorf("failed to rename %s tunnel to %q: %v", n.linkType(), ifName, err)
+		}
+		tunnel.Name = ifName
+
+		// Re-fetch the tunnel to get all properties/attributes
+		contLink, err := netlinksafe.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to refetch %s tunnel %q: %v", n.linkType(), ifName, err)
+		}
+		// Only gretap interfaces have a MAC address
+		if n.Mode == modeGRETap {
+			tunnel.Mac = contLink.Attrs().HardwareAddr.String()
+		}
+		tunnel.Sandbox = netns.Path()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tunnel, nil
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	tunnelInterface, err := createTunnel(n, args.IfName, netns)
+	if err != nil {
+		return err
+	}
+
+	// Delete the tunnel if the rest of the setup fails
+	defer func() {
+		if err != nil {
+			_ = netns.Do(func(_ ns.NetNS) error {
+				return ip.DelLinkByName(args.IfName)
+			})
+		}
+	}()
+
+	var result *current.Result
+	// Configure iface from PrevResult if we have IPs and an IPAM
+	// block has not been configured
+	haveResult := false
+	if n.IPAM.Type == "" && n.PrevResult != nil {
+		result, err = current.NewResultFromResult(n.PrevResult)
+		if err != nil {
+			return err
+		}
+		if len(result.IPs) > 0 {
+			haveResult = true
+		}
+	}
+	if !haveResult {
+		if n.IPAM.Type == "" {
+			err = errors.New("no IPAM configured and no IPs in prevResult")
+			return err
+		}
+
+		// run the IPAM plugin and get back the config to apply
+		var r types.Result
+		r, err = ipam.ExecAdd(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+
+		// Invoke ipam del if err to avoid ip leak
+		defer func() {
+			if err != nil {
+				ipam.ExecDel(n.IPAM.Type, args.StdinData)
+			}
+		}()
+
+		// Convert whatever the IPAM result was into the current Result type
+		result, err = current.NewResultFromResult(r)
+		if err != nil {
+			return err
+		}
+
+		if len(result.IPs) == 0 {
+			err = errors.New("IPAM plugin returned missing IP config")
+			return err
+		}
+	}
+	for _, ipc := range result.IPs {
+		// All addresses belong to the tunnel
+		ipc.Interface = current.Int(0)
+	}
+
+	result.Interfaces = []*current.Interface{tunnelInterface}
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return ipam.ConfigureIface(args.IfName, result)
+	})
+	if err != nil {
+		return err
+	}
+
+	result.DNS = n.DNS
+
+	return types.PrintResult(result, n.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	// On chained invocation, IPAM block can be empty
+	if n.IPAM.Type != "" {
+		err = ipam.ExecDel(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Netns == "" {
+		return nil
+	}
+
+	// There is a netns so try to clean up. Delete can be called multiple times
+	// so don't return an error if the device is already removed.
+	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		if err := ip.DelLinkByName(args.IfName); err != nil {
+			if err != ip.ErrLinkNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if _, ok := err.(ns.NSPathNotExistErr); ok {
+		return nil
+	}
+	return err
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	if n.IPAM.Type != "" {
+		err = ipam.ExecCheck(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.PrevResult == nil {
+		return fmt.Errorf("Required prevResult missing")
+	}
+	result, err := current.NewResultFromResult(n.PrevResult)
+	if err != nil {
+		return err
+	}
+
+	var contMap current.Interface
+	for _, intf := range result.Interfaces {
+		if args.IfName == intf.Name && args.Netns == intf.Sandbox {
+			contMap = *intf
+		}
+	}
+
+	// The namespace must be the same as what was configured
+	if args.Netns != contMap.Sandbox {
+		return fmt.Errorf("Sandbox in prevResult %s doesn't match configured netns: %s",
+			contMap.Sandbox, args.Netns)
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		link, err := netlinksafe.LinkByName(args.IfName)
+		if err != nil {
+			return fmt.Errorf("Container Interface name in prevResult: %s not found", args.IfName)
+		}
+		if err := validateLink(n, link); err != nil {
+			return err
+		}
+
+		if err := ip.ValidateExpectedInterfaceIPs(args.IfName, result.IPs); err != nil {
+			return err
+		}
+
+		return ip.ValidateExpectedRoute(result.Routes)
+	})
+}
+
+func cmdStatus(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.IPAM.Type != "" {
+		if err := ipam.ExecStatus(n.IPAM.Type, args.StdinData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		Status: cmdStatus,
+	}, version.All, bv.BuildString("gre"))
+}
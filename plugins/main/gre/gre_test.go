@@ -0,0 +1,251 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+const ifname = "gre1"
+
+var _ = Describe("gre plugin", func() {
+	ipsOnly := `{"cniVersion": "1.0.0", "ips": [{"address": "10.1.2.3/24"}]}`
+
+	Describe("configuration", func() {
+		It("picks the tunnel type from the mode and the remote family", func() {
+			n, err := loadConf([]byte(`{"remote": "192.0.2.1"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.linkType()).To(Equal("gre"))
+			Expect(n.local.String()).To(Equal("0.0.0.0"))
+
+			n, err = loadConf([]byte(`{"mode": "gretap", "remote": "2001:db8::1", "local": "2001:db8::2"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.linkType()).To(Equal("ip6gretap"))
+			Expect(n.local.String()).To(Equal("2001:db8::2"))
+		})
+
+		It("rejects invalid configurations", func() {
+			for _, conf := range []string{
+				`{}`,
+				`{"remote": "foo"}`,
+				`{"remote": "0.0.0.0"}`,
+				`{"mode": "ipip", "remote": "192.0.2.1"}`,
+				`{"remote": "192.0.2.1", "local": "2001:db8::2"}`,
+				`{"remote": "192.0.2.1", "local": "foo"}`,
+				`{"remote": "192.0.2.1", "ttl": 256}`,
+				`{"remote": "192.0.2.1", "tos": -1}`,
+				`{"remote": "192.0.2.1", "key": -1}`,
+				`{"remote": "192.0.2.1", "mtu": -1}`,
+			} {
+				_, err := loadConf([]byte(conf))
+				Expect(err).To(HaveOccurred(), conf)
+			}
+		})
+	})
+
+	Describe("tunnels", func() {
+		var originalNS, targetNs ns.NetNS
+		var args *skel.CmdArgs
+
+		BeforeEach(func() {
+			var err error
+			originalNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			targetNs, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+
+			args = &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNs.Path(),
+				IfName:      ifname,
+			}
+		})
+
+		AfterEach(func() {
+			Expect(originalNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(originalNS)).To(Succeed())
+			Expect(targetNs.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(targetNs)).To(Succeed())
+		})
+
+		It("gives a gre tunnel to the container", func() {
+			extra := `"remote": "192.0.2.1", "key": 42, "ttl": 64, "tos": 16,`
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "gre",
+				%s
+				"prevResult": %s
+			}`, extra, ipsOnly))
+
+			var result *current.Result
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				r, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				if err != nil {
+					return err
+				}
+				result, err = current.GetResult(r)
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(1))
+			Expect(result.Interfaces[0].Name).To(Equal(ifname))
+			Expect(result.Interfaces[0].Sandbox).To(Equal(targetNs.Path()))
+
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				link, err := netlinksafe.LinkByName(ifname)
+				if err != nil {
+					return err
+				}
+				Expect(link).To(BeAssignableToTypeOf(&netlink.Gretun{}))
+				gre := link.(*netlink.Gretun)
+				Expect(gre.Remote.String()).To(Equal("192.0.2.1"))
+				Expect(gre.IKey).To(Equal(uint32(42)))
+				Expect(gre.OKey).To(Equal(uint32(42)))
+				Expect(gre.Ttl).To(Equal(uint8(64)))
+				Expect(gre.Tos).To(Equal(uint8(16)))
+
+				addrs, err := netlinksafe.AddrList(link, netlink.FAMILY_V4)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addrs).To(HaveLen(1))
+				Expect(addrs[0].IPNet.String()).To(Equal("10.1.2.3/24"))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			prevResult, err := json.Marshal(result)
+			Expect(err).NotTo(HaveOccurred())
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "gre",
+				%s
+				"prevResult": %s
+			}`, extra, string(prevResult)))
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// CHECK notices a different key
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "gre",
+				"remote": "192.0.2.1", "key": 43, "ttl": 64,
+				"prevResult": %s
+			}`, string(prevResult)))
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+			})
+			Expect(err).To(MatchError("interface gre1 key 42 does not match expected value: 43"))
+
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				_, err := netlinksafe.LinkByName(ifname)
+				return err
+			})
+			Expect(err).To(HaveOccurred())
+
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("gives an ip6gretap tunnel to the container", func() {
+			extra := `"mode": "gretap", "remote": "2001:db8::1",`
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "gre",
+				%s
+				"prevResult": %s
+			}`, extra, ipsOnly))
+
+			var result *current.Result
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				r, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				if err != nil {
+					return err
+				}
+				result, err = current.GetResult(r)
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				link, err := netlinksafe.LinkByName(ifname)
+				if err != nil {
+					return err
+				}
+				Expect(link.Type()).To(Equal("ip6gretap"))
+				Expect(link.Attrs().HardwareAddr.String()).To(Equal(result.Interfaces[0].Mac))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// CHECK notices a different type
+			prevResult, err := json.Marshal(result)
+			Expect(err).NotTo(HaveOccurred())
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "gre",
+				"remote": "2001:db8::1",
+				"prevResult": %s
+			}`, string(prevResult)))
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+			})
+			Expect(err).To(MatchError("interface gre1 is of type ip6gretap, expected ip6gre"))
+		})
+
+		It("removes the tunnel when the setup fails", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "gre",
+				"remote": "192.0.2.1",
+				"prevResult": {"cniVersion": "1.0.0"}
+			}`)
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).To(MatchError("no IPAM configured and no IPs in prevResult"))
+
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				_, err := netlinksafe.LinkByName(ifname)
+				return err
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
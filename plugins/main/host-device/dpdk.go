@@ -0,0 +1,116 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type driverRecord struct {
+	PCIAddr string `json:"pciBusID"`
+	Driver  string `json:"driver"`
+}
+
+// currentDriver returns the name of the driver bound to the PCI device, or ""
+// if it is unbound.
+func currentDriver(pciaddr string) (string, error) {
+	driverPath, err := filepath.EvalSymlinks(filepath.Join(sysBusPCI, pciaddr, "driver"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return filepath.Base(driverPath), nil
+}
+
+// bindPCIDriver rebinds the PCI device to driver, through driver_override
+// so that the kernel cannot race us binding another driver. An empty driver
+// leaves the device to the kernel's default choice.
+func bindPCIDriver(pciaddr, driver string) error {
+	if err := unbindPCIDriver(pciaddr); err != nil {
+		return err
+	}
+
+	override := filepath.Join(sysBusPCI, pciaddr, "driver_override")
+	if err := os.WriteFile(override, []byte(driver+"\n"), 0o200); err != nil {
+		return fmt.Errorf("failed to set driver override of %s to %q: %v", pciaddr, driver, err)
+	}
+	probe := filepath.Join(sysBusPCI, "..", "drivers_probe")
+	if err := os.WriteFile(probe, []byte(pciaddr), 0o200); err != nil {
+		return fmt.Errorf("failed to probe driver of %s: %v", pciaddr, err)
+	}
+	return nil
+}
+
+func unbindPCIDriver(pciaddr string) error {
+	driver, err := currentDriver(pciaddr)
+	if err != nil || driver == "" {
+		return err
+	}
+	unbind := filepath.Join(sysBusPCI, pciaddr, "driver", "unbind")
+	if err := os.WriteFile(unbind, []byte(pciaddr), 0o200); err != nil {
+		return fmt.Errorf("failed to unbind %s from driver %s: %v", pciaddr, driver, err)
+	}
+	return nil
+}
+
+// bindUserspaceDriver binds the device to cfg.BindDriver, recording its
+// original driver unless an earlier ADD already did.
+func bindUserspaceDriver(cfg *NetConf, containerID, ifName string) error {
+	driver, err := currentDriver(cfg.PCIAddr)
+	if err != nil {
+		return fmt.Errorf("failed to get driver of %s: %v", cfg.PCIAddr, err)
+	}
+	if driver == cfg.BindDriver {
+		return nil
+	}
+
+	store, key := recordStore(cfg), recordKey(containerID, ifName, "driver")
+	record := &driverRecord{}
+	found, err := store.Load(key, record)
+	if err != nil {
+		return err
+	}
+	if !found || record.PCIAddr != cfg.PCIAddr {
+		if err := store.Save(key, &driverRecord{PCIAddr: cfg.PCIAddr, Driver: driver}); err != nil {
+			return err
+		}
+	}
+	return bindPCIDriver(cfg.PCIAddr, cfg.BindDriver)
+}
+
+// restoreKernelDriver gives the device recorded for the container back to its
+// original driver.
+func restoreKernelDriver(cfg *NetConf, containerID, ifName string) error {
+	store, key := recordStore(cfg), recordKey(containerID, ifName, "driver")
+	record := &driverRecord{}
+	found, err := store.Load(key, record)
+	if err != nil || !found {
+		return err
+	}
+
+	if err := bindPCIDriver(record.PCIAddr, record.Driver); err != nil {
+		return err
+	}
+	// Let later probes pick the driver again
+	override := filepath.Join(sysBusPCI, record.PCIAddr, "driver_override")
+	if err := os.WriteFile(override, []byte("\n"), 0o200); err != nil {
+		return fmt.Errorf("failed to clear driver override of %s: %v", record.PCIAddr, err)
+	}
+	return store.Delete(key)
+}
@@ -23,18 +23,24 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 
+	"github.com/alexflint/go-filemutex"
 	"github.com/vishvananda/netlink"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/log"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/trace"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
 
@@ -49,11 +55,22 @@ var userspaceDrivers = []string{"vfio-pci", "uio_pci_generic", "igb_uio"}
 // NetConf for host-device config, look the README to learn how to use those parameters
 type NetConf struct {
 	types.NetConf
-	Device        string `json:"device"` // Device-Name, something like eth0 or can0 etc.
-	HWAddr        string `json:"hwaddr"` // MAC Address of target network interface
-	DPDKMode      bool
-	KernelPath    string `json:"kernelpath"` // Kernelpath of the device
-	PCIAddr       string `json:"pciBusID"`   // PCI Address of target network device
+	Device     string `json:"device"` // Device-Name, something like eth0 or can0 etc.
+	HWAddr     string `json:"hwaddr"` // MAC Address of target network interface
+	DPDKMode   bool
+	KernelPath string `json:"kernelpath"` // Kernelpath of the device
+	PCIAddr    string `json:"pciBusID"`   // PCI Address of target network device
+	// PCI vendor:device ID of target network device, like 8086:1572; the
+	// first matching device whose netdev is still on the host is used
+	PCIVendorDevice string `json:"pciVendorDevice,omitempty"`
+	// Userspace driver, like vfio-pci, to bind the PCI device to on ADD; the
+	// original driver is restored on DEL
+	BindDriver string `json:"bindDriver,omitempty"`
+	// PF whose first free VF is moved into the container, with the VF
+	// settings applied; the settings are restored on DEL
+	PF            string      `json:"pf,omitempty"`
+	VF            *VFSettings `json:"vf,omitempty"`
+	DataDir       string      `json:"dataDir,omitempty"`
 	RuntimeConfig struct {
 		DeviceID string `json:"deviceID,omitempty"`
 	} `json:"runtimeConfig,omitempty"`
@@ -95,6 +112,8 @@ func handleDeviceID(netconf *NetConf) error {
 }
 
 func loadConf(bytes []byte) (*NetConf, error) {
+	defer trace.Start("parseConfig").End()
+
 	n := &NetConf{}
 	var err error
 	if err = json.Unmarshal(bytes, n); err != nil {
@@ -106,11 +125,23 @@ func loadConf(bytes []byte) (*NetConf, error) {
 		return nil, err
 	}
 
-	if n.Device == "" && n.HWAddr == "" && n.KernelPath == "" && n.PCIAddr == "" && n.auxDevice == "" {
-		return nil, fmt.Errorf(`specify either "device", "hwaddr", "kernelpath" or "pciBusID"`)
+	if n.Device == "" && n.HWAddr == "" && n.KernelPath == "" && n.PCIAddr == "" && n.auxDevice == "" && n.PCIVendorDevice == "" && n.PF == "" {
+		return nil, fmt.Errorf(`specify either "device", "hwaddr", "kernelpath", "pciBusID", "pciVendorDevice" or "pf"`)
+	}
+	if n.VF != nil && n.PF == "" {
+		return nil, fmt.Errorf(`"vf" settings require "pf"`)
 	}
 
-	if len(n.PCIAddr) > 0 {
+	if n.BindDriver != "" {
+		if !slices.Contains(userspaceDrivers, n.BindDriver) {
+			return nil, fmt.Errorf("unsupported bindDriver %q, must be one of %v", n.BindDriver, userspaceDrivers)
+		}
+		if n.PCIAddr == "" && n.PCIVendorDevice == "" {
+			return nil, fmt.Errorf(`"bindDriver" requires "pciBusID" or "pciVendorDevice"`)
+		}
+		// The device is handed to the container as a DPDK device
+		n.DPDKMode = true
+	} else if len(n.PCIAddr) > 0 {
 		n.DPDKMode, err = hasDpdkDriver(n.PCIAddr)
 		if err != nil {
 			return nil, fmt.Errorf("error with host device: %v", err)
@@ -127,7 +158,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 	}
 	containerNs, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer containerNs.Close()
 
@@ -137,17 +168,70 @@ func cmdAdd(args *skel.CmdArgs) error {
 		Sandbox: containerNs.Path(),
 	}}
 
+	if cfg.BindDriver != "" {
+		if cfg.PCIAddr == "" {
+			cfg.PCIAddr, err = findPCIDeviceByID(cfg.PCIVendorDevice)
+			if err != nil {
+				return err
+			}
+		}
+		if err = bindUserspaceDriver(cfg, args.ContainerID, args.IfName); err != nil {
+			return err
+		}
+		// Give the device back to its driver if the ADD fails from here on
+		defer func() {
+			if err != nil {
+				restoreKernelDriver(cfg, args.ContainerID, args.IfName)
+			}
+		}()
+		result.Interfaces[0].PciID = cfg.PCIAddr
+	}
+
+	// Concurrent ADDs on the PF only pick a VF once this one is moved
+	var pfLock *filemutex.FileMutex
+	if cfg.PF != "" && !cfg.DPDKMode {
+		if pfLock, err = allocateVF(cfg, args.ContainerID, args.IfName); err != nil {
+			return err
+		}
+		defer pfLock.Close()
+		// Return the VF to the pool if the ADD fails from here on
+		defer func() {
+			if err != nil {
+				releaseVF(cfg, args.ContainerID, args.IfName)
+			}
+		}()
+		result.Interfaces[0].PciID = cfg.PCIAddr
+	}
+
 	var contDev netlink.Link
 	if !cfg.DPDKMode {
+		// The vendor:device ID is only resolved on ADD: DEL and CHECK find
+		// the device by its name in the container.
+		if cfg.Device == "" && cfg.HWAddr == "" && cfg.KernelPath == "" && cfg.PCIAddr == "" && cfg.auxDevice == "" {
+			cfg.PCIAddr, err = findPCIDeviceByID(cfg.PCIVendorDevice)
+			if err != nil {
+				return err
+			}
+		}
+
 		hostDev, err := getLink(cfg.Device, cfg.HWAddr, cfg.KernelPath, cfg.PCIAddr, cfg.auxDevice)
 		if err != nil {
-			return fmt.Errorf("failed to find host device: %v", err)
+			return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to find host device")
+		}
+
+		if err = saveDeviceState(cfg, args.ContainerID, args.IfName, hostDev); err != nil {
+			return err
 		}
 
 		contDev, err = moveLinkIn(hostDev, containerNs, args.IfName)
+		if pfLock != nil {
+			pfLock.Unlock()
+		}
 		if err != nil {
+			_ = recordStore(cfg).Delete(recordKey(args.ContainerID, args.IfName, "state"))
 			return fmt.Errorf("failed to move link %v", err)
 		}
+		log.Debug("moved device into the container", "device", hostDev.Attrs().Name)
 
 		// Override the device name with the name in the container namespace
 		result.Interfaces[0].Name = contDev.Attrs().Name
@@ -184,6 +268,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if len(newResult.IPs) == 0 {
 		return errors.New("IPAM plugin returned missing IP config")
 	}
+	log.Debug("IPAM allocated addresses", "ipam", cfg.IPAM.Type, "ips", newResult.IPs)
 
 	for _, ipc := range newResult.IPs {
 		// All addresses apply to the container interface (move from host)
@@ -211,12 +296,22 @@ func cmdDel(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	if cfg.BindDriver != "" {
+		if err := restoreKernelDriver(cfg, args.ContainerID, args.IfName); err != nil {
+			return err
+		}
+	}
+	if cfg.PF != "" {
+		if err := releaseVF(cfg, args.ContainerID, args.IfName); err != nil {
+			return err
+		}
+	}
 	if args.Netns == "" {
 		return nil
 	}
 	containerNs, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer containerNs.Close()
 
@@ -230,6 +325,9 @@ func cmdDel(args *skel.CmdArgs) error {
 		if err := moveLinkOut(containerNs, args.IfName); err != nil {
 			return err
 		}
+		if err := restoreDeviceState(cfg, args.ContainerID, args.IfName); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -266,7 +364,7 @@ func moveLinkIn(hostDev netlink.Link, containerNs ns.NetNS, containerIfName stri
 	}
 
 	// Move the host device into tempNS
-	if err = netlink.LinkSetNsFd(hostDev, int(tempNS.Fd())); err != nil {
+	if err = linkSetNsFd(hostDev, int(tempNS.Fd())); err != nil {
 		return nil, fmt.Errorf("failed to move %q to tempNS: %v", hostDevName, err)
 	}
 
@@ -286,7 +384,7 @@ func moveLinkIn(hostDev netlink.Link, containerNs ns.NetNS, containerIfName stri
 		// so we need to actively move the device back to hostNS on error
 		defer func() {
 			if err != nil && tempNSDev != nil {
-				_ = netlink.LinkSetNsFd(tempNSDev, int(hostNS.Fd()))
+				_ = linkSetNsFd(tempNSDev, int(hostNS.Fd()))
 			}
 		}()
 
@@ -315,7 +413,7 @@ func moveLinkIn(hostDev netlink.Link, containerNs ns.NetNS, containerIfName stri
 		}()
 
 		// Move the device to the containerNS
-		if err = netlink.LinkSetNsFd(tempNSDev, int(containerNs.Fd())); err != nil {
+		if err = linkSetNsFd(tempNSDev, int(containerNs.Fd())); err != nil {
 			return fmt.Errorf("failed to move %q (host: %q) to container NS: %v", containerIfName, hostDevName, err)
 		}
 
@@ -336,7 +434,7 @@ func moveLinkIn(hostDev netlink.Link, containerNs ns.NetNS, containerIfName stri
 			// Move the interface back to tempNS on error
 			defer func() {
 				if err != nil {
-					_ = netlink.LinkSetNsFd(contDev, int(tempNS.Fd()))
+					_ = linkSetNsFd(contDev, int(tempNS.Fd()))
 				}
 			}()
 
@@ -398,7 +496,7 @@ func moveLinkOut(containerNs ns.NetNS, containerIfName string) error {
 		}
 
 		// Move the device to the tempNS
-		if err = netlink.LinkSetNsFd(contDev, int(tempNS.Fd())); err != nil {
+		if err = linkSetNsFd(contDev, int(tempNS.Fd())); err != nil {
 			return fmt.Errorf("failed to move %q to tempNS: %v", containerIfName, err)
 		}
 		return nil
@@ -417,7 +515,7 @@ func moveLinkOut(containerNs ns.NetNS, containerIfName string) error {
 		// Move the device back to containerNS on error
 		defer func() {
 			if err != nil {
-				_ = netlink.LinkSetNsFd(tempNSDev, int(containerNs.Fd()))
+				_ = linkSetNsFd(tempNSDev, int(containerNs.Fd()))
 			}
 		}()
 
@@ -448,7 +546,7 @@ func moveLinkOut(containerNs ns.NetNS, containerIfName string) error {
 		}()
 
 		// Finally move the device to the hostNS
-		if err = netlink.LinkSetNsFd(tempNSDev, int(hostNS.Fd())); err != nil {
+		if err = linkSetNsFd(tempNSDev, int(hostNS.Fd())); err != nil {
 			return fmt.Errorf("failed to move %q to hostNS: %v", hostDevName, err)
 		}
 
@@ -482,6 +580,46 @@ func hasDpdkDriver(pciaddr string) (bool, error) {
 	return false, nil
 }
 
+// findPCIDeviceByID returns the address of the first PCI device with the
+// vendor:device ID id that still has a network device in the current network
+// namespace.
+func findPCIDeviceByID(id string) (string, error) {
+	vendor, device, ok := strings.Cut(strings.ToLower(id), ":")
+	if !ok || vendor == "" || device == "" {
+		return "", fmt.Errorf("invalid PCI vendor:device ID %q", id)
+	}
+
+	entries, err := os.ReadDir(sysBusPCI)
+	if err != nil {
+		return "", fmt.Errorf("failed to read PCI devices: %v", err)
+	}
+	for _, entry := range entries {
+		pciaddr := entry.Name()
+		if readPCIID(pciaddr, "vendor") != vendor || readPCIID(pciaddr, "device") != device {
+			continue
+		}
+		netDevs, err := os.ReadDir(filepath.Join(sysBusPCI, pciaddr, "net"))
+		if err != nil {
+			continue
+		}
+		for _, netDev := range netDevs {
+			if _, err := netlinksafe.LinkByName(netDev.Name()); err == nil {
+				return pciaddr, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no unused PCI device with vendor:device ID %s found", id)
+}
+
+// readPCIID reads a sysfs PCI ID file such as vendor, without its 0x prefix.
+func readPCIID(pciaddr, name string) string {
+	data, err := os.ReadFile(filepath.Join(sysBusPCI, pciaddr, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(string(data))), "0x")
+}
+
 func printLink(dev netlink.Link, cniVersion string, containerNs ns.NetNS) error {
 	result := current.Result{
 		CNIVersion: current.ImplementedSpecVersion,
@@ -555,13 +693,13 @@ func getLink(devname, hwaddr, kernelpath, pciaddr string, auxDev string) (netlin
 }
 
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
+	skel.PluginMainFuncs(log.Wrap("host-device", trace.Wrap("host-device", skel.CNIFuncs{
 		Add:    cmdAdd,
 		Check:  cmdCheck,
 		Del:    cmdDel,
 		Status: cmdStatus,
 		/* FIXME GC */
-	}, version.All, bv.BuildString("host-device"))
+	})), version.All, bv.BuildString("host-device"))
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
@@ -571,7 +709,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 	}
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
@@ -675,13 +813,21 @@ func cmdStatus(args *skel.CmdArgs) error {
 		return fmt.Errorf("failed to load netconf: %w", err)
 	}
 
+	if err := status.Netlink(); err != nil {
+		return err
+	}
+	// Devices found by their address or path are only known at ADD
+	if conf.Device != "" {
+		if err := status.Link(conf.Device); err != nil {
+			return err
+		}
+	}
+
 	if conf.IPAM.Type != "" {
 		if err := ipam.ExecStatus(conf.IPAM.Type, args.StdinData); err != nil {
 			return err
 		}
 	}
 
-	// TODO: Check if host device exists.
-
 	return nil
 }
@@ -22,6 +22,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -35,6 +36,7 @@ import (
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 )
 
 type Net struct {
@@ -627,6 +629,402 @@ var _ = Describe("base functionality", func() {
 			})
 		})
 
+		It(fmt.Sprintf("[%s] binds a device to vfio-pci and restores its driver on DEL", ver), func() {
+			fs := &fakeFilesystem{
+				dirs: []string{
+					"sys/bus/pci/devices/0000:00:00.1",
+					"sys/bus/pci/drivers/i40e",
+				},
+				symlinks: map[string]string{
+					"sys/bus/pci/devices/0000:00:00.1/driver": "../../../../bus/pci/drivers/i40e",
+				},
+			}
+			defer fs.use()()
+			dataDir := path.Join(fs.rootDir, "data")
+
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "cni-plugin-host-device-test",
+				"type": "host-device",
+				"pciBusID": "0000:00:00.1",
+				"bindDriver": "vfio-pci",
+				"dataDir": %q
+			}`, ver, dataDir)
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				IfName:      "eth0",
+				Netns:       targetNS.Path(),
+				StdinData:   []byte(conf),
+			}
+			var resI types.Result
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				var err error
+				resI, _, err = testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			if strings.HasPrefix(ver, "1.") {
+				res, err := types100.NewResultFromResult(resI)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(res.Interfaces[0].PciID).To(Equal("0000:00:00.1"))
+			}
+
+			readSysfs := func(file string) string {
+				data, err := os.ReadFile(path.Join(fs.rootDir, file))
+				Expect(err).NotTo(HaveOccurred())
+				return string(data)
+			}
+			Expect(readSysfs("sys/bus/pci/drivers/i40e/unbind")).To(Equal("0000:00:00.1"))
+			Expect(readSysfs("sys/bus/pci/devices/0000:00:00.1/driver_override")).To(Equal("vfio-pci\n"))
+			Expect(readSysfs("sys/bus/pci/drivers_probe")).To(Equal("0000:00:00.1"))
+			record, err := os.ReadFile(path.Join(dataDir, "dummy_eth0.driver.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(record)).To(MatchJSON(`{
+				"version": 1,
+				"network": "cni-plugin-host-device-test",
+				"containerID": "dummy",
+				"ifName": "eth0",
+				"kind": "driver",
+				"data": {"pciBusID": "0000:00:00.1", "driver": "i40e"}
+			}`))
+
+			err = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				return testutils.CmdDelWithArgs(args, func() error {
+					return cmdDel(args)
+				})
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(readSysfs("sys/bus/pci/devices/0000:00:00.1/driver_override")).To(Equal("\n"))
+			_, err = os.Stat(path.Join(dataDir, "dummy_eth0.driver.json"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It(fmt.Sprintf("[%s] moves the first free VF of a PF into the container and back", ver), func() {
+			var vfLink netlink.Link
+			pfName := fmt.Sprintf("pf-%x", rand.Int31())
+
+			// prepare the PF and the netdev of its second VF in original namespace
+			_ = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				for _, name := range []string{pfName, ifname} {
+					linkAttrs := netlink.NewLinkAttrs()
+					linkAttrs.Name = name
+					err := netlink.LinkAdd(&netlink.Dummy{
+						LinkAttrs: linkAttrs,
+					})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				var err error
+				vfLink, err = netlinksafe.LinkByName(ifname)
+				Expect(err).NotTo(HaveOccurred())
+				return nil
+			})
+
+			// the netdev of the first VF is already in another container
+			fs := &fakeFilesystem{
+				dirs: []string{
+					"sys/bus/pci/devices/0000:00:01.0/net/in-use0",
+					"sys/bus/pci/devices/0000:00:01.1/net/" + ifname,
+					"sys/class/net/" + pfName + "/device",
+				},
+				symlinks: map[string]string{
+					"sys/class/net/" + pfName + "/device/virtfn0": "../../../../bus/pci/devices/0000:00:01.0",
+					"sys/class/net/" + pfName + "/device/virtfn1": "../../../../bus/pci/devices/0000:00:01.1",
+				},
+			}
+			defer fs.use()()
+			dataDir := path.Join(fs.rootDir, "data")
+
+			cniName := "eth0"
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "cni-plugin-host-device-test",
+				"type": "host-device",
+				"pf": %q,
+				"dataDir": %q
+			}`, ver, pfName, dataDir)
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNS.Path(),
+				IfName:      cniName,
+				StdinData:   []byte(conf),
+			}
+			var resI types.Result
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				var err error
+				resI, _, err = testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			t := newTesterByVersion(ver)
+			t.expectInterfaces(resI, cniName, vfLink.Attrs().HardwareAddr.String(), targetNS.Path())
+			record, err := os.ReadFile(path.Join(dataDir, "dummy_eth0.vf.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(record)).To(ContainSubstring(`"pciBusID":"0000:00:01.1"`))
+
+			_ = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				_, err := allocateVF(&NetConf{PF: pfName, DataDir: dataDir}, "other", "eth0")
+				Expect(err).To(MatchError(fmt.Sprintf("no free VF left on PF %q", pfName)))
+
+				err = testutils.CmdDelWithArgs(args, func() error {
+					return cmdDel(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = netlinksafe.LinkByName(ifname)
+				Expect(err).NotTo(HaveOccurred())
+				return nil
+			})
+			_, err = os.Stat(path.Join(dataDir, "dummy_eth0.vf.json"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It(fmt.Sprintf("[%s] picks a VF of a PF only once other ADDs moved theirs", ver), func() {
+			pfName := fmt.Sprintf("pf-%x", rand.Int31())
+			// the PF and the netdev of its VF, as a veth pair
+			_ = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				linkAttrs := netlink.NewLinkAttrs()
+				linkAttrs.Name = pfName
+				err := netlink.LinkAdd(&netlink.Veth{
+					LinkAttrs: linkAttrs,
+					PeerName:  ifname,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				return nil
+			})
+
+			fs := &fakeFilesystem{
+				dirs: []string{
+					"sys/bus/pci/devices/0000:00:01.0/net/" + ifname,
+					"sys/class/net/" + pfName + "/device",
+				},
+				symlinks: map[string]string{
+					"sys/class/net/" + pfName + "/device/virtfn0": "../../../../bus/pci/devices/0000:00:01.0",
+				},
+			}
+			defer fs.use()()
+			cfg := &NetConf{PF: pfName, DataDir: path.Join(fs.rootDir, "data")}
+
+			_ = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				lock, err := allocateVF(cfg, "first", "eth0")
+				Expect(err).NotTo(HaveOccurred())
+
+				allocated := make(chan error)
+				go func() {
+					_ = originalNS.Do(func(ns.NetNS) error {
+						_, err := allocateVF(&NetConf{PF: pfName, DataDir: cfg.DataDir}, "second", "eth0")
+						allocated <- err
+						return nil
+					})
+				}()
+				Consistently(allocated, 200*time.Millisecond).ShouldNot(Receive())
+
+				// the first ADD moves the VF into its container
+				link, err := netlinksafe.LinkByName(ifname)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetNsFd(link, int(targetNS.Fd()))).To(Succeed())
+				Expect(lock.Close()).To(Succeed())
+
+				Eventually(allocated).Should(Receive(MatchError(fmt.Sprintf("no free VF left on PF %q", pfName))))
+				return nil
+			})
+		})
+
+		It(fmt.Sprintf("[%s] drops the VF record when the VF settings cannot be applied", ver), func() {
+			pfName := fmt.Sprintf("pf-%x", rand.Int31())
+			// the PF and the netdev of its VF, as a veth pair
+			_ = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				linkAttrs := netlink.NewLinkAttrs()
+				linkAttrs.Name = pfName
+				err := netlink.LinkAdd(&netlink.Veth{
+					LinkAttrs: linkAttrs,
+					PeerName:  ifname,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				return nil
+			})
+
+			fs := &fakeFilesystem{
+				dirs: []string{
+					"sys/bus/pci/devices/0000:00:01.0/net/" + ifname,
+					"sys/class/net/" + pfName + "/device",
+				},
+				symlinks: map[string]string{
+					"sys/class/net/" + pfName + "/device/virtfn0": "../../../../bus/pci/devices/0000:00:01.0",
+				},
+			}
+			defer fs.use()()
+			dataDir := path.Join(fs.rootDir, "data")
+
+			_ = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				// a veth has no VFs to apply settings to
+				vlan := 100
+				_, err := allocateVF(&NetConf{PF: pfName, DataDir: dataDir, VF: &VFSettings{Vlan: &vlan}}, "dummy", "eth0")
+				Expect(err).To(HaveOccurred())
+				return nil
+			})
+			_, err := os.Stat(path.Join(dataDir, "dummy_eth0.vf.json"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It(fmt.Sprintf("[%s] restores the MTU and sysctls of the device on DEL", ver), func() {
+			sysctlName := fmt.Sprintf("net/ipv4/conf/%s/arp_ignore", ifname)
+
+			// prepare ifname in original namespace
+			_ = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				linkAttrs := netlink.NewLinkAttrs()
+				linkAttrs.Name = ifname
+				err := netlink.LinkAdd(&netlink.Dummy{
+					LinkAttrs: linkAttrs,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				_, err = sysctl.Sysctl(sysctlName, "2")
+				Expect(err).NotTo(HaveOccurred())
+				return nil
+			})
+
+			dataDir, err := os.MkdirTemp("", "host-device")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dataDir)
+
+			cniName := "eth0"
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "cni-plugin-host-device-test",
+				"type": "host-device",
+				"device": %q,
+				"dataDir": %q
+			}`, ver, ifname, dataDir)
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNS.Path(),
+				IfName:      cniName,
+				StdinData:   []byte(conf),
+			}
+			err = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = os.Stat(path.Join(dataDir, "dummy_eth0.state.json"))
+			Expect(err).NotTo(HaveOccurred())
+
+			// the container changes the MTU of the device
+			_ = targetNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				link, err := netlinksafe.LinkByName(cniName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetMTU(link, 1400)).To(Succeed())
+				return nil
+			})
+
+			_ = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				err := testutils.CmdDelWithArgs(args, func() error {
+					return cmdDel(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				link, err := netlinksafe.LinkByName(ifname)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.Attrs().MTU).To(Equal(1500))
+				value, err := sysctl.Sysctl(sysctlName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(Equal("2"))
+				return nil
+			})
+			_, err = os.Stat(path.Join(dataDir, "dummy_eth0.state.json"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It(fmt.Sprintf("[%s] works with a config selecting the first unused device by PCI vendor:device ID", ver), func() {
+			var origLink netlink.Link
+
+			// prepare ifname in original namespace
+			_ = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				linkAttrs := netlink.NewLinkAttrs()
+				linkAttrs.Name = ifname
+				err := netlink.LinkAdd(&netlink.Dummy{
+					LinkAttrs: linkAttrs,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				origLink, err = netlinksafe.LinkByName(ifname)
+				Expect(err).NotTo(HaveOccurred())
+				return nil
+			})
+
+			// 0000:00:00.1 matches but its netdev is already gone from the
+			// host, and 0000:00:00.3 has another device ID
+			fs := &fakeFilesystem{
+				dirs: []string{
+					"sys/bus/pci/devices/0000:00:00.1/net/in-use0",
+					"sys/bus/pci/devices/0000:00:00.2/net/" + ifname,
+					"sys/bus/pci/devices/0000:00:00.3/net/" + ifname,
+				},
+			}
+			defer fs.use()()
+			for pciaddr, device := range map[string]string{"0000:00:00.1": "0x1572", "0000:00:00.2": "0x1572", "0000:00:00.3": "0x158b"} {
+				devDir := path.Join(sysBusPCI, pciaddr)
+				Expect(os.WriteFile(path.Join(devDir, "vendor"), []byte("0x8086\n"), 0o644)).To(Succeed())
+				Expect(os.WriteFile(path.Join(devDir, "device"), []byte(device+"\n"), 0o644)).To(Succeed())
+			}
+
+			cniName := "eth0"
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "cni-plugin-host-device-test",
+				"type": "host-device",
+				"pciVendorDevice": "8086:1572"
+			}`, ver)
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNS.Path(),
+				IfName:      cniName,
+				StdinData:   []byte(conf),
+			}
+			var resI types.Result
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				var err error
+				resI, _, err = testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			t := newTesterByVersion(ver)
+			t.expectInterfaces(resI, cniName, origLink.Attrs().HardwareAddr.String(), targetNS.Path())
+
+			// with the device in the container, no unused match is left
+			_ = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				_, err := findPCIDeviceByID("8086:1572")
+				Expect(err).To(MatchError("no unused PCI device with vendor:device ID 8086:1572 found"))
+
+				err = testutils.CmdDelWithArgs(args, func() error {
+					return cmdDel(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = netlinksafe.LinkByName(ifname)
+				Expect(err).NotTo(HaveOccurred())
+				return nil
+			})
+		})
+
 		It(fmt.Sprintf("Works with a valid %s config with IPAM", ver), func() {
 			var origLink netlink.Link
 
@@ -736,7 +1134,7 @@ var _ = Describe("base functionality", func() {
 				StdinData:   []byte(conf),
 			}
 			_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
-			Expect(err).To(MatchError(`specify either "device", "hwaddr", "kernelpath" or "pciBusID"`))
+			Expect(err).To(MatchError(`specify either "device", "hwaddr", "kernelpath", "pciBusID", "pciVendorDevice" or "pf"`))
 		})
 
 		It(fmt.Sprintf("[%s] works with a valid config without IPAM", ver), func() {
@@ -1348,6 +1746,88 @@ var _ = Describe("base functionality", func() {
 			})
 		})
 	}
+
+	It("does not take a veth for a wireless device", func() {
+		_ = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			linkAttrs := netlink.NewLinkAttrs()
+			linkAttrs.Name = ifname
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: linkAttrs, PeerName: ifname + "p"})
+			Expect(err).NotTo(HaveOccurred())
+			link, err := netlinksafe.LinkByName(ifname)
+			Expect(err).NotTo(HaveOccurred())
+			_, wireless := wiphyOf(link.Attrs().Index)
+			Expect(wireless).To(BeFalse())
+			return nil
+		})
+	})
+
+	It("moves a wireless device into the container with its phy", func() {
+		// Wireless devices, like those of mac80211_hwsim, cannot be created
+		// in a namespace: borrow one of the host.
+		var (
+			hostDev netlink.Link
+			phy     uint32
+		)
+		links, err := netlinksafe.LinkList()
+		Expect(err).NotTo(HaveOccurred())
+		for _, link := range links {
+			if p, ok := wiphyOf(link.Attrs().Index); ok {
+				hostDev, phy = link, p
+				break
+			}
+		}
+		if hostDev == nil {
+			Skip("no wireless device on the host")
+		}
+		// The phy goes back to the initial namespace when originalNS is
+		// closed
+		Expect(wiphySetNsFd(phy, int(originalNS.Fd()))).To(Succeed())
+
+		cniName := "eth0"
+		conf := fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "cni-plugin-host-device-test",
+			"type": "host-device",
+			"device": %q
+		}`, hostDev.Attrs().Name)
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNS.Path(),
+			IfName:      cniName,
+			StdinData:   []byte(conf),
+		}
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		_ = targetNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			link, err := netlinksafe.LinkByName(cniName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().HardwareAddr).To(Equal(hostDev.Attrs().HardwareAddr))
+			contPhy, wireless := wiphyOf(link.Attrs().Index)
+			Expect(wireless).To(BeTrue())
+			Expect(contPhy).To(Equal(phy))
+			return nil
+		})
+
+		_ = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			_, err := netlinksafe.LinkByName(hostDev.Attrs().Name)
+			Expect(err).To(HaveOccurred())
+
+			err = testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = netlinksafe.LinkByName(hostDev.Attrs().Name)
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+	})
 })
 
 type fakeFilesystem struct {
@@ -1380,6 +1860,7 @@ func (fs *fakeFilesystem) use() func() {
 
 	sysBusPCI = path.Join(fs.rootDir, "/sys/bus/pci/devices")
 	sysBusAuxiliary = path.Join(fs.rootDir, "/sys/bus/auxiliary/devices")
+	sysClassNet = path.Join(fs.rootDir, "/sys/class/net")
 
 	return func() {
 		// remove temporary fake fs
@@ -0,0 +1,44 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/containernetworking/plugins/pkg/statestore"
+)
+
+// What ADD changed on the host and DEL must undo, like the original driver
+// of a device or the original settings of a VF, is recorded in a store
+// under dataDir, one record of each kind per attachment.
+var defaultDataDir = "/var/lib/cni/host-device"
+
+func dataDir(cfg *NetConf) string {
+	if cfg.DataDir == "" {
+		return defaultDataDir
+	}
+	return cfg.DataDir
+}
+
+func recordStore(cfg *NetConf) *statestore.Store {
+	store := statestore.New(dataDir(cfg), cfg.Name)
+	// Records were <dataDir>/<containerID>-<ifName>.<kind> before the store
+	store.Legacy = func(key statestore.Key) string {
+		return key.ContainerID + "-" + key.IfName + "." + key.Kind
+	}
+	return store
+}
+
+func recordKey(containerID, ifName, kind string) statestore.Key {
+	return statestore.Key{ContainerID: containerID, IfName: ifName, Kind: kind}
+}
@@ -0,0 +1,242 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexflint/go-filemutex"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+)
+
+var sysClassNet = "/sys/class/net"
+
+// VFSettings are applied through the PF to the VF allocated in "pf" mode.
+type VFSettings struct {
+	MAC      string `json:"mac,omitempty"`
+	Vlan     *int   `json:"vlan,omitempty"`
+	SpoofChk *bool  `json:"spoofchk,omitempty"`
+	Trust    *bool  `json:"trust,omitempty"`
+}
+
+// vfRecord holds the VF handed to a container and its settings before ADD.
+type vfRecord struct {
+	PF       string `json:"pf"`
+	VF       int    `json:"vf"`
+	PCIAddr  string `json:"pciBusID"`
+	MAC      string `json:"mac,omitempty"`
+	Vlan     int    `json:"vlan"`
+	SpoofChk bool   `json:"spoofchk"`
+	Trust    bool   `json:"trust"`
+}
+
+type virtfn struct {
+	index   int
+	pciaddr string
+}
+
+// listVFs returns the VFs of the PF, ordered by index.
+func listVFs(pf string) ([]virtfn, error) {
+	links, err := filepath.Glob(filepath.Join(sysClassNet, pf, "device", "virtfn*"))
+	if err != nil {
+		return nil, err
+	}
+
+	vfs := []virtfn{}
+	for _, link := range links {
+		index, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(link), "virtfn"))
+		if err != nil {
+			continue
+		}
+		vfPath, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve VF %d of %s: %v", index, pf, err)
+		}
+		vfs = append(vfs, virtfn{index: index, pciaddr: filepath.Base(vfPath)})
+	}
+	sort.Slice(vfs, func(i, j int) bool { return vfs[i].index < vfs[j].index })
+	return vfs, nil
+}
+
+// vfIsFree reports whether the netdev of the VF is still in the current
+// network namespace.
+func vfIsFree(vf virtfn) bool {
+	netDevs, err := os.ReadDir(filepath.Join(sysBusPCI, vf.pciaddr, "net"))
+	if err != nil {
+		return false
+	}
+	for _, netDev := range netDevs {
+		if _, err := netlinksafe.LinkByName(netDev.Name()); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// lockPF returns the lock of the VFs of pf, taken.
+func lockPF(cfg *NetConf, pf string) (*filemutex.FileMutex, error) {
+	if err := os.MkdirAll(dataDir(cfg), 0o700); err != nil {
+		return nil, err
+	}
+	lock, err := filemutex.New(filepath.Join(dataDir(cfg), "pf-"+pf+".lock"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the lock of PF %q: %v", pf, err)
+	}
+	if err := lock.Lock(); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to lock PF %q: %v", pf, err)
+	}
+	return lock, nil
+}
+
+// allocateVF picks the first free VF of cfg.PF, applies cfg.VF to it and
+// selects it as the device to move into the container. It returns the lock
+// of the PF, which the caller must hold until the VF is moved out of the
+// host netns: other invocations tell the VFs in use by their netdev being
+// gone.
+func allocateVF(cfg *NetConf, containerID, ifName string) (*filemutex.FileMutex, error) {
+	pf, err := netlinksafe.LinkByName(cfg.PF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup PF %q: %v", cfg.PF, err)
+	}
+	lock, err := lockPF(cfg, cfg.PF)
+	if err != nil {
+		return nil, err
+	}
+	vf, err := pickVF(cfg, pf, containerID, ifName)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	cfg.PCIAddr = vf.pciaddr
+	return lock, nil
+}
+
+// pickVF records the first free VF of pf for the container and applies
+// cfg.VF to it. The PF must be locked.
+func pickVF(cfg *NetConf, pf netlink.Link, containerID, ifName string) (*virtfn, error) {
+	vfs, err := listVFs(cfg.PF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VFs of %q: %v", cfg.PF, err)
+	}
+
+	var vf *virtfn
+	for i := range vfs {
+		if vfIsFree(vfs[i]) {
+			vf = &vfs[i]
+			break
+		}
+	}
+	if vf == nil {
+		return nil, fmt.Errorf("no free VF left on PF %q", cfg.PF)
+	}
+
+	record := &vfRecord{PF: cfg.PF, VF: vf.index, PCIAddr: vf.pciaddr}
+	for _, info := range pf.Attrs().Vfs {
+		if info.ID == vf.index {
+			record.MAC = info.Mac.String()
+			record.Vlan = info.Vlan
+			record.SpoofChk = info.Spoofchk
+			record.Trust = info.Trust != 0
+		}
+	}
+	store, key := recordStore(cfg), recordKey(containerID, ifName, "vf")
+	if err := store.Save(key, record); err != nil {
+		return nil, err
+	}
+
+	if cfg.VF != nil {
+		if err := setVFSettings(pf, vf.index, cfg.VF); err != nil {
+			// Undo the settings applied before the failing one as far as
+			// possible, and drop the record either way
+			_ = releaseVF(cfg, containerID, ifName)
+			_ = store.Delete(key)
+			return nil, err
+		}
+	}
+	return vf, nil
+}
+
+func setVFSettings(pf netlink.Link, vf int, settings *VFSettings) error {
+	name := pf.Attrs().Name
+	if settings.MAC != "" {
+		mac, err := net.ParseMAC(settings.MAC)
+		if err != nil {
+			return fmt.Errorf("invalid VF MAC %q: %v", settings.MAC, err)
+		}
+		if err := netlink.LinkSetVfHardwareAddr(pf, vf, mac); err != nil {
+			return fmt.Errorf("failed to set MAC of VF %d of %s: %v", vf, name, err)
+		}
+	}
+	if settings.Vlan != nil {
+		if err := netlink.LinkSetVfVlan(pf, vf, *settings.Vlan); err != nil {
+			return fmt.Errorf("failed to set VLAN of VF %d of %s: %v", vf, name, err)
+		}
+	}
+	if settings.SpoofChk != nil {
+		if err := netlink.LinkSetVfSpoofchk(pf, vf, *settings.SpoofChk); err != nil {
+			return fmt.Errorf("failed to set spoof check of VF %d of %s: %v", vf, name, err)
+		}
+	}
+	if settings.Trust != nil {
+		if err := netlink.LinkSetVfTrust(pf, vf, *settings.Trust); err != nil {
+			return fmt.Errorf("failed to set trust of VF %d of %s: %v", vf, name, err)
+		}
+	}
+	return nil
+}
+
+// releaseVF restores the settings that cfg.VF changed on the VF recorded for
+// the container, returning it to the pool as it was.
+func releaseVF(cfg *NetConf, containerID, ifName string) error {
+	store, key := recordStore(cfg), recordKey(containerID, ifName, "vf")
+	record := &vfRecord{}
+	found, err := store.Load(key, record)
+	if err != nil || !found {
+		return err
+	}
+
+	if cfg.VF != nil {
+		pf, err := netlinksafe.LinkByName(record.PF)
+		if err != nil {
+			return fmt.Errorf("failed to lookup PF %q: %v", record.PF, err)
+		}
+		orig := &VFSettings{}
+		if cfg.VF.MAC != "" {
+			orig.MAC = record.MAC
+		}
+		if cfg.VF.Vlan != nil {
+			orig.Vlan = &record.Vlan
+		}
+		if cfg.VF.SpoofChk != nil {
+			orig.SpoofChk = &record.SpoofChk
+		}
+		if cfg.VF.Trust != nil {
+			orig.Trust = &record.Trust
+		}
+		if err := setVFSettings(pf, record.VF, orig); err != nil {
+			return err
+		}
+	}
+	return store.Delete(key)
+}
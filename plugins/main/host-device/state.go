@@ -0,0 +1,168 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/safchain/ethtool"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+)
+
+// deviceState is the state of a host device before it was moved into a
+// container. The container may change any of it, and the per-interface
+// sysctls are reset to the defaults of each namespace the device enters, so
+// DEL restores it once the device is back on the host.
+type deviceState struct {
+	Name     string            `json:"name"`
+	MTU      int               `json:"mtu"`
+	Features map[string]bool   `json:"features,omitempty"`
+	RxRing   *uint32           `json:"rxRing,omitempty"`
+	TxRing   *uint32           `json:"txRing,omitempty"`
+	SysCtl   map[string]string `json:"sysctl,omitempty"`
+}
+
+// deviceSysctls returns the writable per-interface sysctls of the device,
+// with their current values.
+func deviceSysctls(ifName string) map[string]string {
+	sysctls := map[string]string{}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		dir := filepath.Join("/proc/sys/net", family, "conf", ifName)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o200 == 0 {
+				continue
+			}
+			// Use slashes as separator, as the interface name may hold dots
+			name := strings.Join([]string{"net", family, "conf", ifName, entry.Name()}, "/")
+			value, err := sysctl.Sysctl(name)
+			if err != nil {
+				continue
+			}
+			sysctls[entry.Name()+"@"+family] = value
+		}
+	}
+	return sysctls
+}
+
+func saveDeviceState(cfg *NetConf, containerID, ifName string, hostDev netlink.Link) error {
+	name := hostDev.Attrs().Name
+	state := &deviceState{
+		Name:   name,
+		MTU:    hostDev.Attrs().MTU,
+		SysCtl: deviceSysctls(name),
+	}
+
+	e, err := ethtool.NewEthtool()
+	if err != nil {
+		return fmt.Errorf("failed to initialize ethtool: %v", err)
+	}
+	defer e.Close()
+	// Not every driver supports these, in which case there is nothing to restore
+	if features, err := e.Features(name); err == nil {
+		state.Features = features
+	}
+	if ring, err := e.GetRing(name); err == nil {
+		state.RxRing = &ring.RxPending
+		state.TxRing = &ring.TxPending
+	}
+
+	return recordStore(cfg).Save(recordKey(containerID, ifName, "state"), state)
+}
+
+// restoreDeviceState restores the state recorded for the container on the
+// device, which must be back on the host. It restores as much as it can,
+// logging what it could not.
+func restoreDeviceState(cfg *NetConf, containerID, ifName string) error {
+	store, key := recordStore(cfg), recordKey(containerID, ifName, "state")
+	state := &deviceState{}
+	found, err := store.Load(key, state)
+	if err != nil || !found {
+		return err
+	}
+
+	link, err := netlinksafe.LinkByName(state.Name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			// The device is gone, there is nothing left to restore
+			return store.Delete(key)
+		}
+		return fmt.Errorf("failed to lookup %q to restore its state: %v", state.Name, err)
+	}
+
+	if link.Attrs().MTU != state.MTU {
+		if err := netlink.LinkSetMTU(link, state.MTU); err != nil {
+			fmt.Fprintf(os.Stderr, "host-device: failed to restore MTU of %q: %v\n", state.Name, err)
+		}
+	}
+
+	e, err := ethtool.NewEthtool()
+	if err != nil {
+		return fmt.Errorf("failed to initialize ethtool: %v", err)
+	}
+	defer e.Close()
+	if len(state.Features) > 0 {
+		current, err := e.Features(state.Name)
+		if err == nil {
+			changed := map[string]bool{}
+			for feature, enabled := range state.Features {
+				if cur, ok := current[feature]; ok && cur != enabled {
+					changed[feature] = enabled
+				}
+			}
+			if len(changed) > 0 {
+				err = e.Change(state.Name, changed)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "host-device: failed to restore ethtool features of %q: %v\n", state.Name, err)
+		}
+	}
+	if state.RxRing != nil && state.TxRing != nil {
+		ring, err := e.GetRing(state.Name)
+		if err == nil && (ring.RxPending != *state.RxRing || ring.TxPending != *state.TxRing) {
+			ring.RxPending = *state.RxRing
+			ring.TxPending = *state.TxRing
+			_, err = e.SetRing(state.Name, ring)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "host-device: failed to restore ring sizes of %q: %v\n", state.Name, err)
+		}
+	}
+
+	current := deviceSysctls(state.Name)
+	for key, value := range state.SysCtl {
+		entry, family, _ := strings.Cut(key, "@")
+		if cur, ok := current[key]; !ok || cur == value {
+			continue
+		}
+		name := strings.Join([]string{"net", family, "conf", state.Name, entry}, "/")
+		if _, err := sysctl.Sysctl(name, value); err != nil {
+			fmt.Fprintf(os.Stderr, "host-device: failed to restore sysctl %s: %v\n", name, err)
+		}
+	}
+
+	return store.Delete(key)
+}
@@ -0,0 +1,91 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Wireless devices are local to their network namespace: the kernel only
+// moves them along with their phy, and all the other interfaces of the phy,
+// over the nl80211 generic netlink family, as `iw phy <phy> set netns` does.
+// See include/uapi/linux/nl80211.h.
+
+const nl80211GenlVersion = 1
+
+// wiphyOf returns the index of the phy of the device of index, or false if
+// it is not a wireless device.
+func wiphyOf(index int) (uint32, bool) {
+	family, err := netlink.GenlFamilyGet(unix.NL80211_GENL_NAME)
+	if err != nil {
+		// Without cfg80211 there are no wireless devices
+		return 0, false
+	}
+
+	req := nl.NewNetlinkRequest(int(family.ID), 0)
+	req.AddData(&nl.Genlmsg{Command: unix.NL80211_CMD_GET_INTERFACE, Version: nl80211GenlVersion})
+	req.AddData(nl.NewRtAttr(unix.NL80211_ATTR_IFINDEX, nl.Uint32Attr(uint32(index))))
+	msgs, err := req.Execute(unix.NETLINK_GENERIC, 0)
+	if err != nil {
+		// The device is not handled by cfg80211
+		return 0, false
+	}
+
+	for _, msg := range msgs {
+		attrs, err := nl.ParseRouteAttr(msg[nl.SizeofGenlmsg:])
+		if err != nil {
+			return 0, false
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type == unix.NL80211_ATTR_WIPHY {
+				return nl.NativeEndian().Uint32(attr.Value), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// wiphySetNsFd moves the phy, with its interfaces, from the current
+// network namespace to that of fd.
+func wiphySetNsFd(phy uint32, fd int) error {
+	family, err := netlink.GenlFamilyGet(unix.NL80211_GENL_NAME)
+	if err != nil {
+		return fmt.Errorf("failed to find the %s generic netlink family: %v", unix.NL80211_GENL_NAME, err)
+	}
+
+	req := nl.NewNetlinkRequest(int(family.ID), unix.NLM_F_ACK)
+	req.AddData(&nl.Genlmsg{Command: unix.NL80211_CMD_SET_WIPHY_NETNS, Version: nl80211GenlVersion})
+	req.AddData(nl.NewRtAttr(unix.NL80211_ATTR_WIPHY, nl.Uint32Attr(phy)))
+	req.AddData(nl.NewRtAttr(unix.NL80211_ATTR_NETNS_FD, nl.Uint32Attr(uint32(fd))))
+	_, err = req.Execute(unix.NETLINK_GENERIC, 0)
+	return err
+}
+
+// linkSetNsFd moves the device into the network namespace of fd, through its
+// phy if it is a wireless device.
+func linkSetNsFd(link netlink.Link, fd int) error {
+	phy, wireless := wiphyOf(link.Attrs().Index)
+	if !wireless {
+		return netlink.LinkSetNsFd(link, fd)
+	}
+	if err := wiphySetNsFd(phy, fd); err != nil {
+		return fmt.Errorf("failed to move phy %d of wireless device %q: %v", phy, link.Attrs().Name, err)
+	}
+	return nil
+}
@@ -26,20 +26,34 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/link"
+	"github.com/containernetworking/plugins/pkg/log"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/trace"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 )
 
 type NetConf struct {
 	types.NetConf
-	Master     string `json:"master"`
-	Mode       string `json:"mode"`
+	Master string `json:"master"`
+	Mode   string `json:"mode"`
+	// Flag is the ipvlan isolation flag: "bridge", "private" or "vepa"
+	Flag       string `json:"flag,omitempty"`
 	MTU        int    `json:"mtu"`
 	LinkContNs bool   `json:"linkInContainer,omitempty"`
+	// MasterVlan is a VLAN subinterface to use as master, created on the
+	// first attachment and removed with the last, instead of master.
+	MasterVlan *link.VlanMaster `json:"masterVlan,omitempty"`
+	// ProbeAddressConflicts probes the segment for other hosts using the
+	// addresses before adding them. Modes without ARP are not probed.
+	ProbeAddressConflicts bool `json:"probeAddressConflicts,omitempty"`
 }
 
 func init() {
@@ -50,11 +64,26 @@ func init() {
 }
 
 func loadConf(args *skel.CmdArgs, cmdCheck bool) (*NetConf, string, error) {
+	defer trace.Start("parseConfig").End()
+
 	n := &NetConf{}
 	if err := json.Unmarshal(args.StdinData, n); err != nil {
 		return nil, "", fmt.Errorf("failed to load netconf: %v", err)
 	}
 
+	if n.MasterVlan != nil {
+		if n.Master != "" {
+			return nil, "", fmt.Errorf("cannot set master and masterVlan at the same time")
+		}
+		if n.LinkContNs {
+			return nil, "", fmt.Errorf("masterVlan cannot be used with linkInContainer")
+		}
+		if err := n.MasterVlan.Validate(); err != nil {
+			return nil, "", err
+		}
+		n.Master = n.MasterVlan.Name()
+	}
+
 	if cmdCheck {
 		return n, n.CNIVersion, nil
 	}
@@ -117,6 +146,32 @@ func modeToString(mode netlink.IPVlanMode) (string, error) {
 	}
 }
 
+func flagFromString(s string) (netlink.IPVlanFlag, error) {
+	switch s {
+	case "", "bridge":
+		return netlink.IPVLAN_FLAG_BRIDGE, nil
+	case "private":
+		return netlink.IPVLAN_FLAG_PRIVATE, nil
+	case "vepa":
+		return netlink.IPVLAN_FLAG_VEPA, nil
+	default:
+		return 0, fmt.Errorf("unknown ipvlan flag: %q", s)
+	}
+}
+
+func flagToString(flag netlink.IPVlanFlag) (string, error) {
+	switch flag {
+	case netlink.IPVLAN_FLAG_BRIDGE:
+		return "bridge", nil
+	case netlink.IPVLAN_FLAG_PRIVATE:
+		return "private", nil
+	case netlink.IPVLAN_FLAG_VEPA:
+		return "vepa", nil
+	default:
+		return "", fmt.Errorf("unknown ipvlan flag: %q", flag)
+	}
+}
+
 func createIpvlan(conf *NetConf, ifName string, netns ns.NetNS) (*current.Interface, error) {
 	ipvlan := &current.Interface{}
 
@@ -125,6 +180,11 @@ func createIpvlan(conf *NetConf, ifName string, netns ns.NetNS) (*current.Interf
 		return nil, err
 	}
 
+	flag, err := flagFromString(conf.Flag)
+	if err != nil {
+		return nil, err
+	}
+
 	var m netlink.Link
 	if conf.LinkContNs {
 		err = netns.Do(func(_ ns.NetNS) error {
@@ -135,7 +195,7 @@ func createIpvlan(conf *NetConf, ifName string, netns ns.NetNS) (*current.Interf
 		m, err = netlinksafe.LinkByName(conf.Master)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+		return nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup master %q", conf.Master)
 	}
 
 	// due to kernel bug we have to create with tmpname or it might
@@ -154,6 +214,7 @@ func createIpvlan(conf *NetConf, ifName string, netns ns.NetNS) (*current.Interf
 	mv := &netlink.IPVlan{
 		LinkAttrs: linkAttrs,
 		Mode:      mode,
+		Flag:      flag,
 	}
 
 	if conf.LinkContNs {
@@ -215,7 +276,7 @@ func getNamespacedDefaultRouteInterfaceName(namespace string, inContainer bool)
 	}
 	netns, err := ns.GetNS(namespace)
 	if err != nil {
-		return "", fmt.Errorf("failed to open netns %q: %v", netns, err)
+		return "", cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", namespace)
 	}
 	defer netns.Close()
 	var defaultRouteInterface string
@@ -240,14 +301,38 @@ func cmdAdd(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
-	ipvlanInterface, err := createIpvlan(n, args.IfName, netns)
-	if err != nil {
-		return err
+	var ipvlanInterface *current.Interface
+	if n.MasterVlan != nil {
+		err = link.AcquireVlanMaster(n.MasterVlan, n.Name, args.ContainerID, args.IfName, func(string) error {
+			var err error
+			ipvlanInterface, err = createIpvlan(n, args.IfName, netns)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		// Delete link and release the masterVlan if err, not to keep it
+		// for a failed attachment
+		defer func() {
+			if err != nil {
+				netns.Do(func(_ ns.NetNS) error {
+					return ip.DelLinkByName(args.IfName)
+				})
+				_ = releaseMasterVlan(n, args)
+			}
+		}()
+	} else {
+		ipvlanInterface, err = createIpvlan(n, args.IfName, netns)
+		if err != nil {
+			return err
+		}
 	}
+	log.Debug("created ipvlan link", "master", n.Master, "mac", ipvlanInterface.Mac)
 
 	var result *current.Result
 	// Configure iface from PrevResult if we have IPs and an IPAM
@@ -285,6 +370,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 		if len(result.IPs) == 0 {
 			return errors.New("IPAM plugin returned missing IP config")
 		}
+		log.Debug("IPAM allocated addresses", "ipam", n.IPAM.Type, "ips", result.IPs)
 	}
 	for _, ipc := range result.IPs {
 		// All addresses belong to the ipvlan interface
@@ -297,7 +383,11 @@ func cmdAdd(args *skel.CmdArgs) error {
 		_, _ = sysctl.Sysctl(fmt.Sprintf("net/ipv4/conf/%s/arp_notify", args.IfName), "1")
 		_, _ = sysctl.Sysctl(fmt.Sprintf("net/ipv6/conf/%s/ndisc_notify", args.IfName), "1")
 
-		return ipam.ConfigureIface(args.IfName, result)
+		opts := ipam.ConfigureIfaceOptions{}
+		if n.ProbeAddressConflicts {
+			opts.ConflictProbeTimeout = ipam.DefaultConflictProbeTimeout
+		}
+		return ipam.ConfigureIfaceWithOptions(args.IfName, result, opts)
 	})
 	if err != nil {
 		return err
@@ -323,7 +413,7 @@ func cmdDel(args *skel.CmdArgs) error {
 	}
 
 	if args.Netns == "" {
-		return nil
+		return releaseMasterVlan(n, args)
 	}
 
 	// There is a netns so try to clean up. Delete can be called multiple times
@@ -341,23 +431,46 @@ func cmdDel(args *skel.CmdArgs) error {
 		// so don't return an error if the device is already removed.
 		// https://github.com/kubernetes/kubernetes/issues/43014#issuecomment-287164444
 		_, ok := err.(ns.NSPathNotExistErr)
-		if ok {
-			return nil
+		if !ok {
+			return err
 		}
+	}
+
+	return releaseMasterVlan(n, args)
+}
+
+// releaseMasterVlan drops the attachment from the users of the masterVlan,
+// if any, once its link is gone.
+func releaseMasterVlan(n *NetConf, args *skel.CmdArgs) error {
+	if n.MasterVlan == nil {
+		return nil
+	}
+	return link.ReleaseVlanMaster(n.MasterVlan, n.Name, args.ContainerID, args.IfName)
+}
+
+// cmdGC drops the attachments on this network that are no longer valid, as
+// their DEL was lost, from the users of the masterVlan, if any, so that it
+// is removed once unused.
+func cmdGC(args *skel.CmdArgs) error {
+	n, _, err := loadConf(args, true)
+	if err != nil {
 		return err
 	}
+	if n.MasterVlan == nil {
+		return nil
+	}
 
-	return err
+	return link.GCVlanMaster(n.MasterVlan, n.Name, gc.NewAttachments(n.ValidAttachments))
 }
 
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
+	skel.PluginMainFuncs(log.Wrap("ipvlan", trace.Wrap("ipvlan", skel.CNIFuncs{
 		Add:    cmdAdd,
 		Check:  cmdCheck,
 		Del:    cmdDel,
 		Status: cmdStatus,
-		/* FIXME GC */
-	}, version.All, bv.BuildString("ipvlan"))
+		GC:     cmdGC,
+	})), version.All, bv.BuildString("ipvlan"))
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
@@ -367,7 +480,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 	}
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
@@ -420,13 +533,13 @@ func cmdCheck(args *skel.CmdArgs) error {
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to lookup master %q: %v", n.Master, err)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup master %q", n.Master)
 	}
 
 	// Check prevResults for ips, routes and dns against values found in the container
 	if err := netns.Do(func(_ ns.NetNS) error {
 		// Check interface against values found in the container
-		err := validateCniContainerInterface(contMap, n.Mode)
+		err := validateCniContainerInterface(contMap, n.Mode, n.Flag)
 		if err != nil {
 			return err
 		}
@@ -448,7 +561,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 	return nil
 }
 
-func validateCniContainerInterface(intf current.Interface, modeExpected string) error {
+func validateCniContainerInterface(intf current.Interface, modeExpected, flagExpected string) error {
 	var link netlink.Link
 	var err error
 
@@ -484,6 +597,22 @@ func validateCniContainerInterface(intf current.Interface, modeExpected string)
 		return fmt.Errorf("Container IPVlan mode %s does not match expected value: %s", currString, confString)
 	}
 
+	flag, err := flagFromString(flagExpected)
+	if err != nil {
+		return err
+	}
+	if ipv.Flag != flag {
+		currString, err := flagToString(ipv.Flag)
+		if err != nil {
+			return err
+		}
+		confString, err := flagToString(flag)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("Container IPVlan flag %s does not match expected value: %s", currString, confString)
+	}
+
 	if intf.Mac != "" {
 		if intf.Mac != link.Attrs().HardwareAddr.String() {
 			return fmt.Errorf("Interface %s Mac %s doesn't match container Mac: %s", intf.Name, intf.Mac, link.Attrs().HardwareAddr)
@@ -498,13 +627,31 @@ func cmdStatus(args *skel.CmdArgs) error {
 	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
 		return fmt.Errorf("failed to load netconf: %w", err)
 	}
+
+	if err := status.Netlink(); err != nil {
+		return err
+	}
+	if err := status.KernelModules("ipvlan"); err != nil {
+		return err
+	}
+	// The master is only known to exist in the host namespace
+	if conf.Master != "" && !conf.LinkContNs {
+		if err := status.Link(conf.Master); err != nil {
+			return err
+		}
+	}
+	// The masterVlan itself may not be created yet
+	if conf.MasterVlan != nil {
+		if err := status.Link(conf.MasterVlan.Parent); err != nil {
+			return err
+		}
+	}
+
 	if conf.IPAM.Type != "" {
 		if err := ipam.ExecStatus(conf.IPAM.Type, args.StdinData); err != nil {
 			return err
 		}
 	}
 
-	// TODO: Check if master interface exists.
-
 	return nil
 }
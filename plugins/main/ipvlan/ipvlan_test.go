@@ -270,6 +270,32 @@ func (t *testerV02x) verifyResult(result types.Result, _ string) string {
 	return ""
 }
 
+var _ = Describe("ipvlan masterVlan config", func() {
+	DescribeTable("loads masterVlan",
+		func(conf string, expectedMaster string, expectedErr string) {
+			n, _, err := loadConf(&skel.CmdArgs{StdinData: []byte(conf)}, false)
+			if expectedErr == "" {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(n.Master).To(Equal(expectedMaster))
+			} else {
+				Expect(err).To(MatchError(expectedErr))
+			}
+		},
+		Entry("as the master",
+			`{"name": "mynet", "type": "ipvlan", "masterVlan": {"parent": "eth0", "id": 42}}`,
+			"eth0.42", ""),
+		Entry("with a master",
+			`{"name": "mynet", "type": "ipvlan", "master": "eth0", "masterVlan": {"parent": "eth0", "id": 42}}`,
+			"", "cannot set master and masterVlan at the same time"),
+		Entry("with linkInContainer",
+			`{"name": "mynet", "type": "ipvlan", "linkInContainer": true, "masterVlan": {"parent": "eth0", "id": 42}}`,
+			"", "masterVlan cannot be used with linkInContainer"),
+		Entry("with an invalid id",
+			`{"name": "mynet", "type": "ipvlan", "masterVlan": {"parent": "eth0"}}`,
+			"", "invalid masterVlan id 0, must be [1, 4094]"),
+	)
+})
+
 var _ = Describe("ipvlan Operations", func() {
 	var originalNS, targetNS ns.NetNS
 	var dataDir string
@@ -376,6 +402,65 @@ var _ = Describe("ipvlan Operations", func() {
 				Expect(err).NotTo(HaveOccurred())
 			})
 
+			It(fmt.Sprintf("[%s] creates an l3s ipvlan link with the private flag", ver), func() {
+				conf := &NetConf{
+					NetConf: types.NetConf{
+						CNIVersion: ver,
+						Name:       "testConfig",
+						Type:       "ipvlan",
+					},
+					Master:     masterInterface,
+					Mode:       "l3s",
+					Flag:       "private",
+					LinkContNs: isInContainer,
+				}
+
+				var ipvlanInterface *types100.Interface
+				err := originalNS.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					var err error
+					ipvlanInterface, err = createIpvlan(conf, "foobar0", targetNS)
+					Expect(err).NotTo(HaveOccurred())
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = targetNS.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					link, err := netlinksafe.LinkByName("foobar0")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(link.(*netlink.IPVlan).Mode).To(Equal(netlink.IPVLAN_MODE_L3S))
+					Expect(link.(*netlink.IPVlan).Flag).To(Equal(netlink.IPVLAN_FLAG_PRIVATE))
+
+					Expect(validateCniContainerInterface(*ipvlanInterface, "l3s", "private")).To(Succeed())
+					Expect(validateCniContainerInterface(*ipvlanInterface, "l3s", "vepa")).To(MatchError(
+						"Container IPVlan flag private does not match expected value: vepa"))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It(fmt.Sprintf("[%s] rejects an unknown ipvlan flag", ver), func() {
+				conf := &NetConf{
+					NetConf: types.NetConf{
+						CNIVersion: ver,
+						Name:       "testConfig",
+						Type:       "ipvlan",
+					},
+					Master:     masterInterface,
+					Flag:       "nope",
+					LinkContNs: isInContainer,
+				}
+
+				err := originalNS.Do(func(ns.NetNS) error {
+					_, err := createIpvlan(conf, "foobar0", targetNS)
+					return err
+				})
+				Expect(err).To(MatchError(`unknown ipvlan flag: "nope"`))
+			})
+
 			It(fmt.Sprintf("[%s] configures and deconfigures an iplvan link with ADD/DEL", ver), func() {
 				conf := fmt.Sprintf(`{
 			    "cniVersion": "%s",
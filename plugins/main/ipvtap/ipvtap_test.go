@@ -0,0 +1,190 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"syscall"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+const MASTER_NAME = "eth0"
+
+var _ = Describe("ipvtap config", func() {
+	It("refuses owner and group with linkInContainer", func() {
+		conf := `{"name": "mynet", "type": "ipvtap", "master": "eth0", "linkInContainer": true, "runtimeConfig": {"owner": 1000}}`
+		_, _, err := loadConf(&skel.CmdArgs{StdinData: []byte(conf)}, false)
+		Expect(err).To(MatchError("owner and group cannot be set with linkInContainer"))
+	})
+})
+
+var _ = Describe("ipvtap Operations", func() {
+	var originalNS, targetNS ns.NetNS
+
+	BeforeEach(func() {
+		// Create a new NetNS so we don't modify the host
+		var err error
+		originalNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		targetNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			linkAttrs := netlink.NewLinkAttrs()
+			linkAttrs.Name = MASTER_NAME
+			// Add master
+			err = netlink.LinkAdd(&netlink.Dummy{
+				LinkAttrs: linkAttrs,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = netlinksafe.LinkByName(MASTER_NAME)
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(originalNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(originalNS)).To(Succeed())
+		Expect(targetNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(targetNS)).To(Succeed())
+	})
+
+	It("configures and deconfigures a chained ipvtap link with its device owned by the VMM user with ADD/CHECK/DEL", func() {
+		const IFNAME = "ipvtap0"
+		dataDir := GinkgoT().TempDir()
+
+		conf := fmt.Sprintf(`{
+		    "cniVersion": "1.0.0",
+		    "name": "mynet",
+		    "type": "ipvtap",
+		    "mode": "l3",
+		    "owner": 1000,
+		    "group": 1001,
+		    "dataDir": %q,
+		    "prevResult": {
+			"interfaces": [{"name": %q}],
+			"ips": [{"address": "10.1.2.2/24", "gateway": "10.1.2.1", "interface": 0}]
+		    }
+		}`, dataDir, MASTER_NAME)
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNS.Path(),
+			IfName:      IFNAME,
+			StdinData:   []byte(conf),
+		}
+
+		var result *types100.Result
+		var devPath string
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			r, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result, err = types100.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(1))
+			Expect(result.Interfaces[0].Name).To(Equal(IFNAME))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// Make sure the ipvtap link and its device exist
+		err = targetNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlinksafe.LinkByName(IFNAME)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link).To(BeAssignableToTypeOf(&netlink.IPVtap{}))
+			Expect(link.(*netlink.IPVtap).Mode).To(Equal(netlink.IPVLAN_MODE_L3))
+
+			addrs, err := netlinksafe.AddrList(link, syscall.AF_INET)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addrs).To(HaveLen(1))
+
+			devPath = fmt.Sprintf("/dev/tap%d", link.Attrs().Index)
+			var st unix.Stat_t
+			Expect(unix.Stat(devPath, &st)).To(Succeed())
+			Expect(st.Mode & unix.S_IFMT).To(Equal(uint32(unix.S_IFCHR)))
+			Expect(st.Uid).To(Equal(uint32(1000)))
+			Expect(st.Gid).To(Equal(uint32(1001)))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		raw, err := json.Marshal(result)
+		Expect(err).NotTo(HaveOccurred())
+		args.StdinData = []byte(fmt.Sprintf(`{
+		    "cniVersion": "1.0.0",
+		    "name": "mynet",
+		    "type": "ipvtap",
+		    "master": %q,
+		    "mode": "l3",
+		    "dataDir": %q,
+		    "prevResult": %s
+		}`, MASTER_NAME, dataDir, raw))
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := testutils.CmdCheckWithArgs(args, func() error {
+				return cmdCheck(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = testutils.CmdDelWithArgs(args, func() error {
+				return cmdDel(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// Make sure ipvtap link has been deleted
+		err = targetNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlinksafe.LinkByName(IFNAME)
+			Expect(err).To(HaveOccurred())
+			Expect(link).To(BeNil())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// along with its device, whether devtmpfs or the plugin created it
+		Expect(devPath).NotTo(BeAnExistingFile())
+		records, err := filepath.Glob(filepath.Join(dataDir, "*.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(BeEmpty())
+	})
+})
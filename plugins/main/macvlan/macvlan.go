@@ -19,34 +19,61 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"reflect"
 	"runtime"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/link"
+	"github.com/containernetworking/plugins/pkg/log"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/trace"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 )
 
 type NetConf struct {
 	types.NetConf
-	Master     string `json:"master"`
-	Mode       string `json:"mode"`
-	MTU        int    `json:"mtu"`
-	Mac        string `json:"mac,omitempty"`
-	LinkContNs bool   `json:"linkInContainer,omitempty"`
-	BcQueueLen uint32 `json:"bcqueuelen,omitempty"`
+	Master string `json:"master"`
+	// MasterSubnet selects as master the interface holding an address in
+	// this subnet, when master is not set.
+	MasterSubnet string `json:"masterSubnet,omitempty"`
+	Mode         string `json:"mode"`
+	MTU          int    `json:"mtu"`
+	Mac          string `json:"mac,omitempty"`
+	LinkContNs   bool   `json:"linkInContainer,omitempty"`
+	BcQueueLen   uint32 `json:"bcqueuelen,omitempty"`
+	// DeterministicMac derives the MAC from the container ID and interface
+	// name, so that it is stable across restarts of the same attachment.
+	DeterministicMac bool `json:"deterministicMac,omitempty"`
+	// SourceMacs are the source MACs allowed in "source" mode
+	SourceMacs []string `json:"sourceMacs,omitempty"`
+	// MasterVlan is a VLAN subinterface to use as master, created on the
+	// first attachment and removed with the last, instead of master or
+	// masterSubnet.
+	MasterVlan *link.VlanMaster `json:"masterVlan,omitempty"`
+	// ProbeAddressConflicts fails ADD when another host of the segment
+	// already uses one of the addresses, as happens with external DHCP
+	// servers.
+	ProbeAddressConflicts bool `json:"probeAddressConflicts,omitempty"`
 
 	RuntimeConfig struct {
-		Mac string `json:"mac,omitempty"`
+		Mac        string   `json:"mac,omitempty"`
+		SourceMacs []string `json:"sourceMacs,omitempty"`
 	} `json:"runtimeConfig,omitempty"`
+
+	sourceMacs []net.HardwareAddr
 }
 
 // MacEnvArgs represents CNI_ARG
@@ -63,14 +90,45 @@ func init() {
 }
 
 func getDefaultRouteInterfaceName() (string, error) {
-	routeToDstIP, err := netlinksafe.RouteList(nil, netlink.FAMILY_ALL)
+	// Prefer the IPv4 default route, falling back to IPv6 on nodes without one
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routeToDstIP, err := netlinksafe.RouteList(nil, family)
+		if err != nil {
+			return "", err
+		}
+
+		for _, v := range routeToDstIP {
+			if !ip.IsIPNetZero(v.Dst) || v.Type != unix.RTN_UNICAST {
+				continue
+			}
+			linkIndex := v.LinkIndex
+			if linkIndex == 0 && len(v.MultiPath) > 0 {
+				linkIndex = v.MultiPath[0].LinkIndex
+			}
+			if linkIndex == 0 {
+				continue
+			}
+			l, err := netlink.LinkByIndex(linkIndex)
+			if err != nil {
+				return "", err
+			}
+			return l.Attrs().Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("no default route interface found")
+}
+
+// getSubnetInterfaceName returns the interface holding an address in subnet.
+func getSubnetInterfaceName(subnet *net.IPNet) (string, error) {
+	addrs, err := netlinksafe.AddrList(nil, netlink.FAMILY_ALL)
 	if err != nil {
 		return "", err
 	}
 
-	for _, v := range routeToDstIP {
-		if ip.IsIPNetZero(v.Dst) {
-			l, err := netlink.LinkByIndex(v.LinkIndex)
+	for _, addr := range addrs {
+		if subnet.Contains(addr.IP) {
+			l, err := netlink.LinkByIndex(addr.LinkIndex)
 			if err != nil {
 				return "", err
 			}
@@ -78,21 +136,21 @@ func getDefaultRouteInterfaceName() (string, error) {
 		}
 	}
 
-	return "", fmt.Errorf("no default route interface found")
+	return "", fmt.Errorf("no interface found with an address in %s", subnet)
 }
 
-func getNamespacedDefaultRouteInterfaceName(namespace string, inContainer bool) (string, error) {
+func getNamespacedInterfaceName(namespace string, inContainer bool, getName func() (string, error)) (string, error) {
 	if !inContainer {
-		return getDefaultRouteInterfaceName()
+		return getName()
 	}
 	netns, err := ns.GetNS(namespace)
 	if err != nil {
-		return "", fmt.Errorf("failed to open netns %q: %v", netns, err)
+		return "", cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", namespace)
 	}
 	defer netns.Close()
-	var defaultRouteInterface string
+	var name string
 	err = netns.Do(func(_ ns.NetNS) error {
-		defaultRouteInterface, err = getDefaultRouteInterfaceName()
+		name, err = getName()
 		if err != nil {
 			return err
 		}
@@ -101,24 +159,58 @@ func getNamespacedDefaultRouteInterfaceName(namespace string, inContainer bool)
 	if err != nil {
 		return "", err
 	}
-	return defaultRouteInterface, nil
+	return name, nil
 }
 
 func loadConf(args *skel.CmdArgs, envArgs string) (*NetConf, string, error) {
+	defer trace.Start("parseConfig").End()
+
 	n := &NetConf{}
 	if err := json.Unmarshal(args.StdinData, n); err != nil {
 		return nil, "", fmt.Errorf("failed to load netconf: %v", err)
 	}
+	if n.Master != "" && n.MasterSubnet != "" {
+		return nil, "", fmt.Errorf("cannot set master and masterSubnet at the same time")
+	}
+	// The masterVlan may not be created yet, so its MTU is its parent's
+	mtuMaster := ""
+	if n.MasterVlan != nil {
+		if n.Master != "" || n.MasterSubnet != "" {
+			return nil, "", fmt.Errorf("cannot set masterVlan with master or masterSubnet")
+		}
+		if n.LinkContNs {
+			return nil, "", fmt.Errorf("masterVlan cannot be used with linkInContainer")
+		}
+		if err := n.MasterVlan.Validate(); err != nil {
+			return nil, "", err
+		}
+		n.Master = n.MasterVlan.Name()
+		mtuMaster = n.MasterVlan.Parent
+	}
 	if n.Master == "" {
-		defaultRouteInterface, err := getNamespacedDefaultRouteInterfaceName(args.Netns, n.LinkContNs)
+		getName := getDefaultRouteInterfaceName
+		if n.MasterSubnet != "" {
+			_, subnet, err := net.ParseCIDR(n.MasterSubnet)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid masterSubnet %q: %v", n.MasterSubnet, err)
+			}
+			getName = func() (string, error) {
+				return getSubnetInterfaceName(subnet)
+			}
+		}
+		master, err := getNamespacedInterfaceName(args.Netns, n.LinkContNs, getName)
 		if err != nil {
 			return nil, "", err
 		}
-		n.Master = defaultRouteInterface
+		n.Master = master
+	}
+
+	if mtuMaster == "" {
+		mtuMaster = n.Master
 	}
 
 	// check existing and MTU of master interface
-	masterMTU, err := getMTUByName(n.Master, args.Netns, n.LinkContNs)
+	masterMTU, err := getMTUByName(mtuMaster, args.Netns, n.LinkContNs)
 	if err != nil {
 		return nil, "", err
 	}
@@ -142,9 +234,45 @@ func loadConf(args *skel.CmdArgs, envArgs string) (*NetConf, string, error) {
 		n.Mac = n.RuntimeConfig.Mac
 	}
 
+	if n.DeterministicMac {
+		if n.Mac != "" {
+			return nil, "", errors.New("cannot set mac and deterministicMac at the same time")
+		}
+		n.Mac = ip.StableMAC(args.ContainerID, args.IfName).String()
+	}
+
+	if len(n.RuntimeConfig.SourceMacs) > 0 {
+		n.SourceMacs = n.RuntimeConfig.SourceMacs
+	}
+	if err := parseSourceMacs(n); err != nil {
+		return nil, "", err
+	}
+
 	return n, n.CNIVersion, nil
 }
 
+func parseSourceMacs(n *NetConf) error {
+	if n.Mode != "source" {
+		if len(n.SourceMacs) > 0 {
+			return fmt.Errorf("sourceMacs is only supported in source mode")
+		}
+		return nil
+	}
+	if len(n.SourceMacs) == 0 {
+		return fmt.Errorf("source mode requires sourceMacs")
+	}
+
+	n.sourceMacs = make([]net.HardwareAddr, 0, len(n.SourceMacs))
+	for _, mac := range n.SourceMacs {
+		addr, err := net.ParseMAC(mac)
+		if err != nil {
+			return fmt.Errorf("invalid source MAC %q: %v", mac, err)
+		}
+		n.sourceMacs = append(n.sourceMacs, addr)
+	}
+	return nil
+}
+
 func getMTUByName(ifName string, namespace string, inContainer bool) (int, error) {
 	var link netlink.Link
 	var err error
@@ -152,7 +280,7 @@ func getMTUByName(ifName string, namespace string, inContainer bool) (int, error
 		var netns ns.NetNS
 		netns, err = ns.GetNS(namespace)
 		if err != nil {
-			return 0, fmt.Errorf("failed to open netns %q: %v", netns, err)
+			return 0, cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", namespace)
 		}
 		defer netns.Close()
 
@@ -179,6 +307,8 @@ func modeFromString(s string) (netlink.MacvlanMode, error) {
 		return netlink.MACVLAN_MODE_VEPA, nil
 	case "passthru":
 		return netlink.MACVLAN_MODE_PASSTHRU, nil
+	case "source":
+		return netlink.MACVLAN_MODE_SOURCE, nil
 	default:
 		return 0, fmt.Errorf("unknown macvlan mode: %q", s)
 	}
@@ -194,6 +324,8 @@ func modeToString(mode netlink.MacvlanMode) (string, error) {
 		return "vepa", nil
 	case netlink.MACVLAN_MODE_PASSTHRU:
 		return "passthru", nil
+	case netlink.MACVLAN_MODE_SOURCE:
+		return "source", nil
 	default:
 		return "", fmt.Errorf("unknown macvlan mode: %q", mode)
 	}
@@ -217,7 +349,7 @@ func createMacvlan(conf *NetConf, ifName string, netns ns.NetNS) (*current.Inter
 		m, err = netlinksafe.LinkByName(conf.Master)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+		return nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup master %q", conf.Master)
 	}
 
 	// due to kernel bug we have to create with tmpName or it might
@@ -274,6 +406,13 @@ func createMacvlan(conf *NetConf, ifName string, netns ns.NetNS) (*current.Inter
 		if err != nil {
 			return fmt.Errorf("failed to refetch macvlan %q: %v", ifName, err)
 		}
+
+		if mode == netlink.MACVLAN_MODE_SOURCE {
+			if err := netlink.MacvlanMACAddrSet(contMacvlan, conf.sourceMacs); err != nil {
+				_ = netlink.LinkDel(contMacvlan)
+				return fmt.Errorf("failed to set source MACs of macvlan %q: %v", ifName, err)
+			}
+		}
 		macvlan.Mac = contMacvlan.Attrs().HardwareAddr.String()
 		macvlan.Sandbox = netns.Path()
 
@@ -296,14 +435,24 @@ func cmdAdd(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
-	macvlanInterface, err := createMacvlan(n, args.IfName, netns)
+	var macvlanInterface *current.Interface
+	if n.MasterVlan != nil {
+		err = link.AcquireVlanMaster(n.MasterVlan, n.Name, args.ContainerID, args.IfName, func(string) error {
+			var err error
+			macvlanInterface, err = createMacvlan(n, args.IfName, netns)
+			return err
+		})
+	} else {
+		macvlanInterface, err = createMacvlan(n, args.IfName, netns)
+	}
 	if err != nil {
 		return err
 	}
+	log.Debug("created macvlan link", "master", n.Master, "mac", macvlanInterface.Mac)
 
 	// Delete link if err to avoid link leak in this ns
 	defer func() {
@@ -311,6 +460,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 			netns.Do(func(_ ns.NetNS) error {
 				return ip.DelLinkByName(args.IfName)
 			})
+			_ = releaseMasterVlan(n, args)
 		}
 	}()
 
@@ -343,6 +493,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 		if len(ipamResult.IPs) == 0 {
 			return errors.New("IPAM plugin returned missing IP config")
 		}
+		log.Debug("IPAM allocated addresses", "ipam", n.IPAM.Type, "ips", ipamResult.IPs)
 
 		result.IPs = ipamResult.IPs
 		result.Routes = ipamResult.Routes
@@ -356,7 +507,11 @@ func cmdAdd(args *skel.CmdArgs) error {
 			_, _ = sysctl.Sysctl(fmt.Sprintf("net/ipv4/conf/%s/arp_notify", args.IfName), "1")
 			_, _ = sysctl.Sysctl(fmt.Sprintf("net/ipv6/conf/%s/ndisc_notify", args.IfName), "1")
 
-			return ipam.ConfigureIface(args.IfName, result)
+			opts := ipam.ConfigureIfaceOptions{}
+			if n.ProbeAddressConflicts {
+				opts.ConflictProbeTimeout = ipam.DefaultConflictProbeTimeout
+			}
+			return ipam.ConfigureIfaceWithOptions(args.IfName, result, opts)
 		})
 		if err != nil {
 			return err
@@ -401,7 +556,7 @@ func cmdDel(args *skel.CmdArgs) error {
 	}
 
 	if args.Netns == "" {
-		return nil
+		return releaseMasterVlan(&n, args)
 	}
 
 	// There is a netns so try to clean up. Delete can be called multiple times
@@ -419,23 +574,49 @@ func cmdDel(args *skel.CmdArgs) error {
 		// so don't return an error if the device is already removed.
 		// https://github.com/kubernetes/kubernetes/issues/43014#issuecomment-287164444
 		_, ok := err.(ns.NSPathNotExistErr)
-		if ok {
-			return nil
+		if !ok {
+			return err
 		}
+	}
+
+	return releaseMasterVlan(&n, args)
+}
+
+// releaseMasterVlan drops the attachment from the users of the masterVlan,
+// if any, once its link is gone.
+func releaseMasterVlan(n *NetConf, args *skel.CmdArgs) error {
+	if n.MasterVlan == nil {
+		return nil
+	}
+	return link.ReleaseVlanMaster(n.MasterVlan, n.Name, args.ContainerID, args.IfName)
+}
+
+// cmdGC drops the attachments on this network that are no longer valid, as
+// their DEL was lost, from the users of the masterVlan, if any, so that it
+// is removed once unused.
+func cmdGC(args *skel.CmdArgs) error {
+	n := NetConf{}
+	if err := json.Unmarshal(args.StdinData, &n); err != nil {
+		return fmt.Errorf("failed to load netconf: %v", err)
+	}
+	if n.MasterVlan == nil {
+		return nil
+	}
+	if err := n.MasterVlan.Validate(); err != nil {
 		return err
 	}
 
-	return err
+	return link.GCVlanMaster(n.MasterVlan, n.Name, gc.NewAttachments(n.ValidAttachments))
 }
 
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
+	skel.PluginMainFuncs(log.Wrap("macvlan", trace.Wrap("macvlan", skel.CNIFuncs{
 		Add:    cmdAdd,
 		Check:  cmdCheck,
 		Del:    cmdDel,
 		Status: cmdStatus,
-		/* FIXME GC */
-	}, version.All, bv.BuildString("macvlan"))
+		GC:     cmdGC,
+	})), version.All, bv.BuildString("macvlan"))
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
@@ -447,7 +628,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
@@ -499,13 +680,13 @@ func cmdCheck(args *skel.CmdArgs) error {
 		_, err = netlinksafe.LinkByName(n.Master)
 	}
 	if err != nil {
-		return fmt.Errorf("failed to lookup master %q: %v", n.Master, err)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup master %q", n.Master)
 	}
 
 	// Check prevResults for ips, routes and dns against values found in the container
 	if err := netns.Do(func(_ ns.NetNS) error {
 		// Check interface against values found in the container
-		err := validateCniContainerInterface(contMap, n.Mode)
+		err := validateCniContainerInterface(contMap, n.Mode, n.sourceMacs)
 		if err != nil {
 			return err
 		}
@@ -527,7 +708,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 	return nil
 }
 
-func validateCniContainerInterface(intf current.Interface, modeExpected string) error {
+func validateCniContainerInterface(intf current.Interface, modeExpected string, sourceMacsExpected []net.HardwareAddr) error {
 	var link netlink.Link
 	var err error
 
@@ -563,6 +744,20 @@ func validateCniContainerInterface(intf current.Interface, modeExpected string)
 		return fmt.Errorf("container macvlan mode %s does not match expected value: %s", currString, confString)
 	}
 
+	if mode == netlink.MACVLAN_MODE_SOURCE {
+		expected := map[string]bool{}
+		for _, mac := range sourceMacsExpected {
+			expected[mac.String()] = true
+		}
+		actual := map[string]bool{}
+		for _, mac := range macv.MACAddrs {
+			actual[mac.String()] = true
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			return fmt.Errorf("container macvlan source MACs %v do not match expected value: %v", macv.MACAddrs, sourceMacsExpected)
+		}
+	}
+
 	if intf.Mac != "" {
 		if intf.Mac != link.Attrs().HardwareAddr.String() {
 			return fmt.Errorf("interface %s Mac %s doesn't match container Mac: %s", intf.Name, intf.Mac, link.Attrs().HardwareAddr)
@@ -578,13 +773,30 @@ func cmdStatus(args *skel.CmdArgs) error {
 		return fmt.Errorf("failed to load netconf: %w", err)
 	}
 
+	if err := status.Netlink(); err != nil {
+		return err
+	}
+	if err := status.KernelModules("macvlan"); err != nil {
+		return err
+	}
+	// The master is only known to exist in the host namespace
+	if conf.Master != "" && !conf.LinkContNs {
+		if err := status.Link(conf.Master); err != nil {
+			return err
+		}
+	}
+	// The masterVlan itself may not be created yet
+	if conf.MasterVlan != nil {
+		if err := status.Link(conf.MasterVlan.Parent); err != nil {
+			return err
+		}
+	}
+
 	if conf.IPAM.Type != "" {
 		if err := ipam.ExecStatus(conf.IPAM.Type, args.StdinData); err != nil {
 			return err
 		}
 	}
 
-	// TODO: Check if master interface exists.
-
 	return nil
 }
@@ -31,6 +31,7 @@ import (
 	types020 "github.com/containernetworking/cni/pkg/types/020"
 	types040 "github.com/containernetworking/cni/pkg/types/040"
 	types100 "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
@@ -191,6 +192,101 @@ func (t *testerV01xOr02x) verifyResult(result types.Result, err error, _ string,
 	return ""
 }
 
+var _ = Describe("macvlan source mode config", func() {
+	DescribeTable("validates sourceMacs",
+		func(mode string, sourceMacs []string, expectedErr string) {
+			n := &NetConf{Mode: mode, SourceMacs: sourceMacs}
+			err := parseSourceMacs(n)
+			if expectedErr == "" {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(n.sourceMacs).To(HaveLen(len(sourceMacs)))
+			} else {
+				Expect(err).To(MatchError(expectedErr))
+			}
+		},
+		Entry("source mode with MACs", "source", []string{"02:00:00:00:00:01"}, ""),
+		Entry("source mode without MACs", "source", nil, "source mode requires sourceMacs"),
+		Entry("MACs in bridge mode", "bridge", []string{"02:00:00:00:00:01"}, "sourceMacs is only supported in source mode"),
+		Entry("invalid MAC", "source", []string{"nope"}, `invalid source MAC "nope": address nope: invalid MAC address`),
+	)
+})
+
+var _ = Describe("macvlan deterministicMac config", func() {
+	load := func(containerID, ifName, extra string) (*NetConf, error) {
+		n, _, err := loadConf(&skel.CmdArgs{
+			ContainerID: containerID,
+			IfName:      ifName,
+			StdinData:   []byte(`{"name": "mynet", "type": "macvlan", "master": "lo", "deterministicMac": true` + extra + `}`),
+		}, "")
+		return n, err
+	}
+
+	It("derives the MAC from the container ID and interface name", func() {
+		n, err := load("c1", "eth0", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n.Mac).To(Equal(ip.StableMAC("c1", "eth0").String()))
+
+		other, err := load("c1", "eth1", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Mac).NotTo(Equal(n.Mac))
+	})
+
+	It("refuses an explicit MAC as well", func() {
+		_, err := load("c1", "eth0", `, "mac": "02:00:00:00:00:aa"`)
+		Expect(err).To(MatchError("cannot set mac and deterministicMac at the same time"))
+	})
+})
+
+var _ = Describe("macvlan masterVlan config", func() {
+	DescribeTable("refuses invalid masterVlan configurations",
+		func(conf string, expectedErr string) {
+			_, _, err := loadConf(&skel.CmdArgs{StdinData: []byte(conf)}, "")
+			Expect(err).To(MatchError(expectedErr))
+		},
+		Entry("with a master",
+			`{"name": "mynet", "type": "macvlan", "master": "eth0", "masterVlan": {"parent": "eth0", "id": 42}}`,
+			"cannot set masterVlan with master or masterSubnet"),
+		Entry("with masterSubnet",
+			`{"name": "mynet", "type": "macvlan", "masterSubnet": "10.0.0.0/8", "masterVlan": {"parent": "eth0", "id": 42}}`,
+			"cannot set masterVlan with master or masterSubnet"),
+		Entry("with linkInContainer",
+			`{"name": "mynet", "type": "macvlan", "linkInContainer": true, "masterVlan": {"parent": "eth0", "id": 42}}`,
+			"masterVlan cannot be used with linkInContainer"),
+		Entry("without a parent",
+			`{"name": "mynet", "type": "macvlan", "masterVlan": {"id": 42}}`,
+			"masterVlan parent is required"),
+	)
+})
+
+var _ = Describe("macvlan STATUS", func() {
+	It("reports a missing master as not available", func() {
+		hostNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			Expect(hostNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(hostNS)).To(Succeed())
+		}()
+
+		conf := `{"cniVersion": "1.1.0", "name": "test", "type": "macvlan", "master": %q%s}`
+		err = hostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := testutils.CmdStatus(func() error {
+				return cmdStatus(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(conf, "missing0", ""))})
+			})
+			Expect(err).To(BeAssignableToTypeOf(&types.Error{}))
+			Expect(err.(*types.Error).Code).To(Equal(uint(50)))
+			Expect(err.(*types.Error).Msg).To(Equal(`link "missing0" is not available`))
+
+			// The master of linkInContainer is not in this namespace
+			return testutils.CmdStatus(func() error {
+				return cmdStatus(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(conf, "missing0", `, "linkInContainer": true`))})
+			})
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
 var _ = Describe("macvlan Operations", func() {
 	var originalNS, targetNS ns.NetNS
 	var dataDir string
@@ -296,6 +392,96 @@ var _ = Describe("macvlan Operations", func() {
 				Expect(err).NotTo(HaveOccurred())
 			})
 
+			It(fmt.Sprintf("[%s] creates a source mode macvlan link with the allowed MACs", ver), func() {
+				args := &skel.CmdArgs{
+					Netns: targetNS.Path(),
+					StdinData: []byte(fmt.Sprintf(`{
+						"cniVersion": "%s",
+						"name": "testConfig",
+						"type": "macvlan",
+						"master": "%s",
+						%s
+						"mode": "source",
+						"sourceMacs": ["02:00:00:00:00:01"],
+						"runtimeConfig": {"sourceMacs": ["02:00:00:00:00:02", "02:00:00:00:00:03"]}
+					}`, ver, masterInterface, linkInContainer)),
+				}
+
+				var macvlanInterface *types100.Interface
+				err := originalNS.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					conf, _, err := loadConf(args, "")
+					Expect(err).NotTo(HaveOccurred())
+					macvlanInterface, err = createMacvlan(conf, "foobar0", targetNS)
+					Expect(err).NotTo(HaveOccurred())
+
+					return targetNS.Do(func(ns.NetNS) error {
+						defer GinkgoRecover()
+
+						link, err := netlinksafe.LinkByName("foobar0")
+						Expect(err).NotTo(HaveOccurred())
+						Expect(link.(*netlink.Macvlan).Mode).To(Equal(netlink.MACVLAN_MODE_SOURCE))
+						Expect(link.(*netlink.Macvlan).MACAddrs).To(ConsistOf(
+							net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02},
+							net.HardwareAddr{0x02, 0, 0, 0, 0, 0x03},
+						))
+
+						Expect(validateCniContainerInterface(*macvlanInterface, "source", conf.sourceMacs)).To(Succeed())
+						Expect(validateCniContainerInterface(*macvlanInterface, "source", conf.sourceMacs[:1])).To(HaveOccurred())
+						return nil
+					})
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It(fmt.Sprintf("[%s] selects the master by masterSubnet", ver), func() {
+				masterNS := originalNS
+				if isInContainer != nil && *isInContainer {
+					masterNS = targetNS
+				}
+				err := masterNS.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					link, err := netlinksafe.LinkByName(masterInterface)
+					Expect(err).NotTo(HaveOccurred())
+					addr, err := netlink.ParseAddr("10.20.1.5/24")
+					Expect(err).NotTo(HaveOccurred())
+					return netlink.AddrAdd(link, addr)
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				confFmt := `{
+					"cniVersion": "%s",
+					"name": "testConfig",
+					"type": "macvlan",
+					%s
+					%s
+					"mode": "bridge"
+				}`
+				args := &skel.CmdArgs{
+					Netns:     targetNS.Path(),
+					StdinData: []byte(fmt.Sprintf(confFmt, ver, `"masterSubnet": "10.20.0.0/16",`, linkInContainer)),
+				}
+				err = originalNS.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					conf, _, err := loadConf(args, "")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(conf.Master).To(Equal(masterInterface))
+
+					args.StdinData = []byte(fmt.Sprintf(confFmt, ver, `"masterSubnet": "10.30.0.0/16",`, linkInContainer))
+					_, _, err = loadConf(args, "")
+					Expect(err).To(MatchError("no interface found with an address in 10.30.0.0/16"))
+
+					args.StdinData = []byte(fmt.Sprintf(confFmt, ver, fmt.Sprintf(`"master": %q, "masterSubnet": "10.20.0.0/16",`, masterInterface), linkInContainer))
+					_, _, err = loadConf(args, "")
+					Expect(err).To(MatchError("cannot set master and masterSubnet at the same time"))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
 			It(fmt.Sprintf("[%s] configures and deconfigures a macvlan link with ADD/DEL", ver), func() {
 				const IFNAME = "macvl0"
 
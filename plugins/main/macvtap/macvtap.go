@@ -0,0 +1,701 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/log"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/statestore"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/trace"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+)
+
+const defaultDataDir = "/run/cni/macvtap"
+
+type NetConf struct {
+	types.NetConf
+	Master     string `json:"master"`
+	Mode       string `json:"mode"`
+	MTU        int    `json:"mtu"`
+	Mac        string `json:"mac,omitempty"`
+	LinkContNs bool   `json:"linkInContainer,omitempty"`
+	// Owner and Group of the tap character device, for the VMM that opens it
+	Owner *uint32 `json:"owner,omitempty"`
+	Group *uint32 `json:"group,omitempty"`
+	// DataDir is where the tap devices created by the plugin are recorded,
+	// to be removed on DEL
+	DataDir string `json:"dataDir,omitempty"`
+
+	RuntimeConfig struct {
+		Mac   string  `json:"mac,omitempty"`
+		Owner *uint32 `json:"owner,omitempty"`
+		Group *uint32 `json:"group,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+}
+
+// MacEnvArgs represents CNI_ARG
+type MacEnvArgs struct {
+	types.CommonArgs
+	MAC types.UnmarshallableString `json:"mac,omitempty"`
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func getDefaultRouteInterfaceName() (string, error) {
+	// Prefer the IPv4 default route, falling back to IPv6 on nodes without one
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routeToDstIP, err := netlinksafe.RouteList(nil, family)
+		if err != nil {
+			return "", err
+		}
+
+		for _, v := range routeToDstIP {
+			if !ip.IsIPNetZero(v.Dst) || v.Type != unix.RTN_UNICAST {
+				continue
+			}
+			linkIndex := v.LinkIndex
+			if linkIndex == 0 && len(v.MultiPath) > 0 {
+				linkIndex = v.MultiPath[0].LinkIndex
+			}
+			if linkIndex == 0 {
+				continue
+			}
+			l, err := netlink.LinkByIndex(linkIndex)
+			if err != nil {
+				return "", err
+			}
+			return l.Attrs().Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("no default route interface found")
+}
+
+func getNamespacedDefaultRouteInterfaceName(namespace string, inContainer bool) (string, error) {
+	if !inContainer {
+		return getDefaultRouteInterfaceName()
+	}
+	netns, err := ns.GetNS(namespace)
+	if err != nil {
+		return "", cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", namespace)
+	}
+	defer netns.Close()
+	var name string
+	err = netns.Do(func(_ ns.NetNS) error {
+		name, err = getDefaultRouteInterfaceName()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func loadConf(args *skel.CmdArgs, envArgs string) (*NetConf, string, error) {
+	defer trace.Start("parseConfig").End()
+
+	n := &NetConf{}
+	if err := json.Unmarshal(args.StdinData, n); err != nil {
+		return nil, "", fmt.Errorf("failed to load netconf: %v", err)
+	}
+
+	if n.RuntimeConfig.Owner != nil {
+		n.Owner = n.RuntimeConfig.Owner
+	}
+	if n.RuntimeConfig.Group != nil {
+		n.Group = n.RuntimeConfig.Group
+	}
+	// The tap device is only reachable through sysfs while the link is on
+	// the host
+	if n.LinkContNs && (n.Owner != nil || n.Group != nil) {
+		return nil, "", fmt.Errorf("owner and group cannot be set with linkInContainer")
+	}
+
+	if _, err := modeFromString(n.Mode); err != nil {
+		return nil, "", err
+	}
+
+	if n.Master == "" {
+		master, err := getNamespacedDefaultRouteInterfaceName(args.Netns, n.LinkContNs)
+		if err != nil {
+			return nil, "", err
+		}
+		n.Master = master
+	}
+
+	// check existing and MTU of master interface
+	masterMTU, err := getMTUByName(n.Master, args.Netns, n.LinkContNs)
+	if err != nil {
+		return nil, "", err
+	}
+	if n.MTU < 0 || n.MTU > masterMTU {
+		return nil, "", fmt.Errorf("invalid MTU %d, must be [0, master MTU(%d)]", n.MTU, masterMTU)
+	}
+
+	if envArgs != "" {
+		e := MacEnvArgs{}
+		err := types.LoadArgs(envArgs, &e)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if e.MAC != "" {
+			n.Mac = string(e.MAC)
+		}
+	}
+
+	if n.RuntimeConfig.Mac != "" {
+		n.Mac = n.RuntimeConfig.Mac
+	}
+
+	return n, n.CNIVersion, nil
+}
+
+func getMTUByName(ifName string, namespace string, inContainer bool) (int, error) {
+	var link netlink.Link
+	var err error
+	if inContainer {
+		var netns ns.NetNS
+		netns, err = ns.GetNS(namespace)
+		if err != nil {
+			return 0, cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", namespace)
+		}
+		defer netns.Close()
+
+		err = netns.Do(func(_ ns.NetNS) error {
+			link, err = netlinksafe.LinkByName(ifName)
+			return err
+		})
+	} else {
+		link, err = netlinksafe.LinkByName(ifName)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return link.Attrs().MTU, nil
+}
+
+func modeFromString(s string) (netlink.MacvlanMode, error) {
+	switch s {
+	case "", "bridge":
+		return netlink.MACVLAN_MODE_BRIDGE, nil
+	case "private":
+		return netlink.MACVLAN_MODE_PRIVATE, nil
+	case "vepa":
+		return netlink.MACVLAN_MODE_VEPA, nil
+	case "passthru":
+		return netlink.MACVLAN_MODE_PASSTHRU, nil
+	default:
+		return 0, fmt.Errorf("unknown macvtap mode: %q", s)
+	}
+}
+
+func modeToString(mode netlink.MacvlanMode) (string, error) {
+	switch mode {
+	case netlink.MACVLAN_MODE_BRIDGE:
+		return "bridge", nil
+	case netlink.MACVLAN_MODE_PRIVATE:
+		return "private", nil
+	case netlink.MACVLAN_MODE_VEPA:
+		return "vepa", nil
+	case netlink.MACVLAN_MODE_PASSTHRU:
+		return "passthru", nil
+	default:
+		return "", fmt.Errorf("unknown macvtap mode: %q", mode)
+	}
+}
+
+func createMacvtap(conf *NetConf, ifName string, netns ns.NetNS) (*current.Interface, *ip.TapDevice, error) {
+	macvtap := &current.Interface{}
+
+	var tap *ip.TapDevice
+	mode, err := modeFromString(conf.Mode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var m netlink.Link
+	if conf.LinkContNs {
+		err = netns.Do(func(_ ns.NetNS) error {
+			m, err = netlinksafe.LinkByName(conf.Master)
+			return err
+		})
+	} else {
+		m, err = netlinksafe.LinkByName(conf.Master)
+	}
+	if err != nil {
+		return nil, nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup master %q", conf.Master)
+	}
+
+	// due to kernel bug we have to create with tmpName or it might
+	// collide with the name on the host and error out
+	tmpName, err := ip.RandomVethName()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.MTU = conf.MTU
+	linkAttrs.Name = tmpName
+	linkAttrs.ParentIndex = m.Attrs().Index
+
+	if conf.Mac != "" {
+		addr, err := net.ParseMAC(conf.Mac)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid args %v for MAC addr: %v", conf.Mac, err)
+		}
+		linkAttrs.HardwareAddr = addr
+	}
+
+	mv := &netlink.Macvtap{
+		Macvlan: netlink.Macvlan{
+			LinkAttrs: linkAttrs,
+			Mode:      mode,
+		},
+	}
+
+	if conf.LinkContNs {
+		err = netns.Do(func(_ ns.NetNS) error {
+			return netlink.LinkAdd(mv)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create macvtap: %v", err)
+		}
+	} else {
+		// The link is created on the host, so that its tap device can be
+		// set up through sysfs, then moved to the container
+		if err := netlink.LinkAdd(mv); err != nil {
+			return nil, nil, fmt.Errorf("failed to create macvtap: %v", err)
+		}
+		tap, err = ip.SetupTapDevice(tmpName, conf.Owner, conf.Group)
+		if err != nil {
+			_ = netlink.LinkDel(mv)
+			return nil, nil, err
+		}
+		log.Debug("set up macvtap device", "path", tap.Path, "created", tap.Created)
+		if err := netlink.LinkSetNsFd(mv, int(netns.Fd())); err != nil {
+			_ = netlink.LinkDel(mv)
+			_ = ip.RemoveTapDevice(tap)
+			return nil, nil, fmt.Errorf("failed to move macvtap %q to netns: %v", tmpName, err)
+		}
+	}
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		err := ip.RenameLink(tmpName, ifName)
+		if err != nil {
+			_ = ip.DelLinkByName(tmpName)
+			return fmt.Errorf("failed to rename macvtap to %q: %v", ifName, err)
+		}
+		macvtap.Name = ifName
+
+		// Re-fetch macvtap to get all properties/attributes
+		contMacvtap, err := netlinksafe.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to refetch macvtap %q: %v", ifName, err)
+		}
+		macvtap.Mac = contMacvtap.Attrs().HardwareAddr.String()
+		macvtap.Sandbox = netns.Path()
+
+		return nil
+	})
+	if err != nil {
+		if tap != nil {
+			_ = ip.RemoveTapDevice(tap)
+		}
+		return nil, nil, err
+	}
+
+	return macvtap, tap, nil
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, cniVersion, err := loadConf(args, args.Args)
+	if err != nil {
+		return err
+	}
+
+	isLayer3 := n.IPAM.Type != ""
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	macvtapInterface, tap, err := createMacvtap(n, args.IfName, netns)
+	if err != nil {
+		return err
+	}
+	log.Debug("created macvtap link", "master", n.Master, "mac", macvtapInterface.Mac)
+
+	// Delete link if err to avoid link leak in this ns
+	defer func() {
+		if err != nil {
+			netns.Do(func(_ ns.NetNS) error {
+				return ip.DelLinkByName(args.IfName)
+			})
+		}
+	}()
+
+	if tap != nil && tap.Created {
+		if err = tapStore(n).Save(tapKey(args), tap); err != nil {
+			_ = ip.RemoveTapDevice(tap)
+			return err
+		}
+	}
+
+	// Assume L2 interface only
+	result := &current.Result{
+		CNIVersion: current.ImplementedSpecVersion,
+		Interfaces: []*current.Interface{macvtapInterface},
+	}
+
+	if isLayer3 {
+		// run the IPAM plugin and get back the config to apply
+		r, err := ipam.ExecAdd(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+
+		// Invoke ipam del if err to avoid ip leak
+		defer func() {
+			if err != nil {
+				ipam.ExecDel(n.IPAM.Type, args.StdinData)
+			}
+		}()
+
+		// Convert whatever the IPAM result was into the current Result type
+		ipamResult, err := current.NewResultFromResult(r)
+		if err != nil {
+			return err
+		}
+
+		if len(ipamResult.IPs) == 0 {
+			return errors.New("IPAM plugin returned missing IP config")
+		}
+		log.Debug("IPAM allocated addresses", "ipam", n.IPAM.Type, "ips", ipamResult.IPs)
+
+		result.IPs = ipamResult.IPs
+		result.Routes = ipamResult.Routes
+
+		for _, ipc := range result.IPs {
+			// All addresses apply to the container macvtap interface
+			ipc.Interface = current.Int(0)
+		}
+
+		err = netns.Do(func(_ ns.NetNS) error {
+			_, _ = sysctl.Sysctl(fmt.Sprintf("net/ipv4/conf/%s/arp_notify", args.IfName), "1")
+			_, _ = sysctl.Sysctl(fmt.Sprintf("net/ipv6/conf/%s/ndisc_notify", args.IfName), "1")
+
+			return ipam.ConfigureIface(args.IfName, result)
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		// For L2 just change interface status to up
+		err = netns.Do(func(_ ns.NetNS) error {
+			macvtapInterfaceLink, err := netlinksafe.LinkByName(args.IfName)
+			if err != nil {
+				return fmt.Errorf("failed to find interface name %q: %v", macvtapInterface.Name, err)
+			}
+
+			if err := netlink.LinkSetUp(macvtapInterfaceLink); err != nil {
+				return fmt.Errorf("failed to set %q UP: %v", args.IfName, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	result.DNS = n.DNS
+
+	return types.PrintResult(result, cniVersion)
+}
+
+func tapStore(conf *NetConf) *statestore.Store {
+	dir := conf.DataDir
+	if dir == "" {
+		dir = defaultDataDir
+	}
+	return statestore.New(dir, conf.Name)
+}
+
+func tapKey(args *skel.CmdArgs) statestore.Key {
+	return statestore.Key{ContainerID: args.ContainerID, IfName: args.IfName}
+}
+
+// removeTapDevice removes the tap device of the attachment of args, if the
+// plugin created it.
+func removeTapDevice(conf *NetConf, args *skel.CmdArgs) error {
+	store := tapStore(conf)
+	tap := &ip.TapDevice{}
+	found, err := store.Load(tapKey(args), tap)
+	if err != nil || !found {
+		return err
+	}
+	if err := ip.RemoveTapDevice(tap); err != nil {
+		return err
+	}
+	return store.Delete(tapKey(args))
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	var n NetConf
+	err := json.Unmarshal(args.StdinData, &n)
+	if err != nil {
+		return fmt.Errorf("failed to load netConf: %v", err)
+	}
+
+	isLayer3 := n.IPAM.Type != ""
+	if isLayer3 {
+		err = ipam.ExecDel(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The kernel only removes the tap device along with the link if it was
+	// created by devtmpfs
+	if err := removeTapDevice(&n, args); err != nil {
+		return err
+	}
+
+	if args.Netns == "" {
+		return nil
+	}
+
+	// There is a netns so try to clean up. Delete can be called multiple times
+	// so don't return an error if the device is already removed.
+	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		if err := ip.DelLinkByName(args.IfName); err != nil {
+			if err != ip.ErrLinkNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		//  if NetNs is passed down by the Cloud Orchestration Engine, or if it called multiple times
+		// so don't return an error if the device is already removed.
+		// https://github.com/kubernetes/kubernetes/issues/43014#issuecomment-287164444
+		_, ok := err.(ns.NSPathNotExistErr)
+		if ok {
+			return nil
+		}
+		return err
+	}
+
+	return err
+}
+
+func main() {
+	skel.PluginMainFuncs(log.Wrap("macvtap", trace.Wrap("macvtap", skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		Status: cmdStatus,
+		/* FIXME GC */
+	})), version.All, bv.BuildString("macvtap"))
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, _, err := loadConf(args, args.Args)
+	if err != nil {
+		return err
+	}
+	isLayer3 := n.IPAM.Type != ""
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	if isLayer3 {
+		// run the IPAM plugin and get back the config to apply
+		err = ipam.ExecCheck(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse previous result.
+	if n.NetConf.RawPrevResult == nil {
+		return fmt.Errorf("required prevResult missing")
+	}
+
+	if err := version.ParsePrevResult(&n.NetConf); err != nil {
+		return err
+	}
+
+	result, err := current.NewResultFromResult(n.PrevResult)
+	if err != nil {
+		return err
+	}
+
+	var contMap current.Interface
+	// Find interfaces for names whe know, macvtap device name inside container
+	for _, intf := range result.Interfaces {
+		if args.IfName == intf.Name {
+			if args.Netns == intf.Sandbox {
+				contMap = *intf
+				continue
+			}
+		}
+	}
+
+	// The namespace must be the same as what was configured
+	if args.Netns != contMap.Sandbox {
+		return fmt.Errorf("sandbox in prevResult %s doesn't match configured netns: %s",
+			contMap.Sandbox, args.Netns)
+	}
+
+	if n.LinkContNs {
+		err = netns.Do(func(_ ns.NetNS) error {
+			_, err = netlinksafe.LinkByName(n.Master)
+			return err
+		})
+	} else {
+		_, err = netlinksafe.LinkByName(n.Master)
+	}
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup master %q", n.Master)
+	}
+
+	// Check prevResults for ips, routes and dns against values found in the container
+	if err := netns.Do(func(_ ns.NetNS) error {
+		// Check interface against values found in the container
+		err := validateCniContainerInterface(contMap, n.Mode)
+		if err != nil {
+			return err
+		}
+
+		err = ip.ValidateExpectedInterfaceIPs(args.IfName, result.IPs)
+		if err != nil {
+			return err
+		}
+
+		err = ip.ValidateExpectedRoute(result.Routes)
+		if err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func validateCniContainerInterface(intf current.Interface, modeExpected string) error {
+	var link netlink.Link
+	var err error
+
+	if intf.Name == "" {
+		return fmt.Errorf("container interface name missing in prevResult: %v", intf.Name)
+	}
+	link, err = netlinksafe.LinkByName(intf.Name)
+	if err != nil {
+		return fmt.Errorf("container Interface name in prevResult: %s not found", intf.Name)
+	}
+	if intf.Sandbox == "" {
+		return fmt.Errorf("error: Container interface %s should not be in host namespace", link.Attrs().Name)
+	}
+
+	macv, isMacvtap := link.(*netlink.Macvtap)
+	if !isMacvtap {
+		return fmt.Errorf("error: Container interface %s not of type macvtap", link.Attrs().Name)
+	}
+
+	mode, err := modeFromString(modeExpected)
+	if err != nil {
+		return err
+	}
+	if macv.Mode != mode {
+		currString, err := modeToString(macv.Mode)
+		if err != nil {
+			return err
+		}
+		confString, err := modeToString(mode)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("container macvtap mode %s does not match expected value: %s", currString, confString)
+	}
+
+	if intf.Mac != "" {
+		if intf.Mac != link.Attrs().HardwareAddr.String() {
+			return fmt.Errorf("interface %s Mac %s doesn't match container Mac: %s", intf.Name, intf.Mac, link.Attrs().HardwareAddr)
+		}
+	}
+
+	return nil
+}
+
+func cmdStatus(args *skel.CmdArgs) error {
+	conf := NetConf{}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("failed to load netconf: %w", err)
+	}
+
+	if err := status.Netlink(); err != nil {
+		return err
+	}
+	if err := status.KernelModules("macvtap"); err != nil {
+		return err
+	}
+	// The master is only known to exist in the host namespace
+	if conf.Master != "" && !conf.LinkContNs {
+		if err := status.Link(conf.Master); err != nil {
+			return err
+		}
+	}
+
+	if conf.IPAM.Type != "" {
+		if err := ipam.ExecStatus(conf.IPAM.Type, args.StdinData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+const (
+	MASTER_NAME             = "eth0"
+	MASTER_NAME_INCONTAINER = "eth1"
+)
+
+var _ = Describe("macvtap config", func() {
+	DescribeTable("rejects invalid configurations",
+		func(conf string, expectedErr string) {
+			_, _, err := loadConf(&skel.CmdArgs{StdinData: []byte(conf)}, "")
+			Expect(err).To(MatchError(expectedErr))
+		},
+		Entry("owner with linkInContainer",
+			`{"name": "mynet", "type": "macvtap", "linkInContainer": true, "owner": 1000}`,
+			"owner and group cannot be set with linkInContainer"),
+		Entry("group from runtimeConfig with linkInContainer",
+			`{"name": "mynet", "type": "macvtap", "linkInContainer": true, "runtimeConfig": {"group": 1000}}`,
+			"owner and group cannot be set with linkInContainer"),
+		Entry("macvlan source mode",
+			`{"name": "mynet", "type": "macvtap", "mode": "source"}`,
+			`unknown macvtap mode: "source"`),
+	)
+})
+
+var _ = Describe("macvtap Operations", func() {
+	var originalNS, targetNS ns.NetNS
+
+	BeforeEach(func() {
+		// Create a new NetNS so we don't modify the host
+		var err error
+		originalNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		targetNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		for netns, master := range map[ns.NetNS]string{originalNS: MASTER_NAME, targetNS: MASTER_NAME_INCONTAINER} {
+			err = netns.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				linkAttrs := netlink.NewLinkAttrs()
+				linkAttrs.Name = master
+				// Add master
+				err := netlink.LinkAdd(&netlink.Dummy{
+					LinkAttrs: linkAttrs,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				_, err = netlinksafe.LinkByName(master)
+				Expect(err).NotTo(HaveOccurred())
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	AfterEach(func() {
+		Expect(originalNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(originalNS)).To(Succeed())
+		Expect(targetNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(targetNS)).To(Succeed())
+	})
+
+	It("configures and deconfigures a l2 macvtap link with its device owned by the VMM user with ADD/CHECK/DEL", func() {
+		const IFNAME = "macvtap0"
+		dataDir := GinkgoT().TempDir()
+
+		conf := fmt.Sprintf(`{
+		    "cniVersion": "1.0.0",
+		    "name": "mynet",
+		    "type": "macvtap",
+		    "master": %q,
+		    "mode": "private",
+		    "owner": 1000,
+		    "dataDir": %q,
+		    "runtimeConfig": {"group": 1001}
+		}`, MASTER_NAME, dataDir)
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNS.Path(),
+			IfName:      IFNAME,
+			StdinData:   []byte(conf),
+		}
+
+		var result types.Result
+		var devPath string
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			var err error
+			result, _, err = testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// Make sure the macvtap link and its device exist
+		err = targetNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlinksafe.LinkByName(IFNAME)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link).To(BeAssignableToTypeOf(&netlink.Macvtap{}))
+			Expect(link.(*netlink.Macvtap).Mode).To(Equal(netlink.MACVLAN_MODE_PRIVATE))
+			Expect(link.Attrs().Flags & net.FlagUp).To(Equal(net.FlagUp))
+
+			devPath = fmt.Sprintf("/dev/tap%d", link.Attrs().Index)
+			var st unix.Stat_t
+			Expect(unix.Stat(devPath, &st)).To(Succeed())
+			Expect(st.Mode & unix.S_IFMT).To(Equal(uint32(unix.S_IFCHR)))
+			Expect(st.Uid).To(Equal(uint32(1000)))
+			Expect(st.Gid).To(Equal(uint32(1001)))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		raw, err := json.Marshal(result)
+		Expect(err).NotTo(HaveOccurred())
+		args.StdinData = []byte(fmt.Sprintf(`{
+		    "cniVersion": "1.0.0",
+		    "name": "mynet",
+		    "type": "macvtap",
+		    "master": %q,
+		    "mode": "private",
+		    "dataDir": %q,
+		    "prevResult": %s
+		}`, MASTER_NAME, dataDir, raw))
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := testutils.CmdCheckWithArgs(args, func() error {
+				return cmdCheck(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = testutils.CmdDelWithArgs(args, func() error {
+				return cmdDel(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// Make sure macvtap link has been deleted
+		err = targetNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlinksafe.LinkByName(IFNAME)
+			Expect(err).To(HaveOccurred())
+			Expect(link).To(BeNil())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// along with its device, whether devtmpfs or the plugin created it
+		Expect(devPath).NotTo(BeAnExistingFile())
+		records, err := filepath.Glob(filepath.Join(dataDir, "*.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(BeEmpty())
+	})
+
+	It("creates the macvtap link on a master in the container with linkInContainer", func() {
+		conf := &NetConf{
+			NetConf: types.NetConf{
+				CNIVersion: "1.0.0",
+				Name:       "testConfig",
+				Type:       "macvtap",
+			},
+			Master:     MASTER_NAME_INCONTAINER,
+			LinkContNs: true,
+		}
+
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			_, _, err := createMacvtap(conf, "foobar0", targetNS)
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlinksafe.LinkByName("foobar0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link).To(BeAssignableToTypeOf(&netlink.Macvtap{}))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,604 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This plugin creates a vxlan or geneve interface, for static overlays
+// between nodes. The interface is either given to the container, or, when
+// chained after the bridge plugin, made the uplink of the bridge in the host.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+const (
+	kindVXLAN  = "vxlan"
+	kindGeneve = "geneve"
+
+	// The IANA assigned ports, the kernel defaults to 8472 for vxlan
+	defaultVXLANPort  = 4789
+	defaultGenevePort = 6081
+
+	maxVNI = 1<<24 - 1
+)
+
+var dfModes = map[string]netlink.GeneveDf{
+	"unset":   netlink.GENEVE_DF_UNSET,
+	"set":     netlink.GENEVE_DF_SET,
+	"inherit": netlink.GENEVE_DF_INHERIT,
+}
+
+type NetConf struct {
+	types.NetConf
+	// Kind is "vxlan" or "geneve"
+	Kind string `json:"kind,omitempty"`
+	VNI  *int   `json:"vni"`
+	// Remote is the unicast address of the other end, Group the multicast
+	// group of the overlay (vxlan only)
+	Remote string `json:"remote,omitempty"`
+	Group  string `json:"group,omitempty"`
+	// Local and Device are the source address and the underlay device
+	// (vxlan only)
+	Local   string `json:"local,omitempty"`
+	Device  string `json:"device,omitempty"`
+	DstPort int    `json:"dstPort,omitempty"`
+	TTL     int    `json:"ttl,omitempty"`
+	// DF is "unset", "set" or "inherit" (geneve only)
+	DF  string `json:"df,omitempty"`
+	MTU int    `json:"mtu,omitempty"`
+
+	// Bridge makes the interface the uplink of the bridge, named Uplink
+	Bridge string `json:"bridge,omitempty"`
+	Uplink string `json:"uplink,omitempty"`
+
+	remote net.IP
+	local  net.IP
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func loadConf(bytes []byte) (*NetConf, error) {
+	n := &NetConf{
+		Kind: kindVXLAN,
+	}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+
+	if n.RawPrevResult != nil {
+		if err := version.ParsePrevResult(&n.NetConf); err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+		if _, err := current.NewResultFromResult(n.PrevResult); err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	if n.Kind != kindVXLAN && n.Kind != kindGeneve {
+		return nil, fmt.Errorf("invalid kind %q, must be %q or %q", n.Kind, kindVXLAN, kindGeneve)
+	}
+	if n.VNI == nil {
+		return nil, errors.New("vni is required")
+	}
+	if *n.VNI < 0 || *n.VNI > maxVNI {
+		return nil, fmt.Errorf("invalid vni %d", *n.VNI)
+	}
+
+	if n.Remote != "" && n.Group != "" {
+		return nil, errors.New("remote and group are mutually exclusive")
+	}
+	if n.Remote != "" {
+		n.remote = net.ParseIP(n.Remote)
+		if n.remote == nil || n.remote.IsMulticast() {
+			return nil, fmt.Errorf("invalid remote %q", n.Remote)
+		}
+	}
+	if n.Group != "" {
+		n.remote = net.ParseIP(n.Group)
+		if n.remote == nil || !n.remote.IsMulticast() {
+			return nil, fmt.Errorf("invalid multicast group %q", n.Group)
+		}
+	}
+	if n.Local != "" {
+		n.local = net.ParseIP(n.Local)
+		if n.local == nil {
+			return nil, fmt.Errorf("invalid local address %q", n.Local)
+		}
+	}
+
+	switch n.Kind {
+	case kindVXLAN:
+		if n.DF != "" {
+			return nil, errors.New("df is only supported for geneve")
+		}
+		if n.Group != "" && n.Device == "" {
+			return nil, errors.New("group requires device")
+		}
+		if n.DstPort == 0 {
+			n.DstPort = defaultVXLANPort
+		}
+	case kindGeneve:
+		if n.Group != "" || n.Local != "" || n.Device != "" {
+			return nil, errors.New("group, local and device are only supported for vxlan")
+		}
+		if n.remote == nil {
+			return nil, errors.New("remote is required for geneve")
+		}
+		if _, ok := dfModes[n.DF]; n.DF != "" && !ok {
+			return nil, fmt.Errorf("invalid df %q, must be \"unset\", \"set\" or \"inherit\"", n.DF)
+		}
+		if n.DstPort == 0 {
+			n.DstPort = defaultGenevePort
+		}
+	}
+
+	if n.DstPort < 0 || n.DstPort > 65535 {
+		return nil, fmt.Errorf("invalid dstPort %d", n.DstPort)
+	}
+	if n.TTL < 0 || n.TTL > 255 {
+		return nil, fmt.Errorf("invalid ttl %d", n.TTL)
+	}
+	if n.MTU < 0 {
+		return nil, fmt.Errorf("invalid MTU %d", n.MTU)
+	}
+
+	if n.Uplink != "" && n.Bridge == "" {
+		return nil, errors.New("uplink requires bridge")
+	}
+	if n.Bridge != "" && n.Uplink == "" {
+		n.Uplink = fmt.Sprintf("%s%d", n.Kind, *n.VNI)
+	}
+
+	return n, nil
+}
+
+// newLink returns the link of the configuration, with linkAttrs.
+func newLink(n *NetConf, linkAttrs netlink.LinkAttrs) (netlink.Link, error) {
+	linkAttrs.MTU = n.MTU
+
+	if n.Kind == kindGeneve {
+		return &netlink.Geneve{
+			LinkAttrs: linkAttrs,
+			ID:        uint32(*n.VNI),
+			Remote:    n.remote,
+			Dport:     uint16(n.DstPort),
+			Ttl:       uint8(n.TTL),
+			Df:        dfModes[n.DF],
+		}, nil
+	}
+
+	vxlan := &netlink.Vxlan{
+		LinkAttrs: linkAttrs,
+		VxlanId:   *n.VNI,
+		Group:     n.remote,
+		SrcAddr:   n.local,
+		TTL:       n.TTL,
+		Port:      n.DstPort,
+		Learning:  true,
+	}
+	if n.Device != "" {
+		dev, err := netlinksafe.LinkByName(n.Device)
+		if err != nil {
+			return nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup device %q", n.Device)
+		}
+		vxlan.VtepDevIndex = dev.Attrs().Index
+	}
+	return vxlan, nil
+}
+
+// validateLink checks that link is the interface of the configuration.
+func validateLink(n *NetConf, link netlink.Link) error {
+	name := link.Attrs().Name
+	if link.Type() != n.Kind {
+		return fmt.Errorf("interface %s is of type %s, expected %s", name, link.Type(), n.Kind)
+	}
+
+	var vni, port int
+	var remote net.IP
+	switch l := link.(type) {
+	case *netlink.Vxlan:
+		vni, port, remote = l.VxlanId, l.Port, l.Group
+	case *netlink.Geneve:
+		vni, port, remote = int(l.ID), int(l.Dport), l.Remote
+	}
+
+	if vni != *n.VNI {
+		return fmt.Errorf("interface %s vni %d does not match expected value: %d", name, vni, *n.VNI)
+	}
+	if port != n.DstPort {
+		return fmt.Errorf("interface %s port %d does not match expected value: %d", name, port, n.DstPort)
+	}
+	if n.remote != nil && !n.remote.Equal(remote) {
+		return fmt.Errorf("interface %s remote %s does not match expected value: %s", name, remote, n.remote)
+	}
+	return nil
+}
+
+func createOverlay(n *NetConf, ifName string, netns ns.NetNS) (*current.Interface, error) {
+	// due to kernel bug we have to create with tmpname or it might
+	// collide with the name on the host and error out
+	tmpName, err := ip.RandomVethName()
+	if err != nil {
+		return nil, err
+	}
+
+	// The interface is created in the container namespace, but from the
+	// host one, where its socket is bound
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = tmpName
+	linkAttrs.Namespace = netlink.NsFd(int(netns.Fd()))
+
+	link, err := newLink(n, linkAttrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := netlink.LinkAdd(link); err != nil {
+		return nil, fmt.Errorf("failed to create %s interface: %v", n.Kind, err)
+	}
+
+	overlay := &current.Interface{}
+	err = netns.Do(func(_ ns.NetNS) error {
+		err := ip.RenameLink(tmpName, ifName)
+		if err != nil {
+			_ = ip.DelLinkByName(tmpName)
+			return fmt.Errorf("failed to rename %s interface to %q: %v", n.Kind, ifName, err)
+		}
+		overlay.Name = ifName
+
+		// Re-fetch the interface to get all properties/attributes
+		contLink, err := netlinksafe.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to refetch %s interface %q: %v", n.Kind, ifName, err)
+		}
+		overlay.Mac = contLink.Attrs().HardwareAddr.String()
+		overlay.Mtu = contLink.Attrs().MTU
+		overlay.Sandbox = netns.Path()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return overlay, nil
+}
+
+// bridgeByName returns the bridge name of the host.
+func bridgeByName(name string) (*netlink.Bridge, error) {
+	link, err := netlinksafe.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not lookup bridge %q: %v", name, err)
+	}
+	br, ok := link.(*netlink.Bridge)
+	if !ok {
+		return nil, fmt.Errorf("%q already exists but is not a bridge", name)
+	}
+	return br, nil
+}
+
+// ensureUplink creates the uplink of the bridge if it does not exist yet,
+// as it is shared by all the containers of the bridge.
+func ensureUplink(n *NetConf) (*current.Interface, error) {
+	br, err := bridgeByName(n.Bridge)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := netlinksafe.LinkByName(n.Uplink)
+	if _, ok := err.(netlink.LinkNotFoundError); ok {
+		linkAttrs := netlink.NewLinkAttrs()
+		linkAttrs.Name = n.Uplink
+		linkAttrs.MasterIndex = br.Index
+
+		link, err = newLink(n, linkAttrs)
+		if err != nil {
+			return nil, err
+		}
+		// Another container may have created it meanwhile
+		if err := netlink.LinkAdd(link); err != nil && !errors.Is(err, unix.EEXIST) {
+			return nil, fmt.Errorf("failed to create uplink %q: %v", n.Uplink, err)
+		}
+		link, err = netlinksafe.LinkByName(n.Uplink)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup uplink %q: %v", n.Uplink, err)
+	}
+
+	if err := validateLink(n, link); err != nil {
+		return nil, err
+	}
+	if link.Attrs().MasterIndex != br.Index {
+		if err := netlink.LinkSetMaster(link, br); err != nil {
+			return nil, fmt.Errorf("failed to connect %q to bridge %v: %v", n.Uplink, n.Bridge, err)
+		}
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to set %q up: %v", n.Uplink, err)
+	}
+
+	return &current.Interface{
+		Name: n.Uplink,
+		Mac:  link.Attrs().HardwareAddr.String(),
+		Mtu:  link.Attrs().MTU,
+	}, nil
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if n.Bridge != "" {
+		return addUplink(n)
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	overlayInterface, err := createOverlay(n, args.IfName, netns)
+	if err != nil {
+		return err
+	}
+
+	// Delete the interface if the rest of the setup fails
+	defer func() {
+		if err != nil {
+			_ = netns.Do(func(_ ns.NetNS) error {
+				return ip.DelLinkByName(args.IfName)
+			})
+		}
+	}()
+
+	var result *current.Result
+	// Configure iface from PrevResult if we have IPs and an IPAM
+	// block has not been configured
+	haveResult := false
+	if n.IPAM.Type == "" && n.PrevResult != nil {
+		result, err = current.NewResultFromResult(n.PrevResult)
+		if err != nil {
+			return err
+		}
+		if len(result.IPs) > 0 {
+			haveResult = true
+		}
+	}
+	if !haveResult {
+		if n.IPAM.Type == "" {
+			err = errors.New("no IPAM configured and no IPs in prevResult")
+			return err
+		}
+
+		// run the IPAM plugin and get back the config to apply
+		var r types.Result
+		r, err = ipam.ExecAdd(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+
+		// Invoke ipam del if err to avoid ip leak
+		defer func() {
+			if err != nil {
+				ipam.ExecDel(n.IPAM.Type, args.StdinData)
+			}
+		}()
+
+		// Convert whatever the IPAM result was into the current Result type
+		result, err = current.NewResultFromResult(r)
+		if err != nil {
+			return err
+		}
+
+		if len(result.IPs) == 0 {
+			err = errors.New("IPAM plugin returned missing IP config")
+			return err
+		}
+	}
+	for _, ipc := range result.IPs {
+		// All addresses belong to the overlay interface
+		ipc.Interface = current.Int(0)
+	}
+
+	result.Interfaces = []*current.Interface{overlayInterface}
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return ipam.ConfigureIface(args.IfName, result)
+	})
+	if err != nil {
+		return err
+	}
+
+	result.DNS = n.DNS
+
+	return types.PrintResult(result, n.CNIVersion)
+}
+
+// addUplink sets up the uplink of the bridge and passes the result of the
+// bridge plugin through, with the uplink added to its interfaces.
+func addUplink(n *NetConf) error {
+	if n.PrevResult == nil {
+		return errors.New("must be called as chained plugin with bridge")
+	}
+	result, err := current.NewResultFromResult(n.PrevResult)
+	if err != nil {
+		return fmt.Errorf("could not convert result to current version: %v", err)
+	}
+
+	uplink, err := ensureUplink(n)
+	if err != nil {
+		return err
+	}
+	result.Interfaces = append(result.Interfaces, uplink)
+
+	return types.PrintResult(result, n.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	// The uplink is shared by the containers of the bridge
+	if n.Bridge != "" {
+		return nil
+	}
+
+	// On chained invocation, IPAM block can be empty
+	if n.IPAM.Type != "" {
+		err = ipam.ExecDel(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Netns == "" {
+		return nil
+	}
+
+	// There is a netns so try to clean up. Delete can be called multiple times
+	// so don't return an error if the device is already removed.
+	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		if err := ip.DelLinkByName(args.IfName); err != nil {
+			if err != ip.ErrLinkNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if _, ok := err.(ns.NSPathNotExistErr); ok {
+		return nil
+	}
+	return err
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if n.PrevResult == nil {
+		return fmt.Errorf("Required prevResult missing")
+	}
+	result, err := current.NewResultFromResult(n.PrevResult)
+	if err != nil {
+		return err
+	}
+
+	if n.Bridge != "" {
+		br, err := bridgeByName(n.Bridge)
+		if err != nil {
+			return err
+		}
+		link, err := netlinksafe.LinkByName(n.Uplink)
+		if err != nil {
+			return fmt.Errorf("failed to lookup uplink %q: %v", n.Uplink, err)
+		}
+		if link.Attrs().MasterIndex != br.Index {
+			return fmt.Errorf("uplink %s is not connected to bridge %s", n.Uplink, n.Bridge)
+		}
+		return validateLink(n, link)
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	if n.IPAM.Type != "" {
+		err = ipam.ExecCheck(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	var contMap current.Interface
+	for _, intf := range result.Interfaces {
+		if args.IfName == intf.Name && args.Netns == intf.Sandbox {
+			contMap = *intf
+		}
+	}
+
+	// The namespace must be the same as what was configured
+	if args.Netns != contMap.Sandbox {
+		return fmt.Errorf("Sandbox in prevResult %s doesn't match configured netns: %s",
+			contMap.Sandbox, args.Netns)
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		link, err := netlinksafe.LinkByName(args.IfName)
+		if err != nil {
+			return fmt.Errorf("Container Interface name in prevResult: %s not found", args.IfName)
+		}
+		if err := validateLink(n, link); err != nil {
+			return err
+		}
+
+		if err := ip.ValidateExpectedInterfaceIPs(args.IfName, result.IPs); err != nil {
+			return err
+		}
+
+		return ip.ValidateExpectedRoute(result.Routes)
+	})
+}
+
+func cmdStatus(args *skel.CmdArgs) error {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.IPAM.Type != "" {
+		if err := ipam.ExecStatus(n.IPAM.Type, args.StdinData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		Status: cmdStatus,
+	}, version.All, bv.BuildString("overlay"))
+}
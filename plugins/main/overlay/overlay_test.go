@@ -0,0 +1,311 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+const ifname = "eth0"
+
+var _ = Describe("overlay plugin", func() {
+	ipsOnly := `{"cniVersion": "1.0.0", "ips": [{"address": "10.1.2.3/24"}]}`
+
+	Describe("configuration", func() {
+		It("defaults to vxlan on the IANA port", func() {
+			n, err := loadConf([]byte(`{"vni": 42}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.Kind).To(Equal("vxlan"))
+			Expect(n.DstPort).To(Equal(4789))
+			Expect(n.Uplink).To(BeEmpty())
+
+			n, err = loadConf([]byte(`{"kind": "geneve", "vni": 42, "remote": "192.0.2.1", "df": "inherit", "bridge": "cni0"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.DstPort).To(Equal(6081))
+			Expect(n.Uplink).To(Equal("geneve42"))
+		})
+
+		It("rejects invalid configurations", func() {
+			for _, conf := range []string{
+				`{}`,
+				`{"kind": "gre", "vni": 1}`,
+				`{"vni": 16777216}`,
+				`{"vni": 1, "remote": "192.0.2.1", "group": "239.1.1.1"}`,
+				`{"vni": 1, "remote": "239.1.1.1"}`,
+				`{"vni": 1, "group": "192.0.2.1", "device": "eth0"}`,
+				`{"vni": 1, "group": "239.1.1.1"}`,
+				`{"vni": 1, "local": "foo"}`,
+				`{"vni": 1, "df": "set"}`,
+				`{"vni": 1, "ttl": 256}`,
+				`{"vni": 1, "dstPort": 65536}`,
+				`{"vni": 1, "uplink": "vx0"}`,
+				`{"kind": "geneve", "vni": 1}`,
+				`{"kind": "geneve", "vni": 1, "remote": "192.0.2.1", "df": "maybe"}`,
+				`{"kind": "geneve", "vni": 1, "remote": "192.0.2.1", "device": "eth0"}`,
+			} {
+				_, err := loadConf([]byte(conf))
+				Expect(err).To(HaveOccurred(), conf)
+			}
+		})
+	})
+
+	Describe("interfaces", func() {
+		var originalNS, targetNs ns.NetNS
+		var args *skel.CmdArgs
+
+		BeforeEach(func() {
+			var err error
+			originalNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			targetNs, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+
+			args = &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNs.Path(),
+				IfName:      ifname,
+			}
+		})
+
+		AfterEach(func() {
+			Expect(originalNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(originalNS)).To(Succeed())
+			Expect(targetNs.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(targetNs)).To(Succeed())
+		})
+
+		It("gives a vxlan interface to the container", func() {
+			extra := `"vni": 42, "remote": "192.0.2.1", "ttl": 16,`
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "overlay",
+				%s
+				"prevResult": %s
+			}`, extra, ipsOnly))
+
+			var result *current.Result
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				r, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				if err != nil {
+					return err
+				}
+				result, err = current.GetResult(r)
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(1))
+			Expect(result.Interfaces[0].Name).To(Equal(ifname))
+			Expect(result.Interfaces[0].Sandbox).To(Equal(targetNs.Path()))
+
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				link, err := netlinksafe.LinkByName(ifname)
+				if err != nil {
+					return err
+				}
+				Expect(link).To(BeAssignableToTypeOf(&netlink.Vxlan{}))
+				vxlan := link.(*netlink.Vxlan)
+				Expect(vxlan.VxlanId).To(Equal(42))
+				Expect(vxlan.Port).To(Equal(4789))
+				Expect(vxlan.TTL).To(Equal(16))
+				Expect(vxlan.Group.String()).To(Equal("192.0.2.1"))
+				Expect(vxlan.HardwareAddr.String()).To(Equal(result.Interfaces[0].Mac))
+				Expect(vxlan.MTU).To(Equal(result.Interfaces[0].Mtu))
+
+				addrs, err := netlinksafe.AddrList(link, netlink.FAMILY_V4)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addrs).To(HaveLen(1))
+				Expect(addrs[0].IPNet.String()).To(Equal("10.1.2.3/24"))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			prevResult, err := json.Marshal(result)
+			Expect(err).NotTo(HaveOccurred())
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "overlay",
+				%s
+				"prevResult": %s
+			}`, extra, string(prevResult)))
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// CHECK notices a different VNI
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "overlay",
+				"vni": 43,
+				"prevResult": %s
+			}`, string(prevResult)))
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+			})
+			Expect(err).To(MatchError("interface eth0 vni 42 does not match expected value: 43"))
+
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				_, err := netlinksafe.LinkByName(ifname)
+				return err
+			})
+			Expect(err).To(HaveOccurred())
+
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("removes the interface when the setup fails", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "overlay",
+				"vni": 42,
+				"prevResult": {"cniVersion": "1.0.0"}
+			}`)
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).To(MatchError("no IPAM configured and no IPs in prevResult"))
+
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				_, err := netlinksafe.LinkByName(ifname)
+				return err
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("makes a vxlan interface the uplink of a bridge", func() {
+			bridgeResult := fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "cni0"}, {"name": "eth0", "sandbox": %q}],
+				"ips": [{"address": "10.1.2.3/24", "interface": 1}]
+			}`, targetNs.Path())
+			extra := `"vni": 42, "group": "239.1.1.1", "device": "underlay0", "bridge": "cni0",`
+
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				for _, link := range []netlink.Link{
+					&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "cni0"}},
+					&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "underlay0"}, PeerName: "peer0"},
+				} {
+					if err := netlink.LinkAdd(link); err != nil {
+						return err
+					}
+				}
+
+				for i := 0; i < 2; i++ {
+					args.StdinData = []byte(fmt.Sprintf(`{
+						"cniVersion": "1.0.0",
+						"name": "test",
+						"type": "overlay",
+						%s
+						"prevResult": %s
+					}`, extra, bridgeResult))
+					r, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+					if err != nil {
+						return err
+					}
+					result, err := current.GetResult(r)
+					if err != nil {
+						return err
+					}
+					Expect(result.Interfaces).To(HaveLen(3))
+					Expect(result.Interfaces[2].Name).To(Equal("vxlan42"))
+					Expect(result.IPs).To(HaveLen(1))
+				}
+
+				link, err := netlinksafe.LinkByName("vxlan42")
+				if err != nil {
+					return err
+				}
+				br2, err := netlinksafe.LinkByName("cni0")
+				if err != nil {
+					return err
+				}
+				Expect(link.Attrs().MasterIndex).To(Equal(br2.Attrs().Index))
+				Expect(link.(*netlink.Vxlan).Group.String()).To(Equal("239.1.1.1"))
+
+				args.StdinData = []byte(fmt.Sprintf(`{
+					"cniVersion": "1.0.0",
+					"name": "test",
+					"type": "overlay",
+					%s
+					"prevResult": %s
+				}`, extra, bridgeResult))
+				if err := testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) }); err != nil {
+					return err
+				}
+
+				if err := netlink.LinkSetNoMaster(link); err != nil {
+					return err
+				}
+				err = testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+				Expect(err).To(MatchError("uplink vxlan42 is not connected to bridge cni0"))
+
+				// The uplink is left for the other containers of the bridge
+				if err := testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) }); err != nil {
+					return err
+				}
+				_, err = netlinksafe.LinkByName("vxlan42")
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("refuses an uplink of another kind", func() {
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				for _, link := range []netlink.Link{
+					&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "cni0"}},
+					&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "vxlan42"}, PeerName: "peer0"},
+				} {
+					if err := netlink.LinkAdd(link); err != nil {
+						return err
+					}
+				}
+
+				args.StdinData = []byte(fmt.Sprintf(`{
+					"cniVersion": "1.0.0",
+					"name": "test",
+					"type": "overlay",
+					"vni": 42, "bridge": "cni0",
+					"prevResult": %s
+				}`, ipsOnly))
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).To(MatchError("interface vxlan42 is of type veth, expected vxlan"))
+		})
+	})
+})
@@ -28,10 +28,15 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/journal"
+	"github.com/containernetworking/plugins/pkg/log"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/trace"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
 
@@ -47,6 +52,46 @@ type NetConf struct {
 	IPMasq        bool    `json:"ipMasq"`
 	IPMasqBackend *string `json:"ipMasqBackend,omitempty"`
 	MTU           int     `json:"mtu"`
+	// HostMTU is the MTU of the host-side veth, when it should differ from MTU
+	HostMTU int `json:"hostMtu,omitempty"`
+	// HostAddresses are the host-side peer addresses, at most one per IP
+	// family, used instead of the IPAM gateways
+	HostAddresses []string `json:"hostAddresses,omitempty"`
+}
+
+// parseHostAddresses returns the configured host-side addresses, indexed by
+// whether they are IPv4.
+func parseHostAddresses(conf *NetConf) (map[bool]net.IP, error) {
+	hostAddrs := map[bool]net.IP{}
+	for _, s := range conf.HostAddresses {
+		addr := net.ParseIP(s)
+		if addr == nil {
+			return nil, fmt.Errorf("invalid host address %q", s)
+		}
+		isV4 := addr.To4() != nil
+		if _, ok := hostAddrs[isV4]; ok {
+			return nil, fmt.Errorf("more than one host address of the family of %q", s)
+		}
+		hostAddrs[isV4] = addr
+	}
+	return hostAddrs, nil
+}
+
+// useHostAddresses replaces the IPAM gateways of result, and the routes via
+// them, with the configured host-side addresses.
+func useHostAddresses(result *current.Result, hostAddrs map[bool]net.IP) {
+	for _, ipc := range result.IPs {
+		hostAddr, ok := hostAddrs[ipc.Address.IP.To4() != nil]
+		if !ok {
+			continue
+		}
+		for _, r := range result.Routes {
+			if r.GW != nil && ipc.Gateway != nil && r.GW.Equal(ipc.Gateway) {
+				r.GW = hostAddr
+			}
+		}
+		ipc.Gateway = hostAddr
+	}
 }
 
 func setupContainerVeth(netns ns.NetNS, ifName string, mtu int, pr *current.Result) (*current.Interface, *current.Interface, error) {
@@ -87,7 +132,13 @@ func setupContainerVeth(netns ns.NetNS, ifName string, mtu int, pr *current.Resu
 			return fmt.Errorf("failed to look up %q: %v", ifName, err)
 		}
 
-		if err = ipam.ConfigureIface(ifName, pr); err != nil {
+		// The IPAM routes are added last, once the gateways are reachable, as
+		// configured host addresses lie outside of the IPAM subnets
+		routes := pr.Routes
+		pr.Routes = nil
+		err = ipam.ConfigureIface(ifName, pr)
+		pr.Routes = routes
+		if err != nil {
 			return err
 		}
 
@@ -138,7 +189,7 @@ func setupContainerVeth(netns ns.NetNS, ifName string, mtu int, pr *current.Resu
 			}
 		}
 
-		return nil
+		return ipam.AddRoutes(ifName, pr)
 	})
 	if err != nil {
 		return nil, nil, err
@@ -146,13 +197,19 @@ func setupContainerVeth(netns ns.NetNS, ifName string, mtu int, pr *current.Resu
 	return hostInterface, containerInterface, nil
 }
 
-func setupHostVeth(vethName string, result *current.Result) error {
+func setupHostVeth(vethName string, mtu int, result *current.Result) error {
 	// hostVeth moved namespaces and may have a new ifindex
 	veth, err := netlinksafe.LinkByName(vethName)
 	if err != nil {
 		return fmt.Errorf("failed to lookup %q: %v", vethName, err)
 	}
 
+	if mtu != 0 {
+		if err = netlink.LinkSetMTU(veth, mtu); err != nil {
+			return fmt.Errorf("failed to set MTU of %q to %d: %v", vethName, mtu, err)
+		}
+	}
+
 	for _, ipc := range result.IPs {
 		maskLen := 128
 		if ipc.Address.IP.To4() != nil {
@@ -187,6 +244,11 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return fmt.Errorf("failed to load netconf: %v", err)
 	}
 
+	hostAddrs, err := parseHostAddresses(&conf)
+	if err != nil {
+		return err
+	}
+
 	// run the IPAM plugin and get back the config to apply
 	r, err := ipam.ExecAdd(conf.IPAM.Type, args.StdinData)
 	if err != nil {
@@ -209,6 +271,9 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if len(result.IPs) == 0 {
 		return errors.New("IPAM plugin returned missing IP config")
 	}
+	log.Debug("IPAM allocated addresses", "ipam", conf.IPAM.Type, "ips", result.IPs)
+
+	useHostAddresses(result, hostAddrs)
 
 	if err := ip.EnableForward(result.IPs); err != nil {
 		return fmt.Errorf("Could not enable IP forwarding: %v", err)
@@ -216,7 +281,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
@@ -225,7 +290,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
-	if err = setupHostVeth(hostInterface.Name, result); err != nil {
+	if err = setupHostVeth(hostInterface.Name, conf.HostMTU, result); err != nil {
 		return err
 	}
 
@@ -303,13 +368,13 @@ func cmdDel(args *skel.CmdArgs) error {
 }
 
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
+	skel.PluginMainFuncs(log.Wrap("ptp", trace.Wrap("ptp", journal.Wrap("ptp", ip.ReconcileJournal, skel.CNIFuncs{
 		Add:    cmdAdd,
 		Check:  cmdCheck,
 		Del:    cmdDel,
 		Status: cmdStatus,
 		/* FIXME GC */
-	}, version.All, bv.BuildString("ptp"))
+	}))), version.All, bv.BuildString("ptp"))
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
@@ -320,7 +385,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
@@ -419,6 +484,13 @@ func cmdStatus(args *skel.CmdArgs) error {
 		return fmt.Errorf("failed to load netconf: %w", err)
 	}
 
+	if err := status.Netlink(); err != nil {
+		return err
+	}
+	if err := status.KernelModules("veth"); err != nil {
+		return err
+	}
+
 	if err := ipam.ExecStatus(conf.IPAM.Type, args.StdinData); err != nil {
 		return err
 	}
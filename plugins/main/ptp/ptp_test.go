@@ -22,6 +22,7 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
@@ -458,6 +459,84 @@ var _ = Describe("ptp Operations", func() {
 			doTest(conf, ver, 2, types.DNS{}, targetNS)
 		})
 
+		It(fmt.Sprintf("[%s] configures a ptp link with host addresses and host MTU", ver), func() {
+			conf := fmt.Sprintf(`{
+			    "cniVersion": "%s",
+			    "name": "mynet",
+			    "type": "ptp",
+			    "mtu": 5000,
+			    "hostMtu": 1400,
+			    "hostAddresses": ["169.254.1.1", "2001:db8:ffff::1"],
+			    "ipam": {
+				"type": "host-local",
+				"ranges": [
+					[{ "subnet": "10.1.2.0/24"}],
+					[{ "subnet": "2001:db8:1::0/66"}]
+				],
+				"routes": [
+				  { "dst": "0.0.0.0/0" },
+				  { "dst": "192.168.0.0/16", "gw": "10.1.2.1" }
+				],
+				"dataDir": "%s"
+			    }
+			}`, ver, dataDir)
+
+			doTest(conf, ver, 2, types.DNS{}, targetNS)
+
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNS.Path(),
+				IfName:      "ptp0",
+				StdinData:   []byte(conf),
+			}
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				result, _, err := testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				r, err := types100.GetResult(result)
+				Expect(err).NotTo(HaveOccurred())
+
+				var hostVeth netlink.Link
+				links, err := netlinksafe.LinkList()
+				Expect(err).NotTo(HaveOccurred())
+				for _, link := range links {
+					if _, ok := link.(*netlink.Veth); ok {
+						hostVeth = link
+					}
+				}
+				Expect(hostVeth).NotTo(BeNil())
+				Expect(hostVeth.Attrs().MTU).To(Equal(1400))
+				addrs, err := netlinksafe.AddrList(hostVeth, netlink.FAMILY_V4)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addrs).To(HaveLen(1))
+				Expect(addrs[0].IPNet.String()).To(Equal("169.254.1.1/32"))
+
+				for _, route := range r.Routes {
+					if route.Dst.String() == "192.168.0.0/16" {
+						Expect(route.GW.String()).To(Equal("169.254.1.1"))
+					}
+				}
+
+				return testutils.CmdDelWithArgs(args, func() error {
+					return cmdDel(args)
+				})
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = targetNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				link, err := netlinksafe.LinkByName("ptp0")
+				Expect(err).To(HaveOccurred())
+				Expect(link).To(BeNil())
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
 		It(fmt.Sprintf("[%s] does not override IPAM DNS settings if no DNS settings provided", ver), func() {
 			ipamDNSConf := types.DNS{
 				Nameservers: []string{"10.1.2.123"},
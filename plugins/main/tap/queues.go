@@ -0,0 +1,142 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+const (
+	tunDevice      = "/dev/net/tun"
+	vhostNetDevice = "/dev/vhost-net"
+	// maxQueues is MAX_TAP_QUEUES of the kernel
+	maxQueues = 256
+)
+
+// TapInfo reports in the result the queues of the tap, and the file
+// descriptors passed to the fdSocket, tap queues first, then vhost-net.
+type TapInfo struct {
+	Queues      int `json:"queues"`
+	QueueFds    int `json:"queueFds,omitempty"`
+	VhostNetFds int `json:"vhostNetFds,omitempty"`
+}
+
+// openTapQueues attaches queues file descriptors to the tap ifName, which must
+// be in the current network namespace.
+func openTapQueues(ifName string, queues int, multiqueue bool) ([]*os.File, error) {
+	flags := uint16(unix.IFF_TAP | unix.IFF_NO_PI | unix.IFF_VNET_HDR)
+	if multiqueue {
+		flags |= unix.IFF_MULTI_QUEUE
+	}
+
+	fds := make([]*os.File, 0, queues)
+	for i := 0; i < queues; i++ {
+		fd, err := unix.Open(tunDevice, unix.O_RDWR|unix.O_CLOEXEC, 0)
+		if err != nil {
+			closeFiles(fds)
+			return nil, fmt.Errorf("failed to open %s: %v", tunDevice, err)
+		}
+		file := os.NewFile(uintptr(fd), tunDevice)
+		fds = append(fds, file)
+
+		ifr, err := unix.NewIfreq(ifName)
+		if err != nil {
+			closeFiles(fds)
+			return nil, err
+		}
+		ifr.SetUint16(flags)
+		if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+			closeFiles(fds)
+			return nil, fmt.Errorf("failed to attach queue %d of tap %q: %v", i, ifName, err)
+		}
+	}
+	return fds, nil
+}
+
+// openVhostNet opens one vhost-net backend per queue. The VM launcher takes
+// ownership of them.
+func openVhostNet(queues int) ([]*os.File, error) {
+	fds := make([]*os.File, 0, queues)
+	for i := 0; i < queues; i++ {
+		file, err := os.OpenFile(vhostNetDevice, os.O_RDWR, 0)
+		if err != nil {
+			closeFiles(fds)
+			return nil, fmt.Errorf("failed to open %s: %v", vhostNetDevice, err)
+		}
+		fds = append(fds, file)
+	}
+	return fds, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+// sendFds passes the file descriptors, with the name of the tap as payload,
+// to the process listening on the unix socket at socketPath.
+func sendFds(socketPath, ifName string, files []*os.File) error {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to fdSocket %s: %v", socketPath, err)
+	}
+	defer conn.Close()
+
+	fds := make([]int, 0, len(files))
+	for _, file := range files {
+		fds = append(fds, int(file.Fd()))
+	}
+	if _, _, err := conn.WriteMsgUnix([]byte(ifName), unix.UnixRights(fds...), nil); err != nil {
+		return fmt.Errorf("failed to pass file descriptors to %s: %v", socketPath, err)
+	}
+	return nil
+}
+
+// printResult prints the result, with info under the "tap" key. The fields of
+// the result are fixed by the spec, so info is added to its JSON form.
+func printResult(result *current.Result, cniVersion string, info *TapInfo) error {
+	if info == nil {
+		return types.PrintResult(result, cniVersion)
+	}
+
+	versioned, err := result.GetAsVersion(cniVersion)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(versioned)
+	if err != nil {
+		return err
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return err
+	}
+	out["tap"] = info
+	data, err = json.MarshalIndent(out, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
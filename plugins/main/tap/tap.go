@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"os/exec"
 	"runtime"
 	"strconv"
@@ -32,6 +33,7 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
@@ -43,6 +45,9 @@ import (
 type NetConf struct {
 	types.NetConf
 	MultiQueue     bool      `json:"multiQueue"`
+	Queues         int       `json:"queues,omitempty"`
+	VhostNet       bool      `json:"vhostNet,omitempty"`
+	FdSocket       string    `json:"fdSocket,omitempty"`
 	MTU            int       `json:"mtu"`
 	Mac            string    `json:"mac,omitempty"`
 	Owner          *uint32   `json:"owner,omitempty"`
@@ -52,6 +57,10 @@ type NetConf struct {
 	Args           *struct{} `json:"args,omitempty"`
 	RuntimeConfig  struct {
 		Mac string `json:"mac,omitempty"`
+		// Owner and Group let the runtime hand the tap to the user of the
+		// process consuming it, like a VM launcher, per pod.
+		Owner *uint32 `json:"owner,omitempty"`
+		Group *uint32 `json:"group,omitempty"`
 	} `json:"runtimeConfig,omitempty"`
 }
 
@@ -88,6 +97,22 @@ func loadConf(args *skel.CmdArgs) (*NetConf, string, error) {
 	if n.RuntimeConfig.Mac != "" {
 		n.Mac = n.RuntimeConfig.Mac
 	}
+	if n.RuntimeConfig.Owner != nil {
+		n.Owner = n.RuntimeConfig.Owner
+	}
+	if n.RuntimeConfig.Group != nil {
+		n.Group = n.RuntimeConfig.Group
+	}
+
+	if n.Queues < 0 || n.Queues > maxQueues {
+		return nil, "", fmt.Errorf("invalid queues %d (must be between 1 and %d)", n.Queues, maxQueues)
+	}
+	if n.Queues > 1 {
+		n.MultiQueue = true
+	}
+	if n.VhostNet && n.FdSocket == "" {
+		return nil, "", fmt.Errorf("vhostNet requires fdSocket")
+	}
 
 	return n, n.CNIVersion, nil
 }
@@ -256,7 +281,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
@@ -338,8 +363,41 @@ func cmdAdd(args *skel.CmdArgs) error {
 		}
 	}
 
+	var info *TapInfo
+	if n.Queues > 0 || n.FdSocket != "" {
+		info = &TapInfo{Queues: max(n.Queues, 1)}
+	}
+	if n.FdSocket != "" {
+		var fds []*os.File
+		err = netns.Do(func(_ ns.NetNS) error {
+			// The tun device binds to the tap in the namespace it is opened in
+			fds, err = openTapQueues(args.IfName, info.Queues, n.MultiQueue)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		defer closeFiles(fds)
+		info.QueueFds = len(fds)
+
+		if n.VhostNet {
+			var vhostFds []*os.File
+			vhostFds, err = openVhostNet(info.Queues)
+			if err != nil {
+				return err
+			}
+			defer closeFiles(vhostFds)
+			info.VhostNetFds = len(vhostFds)
+			fds = append(fds, vhostFds...)
+		}
+
+		if err = sendFds(n.FdSocket, args.IfName, fds); err != nil {
+			return err
+		}
+	}
+
 	result.DNS = n.DNS
-	return types.PrintResult(result, cniVersion)
+	return printResult(result, cniVersion, info)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -404,7 +462,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
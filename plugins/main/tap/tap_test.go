@@ -25,6 +25,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
@@ -444,5 +445,151 @@ var _ = Describe("Add, check, remove tap plugin", func() {
 			})
 			Expect(err).NotTo(HaveOccurred())
 		})
+		It(fmt.Sprintf("[%s] passes the queues of a multi-queue tap to the fdSocket", ver), func() {
+			socketPath := dataDir + "/fds.sock"
+			listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+
+			type received struct {
+				payload string
+				fds     []int
+			}
+			receivedCh := make(chan received, 1)
+			go func() {
+				defer GinkgoRecover()
+				conn, err := listener.AcceptUnix()
+				Expect(err).NotTo(HaveOccurred())
+				defer conn.Close()
+				buf := make([]byte, 64)
+				oob := make([]byte, unix.CmsgSpace(8*4))
+				n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+				Expect(err).NotTo(HaveOccurred())
+				msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(msgs).To(HaveLen(1))
+				fds, err := unix.ParseUnixRights(&msgs[0])
+				Expect(err).NotTo(HaveOccurred())
+				receivedCh <- received{payload: string(buf[:n]), fds: fds}
+			}()
+
+			conf := fmt.Sprintf(`{
+				    "cniVersion": "%s",
+				    "name": "tapTest",
+				    "type": "tap",
+				    "queues": 2,
+				    "fdSocket": "%s",
+				    "ipam": {
+						"type": "host-local",
+						"subnet": "10.1.2.0/24",
+						"dataDir": "%s"
+				    }
+				}`, ver, socketPath, dataDir)
+
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNS.Path(),
+				IfName:      IFNAME,
+				StdinData:   []byte(conf),
+			}
+
+			var out []byte
+			err = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				var err error
+				_, out, err = testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var raw struct {
+				Tap *TapInfo `json:"tap"`
+			}
+			Expect(json.Unmarshal(out, &raw)).To(Succeed())
+			Expect(raw.Tap).To(Equal(&TapInfo{Queues: 2, QueueFds: 2}))
+
+			var r received
+			Eventually(receivedCh).Should(Receive(&r))
+			Expect(r.payload).To(Equal(IFNAME))
+			// a tap without multi-queue only takes a single queue
+			Expect(r.fds).To(HaveLen(2))
+			for _, fd := range r.fds {
+				Expect(unix.Close(fd)).To(Succeed())
+			}
+
+			err = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				return testutils.CmdDelWithArgs(args, func() error {
+					return cmdDel(args)
+				})
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It(fmt.Sprintf("[%s] creates the tap with the owner and group of runtimeConfig", ver), func() {
+			conf := fmt.Sprintf(`{
+				    "cniVersion": "%s",
+				    "name": "tapTest",
+				    "type": "tap",
+				    "owner": 0,
+				    "group": 0,
+				    "runtimeConfig": {
+						"owner": 107,
+						"group": 108
+				    },
+				    "ipam": {
+						"type": "host-local",
+						"subnet": "10.1.2.0/24",
+						"dataDir": "%s"
+				    }
+				}`, ver, dataDir)
+
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNS.Path(),
+				IfName:      IFNAME,
+				StdinData:   []byte(conf),
+			}
+
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				_, _, err := testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = targetNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				link, err := netlinksafe.LinkByName(IFNAME)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.(*netlink.Tuntap).Owner).To(Equal(uint32(107)))
+				Expect(link.(*netlink.Tuntap).Group).To(Equal(uint32(108)))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				return testutils.CmdDelWithArgs(args, func() error {
+					return cmdDel(args)
+				})
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It(fmt.Sprintf("[%s] rejects vhostNet without fdSocket", ver), func() {
+			conf := fmt.Sprintf(`{
+				    "cniVersion": "%s",
+				    "name": "tapTest",
+				    "type": "tap",
+				    "vhostNet": true
+				}`, ver)
+			_, _, err := loadConf(&skel.CmdArgs{StdinData: []byte(conf)})
+			Expect(err).To(MatchError("vhostNet requires fdSocket"))
+		})
 	}
 })
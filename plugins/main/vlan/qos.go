@@ -0,0 +1,172 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// The netlink library does not know the VLAN QoS maps, so they are set and
+// read with raw requests. Each map is a list of IFLA_VLAN_QOS_MAPPING
+// attributes holding a struct ifla_vlan_qos_mapping { __u32 from, to; }.
+const iflaVlanQosMapping = 1
+
+// validateQosMaps checks that the PCP side of each mapping is a valid 802.1p
+// priority: the target of egress mappings and the source of ingress ones.
+func validateQosMaps(egress, ingress map[uint32]uint32) error {
+	for from, to := range egress {
+		if to > 7 {
+			return fmt.Errorf("invalid egressQosMap entry %d:%d (PCP must be between 0 and 7)", from, to)
+		}
+	}
+	for from, to := range ingress {
+		if from > 7 {
+			return fmt.Errorf("invalid ingressQosMap entry %d:%d (PCP must be between 0 and 7)", from, to)
+		}
+	}
+	return nil
+}
+
+func addQosMap(data *nl.RtAttr, attrType int, qosMap map[uint32]uint32) {
+	if len(qosMap) == 0 {
+		return
+	}
+	attr := data.AddRtAttr(attrType, nil)
+	for from, to := range qosMap {
+		mapping := make([]byte, 8)
+		native := nl.NativeEndian()
+		native.PutUint32(mapping[0:4], from)
+		native.PutUint32(mapping[4:8], to)
+		attr.AddRtAttr(iflaVlanQosMapping, mapping)
+	}
+}
+
+// setQosMaps applies the egress (skb priority to PCP) and ingress (PCP to skb
+// priority) maps to the VLAN link.
+func setQosMaps(link netlink.Link, egress, ingress map[uint32]uint32) error {
+	if len(egress) == 0 && len(ingress) == 0 {
+		return nil
+	}
+
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	linkInfo := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	linkInfo.AddRtAttr(nl.IFLA_INFO_KIND, nl.NonZeroTerminated("vlan"))
+	data := linkInfo.AddRtAttr(nl.IFLA_INFO_DATA, nil)
+	addQosMap(data, nl.IFLA_VLAN_EGRESS_QOS, egress)
+	addQosMap(data, nl.IFLA_VLAN_INGRESS_QOS, ingress)
+	req.AddData(linkInfo)
+
+	if _, err := req.Execute(unix.NETLINK_ROUTE, 0); err != nil {
+		return fmt.Errorf("failed to set QoS maps of vlan %q: %v", link.Attrs().Name, err)
+	}
+	return nil
+}
+
+// getQosMaps returns the egress and ingress maps of the VLAN link. The kernel
+// leaves out the mappings to 0.
+func getQosMaps(link netlink.Link) (map[uint32]uint32, map[uint32]uint32, error) {
+	req := nl.NewNetlinkRequest(unix.RTM_GETLINK, unix.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWLINK)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get vlan %q: %v", link.Attrs().Name, err)
+	}
+	if len(msgs) != 1 {
+		return nil, nil, fmt.Errorf("failed to get vlan %q: %d messages returned", link.Attrs().Name, len(msgs))
+	}
+	attrs, err := nl.ParseRouteAttr(msgs[0][unix.SizeofIfInfomsg:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	egress := map[uint32]uint32{}
+	ingress := map[uint32]uint32{}
+	native := nl.NativeEndian()
+	for _, attr := range attrs {
+		if attr.Attr.Type != unix.IFLA_LINKINFO {
+			continue
+		}
+		infos, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, info := range infos {
+			if info.Attr.Type != nl.IFLA_INFO_DATA {
+				continue
+			}
+			datas, err := nl.ParseRouteAttr(info.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, d := range datas {
+				var qosMap map[uint32]uint32
+				switch d.Attr.Type {
+				case nl.IFLA_VLAN_EGRESS_QOS:
+					qosMap = egress
+				case nl.IFLA_VLAN_INGRESS_QOS:
+					qosMap = ingress
+				default:
+					continue
+				}
+				mappings, err := nl.ParseRouteAttr(d.Value)
+				if err != nil {
+					return nil, nil, err
+				}
+				for _, m := range mappings {
+					if m.Attr.Type == iflaVlanQosMapping && len(m.Value) >= 8 {
+						qosMap[native.Uint32(m.Value[0:4])] = native.Uint32(m.Value[4:8])
+					}
+				}
+			}
+		}
+	}
+	return egress, ingress, nil
+}
+
+// checkQosMaps verifies the configured mappings of the VLAN link.
+func checkQosMaps(link netlink.Link, egress, ingress map[uint32]uint32) error {
+	if len(egress) == 0 && len(ingress) == 0 {
+		return nil
+	}
+
+	curEgress, curIngress, err := getQosMaps(link)
+	if err != nil {
+		return err
+	}
+	for from, to := range egress {
+		if curEgress[from] != to {
+			return fmt.Errorf("vlan link %s configured egressQosMap %d:%d, current value is %d:%d",
+				link.Attrs().Name, from, to, from, curEgress[from])
+		}
+	}
+	for from, to := range ingress {
+		if curIngress[from] != to {
+			return fmt.Errorf("vlan link %s configured ingressQosMap %d:%d, current value is %d:%d",
+				link.Attrs().Name, from, to, from, curIngress[from])
+		}
+	}
+	return nil
+}
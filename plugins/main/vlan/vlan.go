@@ -26,10 +26,14 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/log"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/trace"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
 
@@ -39,6 +43,16 @@ type NetConf struct {
 	VlanID     int    `json:"vlanId"`
 	MTU        int    `json:"mtu,omitempty"`
 	LinkContNs bool   `json:"linkInContainer,omitempty"`
+	// Protocol is the VLAN protocol of vlanId, "802.1q" or "802.1ad"
+	Protocol string `json:"protocol,omitempty"`
+	// InnerVlanID, when set, makes vlanId the S-VLAN of a QinQ pair: the
+	// S-VLAN is created on the master as <master>.<vlanId> and shared, and
+	// the container gets the 802.1q C-VLAN stacked on it.
+	InnerVlanID int `json:"innerVlanId,omitempty"`
+	// EgressQosMap maps skb priorities to 802.1p PCPs on transmit, and
+	// IngressQosMap PCPs to skb priorities on receive
+	EgressQosMap  map[uint32]uint32 `json:"egressQosMap,omitempty"`
+	IngressQosMap map[uint32]uint32 `json:"ingressQosMap,omitempty"`
 }
 
 func init() {
@@ -49,6 +63,8 @@ func init() {
 }
 
 func loadConf(args *skel.CmdArgs) (*NetConf, string, error) {
+	defer trace.Start("parseConfig").End()
+
 	n := &NetConf{}
 	if err := json.Unmarshal(args.StdinData, n); err != nil {
 		return nil, "", fmt.Errorf("failed to load netconf: %v", err)
@@ -59,6 +75,21 @@ func loadConf(args *skel.CmdArgs) (*NetConf, string, error) {
 	if n.VlanID < 0 || n.VlanID > 4094 {
 		return nil, "", fmt.Errorf("invalid VLAN ID %d (must be between 0 and 4095 inclusive)", n.VlanID)
 	}
+	if _, err := parseProtocol(n.Protocol); err != nil {
+		return nil, "", err
+	}
+	if n.InnerVlanID != 0 {
+		if n.InnerVlanID < 0 || n.InnerVlanID > 4094 {
+			return nil, "", fmt.Errorf("invalid inner VLAN ID %d (must be between 1 and 4094 inclusive)", n.InnerVlanID)
+		}
+		if n.VlanID == 0 {
+			return nil, "", fmt.Errorf("\"innerVlanId\" requires an outer \"vlanId\"")
+		}
+	}
+
+	if err := validateQosMaps(n.EgressQosMap, n.IngressQosMap); err != nil {
+		return nil, "", err
+	}
 
 	// check existing and MTU of master interface
 	masterMTU, err := getMTUByName(n.Master, args.Netns, n.LinkContNs)
@@ -71,6 +102,55 @@ func loadConf(args *skel.CmdArgs) (*NetConf, string, error) {
 	return n, n.CNIVersion, nil
 }
 
+func parseProtocol(s string) (netlink.VlanProtocol, error) {
+	if s == "" {
+		return netlink.VLAN_PROTOCOL_8021Q, nil
+	}
+	protocol := netlink.StringToVlanProtocol(s)
+	if protocol == netlink.VLAN_PROTOCOL_UNKNOWN {
+		return 0, fmt.Errorf("invalid VLAN protocol %q (must be 802.1q or 802.1ad)", s)
+	}
+	return protocol, nil
+}
+
+// ensureServiceVlan returns the S-VLAN of a QinQ pair on master, creating it
+// if needed. It is shared by the containers of the network and is not
+// deleted on DEL.
+func ensureServiceVlan(conf *NetConf, protocol netlink.VlanProtocol, master netlink.Link) (netlink.Link, error) {
+	name := fmt.Sprintf("%s.%d", master.Attrs().Name, conf.VlanID)
+
+	link, err := netlinksafe.LinkByName(name)
+	if err == nil {
+		sVlan, ok := link.(*netlink.Vlan)
+		if !ok || sVlan.ParentIndex != master.Attrs().Index || sVlan.VlanId != conf.VlanID || sVlan.VlanProtocol != protocol {
+			return nil, fmt.Errorf("existing link %q is not VLAN %d (%s) of %q", name, conf.VlanID, protocol, master.Attrs().Name)
+		}
+		return link, nil
+	}
+	if _, ok := err.(netlink.LinkNotFoundError); !ok {
+		return nil, fmt.Errorf("failed to lookup S-VLAN %q: %v", name, err)
+	}
+
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = name
+	linkAttrs.ParentIndex = master.Attrs().Index
+	sVlan := &netlink.Vlan{
+		LinkAttrs:    linkAttrs,
+		VlanId:       conf.VlanID,
+		VlanProtocol: protocol,
+	}
+	if err := netlink.LinkAdd(sVlan); err != nil {
+		return nil, fmt.Errorf("failed to create S-VLAN %q: %v", name, err)
+	}
+	if link, err = netlinksafe.LinkByName(name); err != nil {
+		return nil, fmt.Errorf("failed to lookup S-VLAN %q: %v", name, err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to set S-VLAN %q up: %v", name, err)
+	}
+	return link, nil
+}
+
 func getMTUByName(ifName string, namespace string, inContainer bool) (int, error) {
 	var link netlink.Link
 	var err error
@@ -78,7 +158,7 @@ func getMTUByName(ifName string, namespace string, inContainer bool) (int, error
 		var netns ns.NetNS
 		netns, err = ns.GetNS(namespace)
 		if err != nil {
-			return 0, fmt.Errorf("failed to open netns %q: %v", netns, err)
+			return 0, cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", namespace)
 		}
 		defer netns.Close()
 
@@ -110,7 +190,29 @@ func createVlan(conf *NetConf, ifName string, netns ns.NetNS) (*current.Interfac
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+		return nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup master %q", conf.Master)
+	}
+
+	protocol, err := parseProtocol(conf.Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	vlanID := conf.VlanID
+	if conf.InnerVlanID != 0 {
+		// The container link is the C-VLAN, stacked on the S-VLAN
+		if conf.LinkContNs {
+			err = netns.Do(func(_ ns.NetNS) error {
+				m, err = ensureServiceVlan(conf, protocol, m)
+				return err
+			})
+		} else {
+			m, err = ensureServiceVlan(conf, protocol, m)
+		}
+		if err != nil {
+			return nil, err
+		}
+		vlanID, protocol = conf.InnerVlanID, netlink.VLAN_PROTOCOL_8021Q
 	}
 
 	// due to kernel bug we have to create with tmpname or it might
@@ -127,8 +229,9 @@ func createVlan(conf *NetConf, ifName string, netns ns.NetNS) (*current.Interfac
 	linkAttrs.Namespace = netlink.NsFd(int(netns.Fd()))
 
 	v := &netlink.Vlan{
-		LinkAttrs: linkAttrs,
-		VlanId:    conf.VlanID,
+		LinkAttrs:    linkAttrs,
+		VlanId:       vlanID,
+		VlanProtocol: protocol,
 	}
 
 	if conf.LinkContNs {
@@ -154,7 +257,11 @@ func createVlan(conf *NetConf, ifName string, netns ns.NetNS) (*current.Interfac
 		if err != nil {
 			return fmt.Errorf("failed to refetch vlan %q: %v", vlan.Name, err)
 		}
+		if err := setQosMaps(contVlan, conf.EgressQosMap, conf.IngressQosMap); err != nil {
+			return err
+		}
 		vlan.Mac = contVlan.Attrs().HardwareAddr.String()
+		vlan.Mtu = contVlan.Attrs().MTU
 		vlan.Sandbox = netns.Path()
 
 		return nil
@@ -174,7 +281,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
@@ -182,11 +289,12 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	log.Debug("created vlan link", "master", n.Master, "mac", vlanInterface.Mac)
 
 	// run the IPAM plugin and get back the config to apply
 	r, err := ipam.ExecAdd(n.IPAM.Type, args.StdinData)
 	if err != nil {
-		return fmt.Errorf("failed to execute IPAM delegate: %v", err)
+		return fmt.Errorf("failed to execute IPAM delegate: %w", err)
 	}
 
 	// Invoke ipam del if err to avoid ip leak
@@ -205,6 +313,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if len(result.IPs) == 0 {
 		return errors.New("IPAM plugin returned missing IP config")
 	}
+	log.Debug("IPAM allocated addresses", "ipam", n.IPAM.Type, "ips", result.IPs)
 	for _, ipc := range result.IPs {
 		// All addresses belong to the vlan interface
 		ipc.Interface = current.Int(0)
@@ -261,13 +370,13 @@ func cmdDel(args *skel.CmdArgs) error {
 }
 
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
+	skel.PluginMainFuncs(log.Wrap("vlan", trace.Wrap("vlan", skel.CNIFuncs{
 		Add:    cmdAdd,
 		Check:  cmdCheck,
 		Del:    cmdDel,
 		Status: cmdStatus,
 		/* FIXME GC */
-	}, version.All, bv.BuildString("vlan"))
+	})), version.All, bv.BuildString("vlan"))
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
@@ -275,10 +384,14 @@ func cmdCheck(args *skel.CmdArgs) error {
 	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
 		return fmt.Errorf("failed to load netconf: %v", err)
 	}
+	protocol, err := parseProtocol(conf.Protocol)
+	if err != nil {
+		return err
+	}
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
 	}
 	defer netns.Close()
 
@@ -326,17 +439,29 @@ func cmdCheck(args *skel.CmdArgs) error {
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup master %q", conf.Master)
 	}
 
 	//
 	// Check prevResults for ips, routes and dns against values found in the container
 	if err := netns.Do(func(_ ns.NetNS) error {
 		// Check interface against values found in the container
-		err := validateCniContainerInterface(contMap, conf.VlanID, conf.MTU)
+		vlanID := conf.VlanID
+		if conf.InnerVlanID != 0 {
+			vlanID, protocol = conf.InnerVlanID, netlink.VLAN_PROTOCOL_8021Q
+		}
+		err := validateCniContainerInterface(contMap, vlanID, protocol, conf.MTU)
+		if err != nil {
+			return err
+		}
+
+		link, err := netlinksafe.LinkByName(args.IfName)
 		if err != nil {
 			return err
 		}
+		if err := checkQosMaps(link, conf.EgressQosMap, conf.IngressQosMap); err != nil {
+			return err
+		}
 
 		err = ip.ValidateExpectedInterfaceIPs(args.IfName, result.IPs)
 		if err != nil {
@@ -355,7 +480,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 	return nil
 }
 
-func validateCniContainerInterface(intf current.Interface, vlanID int, mtu int) error {
+func validateCniContainerInterface(intf current.Interface, vlanID int, protocol netlink.VlanProtocol, mtu int) error {
 	var link netlink.Link
 	var err error
 
@@ -391,6 +516,11 @@ func validateCniContainerInterface(intf current.Interface, vlanID int, mtu int)
 			intf.Name, vlanID, vlan.VlanId)
 	}
 
+	if protocol != vlan.VlanProtocol {
+		return fmt.Errorf("Error: vlan link %s configured protocol is %s, current value is %s",
+			intf.Name, protocol, vlan.VlanProtocol)
+	}
+
 	if mtu != 0 {
 		if mtu != link.Attrs().MTU {
 			return fmt.Errorf("Error: Tuning configured MTU of %s is %d, current value is %d",
@@ -407,11 +537,22 @@ func cmdStatus(args *skel.CmdArgs) error {
 		return fmt.Errorf("failed to load netconf: %w", err)
 	}
 
-	if err := ipam.ExecStatus(conf.IPAM.Type, args.StdinData); err != nil {
+	if err := status.Netlink(); err != nil {
+		return err
+	}
+	if err := status.KernelModules("8021q"); err != nil {
 		return err
 	}
+	// The master is only known to exist in the host namespace
+	if conf.Master != "" && !conf.LinkContNs {
+		if err := status.Link(conf.Master); err != nil {
+			return err
+		}
+	}
 
-	// TODO: Check if master interface exists.
+	if err := ipam.ExecStatus(conf.IPAM.Type, args.StdinData); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -170,6 +170,25 @@ func (t *testerV01xOr02x) verifyResult(result types.Result, _ string) string {
 	return ""
 }
 
+var _ = Describe("vlan config", func() {
+	DescribeTable("validates protocol, innerVlanId and QoS maps",
+		func(conf, expectedErr string) {
+			_, _, err := loadConf(&skel.CmdArgs{StdinData: []byte(conf)})
+			Expect(err).To(MatchError(expectedErr))
+		},
+		Entry("unknown protocol", `{"master": "eth0", "vlanId": 100, "protocol": "802.1x"}`,
+			`invalid VLAN protocol "802.1x" (must be 802.1q or 802.1ad)`),
+		Entry("inner VLAN without outer VLAN", `{"master": "eth0", "innerVlanId": 33}`,
+			`"innerVlanId" requires an outer "vlanId"`),
+		Entry("inner VLAN out of range", `{"master": "eth0", "vlanId": 100, "innerVlanId": 4095}`,
+			"invalid inner VLAN ID 4095 (must be between 1 and 4094 inclusive)"),
+		Entry("egress map to an invalid PCP", `{"master": "eth0", "vlanId": 100, "egressQosMap": {"2": 8}}`,
+			"invalid egressQosMap entry 2:8 (PCP must be between 0 and 7)"),
+		Entry("ingress map from an invalid PCP", `{"master": "eth0", "vlanId": 100, "ingressQosMap": {"8": 2}}`,
+			"invalid ingressQosMap entry 8:2 (PCP must be between 0 and 7)"),
+	)
+})
+
 var _ = Describe("vlan Operations", func() {
 	var originalNS, targetNS ns.NetNS
 	var dataDir string
@@ -323,6 +342,111 @@ var _ = Describe("vlan Operations", func() {
 				Expect(err).NotTo(HaveOccurred())
 			})
 
+			It(fmt.Sprintf("[%s] creates a QinQ C-VLAN on an 802.1ad S-VLAN", ver), func() {
+				conf := &NetConf{
+					NetConf: types.NetConf{
+						CNIVersion: ver,
+						Name:       "testConfig",
+						Type:       "vlan",
+					},
+					Master:      masterInterface,
+					VlanID:      100,
+					Protocol:    "802.1ad",
+					InnerVlanID: 33,
+					LinkContNs:  isInContainer,
+				}
+
+				masterNS := originalNS
+				if isInContainer {
+					masterNS = targetNS
+				}
+
+				var vlanInterface *types100.Interface
+				err := originalNS.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					var err error
+					vlanInterface, err = createVlan(conf, "foobar0", targetNS)
+					Expect(err).NotTo(HaveOccurred())
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				var sVlanIndex int
+				err = masterNS.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					m, err := netlinksafe.LinkByName(masterInterface)
+					Expect(err).NotTo(HaveOccurred())
+					link, err := netlinksafe.LinkByName(masterInterface + ".100")
+					Expect(err).NotTo(HaveOccurred())
+					sVlan := link.(*netlink.Vlan)
+					Expect(sVlan.ParentIndex).To(Equal(m.Attrs().Index))
+					Expect(sVlan.VlanId).To(Equal(100))
+					Expect(sVlan.VlanProtocol).To(Equal(netlink.VLAN_PROTOCOL_8021AD))
+					sVlanIndex = sVlan.Index
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = targetNS.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					link, err := netlinksafe.LinkByName("foobar0")
+					Expect(err).NotTo(HaveOccurred())
+					cVlan := link.(*netlink.Vlan)
+					Expect(cVlan.ParentIndex).To(Equal(sVlanIndex))
+					Expect(cVlan.VlanId).To(Equal(33))
+					Expect(cVlan.VlanProtocol).To(Equal(netlink.VLAN_PROTOCOL_8021Q))
+
+					Expect(validateCniContainerInterface(*vlanInterface, 33, netlink.VLAN_PROTOCOL_8021Q, 0)).To(Succeed())
+					Expect(validateCniContainerInterface(*vlanInterface, 33, netlink.VLAN_PROTOCOL_8021AD, 0)).To(HaveOccurred())
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It(fmt.Sprintf("[%s] creates a vlan link with QoS maps", ver), func() {
+				conf := &NetConf{
+					NetConf: types.NetConf{
+						CNIVersion: ver,
+						Name:       "testConfig",
+						Type:       "vlan",
+					},
+					Master:        masterInterface,
+					VlanID:        33,
+					EgressQosMap:  map[uint32]uint32{1: 3, 6: 5},
+					IngressQosMap: map[uint32]uint32{3: 1, 5: 6},
+					LinkContNs:    isInContainer,
+				}
+
+				err := originalNS.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					_, err := createVlan(conf, "foobar0", targetNS)
+					Expect(err).NotTo(HaveOccurred())
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = targetNS.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					link, err := netlinksafe.LinkByName("foobar0")
+					Expect(err).NotTo(HaveOccurred())
+					egress, ingress, err := getQosMaps(link)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(egress).To(Equal(conf.EgressQosMap))
+					Expect(ingress).To(Equal(conf.IngressQosMap))
+
+					Expect(checkQosMaps(link, conf.EgressQosMap, conf.IngressQosMap)).To(Succeed())
+					Expect(checkQosMaps(link, map[uint32]uint32{1: 4}, nil)).To(MatchError(
+						"vlan link foobar0 configured egressQosMap 1:4, current value is 1:3"))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
 			It(fmt.Sprintf("[%s] configures and deconfigures a vlan link with ADD/CHECK/DEL", ver), func() {
 				const IFNAME = "ethX"
 
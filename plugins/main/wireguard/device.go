@@ -0,0 +1,331 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// WireGuard devices are configured over the "wireguard" generic netlink
+// family, see include/uapi/linux/wireguard.h.
+
+const (
+	wgGenlName    = "wireguard"
+	wgGenlVersion = 1
+
+	wgCmdGetDevice = 0
+	wgCmdSetDevice = 1
+
+	wgDeviceAIfIndex    = 1
+	wgDeviceAPrivateKey = 3
+	wgDeviceAPublicKey  = 4
+	wgDeviceAFlags      = 5
+	wgDeviceAListenPort = 6
+	wgDeviceAPeers      = 8
+
+	wgDeviceFReplacePeers = 1
+
+	wgPeerAPublicKey    = 1
+	wgPeerAPresharedKey = 2
+	wgPeerAFlags        = 3
+	wgPeerAEndpoint     = 4
+	wgPeerAKeepalive    = 5
+	wgPeerAAllowedIPs   = 9
+
+	wgPeerFReplaceAllowedIPs = 2
+
+	wgAllowedIPAFamily   = 1
+	wgAllowedIPAIPAddr   = 2
+	wgAllowedIPACIDRMask = 3
+
+	keyLen = 32
+)
+
+// device is the configuration of a WireGuard interface.
+type device struct {
+	privateKey []byte
+	publicKey  []byte
+	listenPort int
+	peers      []peer
+}
+
+type peer struct {
+	publicKey    []byte
+	presharedKey []byte
+	endpoint     *net.UDPAddr
+	keepalive    int
+	allowedIPs   []net.IPNet
+}
+
+// parseKey decodes a base64 key, as printed by wg(8).
+func parseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != keyLen {
+		return nil, fmt.Errorf("invalid key %q: must be %d base64 encoded bytes", s, keyLen)
+	}
+	return key, nil
+}
+
+func encodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// generateKey returns a new private key.
+func generateKey() ([]byte, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %v", err)
+	}
+	return key.Bytes(), nil
+}
+
+// publicKey returns the public key of privateKey.
+func publicKey(privateKey []byte) ([]byte, error) {
+	key, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return key.PublicKey().Bytes(), nil
+}
+
+func familyID() (int, error) {
+	family, err := netlink.GenlFamilyGet(wgGenlName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the %s generic netlink family: %v", wgGenlName, err)
+	}
+	return int(family.ID), nil
+}
+
+func sockaddr(addr *net.UDPAddr) []byte {
+	port := nl.BEUint16Attr(uint16(addr.Port))
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		// struct sockaddr_in
+		b := make([]byte, 16)
+		nl.NativeEndian().PutUint16(b, unix.AF_INET)
+		copy(b[2:], port)
+		copy(b[4:], ip4)
+		return b
+	}
+	// struct sockaddr_in6
+	b := make([]byte, 28)
+	nl.NativeEndian().PutUint16(b, unix.AF_INET6)
+	copy(b[2:], port)
+	copy(b[8:], addr.IP.To16())
+	return b
+}
+
+func parseSockaddr(b []byte) *net.UDPAddr {
+	if len(b) < 4 {
+		return nil
+	}
+	port := int(binary.BigEndian.Uint16(b[2:]))
+	switch nl.NativeEndian().Uint16(b) {
+	case unix.AF_INET:
+		if len(b) >= 8 {
+			return &net.UDPAddr{IP: net.IP(b[4:8]), Port: port}
+		}
+	case unix.AF_INET6:
+		if len(b) >= 24 {
+			return &net.UDPAddr{IP: net.IP(b[8:24]), Port: port}
+		}
+	}
+	return nil
+}
+
+// deviceAttrs returns the attributes of a WG_CMD_SET_DEVICE message
+// replacing the configuration of the interface of index with dev.
+func deviceAttrs(index int, dev *device) []*nl.RtAttr {
+	attrs := []*nl.RtAttr{
+		nl.NewRtAttr(wgDeviceAIfIndex, nl.Uint32Attr(uint32(index))),
+		nl.NewRtAttr(wgDeviceAFlags, nl.Uint32Attr(wgDeviceFReplacePeers)),
+		nl.NewRtAttr(wgDeviceAListenPort, nl.Uint16Attr(uint16(dev.listenPort))),
+	}
+	if dev.privateKey != nil {
+		attrs = append(attrs, nl.NewRtAttr(wgDeviceAPrivateKey, dev.privateKey))
+	}
+
+	peers := nl.NewRtAttr(unix.NLA_F_NESTED|wgDeviceAPeers, nil)
+	for _, p := range dev.peers {
+		attr := peers.AddRtAttr(unix.NLA_F_NESTED, nil)
+		attr.AddRtAttr(wgPeerAPublicKey, p.publicKey)
+		attr.AddRtAttr(wgPeerAFlags, nl.Uint32Attr(wgPeerFReplaceAllowedIPs))
+		if p.presharedKey != nil {
+			attr.AddRtAttr(wgPeerAPresharedKey, p.presharedKey)
+		}
+		if p.endpoint != nil {
+			attr.AddRtAttr(wgPeerAEndpoint, sockaddr(p.endpoint))
+		}
+		attr.AddRtAttr(wgPeerAKeepalive, nl.Uint16Attr(uint16(p.keepalive)))
+
+		allowedIPs := attr.AddRtAttr(unix.NLA_F_NESTED|wgPeerAAllowedIPs, nil)
+		for _, ipn := range p.allowedIPs {
+			family, addr := unix.AF_INET6, ipn.IP.To16()
+			if ip4 := ipn.IP.To4(); ip4 != nil {
+				family, addr = unix.AF_INET, ip4
+			}
+			ones, _ := ipn.Mask.Size()
+
+			allowedIP := allowedIPs.AddRtAttr(unix.NLA_F_NESTED, nil)
+			allowedIP.AddRtAttr(wgAllowedIPAFamily, nl.Uint16Attr(uint16(family)))
+			allowedIP.AddRtAttr(wgAllowedIPAIPAddr, addr)
+			allowedIP.AddRtAttr(wgAllowedIPACIDRMask, nl.Uint8Attr(uint8(ones)))
+		}
+	}
+	return append(attrs, peers)
+}
+
+// parseDevice merges into dev the attributes of a WG_CMD_GET_DEVICE reply.
+// Devices with many peers are dumped over several messages.
+func parseDevice(dev *device, attrs []byte) error {
+	parsed, err := nl.ParseRouteAttr(attrs)
+	if err != nil {
+		return err
+	}
+	for _, attr := range parsed {
+		switch attr.Attr.Type &^ unix.NLA_F_NESTED {
+		case wgDeviceAPrivateKey:
+			dev.privateKey = attr.Value
+		case wgDeviceAPublicKey:
+			dev.publicKey = attr.Value
+		case wgDeviceAListenPort:
+			dev.listenPort = int(nl.NativeEndian().Uint16(attr.Value))
+		case wgDeviceAPeers:
+			peers, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return err
+			}
+			for _, p := range peers {
+				if err := parsePeer(dev, p.Value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func parsePeer(dev *device, attrs []byte) error {
+	parsed, err := nl.ParseRouteAttr(attrs)
+	if err != nil {
+		return err
+	}
+
+	p := peer{}
+	for _, attr := range parsed {
+		switch attr.Attr.Type &^ unix.NLA_F_NESTED {
+		case wgPeerAPublicKey:
+			p.publicKey = attr.Value
+		case wgPeerAPresharedKey:
+			p.presharedKey = attr.Value
+		case wgPeerAEndpoint:
+			p.endpoint = parseSockaddr(attr.Value)
+		case wgPeerAKeepalive:
+			p.keepalive = int(nl.NativeEndian().Uint16(attr.Value))
+		case wgPeerAAllowedIPs:
+			allowedIPs, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return err
+			}
+			for _, a := range allowedIPs {
+				ipn, err := parseAllowedIP(a.Value)
+				if err != nil {
+					return err
+				}
+				p.allowedIPs = append(p.allowedIPs, *ipn)
+			}
+		}
+	}
+
+	// A peer split over two messages is continued by the second one
+	if n := len(dev.peers); n > 0 && string(dev.peers[n-1].publicKey) == string(p.publicKey) {
+		dev.peers[n-1].allowedIPs = append(dev.peers[n-1].allowedIPs, p.allowedIPs...)
+		return nil
+	}
+	dev.peers = append(dev.peers, p)
+	return nil
+}
+
+func parseAllowedIP(attrs []byte) (*net.IPNet, error) {
+	parsed, err := nl.ParseRouteAttr(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var addr net.IP
+	var ones int
+	for _, attr := range parsed {
+		switch attr.Attr.Type {
+		case wgAllowedIPAIPAddr:
+			addr = net.IP(attr.Value)
+		case wgAllowedIPACIDRMask:
+			ones = int(attr.Value[0])
+		}
+	}
+	if addr == nil {
+		return nil, fmt.Errorf("allowed IP without address")
+	}
+	return &net.IPNet{IP: addr, Mask: net.CIDRMask(ones, 8*len(addr))}, nil
+}
+
+// setDevice replaces the configuration of the interface of index with dev.
+func setDevice(index int, dev *device) error {
+	id, err := familyID()
+	if err != nil {
+		return err
+	}
+
+	req := nl.NewNetlinkRequest(id, unix.NLM_F_ACK)
+	req.AddData(&nl.Genlmsg{Command: wgCmdSetDevice, Version: wgGenlVersion})
+	for _, attr := range deviceAttrs(index, dev) {
+		req.AddData(attr)
+	}
+	if _, err := req.Execute(unix.NETLINK_GENERIC, 0); err != nil {
+		return fmt.Errorf("failed to configure WireGuard device: %v", err)
+	}
+	return nil
+}
+
+// getDevice returns the configuration of the interface of index.
+func getDevice(index int) (*device, error) {
+	id, err := familyID()
+	if err != nil {
+		return nil, err
+	}
+
+	req := nl.NewNetlinkRequest(id, unix.NLM_F_DUMP)
+	req.AddData(&nl.Genlmsg{Command: wgCmdGetDevice, Version: wgGenlVersion})
+	req.AddData(nl.NewRtAttr(wgDeviceAIfIndex, nl.Uint32Attr(uint32(index))))
+	msgs, err := req.Execute(unix.NETLINK_GENERIC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get WireGuard device: %v", err)
+	}
+
+	dev := &device{}
+	for _, msg := range msgs {
+		if err := parseDevice(dev, msg[nl.SizeofGenlmsg:]); err != nil {
+			return nil, fmt.Errorf("failed to parse WireGuard device: %v", err)
+		}
+	}
+	return dev, nil
+}
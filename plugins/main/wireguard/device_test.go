@@ -0,0 +1,187 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// Keys of Alice and Bob from RFC 7748
+const (
+	testPrivateKey = "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+	testPublicKey  = "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo="
+	testPeerKey    = "3p7bfXt9wbTTW2HC7OQ1Nz+DQ8hbeGdNrfx+FG+IK08="
+)
+
+var _ = Describe("wireguard device", func() {
+	Describe("keys", func() {
+		It("derives the public key", func() {
+			private, err := parseKey(testPrivateKey)
+			Expect(err).NotTo(HaveOccurred())
+			public, err := publicKey(private)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encodeKey(public)).To(Equal(testPublicKey))
+		})
+
+		It("generates private keys", func() {
+			a, err := generateKey()
+			Expect(err).NotTo(HaveOccurred())
+			b, err := generateKey()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(a).To(HaveLen(keyLen))
+			Expect(a).NotTo(Equal(b))
+		})
+
+		It("rejects invalid keys", func() {
+			for _, key := range []string{"", "not base64!", "AAAA"} {
+				_, err := parseKey(key)
+				Expect(err).To(HaveOccurred(), key)
+			}
+		})
+	})
+
+	Describe("configuration", func() {
+		It("adds the runtime peers to the configured ones", func() {
+			n, dev, err := loadConf([]byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "wireguard",
+				"listenPort": 51820,
+				"peers": [{"publicKey": %q, "allowedIPs": ["10.0.0.0/8"]}],
+				"runtimeConfig": {"wireguard": {
+					"privateKey": %q,
+					"peers": [{"publicKey": %q, "endpoint": "192.0.2.1:51820", "allowedIPs": ["fd00::/64"], "persistentKeepalive": 25}]
+				}}
+			}`, testPeerKey, testPrivateKey, testPublicKey)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.DataDir).To(Equal(defaultDataDir))
+			Expect(n.Peers).To(HaveLen(1))
+
+			Expect(encodeKey(dev.privateKey)).To(Equal(testPrivateKey))
+			Expect(dev.listenPort).To(Equal(51820))
+			Expect(dev.peers).To(HaveLen(2))
+			Expect(dev.peers[1].endpoint.String()).To(Equal("192.0.2.1:51820"))
+			Expect(dev.peers[1].keepalive).To(Equal(25))
+			Expect(dev.peers[1].allowedIPs[0].String()).To(Equal("fd00::/64"))
+		})
+
+		It("leaves the private key to be generated", func() {
+			_, dev, err := loadConf([]byte(`{"cniVersion": "1.0.0", "name": "test", "type": "wireguard"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dev.privateKey).To(BeNil())
+		})
+
+		It("rejects invalid configurations", func() {
+			for _, conf := range []string{
+				`{"privateKey": "AAAA"}`,
+				`{"listenPort": 70000}`,
+				`{"mtu": -1}`,
+				`{"peers": [{"publicKey": "AAAA"}]}`,
+				fmt.Sprintf(`{"peers": [{"publicKey": %q, "allowedIPs": ["10.0.0.1"]}]}`, testPeerKey),
+				fmt.Sprintf(`{"peers": [{"publicKey": %q, "endpoint": "192.0.2.1"}]}`, testPeerKey),
+				fmt.Sprintf(`{"runtimeConfig": {"wireguard": {"peers": [{"publicKey": %q, "persistentKeepalive": -1}]}}}`, testPeerKey),
+			} {
+				_, _, err := loadConf([]byte(conf))
+				Expect(err).To(HaveOccurred(), conf)
+			}
+		})
+	})
+
+	Describe("netlink attributes", func() {
+		It("parses back the device it configures", func() {
+			_, dev, err := loadConf([]byte(fmt.Sprintf(`{
+				"privateKey": %q,
+				"listenPort": 51820,
+				"peers": [
+					{"publicKey": %q, "presharedKey": %q, "endpoint": "192.0.2.1:51820", "allowedIPs": ["10.0.0.0/8", "0.0.0.0/0"]},
+					{"publicKey": %q, "endpoint": "[2001:db8::1]:4500", "allowedIPs": ["fd00::/64"], "persistentKeepalive": 25}
+				]
+			}`, testPrivateKey, testPeerKey, testPublicKey, testPublicKey)))
+			Expect(err).NotTo(HaveOccurred())
+
+			data := []byte{}
+			for _, attr := range deviceAttrs(7, dev) {
+				data = append(data, attr.Serialize()...)
+			}
+			parsed := &device{}
+			Expect(parseDevice(parsed, data)).To(Succeed())
+
+			Expect(parsed.privateKey).To(Equal(dev.privateKey))
+			Expect(parsed.listenPort).To(Equal(51820))
+			Expect(parsed.peers).To(HaveLen(2))
+
+			Expect(parsed.peers[0].publicKey).To(Equal(dev.peers[0].publicKey))
+			Expect(parsed.peers[0].presharedKey).To(Equal(dev.peers[0].presharedKey))
+			Expect(parsed.peers[0].endpoint.String()).To(Equal("192.0.2.1:51820"))
+			Expect(parsed.peers[0].allowedIPs).To(Equal(dev.peers[0].allowedIPs))
+
+			Expect(parsed.peers[1].endpoint.String()).To(Equal("[2001:db8::1]:4500"))
+			Expect(parsed.peers[1].keepalive).To(Equal(25))
+			Expect(parsed.peers[1].allowedIPs).To(Equal(dev.peers[1].allowedIPs))
+		})
+
+		It("merges peers split over several messages", func() {
+			_, dev, err := loadConf([]byte(fmt.Sprintf(`{
+				"peers": [{"publicKey": %q, "allowedIPs": ["10.0.0.0/8"]}]
+			}`, testPeerKey)))
+			Expect(err).NotTo(HaveOccurred())
+			_, next, err := loadConf([]byte(fmt.Sprintf(`{
+				"peers": [{"publicKey": %q, "allowedIPs": ["fd00::/64"]}]
+			}`, testPeerKey)))
+			Expect(err).NotTo(HaveOccurred())
+
+			parsed := &device{}
+			for _, d := range []*device{dev, next} {
+				attrs := deviceAttrs(7, d)
+				Expect(parseDevice(parsed, attrs[len(attrs)-1].Serialize())).To(Succeed())
+			}
+			Expect(parsed.peers).To(HaveLen(1))
+			Expect(parsed.peers[0].allowedIPs).To(HaveLen(2))
+		})
+	})
+
+	Describe("routes", func() {
+		It("routes the allowed IPs not already routed", func() {
+			_, dev, err := loadConf([]byte(fmt.Sprintf(`{
+				"peers": [
+					{"publicKey": %q, "allowedIPs": ["10.1.0.0/24", "10.1.0.128/25", "10.2.0.0/16", "0.0.0.0/0"]},
+					{"publicKey": %q, "allowedIPs": ["10.2.0.0/16", "fd00::/64"]}
+				]
+			}`, testPeerKey, testPublicKey)))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, addr, _ := net.ParseCIDR("10.1.0.2/24")
+			addr.IP = net.ParseIP("10.1.0.2")
+			_, dflt, _ := net.ParseCIDR("0.0.0.0/0")
+			result := &current.Result{
+				IPs:    []*current.IPConfig{{Address: *addr}},
+				Routes: []*types.Route{{Dst: *dflt}},
+			}
+
+			dsts := []string{}
+			for _, r := range peerRoutes(result, dev) {
+				dsts = append(dsts, r.Dst.String())
+			}
+			Expect(dsts).To(Equal([]string{"10.2.0.0/16", "fd00::/64"}))
+		})
+	})
+})
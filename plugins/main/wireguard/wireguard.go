@@ -0,0 +1,557 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This plugin attaches containers with a WireGuard interface, giving them an
+// encrypted point-to-point link to the configured peers. The interface is
+// created in the container network namespace from the host one, so that its
+// UDP socket stays in the host.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+const defaultDataDir = "/var/lib/cni/wireguard"
+
+// PeerConf is a peer of the interface.
+type PeerConf struct {
+	PublicKey           string   `json:"publicKey"`
+	PresharedKey        string   `json:"presharedKey,omitempty"`
+	Endpoint            string   `json:"endpoint,omitempty"`
+	AllowedIPs          []string `json:"allowedIPs"`
+	PersistentKeepalive int      `json:"persistentKeepalive,omitempty"`
+}
+
+// WireguardConf is the WireGuard configuration of the interface, which can
+// also be passed in runtimeConfig.
+type WireguardConf struct {
+	// PrivateKey is generated if unset
+	PrivateKey string     `json:"privateKey,omitempty"`
+	ListenPort int        `json:"listenPort,omitempty"`
+	Peers      []PeerConf `json:"peers,omitempty"`
+}
+
+type NetConf struct {
+	types.NetConf
+	WireguardConf
+
+	MTU int `json:"mtu,omitempty"`
+	// DataDir holds the public keys of the interfaces
+	DataDir string `json:"dataDir,omitempty"`
+
+	RuntimeConfig struct {
+		Wireguard *WireguardConf `json:"wireguard,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+// loadConf parses the configuration and returns it with the WireGuard
+// device to set up. The private key of the device is nil if it is to be
+// generated.
+func loadConf(stdin []byte) (*NetConf, *device, error) {
+	n := &NetConf{
+		DataDir: defaultDataDir,
+	}
+	if err := json.Unmarshal(stdin, n); err != nil {
+		return nil, nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+
+	if n.NetConf.RawPrevResult != nil {
+		if err := version.ParsePrevResult(&n.NetConf); err != nil {
+			return nil, nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+		if _, err := current.NewResultFromResult(n.PrevResult); err != nil {
+			return nil, nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	// The runtime private key and listen port take precedence, its peers
+	// are added to those of the configuration
+	wg := n.WireguardConf
+	if rc := n.RuntimeConfig.Wireguard; rc != nil {
+		if rc.PrivateKey != "" {
+			wg.PrivateKey = rc.PrivateKey
+		}
+		if rc.ListenPort != 0 {
+			wg.ListenPort = rc.ListenPort
+		}
+		wg.Peers = append(append([]PeerConf{}, wg.Peers...), rc.Peers...)
+	}
+
+	if n.MTU < 0 {
+		return nil, nil, fmt.Errorf("invalid MTU %d", n.MTU)
+	}
+
+	dev, err := newDevice(&wg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return n, dev, nil
+}
+
+func newDevice(wg *WireguardConf) (*device, error) {
+	dev := &device{}
+
+	if wg.PrivateKey != "" {
+		key, err := parseKey(wg.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid privateKey: %v", err)
+		}
+		dev.privateKey = key
+	}
+
+	if wg.ListenPort < 0 || wg.ListenPort > 65535 {
+		return nil, fmt.Errorf("invalid listenPort %d", wg.ListenPort)
+	}
+	dev.listenPort = wg.ListenPort
+
+	for i, p := range wg.Peers {
+		var err error
+		dp := peer{keepalive: p.PersistentKeepalive}
+
+		if dp.publicKey, err = parseKey(p.PublicKey); err != nil {
+			return nil, fmt.Errorf("invalid publicKey of peer %d: %v", i, err)
+		}
+		if p.PresharedKey != "" {
+			if dp.presharedKey, err = parseKey(p.PresharedKey); err != nil {
+				return nil, fmt.Errorf("invalid presharedKey of peer %d: %v", i, err)
+			}
+		}
+		if p.Endpoint != "" {
+			if dp.endpoint, err = net.ResolveUDPAddr("udp", p.Endpoint); err != nil {
+				return nil, fmt.Errorf("invalid endpoint %q of peer %d: %v", p.Endpoint, i, err)
+			}
+		}
+		if p.PersistentKeepalive < 0 || p.PersistentKeepalive > 65535 {
+			return nil, fmt.Errorf("invalid persistentKeepalive %d of peer %d", p.PersistentKeepalive, i)
+		}
+		for _, s := range p.AllowedIPs {
+			_, ipn, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed IP %q of peer %d: %v", s, i, err)
+			}
+			dp.allowedIPs = append(dp.allowedIPs, *ipn)
+		}
+
+		dev.peers = append(dev.peers, dp)
+	}
+
+	return dev, nil
+}
+
+// keyPath returns the path of the file holding the public key of the
+// interface of the container.
+func keyPath(n *NetConf, args *skel.CmdArgs) string {
+	return filepath.Join(n.DataDir, fmt.Sprintf("%s-%s-%s.pub", n.Name, args.ContainerID, args.IfName))
+}
+
+func createWireguard(n *NetConf, dev *device, ifName string, netns ns.NetNS) (*current.Interface, error) {
+	// due to kernel bug we have to create with tmpname or it might
+	// collide with the name on the host and error out
+	tmpName, err := ip.RandomVethName()
+	if err != nil {
+		return nil, err
+	}
+
+	// The interface is created in the container namespace, but from the
+	// host one, which is where its socket lives
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.MTU = n.MTU
+	linkAttrs.Name = tmpName
+	linkAttrs.Namespace = netlink.NsFd(int(netns.Fd()))
+
+	if err := netlink.LinkAdd(&netlink.Wireguard{LinkAttrs: linkAttrs}); err != nil {
+		return nil, fmt.Errorf("failed to create wireguard interface: %v", err)
+	}
+
+	wg := &current.Interface{}
+	err = netns.Do(func(_ ns.NetNS) error {
+		err := ip.RenameLink(tmpName, ifName)
+		if err != nil {
+			_ = ip.DelLinkByName(tmpName)
+			return fmt.Errorf("failed to rename wireguard interface to %q: %v", ifName, err)
+		}
+		wg.Name = ifName
+
+		link, err := netlinksafe.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to refetch wireguard interface %q: %v", ifName, err)
+		}
+		wg.Sandbox = netns.Path()
+
+		if err := setDevice(link.Attrs().Index, dev); err != nil {
+			_ = netlink.LinkDel(link)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return wg, nil
+}
+
+// peerRoutes returns the routes of the allowed IPs of the peers, leaving
+// out those already routed to the interface by its addresses or the
+// result routes.
+func peerRoutes(result *current.Result, dev *device) []*types.Route {
+	routes := []*types.Route{}
+	seen := map[string]bool{}
+	for _, r := range result.Routes {
+		seen[r.Dst.String()] = true
+	}
+
+	for _, p := range dev.peers {
+		for _, ipn := range p.allowedIPs {
+			if seen[ipn.String()] || onLink(result.IPs, ipn) {
+				continue
+			}
+			seen[ipn.String()] = true
+			routes = append(routes, &types.Route{Dst: ipn})
+		}
+	}
+	return routes
+}
+
+// onLink returns whether dst is within the subnet of one of ips.
+func onLink(ips []*current.IPConfig, dst net.IPNet) bool {
+	dstOnes, dstBits := dst.Mask.Size()
+	for _, ipc := range ips {
+		ones, bits := ipc.Address.Mask.Size()
+		if bits == dstBits && ones <= dstOnes && ip.Network(&ipc.Address).Contains(dst.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	n, dev, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if dev.privateKey == nil {
+		if dev.privateKey, err = generateKey(); err != nil {
+			return err
+		}
+	}
+	pub, err := publicKey(dev.privateKey)
+	if err != nil {
+		return fmt.Errorf("invalid privateKey: %v", err)
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	wgInterface, err := createWireguard(n, dev, args.IfName, netns)
+	if err != nil {
+		return err
+	}
+
+	// Delete the interface if the rest of the setup fails
+	defer func() {
+		if err != nil {
+			_ = netns.Do(func(_ ns.NetNS) error {
+				return ip.DelLinkByName(args.IfName)
+			})
+		}
+	}()
+
+	var result *current.Result
+	// Configure iface from PrevResult if we have IPs and an IPAM
+	// block has not been configured
+	haveResult := false
+	if n.IPAM.Type == "" && n.PrevResult != nil {
+		result, err = current.NewResultFromResult(n.PrevResult)
+		if err != nil {
+			return err
+		}
+		if len(result.IPs) > 0 {
+			haveResult = true
+		}
+	}
+	if !haveResult {
+		if n.IPAM.Type == "" {
+			err = errors.New("no IPAM configured and no IPs in prevResult")
+			return err
+		}
+
+		// run the IPAM plugin and get back the config to apply
+		var r types.Result
+		r, err = ipam.ExecAdd(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+
+		// Invoke ipam del if err to avoid ip leak
+		defer func() {
+			if err != nil {
+				ipam.ExecDel(n.IPAM.Type, args.StdinData)
+			}
+		}()
+
+		// Convert whatever the IPAM result was into the current Result type
+		result, err = current.NewResultFromResult(r)
+		if err != nil {
+			return err
+		}
+
+		if len(result.IPs) == 0 {
+			err = errors.New("IPAM plugin returned missing IP config")
+			return err
+		}
+	}
+	for _, ipc := range result.IPs {
+		// All addresses belong to the wireguard interface
+		ipc.Interface = current.Int(0)
+	}
+
+	result.Interfaces = []*current.Interface{wgInterface}
+	result.Routes = append(result.Routes, peerRoutes(result, dev)...)
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return ipam.ConfigureIface(args.IfName, result)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(n.DataDir, 0o700); err != nil {
+		return err
+	}
+	if err = os.WriteFile(keyPath(n, args), []byte(encodeKey(pub)+"\n"), 0o644); err != nil {
+		return err
+	}
+
+	result.DNS = n.DNS
+
+	return types.PrintResult(result, n.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	n, _, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	// On chained invocation, IPAM block can be empty
+	if n.IPAM.Type != "" {
+		err = ipam.ExecDel(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(keyPath(n, args)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if args.Netns == "" {
+		return nil
+	}
+
+	// There is a netns so try to clean up. Delete can be called multiple times
+	// so don't return an error if the device is already removed.
+	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		if err := ip.DelLinkByName(args.IfName); err != nil {
+			if err != ip.ErrLinkNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if _, ok := err.(ns.NSPathNotExistErr); ok {
+		return nil
+	}
+	return err
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, dev, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	if n.IPAM.Type != "" {
+		err = ipam.ExecCheck(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.PrevResult == nil {
+		return fmt.Errorf("Required prevResult missing")
+	}
+	result, err := current.NewResultFromResult(n.PrevResult)
+	if err != nil {
+		return err
+	}
+
+	var contMap current.Interface
+	for _, intf := range result.Interfaces {
+		if args.IfName == intf.Name && args.Netns == intf.Sandbox {
+			contMap = *intf
+		}
+	}
+
+	// The namespace must be the same as what was configured
+	if args.Netns != contMap.Sandbox {
+		return fmt.Errorf("Sandbox in prevResult %s doesn't match configured netns: %s",
+			contMap.Sandbox, args.Netns)
+	}
+
+	// Generated keys are only known from their public half
+	var pub []byte
+	if dev.privateKey != nil {
+		pub, err = publicKey(dev.privateKey)
+	} else {
+		var data []byte
+		data, err = os.ReadFile(keyPath(n, args))
+		if err == nil {
+			pub, err = parseKey(strings.TrimSpace(string(data)))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the public key of %s: %v", args.IfName, err)
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		if err := validateDevice(args.IfName, dev, pub); err != nil {
+			return err
+		}
+
+		if err := ip.ValidateExpectedInterfaceIPs(args.IfName, result.IPs); err != nil {
+			return err
+		}
+
+		return ip.ValidateExpectedRoute(result.Routes)
+	})
+}
+
+// validateDevice checks that the interface is a WireGuard one with public
+// key pub, configured as dev.
+func validateDevice(ifName string, expected *device, pub []byte) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("Container Interface name in prevResult: %s not found", ifName)
+	}
+	if _, ok := link.(*netlink.Wireguard); !ok {
+		return fmt.Errorf("Error: Container interface %s not of type wireguard", ifName)
+	}
+
+	dev, err := getDevice(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+
+	if string(dev.publicKey) != string(pub) {
+		return fmt.Errorf("interface %s public key %s does not match expected %s", ifName, encodeKey(dev.publicKey), encodeKey(pub))
+	}
+	if expected.listenPort != 0 && dev.listenPort != expected.listenPort {
+		return fmt.Errorf("interface %s listens on port %d, expected %d", ifName, dev.listenPort, expected.listenPort)
+	}
+
+	peers := map[string]peer{}
+	for _, p := range dev.peers {
+		peers[string(p.publicKey)] = p
+	}
+	if len(peers) != len(expected.peers) {
+		return fmt.Errorf("interface %s has %d peers, expected %d", ifName, len(peers), len(expected.peers))
+	}
+	for _, e := range expected.peers {
+		p, ok := peers[string(e.publicKey)]
+		if !ok {
+			return fmt.Errorf("interface %s is missing peer %s", ifName, encodeKey(e.publicKey))
+		}
+		if !sameNets(p.allowedIPs, e.allowedIPs) {
+			return fmt.Errorf("peer %s of interface %s has allowed IPs %v, expected %v", encodeKey(e.publicKey), ifName, p.allowedIPs, e.allowedIPs)
+		}
+	}
+	return nil
+}
+
+func sameNets(a, b []net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	nets := map[string]bool{}
+	for _, ipn := range a {
+		nets[ipn.String()] = true
+	}
+	for _, ipn := range b {
+		if !nets[ipn.String()] {
+			return false
+		}
+	}
+	return true
+}
+
+func cmdStatus(args *skel.CmdArgs) error {
+	n, _, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.IPAM.Type != "" {
+		if err := ipam.ExecStatus(n.IPAM.Type, args.StdinData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		Status: cmdStatus,
+	}, version.All, bv.BuildString("wireguard"))
+}
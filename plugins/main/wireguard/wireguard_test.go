@@ -0,0 +1,292 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+const ifname = "wg0"
+
+var _ = Describe("wireguard plugin", func() {
+	var originalNS, targetNs ns.NetNS
+	var args *skel.CmdArgs
+	var dataDir string
+
+	ipsOnly := `{"cniVersion": "1.0.0", "ips": [{"address": "10.99.0.2/24"}]}`
+
+	BeforeEach(func() {
+		var err error
+		originalNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		targetNs, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		dataDir = GinkgoT().TempDir()
+
+		args = &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(originalNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(originalNS)).To(Succeed())
+		Expect(targetNs.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(targetNs)).To(Succeed())
+	})
+
+	deviceOf := func() *device {
+		var dev *device
+		err := targetNs.Do(func(_ ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(ifname)
+			if err != nil {
+				return err
+			}
+			dev, err = getDevice(link.Attrs().Index)
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return dev
+	}
+
+	add := func() *current.Result {
+		var r *current.Result
+		err := originalNS.Do(func(_ ns.NetNS) error {
+			res, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+			if err != nil {
+				return err
+			}
+			r, err = current.GetResult(res)
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return r
+	}
+
+	It("sets up, checks and removes a wireguard interface", func() {
+		extra := fmt.Sprintf(`"privateKey": %q, "listenPort": 51820, "peers": [{"publicKey": %q, "endpoint": "192.0.2.1:51820", "allowedIPs": ["10.99.0.0/24", "10.100.0.0/16"]}],`,
+			testPrivateKey, testPeerKey)
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "wireguard",
+			"dataDir": %q,
+			%s
+			"prevResult": %s
+		}`, dataDir, extra, ipsOnly))
+		result := add()
+
+		Expect(result.Interfaces).To(HaveLen(1))
+		Expect(result.Interfaces[0].Name).To(Equal(ifname))
+		Expect(result.Interfaces[0].Sandbox).To(Equal(targetNs.Path()))
+		Expect(result.Routes).To(HaveLen(1))
+		Expect(result.Routes[0].Dst.String()).To(Equal("10.100.0.0/16"))
+
+		dev := deviceOf()
+		Expect(encodeKey(dev.publicKey)).To(Equal(testPublicKey))
+		Expect(dev.listenPort).To(Equal(51820))
+		Expect(dev.peers).To(HaveLen(1))
+		Expect(encodeKey(dev.peers[0].publicKey)).To(Equal(testPeerKey))
+		Expect(dev.peers[0].endpoint.String()).To(Equal("192.0.2.1:51820"))
+
+		// The interface is brought up
+		err := targetNs.Do(func(_ ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(ifname)
+			if err != nil {
+				return err
+			}
+			Expect(link).To(BeAssignableToTypeOf(&netlink.Wireguard{}))
+			Expect(link.Attrs().Flags & net.FlagUp).NotTo(BeZero())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		key, err := os.ReadFile(filepath.Join(dataDir, "test-dummy-wg0.pub"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.TrimSpace(string(key))).To(Equal(testPublicKey))
+
+		prevResult, err := json.Marshal(result)
+		Expect(err).NotTo(HaveOccurred())
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "wireguard",
+			"dataDir": %q,
+			%s
+			"prevResult": %s
+		}`, dataDir, extra, string(prevResult)))
+		err = originalNS.Do(func(_ ns.NetNS) error {
+			return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// CHECK notices peers going missing
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "wireguard",
+			"dataDir": %q,
+			"privateKey": %q,
+			"prevResult": %s
+		}`, dataDir, testPrivateKey, string(prevResult)))
+		err = originalNS.Do(func(_ ns.NetNS) error {
+			return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+		})
+		Expect(err).To(MatchError("interface wg0 has 1 peers, expected 0"))
+
+		err = originalNS.Do(func(_ ns.NetNS) error {
+			return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+		})
+		Expect(err).NotTo(HaveOccurred())
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			_, err := netlinksafe.LinkByName(ifname)
+			return err
+		})
+		Expect(err).To(HaveOccurred())
+		_, err = os.Stat(filepath.Join(dataDir, "test-dummy-wg0.pub"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		err = originalNS.Do(func(_ ns.NetNS) error {
+			return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("generates the private key and checks against its public half", func() {
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "wireguard",
+			"dataDir": %q,
+			"prevResult": %s
+		}`, dataDir, ipsOnly))
+		result := add()
+
+		key, err := os.ReadFile(filepath.Join(dataDir, "test-dummy-wg0.pub"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encodeKey(deviceOf().publicKey)).To(Equal(strings.TrimSpace(string(key))))
+
+		prevResult, err := json.Marshal(result)
+		Expect(err).NotTo(HaveOccurred())
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "wireguard",
+			"dataDir": %q,
+			"prevResult": %s
+		}`, dataDir, string(prevResult)))
+		err = originalNS.Do(func(_ ns.NetNS) error {
+			return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "wireguard",
+			"dataDir": %q,
+			"privateKey": %q,
+			"prevResult": %s
+		}`, dataDir, testPrivateKey, string(prevResult)))
+		err = originalNS.Do(func(_ ns.NetNS) error {
+			return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+		})
+		Expect(err).To(MatchError(ContainSubstring("does not match expected " + testPublicKey)))
+	})
+
+	It("adds the runtime peers and their routes", func() {
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "wireguard",
+			"dataDir": %q,
+			"runtimeConfig": {"wireguard": {"peers": [{"publicKey": %q, "allowedIPs": ["0.0.0.0/0"]}]}},
+			"prevResult": %s
+		}`, dataDir, testPeerKey, ipsOnly))
+		result := add()
+
+		Expect(deviceOf().peers).To(HaveLen(1))
+		Expect(result.Routes).To(HaveLen(1))
+		Expect(result.Routes[0].Dst.String()).To(Equal("0.0.0.0/0"))
+
+		err := targetNs.Do(func(_ ns.NetNS) error {
+			routes, err := netlinksafe.RouteList(nil, netlink.FAMILY_V4)
+			if err != nil {
+				return err
+			}
+			for _, r := range routes {
+				if r.Dst == nil || r.Dst.String() == "0.0.0.0/0" {
+					return nil
+				}
+			}
+			return fmt.Errorf("no default route in %v", routes)
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("requires addresses", func() {
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "wireguard",
+			"dataDir": %q,
+			"prevResult": {"cniVersion": "1.0.0"}
+		}`, dataDir))
+		err := originalNS.Do(func(_ ns.NetNS) error {
+			_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+			return err
+		})
+		Expect(err).To(MatchError("no IPAM configured and no IPs in prevResult"))
+
+		// The interface is removed
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			_, err := netlinksafe.LinkByName(ifname)
+			return err
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("tolerates a missing netns on DEL", func() {
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "wireguard",
+			"dataDir": %q,
+			"prevResult": %s
+		}`, dataDir, ipsOnly))
+		args.Netns = "/var/run/netns/non-existent"
+		err := originalNS.Do(func(_ ns.NetNS) error {
+			return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,380 @@
+// Copyright 2018 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/log"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/utils"
+)
+
+const ifbDevicePrefix = "bwp"
+
+func getIfbDeviceName(networkName string, containerID string) string {
+	return utils.MustFormatHashWithPrefix(maxIfbDeviceLength, ifbDevicePrefix, networkName+containerID)
+}
+
+// getIfbAlias returns the alias of the ifb device of a container, which
+// identifies the network and container it belongs to for GC, as the device
+// name is a hash of both.
+func getIfbAlias(networkName string, containerID string) string {
+	return networkName + "/" + containerID
+}
+
+func getMTU(links *netlinksafe.LinkCache, deviceName string) (int, error) {
+	link, err := links.LinkByName(deviceName)
+	if err != nil {
+		return -1, err
+	}
+
+	return link.Attrs().MTU, nil
+}
+
+// get the veth peer of container interface in host namespace
+func getHostInterface(links *netlinksafe.LinkCache, interfaces []*current.Interface, containerIfName string, netns ns.NetNS) (*current.Interface, error) {
+	if len(interfaces) == 0 {
+		return nil, fmt.Errorf("no interfaces provided")
+	}
+
+	// get veth peer index of container interface
+	var peerIndex int
+	var err error
+	_ = netns.Do(func(_ ns.NetNS) error {
+		_, peerIndex, err = ip.GetVethPeerIfindex(containerIfName)
+		return nil
+	})
+	if peerIndex <= 0 {
+		return nil, fmt.Errorf("container interface %s has no veth peer: %v", containerIfName, err)
+	}
+
+	// find host interface by index
+	link, err := links.LinkByIndex(peerIndex)
+	if err != nil {
+		return nil, fmt.Errorf("veth peer with index %d is not in host ns", peerIndex)
+	}
+	for _, iface := range interfaces {
+		if iface.Sandbox == "" && iface.Name == link.Attrs().Name {
+			return iface, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no veth peer of container interface found in host ns")
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+
+	bandwidth := getBandwidth(conf)
+	if bandwidth == nil || bandwidth.isZero() {
+		return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+	}
+
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	result, err := current.NewResultFromResult(conf.PrevResult)
+	if err != nil {
+		return fmt.Errorf("could not convert result to current version: %v", err)
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	// The host device is looked up again to shape each direction
+	links := netlinksafe.NewLinkCache()
+	hostInterface, err := getHostInterface(links, result.Interfaces, args.IfName, netns)
+	if err != nil {
+		return err
+	}
+
+	if bandwidth.IngressRate > 0 && bandwidth.IngressBurst > 0 {
+		err = CreateIngressQdisc(links, bandwidth.IngressRate, bandwidth.IngressBurst, hostInterface.Name)
+		if err != nil {
+			return err
+		}
+		log.Debug("shaped ingress traffic", "device", hostInterface.Name, "rate", bandwidth.IngressRate, "burst", bandwidth.IngressBurst)
+	}
+
+	if bandwidth.EgressRate > 0 && bandwidth.EgressBurst > 0 {
+		// Egress traffic is sized by the container interface, which plugins
+		// earlier in the chain may have retuned; prefer what they recorded
+		// over reading the devices.
+		mtu := utils.ResultMTU(result, args.IfName, true)
+		if mtu == 0 {
+			mtu = utils.ResultMTU(result, hostInterface.Name, false)
+		}
+		if mtu == 0 {
+			mtu, err = getMTU(links, hostInterface.Name)
+			if err != nil {
+				return err
+			}
+		}
+
+		if conf.SharedIfb != "" {
+			err = CreateSharedEgressQdisc(links, conf, args.ContainerID, args.IfName,
+				bandwidth.EgressRate, bandwidth.EgressBurst, hostInterface.Name, mtu)
+			if err != nil {
+				return err
+			}
+			log.Debug("shaped egress traffic", "device", conf.SharedIfb, "rate", bandwidth.EgressRate, "burst", bandwidth.EgressBurst)
+			return types.PrintResult(result, conf.CNIVersion)
+		}
+
+		ifbDeviceName := getIfbDeviceName(conf.Name, args.ContainerID)
+
+		err = CreateIfb(links, ifbDeviceName, getIfbAlias(conf.Name, args.ContainerID), mtu)
+		if err != nil {
+			return err
+		}
+
+		ifbDevice, err := links.LinkByName(ifbDeviceName)
+		if err != nil {
+			return err
+		}
+
+		result.Interfaces = append(result.Interfaces, &current.Interface{
+			Name: ifbDeviceName,
+			Mac:  ifbDevice.Attrs().HardwareAddr.String(),
+			Mtu:  mtu,
+		})
+		err = CreateEgressQdisc(links, bandwidth.EgressRate, bandwidth.EgressBurst, hostInterface.Name, ifbDeviceName)
+		if err != nil {
+			return err
+		}
+		log.Debug("shaped egress traffic", "device", ifbDeviceName, "rate", bandwidth.EgressRate, "burst", bandwidth.EgressBurst)
+	}
+
+	return types.PrintResult(result, conf.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+
+	if conf.SharedIfb != "" {
+		if err := TeardownSharedEgress(conf, args.ContainerID, args.IfName); err != nil {
+			return err
+		}
+	}
+
+	ifbDeviceName := getIfbDeviceName(conf.Name, args.ContainerID)
+
+	return TeardownIfb(ifbDeviceName)
+}
+
+// cmdGC deletes the ifb devices of any container on this network without a
+// valid attachment, and its classes on the shared ifb device. Devices
+// created by previous versions have no alias naming their network, and are
+// left alone.
+func cmdGC(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+
+	valid := gc.NewAttachments(conf.ValidAttachments)
+	if conf.SharedIfb != "" {
+		if err := gcSharedEgress(conf, valid); err != nil {
+			return err
+		}
+	}
+
+	expected := valid.Names(func(containerID, _ string) string {
+		return getIfbDeviceName(conf.Name, containerID)
+	})
+
+	links, err := netlinksafe.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %v", err)
+	}
+	prefix := getIfbAlias(conf.Name, "")
+	for _, link := range links {
+		attrs := link.Attrs()
+		if link.Type() != "ifb" || !strings.HasPrefix(attrs.Alias, prefix) || expected[attrs.Name] {
+			continue
+		}
+		if err := TeardownIfb(attrs.Name); err != nil {
+			return fmt.Errorf("failed to delete ifb device %s: %v", attrs.Name, err)
+		}
+	}
+	return nil
+}
+
+// cmdStatus checks that the ifb devices and the traffic control objects
+// shaping the traffic are available.
+func cmdStatus(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+	if err := status.Netlink(); err != nil {
+		return err
+	}
+	modules := []string{"ifb", "sch_tbf", "sch_ingress", "cls_u32", "act_mirred"}
+	if conf.SharedIfb != "" {
+		modules = append(modules, "sch_htb", "act_skbedit")
+	}
+	return status.KernelModules(modules...)
+}
+
+func SafeQdiscList(link netlink.Link) ([]netlink.Qdisc, error) {
+	qdiscs, err := netlinksafe.QdiscList(link)
+	if err != nil {
+		return nil, err
+	}
+	result := []netlink.Qdisc{}
+	for _, qdisc := range qdiscs {
+		// filter out pfifo_fast qdiscs because
+		// older kernels don't return them
+		_, pfifo := qdisc.(*netlink.PfifoFast)
+		if !pfifo {
+			result = append(result, qdisc)
+		}
+	}
+	return result, nil
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	bwConf, err := parseConfig(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+
+	if bwConf.PrevResult == nil {
+		return fmt.Errorf("must be called as a chained plugin")
+	}
+
+	result, err := current.NewResultFromResult(bwConf.PrevResult)
+	if err != nil {
+		return fmt.Errorf("could not convert result to current version: %v", err)
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	links := netlinksafe.NewLinkCache()
+	hostInterface, err := getHostInterface(links, result.Interfaces, args.IfName, netns)
+	if err != nil {
+		return err
+	}
+	link, err := links.LinkByName(hostInterface.Name)
+	if err != nil {
+		return err
+	}
+
+	bandwidth := getBandwidth(bwConf)
+
+	if bandwidth.IngressRate > 0 && bandwidth.IngressBurst > 0 {
+		rateInBytes := bandwidth.IngressRate / 8
+		burstInBytes := bandwidth.IngressBurst / 8
+		bufferInBytes := buffer(rateInBytes, uint32(burstInBytes))
+		latency := latencyInUsec(latencyInMillis)
+		limitInBytes := limit(rateInBytes, latency, uint32(burstInBytes))
+
+		qdiscs, err := SafeQdiscList(link)
+		if err != nil {
+			return err
+		}
+		if len(qdiscs) == 0 {
+			return fmt.Errorf("Failed to find qdisc")
+		}
+
+		for _, qdisc := range qdiscs {
+			tbf, isTbf := qdisc.(*netlink.Tbf)
+			if !isTbf {
+				break
+			}
+			if tbf.Rate != rateInBytes {
+				return fmt.Errorf("Rate doesn't match")
+			}
+			if tbf.Limit != limitInBytes {
+				return fmt.Errorf("Limit doesn't match")
+			}
+			if tbf.Buffer != bufferInBytes {
+				return fmt.Errorf("Buffer doesn't match")
+			}
+		}
+	}
+
+	if bandwidth.EgressRate > 0 && bandwidth.EgressBurst > 0 && bwConf.SharedIfb != "" {
+		return checkSharedEgress(bwConf, args.ContainerID, args.IfName, link, bandwidth.EgressRate, bandwidth.EgressBurst)
+	}
+
+	if bandwidth.EgressRate > 0 && bandwidth.EgressBurst > 0 {
+		rateInBytes := bandwidth.EgressRate / 8
+		burstInBytes := bandwidth.EgressBurst / 8
+		bufferInBytes := buffer(rateInBytes, uint32(burstInBytes))
+		latency := latencyInUsec(latencyInMillis)
+		limitInBytes := limit(rateInBytes, latency, uint32(burstInBytes))
+
+		ifbDeviceName := getIfbDeviceName(bwConf.Name, args.ContainerID)
+
+		ifbDevice, err := netlinksafe.LinkByName(ifbDeviceName)
+		if err != nil {
+			return fmt.Errorf("get ifb device: %s", err)
+		}
+
+		qdiscs, err := SafeQdiscList(ifbDevice)
+		if err != nil {
+			return err
+		}
+		if len(qdiscs) == 0 {
+			return fmt.Errorf("Failed to find qdisc")
+		}
+
+		for _, qdisc := range qdiscs {
+			tbf, isTbf := qdisc.(*netlink.Tbf)
+			if !isTbf {
+				break
+			}
+			if tbf.Rate != rateInBytes {
+				return fmt.Errorf("Rate doesn't match")
+			}
+			if tbf.Limit != limitInBytes {
+				return fmt.Errorf("Limit doesn't match")
+			}
+			if tbf.Buffer != bufferInBytes {
+				return fmt.Errorf("Buffer doesn't match")
+			}
+		}
+	}
+
+	return nil
+}
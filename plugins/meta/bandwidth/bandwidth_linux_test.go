@@ -1044,6 +1044,246 @@ var _ = Describe("bandwidth test", func() {
 		})
 	}
 
+	Describe("cmdGC", func() {
+		It("deletes the ifb devices of stale containers on the network", func() {
+			Expect(hostNs.Do(func(_ ns.NetNS) error {
+				defer GinkgoRecover()
+
+				for _, owner := range []struct{ network, containerID string }{
+					{"net1", "c1"},
+					{"net1", "c2"},
+					{"net2", "c2"},
+				} {
+					name := getIfbDeviceName(owner.network, owner.containerID)
+					Expect(CreateIfb(nil, name, getIfbAlias(owner.network, owner.containerID), 1500)).To(Succeed())
+				}
+				// Devices of previous versions have no alias
+				Expect(CreateIfb(nil, getIfbDeviceName("net1", "c3"), "", 1500)).To(Succeed())
+
+				conf := []byte(`{
+					"cniVersion": "1.1.0",
+					"name": "net1",
+					"type": "bandwidth",
+					"cni.dev/valid-attachments": [{"containerID": "c1", "ifname": "eth0"}]
+				}`)
+				Expect(cmdGC(&skel.CmdArgs{StdinData: conf})).To(Succeed())
+
+				for _, remaining := range []string{
+					getIfbDeviceName("net1", "c1"),
+					getIfbDeviceName("net2", "c2"),
+					getIfbDeviceName("net1", "c3"),
+				} {
+					_, err := netlinksafe.LinkByName(remaining)
+					Expect(err).NotTo(HaveOccurred(), remaining)
+				}
+				_, err := netlinksafe.LinkByName(getIfbDeviceName("net1", "c2"))
+				Expect(err).To(HaveOccurred())
+				return nil
+			})).To(Succeed())
+		})
+	})
+
+	Describe("with a shared ifb device", func() {
+		var dataDir string
+
+		BeforeEach(func() {
+			dataDir = GinkgoT().TempDir()
+		})
+
+		sharedConf := func(name, hostIfname, containerIfname string) string {
+			return fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": %q,
+				"type": "bandwidth",
+				"egressRate": 16000,
+				"egressBurst": 8000,
+				"sharedIfb": "bwshared0",
+				"dataDir": %q,
+				"prevResult": {
+					"interfaces": [
+						{"name": %q, "sandbox": ""},
+						{"name": %q, "sandbox": %q}
+					],
+					"ips": [{"address": "%s/24", "gateway": "10.0.0.1", "interface": 1}]
+				}
+			}`, name, dataDir, hostIfname, containerIfname, containerNs.Path(), containerIP.String())
+		}
+
+		It("shapes the egress traffic with a class of the shared device with ADD/CHECK/DEL", func() {
+			conf := sharedConf("cni-plugin-bandwidth-test", hostIfname, containerIfname)
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       containerNs.Path(),
+				IfName:      containerIfname,
+				StdinData:   []byte(conf),
+			}
+
+			Expect(hostNs.Do(func(_ ns.NetNS) error {
+				defer GinkgoRecover()
+				r, out, err := testutils.CmdAdd(containerNs.Path(), args.ContainerID, "", []byte(conf), func() error { return cmdAdd(args) })
+				Expect(err).NotTo(HaveOccurred(), string(out))
+				result, err := types100.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Interfaces).To(HaveLen(2))
+
+				// No ifb device of the container
+				_, err = netlinksafe.LinkByName(ifbDeviceName)
+				Expect(err).To(HaveOccurred())
+
+				ifbLink, err := netlinksafe.LinkByName("bwshared0")
+				Expect(err).NotTo(HaveOccurred())
+				qdiscs, err := netlinksafe.QdiscList(ifbLink)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(qdiscs).To(HaveLen(1))
+				Expect(qdiscs[0]).To(BeAssignableToTypeOf(&netlink.Htb{}))
+
+				classes, err := netlinksafe.ClassList(ifbLink, netlink.MakeHandle(1, 0))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(classes).To(HaveLen(1))
+				Expect(classes[0].Attrs().Handle).To(Equal(netlink.MakeHandle(1, 1)))
+				Expect(classes[0].(*netlink.HtbClass).Rate).To(Equal(uint64(2000)))
+
+				hostVethLink, err := netlinksafe.LinkByName(hostIfname)
+				Expect(err).NotTo(HaveOccurred())
+				filters, err := netlinksafe.FilterList(hostVethLink, netlink.MakeHandle(0xffff, 0))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(filters).To(HaveLen(1))
+				Expect(filters[0].(*netlink.U32).RedirIndex).To(Equal(ifbLink.Attrs().Index))
+
+				args.StdinData, err = buildOneConfig("cni-plugin-bandwidth-test", "1.0.0", &PluginConf{
+					SharedIfb:      "bwshared0",
+					DataDir:        dataDir,
+					BandwidthEntry: &BandwidthEntry{EgressRate: 16000, EgressBurst: 8000},
+				}, result)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(testutils.CmdCheck(containerNs.Path(), args.ContainerID, "", func() error { return cmdCheck(args) })).To(Succeed())
+
+				Expect(testutils.CmdDel(containerNs.Path(), args.ContainerID, "", func() error { return cmdDel(args) })).To(Succeed())
+
+				classes, err = netlinksafe.ClassList(ifbLink, netlink.MakeHandle(1, 0))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(classes).To(BeEmpty())
+				filters, err = netlinksafe.FilterList(hostVethLink, netlink.MakeHandle(0xffff, 0))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(filters).To(BeEmpty())
+
+				// The shared device is kept
+				_, err = netlinksafe.LinkByName("bwshared0")
+				Expect(err).NotTo(HaveOccurred())
+				return nil
+			})).To(Succeed())
+		})
+
+		It("leaves no class nor record behind when ADD fails", func() {
+			conf := sharedConf("cni-plugin-bandwidth-test", hostIfname, containerIfname)
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       containerNs.Path(),
+				IfName:      containerIfname,
+				StdinData:   []byte(conf),
+			}
+
+			Expect(hostNs.Do(func(_ ns.NetNS) error {
+				defer GinkgoRecover()
+
+				// Someone else's ingress qdisc makes the ADD fail
+				hostVethLink, err := netlinksafe.LinkByName(hostIfname)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.QdiscAdd(&netlink.Ingress{
+					QdiscAttrs: netlink.QdiscAttrs{
+						LinkIndex: hostVethLink.Attrs().Index,
+						Handle:    netlink.MakeHandle(0xffff, 0),
+						Parent:    netlink.HANDLE_INGRESS,
+					},
+				})).To(Succeed())
+
+				_, _, err = testutils.CmdAdd(containerNs.Path(), args.ContainerID, "", []byte(conf), func() error { return cmdAdd(args) })
+				Expect(err).To(MatchError(ContainSubstring("create ingress qdisc")))
+
+				ifbLink, err := netlinksafe.LinkByName("bwshared0")
+				Expect(err).NotTo(HaveOccurred())
+				classes, err := netlinksafe.ClassList(ifbLink, netlink.MakeHandle(1, 0))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(classes).To(BeEmpty())
+
+				keys, err := sharedIfbStore(&PluginConf{
+					NetConf:   types.NetConf{Name: "cni-plugin-bandwidth-test"},
+					SharedIfb: "bwshared0",
+					DataDir:   dataDir,
+				}).List()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(keys).To(BeEmpty())
+
+				qdiscs, err := netlinksafe.QdiscList(hostVethLink)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(qdiscs).To(ContainElement(BeAssignableToTypeOf(&netlink.Ingress{})))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("deletes the classes of stale attachments on the network with GC", func() {
+			owners := []struct{ network, containerID string }{
+				{"net1", "c1"},
+				{"net1", "c2"},
+				{"net2", "c2"},
+			}
+			for i := range owners {
+				createVeth(hostNs, fmt.Sprintf("host-veth%d", i), containerNs, fmt.Sprintf("cont-veth%d", i),
+					net.IP{169, 254, 1, byte(i + 1)}, net.IP{10, 254, 1, byte(i + 1)}, hostIfaceMTU)
+			}
+
+			Expect(hostNs.Do(func(_ ns.NetNS) error {
+				defer GinkgoRecover()
+
+				for i, owner := range owners {
+					containerIfname := fmt.Sprintf("cont-veth%d", i)
+					conf := sharedConf(owner.network, fmt.Sprintf("host-veth%d", i), containerIfname)
+					args := &skel.CmdArgs{
+						ContainerID: owner.containerID,
+						Netns:       containerNs.Path(),
+						IfName:      containerIfname,
+						StdinData:   []byte(conf),
+					}
+					_, out, err := testutils.CmdAdd(containerNs.Path(), args.ContainerID, "", []byte(conf), func() error { return cmdAdd(args) })
+					Expect(err).NotTo(HaveOccurred(), string(out))
+				}
+
+				ifbLink, err := netlinksafe.LinkByName("bwshared0")
+				Expect(err).NotTo(HaveOccurred())
+				classes, err := netlinksafe.ClassList(ifbLink, netlink.MakeHandle(1, 0))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(classes).To(HaveLen(3))
+
+				conf := fmt.Sprintf(`{
+					"cniVersion": "1.1.0",
+					"name": "net1",
+					"type": "bandwidth",
+					"sharedIfb": "bwshared0",
+					"dataDir": %q,
+					"cni.dev/valid-attachments": [{"containerID": "c1", "ifname": "cont-veth0"}]
+				}`, dataDir)
+				Expect(cmdGC(&skel.CmdArgs{StdinData: []byte(conf)})).To(Succeed())
+
+				classes, err = netlinksafe.ClassList(ifbLink, netlink.MakeHandle(1, 0))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(classes).To(HaveLen(2))
+
+				// The ingress qdisc of the stale attachment is gone too
+				hostVethLink, err := netlinksafe.LinkByName("host-veth1")
+				Expect(err).NotTo(HaveOccurred())
+				filters, err := netlinksafe.FilterList(hostVethLink, netlink.MakeHandle(0xffff, 0))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(filters).To(BeEmpty())
+				return nil
+			})).To(Succeed())
+		})
+
+		It("rejects an invalid device name", func() {
+			_, err := parseConfig([]byte(`{"name": "net1", "type": "bandwidth", "sharedIfb": "bw/shared"}`), "")
+			Expect(err).To(MatchError(`invalid sharedIfb device name "bw/shared"`))
+		})
+	})
+
 	Describe("Validating input", func() {
 		It("Should allow only 4GB burst rate", func() {
 			err := validateRateAndBurst(5000, 4*1024*1024*1024*8-16) // 2 bytes less than the max should pass
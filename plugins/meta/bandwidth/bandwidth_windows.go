@@ -0,0 +1,170 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Microsoft/hcsshim/hcn"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/hns"
+	"github.com/containernetworking/plugins/pkg/log"
+)
+
+// On Windows the egress traffic of the container is capped by a QoS policy
+// of its HNS endpoint, which the main plugin created under the name from
+// hns.ConstructEndpointName. HNS has no ingress counterpart nor bursts.
+
+// getEndpoint returns the HNS endpoint of the container.
+func getEndpoint(conf *PluginConf, args *skel.CmdArgs) (*hcn.HostComputeEndpoint, error) {
+	epName := hns.ConstructEndpointName(args.ContainerID, args.Netns, conf.Name)
+	ep, err := hcn.GetEndpointByName(epName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find HNS endpoint %q: %v", epName, err)
+	}
+	return ep, nil
+}
+
+// validateWindowsBandwidth rejects the limits HNS cannot enforce.
+func validateWindowsBandwidth(bandwidth *BandwidthEntry) error {
+	if bandwidth.IngressRate > 0 {
+		return fmt.Errorf("ingress bandwidth limits are not supported on Windows")
+	}
+	return nil
+}
+
+// qosPolicy returns the QoS policy of ep, if any.
+func qosPolicy(ep *hcn.HostComputeEndpoint) (*hcn.QosPolicySetting, error) {
+	for _, policy := range ep.Policies {
+		if policy.Type != hcn.QOS {
+			continue
+		}
+		setting := &hcn.QosPolicySetting{}
+		if err := json.Unmarshal(policy.Settings, setting); err != nil {
+			return nil, fmt.Errorf("failed to parse QoS policy of HNS endpoint %q: %v", ep.Name, err)
+		}
+		return setting, nil
+	}
+	return nil, nil
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+
+	bandwidth := getBandwidth(conf)
+	if bandwidth == nil || bandwidth.isZero() {
+		return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+	}
+
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	if err := validateWindowsBandwidth(bandwidth); err != nil {
+		return err
+	}
+	if conf.SharedIfb != "" {
+		return fmt.Errorf("sharedIfb is not supported on Windows")
+	}
+
+	result, err := current.NewResultFromResult(conf.PrevResult)
+	if err != nil {
+		return fmt.Errorf("could not convert result to current version: %v", err)
+	}
+
+	if bandwidth.EgressRate > 0 {
+		ep, err := getEndpoint(conf, args)
+		if err != nil {
+			return err
+		}
+
+		settings, err := json.Marshal(hcn.QosPolicySetting{
+			MaximumOutgoingBandwidthInBytes: bandwidth.EgressRate / 8,
+		})
+		if err != nil {
+			return err
+		}
+		request := hcn.PolicyEndpointRequest{
+			Policies: []hcn.EndpointPolicy{{Type: hcn.QOS, Settings: settings}},
+		}
+		if err := ep.ApplyPolicy(hcn.RequestTypeAdd, request); err != nil {
+			return fmt.Errorf("failed to apply QoS policy to HNS endpoint %q: %v", ep.Name, err)
+		}
+		log.Debug("shaped egress traffic", "endpoint", ep.Name, "rate", bandwidth.EgressRate)
+	}
+
+	return types.PrintResult(result, conf.CNIVersion)
+}
+
+// cmdDel does nothing, as the QoS policy is deleted with the endpoint by the
+// main plugin.
+func cmdDel(args *skel.CmdArgs) error {
+	_, err := parseConfig(args.StdinData, args.Args)
+	return err
+}
+
+// cmdGC does nothing, as no state outlives the endpoints.
+func cmdGC(args *skel.CmdArgs) error {
+	_, err := parseConfig(args.StdinData, args.Args)
+	return err
+}
+
+// cmdStatus checks that the HNS API the plugin uses is available.
+func cmdStatus(_ *skel.CmdArgs) error {
+	if err := hcn.V2ApiSupported(); err != nil {
+		return fmt.Errorf("HNS v2 API is not available: %v", err)
+	}
+	return nil
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as a chained plugin")
+	}
+
+	bandwidth := getBandwidth(conf)
+	if bandwidth == nil || bandwidth.EgressRate == 0 {
+		return nil
+	}
+
+	ep, err := getEndpoint(conf, args)
+	if err != nil {
+		return err
+	}
+	qos, err := qosPolicy(ep)
+	if err != nil {
+		return err
+	}
+	if qos == nil {
+		return fmt.Errorf("Failed to find QoS policy")
+	}
+	if qos.MaximumOutgoingBandwidthInBytes != bandwidth.EgressRate/8 {
+		return fmt.Errorf("Rate doesn't match")
+	}
+
+	return nil
+}
@@ -27,10 +27,10 @@ import (
 
 const latencyInMillis = 25
 
-func CreateIfb(ifbDeviceName string, mtu int) error {
+func CreateIfb(links *netlinksafe.LinkCache, ifbDeviceName, alias string, mtu int) error {
 	// do not set TxQLen > 0 nor TxQLen == -1 until issues have been fixed with numrxqueues / numtxqueues across interfaces
 	// which needs to get set on IFB devices via upstream library: see hint https://github.com/containernetworking/plugins/pull/1097
-	err := netlink.LinkAdd(&netlink.Ifb{
+	err := links.LinkAdd(&netlink.Ifb{
 		LinkAttrs: netlink.LinkAttrs{
 			Name:   ifbDeviceName,
 			Flags:  net.FlagUp,
@@ -42,6 +42,17 @@ func CreateIfb(ifbDeviceName string, mtu int) error {
 		return fmt.Errorf("adding link: %s", err)
 	}
 
+	// The kernel ignores aliases on link creation
+	if alias != "" {
+		link, err := links.LinkByName(ifbDeviceName)
+		if err != nil {
+			return fmt.Errorf("get ifb device: %s", err)
+		}
+		if err := links.LinkSetAlias(link, alias); err != nil {
+			return fmt.Errorf("setting alias: %s", err)
+		}
+	}
+
 	return nil
 }
 
@@ -53,20 +64,20 @@ func TeardownIfb(deviceName string) error {
 	return err
 }
 
-func CreateIngressQdisc(rateInBits, burstInBits uint64, hostDeviceName string) error {
-	hostDevice, err := netlinksafe.LinkByName(hostDeviceName)
+func CreateIngressQdisc(links *netlinksafe.LinkCache, rateInBits, burstInBits uint64, hostDeviceName string) error {
+	hostDevice, err := links.LinkByName(hostDeviceName)
 	if err != nil {
 		return fmt.Errorf("get host device: %s", err)
 	}
 	return createTBF(rateInBits, burstInBits, hostDevice.Attrs().Index)
 }
 
-func CreateEgressQdisc(rateInBits, burstInBits uint64, hostDeviceName string, ifbDeviceName string) error {
-	ifbDevice, err := netlinksafe.LinkByName(ifbDeviceName)
+func CreateEgressQdisc(links *netlinksafe.LinkCache, rateInBits, burstInBits uint64, hostDeviceName string, ifbDeviceName string) error {
+	ifbDevice, err := links.LinkByName(ifbDeviceName)
 	if err != nil {
 		return fmt.Errorf("get ifb device: %s", err)
 	}
-	hostDevice, err := netlinksafe.LinkByName(hostDeviceName)
+	hostDevice, err := links.LinkByName(hostDeviceName)
 	if err != nil {
 		return fmt.Errorf("get host device: %s", err)
 	}
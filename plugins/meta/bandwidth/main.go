@@ -15,26 +15,23 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"math"
-
-	"github.com/vishvananda/netlink"
+	"strings"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
-	"github.com/containernetworking/plugins/pkg/ip"
-	"github.com/containernetworking/plugins/pkg/netlinksafe"
-	"github.com/containernetworking/plugins/pkg/ns"
-	"github.com/containernetworking/plugins/pkg/utils"
+	"github.com/containernetworking/plugins/pkg/config"
+	"github.com/containernetworking/plugins/pkg/log"
+	"github.com/containernetworking/plugins/pkg/trace"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
 
 const (
+	defaultDataDir     = "/run/cni/bandwidth"
 	maxIfbDeviceLength = 15
-	ifbDevicePrefix    = "bwp"
 )
 
 // BandwidthEntry corresponds to a single entry in the bandwidth argument,
@@ -54,6 +51,14 @@ func (bw *BandwidthEntry) isZero() bool {
 type PluginConf struct {
 	types.NetConf
 
+	// SharedIfb is the name of an ifb device carrying the egress traffic of
+	// every attachment, each shaped by its own class of an HTB qdisc of the
+	// device, rather than an ifb device per container. The device is
+	// created if missing and never deleted.
+	SharedIfb string `json:"sharedIfb,omitempty"`
+	// DataDir records the class of each attachment on the shared ifb device.
+	DataDir string `json:"dataDir,omitempty"`
+
 	RuntimeConfig struct {
 		Bandwidth *BandwidthEntry `json:"bandwidth,omitempty"`
 	} `json:"runtimeConfig,omitempty"`
@@ -62,13 +67,24 @@ type PluginConf struct {
 }
 
 // parseConfig parses the supplied configuration (and prevResult) from stdin.
-func parseConfig(stdin []byte) (*PluginConf, error) {
+func parseConfig(stdin []byte, envArgs string) (*PluginConf, error) {
+	defer trace.Start("parseConfig").End()
+
 	conf := PluginConf{}
 
-	if err := json.Unmarshal(stdin, &conf); err != nil {
+	if err := config.Decode(stdin, envArgs, &conf); err != nil {
 		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
 	}
 
+	if conf.DataDir == "" {
+		conf.DataDir = defaultDataDir
+	}
+	if conf.SharedIfb != "" {
+		if len(conf.SharedIfb) > maxIfbDeviceLength || strings.ContainsAny(conf.SharedIfb, "/: \t\n") {
+			return nil, fmt.Errorf("invalid sharedIfb device name %q", conf.SharedIfb)
+		}
+	}
+
 	bandwidth := getBandwidth(&conf)
 	if bandwidth != nil {
 		err := validateRateAndBurst(bandwidth.IngressRate, bandwidth.IngressBurst)
@@ -116,259 +132,12 @@ func validateRateAndBurst(rate, burst uint64) error {
 	return nil
 }
 
-func getIfbDeviceName(networkName string, containerID string) string {
-	return utils.MustFormatHashWithPrefix(maxIfbDeviceLength, ifbDevicePrefix, networkName+containerID)
-}
-
-func getMTU(deviceName string) (int, error) {
-	link, err := netlinksafe.LinkByName(deviceName)
-	if err != nil {
-		return -1, err
-	}
-
-	return link.Attrs().MTU, nil
-}
-
-// get the veth peer of container interface in host namespace
-func getHostInterface(interfaces []*current.Interface, containerIfName string, netns ns.NetNS) (*current.Interface, error) {
-	if len(interfaces) == 0 {
-		return nil, fmt.Errorf("no interfaces provided")
-	}
-
-	// get veth peer index of container interface
-	var peerIndex int
-	var err error
-	_ = netns.Do(func(_ ns.NetNS) error {
-		_, peerIndex, err = ip.GetVethPeerIfindex(containerIfName)
-		return nil
-	})
-	if peerIndex <= 0 {
-		return nil, fmt.Errorf("container interface %s has no veth peer: %v", containerIfName, err)
-	}
-
-	// find host interface by index
-	link, err := netlink.LinkByIndex(peerIndex)
-	if err != nil {
-		return nil, fmt.Errorf("veth peer with index %d is not in host ns", peerIndex)
-	}
-	for _, iface := range interfaces {
-		if iface.Sandbox == "" && iface.Name == link.Attrs().Name {
-			return iface, nil
-		}
-	}
-
-	return nil, fmt.Errorf("no veth peer of container interface found in host ns")
-}
-
-func cmdAdd(args *skel.CmdArgs) error {
-	conf, err := parseConfig(args.StdinData)
-	if err != nil {
-		return err
-	}
-
-	bandwidth := getBandwidth(conf)
-	if bandwidth == nil || bandwidth.isZero() {
-		return types.PrintResult(conf.PrevResult, conf.CNIVersion)
-	}
-
-	if conf.PrevResult == nil {
-		return fmt.Errorf("must be called as chained plugin")
-	}
-
-	result, err := current.NewResultFromResult(conf.PrevResult)
-	if err != nil {
-		return fmt.Errorf("could not convert result to current version: %v", err)
-	}
-
-	netns, err := ns.GetNS(args.Netns)
-	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", netns, err)
-	}
-	defer netns.Close()
-
-	hostInterface, err := getHostInterface(result.Interfaces, args.IfName, netns)
-	if err != nil {
-		return err
-	}
-
-	if bandwidth.IngressRate > 0 && bandwidth.IngressBurst > 0 {
-		err = CreateIngressQdisc(bandwidth.IngressRate, bandwidth.IngressBurst, hostInterface.Name)
-		if err != nil {
-			return err
-		}
-	}
-
-	if bandwidth.EgressRate > 0 && bandwidth.EgressBurst > 0 {
-		mtu, err := getMTU(hostInterface.Name)
-		if err != nil {
-			return err
-		}
-
-		ifbDeviceName := getIfbDeviceName(conf.Name, args.ContainerID)
-
-		err = CreateIfb(ifbDeviceName, mtu)
-		if err != nil {
-			return err
-		}
-
-		ifbDevice, err := netlinksafe.LinkByName(ifbDeviceName)
-		if err != nil {
-			return err
-		}
-
-		result.Interfaces = append(result.Interfaces, &current.Interface{
-			Name: ifbDeviceName,
-			Mac:  ifbDevice.Attrs().HardwareAddr.String(),
-		})
-		err = CreateEgressQdisc(bandwidth.EgressRate, bandwidth.EgressBurst, hostInterface.Name, ifbDeviceName)
-		if err != nil {
-			return err
-		}
-	}
-
-	return types.PrintResult(result, conf.CNIVersion)
-}
-
-func cmdDel(args *skel.CmdArgs) error {
-	conf, err := parseConfig(args.StdinData)
-	if err != nil {
-		return err
-	}
-
-	ifbDeviceName := getIfbDeviceName(conf.Name, args.ContainerID)
-
-	return TeardownIfb(ifbDeviceName)
-}
-
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
-		Add:   cmdAdd,
-		Check: cmdCheck,
-		Del:   cmdDel,
-		/* FIXME GC */
-		/* FIXME Status */
-	}, version.VersionsStartingFrom("0.3.0"), bv.BuildString("bandwidth"))
-}
-
-func SafeQdiscList(link netlink.Link) ([]netlink.Qdisc, error) {
-	qdiscs, err := netlinksafe.QdiscList(link)
-	if err != nil {
-		return nil, err
-	}
-	result := []netlink.Qdisc{}
-	for _, qdisc := range qdiscs {
-		// filter out pfifo_fast qdiscs because
-		// older kernels don't return them
-		_, pfifo := qdisc.(*netlink.PfifoFast)
-		if !pfifo {
-			result = append(result, qdisc)
-		}
-	}
-	return result, nil
-}
-
-func cmdCheck(args *skel.CmdArgs) error {
-	bwConf, err := parseConfig(args.StdinData)
-	if err != nil {
-		return err
-	}
-
-	if bwConf.PrevResult == nil {
-		return fmt.Errorf("must be called as a chained plugin")
-	}
-
-	result, err := current.NewResultFromResult(bwConf.PrevResult)
-	if err != nil {
-		return fmt.Errorf("could not convert result to current version: %v", err)
-	}
-
-	netns, err := ns.GetNS(args.Netns)
-	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", netns, err)
-	}
-	defer netns.Close()
-
-	hostInterface, err := getHostInterface(result.Interfaces, args.IfName, netns)
-	if err != nil {
-		return err
-	}
-	link, err := netlinksafe.LinkByName(hostInterface.Name)
-	if err != nil {
-		return err
-	}
-
-	bandwidth := getBandwidth(bwConf)
-
-	if bandwidth.IngressRate > 0 && bandwidth.IngressBurst > 0 {
-		rateInBytes := bandwidth.IngressRate / 8
-		burstInBytes := bandwidth.IngressBurst / 8
-		bufferInBytes := buffer(rateInBytes, uint32(burstInBytes))
-		latency := latencyInUsec(latencyInMillis)
-		limitInBytes := limit(rateInBytes, latency, uint32(burstInBytes))
-
-		qdiscs, err := SafeQdiscList(link)
-		if err != nil {
-			return err
-		}
-		if len(qdiscs) == 0 {
-			return fmt.Errorf("Failed to find qdisc")
-		}
-
-		for _, qdisc := range qdiscs {
-			tbf, isTbf := qdisc.(*netlink.Tbf)
-			if !isTbf {
-				break
-			}
-			if tbf.Rate != rateInBytes {
-				return fmt.Errorf("Rate doesn't match")
-			}
-			if tbf.Limit != limitInBytes {
-				return fmt.Errorf("Limit doesn't match")
-			}
-			if tbf.Buffer != bufferInBytes {
-				return fmt.Errorf("Buffer doesn't match")
-			}
-		}
-	}
-
-	if bandwidth.EgressRate > 0 && bandwidth.EgressBurst > 0 {
-		rateInBytes := bandwidth.EgressRate / 8
-		burstInBytes := bandwidth.EgressBurst / 8
-		bufferInBytes := buffer(rateInBytes, uint32(burstInBytes))
-		latency := latencyInUsec(latencyInMillis)
-		limitInBytes := limit(rateInBytes, latency, uint32(burstInBytes))
-
-		ifbDeviceName := getIfbDeviceName(bwConf.Name, args.ContainerID)
-
-		ifbDevice, err := netlinksafe.LinkByName(ifbDeviceName)
-		if err != nil {
-			return fmt.Errorf("get ifb device: %s", err)
-		}
-
-		qdiscs, err := SafeQdiscList(ifbDevice)
-		if err != nil {
-			return err
-		}
-		if len(qdiscs) == 0 {
-			return fmt.Errorf("Failed to find qdisc")
-		}
-
-		for _, qdisc := range qdiscs {
-			tbf, isTbf := qdisc.(*netlink.Tbf)
-			if !isTbf {
-				break
-			}
-			if tbf.Rate != rateInBytes {
-				return fmt.Errorf("Rate doesn't match")
-			}
-			if tbf.Limit != limitInBytes {
-				return fmt.Errorf("Limit doesn't match")
-			}
-			if tbf.Buffer != bufferInBytes {
-				return fmt.Errorf("Buffer doesn't match")
-			}
-		}
-	}
-
-	return nil
+	skel.PluginMainFuncs(log.Wrap("bandwidth", trace.Wrap("bandwidth", skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		GC:     cmdGC,
+		Status: cmdStatus,
+	})), version.VersionsStartingFrom("0.3.0"), bv.BuildString("bandwidth"))
 }
@@ -0,0 +1,404 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/alexflint/go-filemutex"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/statestore"
+)
+
+// With a shared ifb device, the egress traffic of every attachment is
+// redirected from the ingress of its host veth to the same ifb device,
+// after setting its priority to the handle of the attachment's class in the
+// HTB qdisc of the device, which HTB then classifies it to.
+//
+// The class of each attachment is recorded under
+// <dataDir>/<sharedIfb>, whose lock is <dataDir>/<sharedIfb>.lock, as the
+// class handles are allocated across the networks sharing the device.
+
+// sharedIfbMajor is the major number of the root HTB qdisc of the shared
+// ifb device and of its classes.
+const sharedIfbMajor = 1
+
+// sharedIfbRecord is the class of an attachment on the shared ifb device,
+// and the host veth redirecting to it.
+type sharedIfbRecord struct {
+	Minor       uint16 `json:"minor"`
+	HostIfName  string `json:"hostIfName"`
+	HostIfIndex int    `json:"hostIfIndex"`
+}
+
+func (r *sharedIfbRecord) handle() uint32 {
+	return netlink.MakeHandle(sharedIfbMajor, r.Minor)
+}
+
+func sharedIfbStore(conf *PluginConf) *statestore.Store {
+	return statestore.New(filepath.Join(conf.DataDir, conf.SharedIfb), conf.Name)
+}
+
+// lockSharedIfb serializes the changes to the classes of the shared ifb
+// device across the plugin processes.
+func lockSharedIfb(conf *PluginConf) (*filemutex.FileMutex, error) {
+	if err := os.MkdirAll(conf.DataDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", conf.DataDir, err)
+	}
+	m, err := filemutex.New(filepath.Join(conf.DataDir, conf.SharedIfb+".lock"))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Lock(); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// ensureSharedIfb creates the shared ifb device and its root HTB qdisc if
+// they do not exist, and raises its MTU to mtu.
+func ensureSharedIfb(links *netlinksafe.LinkCache, name string, mtu int) (netlink.Link, error) {
+	ifb, err := links.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return nil, fmt.Errorf("get shared ifb device: %s", err)
+		}
+		if err := CreateIfb(links, name, "", mtu); err != nil {
+			return nil, err
+		}
+		if ifb, err = links.LinkByName(name); err != nil {
+			return nil, fmt.Errorf("get shared ifb device: %s", err)
+		}
+	}
+	if ifb.Type() != "ifb" {
+		return nil, fmt.Errorf("shared ifb device %q is a %s device", name, ifb.Type())
+	}
+	if ifb.Attrs().Flags&net.FlagUp == 0 {
+		if err := links.LinkSetUp(ifb); err != nil {
+			return nil, fmt.Errorf("set shared ifb device up: %s", err)
+		}
+	}
+	if ifb.Attrs().MTU < mtu {
+		if err := links.LinkSetMTU(ifb, mtu); err != nil {
+			return nil, fmt.Errorf("set shared ifb device MTU: %s", err)
+		}
+	}
+
+	qdiscs, err := SafeQdiscList(ifb)
+	if err != nil {
+		return nil, err
+	}
+	for _, qdisc := range qdiscs {
+		if qdisc.Attrs().Parent != netlink.HANDLE_ROOT || qdisc.Attrs().Handle == netlink.HANDLE_NONE {
+			continue
+		}
+		if _, ok := qdisc.(*netlink.Htb); !ok || qdisc.Attrs().Handle != netlink.MakeHandle(sharedIfbMajor, 0) {
+			return nil, fmt.Errorf("shared ifb device %q has a root qdisc %s %s, not htb 1:",
+				name, qdisc.Type(), netlink.HandleStr(qdisc.Attrs().Handle))
+		}
+		return ifb, nil
+	}
+	// Unclassified traffic is not shaped
+	htb := netlink.NewHtb(netlink.QdiscAttrs{
+		LinkIndex: ifb.Attrs().Index,
+		Handle:    netlink.MakeHandle(sharedIfbMajor, 0),
+		Parent:    netlink.HANDLE_ROOT,
+	})
+	if err := netlink.QdiscAdd(htb); err != nil {
+		return nil, fmt.Errorf("create shared ifb qdisc: %s", err)
+	}
+	return ifb, nil
+}
+
+// freeClassMinor returns the lowest minor number not used by a class of the
+// shared ifb device.
+func freeClassMinor(ifb netlink.Link) (uint16, error) {
+	classes, err := netlinksafe.ClassList(ifb, netlink.MakeHandle(sharedIfbMajor, 0))
+	if err != nil {
+		return 0, fmt.Errorf("list shared ifb classes: %s", err)
+	}
+	used := map[uint16]bool{}
+	for _, class := range classes {
+		_, minor := netlink.MajorMinor(class.Attrs().Handle)
+		used[minor] = true
+	}
+	for minor := uint16(1); minor < 0xffff; minor++ {
+		if !used[minor] {
+			return minor, nil
+		}
+	}
+	return 0, fmt.Errorf("no free class on shared ifb device %q", ifb.Attrs().Name)
+}
+
+// sharedIfbClass returns the HTB class shaping traffic to rateInBits with
+// bursts of burstInBits.
+func sharedIfbClass(ifbIndex int, handle uint32, rateInBits, burstInBits uint64) *netlink.HtbClass {
+	return netlink.NewHtbClass(netlink.ClassAttrs{
+		LinkIndex: ifbIndex,
+		Handle:    handle,
+		Parent:    netlink.MakeHandle(sharedIfbMajor, 0),
+	}, netlink.HtbClassAttrs{
+		Rate:   rateInBits,
+		Buffer: uint32(burstInBits / 8),
+	})
+}
+
+// CreateSharedEgressQdisc shapes the egress traffic of the host device with
+// a new class of the shared ifb device, recorded for the attachment of
+// containerID and ifName.
+func CreateSharedEgressQdisc(links *netlinksafe.LinkCache, conf *PluginConf, containerID, ifName string,
+	rateInBits, burstInBits uint64, hostDeviceName string, mtu int,
+) (err error) {
+	m, err := lockSharedIfb(conf)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	ifb, err := ensureSharedIfb(links, conf.SharedIfb, mtu)
+	if err != nil {
+		return err
+	}
+	hostDevice, err := links.LinkByName(hostDeviceName)
+	if err != nil {
+		return fmt.Errorf("get host device: %s", err)
+	}
+
+	store := sharedIfbStore(conf)
+	key := statestore.Key{ContainerID: containerID, IfName: ifName}
+	rec := &sharedIfbRecord{}
+	found, err := store.Load(key, rec)
+	if err != nil {
+		return err
+	}
+	if found {
+		// Left by an ADD whose DEL was lost
+		if err := deleteSharedIfbClass(conf, rec); err != nil {
+			return err
+		}
+	}
+	minor, err := freeClassMinor(ifb)
+	if err != nil {
+		return err
+	}
+	rec = &sharedIfbRecord{Minor: minor, HostIfName: hostDeviceName, HostIfIndex: hostDevice.Attrs().Index}
+
+	class := sharedIfbClass(ifb.Attrs().Index, rec.handle(), rateInBits, burstInBits)
+	if err := netlink.ClassAdd(class); err != nil {
+		return fmt.Errorf("create shared ifb class: %s", err)
+	}
+	ingress := &netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: hostDevice.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0), // ffff:
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	}
+	// Undo what was set up so far if a later step fails, leaving no class
+	// nor record behind. An ingress qdisc that was already there is kept.
+	ingressAdded := false
+	defer func() {
+		if err == nil {
+			return
+		}
+		if ingressAdded {
+			_ = netlink.QdiscDel(ingress)
+		}
+		_ = netlink.ClassDel(class)
+		_ = store.Delete(key)
+	}()
+	if err := store.Save(key, rec); err != nil {
+		return err
+	}
+
+	// add qdisc ingress on host device
+	if err := netlink.QdiscAdd(ingress); err != nil {
+		return fmt.Errorf("create ingress qdisc: %s", err)
+	}
+	ingressAdded = true
+
+	// add filter on host device to classify traffic and redirect it to the
+	// shared ifb device
+	priority := rec.handle()
+	filter := &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: hostDevice.Attrs().Index,
+			Parent:    ingress.QdiscAttrs.Handle,
+			Priority:  1,
+			Protocol:  syscall.ETH_P_ALL,
+		},
+		Actions: []netlink.Action{
+			&netlink.SkbEditAction{
+				ActionAttrs: netlink.ActionAttrs{Action: netlink.TC_ACT_PIPE},
+				Priority:    &priority,
+			},
+			&netlink.MirredAction{
+				ActionAttrs:  netlink.ActionAttrs{Action: netlink.TC_ACT_STOLEN},
+				MirredAction: netlink.TCA_EGRESS_REDIR,
+				Ifindex:      ifb.Attrs().Index,
+			},
+		},
+	}
+	if err := netlink.FilterAdd(filter); err != nil {
+		return fmt.Errorf("add filter: %s", err)
+	}
+	return nil
+}
+
+// deleteSharedIfbClass deletes the class of rec and the ingress qdisc of its
+// host veth, if they still exist.
+func deleteSharedIfbClass(conf *PluginConf, rec *sharedIfbRecord) error {
+	ifb, err := netlinksafe.LinkByName(conf.SharedIfb)
+	if err == nil {
+		classes, err := netlinksafe.ClassList(ifb, netlink.MakeHandle(sharedIfbMajor, 0))
+		if err != nil {
+			return fmt.Errorf("list shared ifb classes: %s", err)
+		}
+		for _, class := range classes {
+			if class.Attrs().Handle != rec.handle() {
+				continue
+			}
+			if err := netlink.ClassDel(class); err != nil {
+				return fmt.Errorf("delete shared ifb class %s: %s", netlink.HandleStr(rec.handle()), err)
+			}
+		}
+	} else if _, ok := err.(netlink.LinkNotFoundError); !ok {
+		return fmt.Errorf("get shared ifb device: %s", err)
+	}
+
+	// The host veth is normally deleted with the container, after DEL; its
+	// index tells it apart from a later device of the same name.
+	hostDevice, err := netlink.LinkByIndex(rec.HostIfIndex)
+	if err != nil || hostDevice.Attrs().Name != rec.HostIfName {
+		return nil
+	}
+	err = netlink.QdiscDel(&netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: rec.HostIfIndex,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	})
+	if err != nil && err != syscall.ENOENT && err != syscall.EINVAL {
+		return fmt.Errorf("delete ingress qdisc of %q: %s", rec.HostIfName, err)
+	}
+	return nil
+}
+
+// TeardownSharedEgress deletes the class of the attachment of containerID
+// and ifName on the shared ifb device. The device itself is left.
+func TeardownSharedEgress(conf *PluginConf, containerID, ifName string) error {
+	m, err := lockSharedIfb(conf)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	store := sharedIfbStore(conf)
+	key := statestore.Key{ContainerID: containerID, IfName: ifName}
+	rec := &sharedIfbRecord{}
+	found, err := store.Load(key, rec)
+	if err != nil || !found {
+		return err
+	}
+	if err := deleteSharedIfbClass(conf, rec); err != nil {
+		return err
+	}
+	return store.Delete(key)
+}
+
+// gcSharedEgress deletes the classes of the attachments on the network that
+// are not valid.
+func gcSharedEgress(conf *PluginConf, valid *gc.Attachments) error {
+	m, err := lockSharedIfb(conf)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	return sharedIfbStore(conf).GC(valid, func(_ statestore.Key, data json.RawMessage) error {
+		rec := &sharedIfbRecord{}
+		if err := json.Unmarshal(data, rec); err != nil {
+			// Nothing can be released
+			return nil
+		}
+		return deleteSharedIfbClass(conf, rec)
+	})
+}
+
+// checkSharedEgress checks that the egress traffic of the host device is
+// redirected to the class of the attachment of containerID and ifName on
+// the shared ifb device, which shapes it to rateInBits.
+func checkSharedEgress(conf *PluginConf, containerID, ifName string, hostDevice netlink.Link, rateInBits, burstInBits uint64) error {
+	rec := &sharedIfbRecord{}
+	found, err := sharedIfbStore(conf).Load(statestore.Key{ContainerID: containerID, IfName: ifName}, rec)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("Failed to find shared ifb class")
+	}
+
+	ifb, err := netlinksafe.LinkByName(conf.SharedIfb)
+	if err != nil {
+		return fmt.Errorf("get shared ifb device: %s", err)
+	}
+	classes, err := netlinksafe.ClassList(ifb, netlink.MakeHandle(sharedIfbMajor, 0))
+	if err != nil {
+		return fmt.Errorf("list shared ifb classes: %s", err)
+	}
+	var class *netlink.HtbClass
+	for _, c := range classes {
+		if htbClass, ok := c.(*netlink.HtbClass); ok && c.Attrs().Handle == rec.handle() {
+			class = htbClass
+		}
+	}
+	if class == nil {
+		return fmt.Errorf("Failed to find shared ifb class")
+	}
+	expected := sharedIfbClass(ifb.Attrs().Index, rec.handle(), rateInBits, burstInBits)
+	if class.Rate != expected.Rate || class.Ceil != expected.Ceil {
+		return fmt.Errorf("Rate doesn't match")
+	}
+	if class.Buffer != expected.Buffer {
+		return fmt.Errorf("Buffer doesn't match")
+	}
+
+	filters, err := netlinksafe.FilterList(hostDevice, netlink.MakeHandle(0xffff, 0))
+	if err != nil {
+		return fmt.Errorf("list filters of %q: %s", hostDevice.Attrs().Name, err)
+	}
+	for _, filter := range filters {
+		u32, ok := filter.(*netlink.U32)
+		if !ok || u32.RedirIndex != ifb.Attrs().Index {
+			continue
+		}
+		for _, action := range u32.Actions {
+			if skbedit, ok := action.(*netlink.SkbEditAction); ok && skbedit.Priority != nil && *skbedit.Priority == rec.handle() {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Failed to find filter redirecting to shared ifb class")
+}
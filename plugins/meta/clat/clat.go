@@ -0,0 +1,361 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+)
+
+// The IPv6 header is 20 bytes larger than the IPv4 one, and a fragment
+// header may be added.
+const translationOverhead = 28
+
+// clatAddrs are the addresses of an attachment. The translator maps ipv4 to
+// clat, and uses translatorIPv4 and translatorIPv6 for the ICMP errors it
+// sends.
+type clatAddrs struct {
+	ipv4           net.IP
+	translatorIPv4 net.IP
+	clat           net.IP
+	translatorIPv6 net.IP
+	nat64Prefix    *net.IPNet
+}
+
+// addresses returns the addresses of the attachment of args. Unless
+// configured, the IPv6 ones are in the /64 of the container IPv6 address,
+// with interface identifiers derived from the attachment.
+func (conf *ClatConf) addresses(result *current.Result, args *skel.CmdArgs) (*clatAddrs, error) {
+	addrs := &clatAddrs{
+		ipv4:           conf.ipv4Address,
+		translatorIPv4: ip.NextIP(conf.ipv4Address),
+		nat64Prefix:    conf.nat64Prefix,
+	}
+
+	var containerNet *net.IPNet
+	for _, ipc := range result.IPs {
+		if ipc.Address.IP.To4() != nil {
+			continue
+		}
+		if ipc.Interface != nil && *ipc.Interface >= 0 && *ipc.Interface < len(result.Interfaces) &&
+			result.Interfaces[*ipc.Interface].Name != args.IfName {
+			continue
+		}
+		containerNet = &ipc.Address
+		break
+	}
+	if containerNet == nil {
+		return nil, fmt.Errorf("no IPv6 address on %s in prevResult", args.IfName)
+	}
+
+	sum := sha256.Sum256([]byte(args.ContainerID + "/" + args.IfName))
+	if conf.clatAddress != nil {
+		addrs.clat = conf.clatAddress
+	} else {
+		if ones, _ := containerNet.Mask.Size(); ones > 64 {
+			return nil, fmt.Errorf("cannot derive clatAddress from %s, whose prefix is longer than /64", containerNet)
+		}
+		addrs.clat = make(net.IP, net.IPv6len)
+		copy(addrs.clat, containerNet.IP.Mask(net.CIDRMask(64, 128)))
+		copy(addrs.clat[8:], sum[:8])
+	}
+
+	addrs.translatorIPv6 = make(net.IP, net.IPv6len)
+	copy(addrs.translatorIPv6, addrs.clat)
+	addrs.translatorIPv6[15] ^= 1
+
+	return addrs, nil
+}
+
+// taygaConfig returns the tayga configuration of the attachment.
+func taygaConfig(addrs *clatAddrs) string {
+	return fmt.Sprintf(`tun-device %s
+ipv4-addr %s
+ipv6-addr %s
+prefix %s
+map %s %s
+`, clatIfName, addrs.translatorIPv4, addrs.translatorIPv6, addrs.nat64Prefix, addrs.ipv4, addrs.clat)
+}
+
+// setupCLAT creates the TUN interface of the translator, routes the container
+// IPv4 traffic and the CLAT addresses to it, and answers neighbor
+// solicitations for the CLAT addresses on ifName.
+func setupCLAT(ifName string, addrs *clatAddrs) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = clatIfName
+	tun := &netlink.Tuntap{
+		LinkAttrs: linkAttrs,
+		Mode:      netlink.TUNTAP_MODE_TUN,
+		Flags:     netlink.TUNTAP_DEFAULTS,
+	}
+	if err := netlink.LinkAdd(tun); err != nil {
+		return fmt.Errorf("failed to create %s: %v", clatIfName, err)
+	}
+	tunLink, err := netlinksafe.LinkByName(clatIfName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", clatIfName, err)
+	}
+	if err := netlink.LinkSetMTU(tunLink, link.Attrs().MTU-translationOverhead); err != nil {
+		return fmt.Errorf("failed to set the MTU of %q: %v", clatIfName, err)
+	}
+	if err := netlink.LinkSetUp(tunLink); err != nil {
+		return fmt.Errorf("failed to set %q up: %v", clatIfName, err)
+	}
+
+	addr := &netlink.Addr{IPNet: &net.IPNet{IP: addrs.ipv4, Mask: net.CIDRMask(32, 32)}}
+	if err := netlink.AddrAdd(tunLink, addr); err != nil {
+		return fmt.Errorf("failed to add %s to %s: %v", addrs.ipv4, clatIfName, err)
+	}
+
+	routes := []*netlink.Route{{
+		LinkIndex: tunLink.Attrs().Index,
+		Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+		Src:       addrs.ipv4,
+		Scope:     netlink.SCOPE_LINK,
+	}}
+	for _, ip := range []net.IP{addrs.clat, addrs.translatorIPv6} {
+		routes = append(routes, &netlink.Route{
+			LinkIndex: tunLink.Attrs().Index,
+			Dst:       &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)},
+		})
+	}
+	for _, route := range routes {
+		if err := netlink.RouteAdd(route); err != nil {
+			return fmt.Errorf("failed to add route to %s: %v", route.Dst, err)
+		}
+	}
+
+	// Forward between the interfaces, while still accepting router
+	// advertisements on ifName
+	for key, value := range map[string]string{
+		"net/ipv6/conf/all/forwarding":                    "1",
+		fmt.Sprintf("net/ipv6/conf/%s/accept_ra", ifName): "2",
+		fmt.Sprintf("net/ipv6/conf/%s/proxy_ndp", ifName): "1",
+	} {
+		if _, err := sysctl.Sysctl(key, value); err != nil {
+			return fmt.Errorf("failed to set %s: %v", key, err)
+		}
+	}
+	for _, ip := range []net.IP{addrs.clat, addrs.translatorIPv6} {
+		err := netlink.NeighAdd(&netlink.Neigh{
+			LinkIndex: link.Attrs().Index,
+			Family:    netlink.FAMILY_V6,
+			Flags:     netlink.NTF_PROXY,
+			IP:        ip,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to proxy %s on %s: %v", ip, ifName, err)
+		}
+	}
+
+	return nil
+}
+
+// teardownCLAT removes the TUN interface and the neighbor proxies of ifName.
+// It does not fail if they do not exist.
+func teardownCLAT(ifName string) error {
+	tunLink, err := netlinksafe.LinkByName(clatIfName)
+	if err != nil {
+		var linkNotFound netlink.LinkNotFoundError
+		if errors.As(err, &linkNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to lookup %q: %v", clatIfName, err)
+	}
+
+	// The CLAT addresses are the ones routed to the interface
+	if link, err := netlinksafe.LinkByName(ifName); err == nil {
+		routes, err := netlinksafe.RouteList(tunLink, netlink.FAMILY_V6)
+		if err != nil {
+			return fmt.Errorf("failed to list routes of %s: %v", clatIfName, err)
+		}
+		for _, route := range routes {
+			if route.Dst == nil || route.Dst.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			err := netlink.NeighDel(&netlink.Neigh{
+				LinkIndex: link.Attrs().Index,
+				Family:    netlink.FAMILY_V6,
+				Flags:     netlink.NTF_PROXY,
+				IP:        route.Dst.IP,
+			})
+			if err != nil && !errors.Is(err, syscall.ENOENT) {
+				return fmt.Errorf("failed to remove the proxy of %s: %v", route.Dst.IP, err)
+			}
+		}
+	}
+
+	if err := netlink.LinkDel(tunLink); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", clatIfName, err)
+	}
+	return nil
+}
+
+// checkCLAT checks that the TUN interface is up with the container IPv4
+// address.
+func checkCLAT(ipv4 net.IP) error {
+	tunLink, err := netlinksafe.LinkByName(clatIfName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", clatIfName, err)
+	}
+	if tunLink.Attrs().Flags&net.FlagUp == 0 {
+		return fmt.Errorf("%s is down", clatIfName)
+	}
+
+	addrs, err := netlinksafe.AddrList(tunLink, netlink.FAMILY_V4)
+	if err != nil {
+		return fmt.Errorf("failed to list addresses of %s: %v", clatIfName, err)
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(ipv4) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s does not have address %s", clatIfName, ipv4)
+}
+
+// startTayga writes the tayga configuration of the attachment and runs tayga
+// with it in netns.
+func (conf *ClatConf) startTayga(args *skel.CmdArgs, netns ns.NetNS, addrs *clatAddrs) error {
+	path, err := exec.LookPath(conf.TaygaPath)
+	if err != nil {
+		return fmt.Errorf("failed to find tayga: %v", err)
+	}
+	if err := os.MkdirAll(conf.DataDir, 0o700); err != nil {
+		return err
+	}
+	configPath := conf.statePath(args, "conf")
+	if err := os.WriteFile(configPath, []byte(taygaConfig(addrs)), 0o600); err != nil {
+		return err
+	}
+
+	// tayga outlives the plugin, so it logs to a file rather than to it
+	logPath := conf.statePath(args, "log")
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(path, "--config", configPath, "--nodetach")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	// The child is forked from the thread in netns, so it runs there
+	err = netns.Do(func(_ ns.NetNS) error {
+		return cmd.Start()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start tayga: %v", err)
+	}
+
+	// Report the errors of a translator that does not come up
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	select {
+	case err := <-exited:
+		output, _ := os.ReadFile(logPath)
+		return fmt.Errorf("tayga exited: %v: %s", err, strings.TrimSpace(string(output)))
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Without its pid, DEL could not stop it
+	if err := os.WriteFile(conf.statePath(args, "pid"), []byte(strconv.Itoa(cmd.Process.Pid)), 0o600); err != nil {
+		_ = cmd.Process.Kill()
+		return err
+	}
+	return nil
+}
+
+// taygaProcess returns the tayga process of the attachment, or nil if it is
+// not running. An exited process, even if not reaped yet, has no command
+// line, so it is not mistaken for a running one.
+func (conf *ClatConf) taygaProcess(args *skel.CmdArgs) (*os.Process, error) {
+	data, err := os.ReadFile(conf.statePath(args, "pid"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tayga pid file: %v", err)
+	}
+
+	// Make sure the pid was not reused by another process
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || !bytes.Contains(cmdline, []byte(conf.statePath(args, "conf"))) {
+		return nil, nil
+	}
+	return os.FindProcess(pid)
+}
+
+func (conf *ClatConf) stopTayga(args *skel.CmdArgs) error {
+	p, err := conf.taygaProcess(args)
+	if err != nil || p == nil {
+		return err
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("failed to stop tayga: %v", err)
+	}
+	return nil
+}
+
+func (conf *ClatConf) checkTayga(args *skel.CmdArgs) error {
+	p, err := conf.taygaProcess(args)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return fmt.Errorf("tayga is not running for %s", args.IfName)
+	}
+	return nil
+}
+
+func (conf *ClatConf) removeState(args *skel.CmdArgs) error {
+	for _, kind := range []string{"conf", "pid", "log"} {
+		if err := os.Remove(conf.statePath(args, kind)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
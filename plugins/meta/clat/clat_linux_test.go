@@ -0,0 +1,200 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+var _ = Describe("clat plugin", func() {
+	var targetNs ns.NetNS
+	var dataDir string
+	var args *skel.CmdArgs
+
+	BeforeEach(func() {
+		var err error
+		targetNs, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			veth := &netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "eth0"},
+				PeerName:  "peer0",
+			}
+			if err := netlink.LinkAdd(veth); err != nil {
+				return err
+			}
+			return netlink.LinkSetUp(veth)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// A stand-in for tayga that keeps running with its arguments
+		dataDir = GinkgoT().TempDir()
+		tayga := filepath.Join(dataDir, "tayga")
+		Expect(os.WriteFile(tayga, []byte("#!/bin/sh\nwhile :; do sleep 1; done\n"), 0o755)).To(Succeed())
+
+		args = &skel.CmdArgs{
+			ContainerID: "ctr",
+			Netns:       targetNs.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "clat",
+				"taygaPath": %q, "dataDir": %q,
+				"prevResult": {
+					"cniVersion": "1.0.0",
+					"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+					"ips": [{"address": "2001:db8:1:2::5/64", "interface": 0}]
+				}
+			}`, tayga, dataDir)),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(targetNs.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(targetNs)).To(Succeed())
+	})
+
+	It("sets up, checks and tears down the CLAT", func() {
+		r, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := current.GetResult(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Interfaces).To(HaveLen(2))
+		Expect(result.Interfaces[1].Name).To(Equal("clat"))
+		Expect(result.IPs).To(HaveLen(2))
+		Expect(result.IPs[1].Address.String()).To(Equal("192.0.0.1/32"))
+		Expect(*result.IPs[1].Interface).To(Equal(1))
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			defer GinkgoRecover()
+
+			link, err := netlinksafe.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			tun, err := netlinksafe.LinkByName("clat")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tun.Attrs().MTU).To(Equal(link.Attrs().MTU - translationOverhead))
+
+			routes, err := netlinksafe.RouteList(tun, netlink.FAMILY_V4)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routes).To(ContainElement(HaveField("Dst.String()", "0.0.0.0/0")))
+
+			proxies, err := netlink.NeighProxyList(link.Attrs().Index, netlink.FAMILY_V6)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proxies).To(HaveLen(2))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		config, err := os.ReadFile(filepath.Join(dataDir, "test-ctr-eth0.conf"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(config)).To(ContainSubstring("map 192.0.0.1 2001:db8:1:2:"))
+
+		Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(Succeed())
+
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			defer GinkgoRecover()
+
+			_, err := netlinksafe.LinkByName("clat")
+			Expect(err).To(HaveOccurred())
+			link, err := netlinksafe.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			proxies, err := netlink.NeighProxyList(link.Attrs().Index, netlink.FAMILY_V6)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proxies).To(BeEmpty())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(dataDir, "test-ctr-eth0.pid")).NotTo(BeAnExistingFile())
+		Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(MatchError("tayga is not running for eth0"))
+	})
+
+	It("reports a translator that does not start", func() {
+		failing := filepath.Join(dataDir, "failing")
+		Expect(os.WriteFile(failing, []byte("#!/bin/sh\necho bad config >&2\nexit 1\n"), 0o755)).To(Succeed())
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "clat",
+			"taygaPath": %q, "dataDir": %q,
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "2001:db8:1:2::5/64", "interface": 0}]
+			}
+		}`, failing, dataDir))
+
+		_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).To(MatchError(ContainSubstring("tayga exited: exit status 1: bad config")))
+
+		// The failed ADD left nothing behind
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			defer GinkgoRecover()
+
+			_, err := netlinksafe.LinkByName("clat")
+			Expect(err).To(HaveOccurred())
+			link, err := netlinksafe.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			proxies, err := netlink.NeighProxyList(link.Attrs().Index, netlink.FAMILY_V6)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proxies).To(BeEmpty())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(dataDir, "test-ctr-eth0.conf")).NotTo(BeAnExistingFile())
+	})
+
+	It("fails CHECK once tayga is no longer running", func() {
+		_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+		Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(Succeed())
+
+		data, err := os.ReadFile(filepath.Join(dataDir, "test-ctr-eth0.pid"))
+		Expect(err).NotTo(HaveOccurred())
+		pid, err := strconv.Atoi(string(data))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(syscall.Kill(pid, syscall.SIGKILL)).To(Succeed())
+
+		Eventually(func() error {
+			return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+		}).Should(MatchError("tayga is not running for eth0"))
+
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+	})
+
+	It("does not fail DEL once the netns is gone", func() {
+		args.Netns = "/var/run/netns/nonexistent"
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+	})
+})
@@ -0,0 +1,138 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+var _ = Describe("clat configuration", func() {
+	args := &skel.CmdArgs{ContainerID: "ctr", IfName: "eth0"}
+
+	It("defaults to the well-known prefix and the IPv4 service continuity prefix", func() {
+		conf, err := parseConfig([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "clat",
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "2001:db8:1:2::5/64", "interface": 0}]
+			}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		addrs, err := conf.addresses(conf.PrevResult.(*current.Result), args)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addrs.ipv4.String()).To(Equal("192.0.0.1"))
+		Expect(addrs.translatorIPv4.String()).To(Equal("192.0.0.2"))
+		Expect(addrs.nat64Prefix.String()).To(Equal("64:ff9b::/96"))
+
+		// The CLAT addresses are stable and within the container /64
+		Expect(addrs.translatorIPv6[:15]).To(Equal(addrs.clat[:15]))
+		Expect(addrs.clat.String()).To(HavePrefix("2001:db8:1:2:"))
+		Expect(addrs.translatorIPv6[15]).To(Equal(addrs.clat[15] ^ 1))
+		again, err := conf.addresses(conf.PrevResult.(*current.Result), args)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again.clat).To(Equal(addrs.clat))
+
+		Expect(taygaConfig(addrs)).To(Equal(fmt.Sprintf(`tun-device clat
+ipv4-addr 192.0.0.2
+ipv6-addr %s
+prefix 64:ff9b::/96
+map 192.0.0.1 %s
+`, addrs.translatorIPv6, addrs.clat)))
+	})
+
+	It("uses the configured addresses", func() {
+		conf, err := parseConfig([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "clat",
+			"nat64Prefix": "2001:db8:64::/96", "ipv4Address": "192.0.0.4", "clatAddress": "2001:db8:1:2::c1a7",
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "2001:db8:1:2::5/128", "interface": 0}]
+			}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		addrs, err := conf.addresses(conf.PrevResult.(*current.Result), args)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addrs.ipv4.String()).To(Equal("192.0.0.4"))
+		Expect(addrs.translatorIPv4.String()).To(Equal("192.0.0.5"))
+		Expect(addrs.clat.String()).To(Equal("2001:db8:1:2::c1a7"))
+		Expect(addrs.translatorIPv6.String()).To(Equal("2001:db8:1:2::c1a6"))
+	})
+
+	It("requires an IPv6 address on the interface", func() {
+		conf, err := parseConfig([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "clat",
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "10.1.2.3/24", "interface": 0}]
+			}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conf.addresses(conf.PrevResult.(*current.Result), args)
+		Expect(err).To(MatchError("no IPv6 address on eth0 in prevResult"))
+
+		conf, err = parseConfig([]byte(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "clat",
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "2001:db8:1:2::5/128", "interface": 0}]
+			}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conf.addresses(conf.PrevResult.(*current.Result), args)
+		Expect(err).To(MatchError(ContainSubstring("cannot derive clatAddress")))
+	})
+
+	It("rejects invalid configurations", func() {
+		for _, extra := range []string{
+			`"nat64Prefix": "64:ff9b::/64",`,
+			`"nat64Prefix": "10.0.0.0/8",`,
+			`"ipv4Address": "2001:db8::1",`,
+			`"clatAddress": "192.0.0.1",`,
+		} {
+			_, err := parseConfig([]byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "clat",
+				%s
+				"prevResult": {
+					"cniVersion": "1.0.0",
+					"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+					"ips": [{"address": "2001:db8::5/64", "interface": 0}]
+				}
+			}`, extra)))
+			Expect(err).To(HaveOccurred(), extra)
+		}
+	})
+})
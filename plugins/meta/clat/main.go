@@ -0,0 +1,228 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a chained plugin that provides IPv4 connectivity to containers of
+// IPv6-only networks, as the CLAT of 464XLAT (RFC 6877). It gives the
+// container an IPv4 address and default route on a TUN interface, and runs
+// the tayga stateless translator on it to translate to and from the NAT64
+// prefix of the network.
+//
+// The plugin owns the tayga process of each attachment: ADD starts it in a
+// session of its own, so that it outlives the plugin, and records its pid;
+// CHECK fails once it is no longer running, and DEL stops it. Nothing
+// restarts a translator that died: the runtime has to recreate the
+// attachment after a failed CHECK.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ns"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+const (
+	defaultNAT64Prefix = "64:ff9b::/96"
+	// The first address of the IPv4 Service Continuity Prefix (RFC 7335)
+	defaultIPv4Address = "192.0.0.1"
+	defaultDataDir     = "/var/lib/cni/clat"
+	defaultTayga       = "tayga"
+
+	clatIfName = "clat"
+)
+
+// ClatConf is the configuration document passed in.
+type ClatConf struct {
+	types.NetConf
+
+	// NAT64Prefix is the /96 prefix IPv4 destinations are mapped to
+	NAT64Prefix string `json:"nat64Prefix,omitempty"`
+	// IPv4Address is the address given to the container; the next one is
+	// the address of the translator
+	IPv4Address string `json:"ipv4Address,omitempty"`
+	// CLATAddress is the IPv6 address the container IPv4 address is
+	// translated to. It is derived from the prefix of the container IPv6
+	// address if unset.
+	CLATAddress string `json:"clatAddress,omitempty"`
+	TaygaPath   string `json:"taygaPath,omitempty"`
+	DataDir     string `json:"dataDir,omitempty"`
+
+	nat64Prefix *net.IPNet
+	ipv4Address net.IP
+	clatAddress net.IP
+}
+
+// parseConfig parses the supplied configuration (and prevResult) from stdin.
+func parseConfig(stdin []byte) (*ClatConf, error) {
+	conf := ClatConf{
+		NAT64Prefix: defaultNAT64Prefix,
+		IPv4Address: defaultIPv4Address,
+		TaygaPath:   defaultTayga,
+		DataDir:     defaultDataDir,
+	}
+
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	if conf.RawPrevResult != nil {
+		var err error
+		if err = version.ParsePrevResult(&conf.NetConf); err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+
+		_, err = current.NewResultFromResult(conf.PrevResult)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	_, prefix, err := net.ParseCIDR(conf.NAT64Prefix)
+	if err != nil || prefix.IP.To4() != nil {
+		return nil, fmt.Errorf("invalid nat64Prefix %q", conf.NAT64Prefix)
+	}
+	if ones, _ := prefix.Mask.Size(); ones != 96 {
+		return nil, fmt.Errorf("nat64Prefix %q must be a /96", conf.NAT64Prefix)
+	}
+	conf.nat64Prefix = prefix
+
+	conf.ipv4Address = net.ParseIP(conf.IPv4Address).To4()
+	if conf.ipv4Address == nil {
+		return nil, fmt.Errorf("invalid ipv4Address %q", conf.IPv4Address)
+	}
+
+	if conf.CLATAddress != "" {
+		conf.clatAddress = net.ParseIP(conf.CLATAddress)
+		if conf.clatAddress == nil || conf.clatAddress.To4() != nil {
+			return nil, fmt.Errorf("invalid clatAddress %q", conf.CLATAddress)
+		}
+	}
+
+	return &conf, nil
+}
+
+// statePath returns the path of the file of kind for the attachment.
+func (conf *ClatConf) statePath(args *skel.CmdArgs, kind string) string {
+	return filepath.Join(conf.DataDir, fmt.Sprintf("%s-%s-%s.%s", conf.Name, args.ContainerID, args.IfName, kind))
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+	result, err := current.NewResultFromResult(conf.PrevResult)
+	if err != nil {
+		return fmt.Errorf("could not convert result to current version: %v", err)
+	}
+
+	addrs, err := conf.addresses(result, args)
+	if err != nil {
+		return err
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return setupCLAT(args.IfName, addrs)
+	})
+	if err == nil {
+		err = conf.startTayga(args, netns, addrs)
+	}
+	if err != nil {
+		// Leave neither the TUN interface nor its routes and proxies behind
+		_ = netns.Do(func(_ ns.NetNS) error {
+			return teardownCLAT(args.IfName)
+		})
+		_ = conf.removeState(args)
+		return err
+	}
+
+	result.Interfaces = append(result.Interfaces, &current.Interface{
+		Name:    clatIfName,
+		Sandbox: args.Netns,
+	})
+	result.IPs = append(result.IPs, &current.IPConfig{
+		Address:   net.IPNet{IP: addrs.ipv4, Mask: net.CIDRMask(32, 32)},
+		Interface: current.Int(len(result.Interfaces) - 1),
+	})
+	result.Routes = append(result.Routes, &types.Route{
+		Dst: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+	})
+
+	return types.PrintResult(result, conf.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if err := conf.stopTayga(args); err != nil {
+		return err
+	}
+
+	if args.Netns != "" {
+		err := ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+			return teardownCLAT(args.IfName)
+		})
+		if _, ok := err.(ns.NSPathNotExistErr); !ok && err != nil {
+			return err
+		}
+	}
+
+	return conf.removeState(args)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	if err := conf.checkTayga(args); err != nil {
+		return err
+	}
+
+	return ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		return checkCLAT(conf.ipv4Address)
+	})
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Check: cmdCheck,
+		Del:   cmdDel,
+	}, version.All, bv.BuildString("clat"))
+}
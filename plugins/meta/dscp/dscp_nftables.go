@@ -0,0 +1,191 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"sigs.k8s.io/knftables"
+)
+
+const (
+	tableName = "cni_dscp"
+
+	postroutingChain = "postrouting"
+)
+
+// Each interface gets a chain of its own, with a rule per class setting the
+// codepoint and accepting the packet, followed by the rules setting the
+// default codepoint. The postrouting chain jumps to it for the packets sent
+// through the interface; that rule is commented with the interface name so
+// it can be found on CHECK and DEL.
+
+func interfaceChain(ifName string) string {
+	return "dscp-" + ifName
+}
+
+// classRules returns the rules of the chain of an interface.
+func classRules(conf *DSCPConf) [][]string {
+	rules := [][]string{}
+	for _, c := range conf.Classes {
+		var match []string
+		families := []string{"ip", "ip6"}
+		if c.Dst != "" {
+			ip, _, _ := net.ParseCIDR(c.Dst)
+			if ip.To4() != nil {
+				families = []string{"ip"}
+			} else {
+				families = []string{"ip6"}
+			}
+			match = append(match, families[0], "daddr", c.Dst)
+		}
+		if c.Protocol != "" {
+			match = append(match, "meta l4proto", c.Protocol)
+		}
+		if c.Ports != "" {
+			match = append(match, "th dport", c.Ports)
+		}
+
+		for _, family := range families {
+			rules = append(rules, append(match[:len(match):len(match)],
+				family, "dscp set", fmt.Sprint(c.DSCP), "accept"))
+		}
+	}
+
+	if conf.DSCP != nil {
+		for _, family := range []string{"ip", "ip6"} {
+			rules = append(rules, []string{family, "dscp set", fmt.Sprint(*conf.DSCP)})
+		}
+	}
+	return rules
+}
+
+// setupMarking (re)creates the chain of ifName and jumps to it.
+func setupMarking(nft knftables.Interface, conf *DSCPConf, ifName string) error {
+	chain := interfaceChain(ifName)
+
+	tx := nft.NewTransaction()
+	tx.Add(&knftables.Table{
+		Comment: knftables.PtrTo("CNI dscp plugin"),
+	})
+	tx.Add(&knftables.Chain{
+		Name:     postroutingChain,
+		Type:     knftables.PtrTo(knftables.FilterType),
+		Hook:     knftables.PtrTo(knftables.PostroutingHook),
+		Priority: knftables.PtrTo(knftables.ManglePriority),
+	})
+	tx.Add(&knftables.Chain{
+		Name: chain,
+	})
+	tx.Flush(&knftables.Chain{
+		Name: chain,
+	})
+	for _, rule := range classRules(conf) {
+		tx.Add(&knftables.Rule{
+			Chain: chain,
+			Rule:  knftables.Concat(rule),
+		})
+	}
+
+	jumps, err := jumpRules(nft, ifName)
+	if err != nil {
+		return err
+	}
+	for _, r := range jumps {
+		tx.Delete(r)
+	}
+	tx.Add(&knftables.Rule{
+		Chain:   postroutingChain,
+		Rule:    knftables.Concat("oifname", fmt.Sprintf("%q", ifName), "jump", chain),
+		Comment: knftables.PtrTo(ifName),
+	})
+
+	return nft.Run(context.TODO(), tx)
+}
+
+// jumpRules returns the rules of the postrouting chain jumping to the chain
+// of ifName.
+func jumpRules(nft knftables.Interface, ifName string) ([]*knftables.Rule, error) {
+	rules, err := nft.ListRules(context.TODO(), postroutingChain)
+	if err != nil {
+		if knftables.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not list rules in table %s: %w", tableName, err)
+	}
+
+	jumps := []*knftables.Rule{}
+	for _, r := range rules {
+		if r.Comment != nil && *r.Comment == ifName {
+			jumps = append(jumps, r)
+		}
+	}
+	return jumps, nil
+}
+
+// checkMarking checks that packets sent through ifName are marked as set up
+// by conf. The rules are only counted, as nft does not print them back as
+// they were written.
+func checkMarking(nft knftables.Interface, conf *DSCPConf, ifName string) error {
+	jumps, err := jumpRules(nft, ifName)
+	if err != nil {
+		return err
+	}
+	if len(jumps) == 0 {
+		return fmt.Errorf("missing DSCP marking of %s", ifName)
+	}
+
+	rules, err := nft.ListRules(context.TODO(), interfaceChain(ifName))
+	if err != nil {
+		return fmt.Errorf("could not list DSCP rules of %s: %w", ifName, err)
+	}
+	if expected := len(classRules(conf)); len(rules) != expected {
+		return fmt.Errorf("expected %d DSCP rules for %s, found %d", expected, ifName, len(rules))
+	}
+	return nil
+}
+
+// teardownMarking removes the chain of ifName and the jump to it. It does not
+// fail if they do not exist.
+func teardownMarking(nft knftables.Interface, ifName string) error {
+	jumps, err := jumpRules(nft, ifName)
+	if err != nil {
+		return err
+	}
+
+	chain := interfaceChain(ifName)
+	if _, err := nft.ListRules(context.TODO(), chain); err != nil {
+		if !knftables.IsNotFound(err) {
+			return fmt.Errorf("could not list DSCP rules of %s: %w", ifName, err)
+		}
+		if len(jumps) == 0 {
+			return nil
+		}
+		chain = ""
+	}
+
+	tx := nft.NewTransaction()
+	for _, r := range jumps {
+		tx.Delete(r)
+	}
+	if chain != "" {
+		tx.Delete(&knftables.Chain{
+			Name: chain,
+		})
+	}
+	return nft.Run(context.TODO(), tx)
+}
@@ -0,0 +1,127 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/knftables"
+)
+
+var _ = Describe("dscp plugin", func() {
+	Describe("configuration", func() {
+		It("accepts codepoints as numbers and names", func() {
+			conf, err := parseConfig([]byte(`{
+				"cniVersion": "1.0.0", "name": "test", "type": "dscp",
+				"dscp": "AF41",
+				"classes": [{"dst": "10.0.0.0/8", "dscp": 46}, {"protocol": "udp", "ports": "5060-5061", "dscp": "cs3"}]
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*conf.DSCP).To(Equal(Codepoint(34)))
+			Expect(conf.Classes[0].DSCP).To(Equal(Codepoint(46)))
+			Expect(conf.Classes[1].DSCP).To(Equal(Codepoint(24)))
+		})
+
+		It("rejects invalid configurations", func() {
+			for _, conf := range []string{
+				`{}`,
+				`{"dscp": 64}`,
+				`{"dscp": "gold"}`,
+				`{"classes": [{"dst": "10.0.0.0", "dscp": 46}]}`,
+				`{"classes": [{"protocol": "icmp", "dscp": 46}]}`,
+				`{"classes": [{"ports": "80", "dscp": 46}]}`,
+				`{"classes": [{"protocol": "tcp", "ports": "80-79", "dscp": 46}]}`,
+				`{"classes": [{"protocol": "tcp", "ports": "70000", "dscp": 46}]}`,
+			} {
+				_, err := parseConfig([]byte(conf))
+				Expect(err).To(HaveOccurred(), conf)
+			}
+		})
+	})
+
+	Describe("nftables rules", func() {
+		var fake *knftables.Fake
+		var conf *DSCPConf
+
+		BeforeEach(func() {
+			fake = knftables.NewFake(knftables.InetFamily, tableName)
+
+			var err error
+			conf, err = parseConfig([]byte(`{
+				"cniVersion": "1.0.0", "name": "test", "type": "dscp",
+				"dscp": "af11",
+				"classes": [
+					{"dst": "10.0.0.0/8", "protocol": "tcp", "ports": "443", "dscp": "ef"},
+					{"dst": "2001:db8::/32", "dscp": "cs1"},
+					{"protocol": "udp", "ports": "5060-5061", "dscp": 24}
+				]
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("marks the traffic of each interface in a chain of its own", func() {
+			Expect(setupMarking(fake, conf, "eth0")).To(Succeed())
+			Expect(setupMarking(fake, conf, "net1")).To(Succeed())
+
+			// Setting up again replaces the rules of the interface
+			conf.Classes = conf.Classes[:1]
+			Expect(setupMarking(fake, conf, "eth0")).To(Succeed())
+
+			expected := strings.TrimSpace(`
+add table inet cni_dscp { comment "CNI dscp plugin" ; }
+add chain inet cni_dscp dscp-eth0
+add chain inet cni_dscp dscp-net1
+add chain inet cni_dscp postrouting { type filter hook postrouting priority -150 ; }
+add rule inet cni_dscp dscp-eth0 ip daddr 10.0.0.0/8 meta l4proto tcp th dport 443 ip dscp set 46 accept
+add rule inet cni_dscp dscp-eth0 ip dscp set 10
+add rule inet cni_dscp dscp-eth0 ip6 dscp set 10
+add rule inet cni_dscp dscp-net1 ip daddr 10.0.0.0/8 meta l4proto tcp th dport 443 ip dscp set 46 accept
+add rule inet cni_dscp dscp-net1 ip6 daddr 2001:db8::/32 ip6 dscp set 8 accept
+add rule inet cni_dscp dscp-net1 meta l4proto udp th dport 5060-5061 ip dscp set 24 accept
+add rule inet cni_dscp dscp-net1 meta l4proto udp th dport 5060-5061 ip6 dscp set 24 accept
+add rule inet cni_dscp dscp-net1 ip dscp set 10
+add rule inet cni_dscp dscp-net1 ip6 dscp set 10
+add rule inet cni_dscp postrouting oifname "net1" jump dscp-net1 comment "net1"
+add rule inet cni_dscp postrouting oifname "eth0" jump dscp-eth0 comment "eth0"
+`)
+			Expect(strings.TrimSpace(fake.Dump())).To(Equal(expected))
+		})
+
+		It("checks and removes the marking of an interface", func() {
+			Expect(checkMarking(fake, conf, "eth0")).To(MatchError("missing DSCP marking of eth0"))
+
+			Expect(setupMarking(fake, conf, "eth0")).To(Succeed())
+			Expect(setupMarking(fake, conf, "net1")).To(Succeed())
+			Expect(checkMarking(fake, conf, "eth0")).To(Succeed())
+
+			conf.Classes = conf.Classes[:1]
+			Expect(checkMarking(fake, conf, "eth0")).To(MatchError("expected 3 DSCP rules for eth0, found 6"))
+
+			Expect(teardownMarking(fake, "eth0")).To(Succeed())
+			Expect(teardownMarking(fake, "eth0")).To(Succeed())
+			Expect(checkMarking(fake, conf, "eth0")).To(MatchError("missing DSCP marking of eth0"))
+
+			dump := fake.Dump()
+			Expect(dump).NotTo(ContainSubstring("eth0"))
+			Expect(dump).To(ContainSubstring("jump dscp-net1"))
+		})
+
+		It("does not fail to remove what was never set up", func() {
+			Expect(teardownMarking(fake, "eth0")).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,246 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a chained plugin that sets the DSCP field of the packets the
+// container sends, using nftables in the container network namespace. The
+// codepoint can be chosen per destination and per port.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/knftables"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ns"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+// Codepoint is a DSCP value, given either as a number or by its name in
+// RFC 2474, RFC 2597, RFC 3246 or RFC 8622 ("cs1", "af41", "ef", "le").
+type Codepoint uint8
+
+var codepointNames = map[string]Codepoint{
+	"cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+	"af11": 10, "af12": 12, "af13": 14,
+	"af21": 18, "af22": 20, "af23": 22,
+	"af31": 26, "af32": 28, "af33": 30,
+	"af41": 34, "af42": 36, "af43": 38,
+	"ef": 46, "le": 1,
+}
+
+func (c *Codepoint) UnmarshalJSON(data []byte) error {
+	var value int
+	if err := json.Unmarshal(data, &value); err == nil {
+		if value < 0 || value > 63 {
+			return fmt.Errorf("invalid DSCP value %d", value)
+		}
+		*c = Codepoint(value)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("invalid DSCP value %s", string(data))
+	}
+	codepoint, ok := codepointNames[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown DSCP class %q", name)
+	}
+	*c = codepoint
+	return nil
+}
+
+// Class selects egress traffic by destination and port to give it a
+// codepoint of its own.
+type Class struct {
+	Dst      string `json:"dst,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	// Ports is a port or a range of ports, e.g. "443" or "8000-8080"
+	Ports string    `json:"ports,omitempty"`
+	DSCP  Codepoint `json:"dscp"`
+}
+
+// DSCPConf is the configuration document passed in.
+type DSCPConf struct {
+	types.NetConf
+
+	RawPrevResult *map[string]interface{} `json:"prevResult"`
+	PrevResult    *current.Result         `json:"-"`
+
+	// DSCP is given to the traffic no class matches; it is left alone if
+	// unset.
+	DSCP    *Codepoint `json:"dscp,omitempty"`
+	Classes []Class    `json:"classes,omitempty"`
+}
+
+var portsRegexp = regexp.MustCompile(`^([0-9]+)(-([0-9]+))?$`)
+
+func (c *Class) validate() error {
+	if c.Dst != "" {
+		if _, _, err := net.ParseCIDR(c.Dst); err != nil {
+			return fmt.Errorf("invalid class destination %q", c.Dst)
+		}
+	}
+
+	switch c.Protocol {
+	case "":
+		if c.Ports != "" {
+			return fmt.Errorf("class ports %q require a protocol", c.Ports)
+		}
+	case "tcp", "udp", "sctp":
+	default:
+		return fmt.Errorf("invalid class protocol %q", c.Protocol)
+	}
+
+	if c.Ports != "" {
+		m := portsRegexp.FindStringSubmatch(c.Ports)
+		if m == nil {
+			return fmt.Errorf("invalid class ports %q", c.Ports)
+		}
+		first, err := strconv.ParseUint(m[1], 10, 16)
+		last := first
+		if err == nil && m[3] != "" {
+			last, err = strconv.ParseUint(m[3], 10, 16)
+		}
+		if err != nil || first == 0 || last < first {
+			return fmt.Errorf("invalid class ports %q", c.Ports)
+		}
+	}
+	return nil
+}
+
+// parseConfig parses the supplied configuration (and prevResult) from stdin.
+func parseConfig(stdin []byte) (*DSCPConf, error) {
+	conf := DSCPConf{}
+
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	if conf.RawPrevResult != nil {
+		resultBytes, err := json.Marshal(conf.RawPrevResult)
+		if err != nil {
+			return nil, fmt.Errorf("could not serialize prevResult: %v", err)
+		}
+		res, err := version.NewResult(conf.CNIVersion, resultBytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+		conf.RawPrevResult = nil
+		conf.PrevResult, err = current.NewResultFromResult(res)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	if conf.DSCP == nil && len(conf.Classes) == 0 {
+		return nil, fmt.Errorf("at least one of dscp and classes must be set")
+	}
+	for i := range conf.Classes {
+		if err := conf.Classes[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &conf, nil
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		nft, err := knftables.New(knftables.InetFamily, tableName)
+		if err != nil {
+			return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "could not initialize nftables")
+		}
+		return setupMarking(nft, conf, args.IfName)
+	})
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInternal, err, "failed to set up DSCP marking on %s", args.IfName)
+	}
+
+	return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	if args.Netns == "" {
+		return nil
+	}
+
+	err := ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		nft, err := knftables.New(knftables.InetFamily, tableName)
+		if err != nil {
+			return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "could not initialize nftables")
+		}
+		return teardownMarking(nft, args.IfName)
+	})
+	if _, ok := err.(ns.NSPathNotExistErr); ok {
+		return nil
+	}
+	return err
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	return netns.Do(func(_ ns.NetNS) error {
+		nft, err := knftables.New(knftables.InetFamily, tableName)
+		if err != nil {
+			return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "could not initialize nftables")
+		}
+		return checkMarking(nft, conf, args.IfName)
+	})
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Check: cmdCheck,
+		Del:   cmdDel,
+	}, version.All, bv.BuildString("dscp"))
+}
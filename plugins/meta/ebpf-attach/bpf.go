@@ -0,0 +1,110 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// This is a minimal wrapper around the bpf(2) syscall, covering just what the
+// plugin needs: opening pinned programs and reading their ID. The attr
+// layouts follow union bpf_attr in include/uapi/linux/bpf.h.
+
+// bpfAttrObj is the BPF_OBJ_* part of union bpf_attr
+type bpfAttrObj struct {
+	pathname  uint64
+	bpfFd     uint32
+	fileFlags uint32
+}
+
+// bpfAttrInfo is the BPF_OBJ_GET_INFO_BY_FD part of union bpf_attr
+type bpfAttrInfo struct {
+	bpfFd   uint32
+	infoLen uint32
+	info    uint64
+}
+
+// bpfProgInfo is the head of struct bpf_prog_info, which is all the kernel
+// fills in when passed a shorter length.
+type bpfProgInfo struct {
+	progType uint32
+	id       uint32
+}
+
+func bpfCall(cmd int, attr unsafe.Pointer, size uintptr) (uintptr, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
+
+// bpfObjGet opens the BPF object pinned at path.
+func bpfObjGet(path string) (int, error) {
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return -1, err
+	}
+	attr := bpfAttrObj{pathname: uint64(uintptr(unsafe.Pointer(p)))}
+	fd, err := bpfCall(unix.BPF_OBJ_GET, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(p)
+	if err != nil {
+		return -1, err
+	}
+	return int(fd), nil
+}
+
+// bpfProgID returns the ID of the program fd.
+func bpfProgID(fd int) (uint32, error) {
+	info := bpfProgInfo{}
+	attr := bpfAttrInfo{
+		bpfFd:   uint32(fd),
+		infoLen: uint32(unsafe.Sizeof(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info))),
+	}
+	_, err := bpfCall(unix.BPF_OBJ_GET_INFO_BY_FD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(&info)
+	if err != nil {
+		return 0, err
+	}
+	return info.id, nil
+}
+
+// pinnedProgram is a program opened from its pin.
+type pinnedProgram struct {
+	fd int
+	id uint32
+}
+
+// openPinnedProgram opens the program pinned at path. It must be closed.
+func openPinnedProgram(path string) (*pinnedProgram, error) {
+	fd, err := bpfObjGet(path)
+	if err != nil {
+		return nil, err
+	}
+	id, err := bpfProgID(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &pinnedProgram{fd: fd, id: id}, nil
+}
+
+func (p *pinnedProgram) close() {
+	unix.Close(p.fd)
+}
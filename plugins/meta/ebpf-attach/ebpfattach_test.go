@@ -0,0 +1,263 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"unsafe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+// bpfAttrProgLoad is the BPF_PROG_LOAD part of union bpf_attr
+type bpfAttrProgLoad struct {
+	progType uint32
+	insnCnt  uint32
+	insns    uint64
+	license  uint64
+}
+
+// loadProgram loads a program of progType returning ret, and pins it at
+// path.
+func loadProgram(progType uint32, ret int32, path string) error {
+	insns := []uint64{
+		0xb7 | uint64(uint32(ret))<<32, // mov r0, ret
+		0x95,                           // exit
+	}
+	license := []byte("Apache-2.0\x00")
+	attr := bpfAttrProgLoad{
+		progType: progType,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	fd, err := bpfCall(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(int(fd))
+
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	pin := bpfAttrObj{pathname: uint64(uintptr(unsafe.Pointer(p))), bpfFd: uint32(fd)}
+	_, err = bpfCall(unix.BPF_OBJ_PIN, unsafe.Pointer(&pin), unsafe.Sizeof(pin))
+	runtime.KeepAlive(p)
+	return err
+}
+
+var _ = Describe("ebpf-attach plugin", func() {
+	Describe("configuration", func() {
+		It("defaults programs to the container side", func() {
+			conf, err := parseConfig([]byte(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "ebpf-attach",
+				"programs": [
+					{"pin": "/sys/fs/bpf/a", "hook": "ingress", "priority": 10},
+					{"pin": "/sys/fs/bpf/b", "hook": "xdp", "side": "host", "xdpMode": "generic"}
+				],
+				"prevResult": {
+					"cniVersion": "1.0.0",
+					"interfaces": [{"name": "eth0", "sandbox": "/some/netns"}],
+					"ips": [{"address": "10.1.2.3/24", "interface": 0}]
+				}
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.PrevResult).NotTo(BeNil())
+			Expect(conf.programs(sideContainer)).To(HaveLen(1))
+			Expect(conf.programs(sideHost)).To(HaveLen(1))
+			Expect(conf.Programs[0].filterName()).To(Equal("cni:/sys/fs/bpf/a"))
+		})
+
+		It("rejects invalid programs", func() {
+			for _, progs := range []string{
+				`[{"hook": "ingress"}]`,
+				`[{"pin": "/sys/fs/bpf/a"}]`,
+				`[{"pin": "/sys/fs/bpf/a", "hook": "forward"}]`,
+				`[{"pin": "/sys/fs/bpf/a", "hook": "ingress", "side": "peer"}]`,
+				`[{"pin": "/sys/fs/bpf/a", "hook": "ingress", "xdpMode": "native"}]`,
+				`[{"pin": "/sys/fs/bpf/a", "hook": "xdp", "xdpMode": "fast"}]`,
+				`[{"pin": "/sys/fs/bpf/a", "hook": "xdp", "priority": 1}]`,
+			} {
+				_, err := parseConfig([]byte(fmt.Sprintf(`{
+					"cniVersion": "1.0.0",
+					"name": "test",
+					"type": "ebpf-attach",
+					"programs": %s,
+					"prevResult": {
+						"cniVersion": "1.0.0",
+						"interfaces": [{"name": "eth0", "sandbox": "/some/netns"}],
+						"ips": [{"address": "10.1.2.3/24", "interface": 0}]
+					}
+				}`, progs)))
+				Expect(err).To(HaveOccurred(), progs)
+			}
+		})
+	})
+
+	Describe("attachment", func() {
+		var hostNs, targetNs ns.NetNS
+		var bpffs string
+		var args *skel.CmdArgs
+
+		BeforeEach(func() {
+			bpffs = GinkgoT().TempDir()
+			if err := unix.Mount("bpf", bpffs, "bpf", 0, ""); err != nil {
+				Skip(fmt.Sprintf("cannot mount a bpffs: %v", err))
+			}
+			DeferCleanup(unix.Unmount, bpffs, 0)
+			if err := loadProgram(unix.BPF_PROG_TYPE_SCHED_CLS, 0, filepath.Join(bpffs, "tc")); err != nil {
+				Skip(fmt.Sprintf("cannot load BPF programs: %v", err))
+			}
+			Expect(loadProgram(unix.BPF_PROG_TYPE_XDP, 2, filepath.Join(bpffs, "xdp"))).To(Succeed())
+
+			var err error
+			hostNs, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			targetNs, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+
+			err = hostNs.Do(func(_ ns.NetNS) error {
+				veth := &netlink.Veth{
+					LinkAttrs:     netlink.LinkAttrs{Name: "peer0"},
+					PeerName:      "eth0",
+					PeerNamespace: netlink.NsFd(int(targetNs.Fd())),
+				}
+				if err := netlink.LinkAdd(veth); err != nil {
+					return err
+				}
+				return netlink.LinkSetUp(veth)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			args = &skel.CmdArgs{
+				ContainerID: "ctr",
+				Netns:       targetNs.Path(),
+				IfName:      "eth0",
+				StdinData: []byte(fmt.Sprintf(`{
+					"cniVersion": "1.0.0",
+					"name": "test",
+					"type": "ebpf-attach",
+					"programs": [
+						{"pin": %q, "hook": "ingress"},
+						{"pin": %q, "hook": "egress", "priority": 7},
+						{"pin": %q, "hook": "xdp", "side": "host", "xdpMode": "generic"}
+					],
+					"prevResult": {
+						"cniVersion": "1.0.0",
+						"interfaces": [{"name": "eth0", "sandbox": "/some/netns"}],
+						"ips": [{"address": "10.1.2.3/24", "interface": 0}]
+					}
+				}`, filepath.Join(bpffs, "tc"), filepath.Join(bpffs, "tc"), filepath.Join(bpffs, "xdp"))),
+			}
+		})
+
+		AfterEach(func() {
+			Expect(targetNs.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(targetNs)).To(Succeed())
+			Expect(hostNs.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(hostNs)).To(Succeed())
+		})
+
+		// attached returns the number of TC filters and whether an XDP
+		// program is attached on the interface ifName.
+		attached := func(ifName string) (int, bool) {
+			link, err := netlinksafe.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+			filters := 0
+			for _, parent := range []uint32{netlink.HANDLE_MIN_INGRESS, netlink.HANDLE_MIN_EGRESS} {
+				fs, err := netlinksafe.FilterList(link, parent)
+				Expect(err).NotTo(HaveOccurred())
+				filters += len(fs)
+			}
+			return filters, link.Attrs().Xdp != nil && link.Attrs().Xdp.Attached
+		}
+
+		It("attaches, checks and detaches the programs on both sides", func() {
+			err := hostNs.Do(func(_ ns.NetNS) error {
+				defer GinkgoRecover()
+
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				Expect(err).NotTo(HaveOccurred())
+				// Adding again does not duplicate the filters
+				_, _, err = testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				Expect(err).NotTo(HaveOccurred())
+				Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(Succeed())
+
+				filters, xdp := attached("peer0")
+				Expect(filters).To(Equal(0))
+				Expect(xdp).To(BeTrue())
+				err = targetNs.Do(func(_ ns.NetNS) error {
+					defer GinkgoRecover()
+
+					filters, xdp := attached("eth0")
+					Expect(filters).To(Equal(2))
+					Expect(xdp).To(BeFalse())
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+				Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+				Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(MatchError(
+					ContainSubstring("not attached")))
+
+				_, xdp = attached("peer0")
+				Expect(xdp).To(BeFalse())
+				return targetNs.Do(func(_ ns.NetNS) error {
+					defer GinkgoRecover()
+
+					filters, _ := attached("eth0")
+					Expect(filters).To(Equal(0))
+					return nil
+				})
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("fails on a missing pin", func() {
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "ebpf-attach",
+				"programs": [{"pin": %q, "hook": "ingress"}],
+				"prevResult": {
+					"cniVersion": "1.0.0",
+					"interfaces": [{"name": "eth0", "sandbox": "/some/netns"}],
+					"ips": [{"address": "10.1.2.3/24", "interface": 0}]
+				}
+			}`, filepath.Join(bpffs, "missing")))
+			err := hostNs.Do(func(_ ns.NetNS) error {
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).To(MatchError(ContainSubstring("failed to open pinned program")))
+		})
+	})
+})
@@ -0,0 +1,512 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a chained plugin that attaches eBPF programs, loaded and pinned in
+// a bpffs by the operator, to the interfaces of the attachment: as TC
+// classifiers on their ingress or egress, or as their XDP program. It gives
+// custom datapath logic a hook without forking the interface plugins. The
+// programs are verified on CHECK and detached on DEL.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+const (
+	sideContainer = "container"
+	sideHost      = "host"
+
+	hookIngress = "ingress"
+	hookEgress  = "egress"
+	hookXDP     = "xdp"
+
+	// The prefix of the names of the TC filters of the plugin, followed by
+	// the pin of their program
+	filterPrefix = "cni:"
+)
+
+var xdpModes = map[string]int{
+	"":        0,
+	"generic": unix.XDP_FLAGS_SKB_MODE,
+	"native":  unix.XDP_FLAGS_DRV_MODE,
+	"offload": unix.XDP_FLAGS_HW_MODE,
+}
+
+// Program is a pinned program to attach. Side is "container", the default,
+// or "host". Dev defaults to CNI_IFNAME in the container, and to its veth
+// peer on the host.
+type Program struct {
+	Pin  string `json:"pin"`
+	Hook string `json:"hook"`
+	Side string `json:"side,omitempty"`
+	Dev  string `json:"dev,omitempty"`
+	// Priority is the priority of a TC filter, picked by the kernel if unset
+	Priority uint16 `json:"priority,omitempty"`
+	// XDPMode forces the mode of an XDP program to "generic", "native" or
+	// "offload"
+	XDPMode string `json:"xdpMode,omitempty"`
+}
+
+// PluginConf is the configuration document passed in.
+type PluginConf struct {
+	types.NetConf
+
+	RawPrevResult *map[string]interface{} `json:"prevResult"`
+	PrevResult    *current.Result         `json:"-"`
+
+	Programs []Program `json:"programs"`
+}
+
+// parseConfig parses the supplied configuration (and prevResult) from stdin.
+func parseConfig(stdin []byte) (*PluginConf, error) {
+	conf := PluginConf{}
+
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	for i := range conf.Programs {
+		if err := conf.Programs[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.RawPrevResult != nil {
+		resultBytes, err := json.Marshal(conf.RawPrevResult)
+		if err != nil {
+			return nil, fmt.Errorf("could not serialize prevResult: %v", err)
+		}
+		res, err := version.NewResult(conf.CNIVersion, resultBytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+		conf.RawPrevResult = nil
+		conf.PrevResult, err = current.NewResultFromResult(res)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	return &conf, nil
+}
+
+func (p *Program) validate() error {
+	if p.Pin == "" {
+		return fmt.Errorf("program pin must be set")
+	}
+	switch p.Hook {
+	case hookIngress, hookEgress:
+		if p.XDPMode != "" {
+			return fmt.Errorf("xdpMode of program %s requires the %q hook", p.Pin, hookXDP)
+		}
+	case hookXDP:
+		if _, ok := xdpModes[p.XDPMode]; !ok {
+			return fmt.Errorf("invalid xdpMode %q for program %s", p.XDPMode, p.Pin)
+		}
+		if p.Priority != 0 {
+			return fmt.Errorf("priority of program %s requires a TC hook", p.Pin)
+		}
+	default:
+		return fmt.Errorf("invalid hook %q for program %s, must be %q, %q or %q", p.Hook, p.Pin, hookIngress, hookEgress, hookXDP)
+	}
+	switch p.Side {
+	case "":
+		p.Side = sideContainer
+	case sideContainer, sideHost:
+	default:
+		return fmt.Errorf("invalid side %q for program %s, must be %q or %q", p.Side, p.Pin, sideContainer, sideHost)
+	}
+	return nil
+}
+
+// programs returns the programs of side.
+func (conf *PluginConf) programs(side string) []Program {
+	progs := []Program{}
+	for _, p := range conf.Programs {
+		if p.Side == side {
+			progs = append(progs, p)
+		}
+	}
+	return progs
+}
+
+func (p *Program) filterName() string {
+	return filterPrefix + p.Pin
+}
+
+func (p *Program) tcParent() uint32 {
+	if p.Hook == hookIngress {
+		return netlink.HANDLE_MIN_INGRESS
+	}
+	return netlink.HANDLE_MIN_EGRESS
+}
+
+// link returns the interface of p, its Dev or else the one returned by
+// defaultLink, resolved in the current netns.
+func (p *Program) link(defaultLink func() (netlink.Link, error)) (netlink.Link, error) {
+	var link netlink.Link
+	var err error
+	if p.Dev != "" {
+		link, err = netlinksafe.LinkByName(p.Dev)
+	} else {
+		link, err = defaultLink()
+	}
+	if err != nil {
+		return nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup the interface of program %s", p.Pin)
+	}
+	return link, nil
+}
+
+func openProgram(pin string) (*pinnedProgram, error) {
+	prog, err := openPinnedProgram(pin)
+	if err != nil {
+		return nil, cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to open pinned program %s", pin)
+	}
+	return prog, nil
+}
+
+// containerLink returns a lookup of the container interface, to be called
+// in the container netns.
+func containerLink(ifName string) func() (netlink.Link, error) {
+	return func() (netlink.Link, error) {
+		return netlinksafe.LinkByName(ifName)
+	}
+}
+
+// hostLink returns a lookup of the veth peer of the container interface, to
+// be called in the host netns.
+func hostLink(netns ns.NetNS, ifName string) func() (netlink.Link, error) {
+	return func() (netlink.Link, error) {
+		var peerIndex int
+		err := netns.Do(func(_ ns.NetNS) error {
+			// Looked up first to tell a missing interface apart
+			if _, err := netlinksafe.LinkByName(ifName); err != nil {
+				return err
+			}
+			var err error
+			_, peerIndex, err = ip.GetVethPeerIfindex(ifName)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		link, err := netlink.LinkByIndex(peerIndex)
+		if err != nil {
+			return nil, fmt.Errorf("veth peer of %s with index %d is not in host ns: %w", ifName, peerIndex, err)
+		}
+		return link, nil
+	}
+}
+
+// tcFilters returns the filters of p on link.
+func tcFilters(link netlink.Link, p *Program) ([]*netlink.BpfFilter, error) {
+	filters, err := netlinksafe.FilterList(link, p.tcParent())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list filters on %q: %v", link.Attrs().Name, err)
+	}
+	ours := []*netlink.BpfFilter{}
+	for _, f := range filters {
+		if bpf, ok := f.(*netlink.BpfFilter); ok && bpf.Name == p.filterName() {
+			ours = append(ours, bpf)
+		}
+	}
+	return ours, nil
+}
+
+// attachTC attaches p as a classifier of link, replacing its filters of a
+// previous version of the program.
+func attachTC(link netlink.Link, p *Program, prog *pinnedProgram) error {
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+	if err := netlink.QdiscReplace(qdisc); err != nil {
+		return fmt.Errorf("failed to add clsact qdisc to %q: %v", link.Attrs().Name, err)
+	}
+
+	filters, err := tcFilters(link, p)
+	if err != nil {
+		return err
+	}
+	for _, f := range filters {
+		if f.Id == int(prog.id) && (p.Priority == 0 || f.Priority == p.Priority) {
+			return nil
+		}
+	}
+	for _, f := range filters {
+		if err := netlink.FilterDel(f); err != nil {
+			return fmt.Errorf("failed to detach stale %s from %q: %v", p.Pin, link.Attrs().Name, err)
+		}
+	}
+
+	filter := &netlink.BpfFilter{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    p.tcParent(),
+			Priority:  p.Priority,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		Fd:           prog.fd,
+		Name:         p.filterName(),
+		DirectAction: true,
+	}
+	if err := netlink.FilterAdd(filter); err != nil {
+		return fmt.Errorf("failed to attach %s to %s of %q: %v", p.Pin, p.Hook, link.Attrs().Name, err)
+	}
+	return nil
+}
+
+// attachXDP sets p as the XDP program of link, which must not have another
+// one already.
+func attachXDP(link netlink.Link, p *Program, prog *pinnedProgram) error {
+	if xdp := link.Attrs().Xdp; xdp != nil && xdp.Attached && xdp.ProgId == prog.id {
+		return nil
+	}
+	flags := xdpModes[p.XDPMode] | unix.XDP_FLAGS_UPDATE_IF_NOEXIST
+	if err := netlink.LinkSetXdpFdWithFlags(link, prog.fd, flags); err != nil {
+		if errors.Is(err, unix.EBUSY) || errors.Is(err, unix.EEXIST) {
+			return fmt.Errorf("failed to attach %s to %q: another XDP program is attached", p.Pin, link.Attrs().Name)
+		}
+		return fmt.Errorf("failed to attach %s to XDP of %q: %v", p.Pin, link.Attrs().Name, err)
+	}
+	return nil
+}
+
+// attachPrograms attaches progs, replacing the filters of previous versions
+// of their TC programs.
+func attachPrograms(progs []Program, defaultLink func() (netlink.Link, error)) error {
+	for _, p := range progs {
+		prog, err := openProgram(p.Pin)
+		if err != nil {
+			return err
+		}
+		link, err := p.link(defaultLink)
+		if err == nil {
+			if p.Hook == hookXDP {
+				err = attachXDP(link, &p, prog)
+			} else {
+				err = attachTC(link, &p, prog)
+			}
+		}
+		prog.close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPrograms verifies that progs are attached.
+func checkPrograms(progs []Program, defaultLink func() (netlink.Link, error)) error {
+	for _, p := range progs {
+		prog, err := openProgram(p.Pin)
+		if err != nil {
+			return err
+		}
+		prog.close()
+		link, err := p.link(defaultLink)
+		if err != nil {
+			return err
+		}
+
+		if p.Hook == hookXDP {
+			if xdp := link.Attrs().Xdp; xdp == nil || !xdp.Attached || xdp.ProgId != prog.id {
+				return fmt.Errorf("program %s not attached to XDP of %q", p.Pin, link.Attrs().Name)
+			}
+			continue
+		}
+		filters, err := tcFilters(link, &p)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, f := range filters {
+			found = found || f.Id == int(prog.id)
+		}
+		if !found {
+			return fmt.Errorf("program %s not attached to %s of %q", p.Pin, p.Hook, link.Attrs().Name)
+		}
+	}
+	return nil
+}
+
+// detachPrograms detaches progs. The programs of an interface already gone
+// went with it, and an XDP program whose pin is gone is left alone as it
+// cannot be told apart from another one.
+func detachPrograms(progs []Program, defaultLink func() (netlink.Link, error)) error {
+	for _, p := range progs {
+		link, err := p.link(defaultLink)
+		if err != nil {
+			if cnierrors.Code(err) == cnierrors.ErrInterfaceNotFound {
+				continue
+			}
+			return err
+		}
+
+		if p.Hook != hookXDP {
+			filters, err := tcFilters(link, &p)
+			if err != nil {
+				return err
+			}
+			for _, f := range filters {
+				if err := netlink.FilterDel(f); err != nil && !errors.Is(err, unix.ENOENT) {
+					return fmt.Errorf("failed to detach %s from %q: %v", p.Pin, link.Attrs().Name, err)
+				}
+			}
+			continue
+		}
+
+		xdp := link.Attrs().Xdp
+		if xdp == nil || !xdp.Attached {
+			continue
+		}
+		prog, err := openPinnedProgram(p.Pin)
+		if err != nil {
+			continue
+		}
+		prog.close()
+		if xdp.ProgId != prog.id {
+			continue
+		}
+		if err := netlink.LinkSetXdpFdWithFlags(link, -1, xdpDetachFlags(xdp.AttachMode)); err != nil {
+			return fmt.Errorf("failed to detach %s from XDP of %q: %v", p.Pin, link.Attrs().Name, err)
+		}
+	}
+	return nil
+}
+
+// xdpDetachFlags returns the flags detaching a program in attachMode, one of
+// the XDP_ATTACHED_* values.
+func xdpDetachFlags(attachMode uint32) int {
+	switch attachMode {
+	case 2: // XDP_ATTACHED_SKB
+		return unix.XDP_FLAGS_SKB_MODE
+	case 3: // XDP_ATTACHED_HW
+		return unix.XDP_FLAGS_HW_MODE
+	default:
+		return unix.XDP_FLAGS_DRV_MODE
+	}
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return attachPrograms(conf.programs(sideContainer), containerLink(args.IfName))
+	})
+	if err != nil {
+		return err
+	}
+	if err := attachPrograms(conf.programs(sideHost), hostLink(netns, args.IfName)); err != nil {
+		return err
+	}
+
+	return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	// The host programs on a named interface outlive the container, the
+	// others go with its netns
+	hostProgs := conf.programs(sideHost)
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		if _, ok := err.(ns.NSPathNotExistErr); !ok {
+			return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+		}
+		named := []Program{}
+		for _, p := range hostProgs {
+			if p.Dev != "" {
+				named = append(named, p)
+			}
+		}
+		return detachPrograms(named, nil)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return detachPrograms(conf.programs(sideContainer), containerLink(args.IfName))
+	})
+	if err != nil {
+		return err
+	}
+	return detachPrograms(hostProgs, hostLink(netns, args.IfName))
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return checkPrograms(conf.programs(sideContainer), containerLink(args.IfName))
+	})
+	if err != nil {
+		return err
+	}
+	return checkPrograms(conf.programs(sideHost), hostLink(netns, args.IfName))
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Check: cmdCheck,
+		Del:   cmdDel,
+	}, version.All, bv.BuildString("ebpf-attach"))
+}
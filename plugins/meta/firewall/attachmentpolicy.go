@@ -0,0 +1,369 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/utils"
+)
+
+// policyDirection is the direction of the connections an attachmentPolicy
+// filters: to the attachment for ingress, and from it for egress.
+type policyDirection string
+
+const (
+	policyIngress policyDirection = "ingress"
+	policyEgress  policyDirection = "egress"
+)
+
+// policyRule matches new connections from (ingress) or to (egress) peer,
+// to port; a nil peer or port matches anything.
+type policyRule struct {
+	peer    *net.IPNet
+	port    *PolicyPort
+	verdict string // "return" or "drop"
+}
+
+// attachmentPolicy is a list of rules applied to the new connections of a
+// single attachment, in order. Allowed connections return rather than
+// being accepted outright, so that a connection between two attachments
+// goes through the egress policy of one and the ingress policy of the
+// other; they, and connections not matched by any rule, are left to the
+// rest of the firewall.
+type attachmentPolicy struct {
+	direction policyDirection
+	rules     []policyRule
+}
+
+// allowRules returns rules letting through connections with any of peers
+// and any of ports; an empty list matches anything.
+func allowRules(peers []*net.IPNet, ports []PolicyPort) []policyRule {
+	if len(peers) == 0 {
+		peers = []*net.IPNet{nil}
+	}
+	rules := []policyRule{}
+	for _, peer := range peers {
+		if len(ports) == 0 {
+			rules = append(rules, policyRule{peer: peer, verdict: "return"})
+			continue
+		}
+		for i := range ports {
+			rules = append(rules, policyRule{peer: peer, port: &ports[i], verdict: "return"})
+		}
+	}
+	return rules
+}
+
+func parsePolicyCIDRs(what string, cidrs []string) ([]*net.IPNet, error) {
+	ipns := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, ipn, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", what, cidr, err)
+		}
+		ipns = append(ipns, ipn)
+	}
+	return ipns, nil
+}
+
+// parsePolicyPorts validates ports, defaulting their protocol to tcp.
+func parsePolicyPorts(field string, ports []PolicyPort) error {
+	for i := range ports {
+		port := &ports[i]
+		port.Protocol = strings.ToLower(port.Protocol)
+		switch port.Protocol {
+		case "":
+			port.Protocol = "tcp"
+		case "tcp", "udp", "sctp":
+		default:
+			return fmt.Errorf("invalid %s allowed port protocol %q", field, port.Protocol)
+		}
+		if port.Port <= 0 || port.Port > 65535 {
+			return fmt.Errorf("invalid %s allowed port %d", field, port.Port)
+		}
+	}
+	return nil
+}
+
+// attachmentEnforcer is implemented by the backends that enforce attachment
+// policies and conntrack zones themselves.
+type attachmentEnforcer interface {
+	setupAttachmentPolicy(*FirewallNetConf, *types100.Result, *attachmentPolicy) error
+	teardownAttachmentPolicy(*FirewallNetConf, policyDirection) error
+	checkAttachmentPolicy(*FirewallNetConf, *types100.Result, *attachmentPolicy) error
+
+	setupConntrackZone(*FirewallNetConf, *types100.Result) error
+	teardownConntrackZone(*FirewallNetConf) error
+	checkConntrackZone(*FirewallNetConf, *types100.Result) error
+}
+
+// enforcerFor returns backend if it enforces attachment policies and
+// conntrack zones itself, and falls back to iptables otherwise.
+func enforcerFor(conf *FirewallNetConf, backend FirewallBackend) (attachmentEnforcer, error) {
+	if enforcer, ok := backend.(attachmentEnforcer); ok {
+		return enforcer, nil
+	}
+	ib, err := newIptablesBackend(conf)
+	if err != nil {
+		return nil, err
+	}
+	return ib.(*iptablesBackend), nil
+}
+
+// attachmentPolicies returns the attachment policies configured in conf.
+func attachmentPolicies(conf *FirewallNetConf) []*attachmentPolicy {
+	policies := []*attachmentPolicy{}
+	if conf.IngressPolicy == IngressPolicyAllowlist {
+		policies = append(policies, conf.IngressAllowlist.policy())
+	}
+	if conf.EgressPolicy != nil {
+		policies = append(policies, conf.EgressPolicy.policy())
+	}
+	return policies
+}
+
+func setupAttachmentPolicy(conf *FirewallNetConf, result *types100.Result, backend FirewallBackend, policy *attachmentPolicy) error {
+	enforcer, err := enforcerFor(conf, backend)
+	if err != nil {
+		return err
+	}
+	return enforcer.setupAttachmentPolicy(conf, result, policy)
+}
+
+func teardownAttachmentPolicy(conf *FirewallNetConf, backend FirewallBackend, direction policyDirection) error {
+	enforcer, err := enforcerFor(conf, backend)
+	if err != nil {
+		return err
+	}
+	return enforcer.teardownAttachmentPolicy(conf, direction)
+}
+
+func checkAttachmentPolicy(conf *FirewallNetConf, result *types100.Result, backend FirewallBackend, policy *attachmentPolicy) error {
+	enforcer, err := enforcerFor(conf, backend)
+	if err != nil {
+		return err
+	}
+	return enforcer.checkAttachmentPolicy(conf, result, policy)
+}
+
+// With iptables, attachment policies are enforced with a chain per
+// attachment and direction, jumped to for new connections to (ingress) or
+// from (egress) the attachment's addresses:
+// ```
+// iptables -I FORWARD -j CNI-INGRESS
+// iptables -A CNI-INGRESS -d ${containerIP} -m conntrack --ctstate NEW -j CNI-IN-xxx
+// iptables -A CNI-IN-xxx [-s ${source}] [-p ${protocol} --dport ${port}] -j RETURN
+// iptables -A CNI-IN-xxx -j DROP
+// iptables -I FORWARD -j CNI-EGRESS
+// iptables -A CNI-EGRESS -s ${containerIP} -m conntrack --ctstate NEW -j CNI-EG-xxx
+// iptables -A CNI-EG-xxx [-d ${destination}] [-p ${protocol} --dport ${port}] -j RETURN|DROP
+// ```
+
+func (d policyDirection) iptablesChain() string {
+	if d == policyEgress {
+		return "CNI-EGRESS"
+	}
+	return "CNI-INGRESS"
+}
+
+func (d policyDirection) iptablesAttachmentChain(conf *FirewallNetConf) string {
+	prefix := "IN-"
+	if d == policyEgress {
+		prefix = "EG-"
+	}
+	return utils.MustFormatChainNameWithPrefix(conf.Name, attachmentComment(conf), prefix)
+}
+
+// iptablesFlags returns the flags matching the attachment's address and
+// the peer's.
+func (d policyDirection) iptablesFlags() (string, string) {
+	if d == policyEgress {
+		return "-s", "-d"
+	}
+	return "-d", "-s"
+}
+
+func (d policyDirection) iptablesComment() string {
+	if d == policyEgress {
+		return "CNI firewall plugin rules (egressPolicy)"
+	}
+	return fmt.Sprintf("CNI firewall plugin rules (ingressPolicy: %s)", IngressPolicyAllowlist)
+}
+
+func policyJumpRules(result *types100.Result, proto iptables.Protocol, direction policyDirection, chain string) [][]string {
+	flag, _ := direction.iptablesFlags()
+	rules := [][]string{}
+	for _, ip := range result.IPs {
+		if protoForIP(ip.Address) == proto {
+			rules = append(rules, []string{flag, ipString(ip.Address), "-m", "conntrack", "--ctstate", "NEW", "-j", chain})
+		}
+	}
+	return rules
+}
+
+// iptablesPolicyRules returns the rules of the policy for one IP family,
+// skipping those with a peer of the other family.
+func iptablesPolicyRules(policy *attachmentPolicy, isV6 bool) [][]string {
+	_, peerFlag := policy.direction.iptablesFlags()
+	rules := [][]string{}
+	for _, r := range policy.rules {
+		rule := []string{}
+		if r.peer != nil {
+			if (r.peer.IP.To4() == nil) != isV6 {
+				continue
+			}
+			rule = append(rule, peerFlag, r.peer.String())
+		}
+		if r.port != nil {
+			rule = append(rule, "-p", r.port.Protocol, "--dport", strconv.Itoa(r.port.Port))
+		}
+		rules = append(rules, append(rule, "-j", strings.ToUpper(r.verdict)))
+	}
+	return rules
+}
+
+func (ib *iptablesBackend) setupAttachmentPolicy(conf *FirewallNetConf, result *types100.Result, policy *attachmentPolicy) error {
+	for proto, ipt := range ib.protos {
+		payload := utils.RestorePayload{}
+		if err := ib.restoreAttachmentPolicy(ipt, proto, conf, result, policy, &payload); err != nil {
+			return err
+		}
+		if err := utils.IPTablesRestore(ipt, &payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreAttachmentPolicy makes sure the hook chain of the policy's
+// direction exists, and adds the commands (re)creating the attachment's
+// chain and jumping to it to payload.
+func (ib *iptablesBackend) restoreAttachmentPolicy(ipt *iptables.IPTables, proto iptables.Protocol, conf *FirewallNetConf, result *types100.Result, policy *attachmentPolicy, payload *utils.RestorePayload) error {
+	hookChain := policy.direction.iptablesChain()
+	chain := policy.direction.iptablesAttachmentChain(conf)
+	jumps := policyJumpRules(result, proto, policy.direction, chain)
+	if len(jumps) == 0 {
+		return nil
+	}
+
+	if err := utils.EnsureChain(ipt, filterTableName, hookChain); err != nil {
+		return err
+	}
+	jumpToHook := withComment([]string{"-j", hookChain}, policy.direction.iptablesComment())
+	if err := utils.InsertUnique(ipt, filterTableName, forwardChainName, true, jumpToHook); err != nil {
+		return err
+	}
+
+	// Declaring the chain creates it, or flushes it if it exists
+	payload.Add(filterTableName, fmt.Sprintf(":%s - [0:0]", chain))
+	for _, rule := range iptablesPolicyRules(policy, proto == iptables.ProtocolIPv6) {
+		payload.Add(filterTableName, utils.RestoreRule("-A", chain, rule))
+	}
+	for _, jump := range jumps {
+		exists, err := ipt.Exists(filterTableName, hookChain, jump...)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			payload.Add(filterTableName, utils.RestoreRule("-A", hookChain, jump))
+		}
+	}
+	return nil
+}
+
+// teardownAttachmentPolicy removes the attachment's chain and the rules
+// jumping to it. It does not need prevResult.
+func (ib *iptablesBackend) teardownAttachmentPolicy(conf *FirewallNetConf, direction policyDirection) error {
+	hookChain := direction.iptablesChain()
+	chain := direction.iptablesAttachmentChain(conf)
+	for _, ipt := range ib.protos {
+		if err := deleteAttachmentChain(ipt, filterTableName, hookChain, chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteAttachmentChain deletes chain and the rules of hookChain jumping to
+// it, if any.
+func deleteAttachmentChain(ipt *iptables.IPTables, table, hookChain, chain string) error {
+	exists, err := ipt.ChainExists(table, hookChain)
+	if err != nil {
+		return err
+	}
+	if exists {
+		rules, err := ipt.List(table, hookChain)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			// e.g. "-A CNI-INGRESS -d 10.0.0.2/32 ... -j CNI-IN-xxx"
+			fields := strings.Fields(rule)
+			if len(fields) < 2 || fields[len(fields)-1] != chain {
+				continue
+			}
+			if err := utils.DeleteRule(ipt, table, hookChain, fields[2:]...); err != nil {
+				return err
+			}
+		}
+	}
+	return utils.DeleteChain(ipt, table, chain)
+}
+
+func (ib *iptablesBackend) checkAttachmentPolicy(conf *FirewallNetConf, result *types100.Result, policy *attachmentPolicy) error {
+	hookChain := policy.direction.iptablesChain()
+	chain := policy.direction.iptablesAttachmentChain(conf)
+	for proto, ipt := range ib.protos {
+		jumps := policyJumpRules(result, proto, policy.direction, chain)
+		if len(jumps) == 0 {
+			continue
+		}
+
+		exists, err := ipt.ChainExists(filterTableName, chain)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("expected chain %s not found", chain)
+		}
+
+		for _, rule := range iptablesPolicyRules(policy, proto == iptables.ProtocolIPv6) {
+			exists, err := ipt.Exists(filterTableName, chain, rule...)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("expected %v rule %v not found", chain, rule)
+			}
+		}
+		for _, jump := range jumps {
+			exists, err := ipt.Exists(filterTableName, hookChain, jump...)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("expected %v rule %v not found", hookChain, jump)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/gc"
+)
+
+// The result of each attachment is recorded on ADD, in a file per
+// attachment under <dataDir>/<network name>, so that GC can tear down the
+// rules of attachments whose DEL was lost. The iptables and firewalld
+// backends cannot do this from their rules alone, as those only hold the
+// attachment's addresses.
+var defaultDataDir = "/var/lib/cni/firewall"
+
+type attachmentRecord struct {
+	ContainerID string          `json:"containerID"`
+	IfName      string          `json:"ifName"`
+	Result      *current.Result `json:"result"`
+}
+
+func attachmentsDir(conf *FirewallNetConf) string {
+	dataDir := conf.DataDir
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+	return filepath.Join(dataDir, conf.Name)
+}
+
+func attachmentPath(conf *FirewallNetConf) string {
+	return filepath.Join(attachmentsDir(conf), conf.ContainerID+"-"+conf.IfName)
+}
+
+func saveAttachment(conf *FirewallNetConf, result *current.Result) error {
+	data, err := json.Marshal(&attachmentRecord{
+		ContainerID: conf.ContainerID,
+		IfName:      conf.IfName,
+		Result:      result,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(attachmentsDir(conf), 0o755); err != nil {
+		return fmt.Errorf("failed to create firewall data directory: %v", err)
+	}
+	// Write then rename, so that a crash never leaves a torn record
+	path := attachmentPath(conf)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("failed to record attachment: %v", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func removeAttachment(conf *FirewallNetConf) error {
+	if err := os.Remove(attachmentPath(conf)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove attachment record: %v", err)
+	}
+	return nil
+}
+
+// listAttachments returns the attachments recorded for the network. Records
+// that cannot be read are skipped.
+func listAttachments(conf *FirewallNetConf) ([]*attachmentRecord, error) {
+	entries, err := os.ReadDir(attachmentsDir(conf))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	records := []*attachmentRecord{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(attachmentsDir(conf), entry.Name()))
+		if err != nil {
+			continue
+		}
+		record := &attachmentRecord{}
+		if err := json.Unmarshal(data, record); err != nil || record.Result == nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// gcAttachments tears down the recorded attachments that are not in
+// conf.ValidAttachments.
+func gcAttachments(conf *FirewallNetConf, backend FirewallBackend) error {
+	valid := gc.NewAttachments(conf.ValidAttachments)
+
+	records, err := listAttachments(conf)
+	if err != nil {
+		return fmt.Errorf("failed to list attachments: %v", err)
+	}
+	for _, record := range records {
+		if valid.Valid(record.ContainerID, record.IfName) {
+			continue
+		}
+		conf.ContainerID = record.ContainerID
+		conf.IfName = record.IfName
+		if err := teardownAttachment(conf, backend, record.Result); err != nil {
+			return fmt.Errorf("failed to tear down attachment %s: %v", attachmentComment(conf), err)
+		}
+		if err := removeAttachment(conf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/coreos/go-iptables/iptables"
+
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/utils"
+)
+
+// Conntrack zones are only set for the original direction of connections
+// ("ct original zone set" / "-j CT --zone-orig"), on packets from the
+// attachment's addresses arriving on its host-side interfaces. Replies,
+// which are typically masqueraded and arrive on the uplink, are looked up
+// in the default zone, so that the zone does not need to be known for
+// them.
+const rawTableName = "raw"
+
+// conntrackZoneID returns the zone of the attachment in conf, derived from
+// its container ID and interface name. Zone 0 is the default zone and is
+// never used.
+func conntrackZoneID(conf *FirewallNetConf) uint16 {
+	h := fnv.New32a()
+	h.Write([]byte(attachmentComment(conf)))
+	return uint16(h.Sum32()%0xffff) + 1
+}
+
+// hostInterfaces returns the names of the host-side interfaces in result,
+// such as a bridge and the host end of a veth pair.
+func hostInterfaces(result *types100.Result) []string {
+	names := []string{}
+	for _, iface := range result.Interfaces {
+		if iface.Sandbox == "" && iface.Name != "" {
+			names = append(names, iface.Name)
+		}
+	}
+	return names
+}
+
+func setupConntrackZone(conf *FirewallNetConf, prevResult *types100.Result, backend FirewallBackend) error {
+	if !conf.ConntrackZone {
+		return nil
+	}
+	enforcer, err := enforcerFor(conf, backend)
+	if err != nil {
+		return err
+	}
+	return enforcer.setupConntrackZone(conf, prevResult)
+}
+
+func teardownConntrackZone(conf *FirewallNetConf, backend FirewallBackend) error {
+	if !conf.ConntrackZone {
+		return nil
+	}
+	enforcer, err := enforcerFor(conf, backend)
+	if err != nil {
+		return err
+	}
+	return enforcer.teardownConntrackZone(conf)
+}
+
+func checkConntrackZone(conf *FirewallNetConf, prevResult *types100.Result, backend FirewallBackend) error {
+	if !conf.ConntrackZone {
+		return nil
+	}
+	enforcer, err := enforcerFor(conf, backend)
+	if err != nil {
+		return err
+	}
+	return enforcer.checkConntrackZone(conf, prevResult)
+}
+
+// With iptables, each attachment gets a chain in the raw table:
+// ```
+// iptables -t raw -I PREROUTING -j CNI-CT-ZONES
+// iptables -t raw -A CNI-CT-ZONES -j CNI-CTZ-xxx
+// iptables -t raw -A CNI-CTZ-xxx -i ${hostIf} -s ${containerIP} -j CT --zone-orig ${zone}
+// ```
+const conntrackZonesChainName = "CNI-CT-ZONES"
+
+func conntrackZoneChain(conf *FirewallNetConf) string {
+	return utils.MustFormatChainNameWithPrefix(conf.Name, attachmentComment(conf), "CTZ-")
+}
+
+func iptablesConntrackZoneRules(conf *FirewallNetConf, result *types100.Result, proto iptables.Protocol) [][]string {
+	zone := strconv.Itoa(int(conntrackZoneID(conf)))
+	ifaces := hostInterfaces(result)
+	rules := [][]string{}
+	for _, ip := range result.IPs {
+		if protoForIP(ip.Address) != proto {
+			continue
+		}
+		if len(ifaces) == 0 {
+			rules = append(rules, []string{"-s", ipString(ip.Address), "-j", "CT", "--zone-orig", zone})
+			continue
+		}
+		for _, iface := range ifaces {
+			rules = append(rules, []string{"-i", iface, "-s", ipString(ip.Address), "-j", "CT", "--zone-orig", zone})
+		}
+	}
+	return rules
+}
+
+func (ib *iptablesBackend) setupConntrackZone(conf *FirewallNetConf, result *types100.Result) error {
+	for proto, ipt := range ib.protos {
+		payload := utils.RestorePayload{}
+		if err := ib.restoreConntrackZone(ipt, proto, conf, result, &payload); err != nil {
+			return err
+		}
+		if err := utils.IPTablesRestore(ipt, &payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreConntrackZone makes sure the CNI-CT-ZONES chain exists, and adds
+// the commands (re)creating the attachment's chain and jumping to it to
+// payload.
+func (ib *iptablesBackend) restoreConntrackZone(ipt *iptables.IPTables, proto iptables.Protocol, conf *FirewallNetConf, result *types100.Result, payload *utils.RestorePayload) error {
+	chain := conntrackZoneChain(conf)
+	rules := iptablesConntrackZoneRules(conf, result, proto)
+	if len(rules) == 0 {
+		return nil
+	}
+
+	if err := utils.EnsureChain(ipt, rawTableName, conntrackZonesChainName); err != nil {
+		return err
+	}
+	jumpToZones := withComment([]string{"-j", conntrackZonesChainName}, "CNI firewall plugin conntrack zones")
+	if err := utils.InsertUnique(ipt, rawTableName, "PREROUTING", true, jumpToZones); err != nil {
+		return err
+	}
+
+	payload.Add(rawTableName, fmt.Sprintf(":%s - [0:0]", chain))
+	for _, rule := range rules {
+		payload.Add(rawTableName, utils.RestoreRule("-A", chain, rule))
+	}
+	exists, err := ipt.Exists(rawTableName, conntrackZonesChainName, "-j", chain)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		payload.Add(rawTableName, utils.RestoreRule("-A", conntrackZonesChainName, []string{"-j", chain}))
+	}
+	return nil
+}
+
+func (ib *iptablesBackend) teardownConntrackZone(conf *FirewallNetConf) error {
+	chain := conntrackZoneChain(conf)
+	for _, ipt := range ib.protos {
+		if err := deleteAttachmentChain(ipt, rawTableName, conntrackZonesChainName, chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ib *iptablesBackend) checkConntrackZone(conf *FirewallNetConf, result *types100.Result) error {
+	chain := conntrackZoneChain(conf)
+	for proto, ipt := range ib.protos {
+		rules := iptablesConntrackZoneRules(conf, result, proto)
+		if len(rules) == 0 {
+			continue
+		}
+
+		exists, err := ipt.Exists(rawTableName, conntrackZonesChainName, "-j", chain)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("expected %v rule %v not found", conntrackZonesChainName, []string{"-j", chain})
+		}
+		for _, rule := range rules {
+			exists, err := ipt.Exists(rawTableName, chain, rule...)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("expected %v rule %v not found", chain, rule)
+			}
+		}
+	}
+	return nil
+}
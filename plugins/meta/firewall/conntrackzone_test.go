@@ -0,0 +1,96 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/coreos/go-iptables/iptables"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/knftables"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+var _ = Describe("firewall conntrack zones", func() {
+	var result *current.Result
+
+	BeforeEach(func() {
+		result = &current.Result{
+			Interfaces: []*current.Interface{
+				{Name: "cni0"},
+				{Name: "veth1"},
+				{Name: "eth0", Sandbox: "/var/run/netns/test"},
+			},
+		}
+		for _, addr := range []string{"10.0.0.2/24", "2001:db8::2/64"} {
+			ipn, err := types.ParseCIDR(addr)
+			Expect(err).NotTo(HaveOccurred())
+			result.IPs = append(result.IPs, &current.IPConfig{Address: *ipn})
+		}
+	})
+
+	attachment := func(containerID string) *FirewallNetConf {
+		return &FirewallNetConf{ContainerID: containerID, IfName: "eth0", ConntrackZone: true}
+	}
+
+	It("gives each attachment its own non-default zone", func() {
+		zone := conntrackZoneID(attachment("c1"))
+		Expect(zone).NotTo(BeZero())
+		Expect(conntrackZoneID(attachment("c1"))).To(Equal(zone))
+		Expect(conntrackZoneID(attachment("c2"))).NotTo(Equal(zone))
+	})
+
+	It("generates iptables rules for the host interfaces", func() {
+		conf := attachment("c1")
+		zone := fmt.Sprint(conntrackZoneID(conf))
+		Expect(iptablesConntrackZoneRules(conf, result, iptables.ProtocolIPv4)).To(Equal([][]string{
+			{"-i", "cni0", "-s", "10.0.0.2/32", "-j", "CT", "--zone-orig", zone},
+			{"-i", "veth1", "-s", "10.0.0.2/32", "-j", "CT", "--zone-orig", zone},
+		}))
+
+		result.Interfaces = result.Interfaces[2:]
+		Expect(iptablesConntrackZoneRules(conf, result, iptables.ProtocolIPv6)).To(Equal([][]string{
+			{"-s", "2001:db8::2/128", "-j", "CT", "--zone-orig", zone},
+		}))
+	})
+
+	It("adds, checks and deletes nftables rules", func() {
+		fake := knftables.NewFake(knftables.InetFamily, nftablesTableName)
+		backend := &nftablesBackend{nft: fake}
+		conf := attachment("c1")
+		zone := conntrackZoneID(conf)
+
+		Expect(backend.setupConntrackZone(conf, result)).To(Succeed())
+		Expect(backend.setupConntrackZone(attachment("c2"), result)).To(Succeed())
+		// Setting up again replaces the rules
+		Expect(backend.setupConntrackZone(conf, result)).To(Succeed())
+
+		dump := fake.Dump()
+		Expect(dump).To(ContainSubstring("add chain inet cni_firewall conntrack_zones { type filter hook prerouting priority -300 ; }"))
+		Expect(dump).To(ContainSubstring(fmt.Sprintf(`add rule inet cni_firewall conntrack_zones iifname { "cni0", "veth1" } ip saddr 10.0.0.2 ct original zone set %d comment "c1-eth0"`, zone)))
+		Expect(dump).To(ContainSubstring(fmt.Sprintf(`add rule inet cni_firewall conntrack_zones iifname { "cni0", "veth1" } ip6 saddr 2001:db8::2 ct original zone set %d comment "c1-eth0"`, zone)))
+
+		Expect(backend.checkConntrackZone(conf, result)).To(Succeed())
+		Expect(backend.checkConntrackZone(attachment("c2"), result)).To(Succeed())
+
+		Expect(backend.teardownConntrackZone(conf)).To(Succeed())
+		Expect(backend.checkConntrackZone(conf, result)).To(MatchError("expected 2 conntrack zone rules for c1-eth0, found 0"))
+		Expect(backend.checkConntrackZone(attachment("c2"), result)).To(Succeed())
+		Expect(backend.teardownConntrackZone(conf)).To(Succeed())
+	})
+})
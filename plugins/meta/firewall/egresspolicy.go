@@ -0,0 +1,76 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+)
+
+func parseEgressPolicy(conf *FirewallNetConf) error {
+	ep := conf.EgressPolicy
+	if ep == nil {
+		return nil
+	}
+
+	var err error
+	if ep.allowed, err = parsePolicyCIDRs("egressPolicy allowed destination", ep.AllowedDestinations); err != nil {
+		return err
+	}
+	if ep.denied, err = parsePolicyCIDRs("egressPolicy denied destination", ep.DeniedDestinations); err != nil {
+		return err
+	}
+	return parsePolicyPorts("egressPolicy", ep.AllowedPorts)
+}
+
+// policy returns the rules enforcing the egress policy: the allowed
+// destinations and ports first, then the denied destinations, then the
+// default.
+func (ep *EgressPolicy) policy() *attachmentPolicy {
+	rules := []policyRule{}
+	if len(ep.allowed) > 0 || len(ep.AllowedPorts) > 0 {
+		rules = append(rules, allowRules(ep.allowed, ep.AllowedPorts)...)
+	}
+	for _, denied := range ep.denied {
+		rules = append(rules, policyRule{peer: denied, verdict: "drop"})
+	}
+	if ep.DefaultDeny {
+		rules = append(rules, policyRule{verdict: "drop"})
+	}
+	return &attachmentPolicy{
+		direction: policyEgress,
+		rules:     rules,
+	}
+}
+
+func setupEgressPolicy(conf *FirewallNetConf, prevResult *types100.Result, backend FirewallBackend) error {
+	if conf.EgressPolicy == nil {
+		return nil
+	}
+	return setupAttachmentPolicy(conf, prevResult, backend, conf.EgressPolicy.policy())
+}
+
+func teardownEgressPolicy(conf *FirewallNetConf, backend FirewallBackend) error {
+	if conf.EgressPolicy == nil {
+		return nil
+	}
+	return teardownAttachmentPolicy(conf, backend, policyEgress)
+}
+
+func checkEgressPolicy(conf *FirewallNetConf, prevResult *types100.Result, backend FirewallBackend) error {
+	if conf.EgressPolicy == nil {
+		return nil
+	}
+	return checkAttachmentPolicy(conf, prevResult, backend, conf.EgressPolicy.policy())
+}
@@ -26,6 +26,11 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/log"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/trace"
+	"github.com/containernetworking/plugins/pkg/utils"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
 
@@ -34,7 +39,7 @@ type FirewallNetConf struct {
 	types.NetConf
 
 	// Backend is the firewall type to add rules to.  Allowed values are
-	// 'iptables' and 'firewalld'.
+	// 'iptables', 'firewalld' and 'nftables'.
 	Backend string `json:"backend"`
 
 	// IptablesAdminChainName is an optional name to use instead of the default
@@ -46,9 +51,38 @@ type FirewallNetConf struct {
 	// to 'trusted'
 	FirewalldZone string `json:"firewalldZone,omitempty"`
 
+	// FirewalldPolicy is an optional firewalld policy object to add the
+	// zone to as an egress zone, so that the policy applies to traffic to
+	// the network's containers. firewalld cannot create policies at
+	// runtime, so the policy must exist in its permanent configuration.
+	FirewalldPolicy string `json:"firewalldPolicy,omitempty"`
+
 	// IngressPolicy is an optional ingress policy.
-	// Defaults to "open".
-	IngressPolicy IngressPolicy `json:"ingressPolicy,omitempty"`
+	// Defaults to "open". It is parsed from RawIngressPolicy, which may
+	// also be an IngressAllowlist object.
+	IngressPolicy IngressPolicy `json:"-"`
+	// IngressAllowlist is set if the ingress policy is "allowlist".
+	IngressAllowlist *IngressAllowlist `json:"-"`
+
+	RawIngressPolicy json.RawMessage `json:"ingressPolicy,omitempty"`
+
+	// EgressPolicy optionally restricts the connections made by the
+	// container.
+	EgressPolicy *EgressPolicy `json:"egressPolicy,omitempty"`
+
+	// ConntrackZone gives each attachment a conntrack zone of its own, so
+	// that connections from attachments with overlapping addresses do not
+	// collide in the host's conntrack table.
+	ConntrackZone bool `json:"conntrackZone,omitempty"`
+
+	// DataDir is where the attachments are recorded for GC. Defaults to
+	// /var/lib/cni/firewall.
+	DataDir string `json:"dataDir,omitempty"`
+
+	// These are fields parsed out of the environment; included here for
+	// convenience
+	ContainerID string `json:"-"`
+	IfName      string `json:"-"`
 }
 
 // IngressPolicy is an ingress policy string.
@@ -71,14 +105,64 @@ const (
 	// IngressPolicyIsolated executes `iptables` regardless to the value of `Backend`.
 	// IngressPolicyIsolated may not work as expected for non-bridge networks.
 	IngressPolicyIsolated IngressPolicy = "isolated"
+
+	// IngressPolicyAllowlist ("allowlist"): new connections to the container are dropped
+	// unless they match the IngressAllowlist. It is selected by giving ingressPolicy as an
+	// IngressAllowlist object rather than a string.
+	// Unlike the other policies it is enforced per attachment, with the nftables
+	// backend if that is in use and with `iptables` otherwise.
+	IngressPolicyAllowlist IngressPolicy = "allowlist"
 )
 
+// IngressAllowlist is the object form of ingressPolicy. A new connection to
+// the container is dropped unless it comes from one of AllowedSources and
+// goes to one of AllowedPorts; an empty list matches any source, or any
+// port.
+// Established connections and traffic from the container are not affected.
+type IngressAllowlist struct {
+	AllowedSources []string     `json:"allowedSources,omitempty"`
+	AllowedPorts   []PolicyPort `json:"allowedPorts,omitempty"`
+
+	sources []*net.IPNet
+}
+
+// EgressPolicy restricts the new connections made by the container. A
+// connection to one of AllowedDestinations and AllowedPorts is allowed;
+// as for IngressAllowlist, an empty list matches anything, but only if the
+// other one is set. Otherwise connections to DeniedDestinations are
+// dropped, as is everything else if DefaultDeny is set.
+// Only forwarded traffic is filtered, not connections to the host itself.
+type EgressPolicy struct {
+	AllowedDestinations []string     `json:"allowedDestinations,omitempty"`
+	AllowedPorts        []PolicyPort `json:"allowedPorts,omitempty"`
+	DeniedDestinations  []string     `json:"deniedDestinations,omitempty"`
+	DefaultDeny         bool         `json:"defaultDeny,omitempty"`
+
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+}
+
+// PolicyPort is a destination port allowed by an IngressAllowlist or an
+// EgressPolicy.
+type PolicyPort struct {
+	Port int `json:"port"`
+	// Protocol is "tcp", "udp" or "sctp". Defaults to "tcp".
+	Protocol string `json:"protocol,omitempty"`
+}
+
 type FirewallBackend interface {
 	Add(*FirewallNetConf, *current.Result) error
 	Del(*FirewallNetConf, *current.Result) error
 	Check(*FirewallNetConf, *current.Result) error
 }
 
+// atomicBackend is implemented by the backends that can apply all the
+// rules of an attachment, including its attachment policies and conntrack
+// zone, at once rather than one by one.
+type atomicBackend interface {
+	setupAttachment(*FirewallNetConf, *current.Result) error
+}
+
 func ipString(ip net.IPNet) string {
 	if ip.IP.To4() == nil {
 		return ip.IP.String() + "/128"
@@ -87,11 +171,20 @@ func ipString(ip net.IPNet) string {
 }
 
 func parseConf(data []byte) (*FirewallNetConf, *current.Result, error) {
+	defer trace.Start("parseConfig").End()
+
 	conf := FirewallNetConf{}
 	if err := json.Unmarshal(data, &conf); err != nil {
 		return nil, nil, fmt.Errorf("failed to load netconf: %v", err)
 	}
 
+	if err := parseIngressPolicy(&conf); err != nil {
+		return nil, nil, err
+	}
+	if err := parseEgressPolicy(&conf); err != nil {
+		return nil, nil, err
+	}
+
 	// Default the firewalld zone to trusted
 	if conf.FirewalldZone == "" {
 		conf.FirewalldZone = "trusted"
@@ -124,6 +217,8 @@ func getBackend(conf *FirewallNetConf) (FirewallBackend, error) {
 		return newIptablesBackend(conf)
 	case "firewalld":
 		return newFirewalldBackend()
+	case "nftables":
+		return newNftablesBackend()
 	}
 
 	// Default to firewalld if it's running
@@ -131,7 +226,10 @@ func getBackend(conf *FirewallNetConf) (FirewallBackend, error) {
 		return newFirewalldBackend()
 	}
 
-	// Otherwise iptables
+	// Otherwise iptables, unless only nftables is available
+	if !utils.SupportsIPTables() && utils.SupportsNFTables() {
+		return newNftablesBackend()
+	}
 	return newIptablesBackend(conf)
 }
 
@@ -140,6 +238,8 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	conf.ContainerID = args.ContainerID
+	conf.IfName = args.IfName
 
 	if conf.PrevResult == nil {
 		return fmt.Errorf("missing prevResult from earlier plugin")
@@ -150,11 +250,12 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
-	if err := backend.Add(conf, result); err != nil {
+	if err := setupAttachment(conf, result, backend); err != nil {
 		return err
 	}
+	log.Debug("added firewall rules", "backend", conf.Backend, "ips", result.IPs)
 
-	if err := setupIngressPolicy(conf, result); err != nil {
+	if err := saveAttachment(conf, result); err != nil {
 		return err
 	}
 
@@ -171,28 +272,147 @@ func cmdDel(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	conf.ContainerID = args.ContainerID
+	conf.IfName = args.IfName
 
 	backend, err := getBackend(conf)
 	if err != nil {
 		return err
 	}
 
+	if err := teardownAttachment(conf, backend, result); err != nil {
+		return err
+	}
+
+	return removeAttachment(conf)
+}
+
+// setupAttachment adds the rules of the attachment in conf. If that fails
+// halfway, the rules that were added are removed again.
+func setupAttachment(conf *FirewallNetConf, result *current.Result, backend FirewallBackend) error {
+	err := addAttachmentRules(conf, result, backend)
+	if err != nil {
+		if terr := teardownAttachment(conf, backend, result); terr != nil {
+			log.Warn("failed to roll back firewall rules", "error", terr)
+		}
+	}
+	return err
+}
+
+func addAttachmentRules(conf *FirewallNetConf, result *current.Result, backend FirewallBackend) error {
+	if ab, ok := backend.(atomicBackend); ok {
+		if err := ab.setupAttachment(conf, result); err != nil {
+			return err
+		}
+		// The bridge isolation chains are shared by all the attachments
+		// to the bridge, so they are not part of the attachment's rules.
+		if conf.IngressPolicy == IngressPolicyAllowlist {
+			return nil
+		}
+		return setupIngressPolicy(conf, result, backend)
+	}
+
+	if err := backend.Add(conf, result); err != nil {
+		return err
+	}
+
+	if err := setupIngressPolicy(conf, result, backend); err != nil {
+		return err
+	}
+
+	if err := setupEgressPolicy(conf, result, backend); err != nil {
+		return err
+	}
+
+	return setupConntrackZone(conf, result, backend)
+}
+
+// teardownAttachment removes the rules of the attachment in conf.
+func teardownAttachment(conf *FirewallNetConf, backend FirewallBackend, result *current.Result) error {
 	// Runtime errors are ignored
 	if err := backend.Del(conf, result); err != nil {
 		return err
 	}
 
-	return teardownIngressPolicy(conf)
+	if err := teardownIngressPolicy(conf, backend); err != nil {
+		return err
+	}
+
+	if err := teardownEgressPolicy(conf, backend); err != nil {
+		return err
+	}
+
+	return teardownConntrackZone(conf, backend)
+}
+
+// cmdGC tears down the recorded attachments on this network that the runtime
+// no longer considers valid, e.g. because a DEL was lost in a crash.
+func cmdGC(args *skel.CmdArgs) error {
+	conf, _, err := parseConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	backend, err := getBackend(conf)
+	if err != nil {
+		return err
+	}
+
+	return gcAttachments(conf, backend)
 }
 
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
-		Add:   cmdAdd,
-		Check: cmdCheck,
-		Del:   cmdDel,
-		/* FIXME GC */
-		/* FIXME Status */
-	}, version.VersionsStartingFrom("0.4.0"), bv.BuildString("firewall"))
+	skel.PluginMainFuncs(log.Wrap("firewall", trace.Wrap("firewall", skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		GC:     cmdGC,
+		Status: cmdStatus,
+	})), version.VersionsStartingFrom("0.4.0"), bv.BuildString("firewall"))
+}
+
+// cmdStatus checks that the backend getBackend would pick is usable.
+func cmdStatus(args *skel.CmdArgs) error {
+	conf, _, err := parseConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	// The bridge isolation policies always use iptables
+	if conf.IngressPolicy == IngressPolicySameBridge || conf.IngressPolicy == IngressPolicyIsolated {
+		if err := iptablesStatus(); err != nil {
+			return err
+		}
+	}
+
+	switch conf.Backend {
+	case "iptables":
+		return iptablesStatus()
+	case "firewalld":
+		if !isFirewalldRunning() {
+			return types.NewError(cnierrors.ErrPluginNotAvailable, "firewalld is not running", "")
+		}
+		return nil
+	case "nftables":
+		return status.NFTables()
+	}
+
+	if isFirewalldRunning() {
+		return nil
+	}
+	if err := iptablesStatus(); err != nil && status.NFTables() != nil {
+		return err
+	}
+	return nil
+}
+
+// iptablesStatus checks that both iptables and ip6tables are usable, as the
+// iptables backend needs both.
+func iptablesStatus() error {
+	if err := status.IPTables(false); err != nil {
+		return err
+	}
+	return status.IPTables(true)
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
@@ -200,6 +420,8 @@ func cmdCheck(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	conf.ContainerID = args.ContainerID
+	conf.IfName = args.IfName
 
 	// Ensure we have previous result.
 	if conf.PrevResult == nil {
@@ -211,5 +433,17 @@ func cmdCheck(args *skel.CmdArgs) error {
 		return err
 	}
 
-	return backend.Check(conf, result)
+	if err := backend.Check(conf, result); err != nil {
+		return err
+	}
+
+	if err := checkIngressPolicy(conf, result, backend); err != nil {
+		return err
+	}
+
+	if err := checkEgressPolicy(conf, result, backend); err != nil {
+		return err
+	}
+
+	return checkConntrackZone(conf, result, backend)
 }
@@ -70,6 +70,31 @@ func (f *fakeFirewalld) QuerySource(zone, source string) (bool, *dbus.Error) {
 	return true, nil
 }
 
+type fakeFirewalldPolicies struct {
+	egressZones map[string][]string
+}
+
+//nolint:unparam
+func (f *fakeFirewalldPolicies) GetPolicySettings(policy string) (map[string]dbus.Variant, *dbus.Error) {
+	zones, ok := f.egressZones[policy]
+	if !ok {
+		return nil, dbus.NewError("org.fedoraproject.FirewallD1.Exception", []interface{}{"INVALID_POLICY: " + policy})
+	}
+	return map[string]dbus.Variant{
+		firewalldEgressZonesKey: dbus.MakeVariant(zones),
+	}, nil
+}
+
+//nolint:unparam
+func (f *fakeFirewalldPolicies) SetPolicySettings(policy string, settings map[string]dbus.Variant) *dbus.Error {
+	var zones []string
+	if err := settings[firewalldEgressZonesKey].Store(&zones); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	f.egressZones[policy] = zones
+	return nil
+}
+
 func spawnSessionDbus(wg *sync.WaitGroup) (string, *exec.Cmd) {
 	// Start a private D-Bus session bus
 	path, err := invoke.FindInPath("dbus-daemon", []string{
@@ -136,6 +161,7 @@ var _ = Describe("firewalld test", func() {
 		conn     *dbus.Conn
 		wg       sync.WaitGroup
 		fwd      *fakeFirewalld
+		policies *fakeFirewalldPolicies
 		busAddr  string
 	)
 
@@ -169,6 +195,14 @@ var _ = Describe("firewalld test", func() {
 		}
 		conn.ExportWithMap(fwd, methods, firewalldPath, firewalldZoneInterface)
 
+		policies = &fakeFirewalldPolicies{
+			egressZones: map[string][]string{"cni-ingress": {"public"}},
+		}
+		conn.ExportWithMap(policies, map[string]string{
+			"GetPolicySettings": firewalldGetPolicySettingsMethod,
+			"SetPolicySettings": firewalldSetPolicySettingsMethod,
+		}, firewalldPath, firewalldPolicyInterface)
+
 		// Make sure the plugin uses our private session bus
 		testConn = conn
 	})
@@ -186,6 +220,96 @@ var _ = Describe("firewalld test", func() {
 		Expect(testutils.UnmountNS(targetNs)).To(Succeed())
 	})
 
+	It("attaches the zone to a policy", func() {
+		conf := []byte(fmt.Sprintf(`{
+		  "cniVersion": "1.0.0",
+		  "name": "firewalld-test",
+		  "type": "firewall",
+		  "backend": "firewalld",
+		  "firewalldZone": "cni",
+		  "firewalldPolicy": "cni-ingress",
+		  "prevResult": {
+		    "cniVersion": "1.0.0",
+		    "interfaces": [
+		      {"name": "eth0", "sandbox": "%s"}
+		    ],
+		    "ips": [
+		      {
+			"address": "10.0.0.2/24",
+			"gateway": "10.0.0.1",
+			"interface": 0
+		      }
+		    ]
+		  }
+		}`, targetNs.Path()))
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+			StdinData:   conf,
+		}
+
+		_, _, err := testutils.CmdAddWithArgs(args, func() error {
+			return cmdAdd(args)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fwd.zone).To(Equal("cni"))
+		Expect(policies.egressZones["cni-ingress"]).To(Equal([]string{"public", "cni"}))
+
+		// Adding again does not duplicate the zone
+		_, _, err = testutils.CmdAddWithArgs(args, func() error {
+			return cmdAdd(args)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policies.egressZones["cni-ingress"]).To(Equal([]string{"public", "cni"}))
+
+		err = testutils.CmdCheckWithArgs(args, func() error {
+			return cmdCheck(args)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		policies.egressZones["cni-ingress"] = []string{"public"}
+		err = testutils.CmdCheckWithArgs(args, func() error {
+			return cmdCheck(args)
+		})
+		Expect(err).To(MatchError("cni zone is not an egress zone of cni-ingress policy"))
+
+		err = testutils.CmdDelWithArgs(args, func() error {
+			return cmdDel(args)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fwd.zone).To(Equal("cni"))
+		Expect(fwd.source).To(Equal("10.0.0.2/32"))
+	})
+
+	It("fails if the policy does not exist", func() {
+		conf := []byte(fmt.Sprintf(`{
+		  "cniVersion": "1.0.0",
+		  "name": "firewalld-test",
+		  "type": "firewall",
+		  "backend": "firewalld",
+		  "firewalldPolicy": "missing",
+		  "prevResult": {
+		    "cniVersion": "1.0.0",
+		    "interfaces": [
+		      {"name": "eth0", "sandbox": "%s"}
+		    ],
+		    "ips": [{"address": "10.0.0.2/24", "interface": 0}]
+		  }
+		}`, targetNs.Path()))
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+			StdinData:   conf,
+		}
+
+		_, _, err := testutils.CmdAddWithArgs(args, func() error {
+			return cmdAdd(args)
+		})
+		Expect(err).To(MatchError(ContainSubstring("failed to get the settings of missing policy")))
+	})
+
 	// firewall plugin requires a prevResult and thus only supports 0.3.0
 	// and later CNI versions
 	for _, ver := range []string{"0.3.0", "0.3.1", "0.4.0", "1.0.0"} {
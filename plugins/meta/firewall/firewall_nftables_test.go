@@ -0,0 +1,281 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/knftables"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+var _ = Describe("firewall plugin nftables backend", func() {
+	var fake *knftables.Fake
+	var backend *nftablesBackend
+
+	BeforeEach(func() {
+		fake = knftables.NewFake(knftables.InetFamily, nftablesTableName)
+		backend = &nftablesBackend{nft: fake}
+	})
+
+	makeResult := func(addrs ...string) *current.Result {
+		result := &current.Result{}
+		for _, addr := range addrs {
+			ipn, err := types.ParseCIDR(addr)
+			Expect(err).NotTo(HaveOccurred())
+			result.IPs = append(result.IPs, &current.IPConfig{Address: *ipn})
+		}
+		return result
+	}
+
+	attachment := func(containerID string) *FirewallNetConf {
+		return &FirewallNetConf{ContainerID: containerID, IfName: "eth0"}
+	}
+
+	It("adds, checks and deletes the addresses of an attachment", func() {
+		conf := attachment("c1")
+		result := makeResult("10.0.0.2/24", "2001:db8::2/64")
+
+		Expect(backend.Add(conf, result)).To(Succeed())
+
+		expected := strings.TrimSpace(`
+add table inet cni_firewall { comment "CNI firewall plugin rules" ; }
+add chain inet cni_firewall admin { comment "CNI firewall plugin admin overrides" ; }
+add chain inet cni_firewall forward { type filter hook forward priority 0 ; }
+add set inet cni_firewall allowed_ipv4 { type ipv4_addr ; }
+add set inet cni_firewall allowed_ipv6 { type ipv6_addr ; }
+add map inet cni_firewall egress_ipv4 { type ipv4_addr : verdict ; }
+add map inet cni_firewall egress_ipv6 { type ipv6_addr : verdict ; }
+add map inet cni_firewall ingress_ipv4 { type ipv4_addr : verdict ; }
+add map inet cni_firewall ingress_ipv6 { type ipv6_addr : verdict ; }
+add rule inet cni_firewall forward jump admin
+add rule inet cni_firewall forward ct state new ip daddr vmap @ingress_ipv4
+add rule inet cni_firewall forward ct state new ip6 daddr vmap @ingress_ipv6
+add rule inet cni_firewall forward ct state new ip saddr vmap @egress_ipv4
+add rule inet cni_firewall forward ct state new ip6 saddr vmap @egress_ipv6
+add rule inet cni_firewall forward ip daddr @allowed_ipv4 ct state related,established accept
+add rule inet cni_firewall forward ip saddr @allowed_ipv4 accept
+add rule inet cni_firewall forward ip6 daddr @allowed_ipv6 ct state related,established accept
+add rule inet cni_firewall forward ip6 saddr @allowed_ipv6 accept
+add element inet cni_firewall allowed_ipv4 { 10.0.0.2 comment "c1-eth0" }
+add element inet cni_firewall allowed_ipv6 { 2001:db8::2 comment "c1-eth0" }
+`)
+		Expect(strings.TrimSpace(fake.Dump())).To(Equal(expected))
+
+		Expect(backend.Check(conf, result)).To(Succeed())
+		Expect(backend.Check(attachment("c2"), result)).To(MatchError("expected element 10.0.0.2 not found in set allowed_ipv4"))
+
+		// DEL works without prevResult
+		Expect(backend.Del(conf, &current.Result{})).To(Succeed())
+		Expect(fake.Dump()).NotTo(ContainSubstring("add element"))
+		Expect(backend.Check(conf, result)).NotTo(Succeed())
+	})
+
+	It("does not delete an address that was reused by another attachment", func() {
+		Expect(backend.Add(attachment("c1"), makeResult("10.0.0.2/24"))).To(Succeed())
+		Expect(backend.Add(attachment("c2"), makeResult("10.0.0.2/24", "10.0.0.3/24"))).To(Succeed())
+
+		Expect(backend.Del(attachment("c1"), &current.Result{})).To(Succeed())
+		Expect(backend.Check(attachment("c2"), makeResult("10.0.0.2/24", "10.0.0.3/24"))).To(Succeed())
+	})
+
+	It("does nothing on DEL if the table does not exist", func() {
+		Expect(backend.Del(attachment("c1"), &current.Result{})).To(Succeed())
+		Expect(backend.teardownAttachmentPolicy(attachment("c1"), policyIngress)).To(Succeed())
+	})
+
+	It("enforces an ingress allowlist per attachment", func() {
+		conf := attachment("c1")
+		conf.RawIngressPolicy = []byte(`{
+			"allowedSources": ["192.168.0.0/16", "2001:db8:1::/48"],
+			"allowedPorts": [{"port": 80}, {"port": 53, "protocol": "UDP"}]
+		}`)
+		Expect(parseIngressPolicy(conf)).To(Succeed())
+		result := makeResult("10.0.0.2/24", "2001:db8::2/64")
+
+		Expect(backend.Add(conf, result)).To(Succeed())
+		policy := conf.IngressAllowlist.policy()
+		Expect(backend.setupAttachmentPolicy(conf, result, policy)).To(Succeed())
+
+		chain := policyIngress.nftablesAttachmentChain(conf)
+		dump := fake.Dump()
+		for _, line := range []string{
+			"add rule inet cni_firewall " + chain + " ip saddr 192.168.0.0/16 tcp dport 80 return",
+			"add rule inet cni_firewall " + chain + " ip saddr 192.168.0.0/16 udp dport 53 return",
+			"add rule inet cni_firewall " + chain + " ip6 saddr 2001:db8:1::/48 tcp dport 80 return",
+			"add rule inet cni_firewall " + chain + " ip6 saddr 2001:db8:1::/48 udp dport 53 return",
+			"add rule inet cni_firewall " + chain + " drop",
+			`add element inet cni_firewall ingress_ipv4 { 10.0.0.2 comment "c1-eth0" : jump ` + chain + " }",
+			`add element inet cni_firewall ingress_ipv6 { 2001:db8::2 comment "c1-eth0" : jump ` + chain + " }",
+		} {
+			Expect(dump).To(ContainSubstring(line))
+		}
+
+		Expect(backend.checkAttachmentPolicy(conf, result, policy)).To(Succeed())
+		Expect(backend.checkAttachmentPolicy(attachment("c2"), result, policy)).NotTo(Succeed())
+
+		Expect(backend.teardownAttachmentPolicy(conf, policyIngress)).To(Succeed())
+		dump = fake.Dump()
+		Expect(dump).NotTo(ContainSubstring(chain))
+		Expect(dump).To(ContainSubstring("add element inet cni_firewall allowed_ipv4"))
+	})
+
+	It("treats empty allowlist fields as matching anything", func() {
+		conf := attachment("c1")
+		conf.RawIngressPolicy = []byte(`{}`)
+		Expect(parseIngressPolicy(conf)).To(Succeed())
+		Expect(nftablesPolicyRules(conf.IngressAllowlist.policy())).To(Equal([]string{"return", "drop"}))
+
+		conf.RawIngressPolicy = []byte(`{"allowedPorts": [{"port": 22}]}`)
+		Expect(parseIngressPolicy(conf)).To(Succeed())
+		Expect(nftablesPolicyRules(conf.IngressAllowlist.policy())).To(Equal([]string{"tcp dport 22 return", "drop"}))
+	})
+
+	It("enforces an egress policy per attachment", func() {
+		conf := attachment("c1")
+		conf.EgressPolicy = &EgressPolicy{
+			AllowedDestinations: []string{"10.96.0.10/32"},
+			AllowedPorts:        []PolicyPort{{Port: 53, Protocol: "udp"}},
+			DeniedDestinations:  []string{"169.254.169.254/32", "10.0.0.0/8"},
+		}
+		Expect(parseEgressPolicy(conf)).To(Succeed())
+		result := makeResult("10.0.0.2/24")
+		policy := conf.EgressPolicy.policy()
+
+		Expect(backend.setupAttachmentPolicy(conf, result, policy)).To(Succeed())
+
+		chain := policyEgress.nftablesAttachmentChain(conf)
+		rules, err := fake.ListRules(context.Background(), chain)
+		Expect(err).NotTo(HaveOccurred())
+		ruleStrings := []string{}
+		for _, r := range rules {
+			ruleStrings = append(ruleStrings, r.Rule)
+		}
+		Expect(ruleStrings).To(Equal([]string{
+			"ip daddr 10.96.0.10/32 udp dport 53 return",
+			"ip daddr 169.254.169.254/32 drop",
+			"ip daddr 10.0.0.0/8 drop",
+		}))
+		Expect(fake.Dump()).To(ContainSubstring(`add element inet cni_firewall egress_ipv4 { 10.0.0.2 comment "c1-eth0" : jump ` + chain + " }"))
+		Expect(backend.checkAttachmentPolicy(conf, result, policy)).To(Succeed())
+
+		// Ingress is unaffected
+		Expect(fake.Dump()).NotTo(ContainSubstring("add element inet cni_firewall ingress_ipv4"))
+
+		Expect(backend.teardownAttachmentPolicy(conf, policyEgress)).To(Succeed())
+		Expect(fake.Dump()).NotTo(ContainSubstring(chain))
+	})
+
+	It("denies all other egress with defaultDeny", func() {
+		conf := attachment("c1")
+		conf.EgressPolicy = &EgressPolicy{
+			AllowedDestinations: []string{"192.168.1.0/24", "2001:db8::/32"},
+			DefaultDeny:         true,
+		}
+		Expect(parseEgressPolicy(conf)).To(Succeed())
+		Expect(nftablesPolicyRules(conf.EgressPolicy.policy())).To(Equal([]string{
+			"ip daddr 192.168.1.0/24 return",
+			"ip6 daddr 2001:db8::/32 return",
+			"drop",
+		}))
+	})
+
+	It("garbage collects the attachments the runtime no longer knows", func() {
+		conf := func(containerID string) *FirewallNetConf {
+			c := attachment(containerID)
+			c.Name = "test"
+			c.ConntrackZone = true
+			c.ValidAttachments = []types.GCAttachment{{ContainerID: "c2", IfName: "eth0"}}
+			return c
+		}
+		for i, id := range []string{"c1", "c2"} {
+			result := makeResult(fmt.Sprintf("10.0.0.%d/24", i+2))
+			Expect(backend.Add(conf(id), result)).To(Succeed())
+			Expect(backend.setupConntrackZone(conf(id), result)).To(Succeed())
+			Expect(saveAttachment(conf(id), result)).To(Succeed())
+		}
+
+		Expect(gcAttachments(conf(""), backend)).To(Succeed())
+
+		dump := fake.Dump()
+		Expect(dump).NotTo(ContainSubstring(`"c1-eth0"`))
+		Expect(dump).To(ContainSubstring(`add element inet cni_firewall allowed_ipv4 { 10.0.0.3 comment "c2-eth0" }`))
+		Expect(dump).To(ContainSubstring(`ip saddr 10.0.0.3 ct original zone set`))
+
+		records, err := listAttachments(conf(""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].ContainerID).To(Equal("c2"))
+		Expect(records[0].Result.IPs[0].Address.IP.String()).To(Equal("10.0.0.3"))
+	})
+
+	It("applies all the rules of an attachment in a single transaction", func() {
+		conf := attachment("c1")
+		conf.RawIngressPolicy = []byte(`{"allowedPorts": [{"port": 80}]}`)
+		Expect(parseIngressPolicy(conf)).To(Succeed())
+		conf.EgressPolicy = &EgressPolicy{DefaultDeny: true}
+		Expect(parseEgressPolicy(conf)).To(Succeed())
+		conf.ConntrackZone = true
+		result := makeResult("10.0.0.2/24")
+
+		counting := &runCountingNft{Fake: fake}
+		backend.nft = counting
+		Expect(setupAttachment(conf, result, backend)).To(Succeed())
+		Expect(counting.runs).To(Equal(1))
+
+		dump := fake.Dump()
+		for _, line := range []string{
+			`add element inet cni_firewall allowed_ipv4 { 10.0.0.2 comment "c1-eth0" }`,
+			`add element inet cni_firewall ingress_ipv4 { 10.0.0.2 comment "c1-eth0" : jump ` + policyIngress.nftablesAttachmentChain(conf) + " }",
+			`add element inet cni_firewall egress_ipv4 { 10.0.0.2 comment "c1-eth0" : jump ` + policyEgress.nftablesAttachmentChain(conf) + " }",
+			`ip saddr 10.0.0.2 ct original zone set`,
+		} {
+			Expect(dump).To(ContainSubstring(line))
+		}
+	})
+
+	It("leaves no rules behind if the transaction fails", func() {
+		conf := attachment("c1")
+		conf.EgressPolicy = &EgressPolicy{DefaultDeny: true}
+		Expect(parseEgressPolicy(conf)).To(Succeed())
+		result := makeResult("10.0.0.2/24")
+
+		backend.nft = &runCountingNft{Fake: fake, err: fmt.Errorf("injected failure")}
+		Expect(setupAttachment(conf, result, backend)).To(MatchError(ContainSubstring("injected failure")))
+		Expect(fake.Dump()).NotTo(ContainSubstring(`"c1-eth0"`))
+	})
+})
+
+// runCountingNft counts the transactions run, and fails them with err if
+// it is set.
+type runCountingNft struct {
+	*knftables.Fake
+	runs int
+	err  error
+}
+
+func (n *runCountingNft) Run(ctx context.Context, tx *knftables.Transaction) error {
+	n.runs++
+	if n.err != nil {
+		return n.err
+	}
+	return n.Fake.Run(ctx, tx)
+}
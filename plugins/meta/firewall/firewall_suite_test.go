@@ -25,3 +25,8 @@ func TestFirewall(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "firewall Suite")
 }
+
+var _ = BeforeEach(func() {
+	// Keep the attachment records of the tests out of the host's
+	defaultDataDir = GinkgoT().TempDir()
+})
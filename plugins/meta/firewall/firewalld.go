@@ -21,6 +21,7 @@ import (
 	"github.com/godbus/dbus/v5"
 
 	current "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 )
 
 const (
@@ -35,6 +36,11 @@ const (
 	firewalldRemoveSourceMethod = "removeSource"
 	firewalldQuerySourceMethod  = "querySource"
 
+	firewalldPolicyInterface         = "org.fedoraproject.FirewallD1.policy"
+	firewalldGetPolicySettingsMethod = "getPolicySettings"
+	firewalldSetPolicySettingsMethod = "setPolicySettings"
+	firewalldEgressZonesKey          = "egress_zones"
+
 	errZoneAlreadySet = "ZONE_ALREADY_SET"
 )
 
@@ -74,7 +80,7 @@ func isFirewalldRunning() bool {
 func newFirewalldBackend() (FirewallBackend, error) {
 	conn, err := getConn()
 	if err != nil {
-		return nil, err
+		return nil, cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "could not connect to firewalld")
 	}
 
 	backend := &fwdBackend{
@@ -95,6 +101,51 @@ func (fb *fwdBackend) Add(conf *FirewallNetConf, result *current.Result) error {
 			}
 		}
 	}
+
+	if conf.FirewalldPolicy != "" {
+		return fb.attachPolicy(conf)
+	}
+	return nil
+}
+
+// policyEgressZones returns the egress zones of the policy in conf.
+func (fb *fwdBackend) policyEgressZones(conf *FirewallNetConf) ([]string, error) {
+	firewalldObj := fb.conn.Object(firewalldName, firewalldPath)
+	var settings map[string]dbus.Variant
+	if err := firewalldObj.Call(firewalldPolicyInterface+"."+firewalldGetPolicySettingsMethod, 0, conf.FirewalldPolicy).Store(&settings); err != nil {
+		return nil, fmt.Errorf("failed to get the settings of %v policy: %v", conf.FirewalldPolicy, err)
+	}
+
+	var zones []string
+	if v, ok := settings[firewalldEgressZonesKey]; ok {
+		if err := v.Store(&zones); err != nil {
+			return nil, fmt.Errorf("failed to parse the egress zones of %v policy: %v", conf.FirewalldPolicy, err)
+		}
+	}
+	return zones, nil
+}
+
+// attachPolicy adds the zone to the egress zones of the policy, unless it
+// is already there. The zone is shared by the whole network, so DEL leaves
+// it attached.
+func (fb *fwdBackend) attachPolicy(conf *FirewallNetConf) error {
+	zones, err := fb.policyEgressZones(conf)
+	if err != nil {
+		return err
+	}
+	for _, zone := range zones {
+		if zone == conf.FirewalldZone {
+			return nil
+		}
+	}
+
+	settings := map[string]dbus.Variant{
+		firewalldEgressZonesKey: dbus.MakeVariant(append(zones, conf.FirewalldZone)),
+	}
+	firewalldObj := fb.conn.Object(firewalldName, firewalldPath)
+	if err := firewalldObj.Call(firewalldPolicyInterface+"."+firewalldSetPolicySettingsMethod, 0, conf.FirewalldPolicy, settings).Err; err != nil {
+		return fmt.Errorf("failed to add %v zone to %v policy: %v", conf.FirewalldZone, conf.FirewalldPolicy, err)
+	}
 	return nil
 }
 
@@ -115,9 +166,22 @@ func (fb *fwdBackend) Check(conf *FirewallNetConf, result *current.Result) error
 		// Check for a firewalld rule for the given source IP to the given zone
 		firewalldObj := fb.conn.Object(firewalldName, firewalldPath)
 		var res bool
-		if err := firewalldObj.Call(firewalldZoneInterface+"."+firewalldQuerySourceMethod, 0, conf.FirewalldZone, ipStr).Store(&res); err != nil {
+		if err := firewalldObj.Call(firewalldZoneInterface+"."+firewalldQuerySourceMethod, 0, conf.FirewalldZone, ipStr).Store(&res); err != nil || !res {
 			return fmt.Errorf("failed to find the address %v in %v zone", ipStr, conf.FirewalldZone)
 		}
 	}
+
+	if conf.FirewalldPolicy != "" {
+		zones, err := fb.policyEgressZones(conf)
+		if err != nil {
+			return err
+		}
+		for _, zone := range zones {
+			if zone == conf.FirewalldZone {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v zone is not an egress zone of %v policy", conf.FirewalldZone, conf.FirewalldPolicy)
+	}
 	return nil
 }
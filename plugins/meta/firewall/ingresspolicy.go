@@ -17,6 +17,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 
 	"github.com/coreos/go-iptables/iptables"
@@ -25,7 +27,50 @@ import (
 	"github.com/containernetworking/plugins/pkg/utils"
 )
 
-func setupIngressPolicy(conf *FirewallNetConf, prevResult *types100.Result) error {
+// parseIngressPolicy parses conf.RawIngressPolicy, which is either a policy
+// name or an IngressAllowlist.
+func parseIngressPolicy(conf *FirewallNetConf) error {
+	raw := bytes.TrimSpace(conf.RawIngressPolicy)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+
+	if raw[0] != '{' {
+		if err := json.Unmarshal(raw, &conf.IngressPolicy); err != nil {
+			return fmt.Errorf("failed to parse ingressPolicy: %v", err)
+		}
+		if conf.IngressPolicy == IngressPolicyAllowlist {
+			return fmt.Errorf("ingress policy %q must be given as an object", IngressPolicyAllowlist)
+		}
+		return nil
+	}
+
+	allowlist := &IngressAllowlist{}
+	if err := json.Unmarshal(raw, allowlist); err != nil {
+		return fmt.Errorf("failed to parse ingressPolicy: %v", err)
+	}
+	var err error
+	if allowlist.sources, err = parsePolicyCIDRs("ingressPolicy allowed source", allowlist.AllowedSources); err != nil {
+		return err
+	}
+	if err := parsePolicyPorts("ingressPolicy", allowlist.AllowedPorts); err != nil {
+		return err
+	}
+
+	conf.IngressPolicy = IngressPolicyAllowlist
+	conf.IngressAllowlist = allowlist
+	return nil
+}
+
+// policy returns the rules enforcing the allowlist.
+func (al *IngressAllowlist) policy() *attachmentPolicy {
+	return &attachmentPolicy{
+		direction: policyIngress,
+		rules:     append(allowRules(al.sources, al.AllowedPorts), policyRule{verdict: "drop"}),
+	}
+}
+
+func setupIngressPolicy(conf *FirewallNetConf, prevResult *types100.Result, backend FirewallBackend) error {
 	switch conf.IngressPolicy {
 	case "", IngressPolicyOpen:
 		// NOP
@@ -34,11 +79,20 @@ func setupIngressPolicy(conf *FirewallNetConf, prevResult *types100.Result) erro
 		return setupIngressPolicyBridgeIsolation(conf, prevResult, false)
 	case IngressPolicyIsolated:
 		return setupIngressPolicyBridgeIsolation(conf, prevResult, true)
+	case IngressPolicyAllowlist:
+		return setupAttachmentPolicy(conf, prevResult, backend, conf.IngressAllowlist.policy())
 	default:
 		return fmt.Errorf("unknown ingress policy: %q", conf.IngressPolicy)
 	}
 }
 
+func checkIngressPolicy(conf *FirewallNetConf, prevResult *types100.Result, backend FirewallBackend) error {
+	if conf.IngressPolicy != IngressPolicyAllowlist {
+		return nil
+	}
+	return checkAttachmentPolicy(conf, prevResult, backend, conf.IngressAllowlist.policy())
+}
+
 func setupIngressPolicyBridgeIsolation(conf *FirewallNetConf, prevResult *types100.Result, isolated bool) error {
 	if len(prevResult.Interfaces) == 0 {
 		return fmt.Errorf("interface needs to be set for ingress policy %q, make sure to chain \"firewall\" plugin with \"bridge\"",
@@ -64,7 +118,7 @@ func setupIngressPolicyBridgeIsolation(conf *FirewallNetConf, prevResult *types1
 	return nil
 }
 
-func teardownIngressPolicy(conf *FirewallNetConf) error {
+func teardownIngressPolicy(conf *FirewallNetConf, backend FirewallBackend) error {
 	switch conf.IngressPolicy {
 	case "", IngressPolicyOpen:
 		// NOP
@@ -75,6 +129,8 @@ func teardownIngressPolicy(conf *FirewallNetConf) error {
 		// We can't be sure whether conf.bridgeName is still in use by other containers.
 		// So we do not remove the iptable rules that are created per bridge.
 		return nil
+	case IngressPolicyAllowlist:
+		return teardownAttachmentPolicy(conf, backend, policyIngress)
 	default:
 		return fmt.Errorf("unknown ingress policy: %q", conf.IngressPolicy)
 	}
@@ -0,0 +1,86 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("firewall ingress policy", func() {
+	parse := func(policy string) (*FirewallNetConf, error) {
+		conf := &FirewallNetConf{RawIngressPolicy: []byte(policy)}
+		return conf, parseIngressPolicy(conf)
+	}
+
+	It("parses policy names", func() {
+		conf, err := parse(`"same-bridge"`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.IngressPolicy).To(Equal(IngressPolicySameBridge))
+		Expect(conf.IngressAllowlist).To(BeNil())
+
+		conf, err = parse("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.IngressPolicy).To(BeEmpty())
+
+		_, err = parse(`"allowlist"`)
+		Expect(err).To(MatchError(`ingress policy "allowlist" must be given as an object`))
+	})
+
+	It("parses and validates allowlists", func() {
+		conf, err := parse(`{"allowedSources": ["10.1.0.0/16"], "allowedPorts": [{"port": 443}]}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.IngressPolicy).To(Equal(IngressPolicyAllowlist))
+		Expect(conf.IngressAllowlist.AllowedPorts).To(Equal([]PolicyPort{{Port: 443, Protocol: "tcp"}}))
+
+		_, err = parse(`{"allowedSources": ["10.1.0.0"]}`)
+		Expect(err).To(MatchError(ContainSubstring(`invalid ingressPolicy allowed source "10.1.0.0"`)))
+		_, err = parse(`{"allowedPorts": [{"port": 0}]}`)
+		Expect(err).To(MatchError("invalid ingressPolicy allowed port 0"))
+		_, err = parse(`{"allowedPorts": [{"port": 80, "protocol": "icmp"}]}`)
+		Expect(err).To(MatchError(`invalid ingressPolicy allowed port protocol "icmp"`))
+	})
+
+	It("generates iptables rules for each family", func() {
+		conf, err := parse(`{"allowedSources": ["10.1.0.0/16", "2001:db8::/32"], "allowedPorts": [{"port": 53, "protocol": "udp"}]}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(iptablesPolicyRules(conf.IngressAllowlist.policy(), false)).To(Equal([][]string{
+			{"-s", "10.1.0.0/16", "-p", "udp", "--dport", "53", "-j", "RETURN"},
+			{"-j", "DROP"},
+		}))
+		Expect(iptablesPolicyRules(conf.IngressAllowlist.policy(), true)).To(Equal([][]string{
+			{"-s", "2001:db8::/32", "-p", "udp", "--dport", "53", "-j", "RETURN"},
+			{"-j", "DROP"},
+		}))
+	})
+
+	It("generates iptables egress rules", func() {
+		conf := &FirewallNetConf{EgressPolicy: &EgressPolicy{
+			DeniedDestinations: []string{"169.254.169.254/32", "fd00::/8"},
+		}}
+		Expect(parseEgressPolicy(conf)).To(Succeed())
+
+		Expect(iptablesPolicyRules(conf.EgressPolicy.policy(), false)).To(Equal([][]string{
+			{"-d", "169.254.169.254/32", "-j", "DROP"},
+		}))
+		Expect(iptablesPolicyRules(conf.EgressPolicy.policy(), true)).To(Equal([][]string{
+			{"-d", "fd00::/8", "-j", "DROP"},
+		}))
+
+		conf.EgressPolicy = &EgressPolicy{DeniedDestinations: []string{"metadata"}}
+		Expect(parseEgressPolicy(conf)).To(MatchError(ContainSubstring(`invalid egressPolicy denied destination "metadata"`)))
+	})
+})
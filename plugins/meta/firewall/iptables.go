@@ -24,6 +24,7 @@ import (
 	"github.com/coreos/go-iptables/iptables"
 
 	current "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/utils"
 )
 
@@ -85,34 +86,65 @@ func protoForIP(ip net.IPNet) iptables.Protocol {
 }
 
 func (ib *iptablesBackend) addRules(_ *FirewallNetConf, result *current.Result, ipt *iptables.IPTables, proto iptables.Protocol) error {
+	payload := utils.RestorePayload{}
+	if err := ib.restoreForwardRules(ipt, proto, result, &payload); err != nil {
+		return err
+	}
+	return utils.IPTablesRestore(ipt, &payload)
+}
+
+// restoreForwardRules makes sure the shared chains exist, and adds the
+// commands appending the rules for the attachment's addresses of the
+// protocol, if not there already, to payload.
+func (ib *iptablesBackend) restoreForwardRules(ipt *iptables.IPTables, proto iptables.Protocol, result *current.Result, payload *utils.RestorePayload) error {
 	rules := make([][]string, 0)
 	for _, ip := range result.IPs {
 		if protoForIP(ip.Address) == proto {
 			rules = append(rules, getPrivChainRules(ipString(ip.Address))...)
 		}
 	}
+	if len(rules) == 0 {
+		return nil
+	}
 
-	if len(rules) > 0 {
-		if err := ib.setupChains(ipt); err != nil {
+	if err := ib.setupChains(ipt); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		exists, err := ipt.Exists("filter", ib.privChainName, rule...)
+		if err != nil {
 			return err
 		}
+		if !exists {
+			payload.Add("filter", utils.RestoreRule("-A", ib.privChainName, rule))
+		}
+	}
+	return nil
+}
 
-		// Clean up on any errors
-		var err error
-		defer func() {
-			if err != nil {
-				cleanupRules(ipt, ib.privChainName, rules)
+// setupAttachment adds all the rules of the attachment in conf, including
+// its attachment policies and conntrack zone, with one iptables-restore per
+// IP family. Only the shared chains they hook into are set up beforehand.
+func (ib *iptablesBackend) setupAttachment(conf *FirewallNetConf, result *current.Result) error {
+	for proto, ipt := range ib.protos {
+		payload := utils.RestorePayload{}
+		if err := ib.restoreForwardRules(ipt, proto, result, &payload); err != nil {
+			return err
+		}
+		for _, policy := range attachmentPolicies(conf) {
+			if err := ib.restoreAttachmentPolicy(ipt, proto, conf, result, policy, &payload); err != nil {
+				return err
 			}
-		}()
-
-		for _, rule := range rules {
-			err = ipt.AppendUnique("filter", ib.privChainName, rule...)
-			if err != nil {
+		}
+		if conf.ConntrackZone {
+			if err := ib.restoreConntrackZone(ipt, proto, conf, result, &payload); err != nil {
 				return err
 			}
 		}
+		if err := utils.IPTablesRestore(ipt, &payload); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
@@ -207,8 +239,11 @@ type iptablesBackend struct {
 	adminChainName string
 }
 
-// iptablesBackend implements the FirewallBackend interface
-var _ FirewallBackend = &iptablesBackend{}
+// iptablesBackend implements the FirewallBackend and atomicBackend interfaces
+var (
+	_ FirewallBackend = &iptablesBackend{}
+	_ atomicBackend   = &iptablesBackend{}
+)
 
 func newIptablesBackend(conf *FirewallNetConf) (FirewallBackend, error) {
 	adminChainName := conf.IptablesAdminChainName
@@ -225,7 +260,7 @@ func newIptablesBackend(conf *FirewallNetConf) (FirewallBackend, error) {
 	for _, proto := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
 		ipt, err := iptables.NewWithProtocol(proto)
 		if err != nil {
-			return nil, fmt.Errorf("could not initialize iptables protocol %v: %v", proto, err)
+			return nil, cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "could not initialize iptables protocol %v", proto)
 		}
 		backend.protos[proto] = ipt
 	}
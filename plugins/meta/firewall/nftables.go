@@ -0,0 +1,619 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/knftables"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/utils"
+)
+
+// The nftables backend is the equivalent of the iptables one, in a table of
+// its own:
+//
+//	table inet cni_firewall {
+//		set allowed_ipv4 { type ipv4_addr }
+//		set allowed_ipv6 { type ipv6_addr }
+//		map ingress_ipv4 { type ipv4_addr : verdict }
+//		map ingress_ipv6 { type ipv6_addr : verdict }
+//		map egress_ipv4 { type ipv4_addr : verdict }
+//		map egress_ipv6 { type ipv6_addr : verdict }
+//		chain admin { }
+//		chain forward {
+//			type filter hook forward priority filter
+//			jump admin
+//			ct state new ip daddr vmap @ingress_ipv4
+//			ct state new ip6 daddr vmap @ingress_ipv6
+//			ct state new ip saddr vmap @egress_ipv4
+//			ct state new ip6 saddr vmap @egress_ipv6
+//			ip daddr @allowed_ipv4 ct state related,established accept
+//			ip saddr @allowed_ipv4 accept
+//			ip6 daddr @allowed_ipv6 ct state related,established accept
+//			ip6 saddr @allowed_ipv6 accept
+//		}
+//	}
+//
+// Each attachment adds its addresses to the sets, with a comment naming the
+// attachment so that DEL only removes its own elements, even if an address
+// was since reused. As with the iptables backend's CNI-ADMIN chain, the
+// admin chain is left for administrators to add overrides to.
+//
+// With conntrackZone, each attachment also adds a rule, commented as its
+// set elements, to
+//
+//	chain conntrack_zones {
+//		type filter hook prerouting priority raw
+//		iifname { "cni0", "veth1" } ip saddr 10.0.0.2 ct original zone set 1234
+//	}
+//
+// Attachments with an "allowlist" ingress policy or an egress policy also
+// map their addresses to a chain of their own in the ingress or egress
+// maps, holding the rules of the policy.
+const (
+	nftablesTableName    = "cni_firewall"
+	nftablesForwardChain = "forward"
+	nftablesAdminChain   = "admin"
+	nftablesAllowedIPv4  = "allowed_ipv4"
+	nftablesAllowedIPv6  = "allowed_ipv6"
+
+	nftablesConntrackZonesChain = "conntrack_zones"
+)
+
+type nftablesBackend struct {
+	nft knftables.Interface
+}
+
+// nftablesBackend implements the FirewallBackend and atomicBackend interfaces
+var (
+	_ FirewallBackend = &nftablesBackend{}
+	_ atomicBackend   = &nftablesBackend{}
+)
+
+func newNftablesBackend() (FirewallBackend, error) {
+	nft, err := knftables.New(knftables.InetFamily, nftablesTableName)
+	if err != nil {
+		return nil, cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "could not initialize nftables")
+	}
+	return &nftablesBackend{nft: nft}, nil
+}
+
+// attachmentComment returns the comment identifying the set elements of the
+// attachment in conf.
+func attachmentComment(conf *FirewallNetConf) string {
+	comment := conf.ContainerID + "-" + conf.IfName
+	if len(comment) > knftables.CommentLengthMax {
+		comment = comment[:knftables.CommentLengthMax]
+	}
+	return comment
+}
+
+func allowedSetForIP(ip net.IPNet) string {
+	if ip.IP.To4() != nil {
+		return nftablesAllowedIPv4
+	}
+	return nftablesAllowedIPv6
+}
+
+// nftablesMaps returns the names of the IPv4 and IPv6 policy maps.
+func (d policyDirection) nftablesMaps() []string {
+	return []string{string(d) + "_ipv4", string(d) + "_ipv6"}
+}
+
+func (d policyDirection) nftablesMapForIP(ip net.IPNet) string {
+	maps := d.nftablesMaps()
+	if ip.IP.To4() != nil {
+		return maps[0]
+	}
+	return maps[1]
+}
+
+// nftablesKeys returns the expressions matching the attachment's address
+// and the peer's.
+func (d policyDirection) nftablesKeys() (string, string) {
+	if d == policyEgress {
+		return "saddr", "daddr"
+	}
+	return "daddr", "saddr"
+}
+
+// nftablesAttachmentChain returns the name of the chain enforcing the
+// policy of the attachment in conf.
+func (d policyDirection) nftablesAttachmentChain(conf *FirewallNetConf) string {
+	return utils.MustFormatHashWithPrefix(24, string(d)+"-", attachmentComment(conf))
+}
+
+// ensureTable adds the table, sets and chains to tx, (re)writing the rules
+// of the forward chain.
+func (nb *nftablesBackend) ensureTable(tx *knftables.Transaction) {
+	tx.Add(&knftables.Table{
+		Comment: knftables.PtrTo("CNI firewall plugin rules"),
+	})
+	tx.Add(&knftables.Set{
+		Name: nftablesAllowedIPv4,
+		Type: "ipv4_addr",
+	})
+	tx.Add(&knftables.Set{
+		Name: nftablesAllowedIPv6,
+		Type: "ipv6_addr",
+	})
+	for _, direction := range []policyDirection{policyIngress, policyEgress} {
+		maps := direction.nftablesMaps()
+		tx.Add(&knftables.Map{
+			Name: maps[0],
+			Type: "ipv4_addr : verdict",
+		})
+		tx.Add(&knftables.Map{
+			Name: maps[1],
+			Type: "ipv6_addr : verdict",
+		})
+	}
+	tx.Add(&knftables.Chain{
+		Name:    nftablesAdminChain,
+		Comment: knftables.PtrTo("CNI firewall plugin admin overrides"),
+	})
+	tx.Add(&knftables.Chain{
+		Name:     nftablesForwardChain,
+		Type:     knftables.PtrTo(knftables.FilterType),
+		Hook:     knftables.PtrTo(knftables.ForwardHook),
+		Priority: knftables.PtrTo(knftables.FilterPriority),
+	})
+	tx.Flush(&knftables.Chain{
+		Name: nftablesForwardChain,
+	})
+
+	tx.Add(&knftables.Rule{
+		Chain: nftablesForwardChain,
+		Rule:  knftables.Concat("jump", nftablesAdminChain),
+	})
+	for _, direction := range []policyDirection{policyIngress, policyEgress} {
+		key, _ := direction.nftablesKeys()
+		maps := direction.nftablesMaps()
+		for i, ipX := range []string{"ip", "ip6"} {
+			tx.Add(&knftables.Rule{
+				Chain: nftablesForwardChain,
+				Rule: knftables.Concat(
+					"ct state new",
+					ipX, key, "vmap", "@"+maps[i],
+				),
+			})
+		}
+	}
+	for _, family := range []struct{ ipX, set string }{
+		{"ip", nftablesAllowedIPv4},
+		{"ip6", nftablesAllowedIPv6},
+	} {
+		tx.Add(&knftables.Rule{
+			Chain: nftablesForwardChain,
+			Rule: knftables.Concat(
+				family.ipX, "daddr", "@"+family.set,
+				"ct state related,established",
+				"accept",
+			),
+		})
+		tx.Add(&knftables.Rule{
+			Chain: nftablesForwardChain,
+			Rule: knftables.Concat(
+				family.ipX, "saddr", "@"+family.set,
+				"accept",
+			),
+		})
+	}
+}
+
+func (nb *nftablesBackend) Add(conf *FirewallNetConf, result *current.Result) error {
+	if len(result.IPs) == 0 {
+		return nil
+	}
+
+	tx := nb.nft.NewTransaction()
+	nb.ensureTable(tx)
+	nb.addAllowedElements(tx, conf, result)
+
+	if err := nb.nft.Run(context.TODO(), tx); err != nil {
+		return fmt.Errorf("failed to add nftables rules: %v", err)
+	}
+	return nil
+}
+
+// addAllowedElements adds the attachment's addresses to the allowed sets
+// in tx.
+func (nb *nftablesBackend) addAllowedElements(tx *knftables.Transaction, conf *FirewallNetConf, result *current.Result) {
+	comment := attachmentComment(conf)
+	for _, ip := range result.IPs {
+		element := &knftables.Element{
+			Set:     allowedSetForIP(ip.Address),
+			Key:     []string{ip.Address.IP.String()},
+			Comment: &comment,
+		}
+		// Adding an existing element does not update its comment, so
+		// make sure any element left by a previous owner of the address
+		// is replaced.
+		tx.Add(element)
+		tx.Delete(element)
+		tx.Add(element)
+	}
+}
+
+// setupAttachment adds all the rules of the attachment in conf, including
+// its attachment policies and conntrack zone, in a single transaction.
+func (nb *nftablesBackend) setupAttachment(conf *FirewallNetConf, result *current.Result) error {
+	if len(result.IPs) == 0 {
+		return nil
+	}
+
+	tx := nb.nft.NewTransaction()
+	nb.ensureTable(tx)
+	nb.addAllowedElements(tx, conf, result)
+	for _, policy := range attachmentPolicies(conf) {
+		nb.addAttachmentPolicy(tx, conf, result, policy)
+	}
+	if conf.ConntrackZone {
+		if err := nb.addConntrackZone(tx, conf, result); err != nil {
+			return err
+		}
+	}
+
+	if err := nb.nft.Run(context.TODO(), tx); err != nil {
+		return fmt.Errorf("failed to add nftables rules: %v", err)
+	}
+	return nil
+}
+
+// Del removes the elements added for this attachment. Unlike the iptables
+// backend it does not need prevResult to find them.
+func (nb *nftablesBackend) Del(conf *FirewallNetConf, _ *current.Result) error {
+	comment := attachmentComment(conf)
+	tx := nb.nft.NewTransaction()
+	for _, set := range []string{nftablesAllowedIPv4, nftablesAllowedIPv6} {
+		elements, err := nb.nft.ListElements(context.TODO(), "set", set)
+		if err != nil {
+			if knftables.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("could not list elements of set %s: %v", set, err)
+		}
+		for _, e := range elements {
+			if e.Comment != nil && *e.Comment == comment {
+				tx.Delete(e)
+			}
+		}
+	}
+
+	if tx.NumOperations() == 0 {
+		return nil
+	}
+	if err := nb.nft.Run(context.TODO(), tx); err != nil {
+		return fmt.Errorf("failed to delete nftables elements: %v", err)
+	}
+	return nil
+}
+
+func (nb *nftablesBackend) Check(conf *FirewallNetConf, result *current.Result) error {
+	if len(result.IPs) == 0 {
+		return nil
+	}
+
+	rules, err := nb.nft.ListRules(context.TODO(), nftablesForwardChain)
+	if err != nil {
+		return fmt.Errorf("could not list rules of chain %s: %v", nftablesForwardChain, err)
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("expected rules in chain %s not found", nftablesForwardChain)
+	}
+
+	comment := attachmentComment(conf)
+	for _, ip := range result.IPs {
+		set := allowedSetForIP(ip.Address)
+		elements, err := nb.nft.ListElements(context.TODO(), "set", set)
+		if err != nil {
+			return fmt.Errorf("could not list elements of set %s: %v", set, err)
+		}
+		found := false
+		for _, e := range elements {
+			if len(e.Key) == 1 && e.Key[0] == ip.Address.IP.String() && e.Comment != nil && *e.Comment == comment {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("expected element %s not found in set %s", ip.Address.IP, set)
+		}
+	}
+	return nil
+}
+
+// nftablesPolicyRules returns the rules of an attachment's policy chain.
+func nftablesPolicyRules(policy *attachmentPolicy) []string {
+	_, peerKey := policy.direction.nftablesKeys()
+	rules := []string{}
+	for _, r := range policy.rules {
+		var peer, dport []string
+		if r.peer != nil {
+			ipX := "ip"
+			if r.peer.IP.To4() == nil {
+				ipX = "ip6"
+			}
+			peer = []string{ipX, peerKey, r.peer.String()}
+		}
+		if r.port != nil {
+			dport = []string{r.port.Protocol, "dport", strconv.Itoa(r.port.Port)}
+		}
+		rules = append(rules, knftables.Concat(peer, dport, r.verdict))
+	}
+	return rules
+}
+
+func (nb *nftablesBackend) setupAttachmentPolicy(conf *FirewallNetConf, result *current.Result, policy *attachmentPolicy) error {
+	if len(result.IPs) == 0 {
+		return nil
+	}
+
+	tx := nb.nft.NewTransaction()
+	nb.ensureTable(tx)
+	nb.addAttachmentPolicy(tx, conf, result, policy)
+
+	if err := nb.nft.Run(context.TODO(), tx); err != nil {
+		return fmt.Errorf("failed to add nftables %s policy rules: %v", policy.direction, err)
+	}
+	return nil
+}
+
+// addAttachmentPolicy adds the (re)creation of the attachment's policy
+// chain, and the map elements jumping to it, to tx.
+func (nb *nftablesBackend) addAttachmentPolicy(tx *knftables.Transaction, conf *FirewallNetConf, result *current.Result, policy *attachmentPolicy) {
+	comment := attachmentComment(conf)
+	chain := policy.direction.nftablesAttachmentChain(conf)
+	tx.Add(&knftables.Chain{
+		Name:    chain,
+		Comment: &comment,
+	})
+	tx.Flush(&knftables.Chain{
+		Name: chain,
+	})
+	for _, rule := range nftablesPolicyRules(policy) {
+		tx.Add(&knftables.Rule{
+			Chain: chain,
+			Rule:  rule,
+		})
+	}
+	for _, ip := range result.IPs {
+		element := &knftables.Element{
+			Map:     policy.direction.nftablesMapForIP(ip.Address),
+			Key:     []string{ip.Address.IP.String()},
+			Value:   []string{"jump " + chain},
+			Comment: &comment,
+		}
+		// As in addAllowedElements, replace any element left by a
+		// previous owner of the address.
+		tx.Add(element)
+		tx.Delete(element)
+		tx.Add(element)
+	}
+}
+
+// teardownAttachmentPolicy removes the attachment's policy chain and the
+// map elements jumping to it. It does not need prevResult.
+func (nb *nftablesBackend) teardownAttachmentPolicy(conf *FirewallNetConf, direction policyDirection) error {
+	comment := attachmentComment(conf)
+	chain := direction.nftablesAttachmentChain(conf)
+	tx := nb.nft.NewTransaction()
+	for _, vmap := range direction.nftablesMaps() {
+		elements, err := nb.nft.ListElements(context.TODO(), "map", vmap)
+		if err != nil {
+			if knftables.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("could not list elements of map %s: %v", vmap, err)
+		}
+		for _, e := range elements {
+			if e.Comment != nil && *e.Comment == comment {
+				tx.Delete(e)
+			}
+		}
+	}
+
+	chains, err := nb.nft.List(context.TODO(), "chain")
+	if err != nil && !knftables.IsNotFound(err) {
+		return fmt.Errorf("could not list chains: %v", err)
+	}
+	for _, c := range chains {
+		if c == chain {
+			tx.Delete(&knftables.Chain{
+				Name: chain,
+			})
+		}
+	}
+
+	if tx.NumOperations() == 0 {
+		return nil
+	}
+	if err := nb.nft.Run(context.TODO(), tx); err != nil {
+		return fmt.Errorf("failed to delete nftables %s policy rules: %v", direction, err)
+	}
+	return nil
+}
+
+func (nb *nftablesBackend) checkAttachmentPolicy(conf *FirewallNetConf, result *current.Result, policy *attachmentPolicy) error {
+	if len(result.IPs) == 0 {
+		return nil
+	}
+
+	chain := policy.direction.nftablesAttachmentChain(conf)
+	rules, err := nb.nft.ListRules(context.TODO(), chain)
+	if err != nil {
+		return fmt.Errorf("could not list rules of chain %s: %v", chain, err)
+	}
+	expected := nftablesPolicyRules(policy)
+	if len(rules) != len(expected) {
+		return fmt.Errorf("expected %d rules in chain %s, found %d", len(expected), chain, len(rules))
+	}
+
+	comment := attachmentComment(conf)
+	for _, ip := range result.IPs {
+		vmap := policy.direction.nftablesMapForIP(ip.Address)
+		elements, err := nb.nft.ListElements(context.TODO(), "map", vmap)
+		if err != nil {
+			return fmt.Errorf("could not list elements of map %s: %v", vmap, err)
+		}
+		found := false
+		for _, e := range elements {
+			if len(e.Key) == 1 && e.Key[0] == ip.Address.IP.String() && e.Comment != nil && *e.Comment == comment {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("expected element %s not found in map %s", ip.Address.IP, vmap)
+		}
+	}
+	return nil
+}
+
+// nftablesConntrackZoneRules returns the rules setting the conntrack zone
+// of the attachment in conf.
+func nftablesConntrackZoneRules(conf *FirewallNetConf, result *current.Result) []string {
+	var iifname []string
+	if ifaces := hostInterfaces(result); len(ifaces) > 0 {
+		quoted := make([]string, 0, len(ifaces))
+		for _, iface := range ifaces {
+			quoted = append(quoted, strconv.Quote(iface))
+		}
+		iifname = []string{"iifname", "{", strings.Join(quoted, ", "), "}"}
+	}
+
+	rules := []string{}
+	for _, ip := range result.IPs {
+		ipX := "ip"
+		if ip.Address.IP.To4() == nil {
+			ipX = "ip6"
+		}
+		rules = append(rules, knftables.Concat(
+			iifname,
+			ipX, "saddr", ip.Address.IP.String(),
+			"ct original zone set", int(conntrackZoneID(conf)),
+		))
+	}
+	return rules
+}
+
+// deleteConntrackZoneRules adds the deletion of the attachment's conntrack
+// zone rules to tx.
+func (nb *nftablesBackend) deleteConntrackZoneRules(tx *knftables.Transaction, comment string) error {
+	rules, err := nb.nft.ListRules(context.TODO(), nftablesConntrackZonesChain)
+	if err != nil {
+		if knftables.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("could not list rules of chain %s: %v", nftablesConntrackZonesChain, err)
+	}
+	for _, rule := range rules {
+		if rule.Comment != nil && *rule.Comment == comment {
+			tx.Delete(rule)
+		}
+	}
+	return nil
+}
+
+func (nb *nftablesBackend) setupConntrackZone(conf *FirewallNetConf, result *current.Result) error {
+	if len(result.IPs) == 0 {
+		return nil
+	}
+
+	tx := nb.nft.NewTransaction()
+	nb.ensureTable(tx)
+	if err := nb.addConntrackZone(tx, conf, result); err != nil {
+		return err
+	}
+
+	if err := nb.nft.Run(context.TODO(), tx); err != nil {
+		return fmt.Errorf("failed to add nftables conntrack zone rules: %v", err)
+	}
+	return nil
+}
+
+// addConntrackZone adds the replacement of the attachment's conntrack zone
+// rules to tx.
+func (nb *nftablesBackend) addConntrackZone(tx *knftables.Transaction, conf *FirewallNetConf, result *current.Result) error {
+	rules := nftablesConntrackZoneRules(conf, result)
+	if len(rules) == 0 {
+		return nil
+	}
+
+	comment := attachmentComment(conf)
+	tx.Add(&knftables.Chain{
+		Name:     nftablesConntrackZonesChain,
+		Type:     knftables.PtrTo(knftables.FilterType),
+		Hook:     knftables.PtrTo(knftables.PreroutingHook),
+		Priority: knftables.PtrTo(knftables.RawPriority),
+	})
+	if err := nb.deleteConntrackZoneRules(tx, comment); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		tx.Add(&knftables.Rule{
+			Chain:   nftablesConntrackZonesChain,
+			Rule:    rule,
+			Comment: &comment,
+		})
+	}
+	return nil
+}
+
+// teardownConntrackZone removes the attachment's conntrack zone rules. It
+// does not need prevResult.
+func (nb *nftablesBackend) teardownConntrackZone(conf *FirewallNetConf) error {
+	tx := nb.nft.NewTransaction()
+	if err := nb.deleteConntrackZoneRules(tx, attachmentComment(conf)); err != nil {
+		return err
+	}
+	if tx.NumOperations() == 0 {
+		return nil
+	}
+	if err := nb.nft.Run(context.TODO(), tx); err != nil {
+		return fmt.Errorf("failed to delete nftables conntrack zone rules: %v", err)
+	}
+	return nil
+}
+
+func (nb *nftablesBackend) checkConntrackZone(conf *FirewallNetConf, result *current.Result) error {
+	expected := nftablesConntrackZoneRules(conf, result)
+	if len(expected) == 0 {
+		return nil
+	}
+
+	rules, err := nb.nft.ListRules(context.TODO(), nftablesConntrackZonesChain)
+	if err != nil {
+		return fmt.Errorf("could not list rules of chain %s: %v", nftablesConntrackZonesChain, err)
+	}
+	comment := attachmentComment(conf)
+	found := 0
+	for _, rule := range rules {
+		if rule.Comment != nil && *rule.Comment == comment {
+			found++
+		}
+	}
+	if found != len(expected) {
+		return fmt.Errorf("expected %d conntrack zone rules for %s, found %d", len(expected), comment, found)
+	}
+	return nil
+}
@@ -0,0 +1,465 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+// fakeNATPMP is a NAT-PMP gateway that grants every request, capping the
+// lifetime at maxLifetime.
+type fakeNATPMP struct {
+	conn        *net.UDPConn
+	maxLifetime uint32
+
+	sync.Mutex
+	mappings map[string]uint32
+}
+
+func newFakeNATPMP(maxLifetime uint32) *fakeNATPMP {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	Expect(err).NotTo(HaveOccurred())
+
+	f := &fakeNATPMP{conn: conn, maxLifetime: maxLifetime, mappings: map[string]uint32{}}
+	go f.serve()
+	return f
+}
+
+func (f *fakeNATPMP) port() int {
+	return f.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (f *fakeNATPMP) serve() {
+	buf := make([]byte, 64)
+	for {
+		n, addr, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n != 12 {
+			continue
+		}
+		op := buf[1]
+		internal := binary.BigEndian.Uint16(buf[4:])
+		external := binary.BigEndian.Uint16(buf[6:])
+		lifetime := binary.BigEndian.Uint32(buf[8:])
+		if lifetime > f.maxLifetime {
+			lifetime = f.maxLifetime
+		}
+		if external == 0 {
+			external = internal
+		}
+
+		key := fmt.Sprintf("%d/%d", op, internal)
+		f.Lock()
+		if lifetime == 0 {
+			delete(f.mappings, key)
+		} else {
+			f.mappings[key] = lifetime
+		}
+		f.Unlock()
+
+		resp := make([]byte, 16)
+		resp[1] = natPMPReplyBase + op
+		binary.BigEndian.PutUint16(resp[8:], internal)
+		binary.BigEndian.PutUint16(resp[10:], external)
+		binary.BigEndian.PutUint32(resp[12:], lifetime)
+		_, _ = f.conn.WriteToUDP(resp, addr)
+	}
+}
+
+func (f *fakeNATPMP) mappingCount() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.mappings)
+}
+
+// fakePCP is a PCP server that grants every MAP request, capping the
+// lifetime at maxLifetime.
+type fakePCP struct {
+	conn        *net.UDPConn
+	maxLifetime uint32
+
+	sync.Mutex
+	// mappings maps protocol/internal port to the nonce of the mapping
+	mappings map[string]string
+}
+
+func newFakePCP(maxLifetime uint32) *fakePCP {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	Expect(err).NotTo(HaveOccurred())
+
+	f := &fakePCP{conn: conn, maxLifetime: maxLifetime, mappings: map[string]string{}}
+	go f.serve()
+	return f
+}
+
+func (f *fakePCP) port() int {
+	return f.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (f *fakePCP) serve() {
+	buf := make([]byte, 1100)
+	for {
+		n, addr, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n != pcpHeaderLen+pcpMapLen || buf[0] != pcpVersion || buf[1] != pcpOpMap {
+			continue
+		}
+		lifetime := binary.BigEndian.Uint32(buf[4:])
+		if lifetime > f.maxLifetime {
+			lifetime = f.maxLifetime
+		}
+		nonce := fmt.Sprintf("%x", buf[24:36])
+		internal := binary.BigEndian.Uint16(buf[40:])
+		external := binary.BigEndian.Uint16(buf[42:])
+		if external == 0 {
+			external = internal
+		}
+
+		key := fmt.Sprintf("%d/%d", buf[36], internal)
+		f.Lock()
+		if lifetime == 0 {
+			delete(f.mappings, key)
+		} else {
+			f.mappings[key] = nonce
+		}
+		f.Unlock()
+
+		resp := make([]byte, n)
+		copy(resp, buf[:n])
+		resp[1] = pcpReplyBit | pcpOpMap
+		binary.BigEndian.PutUint32(resp[4:], lifetime)
+		binary.BigEndian.PutUint16(resp[42:], external)
+		_, _ = f.conn.WriteToUDP(resp, addr)
+	}
+}
+
+func (f *fakePCP) nonce(key string) (string, bool) {
+	f.Lock()
+	defer f.Unlock()
+	nonce, ok := f.mappings[key]
+	return nonce, ok
+}
+
+const igdDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+var (
+	soapActionRe = regexp.MustCompile(`<u:(\w+) `)
+	soapPortRe   = regexp.MustCompile(`<NewExternalPort>(\d+)</NewExternalPort><NewProtocol>(\w+)</NewProtocol>`)
+)
+
+// fakeUPnP is a UPnP IGD that records the mappings added and deleted.
+type fakeUPnP struct {
+	server *httptest.Server
+
+	sync.Mutex
+	mappings map[string]bool
+}
+
+func newFakeUPnP() *fakeUPnP {
+	f := &fakeUPnP{mappings: map[string]bool{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, igdDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		action := soapActionRe.FindSubmatch(body)
+		port := soapPortRe.FindSubmatch(body)
+		if action == nil || port == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		key := string(port[2]) + "/" + string(port[1])
+		f.Lock()
+		switch string(action[1]) {
+		case "AddPortMapping":
+			f.mappings[key] = true
+		case "DeletePortMapping":
+			delete(f.mappings, key)
+		}
+		f.Unlock()
+	})
+	f.server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeUPnP) hasMapping(key string) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.mappings[key]
+}
+
+var _ = Describe("igd plugin", func() {
+	var dataDir string
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = os.MkdirTemp("", "igd")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	makeConf := func(extra string) []byte {
+		return []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "edge",
+			"type": "igd",
+			"dataDir": "%s",
+			%s
+			"runtimeConfig": {
+				"portMappings": [
+					{ "hostPort": 8080, "containerPort": 80, "protocol": "tcp" },
+					{ "hostPort": 8080, "containerPort": 80, "protocol": "tcp", "hostIP": "::" },
+					{ "hostPort": 5353, "containerPort": 53, "protocol": "udp" }
+				]
+			},
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "10.0.0.2/24", "interface": 0}]
+			}
+		}`, dataDir, extra))
+	}
+
+	args := func(containerID string, conf []byte) *skel.CmdArgs {
+		return &skel.CmdArgs{
+			ContainerID: containerID,
+			Netns:       "/var/run/netns/test",
+			IfName:      "eth0",
+			StdinData:   conf,
+		}
+	}
+
+	Context("with NAT-PMP", func() {
+		var gw *fakeNATPMP
+		var origPort int
+
+		BeforeEach(func() {
+			gw = newFakeNATPMP(600)
+			origPort = natPMPPort
+			natPMPPort = gw.port()
+		})
+
+		AfterEach(func() {
+			natPMPPort = origPort
+			gw.conn.Close()
+		})
+
+		It("acquires and releases mappings, leaving renewal to the renewer", func() {
+			conf := makeConf(`"gateway": "127.0.0.1", "lifetime": 3600,`)
+
+			_, _, err := testutils.CmdAddWithArgs(args("c1", conf), func() error {
+				return cmdAdd(args("c1", conf))
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gw.mappingCount()).To(Equal(2))
+
+			store, err := newLeaseStore(dataDir, "edge")
+			Expect(err).NotTo(HaveOccurred())
+			defer store.Close()
+			leases, err := store.load("c1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leases).To(HaveLen(2))
+			// The gateway capped the lifetime
+			Expect(leases[0].Lifetime).To(Equal(uint32(600)))
+			Expect(leases[0].Gateway).To(Equal("127.0.0.1"))
+			Expect(cmdCheck(args("c1", conf))).To(Succeed())
+
+			// Pretend the lease has expired; CHECK reports it without renewing
+			expired := time.Now().Add(-time.Minute)
+			leases[0].Expires = expired
+			Expect(store.save("c1", leases)).To(Succeed())
+			Expect(cmdCheck(args("c1", conf))).To(MatchError(ContainSubstring("gateway mapping for tcp/8080 expired")))
+			leases, err = store.load("c1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leases[0].Expires).To(BeTemporally("==", expired))
+
+			// The renewer does renew it
+			Expect(renewLeases(dataDir, time.Now())).To(Succeed())
+			leases, err = store.load("c1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leases[0].Expires).To(BeTemporally(">", time.Now().Add(9*time.Minute)))
+			Expect(cmdCheck(args("c1", conf))).To(Succeed())
+
+			Expect(cmdDel(args("c1", conf))).To(Succeed())
+			Expect(gw.mappingCount()).To(Equal(0))
+			leases, err = store.load("c1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leases).To(BeNil())
+		})
+
+		It("releases leases of stale containers on GC", func() {
+			conf := makeConf(`"gateway": "127.0.0.1",`)
+
+			for _, id := range []string{"c1", "c2"} {
+				_, _, err := testutils.CmdAddWithArgs(args(id, conf), func() error {
+					return cmdAdd(args(id, conf))
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			gcConf := []byte(fmt.Sprintf(`{
+				"cniVersion": "1.1.0",
+				"name": "edge",
+				"type": "igd",
+				"gateway": "127.0.0.1",
+				"dataDir": "%s",
+				"cni.dev/valid-attachments": [{"containerID": "c2", "ifname": "eth0"}]
+			}`, dataDir))
+			Expect(cmdGC(&skel.CmdArgs{StdinData: gcConf})).To(Succeed())
+
+			store, err := newLeaseStore(dataDir, "edge")
+			Expect(err).NotTo(HaveOccurred())
+			ids, err := store.containerIDs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids).To(ConsistOf("c2"))
+		})
+
+		It("rejects a zero lifetime", func() {
+			_, err := parseConfig(makeConf(`"lifetime": 0,`))
+			Expect(err).To(MatchError(ContainSubstring("non-zero lifetime")))
+		})
+	})
+
+	Context("with PCP", func() {
+		var gw *fakePCP
+		var origPort int
+
+		BeforeEach(func() {
+			gw = newFakePCP(600)
+			origPort = natPMPPort
+			natPMPPort = gw.port()
+		})
+
+		AfterEach(func() {
+			natPMPPort = origPort
+			gw.conn.Close()
+		})
+
+		It("maps ports with the MAP opcode and deletes them by nonce", func() {
+			conf := makeConf(`"method": "pcp", "gateway": "127.0.0.1", "lifetime": 3600,`)
+
+			_, _, err := testutils.CmdAddWithArgs(args("c1", conf), func() error {
+				return cmdAdd(args("c1", conf))
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			store, err := newLeaseStore(dataDir, "edge")
+			Expect(err).NotTo(HaveOccurred())
+			defer store.Close()
+			leases, err := store.load("c1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leases).To(HaveLen(2))
+			Expect(leases[0].Method).To(Equal(methodPCP))
+			Expect(leases[0].Lifetime).To(Equal(uint32(600)))
+			nonce, ok := gw.nonce("6/8080")
+			Expect(ok).To(BeTrue())
+			Expect(leases[0].Nonce).To(Equal(nonce))
+			_, ok = gw.nonce("17/5353")
+			Expect(ok).To(BeTrue())
+
+			// Renewal keeps the nonce of the mapping
+			leases[0].Expires = time.Now().Add(time.Minute)
+			Expect(store.save("c1", leases)).To(Succeed())
+			Expect(renewLeases(dataDir, time.Now())).To(Succeed())
+			leases, err = store.load("c1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leases[0].Nonce).To(Equal(nonce))
+			Expect(leases[0].Expires).To(BeTemporally(">", time.Now().Add(9*time.Minute)))
+
+			Expect(cmdDel(args("c1", conf))).To(Succeed())
+			_, ok = gw.nonce("6/8080")
+			Expect(ok).To(BeFalse())
+			_, ok = gw.nonce("17/5353")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("with UPnP", func() {
+		var gw *fakeUPnP
+
+		BeforeEach(func() {
+			gw = newFakeUPnP()
+		})
+
+		AfterEach(func() {
+			gw.server.Close()
+		})
+
+		It("adds and deletes mappings via the WAN connection service", func() {
+			conf := makeConf(fmt.Sprintf(`"method": "upnp", "location": "%s/rootDesc.xml",`, gw.server.URL))
+
+			_, _, err := testutils.CmdAddWithArgs(args("c1", conf), func() error {
+				return cmdAdd(args("c1", conf))
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gw.hasMapping("TCP/8080")).To(BeTrue())
+			Expect(gw.hasMapping("UDP/5353")).To(BeTrue())
+
+			store, err := newLeaseStore(dataDir, "edge")
+			Expect(err).NotTo(HaveOccurred())
+			leases, err := store.load("c1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leases[0].ControlURL).To(Equal(gw.server.URL + "/ctl/IPConn"))
+
+			Expect(cmdDel(args("c1", conf))).To(Succeed())
+			Expect(gw.hasMapping("TCP/8080")).To(BeFalse())
+			Expect(gw.hasMapping("UDP/5353")).To(BeFalse())
+		})
+	})
+})
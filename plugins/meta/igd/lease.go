@@ -0,0 +1,223 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-filemutex"
+)
+
+var defaultDataDir = "/var/lib/cni/igd"
+
+// lease is a single mapping obtained from the gateway. Everything needed to
+// renew or delete the mapping is recorded, so that a later invocation does
+// not have to rediscover the gateway.
+type lease struct {
+	Method      string `json:"method"`
+	Gateway     string `json:"gateway,omitempty"`
+	ControlURL  string `json:"controlURL,omitempty"`
+	ServiceType string `json:"serviceType,omitempty"`
+	// Nonce identifies a PCP mapping to the server
+	Nonce string `json:"nonce,omitempty"`
+
+	Protocol     string `json:"protocol"`
+	InternalPort int    `json:"internalPort"`
+	ExternalPort int    `json:"externalPort"`
+	Description  string `json:"description,omitempty"`
+
+	// Lifetime is the granted lifetime in seconds; 0 means the mapping
+	// does not expire.
+	Lifetime uint32    `json:"lifetime"`
+	Expires  time.Time `json:"expires,omitempty"`
+}
+
+// needsRenewal returns true once less than half of the lease's lifetime
+// remains, as recommended by RFC 6886.
+func (l *lease) needsRenewal(now time.Time) bool {
+	if l.Lifetime == 0 {
+		return false
+	}
+	return now.Add(time.Duration(l.Lifetime) * time.Second / 2).After(l.Expires)
+}
+
+// expired returns true once the lease's lifetime has run out.
+func (l *lease) expired(now time.Time) bool {
+	return l.Lifetime != 0 && !now.Before(l.Expires)
+}
+
+// leaseStore keeps one file of leases per container, under a lock shared
+// by the invocations of the plugin and the renewer.
+type leaseStore struct {
+	dir string
+	*filemutex.FileMutex
+}
+
+func newLeaseStore(dataDir, network string) (*leaseStore, error) {
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+	dir := filepath.Join(dataDir, network)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := filemutex.New(filepath.Join(dir, "lock"))
+	if err != nil {
+		return nil, err
+	}
+	return &leaseStore{dir: dir, FileMutex: lock}, nil
+}
+
+func (s *leaseStore) path(containerID string) string {
+	return filepath.Join(s.dir, containerID+".json")
+}
+
+// load returns the leases for containerID, or nil if there are none.
+func (s *leaseStore) load(containerID string) ([]*lease, error) {
+	data, err := os.ReadFile(s.path(containerID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	leases := []*lease{}
+	if err := json.Unmarshal(data, &leases); err != nil {
+		return nil, fmt.Errorf("failed to parse leases of %s: %v", containerID, err)
+	}
+	return leases, nil
+}
+
+// save atomically replaces the leases recorded for containerID.
+func (s *leaseStore) save(containerID string, leases []*lease) error {
+	data, err := json.Marshal(leases)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path(containerID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path(containerID)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (s *leaseStore) remove(containerID string) error {
+	if err := os.Remove(s.path(containerID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// containerIDs lists the containers that have leases recorded.
+func (s *leaseStore) containerIDs() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	return ids, nil
+}
+
+// renewLeases renews the leases recorded under dataDir, of every network,
+// that are past half of their lifetime. It is run periodically by the
+// renew command, as CNI invocations must not renew them: CHECK must not
+// change anything.
+func renewLeases(dataDir string, now time.Time) error {
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Keep on going, but return the last failure.
+	var errReturn error
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if err := renewNetworkLeases(dataDir, e.Name(), now); err != nil {
+			errReturn = fmt.Errorf("failed to renew leases of network %s: %v", e.Name(), err)
+		}
+	}
+	return errReturn
+}
+
+func renewNetworkLeases(dataDir, network string, now time.Time) error {
+	store, err := newLeaseStore(dataDir, network)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock()
+
+	ids, err := store.containerIDs()
+	if err != nil {
+		return err
+	}
+	var errReturn error
+	for _, id := range ids {
+		leases, err := store.load(id)
+		if err != nil {
+			errReturn = err
+			continue
+		}
+		renewed := false
+		for _, l := range leases {
+			if !l.needsRenewal(now) {
+				continue
+			}
+			m, err := mapperForLease(l)
+			if err == nil {
+				err = acquire(m, l, now)
+			}
+			if err != nil {
+				errReturn = fmt.Errorf("failed to renew %s mapping for port %d of %s: %v", l.Protocol, l.ExternalPort, id, err)
+				continue
+			}
+			renewed = true
+		}
+		if renewed {
+			if err := store.save(id, leases); err != nil {
+				errReturn = err
+			}
+		}
+	}
+	return errReturn
+}
@@ -0,0 +1,431 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a chained plugin that asks an upstream Internet Gateway Device to
+// forward the container's host ports, using NAT-PMP, PCP or UPnP IGD.
+//
+// It is intended to run after portmap: portmap forwards hostPort on the node
+// to the container, and this plugin forwards the same port on the gateway's
+// public address to the node. The mappings obtained are recorded on disk so
+// they can be renewed by "igd renew" and removed on DEL or GC.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/gc"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+const (
+	methodNATPMP = "natpmp"
+	methodPCP    = "pcp"
+	methodUPnP   = "upnp"
+
+	defaultLifetime = 7200
+)
+
+// PortMapEntry corresponds to a single entry in the port_mappings argument,
+// see CONVENTIONS.md
+type PortMapEntry struct {
+	HostPort      int    `json:"hostPort"`
+	ContainerPort int    `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+	HostIP        string `json:"hostIP,omitempty"`
+}
+
+// IGDNetConf represents the igd plugin configuration.
+type IGDNetConf struct {
+	types.NetConf
+
+	// Method is the protocol used to talk to the gateway, "natpmp", "pcp"
+	// or "upnp"
+	Method string `json:"method"`
+	// Gateway is the NAT-PMP or PCP server address; defaults to the default
+	// route's gateway
+	Gateway string `json:"gateway,omitempty"`
+	// Location is the UPnP device description URL; discovered via SSDP if unset
+	Location string `json:"location,omitempty"`
+	// Lifetime is the requested mapping lifetime in seconds
+	Lifetime *uint32 `json:"lifetime,omitempty"`
+	DataDir  string  `json:"dataDir,omitempty"`
+
+	RuntimeConfig struct {
+		PortMaps []PortMapEntry `json:"portMappings,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+}
+
+// mapper adds and deletes mappings on the gateway. addMapping may update
+// the external port and lifetime to the ones actually granted.
+type mapper interface {
+	addMapping(m *lease) error
+	deleteMapping(m *lease) error
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "renew" {
+		var dataDir string
+		renewFlags := flag.NewFlagSet("renew", flag.ExitOnError)
+		renewFlags.StringVar(&dataDir, "datadir", defaultDataDir, "directory the leases are recorded in")
+		renewFlags.Parse(os.Args[2:])
+
+		if err := renewLeases(dataDir, time.Now()); err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Check: cmdCheck,
+		Del:   cmdDel,
+		GC:    cmdGC,
+		/* FIXME Status */
+	}, version.VersionsStartingFrom("0.3.0"), bv.BuildString("igd"))
+}
+
+func parseConfig(stdin []byte) (*IGDNetConf, error) {
+	conf := IGDNetConf{}
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	if conf.RawPrevResult != nil {
+		if err := version.ParsePrevResult(&conf.NetConf); err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+	}
+
+	switch conf.Method {
+	case "":
+		conf.Method = methodNATPMP
+	case methodNATPMP, methodPCP, methodUPnP:
+	default:
+		return nil, fmt.Errorf("unsupported method %q, must be %q, %q or %q", conf.Method, methodNATPMP, methodPCP, methodUPnP)
+	}
+
+	if conf.Gateway != "" && net.ParseIP(conf.Gateway) == nil {
+		return nil, fmt.Errorf("invalid gateway address %q", conf.Gateway)
+	}
+
+	if conf.Lifetime == nil {
+		lifetime := uint32(defaultLifetime)
+		conf.Lifetime = &lifetime
+	}
+	if *conf.Lifetime == 0 && conf.Method != methodUPnP {
+		return nil, fmt.Errorf("%s mappings require a non-zero lifetime", conf.Method)
+	}
+
+	for _, pm := range conf.RuntimeConfig.PortMaps {
+		if pm.HostPort <= 0 || pm.HostPort > 65535 {
+			return nil, fmt.Errorf("invalid host port number: %d", pm.HostPort)
+		}
+	}
+
+	return &conf, nil
+}
+
+// newMapper returns the mapper to create new mappings with.
+func newMapper(conf *IGDNetConf) (mapper, error) {
+	if conf.Method == methodUPnP {
+		return newUPnPClient(conf.Location)
+	}
+
+	gw := net.ParseIP(conf.Gateway)
+	if gw == nil {
+		var err error
+		gw, err = defaultGateway()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.Method == methodPCP {
+		return &pcpClient{gateway: gw}, nil
+	}
+	return &natPMPClient{gateway: gw}, nil
+}
+
+// mapperForLease returns the mapper that can renew or delete l, without
+// rediscovering the gateway.
+func mapperForLease(l *lease) (mapper, error) {
+	switch l.Method {
+	case methodNATPMP, methodPCP:
+		gw := net.ParseIP(l.Gateway)
+		if gw == nil {
+			return nil, fmt.Errorf("lease has invalid gateway %q", l.Gateway)
+		}
+		if l.Method == methodPCP {
+			return &pcpClient{gateway: gw}, nil
+		}
+		return &natPMPClient{gateway: gw}, nil
+	case methodUPnP:
+		return newUPnPLeaseClient(l)
+	}
+	return nil, fmt.Errorf("lease has unknown method %q", l.Method)
+}
+
+// defaultGateway returns the IPv4 gateway of the host's default route.
+func defaultGateway() (net.IP, error) {
+	routes, err := netlink.RouteGet(net.IPv4(192, 0, 2, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up default route: %v", err)
+	}
+	for _, r := range routes {
+		if r.Gw != nil {
+			return r.Gw, nil
+		}
+	}
+	return nil, fmt.Errorf("no default gateway found, set \"gateway\" explicitly")
+}
+
+// wantedLeases returns one lease per distinct protocol and host port. The
+// same port often appears once per IP family, but the gateway only forwards
+// IPv4.
+func wantedLeases(conf *IGDNetConf, containerID string) []*lease {
+	leases := []*lease{}
+	seen := map[string]bool{}
+	for _, pm := range conf.RuntimeConfig.PortMaps {
+		proto := strings.ToLower(pm.Protocol)
+		if proto == "" {
+			proto = "tcp"
+		}
+		key := fmt.Sprintf("%s/%d", proto, pm.HostPort)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		leases = append(leases, &lease{
+			Method:       conf.Method,
+			Protocol:     proto,
+			InternalPort: pm.HostPort,
+			ExternalPort: pm.HostPort,
+			Lifetime:     *conf.Lifetime,
+			Description:  fmt.Sprintf("cni %s %s", conf.Name, containerID),
+		})
+	}
+	return leases
+}
+
+// acquire requests l from the gateway and records when it expires.
+func acquire(m mapper, l *lease, now time.Time) error {
+	switch c := m.(type) {
+	case *natPMPClient:
+		l.Gateway = c.gateway.String()
+	case *pcpClient:
+		l.Gateway = c.gateway.String()
+	}
+	if err := m.addMapping(l); err != nil {
+		return err
+	}
+	if l.Lifetime > 0 {
+		l.Expires = now.Add(time.Duration(l.Lifetime) * time.Second)
+	} else {
+		l.Expires = time.Time{}
+	}
+	return nil
+}
+
+// release deletes all of leases from their gateways. Failures are logged
+// but not returned, as the gateway may well be gone for good.
+func release(leases []*lease) {
+	for _, l := range leases {
+		m, err := mapperForLease(l)
+		if err == nil {
+			err = m.deleteMapping(l)
+		}
+		if err != nil {
+			log.Printf("failed to delete %s mapping for port %d: %v", l.Protocol, l.ExternalPort, err)
+		}
+	}
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	leases := wantedLeases(conf, args.ContainerID)
+	if len(leases) == 0 {
+		return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+	}
+
+	store, err := newLeaseStore(conf.DataDir, conf.Name)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock()
+
+	m, err := newMapper(conf)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, l := range leases {
+		if err := acquire(m, l, now); err != nil {
+			release(leases[:i])
+			return err
+		}
+	}
+
+	if err := store.save(args.ContainerID, leases); err != nil {
+		release(leases)
+		return fmt.Errorf("failed to record leases: %v", err)
+	}
+
+	return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+}
+
+// cmdCheck verifies that unexpired leases were obtained for all host ports.
+// Leases are not renewed here, as CHECK must not change anything: that is
+// left to "igd renew".
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	wanted := wantedLeases(conf, args.ContainerID)
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	store, err := newLeaseStore(conf.DataDir, conf.Name)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.RLock(); err != nil {
+		return err
+	}
+	defer store.RUnlock()
+
+	leases, err := store.load(args.ContainerID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, w := range wanted {
+		var found *lease
+		for _, l := range leases {
+			if l.Protocol == w.Protocol && l.InternalPort == w.InternalPort {
+				found = l
+				break
+			}
+		}
+		if found == nil {
+			return fmt.Errorf("no gateway mapping recorded for %s/%d", w.Protocol, w.InternalPort)
+		}
+		if found.expired(now) {
+			return fmt.Errorf("gateway mapping for %s/%d expired at %s", w.Protocol, w.InternalPort, found.Expires.Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	store, err := newLeaseStore(conf.DataDir, conf.Name)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock()
+
+	leases, err := store.load(args.ContainerID)
+	if err != nil {
+		return err
+	}
+	release(leases)
+
+	return store.remove(args.ContainerID)
+}
+
+// cmdGC releases the leases of any container on this network that the
+// runtime no longer considers valid.
+func cmdGC(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	store, err := newLeaseStore(conf.DataDir, conf.Name)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock()
+
+	valid := gc.NewAttachments(conf.ValidAttachments)
+
+	ids, err := store.containerIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if valid.ValidContainer(id) {
+			continue
+		}
+		leases, err := store.load(id)
+		if err != nil {
+			log.Printf("skipping leases of %s: %v", id, err)
+			continue
+		}
+		release(leases)
+		if err := store.remove(id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
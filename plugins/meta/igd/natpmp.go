@@ -0,0 +1,134 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// natPMPPort is the well-known NAT-PMP server port. It is a var so that
+// tests can point the client at a fake gateway.
+var natPMPPort = 5351
+
+// natPMPInitialTimeout is the initial retransmission timeout of RFC 6886
+// section 3.1. Unlike the RFC we give up after natPMPAttempts, since a CNI
+// invocation shouldn't block for over a minute on an absent gateway.
+var (
+	natPMPInitialTimeout = 250 * time.Millisecond
+	natPMPAttempts       = 4
+)
+
+const (
+	natPMPVersion   = 0
+	natPMPOpMapUDP  = 1
+	natPMPOpMapTCP  = 2
+	natPMPReplyBase = 128
+)
+
+var natPMPResultCodes = map[uint16]string{
+	1: "unsupported version",
+	2: "not authorized/refused",
+	3: "network failure",
+	4: "out of resources",
+	5: "unsupported opcode",
+}
+
+// natPMPClient requests mappings from a NAT-PMP (RFC 6886) gateway.
+type natPMPClient struct {
+	gateway net.IP
+}
+
+func (c *natPMPClient) addMapping(m *lease) error {
+	extPort, lifetime, err := c.request(m.Protocol, m.InternalPort, m.ExternalPort, m.Lifetime)
+	if err != nil {
+		return err
+	}
+	// The gateway may have assigned a different port and lifetime than
+	// the ones we asked for.
+	m.ExternalPort = extPort
+	m.Lifetime = lifetime
+	return nil
+}
+
+func (c *natPMPClient) deleteMapping(m *lease) error {
+	// A mapping is deleted by requesting it with a lifetime of zero. The
+	// suggested external port must also be zero (RFC 6886 section 3.4).
+	_, _, err := c.request(m.Protocol, m.InternalPort, 0, 0)
+	return err
+}
+
+func (c *natPMPClient) request(proto string, internalPort, externalPort int, lifetime uint32) (int, uint32, error) {
+	var op byte
+	switch strings.ToLower(proto) {
+	case "udp":
+		op = natPMPOpMapUDP
+	case "tcp":
+		op = natPMPOpMapTCP
+	default:
+		return 0, 0, fmt.Errorf("NAT-PMP does not support protocol %q", proto)
+	}
+
+	req := make([]byte, 12)
+	req[0] = natPMPVersion
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:], lifetime)
+
+	conn, err := net.Dial("udp", net.JoinHostPort(c.gateway.String(), strconv.Itoa(natPMPPort)))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to contact NAT-PMP gateway %s: %v", c.gateway, err)
+	}
+	defer conn.Close()
+
+	timeout := natPMPInitialTimeout
+	resp := make([]byte, 16)
+	for attempt := 0; attempt < natPMPAttempts; attempt++ {
+		if _, err := conn.Write(req); err != nil {
+			return 0, 0, fmt.Errorf("failed to send NAT-PMP request to %s: %v", c.gateway, err)
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return 0, 0, err
+		}
+		timeout *= 2
+
+		n, err := conn.Read(resp)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return 0, 0, fmt.Errorf("failed to read NAT-PMP response from %s: %v", c.gateway, err)
+		}
+		if n < 16 || resp[0] != natPMPVersion || resp[1] != natPMPReplyBase+op {
+			// Not an answer to our request; try again
+			continue
+		}
+		if code := binary.BigEndian.Uint16(resp[2:]); code != 0 {
+			reason, ok := natPMPResultCodes[code]
+			if !ok {
+				reason = fmt.Sprintf("result code %d", code)
+			}
+			return 0, 0, fmt.Errorf("NAT-PMP gateway %s refused mapping for %s/%d: %s", c.gateway, proto, internalPort, reason)
+		}
+		return int(binary.BigEndian.Uint16(resp[10:])), binary.BigEndian.Uint32(resp[12:]), nil
+	}
+
+	return 0, 0, fmt.Errorf("no response from NAT-PMP gateway %s", c.gateway)
+}
@@ -0,0 +1,163 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PCP succeeds NAT-PMP and is served on the same port, so the client uses
+// the same port and retransmission as the NAT-PMP one.
+
+const (
+	pcpVersion   = 2
+	pcpOpMap     = 1
+	pcpReplyBit  = 0x80
+	pcpHeaderLen = 24
+	pcpMapLen    = 36
+	pcpNonceLen  = 12
+	pcpProtoTCP  = 6
+	pcpProtoUDP  = 17
+)
+
+var pcpResultCodes = map[byte]string{
+	1:  "unsupported version",
+	2:  "not authorized",
+	3:  "malformed request",
+	4:  "unsupported opcode",
+	5:  "unsupported option",
+	6:  "malformed option",
+	7:  "network failure",
+	8:  "no resources",
+	9:  "unsupported protocol",
+	10: "user exceeded quota",
+	11: "cannot provide external address or port",
+	12: "address mismatch",
+	13: "excessive remote peers",
+}
+
+// pcpClient requests mappings from a PCP (RFC 6887) server with the MAP
+// opcode.
+type pcpClient struct {
+	gateway net.IP
+}
+
+func (c *pcpClient) addMapping(m *lease) error {
+	// The nonce identifies the mapping to the server, so that it can be
+	// renewed and deleted later on.
+	if m.Nonce == "" {
+		nonce := make([]byte, pcpNonceLen)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		m.Nonce = hex.EncodeToString(nonce)
+	}
+	extPort, lifetime, err := c.request(m, m.ExternalPort, m.Lifetime)
+	if err != nil {
+		return err
+	}
+	m.ExternalPort = extPort
+	m.Lifetime = lifetime
+	return nil
+}
+
+func (c *pcpClient) deleteMapping(m *lease) error {
+	// A mapping is deleted by requesting it with a lifetime of zero (RFC
+	// 6887 section 15).
+	_, _, err := c.request(m, 0, 0)
+	return err
+}
+
+func (c *pcpClient) request(m *lease, externalPort int, lifetime uint32) (int, uint32, error) {
+	var proto byte
+	switch strings.ToLower(m.Protocol) {
+	case "udp":
+		proto = pcpProtoUDP
+	case "tcp":
+		proto = pcpProtoTCP
+	default:
+		return 0, 0, fmt.Errorf("PCP does not support protocol %q", m.Protocol)
+	}
+	nonce, err := hex.DecodeString(m.Nonce)
+	if err != nil || len(nonce) != pcpNonceLen {
+		return 0, 0, fmt.Errorf("lease has invalid PCP nonce %q", m.Nonce)
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(c.gateway.String(), strconv.Itoa(natPMPPort)))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to contact PCP server %s: %v", c.gateway, err)
+	}
+	defer conn.Close()
+
+	// The client address is the one the server sees the request from
+	clientIP := conn.LocalAddr().(*net.UDPAddr).IP
+
+	req := make([]byte, pcpHeaderLen+pcpMapLen)
+	req[0] = pcpVersion
+	req[1] = pcpOpMap
+	binary.BigEndian.PutUint32(req[4:], lifetime)
+	copy(req[8:24], clientIP.To16())
+	copy(req[24:36], nonce)
+	req[36] = proto
+	binary.BigEndian.PutUint16(req[40:], uint16(m.InternalPort))
+	binary.BigEndian.PutUint16(req[42:], uint16(externalPort))
+	// No preference for the external address, as an IPv4-mapped 0.0.0.0
+	copy(req[44:60], net.IPv4zero.To16())
+
+	timeout := natPMPInitialTimeout
+	resp := make([]byte, 1100)
+	for attempt := 0; attempt < natPMPAttempts; attempt++ {
+		if _, err := conn.Write(req); err != nil {
+			return 0, 0, fmt.Errorf("failed to send PCP request to %s: %v", c.gateway, err)
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return 0, 0, err
+		}
+		timeout *= 2
+
+		n, err := conn.Read(resp)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return 0, 0, fmt.Errorf("failed to read PCP response from %s: %v", c.gateway, err)
+		}
+		if n < pcpHeaderLen || resp[0] != pcpVersion || resp[1] != pcpReplyBit|pcpOpMap {
+			// Not an answer to our request; try again
+			continue
+		}
+		if code := resp[3]; code != 0 {
+			reason, ok := pcpResultCodes[code]
+			if !ok {
+				reason = fmt.Sprintf("result code %d", code)
+			}
+			return 0, 0, fmt.Errorf("PCP server %s refused mapping for %s/%d: %s", c.gateway, m.Protocol, m.InternalPort, reason)
+		}
+		if n < pcpHeaderLen+pcpMapLen || !bytes.Equal(resp[24:36], nonce) {
+			continue
+		}
+		return int(binary.BigEndian.Uint16(resp[42:])), binary.BigEndian.Uint32(resp[4:]), nil
+	}
+
+	return 0, 0, fmt.Errorf("no response from PCP server %s", c.gateway)
+}
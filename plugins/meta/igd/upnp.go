@@ -0,0 +1,289 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddr    = "239.255.255.250:1900"
+	ssdpTimeout = 2 * time.Second
+)
+
+// The WAN connection services that can hold port mappings, in order of
+// preference.
+var wanServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnpClient requests mappings from a UPnP Internet Gateway Device.
+type upnpClient struct {
+	// controlURL and serviceType identify the WAN connection service.
+	controlURL  string
+	serviceType string
+	// internalClient is our address as seen by the gateway
+	internalClient string
+	httpClient     *http.Client
+}
+
+// newUPnPClient locates the WAN connection service of the gateway. If
+// location is empty, the device description URL is discovered via SSDP.
+func newUPnPClient(location string) (*upnpClient, error) {
+	if location == "" {
+		var err error
+		location, err = discoverIGD()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := &upnpClient{httpClient: &http.Client{Timeout: 5 * time.Second}}
+	if err := c.loadDescription(location); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newUPnPLeaseClient returns a client for the service that granted l.
+func newUPnPLeaseClient(l *lease) (*upnpClient, error) {
+	if l.ControlURL == "" || l.ServiceType == "" {
+		return nil, fmt.Errorf("lease has no UPnP control URL")
+	}
+	u, err := url.Parse(l.ControlURL)
+	if err != nil {
+		return nil, fmt.Errorf("lease has invalid control URL %q: %v", l.ControlURL, err)
+	}
+
+	c := &upnpClient{
+		controlURL:  l.ControlURL,
+		serviceType: l.ServiceType,
+		httpClient:  &http.Client{Timeout: 5 * time.Second},
+	}
+	if err := c.setInternalClient(u.Host); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// discoverIGD sends an SSDP M-SEARCH for a WAN connection service and
+// returns the LOCATION of the first device that answers.
+func discoverIGD() (string, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to open SSDP socket: %v", err)
+	}
+	defer conn.Close()
+
+	for _, st := range wanServiceTypes {
+		msg := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + ssdpAddr + "\r\n" +
+			"ST: " + st + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n\r\n"
+		if _, err := conn.WriteTo([]byte(msg), raddr); err != nil {
+			return "", fmt.Errorf("failed to send SSDP search: %v", err)
+		}
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(ssdpTimeout)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no UPnP gateway found: %v", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if loc := resp.Header.Get("Location"); loc != "" {
+			return loc, nil
+		}
+	}
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// findServices returns all services of d and its embedded devices.
+func (d *upnpDevice) findServices() []upnpService {
+	services := append([]upnpService{}, d.Services...)
+	for i := range d.Devices {
+		services = append(services, d.Devices[i].findServices()...)
+	}
+	return services
+}
+
+// loadDescription fetches the device description at location and records
+// the control URL of its preferred WAN connection service.
+func (c *upnpClient) loadDescription(location string) error {
+	base, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("invalid IGD location %q: %v", location, err)
+	}
+
+	resp, err := c.httpClient.Get(location)
+	if err != nil {
+		return fmt.Errorf("failed to fetch IGD description from %s: %v", location, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch IGD description from %s: %s", location, resp.Status)
+	}
+
+	root := upnpRoot{}
+	if err := xml.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return fmt.Errorf("failed to parse IGD description from %s: %v", location, err)
+	}
+	if root.URLBase != "" {
+		if b, err := url.Parse(root.URLBase); err == nil {
+			base = b
+		}
+	}
+
+	services := root.Device.findServices()
+	for _, st := range wanServiceTypes {
+		for _, svc := range services {
+			if strings.TrimSpace(svc.ServiceType) != st {
+				continue
+			}
+			ctrl, err := base.Parse(strings.TrimSpace(svc.ControlURL))
+			if err != nil {
+				return fmt.Errorf("invalid control URL %q: %v", svc.ControlURL, err)
+			}
+			c.controlURL = ctrl.String()
+			c.serviceType = st
+			return c.setInternalClient(ctrl.Host)
+		}
+	}
+
+	return fmt.Errorf("IGD at %s has no WAN connection service", location)
+}
+
+// setInternalClient determines the local address used to reach host.
+func (c *upnpClient) setInternalClient(host string) error {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return fmt.Errorf("failed to determine local address towards %s: %v", host, err)
+	}
+	defer conn.Close()
+	c.internalClient = conn.LocalAddr().(*net.UDPAddr).IP.String()
+	return nil
+}
+
+func (c *upnpClient) addMapping(m *lease) error {
+	m.ControlURL = c.controlURL
+	m.ServiceType = c.serviceType
+	return c.soap(m.ControlURL, m.ServiceType, "AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", fmt.Sprint(m.ExternalPort)},
+		{"NewProtocol", strings.ToUpper(m.Protocol)},
+		{"NewInternalPort", fmt.Sprint(m.InternalPort)},
+		{"NewInternalClient", c.internalClient},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", m.Description},
+		{"NewLeaseDuration", fmt.Sprint(m.Lifetime)},
+	})
+}
+
+func (c *upnpClient) deleteMapping(m *lease) error {
+	return c.soap(m.ControlURL, m.ServiceType, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", fmt.Sprint(m.ExternalPort)},
+		{"NewProtocol", strings.ToUpper(m.Protocol)},
+	})
+}
+
+// soap invokes action on the service at controlURL. Arguments are ordered,
+// as some gateways reject requests whose arguments are out of order.
+func (c *upnpClient) soap(controlURL, serviceType, action string, args [][2]string) error {
+	body := &bytes.Buffer{}
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + serviceType + `">`)
+	for _, arg := range args {
+		body.WriteString("<" + arg[0] + ">")
+		if err := xml.EscapeText(body, []byte(arg[1])); err != nil {
+			return err
+		}
+		body.WriteString("</" + arg[0] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	req, err := http.NewRequest(http.MethodPost, controlURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+"#"+action+`"`)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("UPnP %s failed: %v", action, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("UPnP %s failed: %s: %s", action, resp.Status, upnpErrorDescription(msg))
+	}
+	return nil
+}
+
+// upnpErrorDescription extracts the error description from a SOAP fault,
+// falling back to the raw body.
+func upnpErrorDescription(body []byte) string {
+	fault := struct {
+		Code        string `xml:"Body>Fault>detail>UPnPError>errorCode"`
+		Description string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+	}{}
+	if err := xml.Unmarshal(body, &fault); err != nil || fault.Code == "" {
+		return strings.TrimSpace(string(body))
+	}
+	return fmt.Sprintf("%s (error %s)", fault.Description, fault.Code)
+}
@@ -0,0 +1,369 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a chained plugin encrypting the link of the container with a
+// MACsec device created over its interface, which takes over its addresses
+// and routes.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+const (
+	defaultMacsecName  = "macsec0"
+	defaultCipherSuite = "gcm-aes-128"
+	defaultPort        = 1
+)
+
+// SAConf is a secure association, with its key.
+type SAConf struct {
+	// AN is the association number, from 0 to 3
+	AN    int    `json:"an,omitempty"`
+	KeyID string `json:"keyID"`
+	Key   string `json:"key"`
+}
+
+// PeerConf is a peer, from which the packets are received.
+type PeerConf struct {
+	Address string `json:"address"`
+	Port    int    `json:"port,omitempty"`
+	SA      SAConf `json:"sa"`
+}
+
+// KeysConf holds the keys of the device, which are better passed in
+// runtimeConfig than in the network configuration.
+type KeysConf struct {
+	TxSA  *SAConf    `json:"txSA,omitempty"`
+	Peers []PeerConf `json:"peers,omitempty"`
+}
+
+type MacsecConf struct {
+	types.NetConf
+	KeysConf
+
+	MacsecName  string `json:"macsecName,omitempty"`
+	CipherSuite string `json:"cipherSuite,omitempty"`
+	Encrypt     *bool  `json:"encrypt,omitempty"`
+	Port        int    `json:"port,omitempty"`
+
+	RuntimeConfig struct {
+		Macsec *KeysConf `json:"macsec,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+// parseConfig parses the supplied configuration (and prevResult) from stdin.
+func parseConfig(stdin []byte) (*MacsecConf, error) {
+	conf := &MacsecConf{
+		MacsecName:  defaultMacsecName,
+		CipherSuite: defaultCipherSuite,
+		Port:        defaultPort,
+	}
+	if err := json.Unmarshal(stdin, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	if conf.RawPrevResult != nil {
+		if err := version.ParsePrevResult(&conf.NetConf); err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+		if _, err := current.NewResultFromResult(conf.PrevResult); err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	if conf.MacsecName == "" {
+		return nil, fmt.Errorf("macsecName must not be empty")
+	}
+	if _, ok := cipherSuites[conf.CipherSuite]; !ok {
+		return nil, fmt.Errorf("unsupported cipher suite %q", conf.CipherSuite)
+	}
+	if conf.Port < 1 || conf.Port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", conf.Port)
+	}
+
+	// The runtime transmit association takes precedence, its peers are
+	// added to those of the configuration
+	if rc := conf.RuntimeConfig.Macsec; rc != nil {
+		if rc.TxSA != nil {
+			conf.TxSA = rc.TxSA
+		}
+		conf.Peers = append(append([]PeerConf{}, conf.Peers...), rc.Peers...)
+	}
+
+	return conf, nil
+}
+
+func parseSA(sa *SAConf, keyLen int) (*secureAssoc, error) {
+	if sa.AN < 0 || sa.AN > 3 {
+		return nil, fmt.Errorf("invalid association number %d", sa.AN)
+	}
+	keyID, err := hex.DecodeString(sa.KeyID)
+	if err != nil || len(keyID) == 0 || len(keyID) > keyIDLen {
+		return nil, fmt.Errorf("invalid key ID %q: must be 1 to %d hex encoded bytes", sa.KeyID, keyIDLen)
+	}
+	key, err := hex.DecodeString(sa.Key)
+	if err != nil || len(key) != keyLen {
+		return nil, fmt.Errorf("invalid key of association %d: must be %d hex encoded bytes", sa.AN, keyLen)
+	}
+
+	// Key IDs are padded with zeroes, as by ip-macsec(8)
+	return &secureAssoc{
+		an:    uint8(sa.AN),
+		keyID: append(keyID, make([]byte, keyIDLen-len(keyID))...),
+		key:   key,
+	}, nil
+}
+
+// newSecy returns the secure entity of the configuration.
+func newSecy(conf *MacsecConf) (*secy, error) {
+	s := &secy{
+		port:        uint16(conf.Port),
+		cipherSuite: cipherSuites[conf.CipherSuite],
+		encrypt:     conf.Encrypt == nil || *conf.Encrypt,
+	}
+
+	if conf.TxSA == nil {
+		return nil, fmt.Errorf("no transmit secure association configured")
+	}
+	txSA, err := parseSA(conf.TxSA, s.cipherSuite.keyLen)
+	if err != nil {
+		return nil, err
+	}
+	s.txSA = *txSA
+
+	if len(conf.Peers) == 0 {
+		return nil, fmt.Errorf("no peers configured")
+	}
+	for _, p := range conf.Peers {
+		mac, err := net.ParseMAC(p.Address)
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("invalid peer address %q", p.Address)
+		}
+		port := p.Port
+		if port == 0 {
+			port = defaultPort
+		}
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %d of peer %s", p.Port, p.Address)
+		}
+		sa, err := parseSA(&p.SA, s.cipherSuite.keyLen)
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: %v", p.Address, err)
+		}
+		s.rxChannels = append(s.rxChannels, rxChannel{sci: sci(mac, uint16(port)), sa: *sa})
+	}
+	return s, nil
+}
+
+// interfaceIndex returns the index of the interface of the container in
+// result, or -1.
+func interfaceIndex(result *current.Result, name, netns string) int {
+	for i, intf := range result.Interfaces {
+		if intf.Name == name && intf.Sandbox == netns {
+			return i
+		}
+	}
+	return -1
+}
+
+// moveConfig moves the addresses and routes of the interface of lowerIdx
+// in result from lower to the MACsec device, the interface of macsecIdx.
+func moveConfig(result *current.Result, lower netlink.Link, lowerIdx, macsecIdx int) error {
+	moved := &current.Result{
+		Interfaces: result.Interfaces,
+		Routes:     result.Routes,
+	}
+
+	// The routes may be gone with the addresses
+	for _, r := range result.Routes {
+		dst := r.Dst
+		_ = netlink.RouteDel(&netlink.Route{LinkIndex: lower.Attrs().Index, Dst: &dst, Gw: r.GW})
+	}
+
+	for _, ipc := range result.IPs {
+		if ipc.Interface != nil && *ipc.Interface != lowerIdx {
+			continue
+		}
+		addr := &netlink.Addr{IPNet: &ipc.Address}
+		if err := netlink.AddrDel(lower, addr); err != nil {
+			return fmt.Errorf("failed to remove IP addr %v from %q: %v", ipc.Address.String(), lower.Attrs().Name, err)
+		}
+		ipc.Interface = current.Int(macsecIdx)
+		moved.IPs = append(moved.IPs, ipc)
+	}
+
+	return ipam.ConfigureIface(result.Interfaces[macsecIdx].Name, moved)
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("missing prevResult from earlier plugin")
+	}
+	result, err := current.NewResultFromResult(conf.PrevResult)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSecy(conf)
+	if err != nil {
+		return err
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		lower, err := netlinksafe.LinkByName(args.IfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", args.IfName, err)
+		}
+
+		if err := addLink(conf.MacsecName, lower.Attrs().Index, s); err != nil {
+			return err
+		}
+		link, err := netlinksafe.LinkByName(conf.MacsecName)
+		if err == nil {
+			err = configureSecy(link.Attrs().Index, s)
+		}
+		if err == nil {
+			result.Interfaces = append(result.Interfaces, &current.Interface{
+				Name:    conf.MacsecName,
+				Mac:     link.Attrs().HardwareAddr.String(),
+				Sandbox: args.Netns,
+			})
+			err = moveConfig(result, lower, interfaceIndex(result, args.IfName, args.Netns), len(result.Interfaces)-1)
+		}
+		if err != nil {
+			_ = ip.DelLinkByName(conf.MacsecName)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return types.PrintResult(result, conf.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if args.Netns == "" {
+		return nil
+	}
+
+	// The addresses are not moved back, as the interface is about to be
+	// removed by the previous plugin
+	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		if err := ip.DelLinkByName(conf.MacsecName); err != nil && err != ip.ErrLinkNotFound {
+			return err
+		}
+		return nil
+	})
+	if _, ok := err.(ns.NSPathNotExistErr); ok {
+		return nil
+	}
+	return err
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("missing prevResult from earlier plugin")
+	}
+	result, err := current.NewResultFromResult(conf.PrevResult)
+	if err != nil {
+		return err
+	}
+
+	macsecIdx := interfaceIndex(result, conf.MacsecName, args.Netns)
+	if macsecIdx < 0 {
+		return fmt.Errorf("MACsec device %s not found in prevResult", conf.MacsecName)
+	}
+	var ips []*current.IPConfig
+	for _, ipc := range result.IPs {
+		if ipc.Interface != nil && *ipc.Interface == macsecIdx {
+			ips = append(ips, ipc)
+		}
+	}
+
+	return ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		lower, err := netlinksafe.LinkByName(args.IfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", args.IfName, err)
+		}
+		link, err := netlinksafe.LinkByName(conf.MacsecName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", conf.MacsecName, err)
+		}
+		if link.Type() != "macsec" {
+			return fmt.Errorf("interface %s is of type %s, expected macsec", conf.MacsecName, link.Type())
+		}
+		if link.Attrs().ParentIndex != lower.Attrs().Index {
+			return fmt.Errorf("MACsec device %s is not over %s", conf.MacsecName, args.IfName)
+		}
+
+		if err := ip.ValidateExpectedInterfaceIPs(conf.MacsecName, ips); err != nil {
+			return err
+		}
+		return ip.ValidateExpectedRoute(result.Routes)
+	})
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Check: cmdCheck,
+		Del:   cmdDel,
+	}, version.All, bv.BuildString("macsec"))
+}
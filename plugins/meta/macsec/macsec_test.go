@@ -0,0 +1,239 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+// Key of the GCM-AES-128 test vectors of IEEE 802.1AE
+const testKey = "ad7a2bd03eac835a6f620fdcb506b345"
+
+var _ = Describe("macsec plugin", func() {
+	keys := fmt.Sprintf(`"runtimeConfig": {"macsec": {
+		"txSA": {"keyID": "01", "key": %q},
+		"peers": [{"address": "02:00:00:00:00:02", "sa": {"keyID": "02", "key": %q}}]
+	}},`, testKey, testKey)
+
+	Describe("configuration", func() {
+		It("takes the keys from runtimeConfig", func() {
+			conf, err := parseConfig([]byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "macsec",
+				%s
+				"port": 2, "encrypt": false,
+				"prevResult": {"cniVersion": "1.0.0"}
+			}`, keys)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.MacsecName).To(Equal("macsec0"))
+			Expect(conf.CipherSuite).To(Equal("gcm-aes-128"))
+
+			s, err := newSecy(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.port).To(Equal(uint16(2)))
+			Expect(s.encrypt).To(BeFalse())
+			Expect(s.txSA.keyID).To(Equal(append([]byte{1}, make([]byte, keyIDLen-1)...)))
+			Expect(s.rxChannels).To(HaveLen(1))
+			Expect(s.rxChannels[0].sci).To(Equal([]byte{2, 0, 0, 0, 0, 2, 0, 1}))
+		})
+
+		It("rejects invalid configurations", func() {
+			for _, conf := range []string{
+				`{"macsecName": ""}`,
+				`{"cipherSuite": "gcm-aes-xpn-128"}`,
+				`{"port": 0}`,
+			} {
+				_, err := parseConfig([]byte(conf))
+				Expect(err).To(HaveOccurred(), conf)
+			}
+
+			for _, conf := range []string{
+				`{}`,
+				fmt.Sprintf(`{"txSA": {"keyID": "01", "key": %q}}`, testKey),
+				fmt.Sprintf(`{"txSA": {"an": 4, "keyID": "01", "key": %q}, "peers": [{"address": "02:00:00:00:00:02", "sa": {"keyID": "02", "key": %q}}]}`, testKey, testKey),
+				fmt.Sprintf(`{"txSA": {"keyID": "", "key": %q}, "peers": [{"address": "02:00:00:00:00:02", "sa": {"keyID": "02", "key": %q}}]}`, testKey, testKey),
+				fmt.Sprintf(`{"txSA": {"keyID": "01", "key": %q}, "peers": [{"address": "02:00:00:00:00:02", "sa": {"keyID": "02", "key": %q}}]}`, testKey+testKey, testKey),
+				fmt.Sprintf(`{"txSA": {"keyID": "01", "key": %q}, "peers": [{"address": "foo", "sa": {"keyID": "02", "key": %q}}]}`, testKey, testKey),
+				fmt.Sprintf(`{"txSA": {"keyID": "01", "key": %q}, "peers": [{"address": "02:00:00:00:00:02", "port": 65536, "sa": {"keyID": "02", "key": %q}}]}`, testKey, testKey),
+			} {
+				c, err := parseConfig([]byte(conf))
+				Expect(err).NotTo(HaveOccurred(), conf)
+				_, err = newSecy(c)
+				Expect(err).To(HaveOccurred(), conf)
+			}
+		})
+	})
+
+	Describe("MACsec devices", func() {
+		var originalNS, targetNs ns.NetNS
+		var args *skel.CmdArgs
+		var prevResult string
+
+		BeforeEach(func() {
+			var err error
+			originalNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			targetNs, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+
+			args = &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNs.Path(),
+				IfName:      "eth0",
+			}
+			prevResult = fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": %q}],
+				"ips": [{"address": "10.1.2.3/24", "interface": 0}],
+				"routes": [{"dst": "10.2.0.0/16", "gw": "10.1.2.1"}]
+			}`, targetNs.Path())
+
+			// The interface as configured by the previous plugin
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "peer0"}
+				if err := netlink.LinkAdd(veth); err != nil {
+					return err
+				}
+				link, err := netlinksafe.LinkByName("eth0")
+				if err != nil {
+					return err
+				}
+				if err := netlink.LinkSetUp(link); err != nil {
+					return err
+				}
+				addr, err := netlink.ParseAddr("10.1.2.3/24")
+				if err != nil {
+					return err
+				}
+				if err := netlink.AddrAdd(link, addr); err != nil {
+					return err
+				}
+				_, dst, _ := net.ParseCIDR("10.2.0.0/16")
+				return netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Gw: net.ParseIP("10.1.2.1")})
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(originalNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(originalNS)).To(Succeed())
+			Expect(targetNs.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(targetNs)).To(Succeed())
+		})
+
+		It("moves the configuration of the interface to a MACsec device", func() {
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "macsec",
+				%s
+				"prevResult": %s
+			}`, keys, prevResult))
+
+			var result *current.Result
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				r, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				if err != nil {
+					return err
+				}
+				result, err = current.GetResult(r)
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(2))
+			Expect(result.Interfaces[1].Name).To(Equal("macsec0"))
+			Expect(*result.IPs[0].Interface).To(Equal(1))
+
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				lower, err := netlinksafe.LinkByName("eth0")
+				if err != nil {
+					return err
+				}
+				addrs, err := netlinksafe.AddrList(lower, netlink.FAMILY_V4)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addrs).To(BeEmpty())
+
+				link, err := netlinksafe.LinkByName("macsec0")
+				if err != nil {
+					return err
+				}
+				Expect(link.Type()).To(Equal("macsec"))
+				Expect(link.Attrs().ParentIndex).To(Equal(lower.Attrs().Index))
+				addrs, err = netlinksafe.AddrList(link, netlink.FAMILY_V4)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addrs).To(HaveLen(1))
+				Expect(addrs[0].IPNet.String()).To(Equal("10.1.2.3/24"))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			r, err := json.Marshal(result)
+			Expect(err).NotTo(HaveOccurred())
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "macsec",
+				%s
+				"prevResult": %s
+			}`, keys, string(r)))
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = targetNs.Do(func(_ ns.NetNS) error {
+				_, err := netlinksafe.LinkByName("macsec0")
+				return err
+			})
+			Expect(err).To(HaveOccurred())
+
+			err = originalNS.Do(func(_ ns.NetNS) error {
+				return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("requires keys", func() {
+			args.StdinData = []byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "macsec",
+				"prevResult": %s
+			}`, prevResult))
+			err := originalNS.Do(func(_ ns.NetNS) error {
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				return err
+			})
+			Expect(err).To(MatchError("no transmit secure association configured"))
+		})
+	})
+})
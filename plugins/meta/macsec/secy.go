@@ -0,0 +1,181 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// MACsec devices are created over rtnetlink, and their secure channels and
+// associations are configured over the "macsec" generic netlink family, see
+// include/uapi/linux/if_macsec.h.
+
+const (
+	macsecGenlName    = "macsec"
+	macsecGenlVersion = 1
+
+	macsecCmdAddRxSC = 1
+	macsecCmdAddTxSA = 4
+	macsecCmdAddRxSA = 7
+
+	macsecAttrIfIndex    = 1
+	macsecAttrRxSCConfig = 2
+	macsecAttrSAConfig   = 3
+
+	macsecRxSCAttrSCI    = 1
+	macsecRxSCAttrActive = 2
+
+	macsecSAAttrAN     = 1
+	macsecSAAttrActive = 2
+	macsecSAAttrPN     = 3
+	macsecSAAttrKeyID  = 6
+	macsecSAAttrKey    = 7
+
+	keyIDLen = 16
+	icvLen   = 16
+	// initialPN is the first packet number of the associations
+	initialPN = 1
+)
+
+type cipherSuite struct {
+	id     uint64
+	keyLen int
+}
+
+// The extended packet number suites need salts and short secure channel
+// identifiers, which are not supported
+var cipherSuites = map[string]cipherSuite{
+	"gcm-aes-128": {id: 0x0080C20001000001, keyLen: 16},
+	"gcm-aes-256": {id: 0x0080C20001000002, keyLen: 32},
+}
+
+// secureAssoc is a secure association, with its secure association key.
+type secureAssoc struct {
+	an    uint8
+	keyID []byte
+	key   []byte
+}
+
+// rxChannel is a receive secure channel, from a peer.
+type rxChannel struct {
+	sci []byte
+	sa  secureAssoc
+}
+
+// secy is the configuration of a MACsec device, its secure entity.
+type secy struct {
+	port        uint16
+	cipherSuite cipherSuite
+	encrypt     bool
+	txSA        secureAssoc
+	rxChannels  []rxChannel
+}
+
+// sci returns the secure channel identifier of a port of mac, in network
+// byte order.
+func sci(mac net.HardwareAddr, port uint16) []byte {
+	return append(append([]byte{}, mac...), nl.BEUint16Attr(port)...)
+}
+
+// linkInfo returns the IFLA_LINKINFO attribute of a MACsec device.
+func linkInfo(s *secy) *nl.RtAttr {
+	var encrypt uint8
+	if s.encrypt {
+		encrypt = 1
+	}
+
+	info := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	info.AddRtAttr(nl.IFLA_INFO_KIND, nl.NonZeroTerminated("macsec"))
+	data := info.AddRtAttr(nl.IFLA_INFO_DATA, nil)
+	data.AddRtAttr(unix.IFLA_MACSEC_PORT, nl.BEUint16Attr(s.port))
+	data.AddRtAttr(unix.IFLA_MACSEC_CIPHER_SUITE, nl.Uint64Attr(s.cipherSuite.id))
+	data.AddRtAttr(unix.IFLA_MACSEC_ICV_LEN, nl.Uint8Attr(icvLen))
+	data.AddRtAttr(unix.IFLA_MACSEC_ENCODING_SA, nl.Uint8Attr(s.txSA.an))
+	data.AddRtAttr(unix.IFLA_MACSEC_ENCRYPT, nl.Uint8Attr(encrypt))
+	return info
+}
+
+// addLink creates the MACsec device name over the link of parentIndex.
+func addLink(name string, parentIndex int, s *secy) error {
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(unix.IFLA_IFNAME, nl.ZeroTerminated(name)))
+	req.AddData(nl.NewRtAttr(unix.IFLA_LINK, nl.Uint32Attr(uint32(parentIndex))))
+	req.AddData(linkInfo(s))
+	if _, err := req.Execute(unix.NETLINK_ROUTE, 0); err != nil {
+		return fmt.Errorf("failed to create MACsec device %q: %v", name, err)
+	}
+	return nil
+}
+
+// saAttr returns the MACSEC_ATTR_SA_CONFIG attribute of sa.
+func saAttr(sa *secureAssoc) *nl.RtAttr {
+	attr := nl.NewRtAttr(unix.NLA_F_NESTED|macsecAttrSAConfig, nil)
+	attr.AddRtAttr(macsecSAAttrAN, nl.Uint8Attr(sa.an))
+	attr.AddRtAttr(macsecSAAttrActive, nl.Uint8Attr(1))
+	attr.AddRtAttr(macsecSAAttrPN, nl.Uint32Attr(initialPN))
+	attr.AddRtAttr(macsecSAAttrKeyID, sa.keyID)
+	attr.AddRtAttr(macsecSAAttrKey, sa.key)
+	return attr
+}
+
+// rxSCAttr returns the MACSEC_ATTR_RXSC_CONFIG attribute of the channel of
+// sci, with active set if the channel is to be activated.
+func rxSCAttr(sci []byte, active bool) *nl.RtAttr {
+	attr := nl.NewRtAttr(unix.NLA_F_NESTED|macsecAttrRxSCConfig, nil)
+	attr.AddRtAttr(macsecRxSCAttrSCI, sci)
+	if active {
+		attr.AddRtAttr(macsecRxSCAttrActive, nl.Uint8Attr(1))
+	}
+	return attr
+}
+
+func genlRequest(cmd uint8, index int, attrs ...*nl.RtAttr) error {
+	family, err := netlink.GenlFamilyGet(macsecGenlName)
+	if err != nil {
+		return fmt.Errorf("failed to find the %s generic netlink family: %v", macsecGenlName, err)
+	}
+
+	req := nl.NewNetlinkRequest(int(family.ID), unix.NLM_F_ACK)
+	req.AddData(&nl.Genlmsg{Command: cmd, Version: macsecGenlVersion})
+	req.AddData(nl.NewRtAttr(macsecAttrIfIndex, nl.Uint32Attr(uint32(index))))
+	for _, attr := range attrs {
+		req.AddData(attr)
+	}
+	_, err = req.Execute(unix.NETLINK_GENERIC, 0)
+	return err
+}
+
+// configureSecy adds the secure associations of s to the device of index.
+func configureSecy(index int, s *secy) error {
+	if err := genlRequest(macsecCmdAddTxSA, index, saAttr(&s.txSA)); err != nil {
+		return fmt.Errorf("failed to add transmit secure association %d: %v", s.txSA.an, err)
+	}
+
+	for _, rx := range s.rxChannels {
+		if err := genlRequest(macsecCmdAddRxSC, index, rxSCAttr(rx.sci, true)); err != nil {
+			return fmt.Errorf("failed to add receive secure channel %x: %v", rx.sci, err)
+		}
+		if err := genlRequest(macsecCmdAddRxSA, index, rxSCAttr(rx.sci, false), saAttr(&rx.sa)); err != nil {
+			return fmt.Errorf("failed to add receive secure association %d of %x: %v", rx.sa.an, rx.sci, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// parseAttrs returns the attributes of the nested attr by type.
+func parseAttrs(attr *nl.RtAttr) map[uint16][]byte {
+	b := attr.Serialize()
+	parsed, err := nl.ParseRouteAttr(b[unix.SizeofRtAttr:])
+	Expect(err).NotTo(HaveOccurred())
+
+	attrs := map[uint16][]byte{}
+	for _, a := range parsed {
+		attrs[a.Attr.Type&^unix.NLA_F_NESTED] = a.Value
+	}
+	return attrs
+}
+
+var _ = Describe("macsec secure entity", func() {
+	It("identifies secure channels by address and port", func() {
+		mac, err := net.ParseMAC("02:00:00:00:00:01")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sci(mac, 1)).To(Equal([]byte{2, 0, 0, 0, 0, 1, 0, 1}))
+	})
+
+	It("encodes the device attributes", func() {
+		s := &secy{
+			port:        2,
+			cipherSuite: cipherSuites["gcm-aes-256"],
+			encrypt:     true,
+			txSA:        secureAssoc{an: 3},
+		}
+		info := parseAttrs(linkInfo(s))
+		Expect(string(info[nl.IFLA_INFO_KIND])).To(Equal("macsec"))
+
+		data, err := nl.ParseRouteAttr(info[nl.IFLA_INFO_DATA])
+		Expect(err).NotTo(HaveOccurred())
+		attrs := map[uint16][]byte{}
+		for _, a := range data {
+			attrs[a.Attr.Type] = a.Value
+		}
+		Expect(attrs[unix.IFLA_MACSEC_PORT]).To(Equal([]byte{0, 2}))
+		Expect(nl.NativeEndian().Uint64(attrs[unix.IFLA_MACSEC_CIPHER_SUITE])).To(Equal(uint64(0x0080C20001000002)))
+		Expect(attrs[unix.IFLA_MACSEC_ICV_LEN]).To(Equal([]byte{icvLen}))
+		Expect(attrs[unix.IFLA_MACSEC_ENCODING_SA]).To(Equal([]byte{3}))
+		Expect(attrs[unix.IFLA_MACSEC_ENCRYPT]).To(Equal([]byte{1}))
+	})
+
+	It("encodes the secure associations", func() {
+		sa := &secureAssoc{an: 1, keyID: make([]byte, keyIDLen), key: make([]byte, 16)}
+		attrs := parseAttrs(saAttr(sa))
+		Expect(attrs[macsecSAAttrAN]).To(Equal([]byte{1}))
+		Expect(attrs[macsecSAAttrActive]).To(Equal([]byte{1}))
+		Expect(nl.NativeEndian().Uint32(attrs[macsecSAAttrPN])).To(Equal(uint32(initialPN)))
+		Expect(attrs[macsecSAAttrKeyID]).To(HaveLen(keyIDLen))
+		Expect(attrs[macsecSAAttrKey]).To(HaveLen(16))
+
+		attrs = parseAttrs(rxSCAttr([]byte{2, 0, 0, 0, 0, 1, 0, 1}, false))
+		Expect(attrs).To(HaveKey(uint16(macsecRxSCAttrSCI)))
+		Expect(attrs).NotTo(HaveKey(uint16(macsecRxSCAttrActive)))
+	})
+})
@@ -0,0 +1,262 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a chained plugin that clamps the MSS of the TCP connections of the
+// container, so that they work over paths with a smaller MTU than the
+// container interface, such as overlays dropping ICMP. The rules are
+// installed with iptables or nftables in the container network namespace.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/utils"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+var (
+	iptablesBackend = "iptables"
+	nftablesBackend = "nftables"
+)
+
+const (
+	// The TCP and IP headers the MSS leaves out of the MTU
+	ipv4Headers = 40
+	ipv6Headers = 60
+)
+
+// Direction selects the connections to clamp the SYNs of, in one direction.
+type Direction struct {
+	// CIDRs are the remote prefixes to clamp the connections with; all if
+	// empty
+	CIDRs []string `json:"cidrs,omitempty"`
+	// MSS is the value to clamp to. If unset, egress SYNs are clamped to the
+	// MTU of their route, and ingress SYNs to the MTU of the interface.
+	MSS int `json:"mss,omitempty"`
+}
+
+// MSSClampConf is the configuration document passed in.
+type MSSClampConf struct {
+	types.NetConf
+
+	Backend *string    `json:"backend,omitempty"`
+	Egress  *Direction `json:"egress,omitempty"`
+	Ingress *Direction `json:"ingress,omitempty"`
+}
+
+// clampRule is a resolved rule: the SYNs of family to or from cidr get their
+// MSS clamped to mss, or to the route MTU if mss is 0. An empty family
+// matches both IPv4 and IPv6.
+type clampRule struct {
+	family string
+	cidr   string
+	mss    int
+}
+
+// clamper installs the rules of an interface with a firewall backend.
+type clamper interface {
+	setup(ifName string, egress, ingress []clampRule) error
+	check(ifName string, egress, ingress []clampRule) error
+	teardown(ifName string) error
+}
+
+// parseConfig parses the supplied configuration (and prevResult) from stdin.
+func parseConfig(stdin []byte) (*MSSClampConf, error) {
+	conf := MSSClampConf{}
+
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	if conf.RawPrevResult != nil {
+		var err error
+		if err = version.ParsePrevResult(&conf.NetConf); err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+
+		_, err = current.NewResultFromResult(conf.PrevResult)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	if conf.Egress == nil && conf.Ingress == nil {
+		conf.Egress = &Direction{}
+	}
+	for _, dir := range []*Direction{conf.Egress, conf.Ingress} {
+		if dir == nil {
+			continue
+		}
+		if dir.MSS < 0 || dir.MSS > 65535 {
+			return nil, fmt.Errorf("invalid mss %d", dir.MSS)
+		}
+		for _, cidr := range dir.CIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return nil, fmt.Errorf("invalid cidr %q", cidr)
+			}
+		}
+	}
+
+	if conf.Backend == nil {
+		if !utils.SupportsIPTables() && utils.SupportsNFTables() {
+			conf.Backend = &nftablesBackend
+		} else {
+			conf.Backend = &iptablesBackend
+		}
+	}
+	if *conf.Backend != iptablesBackend && *conf.Backend != nftablesBackend {
+		return nil, fmt.Errorf("unrecognized backend %q", *conf.Backend)
+	}
+
+	return &conf, nil
+}
+
+func cidrFamily(cidr string) string {
+	ip, _, _ := net.ParseCIDR(cidr)
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// resolve returns the rules of dir. Ingress SYNs have no route yet, so
+// their MSS is derived from the MTU of the interface.
+func resolve(dir *Direction, ingress bool, mtu int) []clampRule {
+	if dir == nil {
+		return nil
+	}
+
+	mss := func(family string) int {
+		switch {
+		case dir.MSS > 0 || !ingress:
+			return dir.MSS
+		case family == "ipv6":
+			return mtu - ipv6Headers
+		}
+		return mtu - ipv4Headers
+	}
+
+	rules := []clampRule{}
+	if len(dir.CIDRs) == 0 {
+		if dir.MSS > 0 || !ingress {
+			return []clampRule{{mss: dir.MSS}}
+		}
+		for _, family := range []string{"ipv4", "ipv6"} {
+			rules = append(rules, clampRule{family: family, mss: mss(family)})
+		}
+		return rules
+	}
+	for _, cidr := range dir.CIDRs {
+		family := cidrFamily(cidr)
+		rules = append(rules, clampRule{family: family, cidr: cidr, mss: mss(family)})
+	}
+	return rules
+}
+
+// withClamper runs f in the netns of args with the backend of conf and the
+// rules of the interface.
+func withClamper(args *skel.CmdArgs, conf *MSSClampConf, f func(c clamper, egress, ingress []clampRule) error) error {
+	return ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		link, err := netlinksafe.LinkByName(args.IfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", args.IfName, err)
+		}
+		mtu := link.Attrs().MTU
+
+		c, err := newClamper(*conf.Backend)
+		if err != nil {
+			return err
+		}
+		return f(c, resolve(conf.Egress, false, mtu), resolve(conf.Ingress, true, mtu))
+	})
+}
+
+func newClamper(backend string) (clamper, error) {
+	if backend == nftablesBackend {
+		return newNFTablesClamper()
+	}
+	return newIPTablesClamper()
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	err = withClamper(args, conf, func(c clamper, egress, ingress []clampRule) error {
+		return c.setup(args.IfName, egress, ingress)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up MSS clamping on %s: %v", args.IfName, err)
+	}
+
+	return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if args.Netns == "" {
+		return nil
+	}
+
+	// The interface may be gone already, so do not look it up
+	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		c, err := newClamper(*conf.Backend)
+		if err != nil {
+			return err
+		}
+		return c.teardown(args.IfName)
+	})
+	if _, ok := err.(ns.NSPathNotExistErr); ok {
+		return nil
+	}
+	return err
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	return withClamper(args, conf, func(c clamper, egress, ingress []clampRule) error {
+		return c.check(args.IfName, egress, ingress)
+	})
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Check: cmdCheck,
+		Del:   cmdDel,
+	}, version.All, bv.BuildString("mss-clamp"))
+}
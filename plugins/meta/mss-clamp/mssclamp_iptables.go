@@ -0,0 +1,179 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/coreos/go-iptables/iptables"
+
+	"github.com/containernetworking/plugins/pkg/utils"
+)
+
+// As with nftables, each interface gets a chain per direction in the mangle
+// table, jumped to from POSTROUTING and PREROUTING.
+
+type iptDirection struct {
+	name   string
+	base   string
+	prefix string
+	match  string
+	addr   string
+}
+
+var (
+	iptEgress  = iptDirection{"egress", "POSTROUTING", "CNI-MSS-OUT-", "-o", "-d"}
+	iptIngress = iptDirection{"ingress", "PREROUTING", "CNI-MSS-IN-", "-i", "-s"}
+)
+
+type iptablesClamper struct {
+	ipv4 *iptables.IPTables
+	ipv6 *iptables.IPTables
+}
+
+func newIPTablesClamper() (*iptablesClamper, error) {
+	ipv4, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate iptables: %v", err)
+	}
+	ipv6, err := iptables.NewWithProtocol(iptables.ProtocolIPv6)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate ip6tables: %v", err)
+	}
+	return &iptablesClamper{ipv4: ipv4, ipv6: ipv6}, nil
+}
+
+func jumpRule(dir iptDirection, ifName string) []string {
+	return []string{dir.match, ifName, "-p", "tcp", "-j", dir.prefix + ifName}
+}
+
+// iptRules returns the rules of the chain of dir for family.
+func iptRules(dir iptDirection, rules []clampRule, family string) [][]string {
+	specs := [][]string{}
+	for _, r := range rules {
+		if r.family != "" && r.family != family {
+			continue
+		}
+		spec := []string{"-p", "tcp", "--tcp-flags", "SYN,RST", "SYN"}
+		if r.cidr != "" {
+			spec = append(spec, dir.addr, r.cidr)
+		}
+		spec = append(spec, "-j", "TCPMSS")
+		if r.mss > 0 {
+			spec = append(spec, "--set-mss", strconv.Itoa(r.mss))
+		} else {
+			spec = append(spec, "--clamp-mss-to-pmtu")
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+func (c *iptablesClamper) families() map[string]*iptables.IPTables {
+	return map[string]*iptables.IPTables{"ipv4": c.ipv4, "ipv6": c.ipv6}
+}
+
+func (c *iptablesClamper) setup(ifName string, egress, ingress []clampRule) error {
+	if err := c.teardown(ifName); err != nil {
+		return err
+	}
+
+	for family, ipt := range c.families() {
+		for _, d := range []struct {
+			dir   iptDirection
+			rules []clampRule
+		}{{iptEgress, egress}, {iptIngress, ingress}} {
+			specs := iptRules(d.dir, d.rules, family)
+			if len(specs) == 0 {
+				continue
+			}
+
+			chain := d.dir.prefix + ifName
+			if err := utils.ClearChain(ipt, "mangle", chain); err != nil {
+				return err
+			}
+			for _, spec := range specs {
+				if err := ipt.Append("mangle", chain, spec...); err != nil {
+					return err
+				}
+			}
+			if err := utils.InsertUnique(ipt, "mangle", d.dir.base, false, jumpRule(d.dir, ifName)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *iptablesClamper) check(ifName string, egress, ingress []clampRule) error {
+	for family, ipt := range c.families() {
+		for _, d := range []struct {
+			dir   iptDirection
+			rules []clampRule
+		}{{iptEgress, egress}, {iptIngress, ingress}} {
+			specs := iptRules(d.dir, d.rules, family)
+			if len(specs) == 0 {
+				continue
+			}
+
+			chain := d.dir.prefix + ifName
+			exists, err := ipt.Exists("mangle", d.dir.base, jumpRule(d.dir, ifName)...)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("missing %s %s MSS clamping of %s", family, d.dir.name, ifName)
+			}
+			for _, spec := range specs {
+				exists, err := ipt.Exists("mangle", chain, spec...)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return fmt.Errorf("missing %s %s MSS clamping rule for %s", family, d.dir.name, ifName)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// teardown removes the chains of ifName and the jumps to them. It does not
+// fail if they do not exist.
+func (c *iptablesClamper) teardown(ifName string) error {
+	for _, ipt := range c.families() {
+		for _, dir := range []iptDirection{iptEgress, iptIngress} {
+			if err := utils.DeleteRule(ipt, "mangle", dir.base, jumpRule(dir, ifName)...); err != nil {
+				return err
+			}
+			chain := dir.prefix + ifName
+			exists, err := ipt.ChainExists("mangle", chain)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			if err := ipt.ClearChain("mangle", chain); err != nil {
+				return err
+			}
+			if err := utils.DeleteChain(ipt, "mangle", chain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"sigs.k8s.io/knftables"
+)
+
+const tableName = "cni_mss_clamp"
+
+// Each interface gets a chain per direction, which the postrouting and
+// prerouting chains jump to for the packets sent and received through it.
+// The jump rules are commented with the interface name so they can be found
+// on CHECK and DEL.
+
+type nftDirection struct {
+	name   string
+	base   string
+	hook   knftables.BaseChainHook
+	prefix string
+	match  string
+	addr   string
+}
+
+var (
+	nftEgress  = nftDirection{"egress", "postrouting", knftables.PostroutingHook, "egress-", "oifname", "daddr"}
+	nftIngress = nftDirection{"ingress", "prerouting", knftables.PreroutingHook, "ingress-", "iifname", "saddr"}
+)
+
+type nftablesClamper struct {
+	nft knftables.Interface
+}
+
+func newNFTablesClamper() (*nftablesClamper, error) {
+	nft, err := knftables.New(knftables.InetFamily, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &nftablesClamper{nft: nft}, nil
+}
+
+func nftRule(dir nftDirection, r clampRule) string {
+	var match []string
+	switch {
+	case r.cidr != "" && r.family == "ipv4":
+		match = append(match, "ip", dir.addr, r.cidr)
+	case r.cidr != "":
+		match = append(match, "ip6", dir.addr, r.cidr)
+	case r.family != "":
+		match = append(match, "meta nfproto", r.family)
+	}
+
+	value := "rt mtu"
+	if r.mss > 0 {
+		value = fmt.Sprint(r.mss)
+	}
+	return knftables.Concat(match, "tcp flags & (syn | rst) == syn tcp option maxseg size set", value)
+}
+
+func (n *nftablesClamper) setup(ifName string, egress, ingress []clampRule) error {
+	tx := n.nft.NewTransaction()
+	tx.Add(&knftables.Table{
+		Comment: knftables.PtrTo("CNI mss-clamp plugin"),
+	})
+
+	for _, d := range []struct {
+		dir   nftDirection
+		rules []clampRule
+	}{{nftEgress, egress}, {nftIngress, ingress}} {
+		chain := d.dir.prefix + ifName
+		tx.Add(&knftables.Chain{
+			Name:     d.dir.base,
+			Type:     knftables.PtrTo(knftables.FilterType),
+			Hook:     knftables.PtrTo(d.dir.hook),
+			Priority: knftables.PtrTo(knftables.ManglePriority),
+		})
+
+		jumps, err := n.jumpRules(d.dir, ifName)
+		if err != nil {
+			return err
+		}
+		for _, r := range jumps {
+			tx.Delete(r)
+		}
+
+		if len(d.rules) == 0 {
+			if _, err := n.nft.ListRules(context.TODO(), chain); err == nil {
+				tx.Delete(&knftables.Chain{
+					Name: chain,
+				})
+			}
+			continue
+		}
+
+		tx.Add(&knftables.Chain{
+			Name: chain,
+		})
+		tx.Flush(&knftables.Chain{
+			Name: chain,
+		})
+		for _, r := range d.rules {
+			tx.Add(&knftables.Rule{
+				Chain: chain,
+				Rule:  nftRule(d.dir, r),
+			})
+		}
+		tx.Add(&knftables.Rule{
+			Chain:   d.dir.base,
+			Rule:    knftables.Concat(d.dir.match, fmt.Sprintf("%q", ifName), "meta l4proto tcp jump", chain),
+			Comment: knftables.PtrTo(ifName),
+		})
+	}
+
+	return n.nft.Run(context.TODO(), tx)
+}
+
+// jumpRules returns the rules of the base chain of dir jumping to the chain
+// of ifName.
+func (n *nftablesClamper) jumpRules(dir nftDirection, ifName string) ([]*knftables.Rule, error) {
+	rules, err := n.nft.ListRules(context.TODO(), dir.base)
+	if err != nil {
+		if knftables.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not list rules in table %s: %w", tableName, err)
+	}
+
+	jumps := []*knftables.Rule{}
+	for _, r := range rules {
+		if r.Comment != nil && *r.Comment == ifName {
+			jumps = append(jumps, r)
+		}
+	}
+	return jumps, nil
+}
+
+// check checks that the rules of ifName are installed. They are only
+// counted, as nft does not print them back as they were written.
+func (n *nftablesClamper) check(ifName string, egress, ingress []clampRule) error {
+	for _, d := range []struct {
+		dir   nftDirection
+		rules []clampRule
+	}{{nftEgress, egress}, {nftIngress, ingress}} {
+		if len(d.rules) == 0 {
+			continue
+		}
+
+		jumps, err := n.jumpRules(d.dir, ifName)
+		if err != nil {
+			return err
+		}
+		if len(jumps) == 0 {
+			return fmt.Errorf("missing %s MSS clamping of %s", d.dir.name, ifName)
+		}
+		rules, err := n.nft.ListRules(context.TODO(), d.dir.prefix+ifName)
+		if err != nil {
+			return fmt.Errorf("could not list MSS clamping rules of %s: %w", ifName, err)
+		}
+		if len(rules) != len(d.rules) {
+			return fmt.Errorf("expected %d %s MSS clamping rules for %s, found %d", len(d.rules), d.dir.name, ifName, len(rules))
+		}
+	}
+	return nil
+}
+
+// teardown removes the chains of ifName and the jumps to them. It does not
+// fail if they do not exist.
+func (n *nftablesClamper) teardown(ifName string) error {
+	tx := n.nft.NewTransaction()
+	for _, dir := range []nftDirection{nftEgress, nftIngress} {
+		jumps, err := n.jumpRules(dir, ifName)
+		if err != nil {
+			return err
+		}
+		for _, r := range jumps {
+			tx.Delete(r)
+		}
+
+		chain := dir.prefix + ifName
+		if _, err := n.nft.ListRules(context.TODO(), chain); err != nil {
+			if !knftables.IsNotFound(err) {
+				return fmt.Errorf("could not list MSS clamping rules of %s: %w", ifName, err)
+			}
+			continue
+		}
+		tx.Delete(&knftables.Chain{
+			Name: chain,
+		})
+	}
+
+	if tx.NumOperations() == 0 {
+		return nil
+	}
+	return n.nft.Run(context.TODO(), tx)
+}
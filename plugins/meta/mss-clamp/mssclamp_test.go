@@ -0,0 +1,129 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/knftables"
+)
+
+var _ = Describe("mss-clamp plugin", func() {
+	Describe("configuration", func() {
+		It("clamps egress SYNs to the route MTU by default", func() {
+			conf, err := parseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test", "type": "mss-clamp", "backend": "nftables"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.Ingress).To(BeNil())
+			Expect(resolve(conf.Egress, false, 1500)).To(Equal([]clampRule{{}}))
+		})
+
+		It("rejects invalid configurations", func() {
+			for _, conf := range []string{
+				`{"backend": "ebpf"}`,
+				`{"backend": "nftables", "egress": {"mss": -1}}`,
+				`{"backend": "nftables", "ingress": {"cidrs": ["10.0.0.0"]}}`,
+			} {
+				_, err := parseConfig([]byte(conf))
+				Expect(err).To(HaveOccurred(), conf)
+			}
+		})
+
+		It("derives the MSS of ingress SYNs from the interface MTU", func() {
+			Expect(resolve(&Direction{}, true, 1450)).To(Equal([]clampRule{
+				{family: "ipv4", mss: 1410},
+				{family: "ipv6", mss: 1390},
+			}))
+			Expect(resolve(&Direction{CIDRs: []string{"10.0.0.0/8", "fd00::/8"}}, true, 1450)).To(Equal([]clampRule{
+				{family: "ipv4", cidr: "10.0.0.0/8", mss: 1410},
+				{family: "ipv6", cidr: "fd00::/8", mss: 1390},
+			}))
+			Expect(resolve(&Direction{MSS: 1200}, true, 1450)).To(Equal([]clampRule{{mss: 1200}}))
+			Expect(resolve(nil, true, 1450)).To(BeNil())
+		})
+	})
+
+	Describe("iptables rules", func() {
+		It("splits the rules by family", func() {
+			rules := []clampRule{{}, {family: "ipv6", cidr: "fd00::/8", mss: 1300}}
+
+			Expect(iptRules(iptEgress, rules, "ipv4")).To(Equal([][]string{
+				{"-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--clamp-mss-to-pmtu"},
+			}))
+			Expect(iptRules(iptIngress, rules, "ipv6")).To(Equal([][]string{
+				{"-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--clamp-mss-to-pmtu"},
+				{"-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-s", "fd00::/8", "-j", "TCPMSS", "--set-mss", "1300"},
+			}))
+		})
+	})
+
+	Describe("nftables rules", func() {
+		var fake *knftables.Fake
+		var clamper *nftablesClamper
+
+		BeforeEach(func() {
+			fake = knftables.NewFake(knftables.InetFamily, tableName)
+			clamper = &nftablesClamper{nft: fake}
+		})
+
+		It("clamps the SYNs of each interface in chains of its own", func() {
+			egress := []clampRule{{}, {family: "ipv4", cidr: "10.0.0.0/8", mss: 1360}}
+			ingress := resolve(&Direction{}, true, 1450)
+
+			Expect(clamper.setup("eth0", egress, nil)).To(Succeed())
+			Expect(clamper.setup("net1", egress[:1], nil)).To(Succeed())
+			// Setting up again replaces the rules of the interface
+			Expect(clamper.setup("eth0", egress[1:], ingress)).To(Succeed())
+
+			expected := strings.TrimSpace(`
+add table inet cni_mss_clamp { comment "CNI mss-clamp plugin" ; }
+add chain inet cni_mss_clamp egress-eth0
+add chain inet cni_mss_clamp egress-net1
+add chain inet cni_mss_clamp ingress-eth0
+add chain inet cni_mss_clamp postrouting { type filter hook postrouting priority -150 ; }
+add chain inet cni_mss_clamp prerouting { type filter hook prerouting priority -150 ; }
+add rule inet cni_mss_clamp egress-eth0 ip daddr 10.0.0.0/8 tcp flags & (syn | rst) == syn tcp option maxseg size set 1360
+add rule inet cni_mss_clamp egress-net1 tcp flags & (syn | rst) == syn tcp option maxseg size set rt mtu
+add rule inet cni_mss_clamp ingress-eth0 meta nfproto ipv4 tcp flags & (syn | rst) == syn tcp option maxseg size set 1410
+add rule inet cni_mss_clamp ingress-eth0 meta nfproto ipv6 tcp flags & (syn | rst) == syn tcp option maxseg size set 1390
+add rule inet cni_mss_clamp postrouting oifname "net1" meta l4proto tcp jump egress-net1 comment "net1"
+add rule inet cni_mss_clamp postrouting oifname "eth0" meta l4proto tcp jump egress-eth0 comment "eth0"
+add rule inet cni_mss_clamp prerouting iifname "eth0" meta l4proto tcp jump ingress-eth0 comment "eth0"
+`)
+			Expect(strings.TrimSpace(fake.Dump())).To(Equal(expected))
+		})
+
+		It("checks and removes the clamping of an interface", func() {
+			egress := []clampRule{{}}
+			ingress := []clampRule{{mss: 1200}}
+
+			Expect(clamper.check("eth0", egress, nil)).To(MatchError("missing egress MSS clamping of eth0"))
+
+			Expect(clamper.setup("eth0", egress, ingress)).To(Succeed())
+			Expect(clamper.setup("net1", egress, nil)).To(Succeed())
+			Expect(clamper.check("eth0", egress, ingress)).To(Succeed())
+			Expect(clamper.check("eth0", egress, append(ingress, ingress...))).To(MatchError("expected 2 ingress MSS clamping rules for eth0, found 1"))
+
+			Expect(clamper.teardown("eth0")).To(Succeed())
+			Expect(clamper.teardown("eth0")).To(Succeed())
+			Expect(clamper.check("eth0", egress, nil)).To(MatchError("missing egress MSS clamping of eth0"))
+
+			dump := fake.Dump()
+			Expect(dump).NotTo(ContainSubstring("eth0"))
+			Expect(dump).To(ContainSubstring("jump egress-net1"))
+		})
+	})
+})
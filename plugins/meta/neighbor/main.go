@@ -0,0 +1,350 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a chained plugin that installs permanent neighbor (ARP and NDP)
+// entries in the container network namespace and on the host side of the
+// attachment, for networks where the peers must not be resolved. They are
+// taken from the network configuration and from the "neighbors" capability
+// of the runtime, verified on CHECK and removed on DEL.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+const (
+	sideContainer = "container"
+	sideHost      = "host"
+)
+
+// Neighbor is a permanent neighbor entry to install. Side is "container",
+// the default, or "host". Dev defaults to CNI_IFNAME in the container, and
+// to its veth peer on the host.
+type Neighbor struct {
+	IP   string `json:"ip"`
+	MAC  string `json:"mac"`
+	Dev  string `json:"dev,omitempty"`
+	Side string `json:"side,omitempty"`
+
+	ip  net.IP
+	mac net.HardwareAddr
+}
+
+// PluginConf is the configuration document passed in.
+type PluginConf struct {
+	types.NetConf
+
+	RawPrevResult *map[string]interface{} `json:"prevResult"`
+	PrevResult    *current.Result         `json:"-"`
+
+	Neighbors []Neighbor `json:"neighbors,omitempty"`
+
+	RuntimeConfig struct {
+		Neighbors []Neighbor `json:"neighbors,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+}
+
+// parseConfig parses the supplied configuration (and prevResult) from stdin.
+func parseConfig(stdin []byte) (*PluginConf, error) {
+	conf := PluginConf{}
+
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	for _, neighs := range [][]Neighbor{conf.Neighbors, conf.RuntimeConfig.Neighbors} {
+		for i := range neighs {
+			if err := neighs[i].parse(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if conf.RawPrevResult != nil {
+		resultBytes, err := json.Marshal(conf.RawPrevResult)
+		if err != nil {
+			return nil, fmt.Errorf("could not serialize prevResult: %v", err)
+		}
+		res, err := version.NewResult(conf.CNIVersion, resultBytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+		conf.RawPrevResult = nil
+		conf.PrevResult, err = current.NewResultFromResult(res)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	return &conf, nil
+}
+
+func (n *Neighbor) parse() error {
+	if n.ip = net.ParseIP(n.IP); n.ip == nil {
+		return fmt.Errorf("invalid neighbor IP %q", n.IP)
+	}
+	mac, err := net.ParseMAC(n.MAC)
+	if err != nil || len(mac) != 6 {
+		return fmt.Errorf("invalid MAC %q for neighbor %s", n.MAC, n.IP)
+	}
+	n.mac = mac
+	switch n.Side {
+	case "":
+		n.Side = sideContainer
+	case sideContainer, sideHost:
+	default:
+		return fmt.Errorf("invalid side %q for neighbor %s, must be %q or %q", n.Side, n.IP, sideContainer, sideHost)
+	}
+	return nil
+}
+
+// neighbors returns the neighbors of side from the configuration followed by
+// the ones of the runtime.
+func (conf *PluginConf) neighbors(side string) []Neighbor {
+	neighs := []Neighbor{}
+	for _, n := range append(conf.Neighbors, conf.RuntimeConfig.Neighbors...) {
+		if n.Side == side {
+			neighs = append(neighs, n)
+		}
+	}
+	return neighs
+}
+
+func family(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// toNetlink returns the entry of n, on its Dev or else on the link returned
+// by defaultLink, resolved in the current netns.
+func (n *Neighbor) toNetlink(defaultLink func() (netlink.Link, error)) (*netlink.Neigh, error) {
+	var link netlink.Link
+	var err error
+	if n.Dev != "" {
+		link, err = netlinksafe.LinkByName(n.Dev)
+	} else {
+		link, err = defaultLink()
+	}
+	if err != nil {
+		return nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup the interface of neighbor %s", n.IP)
+	}
+	return &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       family(n.ip),
+		State:        netlink.NUD_PERMANENT,
+		IP:           n.ip,
+		HardwareAddr: n.mac,
+	}, nil
+}
+
+// containerLink returns a lookup of the container interface, to be called
+// in the container netns.
+func containerLink(ifName string) func() (netlink.Link, error) {
+	return func() (netlink.Link, error) {
+		return netlinksafe.LinkByName(ifName)
+	}
+}
+
+// hostLink returns a lookup of the veth peer of the container interface, to
+// be called in the host netns.
+func hostLink(netns ns.NetNS, ifName string) func() (netlink.Link, error) {
+	return func() (netlink.Link, error) {
+		var peerIndex int
+		err := netns.Do(func(_ ns.NetNS) error {
+			// Looked up first to tell a missing interface apart
+			if _, err := netlinksafe.LinkByName(ifName); err != nil {
+				return err
+			}
+			var err error
+			_, peerIndex, err = ip.GetVethPeerIfindex(ifName)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		link, err := netlink.LinkByIndex(peerIndex)
+		if err != nil {
+			return nil, fmt.Errorf("veth peer of %s with index %d is not in host ns: %w", ifName, peerIndex, err)
+		}
+		return link, nil
+	}
+}
+
+// setNeighbors installs neighs, replacing the entries of their IPs.
+func setNeighbors(neighs []Neighbor, defaultLink func() (netlink.Link, error)) error {
+	for _, n := range neighs {
+		neigh, err := n.toNetlink(defaultLink)
+		if err != nil {
+			return err
+		}
+		if err := netlink.NeighSet(neigh); err != nil {
+			return fmt.Errorf("failed to set neighbor %s: %v", n.IP, err)
+		}
+	}
+	return nil
+}
+
+// checkNeighbors verifies that neighs are installed as permanent entries.
+func checkNeighbors(neighs []Neighbor, defaultLink func() (netlink.Link, error)) error {
+	for _, n := range neighs {
+		neigh, err := n.toNetlink(defaultLink)
+		if err != nil {
+			return err
+		}
+		entries, err := netlinksafe.NeighList(neigh.LinkIndex, neigh.Family)
+		if err != nil {
+			return fmt.Errorf("failed to list neighbors: %v", err)
+		}
+		found := false
+		for _, e := range entries {
+			if e.IP.Equal(neigh.IP) {
+				found = e.State&netlink.NUD_PERMANENT != 0 && e.HardwareAddr.String() == neigh.HardwareAddr.String()
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("permanent neighbor %s at %s missing", n.IP, n.MAC)
+		}
+	}
+	return nil
+}
+
+// delNeighbors removes neighs. The entries of an interface already gone went
+// with it.
+func delNeighbors(neighs []Neighbor, defaultLink func() (netlink.Link, error)) error {
+	for _, n := range neighs {
+		neigh, err := n.toNetlink(defaultLink)
+		if err != nil {
+			if cnierrors.Code(err) == cnierrors.ErrInterfaceNotFound {
+				continue
+			}
+			return err
+		}
+		if err := netlink.NeighDel(neigh); err != nil && !errors.Is(err, syscall.ENOENT) {
+			return fmt.Errorf("failed to delete neighbor %s: %v", n.IP, err)
+		}
+	}
+	return nil
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return setNeighbors(conf.neighbors(sideContainer), containerLink(args.IfName))
+	})
+	if err != nil {
+		return err
+	}
+	if err := setNeighbors(conf.neighbors(sideHost), hostLink(netns, args.IfName)); err != nil {
+		return err
+	}
+
+	return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	// The host entries on a named interface outlive the container, the
+	// others go with its netns
+	hostNeighs := conf.neighbors(sideHost)
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		if _, ok := err.(ns.NSPathNotExistErr); !ok {
+			return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+		}
+		named := []Neighbor{}
+		for _, n := range hostNeighs {
+			if n.Dev != "" {
+				named = append(named, n)
+			}
+		}
+		return delNeighbors(named, nil)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return delNeighbors(conf.neighbors(sideContainer), containerLink(args.IfName))
+	})
+	if err != nil {
+		return err
+	}
+	return delNeighbors(hostNeighs, hostLink(netns, args.IfName))
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return checkNeighbors(conf.neighbors(sideContainer), containerLink(args.IfName))
+	})
+	if err != nil {
+		return err
+	}
+	return checkNeighbors(conf.neighbors(sideHost), hostLink(netns, args.IfName))
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Check: cmdCheck,
+		Del:   cmdDel,
+	}, version.All, bv.BuildString("neighbor"))
+}
@@ -0,0 +1,213 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+// permanentNeighbors returns the permanent entries of the link named ifName,
+// by IP.
+func permanentNeighbors(ifName string) map[string]string {
+	link, err := netlinksafe.LinkByName(ifName)
+	Expect(err).NotTo(HaveOccurred())
+	entries, err := netlinksafe.NeighList(link.Attrs().Index, netlink.FAMILY_ALL)
+	Expect(err).NotTo(HaveOccurred())
+	neighs := map[string]string{}
+	for _, e := range entries {
+		if e.State&netlink.NUD_PERMANENT != 0 {
+			neighs[e.IP.String()] = e.HardwareAddr.String()
+		}
+	}
+	return neighs
+}
+
+var _ = Describe("neighbor plugin", func() {
+	Describe("configuration", func() {
+		It("defaults entries to the container side", func() {
+			conf, err := parseConfig([]byte(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "neighbor",
+				"neighbors": [
+					{"ip": "10.1.2.1", "mac": "02:00:00:00:00:01"},
+					{"ip": "fd00::1", "mac": "02:00:00:00:00:01", "side": "host", "dev": "eth1"}
+				],
+				"runtimeConfig": {"neighbors": [{"ip": "10.1.2.254", "mac": "02:00:00:00:00:fe"}]},
+				"prevResult": {
+					"cniVersion": "1.0.0",
+					"interfaces": [{"name": "eth0", "sandbox": "/some/netns"}],
+					"ips": [{"address": "10.1.2.3/24", "interface": 0}]
+				}
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.PrevResult).NotTo(BeNil())
+
+			container := conf.neighbors(sideContainer)
+			Expect(container).To(HaveLen(2))
+			Expect(container[0].IP).To(Equal("10.1.2.1"))
+			Expect(container[1].IP).To(Equal("10.1.2.254"))
+			host := conf.neighbors(sideHost)
+			Expect(host).To(HaveLen(1))
+			Expect(host[0].Dev).To(Equal("eth1"))
+		})
+
+		It("rejects invalid entries", func() {
+			for _, neighs := range []string{
+				`[{"ip": "10.1.2", "mac": "02:00:00:00:00:01"}]`,
+				`[{"ip": "10.1.2.1"}]`,
+				`[{"ip": "10.1.2.1", "mac": "02:00:00:00:00:00:00:01"}]`,
+				`[{"ip": "10.1.2.1", "mac": "02:00:00:00:00:01", "side": "peer"}]`,
+			} {
+				_, err := parseConfig([]byte(fmt.Sprintf(`{
+					"cniVersion": "1.0.0",
+					"name": "test",
+					"type": "neighbor",
+					"neighbors": %s,
+					"runtimeConfig": {"neighbors": [{"ip": "10.1.2.254", "mac": "02:00:00:00:00:fe"}]},
+					"prevResult": {
+						"cniVersion": "1.0.0",
+						"interfaces": [{"name": "eth0", "sandbox": "/some/netns"}],
+						"ips": [{"address": "10.1.2.3/24", "interface": 0}]
+					}
+				}`, neighs)))
+				Expect(err).To(HaveOccurred(), neighs)
+			}
+		})
+	})
+
+	Describe("entries", func() {
+		var hostNs, targetNs ns.NetNS
+		var args *skel.CmdArgs
+
+		BeforeEach(func() {
+			var err error
+			hostNs, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			targetNs, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+
+			err = hostNs.Do(func(_ ns.NetNS) error {
+				veth := &netlink.Veth{
+					LinkAttrs:     netlink.LinkAttrs{Name: "peer0"},
+					PeerName:      "eth0",
+					PeerNamespace: netlink.NsFd(int(targetNs.Fd())),
+				}
+				if err := netlink.LinkAdd(veth); err != nil {
+					return err
+				}
+				return netlink.LinkSetUp(veth)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			args = &skel.CmdArgs{
+				ContainerID: "ctr",
+				Netns:       targetNs.Path(),
+				IfName:      "eth0",
+				StdinData: []byte(`{
+					"cniVersion": "1.0.0",
+					"name": "test",
+					"type": "neighbor",
+					"neighbors": [
+						{"ip": "10.1.2.1", "mac": "02:00:00:00:00:01"},
+						{"ip": "fd00::1", "mac": "02:00:00:00:00:02"},
+						{"ip": "10.1.2.3", "mac": "02:00:00:00:00:03", "side": "host"}
+					],
+					"runtimeConfig": {"neighbors": [{"ip": "10.1.2.254", "mac": "02:00:00:00:00:fe"}]},
+					"prevResult": {
+						"cniVersion": "1.0.0",
+						"interfaces": [{"name": "eth0", "sandbox": "/some/netns"}],
+						"ips": [{"address": "10.1.2.3/24", "interface": 0}]
+					}
+				}`),
+			}
+		})
+
+		AfterEach(func() {
+			Expect(targetNs.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(targetNs)).To(Succeed())
+			Expect(hostNs.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(hostNs)).To(Succeed())
+		})
+
+		It("installs, checks and removes the entries on both sides", func() {
+			err := hostNs.Do(func(_ ns.NetNS) error {
+				defer GinkgoRecover()
+
+				_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+				Expect(err).NotTo(HaveOccurred())
+				Expect(permanentNeighbors("peer0")).To(Equal(map[string]string{
+					"10.1.2.3": "02:00:00:00:00:03",
+				}))
+				Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(Succeed())
+
+				err = targetNs.Do(func(_ ns.NetNS) error {
+					defer GinkgoRecover()
+
+					Expect(permanentNeighbors("eth0")).To(Equal(map[string]string{
+						"10.1.2.1":   "02:00:00:00:00:01",
+						"fd00::1":    "02:00:00:00:00:02",
+						"10.1.2.254": "02:00:00:00:00:fe",
+					}))
+					link, err := netlinksafe.LinkByName("eth0")
+					Expect(err).NotTo(HaveOccurred())
+					return netlink.NeighDel(&netlink.Neigh{
+						LinkIndex: link.Attrs().Index,
+						IP:        []byte{10, 1, 2, 254},
+					})
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(MatchError(
+					"permanent neighbor 10.1.2.254 at 02:00:00:00:00:fe missing"))
+
+				Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+				Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+				Expect(permanentNeighbors("peer0")).To(BeEmpty())
+				return targetNs.Do(func(_ ns.NetNS) error {
+					defer GinkgoRecover()
+
+					Expect(permanentNeighbors("eth0")).To(BeEmpty())
+					return nil
+				})
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("does not fail to delete once the interface is gone", func() {
+			err := targetNs.Do(func(_ ns.NetNS) error {
+				link, err := netlinksafe.LinkByName("eth0")
+				if err != nil {
+					return err
+				}
+				return netlink.LinkDel(link)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = hostNs.Do(func(_ ns.NetNS) error {
+				return testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,384 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a chained plugin that impairs the traffic sent by the container,
+// with a netem qdisc on its interface, for chaos testing.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/statestore"
+	"github.com/containernetworking/plugins/pkg/tc"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+// NetemEntry corresponds to the netem argument. Delays are in milliseconds,
+// probabilities and correlations in percent.
+type NetemEntry struct {
+	Delay            uint32  `json:"delay,omitempty"`
+	Jitter           uint32  `json:"jitter,omitempty"`
+	DelayCorrelation float32 `json:"delayCorrelation,omitempty"`
+
+	Loss            float32 `json:"loss,omitempty"`
+	LossCorrelation float32 `json:"lossCorrelation,omitempty"`
+
+	Duplicate            float32 `json:"duplicate,omitempty"`
+	DuplicateCorrelation float32 `json:"duplicateCorrelation,omitempty"`
+
+	Reorder            float32 `json:"reorder,omitempty"`
+	ReorderCorrelation float32 `json:"reorderCorrelation,omitempty"`
+
+	Corrupt            float32 `json:"corrupt,omitempty"`
+	CorruptCorrelation float32 `json:"corruptCorrelation,omitempty"`
+
+	// Rate is in bits per second
+	Rate uint64 `json:"rate,omitempty"`
+	// Limit is the number of packets the qdisc holds
+	Limit uint32 `json:"limit,omitempty"`
+}
+
+func (e *NetemEntry) isZero() bool {
+	return *e == NetemEntry{}
+}
+
+func (e *NetemEntry) validate() error {
+	for _, p := range []struct {
+		name  string
+		value float32
+	}{
+		{"delayCorrelation", e.DelayCorrelation},
+		{"loss", e.Loss},
+		{"lossCorrelation", e.LossCorrelation},
+		{"duplicate", e.Duplicate},
+		{"duplicateCorrelation", e.DuplicateCorrelation},
+		{"reorder", e.Reorder},
+		{"reorderCorrelation", e.ReorderCorrelation},
+		{"corrupt", e.Corrupt},
+		{"corruptCorrelation", e.CorruptCorrelation},
+	} {
+		if p.value < 0 || p.value > 100 {
+			return fmt.Errorf("%s must be a percentage, got %v", p.name, p.value)
+		}
+	}
+	if e.Jitter > 0 && e.Delay == 0 {
+		return fmt.Errorf("jitter requires delay to be set")
+	}
+	if e.Reorder > 0 && e.Delay == 0 {
+		return fmt.Errorf("reorder requires delay to be set")
+	}
+	return nil
+}
+
+const defaultDataDir = "/run/cni/netem"
+
+type PluginConf struct {
+	types.NetConf
+
+	// DataDir holds the records of the root qdiscs to restore on DEL
+	DataDir string `json:"dataDir,omitempty"`
+
+	RuntimeConfig struct {
+		Netem *NetemEntry `json:"netem,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+
+	*NetemEntry
+}
+
+// parseConfig parses the supplied configuration (and prevResult) from stdin.
+func parseConfig(stdin []byte) (*PluginConf, error) {
+	conf := PluginConf{DataDir: defaultDataDir}
+
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	if netem := getNetem(&conf); netem != nil {
+		if err := netem.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.RawPrevResult != nil {
+		var err error
+		if err = version.ParsePrevResult(&conf.NetConf); err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+
+		_, err = current.NewResultFromResult(conf.PrevResult)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	return &conf, nil
+}
+
+// getNetem returns the impairments to apply. Unlike bandwidth, the runtime
+// config takes precedence over the network config, so that impairments can
+// be toggled per container.
+func getNetem(conf *PluginConf) *NetemEntry {
+	if conf.RuntimeConfig.Netem != nil {
+		return conf.RuntimeConfig.Netem
+	}
+	return conf.NetemEntry
+}
+
+// netemRecord is the state of an attachment: the root qdisc the netem qdisc
+// replaced, nil for the kernel default one, and the handle of the netem
+// qdisc, so that DEL only removes that one.
+type netemRecord struct {
+	Prior  *tc.Qdisc `json:"prior,omitempty"`
+	Handle uint32    `json:"handle"`
+}
+
+// netemStore returns the store of the records of the attachments on the
+// network.
+func netemStore(conf *PluginConf) *statestore.Store {
+	return statestore.New(conf.DataDir, conf.Name)
+}
+
+func newNetem(e *NetemEntry, linkIndex int, handle uint32) *netlink.Netem {
+	// Equivalent to
+	// tc qdisc replace dev link root netem
+	//		delay e.Delay e.Jitter e.DelayCorrelation
+	//		loss e.Loss e.LossCorrelation ...
+	return netlink.NewNetem(
+		netlink.QdiscAttrs{
+			LinkIndex: linkIndex,
+			Handle:    handle,
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		netlink.NetemQdiscAttrs{
+			Latency:       e.Delay * 1000,
+			Jitter:        e.Jitter * 1000,
+			DelayCorr:     e.DelayCorrelation,
+			Loss:          e.Loss,
+			LossCorr:      e.LossCorrelation,
+			Duplicate:     e.Duplicate,
+			DuplicateCorr: e.DuplicateCorrelation,
+			ReorderProb:   e.Reorder,
+			ReorderCorr:   e.ReorderCorrelation,
+			CorruptProb:   e.Corrupt,
+			CorruptCorr:   e.CorruptCorrelation,
+			Rate64:        e.Rate / 8,
+			Limit:         e.Limit,
+		},
+	)
+}
+
+// rootNetem returns the netem qdisc at the root of link, or nil.
+func rootNetem(link netlink.Link) (*netlink.Netem, error) {
+	qdisc, err := tc.RootQdisc(link)
+	if err != nil {
+		return nil, err
+	}
+	netem, _ := qdisc.(*netlink.Netem)
+	return netem, nil
+}
+
+// setNetem replaces the root qdisc of the link with the netem qdisc of e,
+// after recording the one it replaces. The record of an ADD whose DEL was
+// lost is kept, as the root qdisc is then its netem one.
+func setNetem(conf *PluginConf, args *skel.CmdArgs, e *NetemEntry) error {
+	link, err := netlinksafe.LinkByName(args.IfName)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup %q", args.IfName)
+	}
+
+	store := netemStore(conf)
+	key := statestore.Key{ContainerID: args.ContainerID, IfName: args.IfName}
+	rec := &netemRecord{}
+	found, err := store.Load(key, rec)
+	if err != nil {
+		return err
+	}
+	if !found {
+		root, err := tc.RootQdisc(link)
+		if err != nil {
+			return err
+		}
+		rec.Prior = tc.Describe(root)
+		// A root qdisc of the same handle would be changed rather than
+		// replaced
+		rec.Handle = netlink.MakeHandle(1, 0)
+		if rec.Prior != nil && rec.Prior.Handle == rec.Handle {
+			rec.Handle = netlink.MakeHandle(2, 0)
+		}
+		if err := store.Save(key, rec); err != nil {
+			return err
+		}
+	}
+
+	if err := netlink.QdiscReplace(newNetem(e, link.Attrs().Index, rec.Handle)); err != nil {
+		if !found {
+			_ = store.Delete(key)
+		}
+		return fmt.Errorf("create netem qdisc: %s", err)
+	}
+	return nil
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	netem := getNetem(conf)
+	if netem != nil && !netem.isZero() {
+		netns, err := ns.GetNS(args.Netns)
+		if err != nil {
+			return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+		}
+		defer netns.Close()
+
+		err = netns.Do(func(_ ns.NetNS) error {
+			return setNetem(conf, args, netem)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+}
+
+// cmdDel restores the root qdisc the netem qdisc of the attachment replaced.
+// A root qdisc that is not that netem qdisc, as set by someone else since,
+// is left alone.
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	store := netemStore(conf)
+	key := statestore.Key{ContainerID: args.ContainerID, IfName: args.IfName}
+	rec := &netemRecord{}
+	found, err := store.Load(key, rec)
+	if err != nil || !found {
+		return err
+	}
+
+	if args.Netns != "" {
+		err := ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(args.IfName)
+			if err != nil {
+				var linkNotFound netlink.LinkNotFoundError
+				if errors.As(err, &linkNotFound) {
+					return nil
+				}
+				return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup %q", args.IfName)
+			}
+
+			netem, err := rootNetem(link)
+			if err != nil || netem == nil || netem.Handle != rec.Handle {
+				return err
+			}
+			return tc.RestoreRootQdisc(link, rec.Prior)
+		})
+		if _, ok := err.(ns.NSPathNotExistErr); !ok && err != nil {
+			return err
+		}
+	}
+
+	return store.Delete(key)
+}
+
+// cmdGC removes the records of the attachments on the network that are no
+// longer valid. Their interfaces, and qdiscs, are gone with them.
+func cmdGC(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	return netemStore(conf).GC(gc.NewAttachments(conf.ValidAttachments), nil)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as a chained plugin")
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	return netns.Do(func(_ ns.NetNS) error {
+		link, err := netlinksafe.LinkByName(args.IfName)
+		if err != nil {
+			return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup %q", args.IfName)
+		}
+		netem, err := rootNetem(link)
+		if err != nil {
+			return err
+		}
+
+		entry := getNetem(conf)
+		if entry == nil || entry.isZero() {
+			if netem != nil {
+				return fmt.Errorf("unexpected netem qdisc on %s", args.IfName)
+			}
+			return nil
+		}
+		if netem == nil {
+			return fmt.Errorf("failed to find netem qdisc on %s", args.IfName)
+		}
+
+		expected := newNetem(entry, link.Attrs().Index, netem.Handle)
+		if netem.Latency != expected.Latency || netem.Jitter != expected.Jitter {
+			return fmt.Errorf("netem delay does not match")
+		}
+		if netem.Loss != expected.Loss || netem.Duplicate != expected.Duplicate ||
+			netem.ReorderProb != expected.ReorderProb || netem.CorruptProb != expected.CorruptProb {
+			return fmt.Errorf("netem probabilities do not match")
+		}
+		if netem.Rate64 != expected.Rate64 || netem.Limit != expected.Limit {
+			return fmt.Errorf("netem rate or limit does not match")
+		}
+		return nil
+	})
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Check: cmdCheck,
+		Del:   cmdDel,
+		GC:    cmdGC,
+	}, version.VersionsStartingFrom("0.3.0"), bv.BuildString("netem"))
+}
@@ -0,0 +1,258 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/statestore"
+	"github.com/containernetworking/plugins/pkg/tc"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+const ifname = "eth0"
+
+var _ = Describe("netem plugin", func() {
+	var targetNs ns.NetNS
+	var args *skel.CmdArgs
+	var dataDir string
+
+	BeforeEach(func() {
+		var err error
+		dataDir = GinkgoT().TempDir()
+		targetNs, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			veth := &netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: ifname},
+				PeerName:  "peer0",
+			}
+			return netlink.LinkAdd(veth)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		args = &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(targetNs.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(targetNs)).To(Succeed())
+	})
+
+	rootNetemOf := func() *netlink.Netem {
+		var netem *netlink.Netem
+		err := targetNs.Do(func(_ ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(ifname)
+			if err != nil {
+				return err
+			}
+			netem, err = rootNetem(link)
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return netem
+	}
+
+	It("installs, checks and removes a netem qdisc", func() {
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "netem",
+			"dataDir": %q,
+			"delay": 100, "jitter": 10, "loss": 1.5, "rate": 8000000,
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "192.168.1.2/24", "interface": 0}]
+			}
+		}`, dataDir))
+
+		_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		netem := rootNetemOf()
+		Expect(netem).NotTo(BeNil())
+		Expect(netem.Rate64).To(Equal(uint64(1000000)))
+		Expect(netem.Loss).To(Equal(netlink.Percentage2u32(1.5)))
+
+		Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(Succeed())
+
+		// CHECK notices different impairments
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "netem",
+			"dataDir": %q,
+			"delay": 200, "jitter": 10, "loss": 1.5, "rate": 8000000,
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "192.168.1.2/24", "interface": 0}]
+			}
+		}`, dataDir))
+		err = testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+		Expect(err).To(MatchError("netem delay does not match"))
+
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+		Expect(rootNetemOf()).To(BeNil())
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+	})
+
+	It("prefers the runtime config", func() {
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "netem",
+			"dataDir": %q,
+			"delay": 100, "runtimeConfig": {"netem": {"loss": 10}},
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "192.168.1.2/24", "interface": 0}]
+			}
+		}`, dataDir))
+
+		_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		netem := rootNetemOf()
+		Expect(netem).NotTo(BeNil())
+		Expect(netem.Latency).To(BeZero())
+		Expect(netem.Loss).To(Equal(netlink.Percentage2u32(10)))
+		Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(Succeed())
+	})
+
+	It("leaves the interface alone without impairments", func() {
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "netem",
+			"dataDir": %q,
+			"runtimeConfig": {"netem": {}},
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "192.168.1.2/24", "interface": 0}]
+			}
+		}`, dataDir))
+
+		_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rootNetemOf()).To(BeNil())
+		Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(Succeed())
+	})
+
+	It("rejects invalid impairments", func() {
+		for _, extra := range []string{
+			`"loss": 101,`,
+			`"duplicate": -1,`,
+			`"jitter": 10,`,
+			`"reorder": 25,`,
+		} {
+			_, err := parseConfig([]byte(fmt.Sprintf(`{
+				"cniVersion": "1.0.0",
+				"name": "test",
+				"type": "netem",
+				%s
+				"prevResult": {
+					"cniVersion": "1.0.0",
+					"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+					"ips": [{"address": "192.168.1.2/24", "interface": 0}]
+				}
+			}`, extra)))
+			Expect(err).To(HaveOccurred(), extra)
+		}
+	})
+
+	It("does nothing on DEL once the netns is gone", func() {
+		args.Netns = "/var/run/netns/nonexistent"
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "netem",
+			"dataDir": %q,
+			"delay": 100,
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "192.168.1.2/24", "interface": 0}]
+			}
+		}`, dataDir))
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+	})
+
+	It("only removes its own netem qdisc on DEL", func() {
+		args.StdinData = []byte(fmt.Sprintf(`{
+			"cniVersion": "1.0.0",
+			"name": "test",
+			"type": "netem",
+			"dataDir": %q,
+			"delay": 100
+		}`, dataDir))
+		conf, err := parseConfig(args.StdinData)
+		Expect(err).NotTo(HaveOccurred())
+
+		// The root qdisc was replaced by someone else since ADD
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(ifname)
+			if err != nil {
+				return err
+			}
+			return netlink.QdiscReplace(&netlink.GenericQdisc{
+				QdiscAttrs: netlink.QdiscAttrs{
+					LinkIndex: link.Attrs().Index,
+					Handle:    netlink.MakeHandle(1, 0),
+					Parent:    netlink.HANDLE_ROOT,
+				},
+				QdiscType: "pfifo",
+			})
+		})
+		Expect(err).NotTo(HaveOccurred())
+		store := netemStore(conf)
+		key := statestore.Key{ContainerID: args.ContainerID, IfName: ifname}
+		Expect(store.Save(key, &netemRecord{Handle: netlink.MakeHandle(1, 0)})).To(Succeed())
+
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(ifname)
+			if err != nil {
+				return err
+			}
+			root, err := tc.RootQdisc(link)
+			if err != nil {
+				return err
+			}
+			Expect(root.Type()).To(Equal("pfifo"))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		found, err := store.Load(key, &netemRecord{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+})
@@ -0,0 +1,223 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Datapath for the portmap plugin's ebpf backend.
+//
+// The plugin maintains the hostports and podports maps pinned in
+// /sys/fs/bpf/cni_hostport; this program only reads them. Build and pin it
+// with, e.g.:
+//
+//   clang -O2 -g -target bpf -c hostport.c -o hostport.o
+//   bpftool prog loadall hostport.o /sys/fs/bpf/cni_hostport/progs \
+//       pinmaps /sys/fs/bpf/cni_hostport
+//
+// and point the plugin's "ingressProgram" and "egressProgram" at
+// progs/hostport_ingress and progs/hostport_egress.
+//
+// The key and value layouts must match portmap_ebpf.go.
+
+#include <linux/bpf.h>
+#include <linux/if_ether.h>
+#include <linux/in.h>
+#include <linux/ip.h>
+#include <linux/ipv6.h>
+#include <linux/pkt_cls.h>
+#include <linux/tcp.h>
+#include <linux/udp.h>
+#include <bpf/bpf_endian.h>
+#include <bpf/bpf_helpers.h>
+
+struct hostport_key {
+	__u8 proto;
+	__u8 family; /* 4 or 6 */
+	__be16 port;
+	__u8 addr[16]; /* IPv4 in the first 4 bytes; all zero for any address */
+};
+
+struct hostport_value {
+	__u8 addr[16]; /* all zero: leave the address alone */
+	__be16 port;
+	__u8 flags;
+	__u8 pad[5];
+	__u64 net_hash;
+	__u64 id_hash;
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, 65536);
+	__type(key, struct hostport_key);
+	__type(value, struct hostport_value);
+	__uint(pinning, LIBBPF_PIN_BY_NAME);
+} hostports SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, 65536);
+	__type(key, struct hostport_key);
+	__type(value, struct hostport_value);
+	__uint(pinning, LIBBPF_PIN_BY_NAME);
+} podports SEC(".maps");
+
+struct l4 {
+	__u8 proto;
+	__u32 l3_off;
+	__u32 l4_off;
+	__u32 csum_off;
+	__u8 csum_flags;
+};
+
+static __always_inline int is_zero(const __u8 *addr, int len)
+{
+	for (int i = 0; i < len; i++)
+		if (addr[i])
+			return 0;
+	return 1;
+}
+
+/* parse fills in key from the packet's destination (ingress) or source
+ * (egress) address and port. */
+static __always_inline int parse(struct __sk_buff *skb, int egress, struct hostport_key *key, struct l4 *l4)
+{
+	void *data = (void *)(long)skb->data;
+	void *data_end = (void *)(long)skb->data_end;
+	struct ethhdr *eth = data;
+	__be16 *ports;
+
+	if ((void *)(eth + 1) > data_end)
+		return -1;
+
+	l4->l3_off = sizeof(*eth);
+	if (eth->h_proto == bpf_htons(ETH_P_IP)) {
+		struct iphdr *ip = (void *)(eth + 1);
+
+		if ((void *)(ip + 1) > data_end || ip->ihl != 5)
+			return -1;
+		key->family = 4;
+		key->proto = ip->protocol;
+		__builtin_memcpy(key->addr, egress ? &ip->saddr : &ip->daddr, 4);
+		l4->l4_off = l4->l3_off + sizeof(*ip);
+		l4->csum_flags = BPF_F_PSEUDO_HDR;
+	} else if (eth->h_proto == bpf_htons(ETH_P_IPV6)) {
+		struct ipv6hdr *ip6 = (void *)(eth + 1);
+
+		if ((void *)(ip6 + 1) > data_end)
+			return -1;
+		key->family = 6;
+		key->proto = ip6->nexthdr;
+		__builtin_memcpy(key->addr, egress ? &ip6->saddr : &ip6->daddr, 16);
+		l4->l4_off = l4->l3_off + sizeof(*ip6);
+		l4->csum_flags = BPF_F_PSEUDO_HDR;
+	} else {
+		return -1;
+	}
+
+	switch (key->proto) {
+	case IPPROTO_TCP:
+		l4->csum_off = l4->l4_off + offsetof(struct tcphdr, check);
+		break;
+	case IPPROTO_UDP:
+		l4->csum_off = l4->l4_off + offsetof(struct udphdr, check);
+		l4->csum_flags |= BPF_F_MARK_MANGLED_0;
+		break;
+	default:
+		return -1;
+	}
+	l4->proto = key->proto;
+
+	ports = data + l4->l4_off;
+	if ((void *)(ports + 2) > data_end)
+		return -1;
+	key->port = egress ? ports[0] : ports[1];
+	return 0;
+}
+
+/* rewrite replaces the destination (ingress) or source (egress) address and
+ * port of the packet with those in val, fixing up checksums. */
+static __always_inline int rewrite(struct __sk_buff *skb, int egress, const struct hostport_key *key,
+				   const struct hostport_value *val, const struct l4 *l4)
+{
+	__u32 port_off = l4->l4_off + (egress ? 0 : 2);
+	int ret;
+
+	if (!is_zero(val->addr, key->family == 4 ? 4 : 16)) {
+		if (key->family == 4) {
+			__u32 old, new;
+			__u32 addr_off = l4->l3_off + (egress ? offsetof(struct iphdr, saddr) : offsetof(struct iphdr, daddr));
+
+			__builtin_memcpy(&old, key->addr, 4);
+			__builtin_memcpy(&new, val->addr, 4);
+			ret = bpf_l4_csum_replace(skb, l4->csum_off, old, new, l4->csum_flags | 4);
+			ret |= bpf_l3_csum_replace(skb, l4->l3_off + offsetof(struct iphdr, check), old, new, 4);
+			ret |= bpf_skb_store_bytes(skb, addr_off, &new, 4, 0);
+		} else {
+			__u32 addr_off = l4->l3_off + (egress ? offsetof(struct ipv6hdr, saddr) : offsetof(struct ipv6hdr, daddr));
+			__s64 diff = bpf_csum_diff((__be32 *)key->addr, 16, (__be32 *)val->addr, 16, 0);
+
+			ret = bpf_l4_csum_replace(skb, l4->csum_off, 0, diff, l4->csum_flags);
+			ret |= bpf_skb_store_bytes(skb, addr_off, val->addr, 16, 0);
+		}
+		if (ret)
+			return TC_ACT_SHOT;
+	}
+
+	if (val->port != key->port) {
+		ret = bpf_l4_csum_replace(skb, l4->csum_off, key->port, val->port, sizeof(__be16));
+		ret |= bpf_skb_store_bytes(skb, port_off, &val->port, sizeof(__be16), 0);
+		if (ret)
+			return TC_ACT_SHOT;
+	}
+	return TC_ACT_OK;
+}
+
+SEC("tc")
+int hostport_ingress(struct __sk_buff *skb)
+{
+	struct hostport_key key = {};
+	struct hostport_value *val;
+	struct l4 l4 = {};
+
+	if (parse(skb, 0, &key, &l4))
+		return TC_ACT_OK;
+
+	/* Exact host IP first, then any address */
+	val = bpf_map_lookup_elem(&hostports, &key);
+	if (!val) {
+		struct hostport_key any = key;
+
+		__builtin_memset(any.addr, 0, sizeof(any.addr));
+		val = bpf_map_lookup_elem(&hostports, &any);
+		if (!val)
+			return TC_ACT_OK;
+	}
+	return rewrite(skb, 0, &key, val, &l4);
+}
+
+SEC("tc")
+int hostport_egress(struct __sk_buff *skb)
+{
+	struct hostport_key key = {};
+	struct hostport_value *val;
+	struct l4 l4 = {};
+
+	if (parse(skb, 1, &key, &l4))
+		return TC_ACT_OK;
+
+	val = bpf_map_lookup_elem(&podports, &key);
+	if (!val)
+		return TC_ACT_OK;
+	return rewrite(skb, 1, &key, val, &l4);
+}
+
+char _license[] SEC("license") = "Apache-2.0";
@@ -0,0 +1,209 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// This is a minimal wrapper around the bpf(2) syscall, covering just what
+// the ebpf backend needs: creating, pinning and opening hash maps, and
+// manipulating their elements. The attr layouts follow union bpf_attr in
+// include/uapi/linux/bpf.h.
+
+// bpfMap is an open BPF map.
+type bpfMap struct {
+	fd        int
+	keySize   int
+	valueSize int
+}
+
+// bpfAttrMapCreate is the BPF_MAP_CREATE part of union bpf_attr
+type bpfAttrMapCreate struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+// bpfAttrObj is the BPF_OBJ_* part of union bpf_attr
+type bpfAttrObj struct {
+	pathname  uint64
+	bpfFd     uint32
+	fileFlags uint32
+}
+
+// bpfAttrMapElem is the BPF_MAP_*_ELEM part of union bpf_attr
+type bpfAttrMapElem struct {
+	mapFd uint32
+	_     uint32
+	key   uint64
+	value uint64 // or next_key
+	flags uint64
+}
+
+func bpfCall(cmd int, attr unsafe.Pointer, size uintptr) (uintptr, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
+
+// bpfObjGet opens the BPF object pinned at path.
+func bpfObjGet(path string) (int, error) {
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return -1, err
+	}
+	attr := bpfAttrObj{pathname: uint64(uintptr(unsafe.Pointer(p)))}
+	fd, err := bpfCall(unix.BPF_OBJ_GET, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(p)
+	if err != nil {
+		return -1, err
+	}
+	return int(fd), nil
+}
+
+// bpfObjPin pins the BPF object fd at path.
+func bpfObjPin(fd int, path string) error {
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attr := bpfAttrObj{pathname: uint64(uintptr(unsafe.Pointer(p))), bpfFd: uint32(fd)}
+	_, err = bpfCall(unix.BPF_OBJ_PIN, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(p)
+	return err
+}
+
+// openOrCreatePinnedMap opens the hash map pinned at path, creating and
+// pinning it first if it does not exist yet.
+func openOrCreatePinnedMap(path string, keySize, valueSize, maxEntries int) (*bpfMap, error) {
+	fd, err := bpfObjGet(path)
+	if err == nil {
+		return &bpfMap{fd: fd, keySize: keySize, valueSize: valueSize}, nil
+	}
+	if !errors.Is(err, unix.ENOENT) {
+		return nil, fmt.Errorf("failed to open pinned map %s: %v", path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	attr := bpfAttrMapCreate{
+		mapType:    unix.BPF_MAP_TYPE_HASH,
+		keySize:    uint32(keySize),
+		valueSize:  uint32(valueSize),
+		maxEntries: uint32(maxEntries),
+	}
+	r, err := bpfCall(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create map %s: %v", path, err)
+	}
+	m := &bpfMap{fd: int(r), keySize: keySize, valueSize: valueSize}
+
+	if err := bpfObjPin(m.fd, path); err != nil {
+		m.close()
+		// We may have raced with another invocation; use its map
+		if errors.Is(err, unix.EEXIST) {
+			return openOrCreatePinnedMap(path, keySize, valueSize, maxEntries)
+		}
+		return nil, fmt.Errorf("failed to pin map at %s: %v", path, err)
+	}
+	return m, nil
+}
+
+func (m *bpfMap) close() {
+	unix.Close(m.fd)
+}
+
+func (m *bpfMap) elemCall(cmd int, key, value []byte, flags uint64) error {
+	if len(key) != m.keySize || (value != nil && len(value) != m.valueSize) {
+		return fmt.Errorf("bpf map element size mismatch")
+	}
+	attr := bpfAttrMapElem{
+		mapFd: uint32(m.fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		flags: flags,
+	}
+	if value != nil {
+		attr.value = uint64(uintptr(unsafe.Pointer(&value[0])))
+	}
+	_, err := bpfCall(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+	return err
+}
+
+// insert adds key with value. It fails with unix.EEXIST, leaving the
+// element be, if key is already in the map.
+func (m *bpfMap) insert(key, value []byte) error {
+	return m.elemCall(unix.BPF_MAP_UPDATE_ELEM, key, value, unix.BPF_NOEXIST)
+}
+
+// lookup returns the value of key, or nil if key is not in the map.
+func (m *bpfMap) lookup(key []byte) ([]byte, error) {
+	value := make([]byte, m.valueSize)
+	err := m.elemCall(unix.BPF_MAP_LOOKUP_ELEM, key, value, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil, nil
+	}
+	return value, err
+}
+
+// delete removes key. It is not an error if key is not in the map.
+func (m *bpfMap) delete(key []byte) error {
+	err := m.elemCall(unix.BPF_MAP_DELETE_ELEM, key, nil, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	return err
+}
+
+// keys returns all keys currently in the map.
+func (m *bpfMap) keys() ([][]byte, error) {
+	keys := [][]byte{}
+	var cur []byte
+	for {
+		next := make([]byte, m.keySize)
+		attr := bpfAttrMapElem{
+			mapFd: uint32(m.fd),
+			value: uint64(uintptr(unsafe.Pointer(&next[0]))),
+		}
+		if cur != nil {
+			attr.key = uint64(uintptr(unsafe.Pointer(&cur[0])))
+		}
+		_, err := bpfCall(unix.BPF_MAP_GET_NEXT_KEY, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+		runtime.KeepAlive(cur)
+		runtime.KeepAlive(next)
+		if errors.Is(err, unix.ENOENT) {
+			return keys, nil
+		} else if err != nil {
+			return nil, err
+		}
+		keys = append(keys, next)
+		cur = next
+	}
+}
@@ -154,3 +154,122 @@ func checkRule(ipt *iptables.IPTables, table, chain string, rule []string) bool
 	}
 	return exists
 }
+
+// setupChains creates the chains, like setup, but as a single
+// iptables-restore transaction: each chain is (re)declared, which flushes
+// any stale rules, and its rules and missing entry rules are added in one
+// exec regardless of the number of rules.
+// If iptables-restore is not installed, the chains are set up one rule at a
+// time.
+func setupChains(ipt *iptables.IPTables, chains ...*chain) error {
+	if !utils.HasIPTablesRestore(ipt) {
+		for _, c := range chains {
+			if err := c.setup(ipt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	payload := utils.RestorePayload{}
+	for _, c := range chains {
+		haveEntry := func(entryChain string, rule []string) bool {
+			return checkRule(ipt, c.table, entryChain, rule)
+		}
+		payload.Add(c.table, c.setupLines(haveEntry)...)
+	}
+
+	return utils.IPTablesRestore(ipt, &payload)
+}
+
+// teardownChains deletes the chains, like teardown, but as a single
+// iptables-restore transaction. Chains that do not exist are skipped.
+// If the transaction fails, e.g. because a concurrent teardown removed an
+// entry rule in the meantime, it falls back to the idempotent teardown of
+// each chain.
+func teardownChains(ipt *iptables.IPTables, chains ...*chain) error {
+	if utils.HasIPTablesRestore(ipt) {
+		payload := utils.RestorePayload{}
+		listed := map[string][]string{}
+		for _, c := range chains {
+			exists, err := ipt.ChainExists(c.table, c.name)
+			if err == nil && !exists {
+				continue
+			}
+
+			entryRefs := []string{}
+			for _, entryChain := range c.entryChains {
+				key := c.table + "/" + entryChain
+				if _, ok := listed[key]; !ok {
+					// Swallow errors - probably the chain doesn't exist
+					listed[key], _ = ipt.List(c.table, entryChain)
+				}
+				for _, entryChainRule := range listed[key] {
+					if strings.HasSuffix(entryChainRule, "-j "+c.name) {
+						entryRefs = append(entryRefs, entryChainRule)
+					}
+				}
+			}
+			payload.Add(c.table, c.teardownLines(entryRefs)...)
+		}
+
+		if payload.Empty() {
+			return nil
+		}
+		if err := utils.IPTablesRestore(ipt, &payload); err == nil {
+			return nil
+		}
+	}
+
+	for _, c := range chains {
+		if err := c.teardown(ipt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupLines renders the iptables-restore commands that create the chain,
+// or flush it if it exists, fill it with the rules and add the entry rules
+// for which haveEntry returns false.
+func (c *chain) setupLines(haveEntry func(entryChain string, rule []string) bool) []string {
+	lines := []string{
+		fmt.Sprintf(":%s - [0:0]", c.name),
+		fmt.Sprintf("-F %s", c.name),
+	}
+	for _, rule := range c.rules {
+		lines = append(lines, utils.RestoreRule("-A", c.name, rule))
+	}
+
+	for _, entryChain := range c.entryChains {
+		for _, rule := range c.entryRules {
+			r := []string{}
+			r = append(r, rule...)
+			r = append(r, "-j", c.name)
+			if haveEntry(entryChain, r) {
+				continue
+			}
+			if c.prependEntry {
+				lines = append(lines, utils.RestoreRule("-I", entryChain, append([]string{"1"}, r...)))
+			} else {
+				lines = append(lines, utils.RestoreRule("-A", entryChain, r))
+			}
+		}
+	}
+
+	return lines
+}
+
+// teardownLines renders the iptables-restore commands that delete the
+// chain. entryRefs are the rules jumping to the chain as listed by
+// iptables -S, they are deleted first.
+func (c *chain) teardownLines(entryRefs []string) []string {
+	lines := []string{}
+	for _, ref := range entryRefs {
+		lines = append(lines, "-D"+strings.TrimPrefix(ref, "-A"))
+	}
+	return append(lines,
+		fmt.Sprintf("-F %s", c.name),
+		fmt.Sprintf("-X %s", c.name),
+	)
+}
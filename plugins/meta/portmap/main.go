@@ -26,7 +26,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"net"
@@ -37,6 +36,12 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/config"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
+	cnilog "github.com/containernetworking/plugins/pkg/log"
+	"github.com/containernetworking/plugins/pkg/status"
+	"github.com/containernetworking/plugins/pkg/trace"
 	"github.com/containernetworking/plugins/pkg/utils"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
@@ -45,12 +50,15 @@ type PortMapper interface {
 	forwardPorts(config *PortMapConf, containerNet net.IPNet) error
 	checkPorts(config *PortMapConf, containerNet net.IPNet) error
 	unforwardPorts(config *PortMapConf) error
+	gcPorts(config *PortMapConf, valid *gc.Attachments) error
+	status(config *PortMapConf) error
 }
 
 // These are vars rather than consts so we can "&" them
 var (
 	iptablesBackend = "iptables"
 	nftablesBackend = "nftables"
+	ebpfBackend     = "ebpf"
 )
 
 // PortMapEntry corresponds to a single entry in the port_mappings argument,
@@ -60,6 +68,12 @@ type PortMapEntry struct {
 	ContainerPort int    `json:"containerPort"`
 	Protocol      string `json:"protocol"`
 	HostIP        string `json:"hostIP,omitempty"`
+
+	// SNAT overrides the plugin-wide snat setting for this mapping
+	SNAT *bool `json:"snat,omitempty"`
+	// SNATSourceIP, if set, is the source address that SNATed traffic for
+	// this mapping gets, rather than masquerading as the outgoing interface
+	SNATSourceIP string `json:"snatSourceIP,omitempty"`
 }
 
 type PortMapConf struct {
@@ -81,6 +95,9 @@ type PortMapConf struct {
 	// iptables-backend-specific config
 	ExternalSetMarkChain *string `json:"externalSetMarkChain"`
 
+	// ebpf-backend-specific config
+	EBPF *EBPFConf `json:"ebpf,omitempty"`
+
 	// These are fields parsed out of the config or the environment;
 	// included here for convenience
 	ContainerID string    `json:"-"`
@@ -93,7 +110,7 @@ type PortMapConf struct {
 const DefaultMarkBit = 13
 
 func cmdAdd(args *skel.CmdArgs) error {
-	netConf, _, err := parseConfig(args.StdinData, args.IfName)
+	netConf, _, err := parseConfig(args.StdinData, args.IfName, args.Args)
 	if err != nil {
 		return fmt.Errorf("failed to parse config: %v", err)
 	}
@@ -112,6 +129,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 		if err := netConf.mapper.forwardPorts(netConf, netConf.ContIPv4); err != nil {
 			return err
 		}
+		cnilog.Debug("forwarded ports", "backend", *netConf.Backend, "ip", netConf.ContIPv4.IP, "mappings", len(netConf.RuntimeConfig.PortMaps))
 		// Delete conntrack entries for UDP to avoid conntrack blackholing traffic
 		// due to stale connections. We do that after the iptables rules are set, so
 		// the new traffic uses them. Failures are informative only.
@@ -119,7 +137,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 			log.Printf("failed to delete stale UDP conntrack entries for %s: %v", netConf.ContIPv4.IP, err)
 		}
 
-		if *netConf.SNAT {
+		if anySNAT(netConf, false) {
 			// Set the route_localnet bit on the host interface, so that
 			// 127/8 can cross a routing boundary.
 			hostIfName := getRoutableHostIF(netConf.ContIPv4.IP)
@@ -135,6 +153,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 		if err := netConf.mapper.forwardPorts(netConf, netConf.ContIPv6); err != nil {
 			return err
 		}
+		cnilog.Debug("forwarded ports", "backend", *netConf.Backend, "ip", netConf.ContIPv6.IP, "mappings", len(netConf.RuntimeConfig.PortMaps))
 		// Delete conntrack entries for UDP to avoid conntrack blackholing traffic
 		// due to stale connections. We do that after the iptables rules are set, so
 		// the new traffic uses them. Failures are informative only.
@@ -148,7 +167,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 }
 
 func cmdDel(args *skel.CmdArgs) error {
-	netConf, _, err := parseConfig(args.StdinData, args.IfName)
+	netConf, _, err := parseConfig(args.StdinData, args.IfName, args.Args)
 	if err != nil {
 		return fmt.Errorf("failed to parse config: %v", err)
 	}
@@ -165,17 +184,59 @@ func cmdDel(args *skel.CmdArgs) error {
 }
 
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
-		Add:   cmdAdd,
-		Check: cmdCheck,
-		Del:   cmdDel,
-		/* FIXME GC */
-		/* FIXME Status */
-	}, version.All, bv.BuildString("portmap"))
+	skel.PluginMainFuncs(cnilog.Wrap("portmap", trace.Wrap("portmap", skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		GC:     cmdGC,
+		Status: cmdStatus,
+	})), version.All, bv.BuildString("portmap"))
+}
+
+// cmdStatus reports whether the configured backend is usable, so that the
+// runtime can mark the network as not ready rather than fail the first
+// container with an obscure rule insertion error.
+func cmdStatus(args *skel.CmdArgs) error {
+	netConf, _, err := parseConfig(args.StdinData, args.IfName, args.Args)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	if err := netConf.mapper.status(netConf); err != nil {
+		return types.NewError(cnierrors.ErrPluginNotAvailable, fmt.Sprintf("portmap %s backend is not available", *netConf.Backend), err.Error())
+	}
+	return status.KernelModules(statusModules(netConf)...)
+}
+
+// statusModules returns the kernel modules the backend of netConf needs.
+func statusModules(netConf *PortMapConf) []string {
+	switch *netConf.Backend {
+	case iptablesBackend:
+		return []string{"nf_conntrack", "nf_nat"}
+	case nftablesBackend:
+		return []string{"nf_conntrack", "nf_nat", "nf_tables"}
+	case ebpfBackend:
+		// The datapath programs are attached to clsact qdiscs
+		if len(netConf.EBPF.Interfaces) > 0 {
+			return []string{"sch_ingress", "cls_bpf"}
+		}
+	}
+	return nil
+}
+
+// cmdGC removes the port mappings of any container on this network that the
+// runtime no longer considers valid, e.g. because a DEL was lost in a crash.
+func cmdGC(args *skel.CmdArgs) error {
+	netConf, _, err := parseConfig(args.StdinData, args.IfName, args.Args)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	return netConf.mapper.gcPorts(netConf, gc.NewAttachments(netConf.ValidAttachments))
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
-	conf, result, err := parseConfig(args.StdinData, args.IfName)
+	conf, result, err := parseConfig(args.StdinData, args.IfName, args.Args)
 	if err != nil {
 		return err
 	}
@@ -207,10 +268,12 @@ func cmdCheck(args *skel.CmdArgs) error {
 }
 
 // parseConfig parses the supplied configuration (and prevResult) from stdin.
-func parseConfig(stdin []byte, ifName string) (*PortMapConf, *current.Result, error) {
+func parseConfig(stdin []byte, ifName, envArgs string) (*PortMapConf, *current.Result, error) {
+	defer trace.Start("parseConfig").End()
+
 	conf := PortMapConf{}
 
-	if err := json.Unmarshal(stdin, &conf); err != nil {
+	if err := config.Decode(stdin, envArgs, &conf); err != nil {
 		return nil, nil, fmt.Errorf("failed to parse network configuration: %v", err)
 	}
 
@@ -259,6 +322,18 @@ func parseConfig(stdin []byte, ifName string) (*PortMapConf, *current.Result, er
 	case nftablesBackend:
 		conf.mapper = &portMapperNFTables{}
 
+	case ebpfBackend:
+		if conf.EBPF == nil {
+			conf.EBPF = &EBPFConf{}
+		}
+		if conf.EBPF.MapDir == "" {
+			conf.EBPF.MapDir = defaultEBPFMapDir
+		}
+		if (conf.EBPF.IngressProgram != "" || conf.EBPF.EgressProgram != "") && len(conf.EBPF.Interfaces) == 0 {
+			return nil, nil, fmt.Errorf("ebpf programs were given but no interfaces to attach them to")
+		}
+		conf.mapper = &portMapperEBPF{}
+
 	default:
 		return nil, nil, fmt.Errorf("unrecognized backend %q", *conf.Backend)
 	}
@@ -271,6 +346,17 @@ func parseConfig(stdin []byte, ifName string) (*PortMapConf, *current.Result, er
 		if pm.HostPort <= 0 {
 			return nil, nil, fmt.Errorf("Invalid host port number: %d", pm.HostPort)
 		}
+		if pm.SNATSourceIP != "" {
+			if net.ParseIP(pm.SNATSourceIP) == nil {
+				return nil, nil, fmt.Errorf("Invalid SNAT source IP: %q", pm.SNATSourceIP)
+			}
+			if pm.SNAT != nil && !*pm.SNAT {
+				return nil, nil, fmt.Errorf("SNAT source IP %s given for host port %d, which has SNAT disabled", pm.SNATSourceIP, pm.HostPort)
+			}
+		}
+		if *conf.Backend == ebpfBackend && (pm.SNAT != nil || pm.SNATSourceIP != "") {
+			return nil, nil, fmt.Errorf("the ebpf backend does not support per-mapping SNAT settings")
+		}
 	}
 
 	if conf.PrevResult != nil {
@@ -303,6 +389,38 @@ func parseConfig(stdin []byte, ifName string) (*PortMapConf, *current.Result, er
 	return &conf, result, nil
 }
 
+// snatFor returns whether traffic forwarded by e to a container address of
+// the given family should be SNATed and, if so, the source address to use.
+// A nil address means the traffic is masqueraded. An SNAT source of the
+// other family does not apply, so that traffic is masqueraded instead.
+// Giving an SNAT source implies enabling SNAT for the mapping.
+func snatFor(config *PortMapConf, e *PortMapEntry, isV6 bool) (bool, net.IP) {
+	snat := *config.SNAT || e.SNATSourceIP != ""
+	if e.SNAT != nil {
+		snat = *e.SNAT
+	}
+	if !snat || e.SNATSourceIP == "" {
+		return snat, nil
+	}
+
+	source := net.ParseIP(e.SNATSourceIP)
+	if (source.To4() == nil) != isV6 {
+		return true, nil
+	}
+	return true, source
+}
+
+// anySNAT returns whether any of the port mappings SNATs traffic to a
+// container address of the given family.
+func anySNAT(config *PortMapConf, isV6 bool) bool {
+	for i := range config.RuntimeConfig.PortMaps {
+		if snat, _ := snatFor(config, &config.RuntimeConfig.PortMaps[i], isV6); snat {
+			return true
+		}
+	}
+	return false
+}
+
 // ensureBackend validates and/or sets conf.Backend
 func ensureBackend(conf *PortMapConf) error {
 	backendConfig := make(map[string][]string)
@@ -317,8 +435,13 @@ func ensureBackend(conf *PortMapConf) error {
 		backendConfig[conditionsBackend] = append(backendConfig[conditionsBackend], "conditionsV6")
 	}
 
+	if conf.EBPF != nil {
+		backendConfig[ebpfBackend] = append(backendConfig[ebpfBackend], "ebpf")
+	}
+
 	// If backend wasn't requested explicitly, default to iptables, unless it is not
 	// available (and nftables is). FIXME: flip this default at some point.
+	// The ebpf backend is only ever used when requested.
 	if conf.Backend == nil {
 		if !utils.SupportsIPTables() && utils.SupportsNFTables() {
 			conf.Backend = &nftablesBackend
@@ -328,14 +451,13 @@ func ensureBackend(conf *PortMapConf) error {
 	}
 
 	// Make sure we dont have config for the wrong backend
-	var wrongBackend string
-	if *conf.Backend == iptablesBackend {
-		wrongBackend = nftablesBackend
-	} else {
-		wrongBackend = iptablesBackend
-	}
-	if len(backendConfig[wrongBackend]) > 0 {
-		return fmt.Errorf("%s backend was requested but configuration contains %s-specific options %v", *conf.Backend, wrongBackend, backendConfig[wrongBackend])
+	for _, wrongBackend := range []string{iptablesBackend, nftablesBackend, ebpfBackend} {
+		if wrongBackend == *conf.Backend {
+			continue
+		}
+		if len(backendConfig[wrongBackend]) > 0 {
+			return fmt.Errorf("%s backend was requested but configuration contains %s-specific options %v", *conf.Backend, wrongBackend, backendConfig[wrongBackend])
+		}
 	}
 
 	// OK
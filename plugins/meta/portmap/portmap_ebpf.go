@@ -0,0 +1,507 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/utils"
+)
+
+// The ebpf backend doesn't program any rules itself. Instead it maintains two
+// pinned BPF hash maps that are consumed by a TC datapath program (see
+// bpf/hostport.c):
+//
+// hostports: (proto, family, hostPort, hostIP) -> (podIP, podPort, owner)
+// podports:  (proto, family, podPort, podIP)   -> (hostIP, hostPort, owner)
+//
+// The program attached on ingress of the host interfaces rewrites the
+// destination of packets matching hostports, first looking up the exact host
+// IP and then the unspecified address. The program attached on egress
+// rewrites the source of replies matching podports. The translation is
+// stateless, so packets skip iptables/nftables NAT and conntrack entirely.
+// Mappings without a hostIP only get their source port restored on egress;
+// the source address is left to the node's masquerading rules.
+//
+// As with the nftables backend, each element records a hash of the network
+// name and container ID that created it, so that DEL and GC only remove
+// their own mappings.
+
+const (
+	defaultEBPFMapDir = "/sys/fs/bpf/cni_hostport"
+	hostPortsMapName  = "hostports"
+	podPortsMapName   = "podports"
+	ebpfFilterName    = "cni_hostport"
+
+	ebpfMaxEntries = 65536
+	ebpfKeySize    = 20
+	ebpfValueSize  = 40
+)
+
+// EBPFConf is the ebpf-backend-specific config.
+type EBPFConf struct {
+	// MapDir is where the hostports and podports maps are pinned.
+	MapDir string `json:"mapDir,omitempty"`
+	// IngressProgram and EgressProgram are the pin paths of the datapath
+	// programs. If set, they are attached to Interfaces.
+	IngressProgram string   `json:"ingressProgram,omitempty"`
+	EgressProgram  string   `json:"egressProgram,omitempty"`
+	Interfaces     []string `json:"interfaces,omitempty"`
+}
+
+// bpfHashMap is the subset of map operations used by the backend, so that
+// tests can substitute an in-memory map.
+type bpfHashMap interface {
+	insert(key, value []byte) error
+	lookup(key []byte) ([]byte, error)
+	delete(key []byte) error
+	keys() ([][]byte, error)
+	close()
+}
+
+type portMapperEBPF struct {
+	hostPorts bpfHashMap
+	podPorts  bpfHashMap
+}
+
+// openMaps opens (or creates) the pinned maps, unless already open.
+func (pm *portMapperEBPF) openMaps(config *PortMapConf) error {
+	if pm.hostPorts != nil && pm.podPorts != nil {
+		return nil
+	}
+
+	dir := config.EBPF.MapDir
+	hostPorts, err := openOrCreatePinnedMap(filepath.Join(dir, hostPortsMapName), ebpfKeySize, ebpfValueSize, ebpfMaxEntries)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to open eBPF maps in %s", dir)
+	}
+	podPorts, err := openOrCreatePinnedMap(filepath.Join(dir, podPortsMapName), ebpfKeySize, ebpfValueSize, ebpfMaxEntries)
+	if err != nil {
+		hostPorts.close()
+		return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to open eBPF maps in %s", dir)
+	}
+	pm.hostPorts = hostPorts
+	pm.podPorts = podPorts
+	return nil
+}
+
+func fnvHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func protoNumber(proto string) (uint8, error) {
+	switch strings.ToLower(proto) {
+	case "tcp":
+		return utils.PROTOCOL_TCP, nil
+	case "udp":
+		return utils.PROTOCOL_UDP, nil
+	case "sctp":
+		return utils.PROTOCOL_SCTP, nil
+	}
+	return 0, fmt.Errorf("unsupported protocol %q", proto)
+}
+
+// ebpfKey encodes a map key. IPv4 addresses occupy the first four address
+// bytes; a nil or unspecified ip is encoded as all zeroes.
+func ebpfKey(proto uint8, isV6 bool, port int, ip net.IP) []byte {
+	key := make([]byte, ebpfKeySize)
+	key[0] = proto
+	key[1] = 4
+	if isV6 {
+		key[1] = 6
+	}
+	binary.BigEndian.PutUint16(key[2:], uint16(port))
+	putEBPFAddr(key[4:20], isV6, ip)
+	return key
+}
+
+// ebpfValue encodes a map value: the translated address and port, followed
+// by the owner hashes.
+func ebpfValue(isV6 bool, ip net.IP, port int, netHash, idHash uint64) []byte {
+	value := make([]byte, ebpfValueSize)
+	putEBPFAddr(value[0:16], isV6, ip)
+	binary.BigEndian.PutUint16(value[16:], uint16(port))
+	binary.LittleEndian.PutUint64(value[24:], netHash)
+	binary.LittleEndian.PutUint64(value[32:], idHash)
+	return value
+}
+
+func putEBPFAddr(dst []byte, isV6 bool, ip net.IP) {
+	if ip == nil || ip.IsUnspecified() {
+		return
+	}
+	if isV6 {
+		copy(dst, ip.To16())
+	} else {
+		copy(dst, ip.To4())
+	}
+}
+
+func ebpfValueOwner(value []byte) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(value[24:]), binary.LittleEndian.Uint64(value[32:])
+}
+
+// reverseKey returns the podports key that corresponds to a hostports
+// element.
+func reverseKey(key, value []byte) []byte {
+	rkey := make([]byte, ebpfKeySize)
+	copy(rkey[0:2], key[0:2])
+	copy(rkey[2:4], value[16:18])
+	copy(rkey[4:20], value[0:16])
+	return rkey
+}
+
+// ebpfEntries returns the hostports and podports elements for config's
+// mappings to containerNet.
+func ebpfEntries(config *PortMapConf, containerNet net.IPNet) ([][4][]byte, error) {
+	isV6 := containerNet.IP.To4() == nil
+	netHash := fnvHash(config.Name)
+	idHash := fnvHash(config.ContainerID)
+
+	entries := [][4][]byte{}
+	podPorts := map[string]int{}
+	for _, e := range config.RuntimeConfig.PortMaps {
+		var hostIP net.IP
+		if e.HostIP != "" {
+			hostIP = net.ParseIP(e.HostIP)
+			// Ignore wrong-IP-family HostIPs
+			if isV6 != (hostIP.To4() == nil) {
+				continue
+			}
+		}
+
+		proto, err := protoNumber(e.Protocol)
+		if err != nil {
+			return nil, err
+		}
+
+		// Replies are translated by pod port alone, so each pod port can
+		// only be reached through a single host port.
+		podKey := fmt.Sprintf("%d/%d", proto, e.ContainerPort)
+		if hp, ok := podPorts[podKey]; ok && hp != e.HostPort {
+			return nil, fmt.Errorf("ebpf backend cannot map both host ports %d and %d to container port %s", hp, e.HostPort, podKey)
+		}
+		podPorts[podKey] = e.HostPort
+
+		entries = append(entries, [4][]byte{
+			ebpfKey(proto, isV6, e.HostPort, hostIP),
+			ebpfValue(isV6, containerNet.IP, e.ContainerPort, netHash, idHash),
+			ebpfKey(proto, isV6, e.ContainerPort, containerNet.IP),
+			ebpfValue(isV6, hostIP, e.HostPort, netHash, idHash),
+		})
+	}
+	return entries, nil
+}
+
+// forwardPorts establishes port forwarding to a given container IP.
+// containerNet.IP can be either v4 or v6.
+func (pm *portMapperEBPF) forwardPorts(config *PortMapConf, containerNet net.IPNet) error {
+	entries, err := ebpfEntries(config, containerNet)
+	if err != nil {
+		return err
+	}
+
+	if err := pm.openMaps(config); err != nil {
+		return err
+	}
+
+	// Elements are only ever added, never replaced, so that a port mapped
+	// to another container is reported rather than taken over. The elements
+	// added before a failure are removed again.
+	type elem struct {
+		m   bpfHashMap
+		key []byte
+	}
+	added := []elem{}
+	rollback := func() {
+		for _, el := range added {
+			_ = el.m.delete(el.key)
+		}
+	}
+	for _, e := range entries {
+		ok, err := insertEBPFElem(pm.hostPorts, "host", e[0], e[1])
+		if err != nil {
+			rollback()
+			return err
+		}
+		if ok {
+			added = append(added, elem{pm.hostPorts, e[0]})
+		}
+		ok, err = insertEBPFElem(pm.podPorts, "container", e[2], e[3])
+		if err != nil {
+			rollback()
+			return err
+		}
+		if ok {
+			added = append(added, elem{pm.podPorts, e[2]})
+		}
+	}
+
+	return attachEBPFPrograms(config.EBPF)
+}
+
+// insertEBPFElem adds key to m, returning whether it did. An identical
+// element, left by an earlier ADD of the same container, is accepted; any
+// other is a conflict.
+func insertEBPFElem(m bpfHashMap, kind string, key, value []byte) (bool, error) {
+	err := m.insert(key, value)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, unix.EEXIST) {
+		return false, fmt.Errorf("failed to add map element: %v", err)
+	}
+	existing, err := m.lookup(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up map element: %v", err)
+	}
+	if existing != nil && bytes.Equal(existing, value) {
+		return false, nil
+	}
+	return false, cnierrors.Newf(cnierrors.ErrPortInUse, "%s %s port %d is already mapped to another container", kind, protoName(key[0]), binary.BigEndian.Uint16(key[2:]))
+}
+
+func protoName(proto uint8) string {
+	switch proto {
+	case utils.PROTOCOL_TCP:
+		return "tcp"
+	case utils.PROTOCOL_UDP:
+		return "udp"
+	case utils.PROTOCOL_SCTP:
+		return "sctp"
+	}
+	return fmt.Sprintf("protocol %d", proto)
+}
+
+func (pm *portMapperEBPF) checkPorts(config *PortMapConf, containerNet net.IPNet) error {
+	entries, err := ebpfEntries(config, containerNet)
+	if err != nil {
+		return err
+	}
+
+	if err := pm.openMaps(config); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		value, err := pm.hostPorts.lookup(e[0])
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(value, e[1]) {
+			return fmt.Errorf("missing or mismatched hostport map element for port %d", binary.BigEndian.Uint16(e[0][2:]))
+		}
+		value, err = pm.podPorts.lookup(e[2])
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(value, e[3]) {
+			return fmt.Errorf("missing or mismatched podport map element for port %d", binary.BigEndian.Uint16(e[2][2:]))
+		}
+	}
+
+	return nil
+}
+
+// unforwardPorts deletes any map elements created for this container.
+// It should be idempotent - it will not error if the maps do not exist.
+func (pm *portMapperEBPF) unforwardPorts(config *PortMapConf) error {
+	netHash := fnvHash(config.Name)
+	idHash := fnvHash(config.ContainerID)
+	return pm.deleteMatching(config, func(n, i uint64) bool {
+		return n == netHash && i == idHash
+	})
+}
+
+// gcPorts deletes the map elements of any container on this network that
+// has no valid attachment.
+func (pm *portMapperEBPF) gcPorts(config *PortMapConf, valid *gc.Attachments) error {
+	netHash := fnvHash(config.Name)
+	validHashes := map[uint64]bool{}
+	for _, id := range valid.ContainerIDs() {
+		validHashes[fnvHash(id)] = true
+	}
+	return pm.deleteMatching(config, func(n, i uint64) bool {
+		return n == netHash && !validHashes[i]
+	})
+}
+
+func (pm *portMapperEBPF) deleteMatching(config *PortMapConf, match func(netHash, idHash uint64) bool) error {
+	if err := pm.openMaps(config); err != nil {
+		return err
+	}
+
+	keys, err := pm.hostPorts.keys()
+	if err != nil {
+		return fmt.Errorf("failed to list hostport map elements: %v", err)
+	}
+	for _, key := range keys {
+		value, err := pm.hostPorts.lookup(key)
+		if err != nil {
+			return err
+		}
+		if value == nil || !match(ebpfValueOwner(value)) {
+			continue
+		}
+		if err := pm.hostPorts.delete(key); err != nil {
+			return fmt.Errorf("failed to delete hostport map element: %v", err)
+		}
+
+		// Only delete the reverse element if it is still ours; the pod IP
+		// may have been reused already.
+		rkey := reverseKey(key, value)
+		rvalue, err := pm.podPorts.lookup(rkey)
+		if err != nil {
+			return err
+		}
+		if rvalue == nil || !match(ebpfValueOwner(rvalue)) {
+			continue
+		}
+		if err := pm.podPorts.delete(rkey); err != nil {
+			return fmt.Errorf("failed to delete podport map element: %v", err)
+		}
+	}
+	return nil
+}
+
+// status checks that the maps can be opened, and that the datapath programs
+// and the interfaces to attach them to exist.
+func (pm *portMapperEBPF) status(config *PortMapConf) error {
+	if err := pm.openMaps(config); err != nil {
+		return err
+	}
+
+	for _, pin := range []string{config.EBPF.IngressProgram, config.EBPF.EgressProgram} {
+		if pin == "" {
+			continue
+		}
+		fd, err := bpfObjGet(pin)
+		if err != nil {
+			return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to open pinned program %s", pin)
+		}
+		unix.Close(fd)
+	}
+
+	for _, ifName := range config.EBPF.Interfaces {
+		if _, err := netlinksafe.LinkByName(ifName); err != nil {
+			return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to look up interface %q", ifName)
+		}
+	}
+	return nil
+}
+
+// attachEBPFPrograms idempotently attaches the datapath programs to the
+// configured host interfaces.
+func attachEBPFPrograms(conf *EBPFConf) error {
+	for _, ifName := range conf.Interfaces {
+		link, err := netlinksafe.LinkByName(ifName)
+		if err != nil {
+			return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to look up interface %q", ifName)
+		}
+
+		if err := ensureClsact(link); err != nil {
+			return err
+		}
+
+		if conf.IngressProgram != "" {
+			if err := attachEBPFProgram(link, netlink.HANDLE_MIN_INGRESS, conf.IngressProgram); err != nil {
+				return err
+			}
+		}
+		if conf.EgressProgram != "" {
+			if err := attachEBPFProgram(link, netlink.HANDLE_MIN_EGRESS, conf.EgressProgram); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureClsact adds a clsact qdisc to link unless it has one already.
+// Replacing it would flush the filters attached to it, including those of
+// other users of the interface.
+func ensureClsact(link netlink.Link) error {
+	qdiscs, err := netlinksafe.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("failed to list qdiscs on %q: %v", link.Attrs().Name, err)
+	}
+	for _, q := range qdiscs {
+		if q.Type() == "clsact" {
+			return nil
+		}
+	}
+
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+	if err := netlink.QdiscAdd(qdisc); err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("failed to add clsact qdisc to %q: %v", link.Attrs().Name, err)
+	}
+	return nil
+}
+
+func attachEBPFProgram(link netlink.Link, parent uint32, pin string) error {
+	filters, err := netlinksafe.FilterList(link, parent)
+	if err != nil {
+		return fmt.Errorf("failed to list filters on %q: %v", link.Attrs().Name, err)
+	}
+	for _, f := range filters {
+		if bpf, ok := f.(*netlink.BpfFilter); ok && strings.HasPrefix(bpf.Name, ebpfFilterName) {
+			return nil
+		}
+	}
+
+	fd, err := bpfObjGet(pin)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to open pinned program %s", pin)
+	}
+	defer unix.Close(fd)
+
+	filter := &netlink.BpfFilter{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    parent,
+			Priority:  1,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		Fd:           fd,
+		Name:         ebpfFilterName,
+		DirectAction: true,
+	}
+	if err := netlink.FilterAdd(filter); err != nil {
+		return fmt.Errorf("failed to attach %s to %q: %v", pin, link.Attrs().Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+
+	"github.com/containernetworking/cni/pkg/types"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
+)
+
+// fakeBPFMap is an in-memory bpfHashMap
+type fakeBPFMap map[string][]byte
+
+func (m fakeBPFMap) insert(key, value []byte) error {
+	if _, ok := m[string(key)]; ok {
+		return unix.EEXIST
+	}
+	m[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (m fakeBPFMap) lookup(key []byte) ([]byte, error) {
+	return m[string(key)], nil
+}
+
+func (m fakeBPFMap) delete(key []byte) error {
+	delete(m, string(key))
+	return nil
+}
+
+func (m fakeBPFMap) keys() ([][]byte, error) {
+	keys := [][]byte{}
+	for k := range m {
+		keys = append(keys, []byte(k))
+	}
+	return keys, nil
+}
+
+func (m fakeBPFMap) close() {}
+
+var _ = Describe("portmapping configuration (ebpf)", func() {
+	var pm *portMapperEBPF
+	var hostPorts, podPorts fakeBPFMap
+
+	BeforeEach(func() {
+		hostPorts = fakeBPFMap{}
+		podPorts = fakeBPFMap{}
+		pm = &portMapperEBPF{hostPorts: hostPorts, podPorts: podPorts}
+	})
+
+	parse := func(name, containerID string) *PortMapConf {
+		conf, _, err := parseConfig([]byte(`{
+			"name": "`+name+`",
+			"type": "portmap",
+			"cniVersion": "1.0.0",
+			"backend": "ebpf",
+			"runtimeConfig": {
+				"portMappings": [
+					{ "hostPort": 8080, "containerPort": 80, "protocol": "tcp"},
+					{ "hostPort": 8053, "containerPort": 53, "protocol": "udp", "hostIP": "192.168.0.2"},
+					{ "hostPort": 8085, "containerPort": 85, "protocol": "tcp", "hostIP": "2001:db8:a::1"}
+				]
+			}
+		}`), "eth0", "")
+		Expect(err).NotTo(HaveOccurred())
+		conf.ContainerID = containerID
+		return conf
+	}
+
+	It("fills the maps on ADD and checks them", func() {
+		conf := parse("test", "c1")
+		Expect(conf.EBPF.MapDir).To(Equal(defaultEBPFMapDir))
+
+		containerNet, err := types.ParseCIDR("10.0.0.2/24")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pm.forwardPorts(conf, *containerNet)).To(Succeed())
+
+		// the IPv6 hostIP is skipped for an IPv4 container
+		Expect(hostPorts).To(HaveLen(2))
+		Expect(podPorts).To(HaveLen(2))
+
+		value := hostPorts[string(ebpfKey(6, false, 8080, nil))]
+		Expect(value).NotTo(BeNil())
+		Expect(net.IP(value[0:4]).String()).To(Equal("10.0.0.2"))
+		Expect(value[16:18]).To(Equal([]byte{0, 80}))
+
+		value = podPorts[string(ebpfKey(17, false, 53, net.ParseIP("10.0.0.2")))]
+		Expect(value).NotTo(BeNil())
+		Expect(net.IP(value[0:4]).String()).To(Equal("192.168.0.2"))
+		Expect(value[16:18]).To(Equal([]byte{0x1f, 0x75}))
+
+		Expect(pm.checkPorts(conf, *containerNet)).To(Succeed())
+
+		delete(hostPorts, string(ebpfKey(6, false, 8080, nil)))
+		Expect(pm.checkPorts(conf, *containerNet)).NotTo(Succeed())
+	})
+
+	It("deletes only its own elements on DEL and GC", func() {
+		containerNet, err := types.ParseCIDR("10.0.0.2/24")
+		Expect(err).NotTo(HaveOccurred())
+		conf1 := parse("test", "c1")
+		Expect(pm.forwardPorts(conf1, *containerNet)).To(Succeed())
+
+		// Same ports but on a different host IP family, owned by another network
+		containerNet6, err := types.ParseCIDR("2001:db8::2/64")
+		Expect(err).NotTo(HaveOccurred())
+		other := parse("other", "c1")
+		Expect(pm.forwardPorts(other, *containerNet6)).To(Succeed())
+		Expect(hostPorts).To(HaveLen(4))
+
+		Expect(pm.gcPorts(conf1, gc.NewAttachments([]types.GCAttachment{{ContainerID: "c1", IfName: "eth0"}}))).To(Succeed())
+		Expect(hostPorts).To(HaveLen(4))
+
+		Expect(pm.gcPorts(conf1, gc.NewAttachments(nil))).To(Succeed())
+		Expect(hostPorts).To(HaveLen(2))
+		Expect(podPorts).To(HaveLen(2))
+
+		Expect(pm.unforwardPorts(other)).To(Succeed())
+		Expect(hostPorts).To(BeEmpty())
+		Expect(podPorts).To(BeEmpty())
+	})
+
+	It("rejects mapping two host ports to one container port", func() {
+		conf, _, err := parseConfig([]byte(`{
+			"name": "test",
+			"type": "portmap",
+			"cniVersion": "1.0.0",
+			"backend": "ebpf",
+			"runtimeConfig": {
+				"portMappings": [
+					{ "hostPort": 8080, "containerPort": 80, "protocol": "tcp"},
+					{ "hostPort": 8081, "containerPort": 80, "protocol": "tcp"}
+				]
+			}
+		}`), "eth0", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		containerNet, err := types.ParseCIDR("10.0.0.2/24")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pm.forwardPorts(conf, *containerNet)).To(MatchError(ContainSubstring("cannot map both host ports 8080 and 8081")))
+	})
+
+	It("refuses host ports mapped to another container", func() {
+		containerNet, err := types.ParseCIDR("10.0.0.2/24")
+		Expect(err).NotTo(HaveOccurred())
+		conf1 := parse("test", "c1")
+		Expect(pm.forwardPorts(conf1, *containerNet)).To(Succeed())
+		// ADD again is idempotent
+		Expect(pm.forwardPorts(conf1, *containerNet)).To(Succeed())
+
+		containerNet2, err := types.ParseCIDR("10.0.0.3/24")
+		Expect(err).NotTo(HaveOccurred())
+		err = pm.forwardPorts(parse("test", "c2"), *containerNet2)
+		Expect(err).To(MatchError(ContainSubstring("host tcp port 8080 is already mapped to another container")))
+		Expect(cnierrors.Code(err)).To(Equal(cnierrors.ErrPortInUse))
+
+		// The elements of c1 are untouched, and none of c2 are left behind
+		Expect(hostPorts).To(HaveLen(2))
+		Expect(podPorts).To(HaveLen(2))
+		Expect(pm.checkPorts(conf1, *containerNet)).To(Succeed())
+	})
+
+	It("reports missing interfaces on STATUS", func() {
+		conf, _, err := parseConfig([]byte(`{
+			"name": "test",
+			"type": "portmap",
+			"cniVersion": "1.1.0",
+			"backend": "ebpf",
+			"ebpf": {"interfaces": ["lo"]}
+		}`), "eth0", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pm.status(conf)).To(Succeed())
+		Expect(statusModules(conf)).To(ConsistOf("sch_ingress", "cls_bpf"))
+
+		conf.EBPF.Interfaces = append(conf.EBPF.Interfaces, "nonexistent0")
+		Expect(pm.status(conf)).To(MatchError(ContainSubstring(`failed to look up interface "nonexistent0"`)))
+	})
+
+	It("rejects ebpf options with another backend", func() {
+		_, _, err := parseConfig([]byte(`{
+			"name": "test",
+			"type": "portmap",
+			"cniVersion": "1.0.0",
+			"backend": "nftables",
+			"ebpf": {"mapDir": "/sys/fs/bpf/foo"}
+		}`), "eth0", "")
+		Expect(err).To(MatchError("nftables backend was requested but configuration contains ebpf-specific options [ebpf]"))
+	})
+})
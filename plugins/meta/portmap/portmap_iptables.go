@@ -17,13 +17,18 @@ package main
 import (
 	"fmt"
 	"net"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/coreos/go-iptables/iptables"
+	"github.com/mattn/go-shellwords"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
 	"github.com/containernetworking/plugins/pkg/utils"
 )
 
@@ -40,15 +45,20 @@ import (
 // CNI-HOSTPORT-DNAT: --destination-ports 8080,8081 -j CNI-DN-abcd123
 // CNI-DN-abcd123: -p tcp --dport 8080 -j DNAT --to-destination 192.0.2.33:80
 // CNI-DN-abcd123: -p tcp --dport 8081 -j DNAT ...
+//
+// SNAT case, for mappings with an explicit SNAT source address:
+// POSTROUTING: -j CNI-HOSTPORT-SNAT
+// CNI-HOSTPORT-SNAT: -j CNI-SN-abcd123
+// CNI-SN-abcd123: -p tcp -d 192.0.2.33 --dport 80 --ctorigdstport 8080 -j SNAT --to-source 198.51.100.1
 
 // The names of the top-level summary chains.
 // These should never be changed, or else upgrading will require manual
 // intervention.
 const (
-	TopLevelDNATChainName    = "CNI-HOSTPORT-DNAT"
-	SetMarkChainName         = "CNI-HOSTPORT-SETMARK"
-	MarkMasqChainName        = "CNI-HOSTPORT-MASQ"
-	OldTopLevelSNATChainName = "CNI-HOSTPORT-SNAT"
+	TopLevelDNATChainName = "CNI-HOSTPORT-DNAT"
+	SetMarkChainName      = "CNI-HOSTPORT-SETMARK"
+	MarkMasqChainName     = "CNI-HOSTPORT-MASQ"
+	TopLevelSNATChainName = "CNI-HOSTPORT-SNAT"
 )
 
 type portMapperIPTables struct{}
@@ -67,7 +77,7 @@ func (*portMapperIPTables) forwardPorts(config *PortMapConf, containerNet net.IP
 		ipt, err = iptables.NewWithProtocol(iptables.ProtocolIPv4)
 	}
 	if err != nil {
-		return fmt.Errorf("failed to open iptables: %v", err)
+		return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to open iptables")
 	}
 
 	// Enable masquerading for traffic as necessary.
@@ -76,7 +86,8 @@ func (*portMapperIPTables) forwardPorts(config *PortMapConf, containerNet net.IP
 	// - hairpin traffic back to the container
 	// Idempotently create the rule that masquerades traffic with this mark.
 	// Need to do this first; the DNAT rules reference these chains
-	if *config.SNAT {
+	masq, withSource := snatModes(config, isV6)
+	if masq {
 		if config.ExternalSetMarkChain == nil {
 			setMarkChain := genSetMarkChain(*config.MarkMasqBit)
 			if err := setMarkChain.setup(ipt); err != nil {
@@ -96,17 +107,48 @@ func (*portMapperIPTables) forwardPorts(config *PortMapConf, containerNet net.IP
 		return fmt.Errorf("failed to create top-level DNAT chain: %v", err)
 	}
 
+	// The per-container chains are created in a single iptables-restore
+	// transaction, so the number of execs doesn't grow with the number of
+	// port mappings.
 	dnatChain := genDnatChain(config.Name, config.ContainerID)
-	// First, idempotently tear down this chain in case there was some
-	// sort of collision or bad state.
 	fillDnatRules(&dnatChain, config, containerNet)
-	if err := dnatChain.setup(ipt); err != nil {
-		return fmt.Errorf("unable to setup DNAT: %v", err)
+	chains := []*chain{&dnatChain}
+
+	// Mappings with an SNAT source address are SNATed in POSTROUTING
+	// rather than marked for masquerading
+	if withSource {
+		toplevelSnatChain := genToplevelSnatChain()
+		if err := toplevelSnatChain.setup(ipt); err != nil {
+			return fmt.Errorf("failed to create top-level SNAT chain: %v", err)
+		}
+
+		snatChain := genSnatChain(config.Name, config.ContainerID)
+		fillSnatRules(&snatChain, config, containerNet)
+		chains = append(chains, &snatChain)
+	}
+
+	if err := setupChains(ipt, chains...); err != nil {
+		return fmt.Errorf("unable to setup DNAT/SNAT: %v", err)
 	}
 
 	return nil
 }
 
+// snatModes returns whether any of the port mappings are masqueraded, and
+// whether any are SNATed to an explicit source address, for a container
+// address of the given family.
+func snatModes(config *PortMapConf, isV6 bool) (masq bool, withSource bool) {
+	for i := range config.RuntimeConfig.PortMaps {
+		snat, source := snatFor(config, &config.RuntimeConfig.PortMaps[i], isV6)
+		if source != nil {
+			withSource = true
+		} else if snat {
+			masq = true
+		}
+	}
+	return masq, withSource
+}
+
 func (*portMapperIPTables) checkPorts(config *PortMapConf, containerNet net.IPNet) error {
 	isV6 := (containerNet.IP.To4() == nil)
 	dnatChain := genDnatChain(config.Name, config.ContainerID)
@@ -143,6 +185,21 @@ func (*portMapperIPTables) checkPorts(config *PortMapConf, containerNet net.IPNe
 		}
 	}
 
+	if _, withSource := snatModes(config, isV6); withSource {
+		snatChain := genSnatChain(config.Name, config.ContainerID)
+		fillSnatRules(&snatChain, config, containerNet)
+		if ip4t != nil {
+			if err := snatChain.check(ip4t); err != nil {
+				return fmt.Errorf("could not check ipv4 snat: %v", err)
+			}
+		}
+		if ip6t != nil {
+			if err := snatChain.check(ip6t); err != nil {
+				return fmt.Errorf("could not check ipv6 snat: %v", err)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -245,22 +302,13 @@ func fillDnatRules(c *chain, config *PortMapConf, containerNet net.IPNet) {
 		}
 
 		// Add mark-to-masquerade rules for hairpin and localhost
-		if *config.SNAT {
+		if snat, source := snatFor(config, &entry, isV6); snat && source == nil {
 			// hairpin
 			hpRule := make([]string, len(ruleBase), len(ruleBase)+4)
 			copy(hpRule, ruleBase)
 
-			masqCIDR := containerNet.String()
-			if config.MasqAll {
-				if isV6 {
-					masqCIDR = "::/0"
-				} else {
-					masqCIDR = "0.0.0.0/0"
-				}
-			}
-
 			hpRule = append(hpRule,
-				"-s", masqCIDR,
+				"-s", masqCIDR(config, containerNet),
 				"-j", setMarkChainName,
 			)
 			c.rules = append(c.rules, hpRule)
@@ -289,6 +337,70 @@ func fillDnatRules(c *chain, config *PortMapConf, containerNet net.IPNet) {
 	}
 }
 
+// masqCIDR returns the source addresses of the hairpin traffic that must be
+// SNATed: just the container's, or everything if MasqAll is set.
+func masqCIDR(config *PortMapConf, containerNet net.IPNet) string {
+	if !config.MasqAll {
+		return containerNet.String()
+	}
+	if containerNet.IP.To4() == nil {
+		return "::/0"
+	}
+	return "0.0.0.0/0"
+}
+
+// fillSnatRules generates the SNAT rules for the mappings that have an SNAT
+// source address. They match the same traffic that fillDnatRules would
+// otherwise mark for masquerading, using conntrack to recover the host port.
+func fillSnatRules(c *chain, config *PortMapConf, containerNet net.IPNet) {
+	isV6 := (containerNet.IP.To4() == nil)
+
+	c.rules = [][]string{}
+	for i := range config.RuntimeConfig.PortMaps {
+		entry := &config.RuntimeConfig.PortMaps[i]
+
+		var hostIP net.IP
+		if entry.HostIP != "" {
+			hostIP = net.ParseIP(entry.HostIP)
+			if isV6 != (hostIP.To4() == nil) {
+				continue
+			}
+		}
+
+		snat, source := snatFor(config, entry, isV6)
+		if !snat || source == nil {
+			continue
+		}
+
+		ruleBase := []string{
+			"-p", entry.Protocol,
+			"-d", containerNet.IP.String(),
+			"--dport", strconv.Itoa(entry.ContainerPort),
+			"-m", "conntrack",
+			"--ctstate", "DNAT",
+			"--ctorigdstport", strconv.Itoa(entry.HostPort),
+		}
+		if hostIP != nil && !hostIP.IsUnspecified() {
+			ruleBase = append(ruleBase, "--ctorigdst", entry.HostIP)
+		}
+
+		sources := []string{masqCIDR(config, containerNet)}
+		if !isV6 && !config.MasqAll {
+			sources = append(sources, "127.0.0.1")
+		}
+		for _, src := range sources {
+			rule := make([]string, len(ruleBase), len(ruleBase)+6)
+			copy(rule, ruleBase)
+			rule = append(rule,
+				"-s", src,
+				"-j", "SNAT",
+				"--to-source", source.String(),
+			)
+			c.rules = append(c.rules, rule)
+		}
+	}
+}
+
 // genSetMarkChain creates the SETMARK chain - the chain that sets the
 // "to-be-masqueraded" mark and returns.
 // Chains are idempotent, so we'll always create this.
@@ -334,13 +446,35 @@ func genMarkMasqChain(markBit int) chain {
 	return ch
 }
 
-// genOldSnatChain is no longer used, but used to be created. We'll try and
-// tear it down in case the plugin version changed between ADD and DEL
-func genOldSnatChain(netName, containerID string) chain {
+// genToplevelSnatChain creates the top-level chain for the per-container
+// SNAT chains. Like the masquerading chain, it must come before the rules of
+// the CNI ptp and bridge plugins.
+func genToplevelSnatChain() chain {
+	return chain{
+		table:        "nat",
+		name:         TopLevelSNATChainName,
+		entryChains:  []string{"POSTROUTING"},
+		prependEntry: true,
+		entryRules: [][]string{{
+			"-m", "comment",
+			"--comment", "CNI portfwd SNAT",
+		}},
+	}
+}
+
+// genSnatChain creates the per-container SNAT chain. It is only set up for
+// containers with mappings that have an SNAT source address, but older
+// versions of the plugin created it for every container, so it is always
+// torn down.
+func genSnatChain(netName, containerID string) chain {
 	return chain{
 		table:       "nat",
 		name:        utils.MustFormatChainNameWithPrefix(netName, containerID, "SN-"),
-		entryChains: []string{OldTopLevelSNATChainName},
+		entryChains: []string{TopLevelSNATChainName},
+		entryRules: [][]string{{
+			"-m", "comment",
+			"--comment", trimComment(fmt.Sprintf(`snat name: "%s" id: "%s"`, netName, containerID)),
+		}},
 	}
 }
 
@@ -357,8 +491,7 @@ func genOldSnatChain(netName, containerID string) chain {
 func (*portMapperIPTables) unforwardPorts(config *PortMapConf) error {
 	dnatChain := genDnatChain(config.Name, config.ContainerID)
 
-	// Might be lying around from old versions
-	oldSnatChain := genOldSnatChain(config.Name, config.ContainerID)
+	snatChain := genSnatChain(config.Name, config.ContainerID)
 
 	ip4t, err4 := maybeGetIptables(false)
 	ip6t, err6 := maybeGetIptables(true)
@@ -370,21 +503,177 @@ func (*portMapperIPTables) unforwardPorts(config *PortMapConf) error {
 	}
 
 	if ip4t != nil {
-		if err := dnatChain.teardown(ip4t); err != nil {
-			return fmt.Errorf("could not teardown ipv4 dnat: %v", err)
+		if err := teardownChains(ip4t, &dnatChain, &snatChain); err != nil {
+			return fmt.Errorf("could not teardown ipv4 dnat/snat: %v", err)
 		}
-		oldSnatChain.teardown(ip4t)
 	}
 
 	if ip6t != nil {
-		if err := dnatChain.teardown(ip6t); err != nil {
-			return fmt.Errorf("could not teardown ipv6 dnat: %v", err)
+		if err := teardownChains(ip6t, &dnatChain, &snatChain); err != nil {
+			return fmt.Errorf("could not teardown ipv6 dnat/snat: %v", err)
+		}
+	}
+	return nil
+}
+
+// gcPorts deletes the DNAT chains of any container on this network that is
+// without a valid attachment, along with the UDP conntrack entries for their host ports.
+//
+// The per-container chain names are hashes, so we can't tell which network or
+// container they belong to. Instead we walk the top-level DNAT chain, whose
+// entry rules carry a comment naming both.
+func (*portMapperIPTables) gcPorts(config *PortMapConf, valid *gc.Attachments) error {
+	ip4t, err4 := maybeGetIptables(false)
+	ip6t, err6 := maybeGetIptables(true)
+	if ip4t == nil && ip6t == nil {
+		err := fmt.Errorf("neither iptables nor ip6tables is usable")
+		err = fmt.Errorf("%v, (iptables) %v", err, err4)
+		err = fmt.Errorf("%v, (ip6tables) %v", err, err6)
+		return err
+	}
+
+	for _, ipt := range []*iptables.IPTables{ip4t, ip6t} {
+		if ipt == nil {
+			continue
+		}
+
+		entryRules, err := ipt.List("nat", TopLevelDNATChainName)
+		if err != nil {
+			if isNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("could not list rules in chain %s: %v", TopLevelDNATChainName, err)
+		}
+
+		staleIDs := []string{}
+		seen := map[string]bool{}
+		for _, rule := range entryRules {
+			netName, containerID, ok := parseDnatComment(rule)
+			if !ok || netName != config.Name || valid.ValidContainer(containerID) || seen[containerID] {
+				continue
+			}
+			seen[containerID] = true
+			staleIDs = append(staleIDs, containerID)
+		}
+
+		for _, containerID := range staleIDs {
+			dnatChain := genDnatChain(config.Name, containerID)
+
+			// Collect the UDP ports before the chain goes away
+			udpPorts := []int{}
+			if rules, err := ipt.List(dnatChain.table, dnatChain.name); err == nil {
+				udpPorts = parseDnatUDPPorts(rules)
+			}
+
+			snatChain := genSnatChain(config.Name, containerID)
+			if err := teardownChains(ipt, &dnatChain, &snatChain); err != nil {
+				return fmt.Errorf("could not teardown dnat/snat for container %s: %v", containerID, err)
+			}
+
+			family := netlink.InetFamily(unix.AF_INET)
+			if ipt.Proto() == iptables.ProtocolIPv6 {
+				family = unix.AF_INET6
+			}
+			for _, port := range udpPorts {
+				// Failures are informative only, the rules are already gone.
+				_ = utils.DeleteConntrackEntriesForDstPort(uint16(port), utils.PROTOCOL_UDP, family)
+			}
 		}
-		oldSnatChain.teardown(ip6t)
 	}
+
 	return nil
 }
 
+// status checks that iptables works for at least one IP family, and that the
+// external set-mark chain, if configured, exists.
+func (*portMapperIPTables) status(config *PortMapConf) error {
+	ip4t, err4 := maybeGetIptables(false)
+	ip6t, err6 := maybeGetIptables(true)
+	if ip4t == nil && ip6t == nil {
+		err := fmt.Errorf("neither iptables nor ip6tables is usable")
+		err = fmt.Errorf("%v, (iptables) %v", err, err4)
+		err = fmt.Errorf("%v, (ip6tables) %v", err, err6)
+		return err
+	}
+
+	if config.ExternalSetMarkChain != nil {
+		for _, ipt := range []*iptables.IPTables{ip4t, ip6t} {
+			if ipt == nil {
+				continue
+			}
+			exists, err := ipt.ChainExists("nat", *config.ExternalSetMarkChain)
+			if err != nil {
+				return fmt.Errorf("could not check for chain %s: %v", *config.ExternalSetMarkChain, err)
+			}
+			if !exists {
+				return fmt.Errorf("external set-mark chain %s does not exist", *config.ExternalSetMarkChain)
+			}
+		}
+	}
+
+	return nil
+}
+
+var dnatCommentRegexp = regexp.MustCompile(`^dnat name: "(.*)" id: "(.*)"$`)
+
+// parseDnatComment extracts the network name and container ID from the
+// comment of a top-level DNAT chain rule, as listed by iptables -S.
+func parseDnatComment(rule string) (string, string, bool) {
+	parts, err := shellwords.Parse(rule)
+	if err != nil {
+		return "", "", false
+	}
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] != "--comment" {
+			continue
+		}
+		m := dnatCommentRegexp.FindStringSubmatch(parts[i+1])
+		if m == nil {
+			return "", "", false
+		}
+		return m[1], m[2], true
+	}
+	return "", "", false
+}
+
+// parseDnatUDPPorts returns the host ports of the UDP DNAT rules in a
+// per-container chain, as listed by iptables -S.
+func parseDnatUDPPorts(rules []string) []int {
+	ports := []int{}
+	for _, rule := range rules {
+		parts, err := shellwords.Parse(rule)
+		if err != nil {
+			continue
+		}
+		var proto, target string
+		port := 0
+		for i := 0; i < len(parts)-1; i++ {
+			switch parts[i] {
+			case "-p":
+				proto = parts[i+1]
+			case "--dport":
+				port, _ = strconv.Atoi(parts[i+1])
+			case "-j":
+				target = parts[i+1]
+			}
+		}
+		if strings.ToLower(proto) == "udp" && target == "DNAT" && port > 0 {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// isNotExist returns true if the error is from iptables indicating
+// that the target does not exist.
+func isNotExist(err error) bool {
+	e, ok := err.(*iptables.Error)
+	if !ok {
+		return false
+	}
+	return e.IsNotExist()
+}
+
 // maybeGetIptables implements the soft error swallowing. If iptables is
 // usable for the given protocol, returns a handle, otherwise nil
 func maybeGetIptables(isV6 bool) (*iptables.IPTables, error) {
@@ -21,6 +21,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/utils"
 )
 
 var _ = Describe("portmapping configuration (iptables)", func() {
@@ -63,7 +64,7 @@ var _ = Describe("portmapping configuration (iptables)", func() {
 						"conditionsV6": ["-c", "d"]
 					}`, ver))
 
-					conf, _, err := parseConfig(configBytes, "foo")
+					conf, _, err := parseConfig(configBytes, "foo", "")
 					Expect(err).NotTo(HaveOccurred())
 					conf.ContainerID = containerID
 
@@ -189,7 +190,7 @@ var _ = Describe("portmapping configuration (iptables)", func() {
 						"conditionsV6": ["-c", "d"]
 					}`, ver))
 
-					conf, _, err := parseConfig(configBytes, "foo")
+					conf, _, err := parseConfig(configBytes, "foo", "")
 					Expect(err).NotTo(HaveOccurred())
 					conf.ContainerID = containerID
 
@@ -246,7 +247,147 @@ var _ = Describe("portmapping configuration (iptables)", func() {
 						prependEntry: true,
 					}))
 				})
+
+				It(fmt.Sprintf("[%s] generates per-mapping SNAT rules", ver), func() {
+					configBytes := []byte(fmt.Sprintf(`{
+						"name": "test",
+						"type": "portmap",
+						"cniVersion": "%s",
+						"runtimeConfig": {
+							"portMappings": [
+								{ "hostPort": 8080, "containerPort": 80, "protocol": "tcp"},
+								{ "hostPort": 8081, "containerPort": 81, "protocol": "tcp", "snat": false},
+								{ "hostPort": 8082, "containerPort": 82, "protocol": "udp", "snatSourceIP": "198.51.100.1"},
+								{ "hostPort": 8083, "containerPort": 83, "protocol": "tcp", "hostIP": "192.168.0.2", "snatSourceIP": "198.51.100.1"}
+							]
+						}
+					}`, ver))
+
+					conf, _, err := parseConfig(configBytes, "foo", "")
+					Expect(err).NotTo(HaveOccurred())
+					conf.ContainerID = containerID
+
+					n, err := types.ParseCIDR("10.0.0.2/24")
+					Expect(err).NotTo(HaveOccurred())
+
+					ch := genDnatChain(netName, containerID)
+					fillDnatRules(&ch, conf, *n)
+					Expect(ch.rules).To(Equal([][]string{
+						{"-p", "tcp", "--dport", "8080", "-s", "10.0.0.2/24", "-j", "CNI-HOSTPORT-SETMARK"},
+						{"-p", "tcp", "--dport", "8080", "-s", "127.0.0.1", "-j", "CNI-HOSTPORT-SETMARK"},
+						{"-p", "tcp", "--dport", "8080", "-j", "DNAT", "--to-destination", "10.0.0.2:80"},
+						{"-p", "tcp", "--dport", "8081", "-j", "DNAT", "--to-destination", "10.0.0.2:81"},
+						{"-p", "udp", "--dport", "8082", "-j", "DNAT", "--to-destination", "10.0.0.2:82"},
+						{"-p", "tcp", "--dport", "8083", "-d", "192.168.0.2", "-j", "DNAT", "--to-destination", "10.0.0.2:83"},
+					}))
+
+					ch = genSnatChain(netName, containerID)
+					Expect(ch.name).To(Equal("CNI-SN-bfd599665540dd91d5d28"))
+					Expect(ch.entryChains).To(Equal([]string{TopLevelSNATChainName}))
+					fillSnatRules(&ch, conf, *n)
+					Expect(ch.rules).To(Equal([][]string{
+						{"-p", "udp", "-d", "10.0.0.2", "--dport", "82", "-m", "conntrack", "--ctstate", "DNAT", "--ctorigdstport", "8082", "-s", "10.0.0.2/24", "-j", "SNAT", "--to-source", "198.51.100.1"},
+						{"-p", "udp", "-d", "10.0.0.2", "--dport", "82", "-m", "conntrack", "--ctstate", "DNAT", "--ctorigdstport", "8082", "-s", "127.0.0.1", "-j", "SNAT", "--to-source", "198.51.100.1"},
+						{"-p", "tcp", "-d", "10.0.0.2", "--dport", "83", "-m", "conntrack", "--ctstate", "DNAT", "--ctorigdstport", "8083", "--ctorigdst", "192.168.0.2", "-s", "10.0.0.2/24", "-j", "SNAT", "--to-source", "198.51.100.1"},
+						{"-p", "tcp", "-d", "10.0.0.2", "--dport", "83", "-m", "conntrack", "--ctstate", "DNAT", "--ctorigdstport", "8083", "--ctorigdst", "192.168.0.2", "-s", "127.0.0.1", "-j", "SNAT", "--to-source", "198.51.100.1"},
+					}))
+
+					// An IPv4 SNAT source doesn't apply to IPv6, which is masqueraded
+					n, err = types.ParseCIDR("2001:db8::2/64")
+					Expect(err).NotTo(HaveOccurred())
+					ch = genDnatChain(netName, containerID)
+					fillDnatRules(&ch, conf, *n)
+					Expect(ch.rules).To(ContainElement(
+						[]string{"-p", "udp", "--dport", "8082", "-s", "2001:db8::2/64", "-j", "CNI-HOSTPORT-SETMARK"},
+					))
+					masq, withSource := snatModes(conf, true)
+					Expect(masq).To(BeTrue())
+					Expect(withSource).To(BeFalse())
+				})
 			})
 		})
 	}
+
+	Describe("parsing listed rules for GC", func() {
+		It("extracts the network and container from a DNAT entry rule", func() {
+			rule := `-A CNI-HOSTPORT-DNAT -p tcp -m comment --comment "dnat name: \"test\" id: \"abc123\"" -m multiport --dports 8080,8081 -j CNI-DN-67e92b96e692a494b6b85`
+			name, id, ok := parseDnatComment(rule)
+			Expect(ok).To(BeTrue())
+			Expect(name).To(Equal("test"))
+			Expect(id).To(Equal("abc123"))
+
+			_, _, ok = parseDnatComment(`-A CNI-HOSTPORT-DNAT -m comment --comment "canary value" -j ACCEPT`)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("extracts UDP host ports from a container chain", func() {
+			rules := []string{
+				"-N CNI-DN-67e92b96e692a494b6b85",
+				"-A CNI-DN-67e92b96e692a494b6b85 -s 10.0.0.2/32 -p udp -m udp --dport 8080 -j CNI-HOSTPORT-SETMARK",
+				"-A CNI-DN-67e92b96e692a494b6b85 -p udp -m udp --dport 8080 -j DNAT --to-destination 10.0.0.2:81",
+				"-A CNI-DN-67e92b96e692a494b6b85 -p tcp -m tcp --dport 8081 -j DNAT --to-destination 10.0.0.2:80",
+				"-A CNI-DN-67e92b96e692a494b6b85 -p udp -m udp --dport 8082 -j DNAT --to-destination 10.0.0.2:82",
+			}
+			Expect(parseDnatUDPPorts(rules)).To(Equal([]int{8080, 8082}))
+		})
+	})
+
+	Describe("rendering iptables-restore payloads", func() {
+		var c chain
+
+		BeforeEach(func() {
+			c = chain{
+				table:       "nat",
+				name:        "CNI-DN-67e92b96e692a494b6b85",
+				entryChains: []string{TopLevelDNATChainName},
+				entryRules: [][]string{{
+					"-m", "comment", "--comment", `dnat name: "test" id: "abc123"`,
+				}},
+				rules: [][]string{
+					{"-p", "tcp", "--dport", "8080", "-j", "DNAT", "--to-destination", "10.0.0.2:80"},
+					{"-p", "udp", "--dport", "8081", "-j", "DNAT", "--to-destination", "10.0.0.2:81"},
+				},
+			}
+		})
+
+		It("creates the chain with all its rules in one transaction", func() {
+			p := utils.RestorePayload{}
+			p.Add(c.table, c.setupLines(func(string, []string) bool { return false })...)
+			Expect(p.String()).To(Equal(`*nat
+:CNI-DN-67e92b96e692a494b6b85 - [0:0]
+-F CNI-DN-67e92b96e692a494b6b85
+-A CNI-DN-67e92b96e692a494b6b85 -p tcp --dport 8080 -j DNAT --to-destination 10.0.0.2:80
+-A CNI-DN-67e92b96e692a494b6b85 -p udp --dport 8081 -j DNAT --to-destination 10.0.0.2:81
+-A CNI-HOSTPORT-DNAT -m comment --comment "dnat name: \"test\" id: \"abc123\"" -j CNI-DN-67e92b96e692a494b6b85
+COMMIT
+`))
+		})
+
+		It("skips existing entry rules and inserts prepended ones", func() {
+			c.entryChains = []string{"PREROUTING", "OUTPUT"}
+			c.prependEntry = true
+			lines := c.setupLines(func(entryChain string, _ []string) bool { return entryChain == "OUTPUT" })
+			Expect(lines).To(ContainElement(`-I PREROUTING 1 -m comment --comment "dnat name: \"test\" id: \"abc123\"" -j CNI-DN-67e92b96e692a494b6b85`))
+			Expect(lines).NotTo(ContainElement(HavePrefix("-I OUTPUT")))
+		})
+
+		It("deletes the listed entry rules before the chain", func() {
+			snat := chain{table: "nat", name: "CNI-SN-67e92b96e692a494b6b85"}
+
+			p := utils.RestorePayload{}
+			Expect(p.Empty()).To(BeTrue())
+			p.Add(c.table, c.teardownLines([]string{
+				`-A CNI-HOSTPORT-DNAT -m comment --comment "dnat name: \"test\" id: \"abc123\"" -j CNI-DN-67e92b96e692a494b6b85`,
+			})...)
+			p.Add(snat.table, snat.teardownLines(nil)...)
+			Expect(p.String()).To(Equal(`*nat
+-D CNI-HOSTPORT-DNAT -m comment --comment "dnat name: \"test\" id: \"abc123\"" -j CNI-DN-67e92b96e692a494b6b85
+-F CNI-DN-67e92b96e692a494b6b85
+-X CNI-DN-67e92b96e692a494b6b85
+-F CNI-SN-67e92b96e692a494b6b85
+-X CNI-SN-67e92b96e692a494b6b85
+COMMIT
+`))
+		})
+	})
 })
@@ -19,8 +19,15 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 	"sigs.k8s.io/knftables"
+
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/utils"
 )
 
 const (
@@ -45,6 +52,35 @@ const (
 // than having a chain with a rule per mapping. But there's no easy, non-racy way to say
 // "delete the element 192.168.1.3 from the map, but only if it was added for container A,
 // not if it was added for container B".
+//
+// The comment is prefixed with a hash of the network name so that GC can tell which
+// network a rule belongs to. Rules created by older versions carry only the bare
+// container ID; DEL and CHECK still recognize those, but GC leaves them alone.
+
+// hashForNetwork returns a unique hash for this network
+func hashForNetwork(netName string) string {
+	return utils.MustFormatHashWithPrefix(16, "", netName)
+}
+
+// commentForContainer returns the comment attached to every rule created for
+// containerID on the network netName.
+func commentForContainer(netName, containerID string) string {
+	comment := hashForNetwork(netName) + "-" + containerID
+	if len(comment) > knftables.CommentLengthMax {
+		comment = comment[:knftables.CommentLengthMax]
+	}
+	return comment
+}
+
+// isContainerRule returns true if r was created for the container in config,
+// by either this or an older version of the plugin.
+func isContainerRule(r *knftables.Rule, config *PortMapConf) bool {
+	if r.Comment == nil {
+		return false
+	}
+	return *r.Comment == commentForContainer(config.Name, config.ContainerID) ||
+		*r.Comment == config.ContainerID
+}
 
 type portMapperNFTables struct {
 	ipv4 knftables.Interface
@@ -58,7 +94,7 @@ func (pmNFT *portMapperNFTables) getPortMapNFT(ipv6 bool) (knftables.Interface,
 		if pmNFT.ipv6 == nil {
 			pmNFT.ipv6, err = knftables.New(knftables.IPv6Family, tableName)
 			if err != nil {
-				return nil, err
+				return nil, cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to open nftables")
 			}
 		}
 		return pmNFT.ipv6, nil
@@ -67,7 +103,7 @@ func (pmNFT *portMapperNFTables) getPortMapNFT(ipv6 bool) (knftables.Interface,
 	if pmNFT.ipv4 == nil {
 		pmNFT.ipv4, err = knftables.New(knftables.IPv4Family, tableName)
 		if err != nil {
-			return nil, err
+			return nil, cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to open nftables")
 		}
 	}
 	return pmNFT.ipv4, err
@@ -159,7 +195,7 @@ func (pmNFT *portMapperNFTables) forwardPorts(config *PortMapConf, containerNet
 		),
 	})
 
-	if *config.SNAT {
+	if anySNAT(config, isV6) {
 		tx.Add(&knftables.Chain{
 			Name:     masqueradingChain,
 			Type:     knftables.PtrTo(knftables.NATType),
@@ -168,6 +204,8 @@ func (pmNFT *portMapperNFTables) forwardPorts(config *PortMapConf, containerNet
 		})
 	}
 
+	comment := commentForContainer(config.Name, config.ContainerID)
+
 	// Set up this container
 	for _, e := range config.RuntimeConfig.PortMaps {
 		useHostIP := false
@@ -191,7 +229,7 @@ func (pmNFT *portMapperNFTables) forwardPorts(config *PortMapConf, containerNet
 					e.Protocol, "dport", e.HostPort,
 					"dnat to", net.JoinHostPort(containerNet.IP.String(), strconv.Itoa(e.ContainerPort)),
 				),
-				Comment: &config.ContainerID,
+				Comment: &comment,
 			})
 		} else {
 			tx.Add(&knftables.Rule{
@@ -200,11 +238,18 @@ func (pmNFT *portMapperNFTables) forwardPorts(config *PortMapConf, containerNet
 					e.Protocol, "dport", e.HostPort,
 					"dnat to", net.JoinHostPort(containerNet.IP.String(), strconv.Itoa(e.ContainerPort)),
 				),
-				Comment: &config.ContainerID,
+				Comment: &comment,
 			})
 		}
 	}
 
+	// Mappings that override the plugin-wide SNAT settings get their own
+	// rules ahead of the container-wide ones below.
+	for _, rule := range snatOverrideRules(config, containerNet) {
+		rule.Comment = &comment
+		tx.Add(rule)
+	}
+
 	if *config.SNAT {
 		// Add mark-to-masquerade rules for hairpin and localhost
 		// In theory we should validate that the original dst IP and port are as
@@ -217,7 +262,7 @@ func (pmNFT *portMapperNFTables) forwardPorts(config *PortMapConf, containerNet
 				ipX, "daddr", containerNet.IP,
 				"masquerade",
 			),
-			Comment: &config.ContainerID,
+			Comment: &comment,
 		})
 		if !isV6 {
 			tx.Add(&knftables.Rule{
@@ -227,7 +272,7 @@ func (pmNFT *portMapperNFTables) forwardPorts(config *PortMapConf, containerNet
 					ipX, "daddr", containerNet.IP,
 					"masquerade",
 				),
-				Comment: &config.ContainerID,
+				Comment: &comment,
 			})
 		}
 	}
@@ -257,25 +302,26 @@ func (pmNFT *portMapperNFTables) checkPorts(config *PortMapConf, containerNet ne
 			masqueradings *= 2
 		}
 	}
+	masqueradings += len(snatOverrideRules(config, containerNet))
 
 	nft, err := pmNFT.getPortMapNFT(isV6)
 	if err != nil {
 		return err
 	}
 	if hostPorts > 0 {
-		err := checkPortsAgainstRules(nft, hostPortsChain, config.ContainerID, hostPorts)
+		err := checkPortsAgainstRules(nft, hostPortsChain, config, hostPorts)
 		if err != nil {
 			return err
 		}
 	}
 	if hostIPHostPorts > 0 {
-		err := checkPortsAgainstRules(nft, hostIPHostPortsChain, config.ContainerID, hostIPHostPorts)
+		err := checkPortsAgainstRules(nft, hostIPHostPortsChain, config, hostIPHostPorts)
 		if err != nil {
 			return err
 		}
 	}
 	if masqueradings > 0 {
-		err := checkPortsAgainstRules(nft, masqueradingChain, config.ContainerID, masqueradings)
+		err := checkPortsAgainstRules(nft, masqueradingChain, config, masqueradings)
 		if err != nil {
 			return err
 		}
@@ -284,7 +330,55 @@ func (pmNFT *portMapperNFTables) checkPorts(config *PortMapConf, containerNet ne
 	return nil
 }
 
-func checkPortsAgainstRules(nft knftables.Interface, chain, comment string, nPorts int) error {
+// snatOverrideRules returns the masquerading chain rules for the mappings
+// that set their own snat or snatSourceIP. They match the hairpin and
+// localhost traffic to the mapping's container port, and either accept it
+// unchanged, masquerade it, or SNAT it to the mapping's source address.
+func snatOverrideRules(config *PortMapConf, containerNet net.IPNet) []*knftables.Rule {
+	isV6 := (containerNet.IP.To4() == nil)
+	ipX := "ip"
+	if isV6 {
+		ipX = "ip6"
+	}
+
+	rules := []*knftables.Rule{}
+	for i := range config.RuntimeConfig.PortMaps {
+		e := &config.RuntimeConfig.PortMaps[i]
+		if e.SNAT == nil && e.SNATSourceIP == "" {
+			continue
+		}
+		if e.HostIP != "" && isV6 != (net.ParseIP(e.HostIP).To4() == nil) {
+			continue
+		}
+
+		action := []string{"accept"}
+		if snat, source := snatFor(config, e, isV6); source != nil {
+			action = []string{"snat to", source.String()}
+		} else if snat {
+			action = []string{"masquerade"}
+		}
+
+		sources := []string{containerNet.IP.String()}
+		if !isV6 {
+			sources = append(sources, "127.0.0.1")
+		}
+		for _, src := range sources {
+			rules = append(rules, &knftables.Rule{
+				Chain: masqueradingChain,
+				Rule: knftables.Concat(
+					ipX, "saddr", src,
+					ipX, "daddr", containerNet.IP,
+					e.Protocol, "dport", e.ContainerPort,
+					"ct original proto-dst", e.HostPort,
+					action,
+				),
+			})
+		}
+	}
+	return rules
+}
+
+func checkPortsAgainstRules(nft knftables.Interface, chain string, config *PortMapConf, nPorts int) error {
 	rules, err := nft.ListRules(context.TODO(), chain)
 	if err != nil {
 		return err
@@ -292,7 +386,7 @@ func checkPortsAgainstRules(nft knftables.Interface, chain, comment string, nPor
 
 	found := 0
 	for _, r := range rules {
-		if r.Comment != nil && *r.Comment == comment {
+		if isContainerRule(r, config) {
 			found++
 		}
 	}
@@ -324,7 +418,7 @@ func (pmNFT *portMapperNFTables) unforwardPorts(config *PortMapConf) error {
 			}
 
 			for _, r := range rules {
-				if r.Comment != nil && *r.Comment == config.ContainerID {
+				if isContainerRule(r, config) {
 					tx.Delete(r)
 				}
 			}
@@ -338,3 +432,107 @@ func (pmNFT *portMapperNFTables) unforwardPorts(config *PortMapConf) error {
 
 	return nil
 }
+
+// gcPorts deletes the nftables rules of any container on this network that is
+// without a valid attachment, along with the UDP conntrack entries for their host ports.
+func (pmNFT *portMapperNFTables) gcPorts(config *PortMapConf, valid *gc.Attachments) error {
+	prefix := hashForNetwork(config.Name) + "-"
+	validComments := valid.Names(func(containerID, _ string) string {
+		return commentForContainer(config.Name, containerID)
+	})
+
+	for _, family := range []knftables.Family{knftables.IPv4Family, knftables.IPv6Family} {
+		nft, err := pmNFT.getPortMapNFT(family == knftables.IPv6Family)
+		if err != nil {
+			continue
+		}
+
+		tx := nft.NewTransaction()
+		udpPorts := []int{}
+		for _, chain := range []string{hostPortsChain, hostIPHostPortsChain, masqueradingChain} {
+			rules, err := nft.ListRules(context.TODO(), chain)
+			if err != nil {
+				if knftables.IsNotFound(err) {
+					continue
+				}
+				return fmt.Errorf("could not list rules in table %s: %w", tableName, err)
+			}
+
+			for _, r := range rules {
+				if r.Comment == nil || !strings.HasPrefix(*r.Comment, prefix) || validComments[*r.Comment] {
+					continue
+				}
+				tx.Delete(r)
+				if port := parseUDPDport(r.Rule); port > 0 {
+					udpPorts = append(udpPorts, port)
+				}
+			}
+		}
+
+		if tx.NumOperations() == 0 {
+			continue
+		}
+		if err := nft.Run(context.TODO(), tx); err != nil {
+			return fmt.Errorf("error deleting nftables rules: %w", err)
+		}
+
+		inetFamily := netlink.InetFamily(unix.AF_INET)
+		if family == knftables.IPv6Family {
+			inetFamily = unix.AF_INET6
+		}
+		for _, port := range udpPorts {
+			// Failures are informative only, the rules are already gone.
+			_ = utils.DeleteConntrackEntriesForDstPort(uint16(port), utils.PROTOCOL_UDP, inetFamily)
+		}
+	}
+
+	return nil
+}
+
+// status checks that nftables NAT works for at least one IP family, by
+// validating (without applying) the creation of our table and a NAT chain.
+func (pmNFT *portMapperNFTables) status(_ *PortMapConf) error {
+	var errs [2]error
+	for i, ipv6 := range []bool{false, true} {
+		nft, err := pmNFT.getPortMapNFT(ipv6)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		tx := nft.NewTransaction()
+		tx.Add(&knftables.Table{})
+		tx.Add(&knftables.Chain{
+			Name:     "prerouting",
+			Type:     knftables.PtrTo(knftables.NATType),
+			Hook:     knftables.PtrTo(knftables.PreroutingHook),
+			Priority: knftables.PtrTo(knftables.DNATPriority),
+		})
+		if err := nft.Check(context.TODO(), tx); err != nil {
+			errs[i] = err
+			continue
+		}
+		return nil
+	}
+
+	err := fmt.Errorf("nftables NAT is not usable")
+	err = fmt.Errorf("%v, (ip) %v", err, errs[0])
+	err = fmt.Errorf("%v, (ip6) %v", err, errs[1])
+	return err
+}
+
+// parseUDPDport returns the destination port matched by a "udp dport N" rule,
+// or 0 if the rule does not match on a UDP port.
+func parseUDPDport(rule string) int {
+	fields := strings.Fields(rule)
+	for i := 0; i < len(fields)-2; i++ {
+		if fields[i] == "udp" && fields[i+1] == "dport" {
+			port, err := strconv.Atoi(fields[i+2])
+			if err != nil {
+				return 0
+			}
+			return port
+		}
+	}
+	return 0
+}
@@ -23,6 +23,7 @@ import (
 	"sigs.k8s.io/knftables"
 
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/gc"
 )
 
 var _ = Describe("portmapping configuration (nftables)", func() {
@@ -68,7 +69,7 @@ var _ = Describe("portmapping configuration (nftables)", func() {
 					"conditionsV6": ["c", "d"]
 				}`, ver))
 
-				conf, _, err := parseConfig(configBytes, "foo")
+				conf, _, err := parseConfig(configBytes, "foo", "")
 				Expect(err).NotTo(HaveOccurred())
 				conf.ContainerID = containerID
 
@@ -85,14 +86,14 @@ add chain ip cni_hostport hostports
 add chain ip cni_hostport masquerading { type nat hook postrouting priority 100 ; }
 add chain ip cni_hostport output { type nat hook output priority -100 ; }
 add chain ip cni_hostport prerouting { type nat hook prerouting priority -100 ; }
-add rule ip cni_hostport hostip_hostports ip daddr 192.168.0.2 tcp dport 8083 dnat to 10.0.0.2:83 comment "icee6giejonei6so"
-add rule ip cni_hostport hostports tcp dport 8080 dnat to 10.0.0.2:80 comment "icee6giejonei6so"
-add rule ip cni_hostport hostports tcp dport 8081 dnat to 10.0.0.2:80 comment "icee6giejonei6so"
-add rule ip cni_hostport hostports udp dport 8080 dnat to 10.0.0.2:81 comment "icee6giejonei6so"
-add rule ip cni_hostport hostports udp dport 8082 dnat to 10.0.0.2:82 comment "icee6giejonei6so"
-add rule ip cni_hostport hostports tcp dport 8084 dnat to 10.0.0.2:84 comment "icee6giejonei6so"
-add rule ip cni_hostport masquerading ip saddr 10.0.0.2 ip daddr 10.0.0.2 masquerade comment "icee6giejonei6so"
-add rule ip cni_hostport masquerading ip saddr 127.0.0.1 ip daddr 10.0.0.2 masquerade comment "icee6giejonei6so"
+add rule ip cni_hostport hostip_hostports ip daddr 192.168.0.2 tcp dport 8083 dnat to 10.0.0.2:83 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip cni_hostport hostports tcp dport 8080 dnat to 10.0.0.2:80 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip cni_hostport hostports tcp dport 8081 dnat to 10.0.0.2:80 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip cni_hostport hostports udp dport 8080 dnat to 10.0.0.2:81 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip cni_hostport hostports udp dport 8082 dnat to 10.0.0.2:82 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip cni_hostport hostports tcp dport 8084 dnat to 10.0.0.2:84 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip cni_hostport masquerading ip saddr 10.0.0.2 ip daddr 10.0.0.2 masquerade comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip cni_hostport masquerading ip saddr 127.0.0.1 ip daddr 10.0.0.2 masquerade comment "ee26b0dd4af7e749-icee6giejonei6so"
 add rule ip cni_hostport output a b jump hostip_hostports
 add rule ip cni_hostport output a b fib daddr type local jump hostports
 add rule ip cni_hostport prerouting a b jump hostip_hostports
@@ -115,12 +116,12 @@ add chain ip6 cni_hostport hostip_hostports
 add chain ip6 cni_hostport hostports
 add chain ip6 cni_hostport output { type nat hook output priority -100 ; }
 add chain ip6 cni_hostport prerouting { type nat hook prerouting priority -100 ; }
-add rule ip6 cni_hostport hostip_hostports ip6 daddr 2001:db8:a::1 tcp dport 8085 dnat to [2001:db8::2]:85 comment "icee6giejonei6so"
-add rule ip6 cni_hostport hostports tcp dport 8080 dnat to [2001:db8::2]:80 comment "icee6giejonei6so"
-add rule ip6 cni_hostport hostports tcp dport 8081 dnat to [2001:db8::2]:80 comment "icee6giejonei6so"
-add rule ip6 cni_hostport hostports udp dport 8080 dnat to [2001:db8::2]:81 comment "icee6giejonei6so"
-add rule ip6 cni_hostport hostports udp dport 8082 dnat to [2001:db8::2]:82 comment "icee6giejonei6so"
-add rule ip6 cni_hostport hostports tcp dport 8086 dnat to [2001:db8::2]:86 comment "icee6giejonei6so"
+add rule ip6 cni_hostport hostip_hostports ip6 daddr 2001:db8:a::1 tcp dport 8085 dnat to [2001:db8::2]:85 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip6 cni_hostport hostports tcp dport 8080 dnat to [2001:db8::2]:80 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip6 cni_hostport hostports tcp dport 8081 dnat to [2001:db8::2]:80 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip6 cni_hostport hostports udp dport 8080 dnat to [2001:db8::2]:81 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip6 cni_hostport hostports udp dport 8082 dnat to [2001:db8::2]:82 comment "ee26b0dd4af7e749-icee6giejonei6so"
+add rule ip6 cni_hostport hostports tcp dport 8086 dnat to [2001:db8::2]:86 comment "ee26b0dd4af7e749-icee6giejonei6so"
 add rule ip6 cni_hostport output c d jump hostip_hostports
 add rule ip6 cni_hostport output c d fib daddr type local jump hostports
 add rule ip6 cni_hostport prerouting c d jump hostip_hostports
@@ -129,6 +130,98 @@ add rule ip6 cni_hostport prerouting c d jump hostports
 				actualRules = strings.TrimSpace(ipv6Fake.Dump())
 				Expect(actualRules).To(Equal(expectedRules))
 			})
+
+			It(fmt.Sprintf("[%s] generates per-mapping SNAT rules", ver), func() {
+				configBytes := []byte(fmt.Sprintf(`{
+					"name": "test",
+					"type": "portmap",
+					"cniVersion": "%s",
+					"backend": "nftables",
+					"snat": false,
+					"runtimeConfig": {
+						"portMappings": [
+							{ "hostPort": 8080, "containerPort": 80, "protocol": "tcp"},
+							{ "hostPort": 8081, "containerPort": 81, "protocol": "tcp", "snat": true},
+							{ "hostPort": 8082, "containerPort": 82, "protocol": "udp", "snatSourceIP": "198.51.100.1"}
+						]
+					}
+				}`, ver))
+
+				conf, _, err := parseConfig(configBytes, "foo", "")
+				Expect(err).NotTo(HaveOccurred())
+				conf.ContainerID = containerID
+
+				containerNet, err := types.ParseCIDR("10.0.0.2/24")
+				Expect(err).NotTo(HaveOccurred())
+
+				err = pmNFT.forwardPorts(conf, *containerNet)
+				Expect(err).NotTo(HaveOccurred())
+
+				dump := ipv4Fake.Dump()
+				Expect(dump).To(ContainSubstring(`add chain ip cni_hostport masquerading { type nat hook postrouting priority 100 ; }`))
+				Expect(dump).To(ContainSubstring(`add rule ip cni_hostport masquerading ip saddr 10.0.0.2 ip daddr 10.0.0.2 tcp dport 81 ct original proto-dst 8081 masquerade comment "ee26b0dd4af7e749-icee6giejonei6so"`))
+				Expect(dump).To(ContainSubstring(`add rule ip cni_hostport masquerading ip saddr 127.0.0.1 ip daddr 10.0.0.2 tcp dport 81 ct original proto-dst 8081 masquerade comment "ee26b0dd4af7e749-icee6giejonei6so"`))
+				Expect(dump).To(ContainSubstring(`add rule ip cni_hostport masquerading ip saddr 10.0.0.2 ip daddr 10.0.0.2 udp dport 82 ct original proto-dst 8082 snat to 198.51.100.1 comment "ee26b0dd4af7e749-icee6giejonei6so"`))
+				Expect(dump).NotTo(ContainSubstring("dport 80 "))
+				Expect(strings.Count(dump, "add rule ip cni_hostport masquerading")).To(Equal(4))
+
+				Expect(pmNFT.checkPorts(conf, *containerNet)).To(Succeed())
+
+				// The IPv4 source does not apply to IPv6, so that mapping is masqueraded
+				containerNet, err = types.ParseCIDR("2001:db8::2/64")
+				Expect(err).NotTo(HaveOccurred())
+				err = pmNFT.forwardPorts(conf, *containerNet)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ipv6Fake.Dump()).To(ContainSubstring(`add rule ip6 cni_hostport masquerading ip6 saddr 2001:db8::2 ip6 daddr 2001:db8::2 udp dport 82 ct original proto-dst 8082 masquerade comment "ee26b0dd4af7e749-icee6giejonei6so"`))
+			})
+
+			It(fmt.Sprintf("[%s] reports the backend as available on STATUS", ver), func() {
+				Expect(pmNFT.status(&PortMapConf{})).To(Succeed())
+				// STATUS must not leave anything behind
+				Expect(ipv4Fake.Dump()).To(BeEmpty())
+			})
+
+			It(fmt.Sprintf("[%s] deletes only stale rules of this network on GC", ver), func() {
+				configBytes := []byte(fmt.Sprintf(`{
+					"name": "test",
+					"type": "portmap",
+					"cniVersion": "%s",
+					"backend": "nftables",
+					"runtimeConfig": {
+						"portMappings": [
+							{ "hostPort": 8080, "containerPort": 80, "protocol": "tcp"}
+						]
+					}
+				}`, ver))
+
+				conf, _, err := parseConfig(configBytes, "foo", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				containerNet, err := types.ParseCIDR("10.0.0.2/24")
+				Expect(err).NotTo(HaveOccurred())
+				conf.ContainerID = containerID
+				Expect(pmNFT.forwardPorts(conf, *containerNet)).To(Succeed())
+
+				containerNet, err = types.ParseCIDR("10.0.0.3/24")
+				Expect(err).NotTo(HaveOccurred())
+				conf.ContainerID = "stale"
+				Expect(pmNFT.forwardPorts(conf, *containerNet)).To(Succeed())
+
+				// A container with the same ID on another network must survive
+				otherConf := *conf
+				otherConf.Name = "other"
+				containerNet, err = types.ParseCIDR("10.1.0.3/24")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pmNFT.forwardPorts(&otherConf, *containerNet)).To(Succeed())
+
+				err = pmNFT.gcPorts(conf, gc.NewAttachments([]types.GCAttachment{{ContainerID: containerID, IfName: "eth0"}}))
+				Expect(err).NotTo(HaveOccurred())
+
+				dump := ipv4Fake.Dump()
+				Expect(dump).To(ContainSubstring(`comment "ee26b0dd4af7e749-icee6giejonei6so"`))
+				Expect(dump).NotTo(ContainSubstring(`comment "ee26b0dd4af7e749-stale"`))
+				Expect(dump).To(ContainSubstring(fmt.Sprintf(`comment "%s"`, commentForContainer("other", "stale"))))
+			})
 		})
 	}
 })
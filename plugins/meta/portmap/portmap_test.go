@@ -72,7 +72,7 @@ var _ = Describe("portmapping configuration", func() {
 						]
 					}
 				}`, ver))
-				c, _, err := parseConfig(configBytes, "container")
+				c, _, err := parseConfig(configBytes, "container", "")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(c.CNIVersion).To(Equal(ver))
 				Expect(c.ConditionsV4).To(Equal(&[]string{"-s", "1.2.3.4"}))
@@ -100,7 +100,7 @@ var _ = Describe("portmapping configuration", func() {
 					"conditionsV4": ["-s", "1.2.3.4"],
 					"conditionsV6": ["-s", "12::34"]
 				}`, ver))
-				c, _, err := parseConfig(configBytes, "container")
+				c, _, err := parseConfig(configBytes, "container", "")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(c.CNIVersion).To(Equal(ver))
 				Expect(c.ConditionsV4).To(Equal(&[]string{"-s", "1.2.3.4"}))
@@ -125,10 +125,48 @@ var _ = Describe("portmapping configuration", func() {
 						]
 					}
 				}`, ver))
-				_, _, err := parseConfig(configBytes, "container")
+				_, _, err := parseConfig(configBytes, "container", "")
 				Expect(err).To(MatchError("Invalid host port number: 0"))
 			})
 
+			It(fmt.Sprintf("[%s] fails with invalid per-mapping SNAT settings", ver), func() {
+				for mapping, msg := range map[string]string{
+					`"snatSourceIP": "bogus"`:                    `Invalid SNAT source IP: "bogus"`,
+					`"snatSourceIP": "192.0.2.1", "snat": false`: "SNAT source IP 192.0.2.1 given for host port 8080, which has SNAT disabled",
+				} {
+					configBytes := []byte(fmt.Sprintf(`{
+						"name": "test",
+						"type": "portmap",
+						"cniVersion": "%s",
+						"backend": "iptables",
+						"runtimeConfig": {
+							"portMappings": [
+								{ "hostPort": 8080, "containerPort": 80, "protocol": "tcp", %s}
+							]
+						}
+					}`, ver, mapping))
+					_, _, err := parseConfig(configBytes, "container", "")
+					Expect(err).To(MatchError(msg))
+				}
+			})
+
+			It(fmt.Sprintf("[%s] rejects unknown keys in strict mode", ver), func() {
+				configBytes := []byte(fmt.Sprintf(`{
+					"name": "test",
+					"type": "portmap",
+					"cniVersion": "%s",
+					"backend": "iptables",
+					"snat": false,
+					"markMasqBit": 13,
+					"conditionV4": ["-s", "1.2.3.4"]
+				}`, ver))
+				_, _, err := parseConfig(configBytes, "container", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, _, err = parseConfig(configBytes, "container", "IgnoreUnknown=1;StrictConfig=true")
+				Expect(err).To(MatchError(`failed to parse network configuration: unknown configuration keys: "conditionV4"`))
+			})
+
 			It(fmt.Sprintf("[%s] defaults to iptables when backend is not specified", ver), func() {
 				// "defaults to iptables" is only true if iptables is installed
 				// (or if neither iptables nor nftables is installed), but the
@@ -139,7 +177,7 @@ var _ = Describe("portmapping configuration", func() {
 					"type": "portmap",
 					"cniVersion": "%s"
 				}`, ver))
-				c, _, err := parseConfig(configBytes, "container")
+				c, _, err := parseConfig(configBytes, "container", "")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(c.CNIVersion).To(Equal(ver))
 				Expect(c.Backend).To(Equal(&iptablesBackend))
@@ -153,11 +191,12 @@ var _ = Describe("portmapping configuration", func() {
 					"cniVersion": "%s",
 					"backend": "nftables"
 				}`, ver))
-				c, _, err := parseConfig(configBytes, "container")
+				c, _, err := parseConfig(configBytes, "container", "")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(c.CNIVersion).To(Equal(ver))
 				Expect(c.Backend).To(Equal(&nftablesBackend))
 				Expect(c.Name).To(Equal("test"))
+				Expect(statusModules(c)).To(ConsistOf("nf_conntrack", "nf_nat", "nf_tables"))
 			})
 
 			It(fmt.Sprintf("[%s] allows nftables conditions if nftables is requested", ver), func() {
@@ -169,7 +208,7 @@ var _ = Describe("portmapping configuration", func() {
 					"conditionsV4": ["ip", "saddr", "1.2.3.4"],
 					"conditionsV6": ["ip6", "saddr", "12::34"]
 				}`, ver))
-				c, _, err := parseConfig(configBytes, "container")
+				c, _, err := parseConfig(configBytes, "container", "")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(c.CNIVersion).To(Equal(ver))
 				Expect(c.Backend).To(Equal(&nftablesBackend))
@@ -187,7 +226,7 @@ var _ = Describe("portmapping configuration", func() {
 					"conditionsV4": ["ip", "saddr", "1.2.3.4"],
 					"conditionsV6": ["ip6", "saddr", "12::34"]
 				}`, ver))
-				_, _, err := parseConfig(configBytes, "container")
+				_, _, err := parseConfig(configBytes, "container", "")
 				Expect(err).To(MatchError("iptables backend was requested but configuration contains nftables-specific options [conditionsV4 conditionsV6]"))
 			})
 
@@ -201,7 +240,7 @@ var _ = Describe("portmapping configuration", func() {
 					"conditionsV4": ["-s", "1.2.3.4"],
 					"conditionsV6": ["-s", "12::34"]
 				}`, ver))
-				_, _, err := parseConfig(configBytes, "container")
+				_, _, err := parseConfig(configBytes, "container", "")
 				Expect(err).To(MatchError("nftables backend was requested but configuration contains iptables-specific options [externalSetMarkChain conditionsV4 conditionsV6]"))
 			})
 
@@ -229,7 +268,7 @@ var _ = Describe("portmapping configuration", func() {
 						]
 					}
 				}`, ver))
-				_, _, err := parseConfig(configBytes, "container")
+				_, _, err := parseConfig(configBytes, "container", "")
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
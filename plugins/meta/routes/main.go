@@ -0,0 +1,503 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This is a chained plugin that installs additional routes and policy
+// routing rules in the container network namespace. They are taken from the
+// network configuration and from the "routes" and "rules" capabilities of
+// the runtime, verified on CHECK and removed on DEL.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/utils"
+	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+)
+
+// Route is a route to install. Dev defaults to CNI_IFNAME, and Table to the
+// main table.
+type Route struct {
+	Dst    string `json:"dst"`
+	GW     string `json:"gw,omitempty"`
+	Src    string `json:"src,omitempty"`
+	Dev    string `json:"dev,omitempty"`
+	Metric int    `json:"metric,omitempty"`
+	Table  int    `json:"table,omitempty"`
+	// Scope is "universe", "link" or "host"; it defaults to "link" for
+	// routes without a gateway, as with ip-route(8).
+	Scope string `json:"scope,omitempty"`
+	MTU   int    `json:"mtu,omitempty"`
+	// LockMTU keeps path MTU discovery from lowering the MTU of the route.
+	// It defaults to the MTU of the interface in the previous result.
+	LockMTU bool `json:"lockMtu,omitempty"`
+}
+
+// Rule is a policy routing rule looking up Table. A rule without Src or Dst
+// is installed for both IPv4 and IPv6.
+type Rule struct {
+	Src      string  `json:"src,omitempty"`
+	Dst      string  `json:"dst,omitempty"`
+	IIF      string  `json:"iif,omitempty"`
+	OIF      string  `json:"oif,omitempty"`
+	Mark     *uint32 `json:"mark,omitempty"`
+	Mask     *uint32 `json:"mask,omitempty"`
+	Table    int     `json:"table"`
+	Priority *int    `json:"priority,omitempty"`
+}
+
+// PluginConf is the configuration document passed in.
+type PluginConf struct {
+	types.NetConf
+
+	RawPrevResult *map[string]interface{} `json:"prevResult"`
+	PrevResult    *current.Result         `json:"-"`
+
+	Routes []Route `json:"routes,omitempty"`
+	Rules  []Rule  `json:"rules,omitempty"`
+
+	RuntimeConfig struct {
+		Routes []Route `json:"routes,omitempty"`
+		Rules  []Rule  `json:"rules,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+}
+
+// parseConfig parses the supplied configuration (and prevResult) from stdin.
+func parseConfig(stdin []byte) (*PluginConf, error) {
+	conf := PluginConf{}
+
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	if conf.RawPrevResult != nil {
+		resultBytes, err := json.Marshal(conf.RawPrevResult)
+		if err != nil {
+			return nil, fmt.Errorf("could not serialize prevResult: %v", err)
+		}
+		res, err := version.NewResult(conf.CNIVersion, resultBytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+		conf.RawPrevResult = nil
+		conf.PrevResult, err = current.NewResultFromResult(res)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	return &conf, nil
+}
+
+// allRoutes returns the routes of the configuration followed by the ones of
+// the runtime.
+func (conf *PluginConf) allRoutes() []Route {
+	return append(append([]Route{}, conf.Routes...), conf.RuntimeConfig.Routes...)
+}
+
+// routes returns allRoutes resolved against the links of the current netns.
+// The MTU of the routes locking it without one is taken from the previous
+// result, as earlier plugins may have changed the MTU of the interface.
+func (conf *PluginConf) routes(ifName string) ([]*netlink.Route, error) {
+	routes := []*netlink.Route{}
+	for _, r := range conf.allRoutes() {
+		route, err := r.toNetlink(ifName)
+		if err != nil {
+			return nil, err
+		}
+		if r.LockMTU && route.MTU == 0 {
+			dev := r.Dev
+			if dev == "" {
+				dev = ifName
+			}
+			route.MTU = utils.ResultMTU(conf.PrevResult, dev, true)
+			if route.MTU == 0 {
+				link, err := netlinksafe.LinkByName(dev)
+				if err != nil {
+					return nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup %q for route to %s", dev, r.Dst)
+				}
+				route.MTU = link.Attrs().MTU
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// rules returns the rules of the configuration followed by the ones of the
+// runtime.
+func (conf *PluginConf) rules() ([]*netlink.Rule, error) {
+	rules := []*netlink.Rule{}
+	for _, r := range append(conf.Rules, conf.RuntimeConfig.Rules...) {
+		rs, err := r.toNetlink()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rs...)
+	}
+	return rules, nil
+}
+
+func parseScope(scope string) (netlink.Scope, error) {
+	switch scope {
+	case "universe", "global":
+		return netlink.SCOPE_UNIVERSE, nil
+	case "link":
+		return netlink.SCOPE_LINK, nil
+	case "host":
+		return netlink.SCOPE_HOST, nil
+	}
+	return 0, fmt.Errorf("invalid route scope %q", scope)
+}
+
+func family(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+func (r *Route) toNetlink(ifName string) (*netlink.Route, error) {
+	_, dst, err := net.ParseCIDR(r.Dst)
+	if err != nil {
+		return nil, fmt.Errorf("invalid route destination %q", r.Dst)
+	}
+	route := &netlink.Route{
+		Dst:      dst,
+		Priority: r.Metric,
+		Table:    r.Table,
+		MTU:      r.MTU,
+		Scope:    netlink.SCOPE_LINK,
+	}
+	if route.Table == 0 {
+		route.Table = syscall.RT_TABLE_MAIN
+	}
+
+	if r.GW != "" {
+		route.Gw = net.ParseIP(r.GW)
+		if route.Gw == nil || family(route.Gw) != family(dst.IP) {
+			return nil, fmt.Errorf("invalid gateway %q for route to %s", r.GW, r.Dst)
+		}
+		route.Scope = netlink.SCOPE_UNIVERSE
+	}
+	if r.Src != "" {
+		route.Src = net.ParseIP(r.Src)
+		if route.Src == nil || family(route.Src) != family(dst.IP) {
+			return nil, fmt.Errorf("invalid source %q for route to %s", r.Src, r.Dst)
+		}
+	}
+	if r.Scope != "" {
+		if route.Scope, err = parseScope(r.Scope); err != nil {
+			return nil, err
+		}
+	}
+
+	dev := r.Dev
+	if dev == "" {
+		dev = ifName
+	}
+	link, err := netlinksafe.LinkByName(dev)
+	if err != nil {
+		return nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to lookup %q for route to %s", dev, r.Dst)
+	}
+	route.LinkIndex = link.Attrs().Index
+
+	return route, nil
+}
+
+func (r *Rule) toNetlink() ([]*netlink.Rule, error) {
+	if r.Table <= 0 {
+		return nil, fmt.Errorf("invalid rule table %d", r.Table)
+	}
+
+	rule := netlink.NewRule()
+	rule.Table = r.Table
+	rule.IifName = r.IIF
+	rule.OifName = r.OIF
+	if r.Priority != nil {
+		rule.Priority = *r.Priority
+	}
+	if r.Mark != nil {
+		rule.Mark = *r.Mark
+	}
+	rule.Mask = r.Mask
+
+	families := []int{}
+	for _, sel := range []struct {
+		addr string
+		dst  **net.IPNet
+	}{{r.Src, &rule.Src}, {r.Dst, &rule.Dst}} {
+		if sel.addr == "" {
+			continue
+		}
+		_, ipn, err := net.ParseCIDR(sel.addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule selector %q", sel.addr)
+		}
+		*sel.dst = ipn
+		families = append(families, family(ipn.IP))
+	}
+
+	switch {
+	case len(families) == 2 && families[0] != families[1]:
+		return nil, fmt.Errorf("rule from %s to %s mixes address families", r.Src, r.Dst)
+	case len(families) > 0:
+		rule.Family = families[0]
+		return []*netlink.Rule{rule}, nil
+	}
+
+	rule6 := *rule
+	rule.Family = netlink.FAMILY_V4
+	rule6.Family = netlink.FAMILY_V6
+	return []*netlink.Rule{rule, &rule6}, nil
+}
+
+// ruleExists tells whether a rule matching rule is installed.
+func ruleExists(rule *netlink.Rule) (bool, error) {
+	rules, err := netlinksafe.RuleList(rule.Family)
+	if err != nil {
+		return false, fmt.Errorf("failed to list rules: %v", err)
+	}
+	for _, r := range rules {
+		if rulesMatch(&r, rule) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func rulesMatch(a, b *netlink.Rule) bool {
+	if a.Table != b.Table || a.IifName != b.IifName || a.OifName != b.OifName ||
+		a.Mark != b.Mark || !ipNetEqual(a.Src, b.Src) || !ipNetEqual(a.Dst, b.Dst) {
+		return false
+	}
+	if b.Priority >= 0 && a.Priority != b.Priority {
+		return false
+	}
+	if b.Mask != nil && (a.Mask == nil || *a.Mask != *b.Mask) {
+		return false
+	}
+	return true
+}
+
+func ipNetEqual(a, b *net.IPNet) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.String() == b.String()
+}
+
+// routeExists tells whether a route matching route is installed.
+func routeExists(route *netlink.Route) (bool, error) {
+	routes, err := netlinksafe.RouteListFiltered(family(route.Dst.IP), route,
+		netlink.RT_FILTER_DST|netlink.RT_FILTER_TABLE|netlink.RT_FILTER_OIF)
+	if err != nil {
+		return false, fmt.Errorf("failed to list routes: %v", err)
+	}
+	for _, r := range routes {
+		if !r.Gw.Equal(route.Gw) {
+			continue
+		}
+		// The kernel picks a metric for IPv6 routes without one, and
+		// ignores their scope
+		if route.Priority != 0 && r.Priority != route.Priority {
+			continue
+		}
+		if route.Dst.IP.To4() != nil && r.Scope != route.Scope {
+			continue
+		}
+		if route.Src != nil && !r.Src.Equal(route.Src) {
+			continue
+		}
+		if route.MTU != 0 && r.MTU != route.MTU {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// addToResult records the routes of the main table in the result.
+func addToResult(result *current.Result, routes []*netlink.Route) {
+	for _, route := range routes {
+		if route.Table != syscall.RT_TABLE_MAIN {
+			continue
+		}
+		result.Routes = append(result.Routes, &types.Route{
+			Dst:      *route.Dst,
+			GW:       route.Gw,
+			MTU:      route.MTU,
+			Priority: route.Priority,
+		})
+	}
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		routes, err := conf.routes(args.IfName)
+		if err != nil {
+			return err
+		}
+		rules, err := conf.rules()
+		if err != nil {
+			return err
+		}
+
+		specs := conf.allRoutes()
+		for i, route := range routes {
+			add := netlink.RouteAdd
+			if specs[i].LockMTU {
+				add = routeAddLockedMTU
+			}
+			if err := add(route); err != nil {
+				return fmt.Errorf("failed to add route to %s: %v", route.Dst, err)
+			}
+		}
+		for _, rule := range rules {
+			if err := netlink.RuleAdd(rule); err != nil {
+				return fmt.Errorf("failed to add rule %s: %v", rule, err)
+			}
+		}
+
+		addToResult(conf.PrevResult, routes)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if args.Netns == "" {
+		return nil
+	}
+
+	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		rules, err := conf.rules()
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			if err := netlink.RuleDel(rule); err != nil && !errors.Is(err, syscall.ENOENT) {
+				return fmt.Errorf("failed to delete rule %s: %v", rule, err)
+			}
+		}
+
+		// The routes of an interface already gone went with it
+		for _, r := range conf.allRoutes() {
+			route, err := r.toNetlink(args.IfName)
+			if err != nil {
+				if cnierrors.Code(err) == cnierrors.ErrInterfaceNotFound {
+					continue
+				}
+				return err
+			}
+			if err := netlink.RouteDel(route); err != nil && !errors.Is(err, syscall.ESRCH) {
+				return fmt.Errorf("failed to delete route to %s: %v", route.Dst, err)
+			}
+		}
+		return nil
+	})
+	if _, ok := err.(ns.NSPathNotExistErr); ok {
+		return nil
+	}
+	return err
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.PrevResult == nil {
+		return fmt.Errorf("must be called as chained plugin")
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	return netns.Do(func(_ ns.NetNS) error {
+		routes, err := conf.routes(args.IfName)
+		if err != nil {
+			return err
+		}
+		rules, err := conf.rules()
+		if err != nil {
+			return err
+		}
+
+		for _, route := range routes {
+			ok, err := routeExists(route)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("route to %s in table %d missing", route.Dst, route.Table)
+			}
+		}
+		for _, rule := range rules {
+			ok, err := ruleExists(rule)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("rule %s missing", rule)
+			}
+		}
+		return nil
+	})
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Check: cmdCheck,
+		Del:   cmdDel,
+	}, version.All, bv.BuildString("routes"))
+}
@@ -0,0 +1,71 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// routeAddLockedMTU adds route as netlink.RouteAdd does, but with its MTU
+// locked (RTAX_LOCK), which netlink.Route cannot express. Only the fields
+// set by Route.toNetlink are supported.
+func routeAddLockedMTU(route *netlink.Route) error {
+	fam := family(route.Dst.IP)
+	addr := func(ip net.IP) []byte {
+		if fam == netlink.FAMILY_V4 {
+			return ip.To4()
+		}
+		return ip.To16()
+	}
+
+	req := nl.NewNetlinkRequest(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+
+	msg := nl.NewRtMsg()
+	msg.Family = uint8(fam)
+	ones, _ := route.Dst.Mask.Size()
+	msg.Dst_len = uint8(ones)
+	msg.Scope = uint8(route.Scope)
+	// Tables above 255 only fit in RTA_TABLE
+	msg.Table = unix.RT_TABLE_UNSPEC
+	if route.Table < 256 {
+		msg.Table = uint8(route.Table)
+	}
+	req.AddData(msg)
+
+	req.AddData(nl.NewRtAttr(unix.RTA_DST, addr(route.Dst.IP)))
+	req.AddData(nl.NewRtAttr(unix.RTA_TABLE, nl.Uint32Attr(uint32(route.Table))))
+	req.AddData(nl.NewRtAttr(unix.RTA_OIF, nl.Uint32Attr(uint32(route.LinkIndex))))
+	if route.Gw != nil {
+		req.AddData(nl.NewRtAttr(unix.RTA_GATEWAY, addr(route.Gw)))
+	}
+	if route.Src != nil {
+		req.AddData(nl.NewRtAttr(unix.RTA_PREFSRC, addr(route.Src)))
+	}
+	if route.Priority > 0 {
+		req.AddData(nl.NewRtAttr(unix.RTA_PRIORITY, nl.Uint32Attr(uint32(route.Priority))))
+	}
+
+	metrics := nl.NewRtAttr(unix.RTA_METRICS, nil)
+	metrics.AddRtAttr(unix.RTAX_LOCK, nl.Uint32Attr(1<<unix.RTAX_MTU))
+	metrics.AddRtAttr(unix.RTAX_MTU, nl.Uint32Attr(uint32(route.MTU)))
+	req.AddData(metrics)
+
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
@@ -0,0 +1,298 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+const ifname = "eth0"
+
+var _ = Describe("routes plugin", func() {
+	var targetNs ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		targetNs, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			veth := &netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: ifname},
+				PeerName:  "peer0",
+			}
+			if err := netlink.LinkAdd(veth); err != nil {
+				return err
+			}
+			if err := netlink.LinkSetUp(veth); err != nil {
+				return err
+			}
+			for _, addr := range []string{"192.168.1.2/24", "2001:db8::2/64"} {
+				ip, ipn, _ := net.ParseCIDR(addr)
+				ipn.IP = ip
+				if err := netlink.AddrAdd(veth, &netlink.Addr{IPNet: ipn, Flags: 0x80 /* IFA_F_NODAD */}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(targetNs.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(targetNs)).To(Succeed())
+	})
+
+	It("installs, checks and removes routes and rules", func() {
+		conf := []byte(`{
+	"cniVersion": "1.0.0",
+	"name": "test",
+	"type": "routes",
+	"routes": [
+		{"dst": "10.10.0.0/16", "gw": "192.168.1.1", "metric": 50},
+		{"dst": "2001:db8:1::/48", "gw": "2001:db8::1", "table": 200}
+	],
+	"rules": [{"src": "192.168.1.2/32", "table": 200, "priority": 1000}],
+	"runtimeConfig": {
+		"routes": [{"dst": "10.20.0.0/16", "table": 200}],
+		"rules": [{"mark": 16, "table": 200, "priority": 1001}]
+	},
+	"prevResult": {
+		"cniVersion": "1.0.0",
+		"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+		"ips": [{"address": "192.168.1.2/24", "gateway": "192.168.1.1", "interface": 0},
+			{"address": "2001:db8::2/64", "interface": 0}]
+	}
+}`)
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+			StdinData:   conf,
+		}
+
+		r, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		// Only the routes of the main table are reported
+		result, err := types100.GetResult(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Routes).To(HaveLen(1))
+		Expect(result.Routes[0].Dst.String()).To(Equal("10.10.0.0/16"))
+		Expect(result.Routes[0].Priority).To(Equal(50))
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			defer GinkgoRecover()
+
+			routes, err := netlinksafe.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: 200}, netlink.RT_FILTER_TABLE)
+			Expect(err).NotTo(HaveOccurred())
+			dsts := []string{}
+			for _, route := range routes {
+				dsts = append(dsts, route.Dst.String())
+			}
+			Expect(dsts).To(ConsistOf("2001:db8:1::/48", "10.20.0.0/16"))
+
+			rules, err := netlinksafe.RuleList(netlink.FAMILY_V4)
+			Expect(err).NotTo(HaveOccurred())
+			priorities := []int{}
+			for _, rule := range rules {
+				if rule.Table == 200 {
+					priorities = append(priorities, rule.Priority)
+				}
+			}
+			Expect(priorities).To(ConsistOf(1000, 1001))
+
+			// The mark rule is installed for IPv6 as well
+			rules, err = netlinksafe.RuleList(netlink.FAMILY_V6)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(ContainElement(HaveField("Priority", 1001)))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		args.StdinData = conf
+		Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(Succeed())
+
+		// CHECK notices a route gone missing
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			_, dst, _ := net.ParseCIDR("10.20.0.0/16")
+			link, err := netlinksafe.LinkByName(ifname)
+			if err != nil {
+				return err
+			}
+			return netlink.RouteDel(&netlink.Route{Dst: dst, Table: 200, LinkIndex: link.Attrs().Index, Scope: netlink.SCOPE_LINK})
+		})
+		Expect(err).NotTo(HaveOccurred())
+		err = testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+		Expect(err).To(MatchError(ContainSubstring("route to 10.20.0.0/16 in table 200 missing")))
+
+		// DEL removes what is left and tolerates what is already gone
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			defer GinkgoRecover()
+
+			routes, err := netlinksafe.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: 200}, netlink.RT_FILTER_TABLE)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routes).To(BeEmpty())
+			routes, err = netlinksafe.RouteList(nil, netlink.FAMILY_V4)
+			Expect(err).NotTo(HaveOccurred())
+			for _, route := range routes {
+				Expect(route.Dst.String()).NotTo(Equal("10.10.0.0/16"))
+			}
+
+			for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+				rules, err := netlinksafe.RuleList(family)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rules).NotTo(ContainElement(HaveField("Table", 200)))
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("locks the route MTU to the one recorded in the result", func() {
+		conf := []byte(`{
+	"cniVersion": "1.0.0",
+	"name": "test",
+	"type": "routes",
+	"routes": [
+		{"dst": "10.30.0.0/16", "gw": "192.168.1.1", "lockMtu": true},
+		{"dst": "10.40.0.0/16", "gw": "192.168.1.1", "mtu": 1300, "lockMtu": true}
+	],
+	"prevResult": {
+		"cniVersion": "1.0.0",
+		"interfaces": [{"name": "eth0", "mtu": 1400, "sandbox": "/var/run/netns/test"}],
+		"ips": [{"address": "192.168.1.2/24", "gateway": "192.168.1.1", "interface": 0}]
+	}
+}`)
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+			StdinData:   conf,
+		}
+
+		_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+		Expect(testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })).To(Succeed())
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			defer GinkgoRecover()
+
+			// netlink.Route does not report the lock
+			out, err := exec.Command("ip", "-4", "route", "show").CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(out))
+			Expect(string(out)).To(MatchRegexp(`10\.30\.0\.0/16 via 192\.168\.1\.1 dev eth0 .*mtu lock 1400`))
+			Expect(string(out)).To(MatchRegexp(`10\.40\.0\.0/16 via 192\.168\.1\.1 dev eth0 .*mtu lock 1300`))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+	})
+
+	It("rejects invalid routes and rules", func() {
+		for _, extra := range []string{
+			`"routes": [{"dst": "10.10.0.0"}]`,
+			`"routes": [{"dst": "10.10.0.0/16", "gw": "2001:db8::1"}]`,
+			`"routes": [{"dst": "10.10.0.0/16", "scope": "site"}]`,
+			`"routes": [{"dst": "10.10.0.0/16", "dev": "missing0"}]`,
+			`"rules": [{"src": "192.168.1.2/32"}]`,
+			`"rules": [{"src": "192.168.1.2/32", "dst": "2001:db8::/64", "table": 100}]`,
+		} {
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNs.Path(),
+				IfName:      ifname,
+				StdinData: []byte(fmt.Sprintf(`{
+	"cniVersion": "1.0.0",
+	"name": "test",
+	"type": "routes",
+	%s,
+	"prevResult": {
+		"cniVersion": "1.0.0",
+		"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+		"ips": [{"address": "192.168.1.2/24", "gateway": "192.168.1.1", "interface": 0}]
+	}
+}`, extra)),
+			}
+			_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+			Expect(err).To(HaveOccurred(), extra)
+		}
+	})
+
+	It("returns typed errors for a missing netns or interface", func() {
+		conf := []byte(`{
+	"cniVersion": "1.0.0",
+	"name": "test",
+	"type": "routes",
+	"routes": [{"dst": "10.10.0.0/16", "dev": "missing0"}],
+	"prevResult": {
+		"cniVersion": "1.0.0",
+		"ips": [{"address": "192.168.1.2/24"}]
+	}
+}`)
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+			StdinData:   conf,
+		}
+		_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(cnierrors.Code(err)).To(Equal(cnierrors.ErrInterfaceNotFound))
+
+		args.Netns = "/var/run/netns/nonexistent"
+		_, _, err = testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(cnierrors.Code(err)).To(Equal(types.ErrInvalidNetNS))
+	})
+
+	It("requires a prevResult", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+			StdinData:   []byte(`{"cniVersion": "1.0.0", "name": "test", "type": "routes"}`),
+		}
+		_, _, err := testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).To(MatchError("must be called as chained plugin"))
+	})
+
+	It("does nothing on DEL once the netns is gone", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       "/var/run/netns/nonexistent",
+			IfName:      ifname,
+			StdinData:   []byte(`{"cniVersion": "1.0.0", "name": "test", "type": "routes", "routes": [{"dst": "10.10.0.0/16"}]}`),
+		}
+		Expect(testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })).To(Succeed())
+	})
+})
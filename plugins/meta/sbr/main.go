@@ -28,12 +28,17 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
 
-const firstTableID = 100
+const (
+	firstTableID   = 100
+	defaultDataDir = "/run/cni/sbr"
+)
 
 // PluginConf is the configuration document passed in.
 type PluginConf struct {
@@ -48,7 +53,137 @@ type PluginConf struct {
 	PrevResult    *current.Result         `json:"-"`
 
 	// Add plugin-specific flags here
+
+	// Table is the routing table the source rules point to. Its routes
+	// are expected to be managed by someone else, unless TableOffset is set.
 	Table *int `json:"table,omitempty"`
+	// TableOffset makes Table the start of a range reserved for this
+	// plugin: each attachment picks free tables from Table plus the index
+	// of its interface, and its routes are moved there as when no table is
+	// given.
+	TableOffset bool `json:"tableOffset,omitempty"`
+	// Priority is the priority of the source rules. If unset, the kernel
+	// places them just before the rule for the main table.
+	Priority *int `json:"priority,omitempty"`
+	// Interfaces selects the interfaces to set up source based routing
+	// for, by name or by index into the prevResult interfaces. Each gets
+	// its own tables. If empty, only CNI_IFNAME is set up.
+	Interfaces []InterfaceSelector `json:"interfaces,omitempty"`
+	// Fwmark, if set, makes the rules match this firewall mark, under
+	// FwmarkMask if set, rather than the source addresses, so that the
+	// traffic marked by other plugins of the chain is routed by the
+	// tables. A single interface with at most one address of each family
+	// can then be set up.
+	Fwmark     *uint32 `json:"fwmark,omitempty"`
+	FwmarkMask *uint32 `json:"fwmarkMask,omitempty"`
+	// DataDir records the rules of each attachment, for CHECK and GC.
+	DataDir string `json:"dataDir,omitempty"`
+}
+
+// InterfaceSelector is an interface name, or an index into the prevResult
+// interfaces.
+type InterfaceSelector struct {
+	Name  string
+	Index *int
+}
+
+func (s *InterfaceSelector) UnmarshalJSON(data []byte) error {
+	var index int
+	if err := json.Unmarshal(data, &index); err == nil {
+		s.Index = &index
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.Name); err != nil || s.Name == "" {
+		return fmt.Errorf("interface %s must be a name or an index", string(data))
+	}
+	return nil
+}
+
+func (s InterfaceSelector) MarshalJSON() ([]byte, error) {
+	if s.Index != nil {
+		return json.Marshal(*s.Index)
+	}
+	return json.Marshal(s.Name)
+}
+
+// interfaceNames resolves the interfaces to set up. prevResult is needed
+// for indices; selectors that cannot be resolved without it are skipped.
+func (conf *PluginConf) interfaceNames(ifName string) ([]string, error) {
+	if len(conf.Interfaces) == 0 {
+		return []string{ifName}, nil
+	}
+
+	names := []string{}
+	for _, s := range conf.Interfaces {
+		if s.Index == nil {
+			names = append(names, s.Name)
+			continue
+		}
+		if conf.PrevResult == nil {
+			log.Printf("No prevResult to resolve interface %d", *s.Index)
+			continue
+		}
+		if *s.Index < 0 || *s.Index >= len(conf.PrevResult.Interfaces) {
+			return nil, fmt.Errorf("interface index %d out of range of prevResult", *s.Index)
+		}
+		names = append(names, conf.PrevResult.Interfaces[*s.Index].Name)
+	}
+	return names, nil
+}
+
+// rulePriority returns the priority to give to the source rules.
+func (conf *PluginConf) rulePriority() int {
+	if conf.Priority != nil {
+		return *conf.Priority
+	}
+	// Let the kernel pick, as netlink.NewRule does.
+	return -1
+}
+
+// newRule returns the rule pointing the traffic of ip to table, matching
+// its source address or the mark of conf.
+func (conf *PluginConf) newRule(ip net.IP, table int) *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Table = table
+	rule.Priority = conf.rulePriority()
+
+	if conf.Fwmark != nil {
+		log.Printf("Mark to use %#x", *conf.Fwmark)
+		rule.Mark = *conf.Fwmark
+		rule.Mask = conf.FwmarkMask
+		rule.Family = netlink.FAMILY_V6
+		if ip.To4() != nil {
+			rule.Family = netlink.FAMILY_V4
+		}
+		return rule
+	}
+
+	// Source must be restricted to a single IP, not a full subnet
+	var src net.IPNet
+	src.IP = ip
+	if src.IP.To4() != nil {
+		src.Mask = net.CIDRMask(32, 32)
+	} else {
+		src.Mask = net.CIDRMask(128, 128)
+	}
+
+	log.Printf("Source to use %s", src.String())
+	rule.Src = &src
+	return rule
+}
+
+// checkFwmarkAddresses checks that a mark selects a single table of each
+// family, as fwmark rules cannot tell the addresses apart.
+func checkFwmarkAddresses(ipCfgs []*current.IPConfig) error {
+	families := map[bool]bool{}
+	for _, ipCfg := range ipCfgs {
+		v4 := ipCfg.Address.IP.To4() != nil
+		if families[v4] {
+			return fmt.Errorf("fwmark rules need at most one address of each family, got several for %v", ipCfg.Address.IP)
+		}
+		families[v4] = true
+	}
+	return nil
 }
 
 // Wrapper that does a lock before and unlock after operations to serialise
@@ -66,16 +201,16 @@ func withLockAndNetNS(nspath string, toRun func(_ ns.NetNS) error) error {
 	if err != nil {
 		return err
 	}
+	// Cleaner to unlock even though about to exit
+	defer lock.Unlock()
 
-	err = ns.WithNetNSPath(nspath, toRun)
+	netns, err := ns.GetNS(nspath)
 	if err != nil {
-		return err
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", nspath)
 	}
+	defer netns.Close()
 
-	// Cleaner to unlock even though about to exit
-	err = lock.Unlock()
-
-	return err
+	return netns.Do(toRun)
 }
 
 // parseConfig parses the supplied configuration (and prevResult) from stdin.
@@ -104,11 +239,36 @@ func parseConfig(stdin []byte) (*PluginConf, error) {
 	}
 	// End previous result parsing
 
+	if conf.TableOffset && conf.Table == nil {
+		return nil, fmt.Errorf("tableOffset requires table to be set")
+	}
+	if conf.Table != nil && *conf.Table <= 0 {
+		return nil, fmt.Errorf("invalid table %d", *conf.Table)
+	}
+	if conf.Priority != nil && *conf.Priority < 0 {
+		return nil, fmt.Errorf("invalid rule priority %d", *conf.Priority)
+	}
+	if conf.Fwmark != nil {
+		if *conf.Fwmark == 0 {
+			return nil, fmt.Errorf("invalid fwmark 0")
+		}
+		if len(conf.Interfaces) > 1 {
+			return nil, fmt.Errorf("fwmark rules can only be set up for a single interface")
+		}
+	} else if conf.FwmarkMask != nil {
+		return nil, fmt.Errorf("fwmarkMask requires fwmark to be set")
+	}
+	if conf.DataDir == "" {
+		conf.DataDir = defaultDataDir
+	}
+
 	return &conf, nil
 }
 
-// getIPCfgs finds the IPs on the supplied interface, returning as IPConfig structures
-func getIPCfgs(iface string, prevResult *current.Result) ([]*current.IPConfig, error) {
+// getIPCfgs finds the IPs on the supplied interface, returning as IPConfig
+// structures. IPs that have no interface are assumed to be on it unless
+// strict is set.
+func getIPCfgs(iface string, prevResult *current.Result, strict bool) ([]*current.IPConfig, error) {
 	if len(prevResult.IPs) == 0 {
 		// No IP addresses; that makes no sense. Pack it in.
 		return nil, fmt.Errorf("No IP addresses supplied on interface: %s", iface)
@@ -125,7 +285,9 @@ func getIPCfgs(iface string, prevResult *current.Result) ([]*current.IPConfig, e
 		// We assume a match if this index is missing.
 		if ipCfg.Interface == nil {
 			log.Printf("No interface for IP address %s", ipCfg.Address.IP)
-			ipCfgs = append(ipCfgs, ipCfg)
+			if !strict {
+				ipCfgs = append(ipCfgs, ipCfg)
+			}
 			continue
 		}
 
@@ -157,23 +319,54 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return fmt.Errorf("This plugin must be called as chained plugin")
 	}
 
-	// Get the list of relevant IPs.
-	ipCfgs, err := getIPCfgs(args.IfName, conf.PrevResult)
+	ifNames, err := conf.interfaceNames(args.IfName)
 	if err != nil {
 		return err
 	}
 
+	// Get the list of relevant IPs of each interface.
+	ipCfgs := map[string][]*current.IPConfig{}
+	for _, ifName := range ifNames {
+		ipCfgs[ifName], err = getIPCfgs(ifName, conf.PrevResult, len(conf.Interfaces) > 0)
+		if err != nil {
+			return err
+		}
+		if conf.Fwmark != nil {
+			if err := checkFwmarkAddresses(ipCfgs[ifName]); err != nil {
+				return err
+			}
+		}
+	}
+
 	// Do the actual work.
+	rec := &attachmentRecord{Netns: args.Netns}
 	err = withLockAndNetNS(args.Netns, func(_ ns.NetNS) error {
-		if conf.Table != nil {
-			return doRoutesWithTable(ipCfgs, *conf.Table)
+		for _, ifName := range ifNames {
+			if len(ipCfgs[ifName]) == 0 {
+				log.Printf("No IP addresses for interface %s, skipping", ifName)
+				continue
+			}
+			var rules []ruleRecord
+			if conf.Table != nil && !conf.TableOffset {
+				rules, err = doRoutesWithTable(ipCfgs[ifName], *conf.Table, conf)
+			} else {
+				rules, err = doRoutes(ipCfgs[ifName], ifName, conf)
+			}
+			rec.Rules = append(rec.Rules, rules...)
+			if err != nil {
+				return err
+			}
 		}
-		return doRoutes(ipCfgs, args.IfName)
+		return nil
 	})
 	if err != nil {
 		return err
 	}
 
+	if err := stateStore(conf).Save(attachmentKey(args), rec); err != nil {
+		return err
+	}
+
 	// Pass through the result for the next plugin
 	return types.PrintResult(conf.PrevResult, conf.CNIVersion)
 }
@@ -206,59 +399,53 @@ func getNextTableID(rules []netlink.Rule, routes []netlink.Route, candidateID in
 	return table
 }
 
-// doRoutes does all the work to set up routes and rules during an add.
-func doRoutes(ipCfgs []*current.IPConfig, iface string) error {
+// doRoutes does all the work to set up routes and rules during an add. It
+// returns the rules added, even on failure.
+func doRoutes(ipCfgs []*current.IPConfig, iface string, conf *PluginConf) ([]ruleRecord, error) {
 	// Get a list of rules and routes ready.
 	rules, err := netlinksafe.RuleList(netlink.FAMILY_ALL)
 	if err != nil {
-		return fmt.Errorf("Failed to list all rules: %v", err)
+		return nil, fmt.Errorf("Failed to list all rules: %v", err)
 	}
 
 	routes, err := netlinksafe.RouteList(nil, netlink.FAMILY_ALL)
 	if err != nil {
-		return fmt.Errorf("Failed to list all routes: %v", err)
+		return nil, fmt.Errorf("Failed to list all routes: %v", err)
 	}
 
-	// Pick a table ID to use. We pick the first table ID from firstTableID
-	// on that has no existing rules mapping to it and no existing routes in
-	// it.
-	table := getNextTableID(rules, routes, firstTableID)
-	log.Printf("First unreferenced table: %d", table)
-
 	link, err := netlinksafe.LinkByName(iface)
 	if err != nil {
-		return fmt.Errorf("Cannot find network interface %s: %v", iface, err)
+		return nil, cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "Cannot find network interface %s", iface)
 	}
 
 	linkIndex := link.Attrs().Index
 
+	// Pick a table ID to use. We pick the first table ID from firstTableID,
+	// or from the attachment's offset into the configured range, on that
+	// has no existing rules mapping to it and no existing routes in it.
+	candidateID := firstTableID
+	if conf.TableOffset {
+		candidateID = *conf.Table + linkIndex
+	}
+	table := getNextTableID(rules, routes, candidateID)
+	log.Printf("First unreferenced table: %d", table)
+
 	// Get all routes for the interface in the default routing table
 	routes, err = netlinksafe.RouteList(link, netlink.FAMILY_ALL)
 	if err != nil {
-		return fmt.Errorf("Unable to list routes: %v", err)
+		return nil, fmt.Errorf("Unable to list routes: %v", err)
 	}
 
 	// Loop through setting up source based rules and default routes.
+	var added []ruleRecord
 	for _, ipCfg := range ipCfgs {
 		log.Printf("Set rule for source %s", ipCfg.String())
-		rule := netlink.NewRule()
-		rule.Table = table
-
-		// Source must be restricted to a single IP, not a full subnet
-		var src net.IPNet
-		src.IP = ipCfg.Address.IP
-		if src.IP.To4() != nil {
-			src.Mask = net.CIDRMask(32, 32)
-		} else {
-			src.Mask = net.CIDRMask(128, 128)
-		}
-
-		log.Printf("Source to use %s", src.String())
-		rule.Src = &src
+		rule := conf.newRule(ipCfg.Address.IP, table)
 
 		if err = netlink.RuleAdd(rule); err != nil {
-			return fmt.Errorf("Failed to add rule: %v", err)
+			return added, fmt.Errorf("Failed to add rule: %v", err)
 		}
+		added = append(added, newRuleRecord(rule, true))
 
 		// Add a default route, since this may have been removed by previous
 		// plugin.
@@ -283,7 +470,7 @@ func doRoutes(ipCfgs []*current.IPConfig, iface string) error {
 
 			err = netlink.RouteAdd(&route)
 			if err != nil {
-				return fmt.Errorf("Failed to add default route to %s: %v",
+				return added, fmt.Errorf("Failed to add default route to %s: %v",
 					ipCfg.Gateway.String(),
 					err)
 			}
@@ -310,7 +497,7 @@ func doRoutes(ipCfgs []*current.IPConfig, iface string) error {
 				// is possible for the default gateway we added above.
 				err = netlink.RouteReplace(&r)
 				if err != nil {
-					return fmt.Errorf("Failed to readd route: %v", err)
+					return added, fmt.Errorf("Failed to readd route: %v", err)
 				}
 			}
 		}
@@ -328,37 +515,28 @@ func doRoutes(ipCfgs []*current.IPConfig, iface string) error {
 		log.Printf("Deleting route %s from table %d", route.String(), route.Table)
 		err := netlink.RouteDel(&route)
 		if err != nil {
-			return fmt.Errorf("Failed to delete route: %v", err)
+			return added, fmt.Errorf("Failed to delete route: %v", err)
 		}
 	}
 
-	return nil
+	return added, nil
 }
 
-func doRoutesWithTable(ipCfgs []*current.IPConfig, table int) error {
+// doRoutesWithTable adds the rules pointing to table, whose routes are
+// managed by someone else. It returns the rules added, even on failure.
+func doRoutesWithTable(ipCfgs []*current.IPConfig, table int, conf *PluginConf) ([]ruleRecord, error) {
+	var added []ruleRecord
 	for _, ipCfg := range ipCfgs {
 		log.Printf("Set rule for source %s", ipCfg.String())
-		rule := netlink.NewRule()
-		rule.Table = table
-
-		// Source must be restricted to a single IP, not a full subnet
-		var src net.IPNet
-		src.IP = ipCfg.Address.IP
-		if src.IP.To4() != nil {
-			src.Mask = net.CIDRMask(32, 32)
-		} else {
-			src.Mask = net.CIDRMask(128, 128)
-		}
-
-		log.Printf("Source to use %s", src.String())
-		rule.Src = &src
+		rule := conf.newRule(ipCfg.Address.IP, table)
 
 		if err := netlink.RuleAdd(rule); err != nil {
-			return fmt.Errorf("failed to add rule: %v", err)
+			return added, fmt.Errorf("failed to add rule: %v", err)
 		}
+		added = append(added, newRuleRecord(rule, false))
 	}
 
-	return nil
+	return added, nil
 }
 
 // cmdDel is called for DELETE requests
@@ -369,12 +547,43 @@ func cmdDel(args *skel.CmdArgs) error {
 		return err
 	}
 
-	log.Printf("Cleaning up SBR for %s", args.IfName)
-	err = withLockAndNetNS(args.Netns, func(_ ns.NetNS) error {
-		return tidyRules(args.IfName, conf.Table)
-	})
+	ifNames, err := conf.interfaceNames(args.IfName)
+	if err != nil {
+		return err
+	}
+
+	rec, err := loadRecord(conf, args)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Cleaning up SBR for %v", ifNames)
+	if args.Netns != "" {
+		err = withLockAndNetNS(args.Netns, func(_ ns.NetNS) error {
+			// With an offset the tables are picked as when no table is given.
+			table := conf.Table
+			if conf.TableOffset {
+				table = nil
+			}
+			// Keep on going, but return the last failure.
+			var errReturn error
+			if rec != nil {
+				errReturn = deleteRules(rec.Rules)
+			}
+			for _, ifName := range ifNames {
+				if err := tidyRules(ifName, table); err != nil {
+					errReturn = err
+				}
+			}
+			return errReturn
+		})
+		// The rules went with the netns
+		if err != nil && cnierrors.Code(err) != types.ErrInvalidNetNS {
+			return err
+		}
+	}
 
-	return err
+	return stateStore(conf).Delete(attachmentKey(args))
 }
 
 // Tidy up the rules for the deleted interface
@@ -414,7 +623,7 @@ func tidyRules(iface string, table *int) error {
 			return nil
 		}
 		log.Printf("Failed to get link %s: %v", iface, err)
-		return fmt.Errorf("Failed to get link %s: %v", iface, err)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "Failed to get link %s", iface)
 	}
 
 	addrs, err := netlinksafe.AddrList(link, netlink.FAMILY_ALL)
@@ -447,16 +656,72 @@ RULE_LOOP:
 	return errReturn
 }
 
+// cmdCheck is called for CHECK requests
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if conf.PrevResult == nil {
+		return fmt.Errorf("This plugin must be called as chained plugin")
+	}
+
+	rec, err := loadRecord(conf, args)
+	if err != nil {
+		return err
+	}
+
+	// Without a record, as for attachments made by previous versions, only
+	// the source rules of the addresses can be checked.
+	var ips []net.IP
+	if rec == nil {
+		if conf.Fwmark != nil {
+			return fmt.Errorf("no rules recorded for %s/%s", args.ContainerID, args.IfName)
+		}
+		ifNames, err := conf.interfaceNames(args.IfName)
+		if err != nil {
+			return err
+		}
+		for _, ifName := range ifNames {
+			ipCfgs, err := getIPCfgs(ifName, conf.PrevResult, len(conf.Interfaces) > 0)
+			if err != nil {
+				return err
+			}
+			for _, ipCfg := range ipCfgs {
+				ips = append(ips, ipCfg.Address.IP)
+			}
+		}
+	}
+
+	return withLockAndNetNS(args.Netns, func(_ ns.NetNS) error {
+		if rec == nil {
+			table := conf.Table
+			if conf.TableOffset {
+				table = nil
+			}
+			return checkSourceRules(ips, table)
+		}
+		return checkRules(rec.Rules)
+	})
+}
+
+// cmdGC is called for GC requests
+func cmdGC(args *skel.CmdArgs) error {
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	return gcRules(conf, gc.NewAttachments(conf.ValidAttachments))
+}
+
 func main() {
 	skel.PluginMainFuncs(skel.CNIFuncs{
 		Add:   cmdAdd,
 		Check: cmdCheck,
 		Del:   cmdDel,
-		/* FIXME GC */
+		GC:    cmdGC,
 		/* FIXME Status */
 	}, version.All, bv.BuildString("sbr"))
 }
-
-func cmdCheck(_ *skel.CmdArgs) error {
-	return nil
-}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"os"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -628,4 +629,427 @@ var _ = Describe("sbr test", func() {
 		Expect(rules[1].Table).To(Equal(tableID))
 		Expect(rules[1].Src.String()).To(Equal("192.168.1.209/32"))
 	})
+
+	It("Works with Table ID and rule priority", func() {
+		ifname := "net1"
+		conf := `{
+	"cniVersion": "0.3.0",
+	"name": "cni-plugin-sbr-test",
+	"type": "sbr",
+	"table": 5000,
+	"priority": 1000,
+	"prevResult": {
+		"cniVersion": "0.3.0",
+		"interfaces": [
+			{
+				"name": "%s",
+				"sandbox": "%s"
+			}
+		],
+		"ips": [
+			{
+				"address": "192.168.1.209/24",
+				"interface": 0
+			}
+		],
+		"routes": []
+	}
+}`
+		conf = fmt.Sprintf(conf, ifname, targetNs.Path())
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+			StdinData:   []byte(conf),
+		}
+
+		err := setup(targetNs, createDefaultStatus())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		var rules []netlink.Rule
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			var err error
+			rules, err = netlinksafe.RuleListFiltered(
+				netlink.FAMILY_ALL, &netlink.Rule{
+					Table: 5000,
+				},
+				netlink.RT_FILTER_TABLE,
+			)
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(HaveLen(1))
+		Expect(rules[0].Priority).To(Equal(1000))
+		Expect(rules[0].Src.String()).To(Equal("192.168.1.209/32"))
+	})
+
+	It("Works with a table offset", func() {
+		ifname := "net1"
+		conf := `{
+	"cniVersion": "0.3.0",
+	"name": "cni-plugin-sbr-test",
+	"type": "sbr",
+	"table": 1000,
+	"tableOffset": true,
+	"priority": 500,
+	"prevResult": {
+		"cniVersion": "0.3.0",
+		"interfaces": [
+			{
+				"name": "%s",
+				"sandbox": "%s"
+			}
+		],
+		"ips": [
+			{
+				"address": "192.168.1.209/24",
+				"gateway": "192.168.1.1",
+				"interface": 0
+			}
+		],
+		"routes": []
+	}
+}`
+		conf = fmt.Sprintf(conf, ifname, targetNs.Path())
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+			StdinData:   []byte(conf),
+		}
+
+		err := setup(targetNs, createDefaultStatus())
+		Expect(err).NotTo(HaveOccurred())
+
+		var table int
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			link, err := netlinksafe.LinkByName(ifname)
+			table = 1000 + link.Attrs().Index
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		var rules []netlink.Rule
+		var routes []netlink.Route
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			var err error
+			rules, err = netlinksafe.RuleListFiltered(
+				netlink.FAMILY_ALL, &netlink.Rule{
+					Table: table,
+				},
+				netlink.RT_FILTER_TABLE,
+			)
+			if err != nil {
+				return err
+			}
+			routes, err = netlinksafe.RouteListFiltered(netlink.FAMILY_V4,
+				&netlink.Route{Table: table},
+				netlink.RT_FILTER_TABLE)
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(HaveLen(1))
+		Expect(rules[0].Priority).To(Equal(500))
+		Expect(rules[0].Src.String()).To(Equal("192.168.1.209/32"))
+		// The routes of the interface have been moved to the table.
+		Expect(routes).NotTo(BeEmpty())
+
+		err = testutils.CmdDel(targetNs.Path(), args.ContainerID, "", func() error { return cmdDel(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			var err error
+			rules, err = netlinksafe.RuleListFiltered(
+				netlink.FAMILY_ALL, &netlink.Rule{
+					Table: table,
+				},
+				netlink.RT_FILTER_TABLE,
+			)
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(BeEmpty())
+	})
+
+	It("Works with selected interfaces", func() {
+		conf := `{
+	"cniVersion": "1.0.0",
+	"name": "cni-plugin-sbr-test",
+	"type": "sbr",
+	"interfaces": [1],
+	"prevResult": {
+		"cniVersion": "1.0.0",
+		"interfaces": [
+			{
+				"name": "eth0",
+				"sandbox": "%[1]s"
+			},
+			{
+				"name": "net1",
+				"sandbox": "%[1]s"
+			}
+		],
+		"ips": [
+			{
+				"address": "10.0.0.2/24",
+				"gateway": "10.0.0.1",
+				"interface": 0
+			},
+			{
+				"address": "192.168.1.209/24",
+				"gateway": "192.168.1.1",
+				"interface": 1
+			}
+		],
+		"routes": []
+	}
+}`
+		conf = fmt.Sprintf(conf, targetNs.Path())
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      "eth0",
+			StdinData:   []byte(conf),
+		}
+
+		err := setup(targetNs, createDefaultStatus())
+		Expect(err).NotTo(HaveOccurred())
+
+		oldStatus, err := readback(targetNs, []string{"net1", "eth0"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		newStatus, err := readback(targetNs, []string{"net1", "eth0"})
+		Expect(err).NotTo(HaveOccurred())
+
+		// Only net1 has been moved to its own table; eth0, although it is
+		// CNI_IFNAME, stays on the main table.
+		Expect(newStatus.Rules).To(HaveLen(1))
+		Expect(newStatus.Rules[0].Table).To(Equal(100))
+		Expect(newStatus.Rules[0].Src.String()).To(Equal("192.168.1.209/32"))
+		for _, route := range newStatus.Devices[0].Routes {
+			if route.Table != 255 {
+				Expect(route.Table).To(Equal(100))
+			}
+		}
+		Expect(equalRoutes(oldStatus.Devices[1].Routes, newStatus.Devices[1].Routes)).To(BeTrue())
+
+		err = testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		retVal, err := readback(targetNs, []string{"net1", "eth0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retVal.Rules).To(BeEmpty())
+	})
+
+	It("parses interface names and indices", func() {
+		conf, err := parseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test", "type": "sbr", "interfaces": ["net1", 2]}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.Interfaces).To(HaveLen(2))
+		Expect(conf.Interfaces[0].Name).To(Equal("net1"))
+		Expect(*conf.Interfaces[1].Index).To(Equal(2))
+
+		_, err = parseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test", "type": "sbr", "interfaces": [true]}`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires a table for the table offset", func() {
+		_, err := parseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test", "type": "sbr", "tableOffset": true}`))
+		Expect(err).To(MatchError("tableOffset requires table to be set"))
+	})
+
+	It("Works with a firewall mark", func() {
+		ifname := "net1"
+		conf := `{
+	"cniVersion": "1.0.0",
+	"name": "cni-plugin-sbr-test",
+	"type": "sbr",
+	"fwmark": 16,
+	"fwmarkMask": 255,
+	"dataDir": "%s",
+	"prevResult": {
+		"cniVersion": "1.0.0",
+		"interfaces": [
+			{
+				"name": "%s",
+				"sandbox": "%s"
+			}
+		],
+		"ips": [
+			{
+				"address": "192.168.1.209/24",
+				"gateway": "192.168.1.1",
+				"interface": 0
+			}
+		],
+		"routes": []
+	}
+}`
+		conf = fmt.Sprintf(conf, GinkgoT().TempDir(), ifname, targetNs.Path())
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+			StdinData:   []byte(conf),
+		}
+
+		err := setup(targetNs, createDefaultStatus())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		newStatus, err := readback(targetNs, []string{"net1", "eth0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(newStatus.Rules).To(HaveLen(1))
+		Expect(newStatus.Rules[0].Table).To(Equal(100))
+		Expect(newStatus.Rules[0].Src).To(BeNil())
+		Expect(newStatus.Rules[0].Mark).To(Equal(uint32(16)))
+		Expect(*newStatus.Rules[0].Mask).To(Equal(uint32(255)))
+
+		err = testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		// CHECK notices the rule going away
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			return netlink.RuleDel(&newStatus.Rules[0])
+		})
+		Expect(err).NotTo(HaveOccurred())
+		err = testutils.CmdCheckWithArgs(args, func() error { return cmdCheck(args) })
+		Expect(err).To(MatchError(ContainSubstring("not found")))
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			return netlink.RuleAdd(&newStatus.Rules[0])
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// The rule has no source to find it by
+		err = testutils.CmdDelWithArgs(args, func() error { return cmdDel(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		retVal, err := readback(targetNs, []string{"net1", "eth0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retVal.Rules).To(BeEmpty())
+	})
+
+	It("garbage collects the rules of stale attachments", func() {
+		dataDir := GinkgoT().TempDir()
+		ifname := "net1"
+		conf := `{
+	"cniVersion": "1.1.0",
+	"name": "cni-plugin-sbr-test",
+	"type": "sbr",
+	"dataDir": "%s",
+	"prevResult": {
+		"cniVersion": "1.1.0",
+		"interfaces": [
+			{
+				"name": "%s",
+				"sandbox": "%s"
+			}
+		],
+		"ips": [
+			{
+				"address": "192.168.1.209/24",
+				"gateway": "192.168.1.1",
+				"interface": 0
+			}
+		],
+		"routes": []
+	}
+}`
+		conf = fmt.Sprintf(conf, dataDir, ifname, targetNs.Path())
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      ifname,
+			StdinData:   []byte(conf),
+		}
+
+		err := setup(targetNs, createDefaultStatus())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = testutils.CmdAddWithArgs(args, func() error { return cmdAdd(args) })
+		Expect(err).NotTo(HaveOccurred())
+
+		gcConf := func(valid string) []byte {
+			return []byte(fmt.Sprintf(`{
+	"cniVersion": "1.1.0",
+	"name": "cni-plugin-sbr-test",
+	"type": "sbr",
+	"dataDir": "%s",
+	"cni.dev/valid-attachments": [%s]
+}`, dataDir, valid))
+		}
+
+		// A valid attachment is kept
+		err = cmdGC(&skel.CmdArgs{StdinData: gcConf(`{"containerID": "dummy", "ifname": "net1"}`)})
+		Expect(err).NotTo(HaveOccurred())
+		status, err := readback(targetNs, []string{"net1", "eth0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Rules).To(HaveLen(1))
+
+		err = cmdGC(&skel.CmdArgs{StdinData: gcConf("")})
+		Expect(err).NotTo(HaveOccurred())
+		status, err = readback(targetNs, []string{"net1", "eth0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Rules).To(BeEmpty())
+
+		err = targetNs.Do(func(_ ns.NetNS) error {
+			routes, err := netlinksafe.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: 100}, netlink.RT_FILTER_TABLE)
+			Expect(routes).To(BeEmpty())
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		entries, err := os.ReadDir(dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	DescribeTable("validates the firewall mark",
+		func(conf, expectedErr string) {
+			_, err := parseConfig([]byte(conf))
+			Expect(err).To(MatchError(expectedErr))
+		},
+		Entry("zero", `{"cniVersion": "1.0.0", "name": "test", "type": "sbr", "fwmark": 0}`,
+			"invalid fwmark 0"),
+		Entry("mask alone", `{"cniVersion": "1.0.0", "name": "test", "type": "sbr", "fwmarkMask": 255}`,
+			"fwmarkMask requires fwmark to be set"),
+		Entry("several interfaces", `{"cniVersion": "1.0.0", "name": "test", "type": "sbr", "fwmark": 1, "interfaces": ["net1", "net2"]}`,
+			"fwmark rules can only be set up for a single interface"),
+	)
+
+	It("refuses a firewall mark for several addresses of a family", func() {
+		conf := `{
+	"cniVersion": "1.0.0",
+	"name": "cni-plugin-sbr-test",
+	"type": "sbr",
+	"fwmark": 16,
+	"prevResult": {
+		"cniVersion": "1.0.0",
+		"interfaces": [{"name": "net1"}],
+		"ips": [
+			{"address": "192.168.1.209/24", "interface": 0},
+			{"address": "192.168.101.209/24", "interface": 0}
+		]
+	}
+}`
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNs.Path(),
+			IfName:      "net1",
+			StdinData:   []byte(conf),
+		}
+		err := cmdAdd(args)
+		Expect(err).To(MatchError("fwmark rules need at most one address of each family, got several for 192.168.101.209"))
+	})
 })
@@ -0,0 +1,253 @@
+// Copyright 2025 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// The rules of an attachment are recorded along with its network namespace,
+// as GC is not given the namespace, and rules keyed on a mark cannot be
+// told apart by the addresses of the interface on DEL.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/netlinksafe"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/statestore"
+)
+
+// attachmentRecord is what is recorded of an attachment.
+type attachmentRecord struct {
+	Netns string       `json:"netns"`
+	Rules []ruleRecord `json:"rules"`
+}
+
+// ruleRecord is a rule added for an attachment.
+type ruleRecord struct {
+	Family int     `json:"family"`
+	Src    string  `json:"src,omitempty"`
+	Mark   uint32  `json:"mark,omitempty"`
+	Mask   *uint32 `json:"mask,omitempty"`
+	Table  int     `json:"table"`
+	// Priority is -1 if the kernel picked it.
+	Priority int `json:"priority"`
+	// OwnTable is set if the routes of Table were moved there by the
+	// plugin, rather than managed by someone else.
+	OwnTable bool `json:"ownTable,omitempty"`
+}
+
+func newRuleRecord(rule *netlink.Rule, ownTable bool) ruleRecord {
+	rec := ruleRecord{
+		Family:   rule.Family,
+		Mark:     rule.Mark,
+		Mask:     rule.Mask,
+		Table:    rule.Table,
+		Priority: rule.Priority,
+		OwnTable: ownTable,
+	}
+	if rule.Src != nil {
+		rec.Src = rule.Src.String()
+		rec.Family = netlink.FAMILY_V6
+		if rule.Src.IP.To4() != nil {
+			rec.Family = netlink.FAMILY_V4
+		}
+	}
+	return rec
+}
+
+// rule returns the netlink rule of r, to delete it.
+func (r *ruleRecord) rule() (*netlink.Rule, error) {
+	rule := netlink.NewRule()
+	rule.Family = r.Family
+	rule.Mark = r.Mark
+	rule.Mask = r.Mask
+	rule.Table = r.Table
+	rule.Priority = r.Priority
+	if r.Src != "" {
+		_, src, err := net.ParseCIDR(r.Src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recorded source %q: %v", r.Src, err)
+		}
+		rule.Src = src
+	}
+	return rule, nil
+}
+
+// matches tells whether the rule listed from the kernel is r.
+func (r *ruleRecord) matches(rule *netlink.Rule) bool {
+	if rule.Family != r.Family || rule.Table != r.Table || rule.Mark != r.Mark {
+		return false
+	}
+	if r.Priority >= 0 && rule.Priority != r.Priority {
+		return false
+	}
+	src := ""
+	if rule.Src != nil {
+		src = rule.Src.String()
+	}
+	if src != r.Src {
+		return false
+	}
+	if r.Mark != 0 {
+		// The kernel matches the whole mark without a mask
+		mask := uint32(math.MaxUint32)
+		if r.Mask != nil {
+			mask = *r.Mask
+		}
+		if rule.Mask == nil || *rule.Mask != mask {
+			return false
+		}
+	}
+	return true
+}
+
+func stateStore(conf *PluginConf) *statestore.Store {
+	return statestore.New(conf.DataDir, conf.Name)
+}
+
+func attachmentKey(args *skel.CmdArgs) statestore.Key {
+	return statestore.Key{ContainerID: args.ContainerID, IfName: args.IfName}
+}
+
+// loadRecord returns the record of the attachment of args, or nil if there
+// is none, as for attachments made by previous versions.
+func loadRecord(conf *PluginConf, args *skel.CmdArgs) (*attachmentRecord, error) {
+	rec := &attachmentRecord{}
+	found, err := stateStore(conf).Load(attachmentKey(args), rec)
+	if err != nil || !found {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// deleteRules deletes the recorded rules, and the routes left in the tables
+// the plugin moved them to, in the current network namespace.
+func deleteRules(rules []ruleRecord) error {
+	// Keep on going, but return the last failure.
+	var errReturn error
+	for i := range rules {
+		rule, err := rules[i].rule()
+		if err != nil {
+			errReturn = err
+			continue
+		}
+		log.Printf("Delete rule %v", rule)
+		if err := netlink.RuleDel(rule); err != nil && err != syscall.ENOENT {
+			errReturn = fmt.Errorf("failed to delete rule %v: %v", rule, err)
+		}
+		if !rules[i].OwnTable {
+			continue
+		}
+
+		routes, err := netlinksafe.RouteListFiltered(rules[i].Family, &netlink.Route{Table: rules[i].Table}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			errReturn = fmt.Errorf("failed to list routes of table %d: %v", rules[i].Table, err)
+			continue
+		}
+		for _, route := range routes {
+			if err := netlink.RouteDel(&route); err != nil && err != syscall.ESRCH {
+				errReturn = fmt.Errorf("failed to delete route %v: %v", route, err)
+			}
+		}
+	}
+	return errReturn
+}
+
+// checkRules checks that the recorded rules exist, and that the tables the
+// plugin moved routes to are not empty, in the current network namespace.
+func checkRules(rules []ruleRecord) error {
+	existing, err := netlinksafe.RuleList(netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %v", err)
+	}
+
+	for i := range rules {
+		found := false
+		for j := range existing {
+			if rules[i].matches(&existing[j]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("rule %+v to table %d not found", rules[i], rules[i].Table)
+		}
+		if !rules[i].OwnTable {
+			continue
+		}
+
+		routes, err := netlinksafe.RouteListFiltered(rules[i].Family, &netlink.Route{Table: rules[i].Table}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return fmt.Errorf("failed to list routes of table %d: %v", rules[i].Table, err)
+		}
+		if len(routes) == 0 {
+			return fmt.Errorf("no routes in table %d", rules[i].Table)
+		}
+	}
+	return nil
+}
+
+// checkSourceRules checks, for attachments without a record, that a rule
+// matches the source of each address, to table if set.
+func checkSourceRules(ips []net.IP, table *int) error {
+	existing, err := netlinksafe.RuleList(netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %v", err)
+	}
+
+IP_LOOP:
+	for _, ip := range ips {
+		for _, rule := range existing {
+			if rule.Src != nil && rule.Src.IP.Equal(ip) && (table == nil || rule.Table == *table) {
+				continue IP_LOOP
+			}
+		}
+		return fmt.Errorf("no rule for source %v", ip)
+	}
+	return nil
+}
+
+// gcRules deletes the rules of the attachments on the network that are not
+// valid, in their network namespace if it still exists.
+func gcRules(conf *PluginConf, valid *gc.Attachments) error {
+	return stateStore(conf).GC(valid, func(key statestore.Key, data json.RawMessage) error {
+		rec := &attachmentRecord{}
+		if err := json.Unmarshal(data, rec); err != nil {
+			// Nothing can be released
+			return nil
+		}
+		if _, err := os.Stat(rec.Netns); err != nil {
+			// The rules went with the namespace
+			return nil
+		}
+
+		log.Printf("Cleaning up SBR for stale attachment %s/%s", key.ContainerID, key.IfName)
+		err := withLockAndNetNS(rec.Netns, func(_ ns.NetNS) error {
+			return deleteRules(rec.Rules)
+		})
+		if _, ok := err.(ns.NSPathNotNSErr); ok {
+			return nil
+		}
+		return err
+	})
+}
@@ -23,11 +23,12 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/safchain/ethtool"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 
@@ -35,8 +36,17 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/config"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
+	"github.com/containernetworking/plugins/pkg/gc"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/log"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/statestore"
+	"github.com/containernetworking/plugins/pkg/tc"
+	"github.com/containernetworking/plugins/pkg/trace"
+	"github.com/containernetworking/plugins/pkg/utils"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
 
@@ -44,8 +54,15 @@ const (
 	defaultDataDir       = "/run/cni/tuning"
 	defaultAllowlistDir  = "/etc/cni/tuning/"
 	defaultAllowlistFile = "allowlist.conf"
+
+	// maxAliasLen and maxAltNameLen are IFALIASZ and ALTIFNAMSIZ, less
+	// the terminating NUL.
+	maxAliasLen   = 255
+	maxAltNameLen = 127
 )
 
+var templateVariable = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
 // TuningConf represents the network tuning configuration.
 type TuningConf struct {
 	types.NetConf
@@ -56,6 +73,22 @@ type TuningConf struct {
 	Mtu      int               `json:"mtu,omitempty"`
 	TxQLen   *int              `json:"txQLen,omitempty"`
 	Allmulti *bool             `json:"allmulti,omitempty"`
+	Ethtool  *EthtoolConf      `json:"ethtool,omitempty"`
+	Qdisc    *QdiscConf        `json:"qdisc,omitempty"`
+
+	// TxQueueLen is an alias of TxQLen.
+	TxQueueLen *int `json:"txQueueLen,omitempty"`
+	GSOMaxSize *int `json:"gsoMaxSize,omitempty"`
+	GROMaxSize *int `json:"groMaxSize,omitempty"`
+	// HostPeer also applies txQLen, gsoMaxSize and groMaxSize to the host
+	// end of the container interface, which must be a veth.
+	HostPeer bool `json:"hostPeer,omitempty"`
+	// IfAlias and AltNames are the alias and the alternative names given
+	// to the container interface. They are templates: {containerID},
+	// {network} and {ifName} are substituted, and so is any other {KEY}
+	// with the value of KEY in CNI_ARGS, e.g. {K8S_POD_NAMESPACE}.
+	IfAlias  string   `json:"ifAlias,omitempty"`
+	AltNames []string `json:"altNames,omitempty"`
 
 	RuntimeConfig struct {
 		Mac string `json:"mac,omitempty"`
@@ -66,32 +99,85 @@ type TuningConf struct {
 }
 
 type IPAMArgs struct {
-	SysCtl   *map[string]string `json:"sysctl"`
-	Mac      *string            `json:"mac,omitempty"`
-	Promisc  *bool              `json:"promisc,omitempty"`
-	Mtu      *int               `json:"mtu,omitempty"`
-	Allmulti *bool              `json:"allmulti,omitempty"`
-	TxQLen   *int               `json:"txQLen,omitempty"`
+	SysCtl     *map[string]string `json:"sysctl"`
+	Mac        *string            `json:"mac,omitempty"`
+	Promisc    *bool              `json:"promisc,omitempty"`
+	Mtu        *int               `json:"mtu,omitempty"`
+	Allmulti   *bool              `json:"allmulti,omitempty"`
+	TxQLen     *int               `json:"txQLen,omitempty"`
+	GSOMaxSize *int               `json:"gsoMaxSize,omitempty"`
+	GROMaxSize *int               `json:"groMaxSize,omitempty"`
+}
+
+// EthtoolConf represents the ethtool settings of the container interface.
+// Unset fields are left alone.
+type EthtoolConf struct {
+	RxRing           *uint32         `json:"rxRing,omitempty"`
+	TxRing           *uint32         `json:"txRing,omitempty"`
+	CombinedChannels *uint32         `json:"combinedChannels,omitempty"`
+	Features         map[string]bool `json:"features,omitempty"`
+}
+
+// QdiscConf represents the root qdisc of the container interface. Parameters
+// are only supported for fq and fq_codel; any other type, e.g. noqueue, is
+// installed with its kernel defaults.
+type QdiscConf struct {
+	Type string `json:"type"`
+	// Limit is the queue limit in packets.
+	Limit uint32 `json:"limit,omitempty"`
+	// FlowLimit is the per-flow queue limit in packets (fq).
+	FlowLimit uint32 `json:"flowLimit,omitempty"`
+	// Quantum is in bytes.
+	Quantum uint32 `json:"quantum,omitempty"`
+	// MaxRate is the per-flow pacing rate in bytes per second (fq).
+	MaxRate uint32 `json:"maxRate,omitempty"`
+	// Target and Interval are in microseconds (fq_codel).
+	Target   uint32 `json:"target,omitempty"`
+	Interval uint32 `json:"interval,omitempty"`
+	ECN      *bool  `json:"ecn,omitempty"`
 }
 
 // configToRestore will contain interface attributes that should be restored on cmdDel
 type configToRestore struct {
-	Mac      string `json:"mac,omitempty"`
-	Promisc  *bool  `json:"promisc,omitempty"`
-	Mtu      int    `json:"mtu,omitempty"`
-	Allmulti *bool  `json:"allmulti,omitempty"`
-	TxQLen   *int   `json:"txQLen,omitempty"`
+	Mac      string       `json:"mac,omitempty"`
+	Promisc  *bool        `json:"promisc,omitempty"`
+	Mtu      int          `json:"mtu,omitempty"`
+	Allmulti *bool        `json:"allmulti,omitempty"`
+	TxQLen   *int         `json:"txQLen,omitempty"`
+	Ethtool  *EthtoolConf `json:"ethtool,omitempty"`
+	// Qdisc is set if the root qdisc was replaced and must be deleted to
+	// get the default one back.
+	Qdisc      bool       `json:"qdisc,omitempty"`
+	GSOMaxSize *int       `json:"gsoMaxSize,omitempty"`
+	GROMaxSize *int       `json:"groMaxSize,omitempty"`
+	HostPeer   *linkSizes `json:"hostPeer,omitempty"`
+	IfAlias    *string    `json:"ifAlias,omitempty"`
+	// AltNames are the alternative names that were added, to be removed.
+	AltNames []string `json:"altNames,omitempty"`
+	// SysCtl maps the /proc/sys files that were written to their previous
+	// values.
+	SysCtl map[string]string `json:"sysctl,omitempty"`
+}
+
+// linkSizes are the queue and segmentation offload sizes of a link.
+type linkSizes struct {
+	TxQLen     *int `json:"txQLen,omitempty"`
+	GSOMaxSize *int `json:"gsoMaxSize,omitempty"`
+	GROMaxSize *int `json:"groMaxSize,omitempty"`
 }
 
 // MacEnvArgs represents CNI_ARG
 type MacEnvArgs struct {
 	types.CommonArgs
-	MAC types.UnmarshallableString `json:"mac,omitempty"`
+	MAC          types.UnmarshallableString `json:"mac,omitempty"`
+	StrictConfig types.UnmarshallableBool
 }
 
 func parseConf(data []byte, envArgs string) (*TuningConf, error) {
+	defer trace.Start("parseConfig").End()
+
 	conf := TuningConf{Promisc: false}
-	if err := json.Unmarshal(data, &conf); err != nil {
+	if err := config.Decode(data, envArgs, &conf); err != nil {
 		return nil, fmt.Errorf("failed to load netconf: %v", err)
 	}
 
@@ -144,11 +230,170 @@ func parseConf(data []byte, envArgs string) (*TuningConf, error) {
 		if conf.Args.A.TxQLen != nil {
 			conf.TxQLen = conf.Args.A.TxQLen
 		}
+
+		if conf.Args.A.GSOMaxSize != nil {
+			conf.GSOMaxSize = conf.Args.A.GSOMaxSize
+		}
+
+		if conf.Args.A.GROMaxSize != nil {
+			conf.GROMaxSize = conf.Args.A.GROMaxSize
+		}
+	}
+
+	if conf.TxQueueLen != nil {
+		if conf.TxQLen != nil && *conf.TxQLen != *conf.TxQueueLen {
+			return nil, fmt.Errorf("txQLen and txQueueLen are set to different values")
+		}
+		conf.TxQLen = conf.TxQueueLen
+	}
+
+	if conf.Qdisc != nil {
+		if err := validateQdiscConf(conf.Qdisc); err != nil {
+			return nil, err
+		}
 	}
 
 	return &conf, nil
 }
 
+func validateQdiscConf(conf *QdiscConf) error {
+	switch conf.Type {
+	case "":
+		return fmt.Errorf("qdisc type must be set")
+	case "fq":
+		if conf.Target != 0 || conf.Interval != 0 || conf.ECN != nil {
+			return fmt.Errorf("qdisc fq does not support target, interval or ecn")
+		}
+	case "fq_codel":
+		if conf.FlowLimit != 0 || conf.MaxRate != 0 {
+			return fmt.Errorf("qdisc fq_codel does not support flowLimit or maxRate")
+		}
+	default:
+		if *conf != (QdiscConf{Type: conf.Type}) {
+			return fmt.Errorf("qdisc %s does not support parameters", conf.Type)
+		}
+	}
+	return nil
+}
+
+// parseCNIArgs parses the KEY1=VAL1;KEY2=VAL2 pairs of CNI_ARGS.
+func parseCNIArgs(cniArgs string) map[string]string {
+	parsed := map[string]string{}
+	for _, argPair := range strings.Split(cniArgs, ";") {
+		args := strings.SplitN(argPair, "=", 2)
+		if len(args) > 1 {
+			parsed[args[0]] = args[1]
+		}
+	}
+	return parsed
+}
+
+// expandTemplate substitutes {containerID}, {network} and {ifName} in tmpl,
+// and any other {KEY} with the value of KEY in CNI_ARGS, e.g. {K8S_POD_NAME}.
+func expandTemplate(tmpl string, args *skel.CmdArgs, netName string, cniArgs map[string]string) (string, error) {
+	var err error
+	expanded := templateVariable.ReplaceAllStringFunc(tmpl, func(match string) string {
+		name := match[1 : len(match)-1]
+		switch name {
+		case "containerID":
+			return args.ContainerID
+		case "network":
+			return netName
+		case "ifName":
+			return args.IfName
+		}
+		value, ok := cniArgs[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("template %q: %q is not a CNI_ARGS key", tmpl, name)
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// expandLinkNames expands the templates of the alias and the alternative
+// names of the container interface in tuningConf, and validates them.
+func expandLinkNames(tuningConf *TuningConf, args *skel.CmdArgs) error {
+	cniArgs := parseCNIArgs(args.Args)
+
+	if tuningConf.IfAlias != "" {
+		alias, err := expandTemplate(tuningConf.IfAlias, args, tuningConf.Name, cniArgs)
+		if err != nil {
+			return err
+		}
+		if alias == "" || len(alias) > maxAliasLen {
+			return fmt.Errorf("invalid ifAlias %q, must be 1 to %d bytes", alias, maxAliasLen)
+		}
+		tuningConf.IfAlias = alias
+	}
+
+	altNames := make([]string, 0, len(tuningConf.AltNames))
+	for _, tmpl := range tuningConf.AltNames {
+		altName, err := expandTemplate(tmpl, args, tuningConf.Name, cniArgs)
+		if err != nil {
+			return err
+		}
+		if err := validateAltName(altName); err != nil {
+			return err
+		}
+		altNames = append(altNames, altName)
+	}
+	tuningConf.AltNames = altNames
+	return nil
+}
+
+// validateAltName checks altName as the kernel checks interface names.
+func validateAltName(altName string) error {
+	if altName == "" || len(altName) > maxAltNameLen {
+		return fmt.Errorf("invalid altName %q, must be 1 to %d bytes", altName, maxAltNameLen)
+	}
+	if altName == "." || altName == ".." || strings.ContainsAny(altName, "/: \t\n\v\f\r") {
+		return fmt.Errorf("invalid altName %q", altName)
+	}
+	return nil
+}
+
+func changeAlias(ifName string, alias string) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
+	}
+	return netlink.LinkSetAlias(link, alias)
+}
+
+// addAltNames adds the alternative names the interface does not have yet.
+func addAltNames(ifName string, altNames []string) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
+	}
+	for _, altName := range altNames {
+		if slices.Contains(link.Attrs().AltNames, altName) {
+			continue
+		}
+		if err := netlink.LinkAddAltName(link, altName); err != nil {
+			return fmt.Errorf("failed to add altName %q to %q: %v", altName, ifName, err)
+		}
+	}
+	return nil
+}
+
+func delAltNames(ifName string, altNames []string) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
+	}
+	for _, altName := range altNames {
+		if !slices.Contains(link.Attrs().AltNames, altName) {
+			continue
+		}
+		if err := netlink.LinkDelAltName(link, altName); err != nil {
+			return fmt.Errorf("failed to delete altName %q of %q: %v", altName, ifName, err)
+		}
+	}
+	return nil
+}
+
 func changeMacAddr(ifName string, newMacAddr string) error {
 	addr, err := net.ParseMAC(newMacAddr)
 	if err != nil {
@@ -157,7 +402,7 @@ func changeMacAddr(ifName string, newMacAddr string) error {
 
 	link, err := netlinksafe.LinkByName(ifName)
 	if err != nil {
-		return fmt.Errorf("failed to get %q: %v", ifName, err)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
 	}
 
 	return netlink.LinkSetHardwareAddr(link, addr)
@@ -180,10 +425,25 @@ func updateResultsMacAddr(config *TuningConf, ifName string, newMacAddr string)
 	config.PrevResult = result
 }
 
+// updateResultsMtu records the new MTU of the container interface in the
+// result, so that plugins later in the chain pick it up.
+func updateResultsMtu(config *TuningConf, ifName string, mtu int) {
+	// Parse previous result.
+	if config.PrevResult == nil {
+		return
+	}
+
+	version.ParsePrevResult(&config.NetConf)
+	result, _ := current.NewResultFromResult(config.PrevResult)
+
+	utils.SetResultMTU(result, ifName, true, mtu)
+	config.PrevResult = result
+}
+
 func changePromisc(ifName string, val bool) error {
 	link, err := netlinksafe.LinkByName(ifName)
 	if err != nil {
-		return fmt.Errorf("failed to get %q: %v", ifName, err)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
 	}
 
 	if val {
@@ -195,7 +455,7 @@ func changePromisc(ifName string, val bool) error {
 func changeMtu(ifName string, mtu int) error {
 	link, err := netlinksafe.LinkByName(ifName)
 	if err != nil {
-		return fmt.Errorf("failed to get %q: %v", ifName, err)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
 	}
 	return netlink.LinkSetMTU(link, mtu)
 }
@@ -203,7 +463,7 @@ func changeMtu(ifName string, mtu int) error {
 func changeAllmulti(ifName string, val bool) error {
 	link, err := netlinksafe.LinkByName(ifName)
 	if err != nil {
-		return fmt.Errorf("failed to get %q: %v", ifName, err)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
 	}
 
 	if val {
@@ -215,16 +475,277 @@ func changeAllmulti(ifName string, val bool) error {
 func changeTxQLen(ifName string, txQLen int) error {
 	link, err := netlinksafe.LinkByName(ifName)
 	if err != nil {
-		return fmt.Errorf("failed to get %q: %v", ifName, err)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
 	}
 	return netlink.LinkSetTxQLen(link, txQLen)
 }
 
-func createBackup(ifName, containerID, backupPath string, tuningConf *TuningConf) error {
+func changeQdisc(ifName string, conf *QdiscConf) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
+	}
+
+	qdisc := &tc.Qdisc{
+		Type:      conf.Type,
+		Handle:    netlink.MakeHandle(1, 0),
+		Limit:     conf.Limit,
+		FlowLimit: conf.FlowLimit,
+		Quantum:   conf.Quantum,
+		MaxRate:   conf.MaxRate,
+		Target:    conf.Target,
+		Interval:  conf.Interval,
+		ECN:       conf.ECN,
+	}
+	if err = netlink.QdiscReplace(qdisc.Netlink(link.Attrs().Index)); err != nil {
+		return fmt.Errorf("failed to set qdisc %s on %q: %v", conf.Type, ifName, err)
+	}
+	return nil
+}
+
+// resetQdisc deletes the root qdisc of the interface, letting the kernel
+// attach its default one.
+func resetQdisc(ifName string) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
+	}
+	return tc.ResetRootQdisc(link)
+}
+
+// getEthtool returns the current value of each setting configured in conf.
+func getEthtool(ifName string, conf *EthtoolConf) (*EthtoolConf, error) {
+	e, err := ethtool.NewEthtool()
+	if err != nil {
+		return nil, cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to initialize ethtool")
+	}
+	defer e.Close()
+
+	current := &EthtoolConf{}
+	if conf.RxRing != nil || conf.TxRing != nil {
+		ring, err := e.GetRing(ifName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ring sizes of %q: %v", ifName, err)
+		}
+		if conf.RxRing != nil {
+			current.RxRing = &ring.RxPending
+		}
+		if conf.TxRing != nil {
+			current.TxRing = &ring.TxPending
+		}
+	}
+	if conf.CombinedChannels != nil {
+		channels, err := e.GetChannels(ifName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get channels of %q: %v", ifName, err)
+		}
+		current.CombinedChannels = &channels.CombinedCount
+	}
+	if len(conf.Features) > 0 {
+		features, err := e.Features(ifName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get features of %q: %v", ifName, err)
+		}
+		current.Features = map[string]bool{}
+		for name := range conf.Features {
+			value, ok := features[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported feature %q on %q", name, ifName)
+			}
+			current.Features[name] = value
+		}
+	}
+	return current, nil
+}
+
+func changeEthtool(ifName string, conf *EthtoolConf) error {
+	e, err := ethtool.NewEthtool()
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrBackendUnavailable, err, "failed to initialize ethtool")
+	}
+	defer e.Close()
+
+	if conf.RxRing != nil || conf.TxRing != nil {
+		ring, err := e.GetRing(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to get ring sizes of %q: %v", ifName, err)
+		}
+		if conf.RxRing != nil {
+			ring.RxPending = *conf.RxRing
+		}
+		if conf.TxRing != nil {
+			ring.TxPending = *conf.TxRing
+		}
+		if _, err = e.SetRing(ifName, ring); err != nil {
+			return fmt.Errorf("failed to set ring sizes of %q: %v", ifName, err)
+		}
+	}
+	if conf.CombinedChannels != nil {
+		channels, err := e.GetChannels(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to get channels of %q: %v", ifName, err)
+		}
+		channels.CombinedCount = *conf.CombinedChannels
+		if _, err = e.SetChannels(ifName, channels); err != nil {
+			return fmt.Errorf("failed to set channels of %q: %v", ifName, err)
+		}
+	}
+	if len(conf.Features) > 0 {
+		if err = e.Change(ifName, conf.Features); err != nil {
+			return fmt.Errorf("failed to change features of %q: %v", ifName, err)
+		}
+	}
+	return nil
+}
+
+// checkEthtool verifies that the settings configured in conf are in effect.
+func checkEthtool(ifName string, conf *EthtoolConf) error {
+	current, err := getEthtool(ifName, conf)
+	if err != nil {
+		return err
+	}
+	if conf.RxRing != nil && *conf.RxRing != *current.RxRing {
+		return fmt.Errorf("Error: Tuning configured rx ring size of %s is %d, current value is %d",
+			ifName, *conf.RxRing, *current.RxRing)
+	}
+	if conf.TxRing != nil && *conf.TxRing != *current.TxRing {
+		return fmt.Errorf("Error: Tuning configured tx ring size of %s is %d, current value is %d",
+			ifName, *conf.TxRing, *current.TxRing)
+	}
+	if conf.CombinedChannels != nil && *conf.CombinedChannels != *current.CombinedChannels {
+		return fmt.Errorf("Error: Tuning configured combined channels of %s is %d, current value is %d",
+			ifName, *conf.CombinedChannels, *current.CombinedChannels)
+	}
+	for name, value := range conf.Features {
+		if current.Features[name] != value {
+			return fmt.Errorf("Error: Tuning configured feature %s of %s is %v, current value is %v",
+				name, ifName, value, current.Features[name])
+		}
+	}
+	return nil
+}
+
+func changeGSOMaxSize(ifName string, size int) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
+	}
+	return netlink.LinkSetGSOMaxSize(link, size)
+}
+
+func changeGROMaxSize(ifName string, size int) error {
+	link, err := netlinksafe.LinkByName(ifName)
+	if err != nil {
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
+	}
+	return netlink.LinkSetGROMaxSize(link, size)
+}
+
+// hostPeerSizes returns the sizes configured in tuningConf.
+func hostPeerSizes(tuningConf *TuningConf) linkSizes {
+	return linkSizes{
+		TxQLen:     tuningConf.TxQLen,
+		GSOMaxSize: tuningConf.GSOMaxSize,
+		GROMaxSize: tuningConf.GROMaxSize,
+	}
+}
+
+// withHostPeer runs toRun in hostNS on the host end of the veth ifName,
+// which must be in the current network namespace.
+func withHostPeer(hostNS ns.NetNS, ifName string, toRun func(peer netlink.Link) error) error {
+	_, peerIndex, err := ip.GetVethPeerIfindex(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to find the host peer of %q: %v", ifName, err)
+	}
+	return hostNS.Do(func(ns.NetNS) error {
+		peer, err := netlink.LinkByIndex(peerIndex)
+		if err != nil {
+			return fmt.Errorf("veth peer with index %d of %q is not in host ns: %v", peerIndex, ifName, err)
+		}
+		return toRun(peer)
+	})
+}
+
+// getLinkSizes returns the current value of each size set in sizes.
+func getLinkSizes(link netlink.Link, sizes linkSizes) *linkSizes {
+	current := &linkSizes{}
+	if sizes.TxQLen != nil {
+		qlen := link.Attrs().TxQLen
+		current.TxQLen = &qlen
+	}
+	if sizes.GSOMaxSize != nil {
+		size := int(link.Attrs().GSOMaxSize)
+		current.GSOMaxSize = &size
+	}
+	if sizes.GROMaxSize != nil {
+		size := int(link.Attrs().GROMaxSize)
+		current.GROMaxSize = &size
+	}
+	return current
+}
+
+func setLinkSizes(link netlink.Link, sizes linkSizes) error {
+	name := link.Attrs().Name
+	if sizes.TxQLen != nil {
+		if err := netlink.LinkSetTxQLen(link, *sizes.TxQLen); err != nil {
+			return fmt.Errorf("failed to set transmit queue length of %q: %v", name, err)
+		}
+	}
+	if sizes.GSOMaxSize != nil {
+		if err := netlink.LinkSetGSOMaxSize(link, *sizes.GSOMaxSize); err != nil {
+			return fmt.Errorf("failed to set GSO max size of %q: %v", name, err)
+		}
+	}
+	if sizes.GROMaxSize != nil {
+		if err := netlink.LinkSetGROMaxSize(link, *sizes.GROMaxSize); err != nil {
+			return fmt.Errorf("failed to set GRO max size of %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func checkLinkSizes(link netlink.Link, sizes linkSizes) error {
+	current := getLinkSizes(link, sizes)
+	name := link.Attrs().Name
+	if sizes.TxQLen != nil && *sizes.TxQLen != *current.TxQLen {
+		return fmt.Errorf("Error: Tuning configured Transmit Queue Length of %s is %d, current value is %d",
+			name, *sizes.TxQLen, *current.TxQLen)
+	}
+	if sizes.GSOMaxSize != nil && *sizes.GSOMaxSize != *current.GSOMaxSize {
+		return fmt.Errorf("Error: Tuning configured GSO max size of %s is %d, current value is %d",
+			name, *sizes.GSOMaxSize, *current.GSOMaxSize)
+	}
+	if sizes.GROMaxSize != nil && *sizes.GROMaxSize != *current.GROMaxSize {
+		return fmt.Errorf("Error: Tuning configured GRO max size of %s is %d, current value is %d",
+			name, *sizes.GROMaxSize, *current.GROMaxSize)
+	}
+	return nil
+}
+
+// backupStore returns the store of the backups of the attachments on the
+// network. Backups are named <containerID>_<ifName>.json, as before the store.
+func backupStore(tuningConf *TuningConf) *statestore.Store {
+	return statestore.New(tuningConf.DataDir, tuningConf.Name)
+}
+
+// createBackup saves the current value of every attribute tuningConf
+// changes. sysctls maps the /proc/sys files that are about to be written to
+// their new values.
+func createBackup(hostNS ns.NetNS, ifName, containerID string, tuningConf *TuningConf, sysctls map[string]string) error {
 	config := configToRestore{}
 	link, err := netlinksafe.LinkByName(ifName)
 	if err != nil {
-		return fmt.Errorf("failed to get %q: %v", ifName, err)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "failed to get %q", ifName)
+	}
+	if len(sysctls) > 0 {
+		config.SysCtl = map[string]string{}
+		for fileName := range sysctls {
+			contents, err := os.ReadFile(fileName)
+			if err != nil {
+				return fmt.Errorf("failed to read sysctl %s: %v", fileName, err)
+			}
+			config.SysCtl[fileName] = strings.TrimSuffix(string(contents), "\n")
+		}
 	}
 	if tuningConf.Mac != "" {
 		config.Mac = link.Attrs().HardwareAddr.String()
@@ -244,39 +765,52 @@ func createBackup(ifName, containerID, backupPath string, tuningConf *TuningConf
 		qlen := link.Attrs().TxQLen
 		config.TxQLen = &qlen
 	}
-
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-		if err = os.MkdirAll(backupPath, 0o600); err != nil {
-			return fmt.Errorf("failed to create backup directory: %v", err)
+	if tuningConf.Ethtool != nil {
+		if config.Ethtool, err = getEthtool(ifName, tuningConf.Ethtool); err != nil {
+			return err
 		}
 	}
-
-	data, err := json.MarshalIndent(config, "", " ")
-	if err != nil {
-		return fmt.Errorf("failed to marshall data for %q: %v", ifName, err)
+	if tuningConf.Qdisc != nil {
+		config.Qdisc = true
+	}
+	if tuningConf.GSOMaxSize != nil {
+		size := int(link.Attrs().GSOMaxSize)
+		config.GSOMaxSize = &size
+	}
+	if tuningConf.GROMaxSize != nil {
+		size := int(link.Attrs().GROMaxSize)
+		config.GROMaxSize = &size
+	}
+	if tuningConf.IfAlias != "" {
+		alias := link.Attrs().Alias
+		config.IfAlias = &alias
+	}
+	for _, altName := range tuningConf.AltNames {
+		if !slices.Contains(link.Attrs().AltNames, altName) {
+			config.AltNames = append(config.AltNames, altName)
+		}
 	}
-	if err = os.WriteFile(path.Join(backupPath, containerID+"_"+ifName+".json"), data, 0o600); err != nil {
-		return fmt.Errorf("failed to save file %s.json: %v", ifName, err)
+	if tuningConf.HostPeer {
+		err = withHostPeer(hostNS, ifName, func(peer netlink.Link) error {
+			config.HostPeer = getLinkSizes(peer, hostPeerSizes(tuningConf))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return backupStore(tuningConf).Save(statestore.Key{ContainerID: containerID, IfName: ifName}, &config)
 }
 
-func restoreBackup(ifName, containerID, backupPath string) error {
-	filePath := path.Join(backupPath, containerID+"_"+ifName+".json")
-
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		// No backup file - nothing to revert
-		return nil
-	}
-
-	file, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file %q: %v", filePath, err)
-	}
+func restoreBackup(hostNS ns.NetNS, ifName, containerID string, tuningConf *TuningConf) error {
+	store := backupStore(tuningConf)
+	key := statestore.Key{ContainerID: containerID, IfName: ifName}
 
 	config := configToRestore{}
-	if err = json.Unmarshal(file, &config); err != nil {
+	found, err := store.Load(key, &config)
+	if err != nil || !found {
+		// No usable backup - nothing to revert
 		return nil
 	}
 
@@ -319,15 +853,76 @@ func restoreBackup(ifName, containerID, backupPath string) error {
 		}
 	}
 
-	if len(errStr) > 0 {
-		return errors.New(strings.Join(errStr, "; "))
+	if config.Ethtool != nil {
+		if err = changeEthtool(ifName, config.Ethtool); err != nil {
+			err = fmt.Errorf("failed to restore ethtool settings: %v", err)
+			errStr = append(errStr, err.Error())
+		}
 	}
 
-	if err = os.Remove(filePath); err != nil {
-		return fmt.Errorf("failed to remove file %v: %v", filePath, err)
+	if config.Qdisc {
+		if err = resetQdisc(ifName); err != nil {
+			err = fmt.Errorf("failed to restore qdisc: %v", err)
+			errStr = append(errStr, err.Error())
+		}
 	}
 
-	return nil
+	if config.GSOMaxSize != nil {
+		if err = changeGSOMaxSize(ifName, *config.GSOMaxSize); err != nil {
+			err = fmt.Errorf("failed to restore GSO max size: %v", err)
+			errStr = append(errStr, err.Error())
+		}
+	}
+
+	if config.GROMaxSize != nil {
+		if err = changeGROMaxSize(ifName, *config.GROMaxSize); err != nil {
+			err = fmt.Errorf("failed to restore GRO max size: %v", err)
+			errStr = append(errStr, err.Error())
+		}
+	}
+
+	if config.IfAlias != nil {
+		if err = changeAlias(ifName, *config.IfAlias); err != nil {
+			err = fmt.Errorf("failed to restore alias: %v", err)
+			errStr = append(errStr, err.Error())
+		}
+	}
+
+	if len(config.AltNames) > 0 {
+		if err = delAltNames(ifName, config.AltNames); err != nil {
+			err = fmt.Errorf("failed to restore altNames: %v", err)
+			errStr = append(errStr, err.Error())
+		}
+	}
+
+	if config.HostPeer != nil {
+		err = withHostPeer(hostNS, ifName, func(peer netlink.Link) error {
+			return setLinkSizes(peer, *config.HostPeer)
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to restore host peer: %v", err)
+			errStr = append(errStr, err.Error())
+		}
+	}
+
+	for fileName, value := range config.SysCtl {
+		// The backup file is only written by ADD, but never let it
+		// point anywhere else.
+		if !strings.HasPrefix(filepath.Clean(fileName), "/proc/sys/net/") {
+			errStr = append(errStr, fmt.Sprintf("refusing to restore sysctl %s", fileName))
+			continue
+		}
+		if err = os.WriteFile(fileName, []byte(value), 0o644); err != nil {
+			err = fmt.Errorf("failed to restore sysctl %s: %v", fileName, err)
+			errStr = append(errStr, err.Error())
+		}
+	}
+
+	if len(errStr) > 0 {
+		return errors.New(strings.Join(errStr, "; "))
+	}
+
+	return store.Delete(key)
 }
 
 func cmdAdd(args *skel.CmdArgs) error {
@@ -347,6 +942,10 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
+	if err = expandLinkNames(tuningConf, args); err != nil {
+		return err
+	}
+
 	// Parse previous result.
 	if tuningConf.RawPrevResult == nil {
 		return fmt.Errorf("Required prevResult missing")
@@ -359,24 +958,37 @@ func cmdAdd(args *skel.CmdArgs) error {
 	// The directory /proc/sys/net is per network namespace. Enter in the
 	// network namespace before writing on it.
 
-	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
-		for key, value := range tuningConf.SysCtl {
-			fileName, err := getSysctlFilename(key, args.IfName)
-			if err != nil {
+	sysctls := map[string]string{}
+	for key, value := range tuningConf.SysCtl {
+		fileName, err := getSysctlFilename(key, args.IfName, args.Netns)
+		if err != nil {
+			return err
+		}
+		sysctls[fileName] = value
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(hostNS ns.NetNS) error {
+		if len(sysctls) > 0 || tuningConf.Mac != "" || tuningConf.Mtu != 0 || tuningConf.Promisc || tuningConf.Allmulti != nil || tuningConf.TxQLen != nil ||
+			tuningConf.Ethtool != nil || tuningConf.Qdisc != nil || tuningConf.GSOMaxSize != nil || tuningConf.GROMaxSize != nil ||
+			tuningConf.IfAlias != "" || len(tuningConf.AltNames) > 0 {
+			if err = createBackup(hostNS, args.IfName, args.ContainerID, tuningConf, sysctls); err != nil {
 				return err
 			}
+		}
 
+		for fileName, value := range sysctls {
 			content := []byte(value)
 			err = os.WriteFile(fileName, content, 0o644)
 			if err != nil {
 				return err
 			}
-		}
-
-		if tuningConf.Mac != "" || tuningConf.Mtu != 0 || tuningConf.Promisc || tuningConf.Allmulti != nil || tuningConf.TxQLen != nil {
-			if err = createBackup(args.IfName, args.ContainerID, tuningConf.DataDir, tuningConf); err != nil {
-				return err
-			}
+			log.Debug("set sysctl", "file", fileName, "value", value)
 		}
 
 		if tuningConf.Mac != "" {
@@ -397,6 +1009,8 @@ func cmdAdd(args *skel.CmdArgs) error {
 			if err = changeMtu(args.IfName, tuningConf.Mtu); err != nil {
 				return err
 			}
+
+			updateResultsMtu(tuningConf, args.IfName, tuningConf.Mtu)
 		}
 
 		if tuningConf.Allmulti != nil {
@@ -410,6 +1024,51 @@ func cmdAdd(args *skel.CmdArgs) error {
 				return err
 			}
 		}
+
+		if tuningConf.Ethtool != nil {
+			if err = changeEthtool(args.IfName, tuningConf.Ethtool); err != nil {
+				return err
+			}
+		}
+
+		if tuningConf.Qdisc != nil {
+			if err = changeQdisc(args.IfName, tuningConf.Qdisc); err != nil {
+				return err
+			}
+		}
+
+		if tuningConf.GSOMaxSize != nil {
+			if err = changeGSOMaxSize(args.IfName, *tuningConf.GSOMaxSize); err != nil {
+				return err
+			}
+		}
+
+		if tuningConf.GROMaxSize != nil {
+			if err = changeGROMaxSize(args.IfName, *tuningConf.GROMaxSize); err != nil {
+				return err
+			}
+		}
+
+		if tuningConf.IfAlias != "" {
+			if err = changeAlias(args.IfName, tuningConf.IfAlias); err != nil {
+				return err
+			}
+		}
+
+		if len(tuningConf.AltNames) > 0 {
+			if err = addAltNames(args.IfName, tuningConf.AltNames); err != nil {
+				return err
+			}
+		}
+
+		if tuningConf.HostPeer {
+			err = withHostPeer(hostNS, args.IfName, func(peer netlink.Link) error {
+				return setLinkSizes(peer, hostPeerSizes(tuningConf))
+			})
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -426,21 +1085,34 @@ func cmdDel(args *skel.CmdArgs) error {
 		return err
 	}
 
-	ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
-		// MAC address, MTU, promiscuous and all-multicast mode settings will be restored
-		return restoreBackup(args.IfName, args.ContainerID, tuningConf.DataDir)
+	ns.WithNetNSPath(args.Netns, func(hostNS ns.NetNS) error {
+		// Every attribute changed by ADD, sysctls included, will be restored
+		return restoreBackup(hostNS, args.IfName, args.ContainerID, tuningConf)
 	})
 	return nil
 }
 
+// cmdGC removes the backups of the attachments on this network that are no
+// longer valid, as their DEL was lost. Their interfaces are gone, so there
+// is nothing left to restore. Backups made by previous versions do not
+// record their attachment, and are left alone.
+func cmdGC(args *skel.CmdArgs) error {
+	tuningConf, err := parseConf(args.StdinData, args.Args)
+	if err != nil {
+		return err
+	}
+
+	return backupStore(tuningConf).GC(gc.NewAttachments(tuningConf.ValidAttachments), nil)
+}
+
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{
+	skel.PluginMainFuncs(log.Wrap("tuning", trace.Wrap("tuning", skel.CNIFuncs{
 		Add:   cmdAdd,
 		Check: cmdCheck,
 		Del:   cmdDel,
-		/* FIXME GC */
+		GC:    cmdGC,
 		/* FIXME Status */
-	}, version.All, bv.BuildString("tuning"))
+	})), version.All, bv.BuildString("tuning"))
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
@@ -466,10 +1138,20 @@ func cmdCheck(args *skel.CmdArgs) error {
 		return err
 	}
 
-	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+	if err = expandLinkNames(tuningConf, args); err != nil {
+		return err
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(hostNS ns.NetNS) error {
 		// Check each configured value vs what's currently in the container
 		for key, confValue := range tuningConf.SysCtl {
-			fileName, err := getSysctlFilename(key, args.IfName)
+			fileName, err := getSysctlFilename(key, args.IfName, args.Netns)
 			if err != nil {
 				return err
 			}
@@ -486,7 +1168,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 
 		link, err := netlinksafe.LinkByName(args.IfName)
 		if err != nil {
-			return fmt.Errorf("Cannot find container link %v", args.IfName)
+			return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "Cannot find container link %v", args.IfName)
 		}
 
 		if tuningConf.Mac != "" {
@@ -529,6 +1211,48 @@ func cmdCheck(args *skel.CmdArgs) error {
 					args.IfName, tuningConf.TxQLen, link.Attrs().TxQLen)
 			}
 		}
+
+		if tuningConf.Ethtool != nil {
+			if err = checkEthtool(args.IfName, tuningConf.Ethtool); err != nil {
+				return err
+			}
+		}
+
+		if tuningConf.Qdisc != nil {
+			qdisc, err := tc.RootQdisc(link)
+			if err != nil {
+				return err
+			}
+			if qdisc == nil || qdisc.Type() != tuningConf.Qdisc.Type {
+				return fmt.Errorf("Error: Tuning configured qdisc of %s is %s, current value is %v",
+					args.IfName, tuningConf.Qdisc.Type, qdisc)
+			}
+		}
+
+		if tuningConf.IfAlias != "" && tuningConf.IfAlias != link.Attrs().Alias {
+			return fmt.Errorf("Error: Tuning configured alias of %s is %q, current value is %q",
+				args.IfName, tuningConf.IfAlias, link.Attrs().Alias)
+		}
+
+		for _, altName := range tuningConf.AltNames {
+			if !slices.Contains(link.Attrs().AltNames, altName) {
+				return fmt.Errorf("Error: Tuning configured altName %s of %s is missing, current values are %v",
+					altName, args.IfName, link.Attrs().AltNames)
+			}
+		}
+
+		if err = checkLinkSizes(link, linkSizes{GSOMaxSize: tuningConf.GSOMaxSize, GROMaxSize: tuningConf.GROMaxSize}); err != nil {
+			return err
+		}
+
+		if tuningConf.HostPeer {
+			err = withHostPeer(hostNS, args.IfName, func(peer netlink.Link) error {
+				return checkLinkSizes(peer, hostPeerSizes(tuningConf))
+			})
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -624,7 +1348,7 @@ func validateArgs(args *skel.CmdArgs) error {
 	return nil
 }
 
-func getSysctlFilename(key, ifName string) (string, error) {
+func getSysctlFilename(key, ifName, netns string) (string, error) {
 	key = strings.ReplaceAll(key, ".", string(os.PathSeparator))
 
 	// If the key contains `IFNAME` - substitute it with args.IfName
@@ -632,6 +1356,14 @@ func getSysctlFilename(key, ifName string) (string, error) {
 	// other operations (like mac/mtu setting) are performed
 	key = strings.Replace(key, "IFNAME", ifName, 1)
 
+	// The {ifname} and {netns} placeholders are substituted everywhere,
+	// after the dots have been replaced, so that an interface name such
+	// as eth0.100 is kept whole.
+	key = strings.NewReplacer(
+		"{ifname}", ifName,
+		"{netns}", filepath.Base(netns),
+	).Replace(key)
+
 	fileName := filepath.Join("/proc/sys", key)
 
 	// Refuse to modify sysctl parameters that don't belong
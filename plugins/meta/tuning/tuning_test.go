@@ -20,9 +20,11 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/safchain/ethtool"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 
@@ -31,6 +33,8 @@ import (
 	types100 "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/statestore"
+	"github.com/containernetworking/plugins/pkg/tc"
 	"github.com/containernetworking/plugins/pkg/testutils"
 )
 
@@ -389,6 +393,7 @@ var _ = Describe("tuning plugin", func() {
 
 				Expect(result.Interfaces).To(HaveLen(1))
 				Expect(result.Interfaces[0].Name).To(Equal(IFNAME))
+				Expect(result.Interfaces[0].Mtu).To(Equal(1454))
 				Expect(result.IPs).To(HaveLen(1))
 				Expect(result.IPs[0].Address.String()).To(Equal("10.0.0.2/24"))
 
@@ -557,6 +562,236 @@ var _ = Describe("tuning plugin", func() {
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		It(fmt.Sprintf("[%s] configures and deconfigures the alias and altnames with ADD/DEL", ver), func() {
+			conf := []byte(fmt.Sprintf(`{
+				"name": "test",
+				"type": "iplink",
+				"cniVersion": "%s",
+				"ifAlias": "{K8S_POD_NAMESPACE}/{K8S_POD_NAME}",
+				"altNames": ["{K8S_POD_NAME}-{ifName}"],
+				"prevResult": {
+					"interfaces": [
+						{"name": "dummy0", "sandbox":"netns"}
+					],
+					"ips": [
+						{
+							"version": "4",
+							"address": "10.0.0.2/24",
+							"gateway": "10.0.0.1",
+							"interface": 0
+						}
+					]
+				}
+			}`, ver))
+
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       originalNS.Path(),
+				IfName:      IFNAME,
+				Args:        "K8S_POD_NAMESPACE=default;K8S_POD_NAME=web-0",
+				StdinData:   conf,
+			}
+
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				r, _, err := testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				link, err := netlinksafe.LinkByName(IFNAME)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.Attrs().Alias).To(Equal("default/web-0"))
+				Expect(link.Attrs().AltNames).To(ContainElement("web-0-dummy0"))
+
+				if testutils.SpecVersionHasCHECK(ver) {
+					n := &TuningConf{}
+					Expect(json.Unmarshal(conf, &n)).NotTo(HaveOccurred())
+
+					confString, err := buildOneConfig(ver, n, r)
+					Expect(err).NotTo(HaveOccurred())
+
+					args.StdinData = confString
+
+					Expect(testutils.CmdCheckWithArgs(args, func() error {
+						return cmdCheck(args)
+					})).NotTo(HaveOccurred())
+				}
+
+				err = testutils.CmdDel(originalNS.Path(),
+					args.ContainerID, "", func() error { return cmdDel(args) })
+				Expect(err).NotTo(HaveOccurred())
+
+				link, err = netlinksafe.LinkByName(IFNAME)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.Attrs().Alias).To(BeEmpty())
+				Expect(link.Attrs().AltNames).NotTo(ContainElement("web-0-dummy0"))
+
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It(fmt.Sprintf("[%s] configures and deconfigures the qdisc with ADD/DEL", ver), func() {
+			conf := []byte(fmt.Sprintf(`{
+				"name": "test",
+				"type": "iplink",
+				"cniVersion": "%s",
+				"qdisc": {"type": "fq_codel", "limit": 1000},
+				"prevResult": {
+					"interfaces": [
+						{"name": "dummy0", "sandbox":"netns"}
+					],
+					"ips": [
+						{
+							"version": "4",
+							"address": "10.0.0.2/24",
+							"gateway": "10.0.0.1",
+							"interface": 0
+						}
+					]
+				}
+			}`, ver))
+
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       originalNS.Path(),
+				IfName:      IFNAME,
+				StdinData:   conf,
+			}
+
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				link, err := netlinksafe.LinkByName(IFNAME)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetUp(link)).To(Succeed())
+				before, err := tc.RootQdisc(link)
+				Expect(err).NotTo(HaveOccurred())
+
+				r, _, err := testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				qdisc, err := tc.RootQdisc(link)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(qdisc).To(BeAssignableToTypeOf(&netlink.FqCodel{}))
+				Expect(qdisc.(*netlink.FqCodel).Limit).To(Equal(uint32(1000)))
+
+				if testutils.SpecVersionHasCHECK(ver) {
+					n := &TuningConf{}
+					Expect(json.Unmarshal(conf, &n)).NotTo(HaveOccurred())
+
+					confString, err := buildOneConfig(ver, n, r)
+					Expect(err).NotTo(HaveOccurred())
+
+					args.StdinData = confString
+
+					Expect(testutils.CmdCheckWithArgs(args, func() error {
+						return cmdCheck(args)
+					})).NotTo(HaveOccurred())
+				}
+
+				err = testutils.CmdDel(originalNS.Path(),
+					args.ContainerID, "", func() error { return cmdDel(args) })
+				Expect(err).NotTo(HaveOccurred())
+
+				qdisc, err = tc.RootQdisc(link)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(qdisc.Type()).To(Equal(before.Type()))
+
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It(fmt.Sprintf("[%s] rejects qdisc parameters the qdisc does not support", ver), func() {
+			conf := []byte(fmt.Sprintf(`{
+				"name": "test",
+				"type": "tuning",
+				"cniVersion": "%s",
+				"qdisc": {"type": "noqueue", "limit": 1000}
+			}`, ver))
+
+			_, err := parseConf(conf, "")
+			Expect(err).To(MatchError("qdisc noqueue does not support parameters"))
+		})
+
+		It(fmt.Sprintf("[%s] configures and deconfigures ethtool features with ADD/DEL", ver), func() {
+			conf := []byte(fmt.Sprintf(`{
+				"name": "test",
+				"type": "iplink",
+				"cniVersion": "%s",
+				"ethtool": {
+					"features": {"tx-scatter-gather": false}
+				},
+				"prevResult": {
+					"interfaces": [
+						{"name": "dummy0", "sandbox":"netns"}
+					],
+					"ips": [
+						{
+							"version": "4",
+							"address": "10.0.0.2/24",
+							"gateway": "10.0.0.1",
+							"interface": 0
+						}
+					]
+				}
+			}`, ver))
+
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       originalNS.Path(),
+				IfName:      IFNAME,
+				StdinData:   conf,
+			}
+
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+
+				features := func() map[string]bool {
+					e, err := ethtool.NewEthtool()
+					Expect(err).NotTo(HaveOccurred())
+					defer e.Close()
+					features, err := e.Features(IFNAME)
+					Expect(err).NotTo(HaveOccurred())
+					return features
+				}
+				Expect(features()).To(HaveKeyWithValue("tx-scatter-gather", true))
+
+				r, _, err := testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(features()).To(HaveKeyWithValue("tx-scatter-gather", false))
+
+				if testutils.SpecVersionHasCHECK(ver) {
+					n := &TuningConf{}
+					Expect(json.Unmarshal(conf, &n)).NotTo(HaveOccurred())
+
+					confString, err := buildOneConfig(ver, n, r)
+					Expect(err).NotTo(HaveOccurred())
+
+					args.StdinData = confString
+
+					Expect(testutils.CmdCheckWithArgs(args, func() error {
+						return cmdCheck(args)
+					})).NotTo(HaveOccurred())
+				}
+
+				err = testutils.CmdDel(originalNS.Path(),
+					args.ContainerID, "", func() error { return cmdDel(args) })
+				Expect(err).NotTo(HaveOccurred())
+				Expect(features()).To(HaveKeyWithValue("tx-scatter-gather", true))
+
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
 		It(fmt.Sprintf("[%s] configures and deconfigures tx queue len from args with ADD/DEL", ver), func() {
 			conf := []byte(fmt.Sprintf(`{
 				"name": "test",
@@ -1355,3 +1590,251 @@ var _ = Describe("tuning plugin", func() {
 
 	}
 })
+
+var _ = Describe("sysctl key templating", func() {
+	It("substitutes the interface name and netns placeholders", func() {
+		fileName, err := getSysctlFilename("net.ipv4.conf.{ifname}.arp_notify", "eth0.100", "/var/run/netns/pod1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fileName).To(Equal("/proc/sys/net/ipv4/conf/eth0.100/arp_notify"))
+
+		fileName, err = getSysctlFilename("net.ipv4.conf.IFNAME.arp_notify", "net1", "/var/run/netns/pod1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fileName).To(Equal("/proc/sys/net/ipv4/conf/net1/arp_notify"))
+
+		fileName, err = getSysctlFilename("net/ipv4/conf/{netns}-{ifname}/forwarding", "eth0", "/var/run/netns/pod1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fileName).To(Equal("/proc/sys/net/ipv4/conf/pod1-eth0/forwarding"))
+	})
+
+	It("refuses keys outside of the network subsystem", func() {
+		_, err := getSysctlFilename("{ifname}.panic", "kernel", "/var/run/netns/pod1")
+		Expect(err).To(MatchError(ContainSubstring("invalid net sysctl key")))
+	})
+})
+
+var _ = Describe("link name templating", func() {
+	args := &skel.CmdArgs{
+		ContainerID: "c1",
+		IfName:      "net1",
+		Args:        "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=web-0",
+	}
+
+	It("substitutes the attachment and the CNI_ARGS keys", func() {
+		conf := &TuningConf{
+			IfAlias:  "{K8S_POD_NAMESPACE}/{K8S_POD_NAME} {containerID}",
+			AltNames: []string{"{network}-{K8S_POD_NAME}-{ifName}"},
+		}
+		conf.Name = "mynet"
+		Expect(expandLinkNames(conf, args)).To(Succeed())
+		Expect(conf.IfAlias).To(Equal("default/web-0 c1"))
+		Expect(conf.AltNames).To(Equal([]string{"mynet-web-0-net1"}))
+	})
+
+	DescribeTable("rejects invalid names",
+		func(conf *TuningConf, expectedErr string) {
+			Expect(expandLinkNames(conf, args)).To(MatchError(expectedErr))
+		},
+		Entry("unknown key", &TuningConf{IfAlias: "{K8S_POD_UID}"},
+			`template "{K8S_POD_UID}": "K8S_POD_UID" is not a CNI_ARGS key`),
+		Entry("altname with a slash", &TuningConf{AltNames: []string{"{K8S_POD_NAMESPACE}/{K8S_POD_NAME}"}},
+			`invalid altName "default/web-0"`),
+		Entry("altname too long", &TuningConf{AltNames: []string{strings.Repeat("x", 128)}},
+			fmt.Sprintf("invalid altName %q, must be 1 to 127 bytes", strings.Repeat("x", 128))),
+	)
+})
+
+var _ = Describe("tuning plugin with a veth", func() {
+	var hostNS, containerNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		hostNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		containerNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = hostNS.Do(func(ns.NetNS) error {
+			linkAttrs := netlink.NewLinkAttrs()
+			linkAttrs.Name = "host0"
+			return netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs:     linkAttrs,
+				PeerName:      "eth0",
+				PeerNamespace: netlink.NsFd(int(containerNS.Fd())),
+			})
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(containerNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(containerNS)).To(Succeed())
+		Expect(hostNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(hostNS)).To(Succeed())
+	})
+
+	It("accepts txQueueLen as an alias of txQLen", func() {
+		conf, err := parseConf([]byte(`{"name": "test", "type": "tuning", "cniVersion": "1.0.0", "txQueueLen": 500}`), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*conf.TxQLen).To(Equal(500))
+
+		_, err = parseConf([]byte(`{"name": "test", "type": "tuning", "cniVersion": "1.0.0", "txQLen": 100, "txQueueLen": 500}`), "")
+		Expect(err).To(MatchError("txQLen and txQueueLen are set to different values"))
+	})
+
+	It("configures and restores the sizes of the interface and its host peer with ADD/DEL", func() {
+		conf := []byte(fmt.Sprintf(`{
+			"name": "test",
+			"type": "tuning",
+			"cniVersion": "1.0.0",
+			"dataDir": %q,
+			"txQueueLen": 5000,
+			"gsoMaxSize": 32768,
+			"groMaxSize": 32768,
+			"hostPeer": true,
+			"prevResult": {
+				"interfaces": [
+					{"name": "eth0", "sandbox": "netns"}
+				],
+				"ips": []
+			}
+		}`, GinkgoT().TempDir()))
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       containerNS.Path(),
+			IfName:      "eth0",
+			StdinData:   conf,
+		}
+
+		sizes := func(netns ns.NetNS, ifName string) []int {
+			var sizes []int
+			err := netns.Do(func(ns.NetNS) error {
+				link, err := netlinksafe.LinkByName(ifName)
+				if err != nil {
+					return err
+				}
+				sizes = []int{link.Attrs().TxQLen, int(link.Attrs().GSOMaxSize), int(link.Attrs().GROMaxSize)}
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return sizes
+		}
+		hostBefore := sizes(hostNS, "host0")
+		containerBefore := sizes(containerNS, "eth0")
+
+		err := hostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			_, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sizes(hostNS, "host0")).To(Equal([]int{5000, 32768, 32768}))
+			Expect(sizes(containerNS, "eth0")).To(Equal([]int{5000, 32768, 32768}))
+
+			Expect(testutils.CmdCheckWithArgs(args, func() error {
+				return cmdCheck(args)
+			})).To(Succeed())
+
+			Expect(testutils.CmdDel(containerNS.Path(),
+				args.ContainerID, "", func() error { return cmdDel(args) })).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(sizes(hostNS, "host0")).To(Equal(hostBefore))
+		Expect(sizes(containerNS, "eth0")).To(Equal(containerBefore))
+	})
+
+	It("restores sysctls on DEL", func() {
+		conf := []byte(fmt.Sprintf(`{
+			"name": "test",
+			"type": "tuning",
+			"cniVersion": "1.0.0",
+			"dataDir": %q,
+			"sysctl": {
+				"net.ipv4.conf.{ifname}.arp_notify": "1"
+			},
+			"prevResult": {
+				"interfaces": [
+					{"name": "eth0", "sandbox": "netns"}
+				],
+				"ips": []
+			}
+		}`, GinkgoT().TempDir()))
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       containerNS.Path(),
+			IfName:      "eth0",
+			StdinData:   conf,
+		}
+
+		arpNotify := func() string {
+			var value []byte
+			err := containerNS.Do(func(ns.NetNS) error {
+				var err error
+				value, err = os.ReadFile("/proc/sys/net/ipv4/conf/eth0/arp_notify")
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return strings.TrimSpace(string(value))
+		}
+		Expect(arpNotify()).To(Equal("0"))
+
+		err := hostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			_, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(arpNotify()).To(Equal("1"))
+
+			sysctlDuplicatesMap = map[sysctlKey]interface{}{}
+			Expect(testutils.CmdCheckWithArgs(args, func() error {
+				return cmdCheck(args)
+			})).To(Succeed())
+
+			Expect(testutils.CmdDel(containerNS.Path(),
+				args.ContainerID, "", func() error { return cmdDel(args) })).To(Succeed())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(arpNotify()).To(Equal("0"))
+	})
+})
+
+var _ = Describe("tuning GC", func() {
+	It("removes the backups of stale attachments on the network", func() {
+		dataDir := GinkgoT().TempDir()
+		for containerID, network := range map[string]string{
+			"c1": "test",
+			"c2": "test",
+			"c3": "other",
+		} {
+			store := backupStore(&TuningConf{NetConf: types.NetConf{Name: network}, DataDir: dataDir})
+			key := statestore.Key{ContainerID: containerID, IfName: "eth0"}
+			Expect(store.Save(key, &configToRestore{Mtu: 1500})).To(Succeed())
+		}
+		// Backups of previous versions do not name their attachment
+		data, err := json.Marshal(configToRestore{Mtu: 1500})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(dataDir, "c4_eth0.json"), data, 0o600)).To(Succeed())
+
+		conf := []byte(fmt.Sprintf(`{
+			"name": "test",
+			"type": "tuning",
+			"cniVersion": "1.1.0",
+			"dataDir": %q,
+			"cni.dev/valid-attachments": [{"containerID": "c1", "ifname": "eth0"}]
+		}`, dataDir))
+		Expect(cmdGC(&skel.CmdArgs{StdinData: conf})).To(Succeed())
+
+		Expect(filepath.Join(dataDir, "c1_eth0.json")).To(BeAnExistingFile())
+		Expect(filepath.Join(dataDir, "c2_eth0.json")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(dataDir, "c3_eth0.json")).To(BeAnExistingFile())
+		Expect(filepath.Join(dataDir, "c4_eth0.json")).To(BeAnExistingFile())
+	})
+})
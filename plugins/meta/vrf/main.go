@@ -17,6 +17,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 
 	"github.com/vishvananda/netlink"
 
@@ -24,6 +25,7 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/ns"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 )
@@ -36,6 +38,27 @@ type VRFNetConf struct {
 	VRFName string `json:"vrfname"`
 	// Table is the optional name of the routing table set for the vrf
 	Table uint32 `json:"table"`
+	// CreateIfMissing, true by default, creates the vrf if it does not
+	// exist. When false, the vrf is expected to be managed by someone else
+	// and is never deleted by the plugin.
+	CreateIfMissing *bool `json:"createIfMissing,omitempty"`
+	// Leaks are the routes to leak between this vrf and others in the
+	// same namespace.
+	Leaks []RouteLeak `json:"leaks,omitempty"`
+}
+
+// createIfMissing tells whether the plugin owns the vrf.
+func (conf *VRFNetConf) createIfMissing() bool {
+	return conf.CreateIfMissing == nil || *conf.CreateIfMissing
+}
+
+// RouteLeak makes Dst, as routed in vrf From, reachable from vrf To.
+type RouteLeak struct {
+	Dst  string `json:"dst"`
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	dst *net.IPNet
 }
 
 func main() {
@@ -58,7 +81,13 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return fmt.Errorf("missing prevResult from earlier plugin")
 	}
 
-	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
 		vrf, err := findVRF(conf.VRFName)
 
 		// If the user set a tableid and the vrf is already in the namespace
@@ -68,6 +97,9 @@ func cmdAdd(args *skel.CmdArgs) error {
 		}
 
 		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			if !conf.createIfMissing() {
+				return cnierrors.Newf(cnierrors.ErrInterfaceNotFound, "VRF %s does not exist and createIfMissing is false", conf.VRFName)
+			}
 			vrf, err = createVRF(conf.VRFName, conf.Table)
 		}
 
@@ -79,10 +111,11 @@ func cmdAdd(args *skel.CmdArgs) error {
 		if err != nil {
 			return err
 		}
-		return nil
+
+		return addLeaks(conf.Leaks)
 	})
 	if err != nil {
-		return fmt.Errorf("cmdAdd failed: %v", err)
+		return cnierrors.Wrapf(types.ErrInternal, err, "cmdAdd failed")
 	}
 
 	if result == nil {
@@ -117,8 +150,9 @@ func cmdDel(args *skel.CmdArgs) error {
 			return err
 		}
 
-		// Meaning, we are deleting the last interface assigned to the VRF
-		if len(interfaces) == 0 {
+		// Meaning, we are deleting the last interface assigned to the VRF,
+		// unless the VRF is not ours to delete
+		if len(interfaces) == 0 && conf.createIfMissing() {
 			err = netlink.LinkDel(vrf)
 			if err != nil {
 				return err
@@ -154,11 +188,21 @@ func cmdCheck(args *skel.CmdArgs) error {
 		return fmt.Errorf("missing prevResult from earlier plugin")
 	}
 
-	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return cnierrors.Wrapf(types.ErrInvalidNetNS, err, "failed to open netns %q", args.Netns)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
 		vrf, err := findVRF(conf.VRFName)
 		if err != nil {
 			return err
 		}
+		if conf.Table != 0 && vrf.Table != conf.Table {
+			return fmt.Errorf("VRF %s has routing table %d, expected %d", conf.VRFName, vrf.Table, conf.Table)
+		}
+
 		vrfInterfaces, err := assignedInterfaces(vrf)
 		if err != nil {
 			return err
@@ -174,7 +218,8 @@ func cmdCheck(args *skel.CmdArgs) error {
 		if !found {
 			return fmt.Errorf("failed to find %s associated to vrf %s", args.IfName, conf.VRFName)
 		}
-		return nil
+
+		return checkLeaks(conf.Leaks)
 	})
 	if err != nil {
 		return err
@@ -193,6 +238,21 @@ func parseConf(data []byte) (*VRFNetConf, *current.Result, error) {
 		return nil, nil, fmt.Errorf("configuration is expected to have a valid vrf name")
 	}
 
+	for i := range conf.Leaks {
+		leak := &conf.Leaks[i]
+		_, dst, err := net.ParseCIDR(leak.Dst)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid leak destination %q: %v", leak.Dst, err)
+		}
+		leak.dst = dst
+		if leak.From == leak.To {
+			return nil, nil, fmt.Errorf("leak of %s must be between two different vrfs", leak.Dst)
+		}
+		if leak.From != conf.VRFName && leak.To != conf.VRFName {
+			return nil, nil, fmt.Errorf("leak of %s from %s to %s does not involve vrf %s", leak.Dst, leak.From, leak.To, conf.VRFName)
+		}
+	}
+
 	if conf.RawPrevResult == nil {
 		// return early if there was no previous result, which is allowed for DEL calls
 		return &conf, &current.Result{}, nil
@@ -22,6 +22,7 @@ import (
 
 	"github.com/vishvananda/netlink"
 
+	cnierrors "github.com/containernetworking/plugins/pkg/errors"
 	"github.com/containernetworking/plugins/pkg/netlinksafe"
 )
 
@@ -90,7 +91,7 @@ func assignedInterfaces(vrf *netlink.Vrf) ([]netlink.Link, error) {
 func addInterface(vrf *netlink.Vrf, intf string) error {
 	i, err := netlinksafe.LinkByName(intf)
 	if err != nil {
-		return fmt.Errorf("could not get link by name %s", intf)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "could not get link by name %s", intf)
 	}
 
 	if i.Attrs().MasterIndex != 0 {
@@ -204,6 +205,76 @@ CONTINUE:
 	return nil
 }
 
+// leakRoute returns the route in the table of vrf to that sends traffic for
+// the leaked destination to vrf from.
+func leakRoute(leak RouteLeak, from, to *netlink.Vrf) *netlink.Route {
+	return &netlink.Route{
+		Dst:       leak.dst,
+		LinkIndex: from.Index,
+		Table:     int(to.Table),
+	}
+}
+
+// leakVRFs finds both vrfs of a leak. ok is false if either does not
+// exist yet: leaks are set up by the attachment that creates the second vrf.
+func leakVRFs(leak RouteLeak) (from, to *netlink.Vrf, ok bool, err error) {
+	from, err = findVRF(leak.From)
+	if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+		return nil, nil, false, nil
+	} else if err != nil {
+		return nil, nil, false, err
+	}
+	to, err = findVRF(leak.To)
+	if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+		return nil, nil, false, nil
+	} else if err != nil {
+		return nil, nil, false, err
+	}
+	return from, to, true, nil
+}
+
+// addLeaks adds the routes of the leaks whose vrfs both exist. The routes go
+// away with either vrf.
+func addLeaks(leaks []RouteLeak) error {
+	for _, leak := range leaks {
+		from, to, ok, err := leakVRFs(leak)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		// equivalent of 'ip route replace <dst> dev <from> table <to table>'.
+		if err := netlink.RouteReplace(leakRoute(leak, from, to)); err != nil {
+			return fmt.Errorf("could not leak %s from vrf %s to vrf %s: %v", leak.Dst, leak.From, leak.To, err)
+		}
+	}
+	return nil
+}
+
+// checkLeaks verifies the routes of the leaks whose vrfs both exist.
+func checkLeaks(leaks []RouteLeak) error {
+	for _, leak := range leaks {
+		from, to, ok, err := leakVRFs(leak)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		route := leakRoute(leak, from, to)
+		routes, err := netlinksafe.RouteListFiltered(netlink.FAMILY_ALL, route,
+			netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE|netlink.RT_FILTER_DST)
+		if err != nil {
+			return fmt.Errorf("failed getting routes of vrf %s: %v", leak.To, err)
+		}
+		if len(routes) == 0 {
+			return fmt.Errorf("leak of %s from vrf %s to vrf %s not found", leak.Dst, leak.From, leak.To)
+		}
+	}
+	return nil
+}
+
 func findFreeRoutingTableID(links []netlink.Link) (uint32, error) {
 	takenTables := make(map[uint32]struct{}, len(links))
 	for _, l := range links {
@@ -223,7 +294,7 @@ func findFreeRoutingTableID(links []netlink.Link) (uint32, error) {
 func resetMaster(interfaceName string) error {
 	intf, err := netlinksafe.LinkByName(interfaceName)
 	if err != nil {
-		return fmt.Errorf("resetMaster: could not get link by name %s", interfaceName)
+		return cnierrors.Wrapf(cnierrors.ErrInterfaceNotFound, err, "resetMaster: could not get link by name %s", interfaceName)
 	}
 	err = netlink.LinkSetNoMaster(intf)
 	if err != nil {
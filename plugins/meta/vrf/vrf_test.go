@@ -821,6 +821,122 @@ var _ = Describe("vrf plugin", func() {
 		})
 	})
 
+	It("attaches to a pre-existing VRF without creating or deleting it", func() {
+		conf := configForExistingVRF("test", IF0Name, VRF0Name, "10.0.0.2/24", 1001)
+
+		err := targetNS.Do(func(ns.NetNS) error {
+			_, err := createVRF(VRF0Name, 1001)
+			return err
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNS.Path(),
+				IfName:      IF0Name,
+				StdinData:   conf,
+			}
+			_, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(testutils.CmdCheckWithArgs(args, func() error {
+				return cmdCheck(args)
+			})).To(Succeed())
+
+			err = testutils.CmdDelWithArgs(args, func() error {
+				return cmdDel(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = targetNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			vrf, err := findVRF(VRF0Name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vrf.Table).To(Equal(uint32(1001)))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("fails if the VRF is missing and createIfMissing is false", func() {
+		conf := configForExistingVRF("test", IF0Name, VRF0Name, "10.0.0.2/24", 1001)
+
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNS.Path(),
+				IfName:      IF0Name,
+				StdinData:   conf,
+			}
+			_, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).To(MatchError(ContainSubstring("does not exist and createIfMissing is false")))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("leaks routes between VRFs once both exist", func() {
+		leaks := fmt.Sprintf(`[{"dst": "10.96.0.0/16", "from": "%s", "to": "%s"}]`, VRF0Name, VRF1Name)
+		conf0 := configWithLeaksFor("test", IF0Name, VRF0Name, "10.0.0.2/24", leaks)
+		conf1 := configWithLeaksFor("test1", IF1Name, VRF1Name, "10.0.1.2/24", leaks)
+
+		for _, add := range []struct {
+			ifName string
+			conf   []byte
+		}{{IF0Name, conf0}, {IF1Name, conf1}} {
+			err := originalNS.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNS.Path(),
+					IfName:      add.ifName,
+					StdinData:   add.conf,
+				}
+				_, _, err := testutils.CmdAddWithArgs(args, func() error {
+					return cmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(testutils.CmdCheckWithArgs(args, func() error {
+					return cmdCheck(args)
+				})).To(Succeed())
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		err := targetNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			from, err := findVRF(VRF0Name)
+			Expect(err).NotTo(HaveOccurred())
+			to, err := findVRF(VRF1Name)
+			Expect(err).NotTo(HaveOccurred())
+
+			routes, err := netlinksafe.RouteListFiltered(netlink.FAMILY_V4,
+				&netlink.Route{Table: int(to.Table)},
+				netlink.RT_FILTER_TABLE)
+			Expect(err).NotTo(HaveOccurred())
+			found := false
+			for _, route := range routes {
+				if route.Dst != nil && route.Dst.String() == "10.96.0.0/16" {
+					Expect(route.LinkIndex).To(Equal(from.Index))
+					found = true
+				}
+			}
+			Expect(found).To(BeTrue())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
 	It("configures and deconfigures VRF with CNI 0.4.0 ADD/DEL", func() {
 		conf := []byte(fmt.Sprintf(`{
 	"name": "test",
@@ -932,6 +1048,22 @@ var _ = Describe("unit tests", func() {
 			return res
 		}(), uint32(1000), false),
 	)
+
+	DescribeTable("When parsing route leaks",
+		func(leaks string, expectedErr string) {
+			_, _, err := parseConf(configWithLeaksFor("test", "eth0", "blue", "10.0.0.2/24", leaks))
+			if expectedErr == "" {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+		},
+		Entry("accepts leaks from this vrf", `[{"dst": "10.0.0.0/8", "from": "blue", "to": "red"}]`, ""),
+		Entry("accepts leaks to this vrf", `[{"dst": "fd00::/64", "from": "red", "to": "blue"}]`, ""),
+		Entry("rejects invalid destinations", `[{"dst": "10.0.0.0", "from": "blue", "to": "red"}]`, "invalid leak destination"),
+		Entry("rejects leaks within a vrf", `[{"dst": "10.0.0.0/8", "from": "blue", "to": "blue"}]`, "two different vrfs"),
+		Entry("rejects leaks not involving this vrf", `[{"dst": "10.0.0.0/8", "from": "red", "to": "green"}]`, "does not involve vrf blue"),
+	)
 })
 
 func configFor(name, intf, vrf, ip string) []byte {
@@ -981,6 +1113,55 @@ func configWithTableFor(name, intf, vrf, ip string, tableID int) []byte {
 	return []byte(conf)
 }
 
+func configForExistingVRF(name, intf, vrf, ip string, tableID int) []byte {
+	conf := fmt.Sprintf(`{
+		"name": "%s",
+		"type": "vrf",
+		"cniVersion": "0.4.0",
+		"vrfName": "%s",
+		"table": %d,
+		"createIfMissing": false,
+		"prevResult": {
+			"interfaces": [
+				{"name": "%s", "sandbox":"netns"}
+			],
+			"ips": [
+				{
+					"version": "4",
+					"address": "%s",
+					"gateway": "10.0.0.1",
+					"interface": 0
+				}
+			]
+		}
+	}`, name, vrf, tableID, intf, ip)
+	return []byte(conf)
+}
+
+func configWithLeaksFor(name, intf, vrf, ip, leaks string) []byte {
+	conf := fmt.Sprintf(`{
+		"name": "%s",
+		"type": "vrf",
+		"cniVersion": "0.3.1",
+		"vrfName": "%s",
+		"leaks": %s,
+		"prevResult": {
+			"interfaces": [
+				{"name": "%s", "sandbox":"netns"}
+			],
+			"ips": [
+				{
+					"version": "4",
+					"address": "%s",
+					"gateway": "10.0.0.1",
+					"interface": 0
+				}
+			]
+		}
+	}`, name, vrf, leaks, intf, ip)
+	return []byte(conf)
+}
+
 func configWithRouteFor(name, intf, vrf, ip, route string) []byte {
 	conf := fmt.Sprintf(`{
 		"name": "%s",